@@ -0,0 +1,148 @@
+use anyhow::{anyhow, Context, Result};
+use reqwest::StatusCode;
+use serde_json::{json, Map, Value};
+use std::fs;
+use std::path::{Path, PathBuf};
+
+use crate::Reply;
+
+/// A directory of recorded node responses, one JSON file per distinct
+/// request.
+///
+/// Requests are keyed on method, the path below the endpoint (with query)
+/// and a hash of the request body, so a recording made against one node
+/// replays against any `--rpc-url`. Endpoints, and any credentials embedded
+/// in them, are never written, and neither are request headers.
+#[derive(Debug)]
+pub struct Fixtures {
+    dir: PathBuf,
+    replay: bool,
+}
+
+impl Fixtures {
+    /// Writes every response the client receives into `dir`, creating it if
+    /// missing. Re-recording a request overwrites its file.
+    pub fn record(dir: &Path) -> Result<Self> {
+        fs::create_dir_all(dir)
+            .with_context(|| format!("failed to create fixture directory {}", dir.display()))?;
+        Ok(Self {
+            dir: dir.to_owned(),
+            replay: false,
+        })
+    }
+
+    /// Serves every request from `dir` without touching the network.
+    pub fn replay(dir: &Path) -> Result<Self> {
+        if !dir.is_dir() {
+            return Err(anyhow!(
+                "fixture directory {} does not exist",
+                dir.display()
+            ));
+        }
+        Ok(Self {
+            dir: dir.to_owned(),
+            replay: true,
+        })
+    }
+
+    pub(crate) fn is_replay(&self) -> bool {
+        self.replay
+    }
+
+    /// The recorded reply for a request; a request that was never recorded
+    /// is an error rather than a fall through to the network.
+    pub(crate) fn load(&self, method: &str, path: &str, body: Option<&Value>) -> Result<Reply> {
+        let file = self.file(method, path, body);
+        let text = fs::read_to_string(&file).map_err(|_| {
+            anyhow!(
+                "no recorded fixture for {method} {path} in {} (expected {})",
+                self.dir.display(),
+                file.display()
+            )
+        })?;
+        let fixture: Value = serde_json::from_str(&text)
+            .with_context(|| format!("failed to parse fixture {}", file.display()))?;
+        let status = fixture
+            .get("status")
+            .and_then(Value::as_u64)
+            .and_then(|status| StatusCode::from_u16(status as u16).ok())
+            .ok_or_else(|| anyhow!("fixture {} has no valid `status`", file.display()))?;
+        let body = match (fixture.get("body"), fixture.get("body_text")) {
+            (Some(body), _) => body.to_string(),
+            (None, Some(Value::String(text))) => text.clone(),
+            _ => String::new(),
+        };
+        Ok(Reply {
+            status,
+            cursor: fixture
+                .get("cursor")
+                .and_then(Value::as_str)
+                .map(str::to_owned),
+            body,
+        })
+    }
+
+    /// Writes one fixture; failures are reported on stderr rather than
+    /// failing the request.
+    pub(crate) fn save(&self, method: &str, path: &str, body: Option<&Value>, reply: &Reply) {
+        let mut fixture = Map::new();
+        fixture.insert("method".to_owned(), json!(method));
+        fixture.insert("path".to_owned(), json!(path));
+        fixture.insert("request_body_hash".to_owned(), json!(body.map(body_hash)));
+        fixture.insert("status".to_owned(), json!(reply.status.as_u16()));
+        fixture.insert("cursor".to_owned(), json!(reply.cursor));
+        match serde_json::from_str::<Value>(&reply.body) {
+            Ok(body) => fixture.insert("body".to_owned(), body),
+            Err(_) => fixture.insert("body_text".to_owned(), json!(reply.body)),
+        };
+        let file = self.file(method, path, body);
+        let mut text =
+            serde_json::to_string_pretty(&Value::Object(fixture)).expect("fixtures serialize");
+        text.push('\n');
+        if let Err(err) = fs::write(&file, text) {
+            eprintln!("failed to write fixture {}: {err}", file.display());
+        }
+    }
+
+    fn file(&self, method: &str, path: &str, body: Option<&Value>) -> PathBuf {
+        let path = path.trim_start_matches('/');
+        let key = match body {
+            Some(body) => format!("{method} /{path} {}", body_hash(body)),
+            None => format!("{method} /{path}"),
+        };
+        self.dir
+            .join(format!("{:016x}.json", fnv1a(key.as_bytes())))
+    }
+}
+
+/// Stable across runs and platforms, unlike `DefaultHasher`.
+fn body_hash(body: &Value) -> String {
+    format!("{:016x}", fnv1a(body.to_string().as_bytes()))
+}
+
+fn fnv1a(bytes: &[u8]) -> u64 {
+    bytes.iter().fold(0xcbf2_9ce4_8422_2325, |hash, byte| {
+        (hash ^ u64::from(*byte)).wrapping_mul(0x0100_0000_01b3)
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn keys_on_method_path_and_body() {
+        let dir = std::env::temp_dir().join(format!("aptly-fixtures-{}", std::process::id()));
+        let fixtures = Fixtures::record(&dir).unwrap();
+        let view = json!({"function": "0x1::m::f"});
+        let get = fixtures.file("GET", "/accounts/0x1", None);
+        assert_eq!(get, fixtures.file("GET", "accounts/0x1", None));
+        assert_ne!(get, fixtures.file("POST", "/accounts/0x1", None));
+        assert_ne!(
+            fixtures.file("POST", "/view", Some(&view)),
+            fixtures.file("POST", "/view", Some(&json!({"function": "0x1::m::g"})))
+        );
+        assert_eq!(fnv1a(b"a"), 0xaf63_dc4c_8601_ec8c);
+        std::fs::remove_dir_all(&dir).unwrap();
+    }
+}
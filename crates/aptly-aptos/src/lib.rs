@@ -1,59 +1,541 @@
 use anyhow::{anyhow, Context, Result};
-use reqwest::blocking::{Client, Response};
+use reqwest::blocking::{Client, RequestBuilder, Response};
+use reqwest::header::RETRY_AFTER;
 use reqwest::StatusCode;
 use serde_json::Value;
+use std::sync::atomic::{AtomicUsize, Ordering};
+use std::sync::Arc;
+use std::time::{Duration, Instant};
+
+mod audit;
+mod fixtures;
+mod rate_limit;
+mod time;
+
+use audit::AuditEntry;
+pub use audit::AuditLog;
+pub use fixtures::Fixtures;
+pub use rate_limit::RateLimitStats;
+use rate_limit::RateLimiter;
+pub use time::UtcDateTime;
+
+/// Retries of a single request after 429 responses before giving up.
+const MAX_RATE_LIMIT_RETRIES: u32 = 3;
+const RATE_LIMIT_BASE_BACKOFF: Duration = Duration::from_millis(500);
+/// Pagination cursor for the next page of list endpoints.
+const CURSOR_HEADER: &str = "x-aptos-cursor";
+const LEDGER_VERSION_HEADER: &str = "x-aptos-ledger-version";
+
+/// Per-invocation client behavior configured from global CLI flags. Clients
+/// for other services (indexer, faucet) reuse the node client's options, so
+/// their requests are rate limited, audited and recorded like node requests.
+#[derive(Debug, Clone, Default)]
+pub struct ClientOptions {
+    /// Use only the first endpoint and never fail over.
+    pub strict_endpoint: bool,
+    /// Log failovers and other client diagnostics to stderr.
+    pub verbose: bool,
+    /// Client-side request rate limit; `0` disables limiting.
+    pub max_rps: f64,
+    /// Append one JSON line per HTTP request to this log.
+    pub audit: Option<Arc<AuditLog>>,
+    /// Record responses to, or replay them from, a fixture directory.
+    pub fixtures: Option<Arc<Fixtures>>,
+}
+
+/// A response read in full, so it can be audited before it is handled.
+struct Reply {
+    status: StatusCode,
+    cursor: Option<String>,
+    body: String,
+}
 
 pub struct AptosClient {
-    base_url: String,
+    endpoints: Vec<String>,
+    active: AtomicUsize,
+    options: ClientOptions,
+    pinned_ledger_version: Option<u64>,
+    limiter: RateLimiter,
     http: Client,
 }
 
 impl AptosClient {
     pub fn new(base_url: &str) -> Result<Self> {
-        let base_url = base_url.trim().trim_end_matches('/').to_owned();
-        if base_url.is_empty() {
+        Self::with_options(base_url, ClientOptions::default())
+    }
+
+    /// Builds a client from a comma-separated list of endpoints. Requests go to
+    /// the first healthy endpoint and fail over to the next one on connection
+    /// errors, timeouts, and 5xx responses.
+    pub fn with_options(base_url: &str, options: ClientOptions) -> Result<Self> {
+        let endpoints = parse_endpoints(base_url);
+        if endpoints.is_empty() {
             return Err(anyhow!("rpc url cannot be empty"));
         }
 
         let http = Client::builder()
             .build()
             .context("failed to build HTTP client")?;
-        Ok(Self { base_url, http })
+        Ok(Self {
+            endpoints,
+            active: AtomicUsize::new(0),
+            limiter: RateLimiter::new(options.max_rps),
+            options,
+            pinned_ledger_version: None,
+            http,
+        })
+    }
+
+    /// Endpoint currently serving requests.
+    pub fn base_url(&self) -> &str {
+        &self.endpoints[self.active.load(Ordering::Relaxed)]
+    }
+
+    /// Reads the current ledger version and pins every later state query to
+    /// it, so multi-request commands observe a single consistent snapshot.
+    pub fn pin_ledger_version(&mut self) -> Result<u64> {
+        let ledger = self.get_json("/")?;
+        let version = ledger
+            .get("ledger_version")
+            .and_then(Value::as_str)
+            .and_then(|version| version.parse::<u64>().ok())
+            .ok_or_else(|| anyhow!("failed to parse `ledger_version` from ledger response"))?;
+        self.pinned_ledger_version = Some(version);
+        Ok(version)
+    }
+
+    pub fn pinned_ledger_version(&self) -> Option<u64> {
+        self.pinned_ledger_version
+    }
+
+    /// Options this client was built with, for clients of other services
+    /// (indexer, faucet, other nodes) used by the same invocation.
+    pub fn options(&self) -> &ClientOptions {
+        &self.options
+    }
+
+    /// Whether `--verbose` progress output is wanted.
+    pub fn verbose(&self) -> bool {
+        self.options.verbose
+    }
+
+    pub fn rate_limit_stats(&self) -> RateLimitStats {
+        self.limiter.stats()
+    }
+
+    /// Prints rate limiter counters to stderr when `verbose` is set.
+    pub fn log_stats(&self) {
+        if !self.options.verbose {
+            return;
+        }
+        let stats = self.limiter.stats();
+        eprintln!(
+            "rate limiter: {} request(s), {} delayed, {:.2}s waited, {} rate-limited (429), effective {:.2} rps",
+            stats.requests,
+            stats.delayed,
+            stats.waited.as_secs_f64(),
+            stats.rate_limited,
+            stats.effective_rps
+        );
     }
 
     pub fn get_json(&self, path: &str) -> Result<Value> {
-        let url = self.endpoint(path);
-        let response = self
-            .http
-            .get(&url)
-            .send()
-            .with_context(|| format!("request failed: GET {url}"))?;
-        self.handle_response(response)
+        let path = self.pinned_path(path)?;
+        self.send("GET", &path, None, |http, url| http.get(url), read_json)
+    }
+
+    /// Like [`AptosClient::get_json`] for paginated endpoints; also returns the
+    /// `X-Aptos-Cursor` header, which is absent on the last page.
+    pub fn get_json_page(&self, path: &str) -> Result<(Value, Option<String>)> {
+        let path = self.pinned_path(path)?;
+        self.send(
+            "GET",
+            &path,
+            None,
+            |http, url| http.get(url),
+            |mut reply| {
+                let cursor = reply.cursor.take();
+                Ok((read_json(reply)?, cursor))
+            },
+        )
     }
 
     pub fn post_json(&self, path: &str, body: &Value) -> Result<Value> {
-        let url = self.endpoint(path);
-        let response = self
-            .http
-            .post(&url)
-            .json(body)
-            .send()
-            .with_context(|| format!("request failed: POST {url}"))?;
-        self.handle_response(response)
+        let path = self.pinned_path(path)?;
+        self.send(
+            "POST",
+            &path,
+            Some(body),
+            |http, url| http.post(url).json(body),
+            read_json,
+        )
+    }
+
+    fn pinned_path(&self, path: &str) -> Result<String> {
+        let Some(version) = self.pinned_ledger_version else {
+            return Ok(path.to_owned());
+        };
+        if path.contains("ledger_version=") {
+            return Ok(path.to_owned());
+        }
+        if !supports_ledger_version(path) {
+            return Err(anyhow!(
+                "endpoint {path} does not accept ledger_version; it cannot be used with --pin-ledger"
+            ));
+        }
+        let separator = if path.contains('?') { '&' } else { '?' };
+        Ok(format!("{path}{separator}ledger_version={version}"))
+    }
+
+    fn send<T>(
+        &self,
+        method: &str,
+        path: &str,
+        request_body: Option<&Value>,
+        build: impl Fn(&Client, &str) -> RequestBuilder,
+        handle: impl FnOnce(Reply) -> Result<T>,
+    ) -> Result<T> {
+        if let Some(fixtures) = self.options.fixtures.as_ref().filter(|f| f.is_replay()) {
+            return handle(fixtures.load(method, path, request_body)?);
+        }
+
+        let start = self.active.load(Ordering::Relaxed);
+        let attempts = if self.options.strict_endpoint {
+            1
+        } else {
+            self.endpoints.len() - start
+        };
+
+        for attempt in 0..attempts {
+            let index = start + attempt;
+            let url = self.endpoint(index, path);
+            let has_fallback = attempt + 1 < attempts;
+
+            let reply = match self.execute(method, &url, request_body, &build) {
+                Ok(reply) => reply,
+                Err(err) if has_fallback && (err.is_connect() || err.is_timeout()) => {
+                    self.log_failover(index, &err.to_string());
+                    continue;
+                }
+                Err(err) => {
+                    return Err(err).with_context(|| format!("request failed: {method} {url}"))
+                }
+            };
+
+            if has_fallback && reply.status.is_server_error() {
+                self.log_failover(index, &format!("status {}", reply.status.as_u16()));
+                continue;
+            }
+
+            self.active.store(index, Ordering::Relaxed);
+            if let Some(fixtures) = &self.options.fixtures {
+                fixtures.save(method, path, request_body, &reply);
+            }
+            return handle(reply);
+        }
+
+        unreachable!("at least one endpoint is always attempted")
+    }
+
+    /// Sends one request through the rate limiter, backing off and retrying
+    /// when the node answers 429. Every attempt is audited.
+    fn execute(
+        &self,
+        method: &str,
+        url: &str,
+        request_body: Option<&Value>,
+        build: &impl Fn(&Client, &str) -> RequestBuilder,
+    ) -> reqwest::Result<Reply> {
+        let mut retries = 0;
+        loop {
+            self.limiter.acquire();
+            let started = Instant::now();
+            let response = match build(&self.http, url).send() {
+                Ok(response) => response,
+                Err(err) => {
+                    self.audit(AuditEntry {
+                        method,
+                        url,
+                        status: None,
+                        latency: started.elapsed(),
+                        ledger_version: None,
+                        error: Some(err.to_string()),
+                        request_body,
+                        response_body: None,
+                    });
+                    return Err(err);
+                }
+            };
+            let status = response.status();
+            let ledger_version = header(&response, LEDGER_VERSION_HEADER);
+            if status == StatusCode::TOO_MANY_REQUESTS && retries < MAX_RATE_LIMIT_RETRIES {
+                self.audit(AuditEntry {
+                    method,
+                    url,
+                    status: Some(status.as_u16()),
+                    latency: started.elapsed(),
+                    ledger_version: ledger_version.as_deref(),
+                    error: None,
+                    request_body,
+                    response_body: None,
+                });
+                let backoff =
+                    retry_after(&response).unwrap_or(RATE_LIMIT_BASE_BACKOFF * 2u32.pow(retries));
+                if self.options.verbose {
+                    eprintln!(
+                        "rate limited by {url}; retrying in {:.2}s",
+                        backoff.as_secs_f64()
+                    );
+                }
+                self.limiter.on_rate_limited(backoff);
+                retries += 1;
+                continue;
+            }
+            if status.is_success() {
+                self.limiter.on_success();
+            }
+            let cursor = header(&response, CURSOR_HEADER);
+            let body = response.text();
+            self.audit(AuditEntry {
+                method,
+                url,
+                status: Some(status.as_u16()),
+                latency: started.elapsed(),
+                ledger_version: ledger_version.as_deref(),
+                error: body.as_ref().err().map(ToString::to_string),
+                request_body,
+                response_body: body.as_deref().ok(),
+            });
+            return Ok(Reply {
+                status,
+                cursor,
+                body: body?,
+            });
+        }
     }
 
-    fn endpoint(&self, path: &str) -> String {
-        format!("{}/{}", self.base_url, path.trim_start_matches('/'))
+    fn audit(&self, entry: AuditEntry<'_>) {
+        if let Some(audit) = &self.options.audit {
+            audit.record(entry);
+        }
     }
 
-    fn handle_response(&self, response: Response) -> Result<Value> {
-        let status = response.status();
-        let text = response.text().context("failed to read response body")?;
+    fn endpoint(&self, index: usize, path: &str) -> String {
+        format!("{}/{}", self.endpoints[index], path.trim_start_matches('/'))
+    }
 
-        if status != StatusCode::OK && status != StatusCode::ACCEPTED {
-            return Err(anyhow!("API error (status {}): {}", status.as_u16(), text));
+    fn log_failover(&self, index: usize, reason: &str) {
+        if self.options.verbose {
+            eprintln!(
+                "rpc endpoint {} failed ({reason}); failing over to {}",
+                self.endpoints[index],
+                self.endpoints[index + 1]
+            );
         }
+    }
+}
+
+fn read_json(reply: Reply) -> Result<Value> {
+    let status = reply.status;
+    if status != StatusCode::OK && status != StatusCode::ACCEPTED {
+        return Err(anyhow!(
+            "API error (status {}): {}",
+            status.as_u16(),
+            reply.body
+        ));
+    }
+
+    serde_json::from_str(&reply.body).context("failed to parse response JSON")
+}
+
+fn header(response: &Response, name: &str) -> Option<String> {
+    response
+        .headers()
+        .get(name)
+        .and_then(|value| value.to_str().ok())
+        .map(str::to_owned)
+}
+
+fn retry_after(response: &Response) -> Option<Duration> {
+    let value = response.headers().get(RETRY_AFTER)?.to_str().ok()?;
+    value.trim().parse::<u64>().ok().map(Duration::from_secs)
+}
+
+/// Splits a comma-separated `--rpc-url` value into normalized endpoints.
+pub fn parse_endpoints(base_url: &str) -> Vec<String> {
+    base_url
+        .split(',')
+        .map(|url| url.trim().trim_end_matches('/').to_owned())
+        .filter(|url| !url.is_empty())
+        .collect()
+}
+
+/// Reports whether a node API path accepts the `ledger_version` query
+/// parameter (account state, view functions, and table items).
+pub fn supports_ledger_version(path: &str) -> bool {
+    let path = path.split('?').next().unwrap_or_default();
+    let path = path.trim_start_matches('/');
+    if path == "view" || path.starts_with("tables/") {
+        return true;
+    }
+    let Some(rest) = path.strip_prefix("accounts/") else {
+        return false;
+    };
+    match rest.split('/').nth(1) {
+        None => true,
+        Some(kind) => matches!(
+            kind,
+            "resources" | "resource" | "modules" | "module" | "balance"
+        ),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::io::{Read, Write};
+    use std::net::TcpListener;
+    use std::thread;
+
+    /// Serves `count` requests with a fixed status and JSON body.
+    fn serve(status: &'static str, body: &'static str, count: usize) -> String {
+        let listener = TcpListener::bind("127.0.0.1:0").unwrap();
+        let addr = listener.local_addr().unwrap();
+        thread::spawn(move || {
+            for stream in listener.incoming().take(count) {
+                let mut stream = stream.unwrap();
+                let mut buf = [0u8; 4096];
+                let _ = stream.read(&mut buf);
+                let response = format!(
+                    "HTTP/1.1 {status}\r\nContent-Type: application/json\r\nContent-Length: {}\r\nConnection: close\r\n\r\n{body}",
+                    body.len()
+                );
+                stream.write_all(response.as_bytes()).unwrap();
+            }
+        });
+        format!("http://{addr}")
+    }
+
+    fn dead_endpoint() -> String {
+        let listener = TcpListener::bind("127.0.0.1:0").unwrap();
+        let addr = listener.local_addr().unwrap();
+        drop(listener);
+        format!("http://{addr}")
+    }
+
+    #[test]
+    fn parses_comma_separated_endpoints() {
+        assert_eq!(
+            parse_endpoints(" https://a/v1/ , ,https://b/v1"),
+            vec!["https://a/v1", "https://b/v1"]
+        );
+    }
+
+    #[test]
+    fn classifies_versioned_endpoints() {
+        assert!(supports_ledger_version("/accounts/0x1"));
+        assert!(supports_ledger_version("/accounts/0x1/resources?limit=10"));
+        assert!(supports_ledger_version("/accounts/0x1/balance/0xa"));
+        assert!(supports_ledger_version("/view"));
+        assert!(supports_ledger_version("/tables/0x2/item"));
+        assert!(!supports_ledger_version(
+            "/accounts/0x1/transactions?limit=25"
+        ));
+        assert!(!supports_ledger_version("/accounts/0x1/events/0"));
+        assert!(!supports_ledger_version("/transactions/by_version/1"));
+        assert!(!supports_ledger_version("/"));
+    }
+
+    #[test]
+    fn fails_over_from_dead_endpoint_and_remembers_healthy_one() {
+        let healthy = serve("200 OK", r#"{"chain_id":1}"#, 2);
+        let client = AptosClient::new(&format!("{},{healthy}", dead_endpoint())).unwrap();
+
+        assert_eq!(client.get_json("/").unwrap()["chain_id"], 1);
+        assert_eq!(client.base_url(), healthy);
+        assert_eq!(client.get_json("/").unwrap()["chain_id"], 1);
+    }
+
+    #[test]
+    fn fails_over_on_server_error_but_not_client_error() {
+        let broken = serve("503 Service Unavailable", "{}", 1);
+        let healthy = serve("200 OK", "[]", 1);
+        let client = AptosClient::new(&format!("{broken},{healthy}")).unwrap();
+        assert!(client.get_json("/transactions").is_ok());
+
+        let missing = serve("404 Not Found", r#"{"error_code":"resource_not_found"}"#, 1);
+        let unused = serve("200 OK", "[]", 1);
+        let client = AptosClient::new(&format!("{missing},{unused}")).unwrap();
+        let err = client
+            .get_json("/accounts/0x1/resource/0x1::x::Y")
+            .unwrap_err();
+        assert!(err.to_string().contains("status 404"));
+    }
+
+    #[test]
+    fn strict_endpoint_disables_failover() {
+        let healthy = serve("200 OK", "{}", 1);
+        let options = ClientOptions {
+            strict_endpoint: true,
+            ..ClientOptions::default()
+        };
+        let client =
+            AptosClient::with_options(&format!("{},{healthy}", dead_endpoint()), options).unwrap();
+        assert!(client.get_json("/").is_err());
+    }
+
+    #[test]
+    fn audits_every_attempt_including_failovers() {
+        let path =
+            std::env::temp_dir().join(format!("aptly-audit-lib-{}.jsonl", std::process::id()));
+        let _ = std::fs::remove_file(&path);
+        let healthy = serve("200 OK", r#"{"chain_id":1}"#, 1);
+        let options = ClientOptions {
+            audit: Some(Arc::new(
+                AuditLog::open(&path, "node ledger", false).unwrap(),
+            )),
+            ..ClientOptions::default()
+        };
+        let client =
+            AptosClient::with_options(&format!("{},{healthy}", dead_endpoint()), options).unwrap();
+        client.get_json("/").unwrap();
+
+        let log = std::fs::read_to_string(&path).unwrap();
+        let lines: Vec<Value> = log
+            .lines()
+            .map(|line| serde_json::from_str(line).unwrap())
+            .collect();
+        assert_eq!(lines.len(), 2);
+        assert_eq!(lines[0]["status"], Value::Null);
+        assert!(lines[0]["error"].is_string());
+        assert_eq!(lines[1]["status"], 200);
+        assert_eq!(lines[1]["command"], "node ledger");
+        assert_eq!(lines[1]["url"], format!("{healthy}/"));
+        std::fs::remove_file(&path).unwrap();
+    }
+
+    #[test]
+    fn replays_recorded_responses_without_the_network() {
+        let dir = std::env::temp_dir().join(format!("aptly-replay-lib-{}", std::process::id()));
+        let _ = std::fs::remove_dir_all(&dir);
+        let healthy = serve("200 OK", r#"{"chain_id":1}"#, 1);
+        let options = ClientOptions {
+            fixtures: Some(Arc::new(Fixtures::record(&dir).unwrap())),
+            ..ClientOptions::default()
+        };
+        let client = AptosClient::with_options(&healthy, options).unwrap();
+        assert_eq!(client.get_json("/").unwrap()["chain_id"], 1);
 
-        serde_json::from_str(&text).context("failed to parse response JSON")
+        let options = ClientOptions {
+            fixtures: Some(Arc::new(Fixtures::replay(&dir).unwrap())),
+            ..ClientOptions::default()
+        };
+        let client = AptosClient::with_options(&dead_endpoint(), options).unwrap();
+        assert_eq!(client.get_json("/").unwrap()["chain_id"], 1);
+        let err = client.get_json("/transactions").unwrap_err();
+        assert!(err
+            .to_string()
+            .starts_with("no recorded fixture for GET /transactions in "));
+        std::fs::remove_dir_all(&dir).unwrap();
     }
 }
@@ -0,0 +1,213 @@
+use std::sync::Mutex;
+use std::thread;
+use std::time::{Duration, Instant};
+
+/// Lowest rate the limiter backs off to after repeated 429 responses.
+const MIN_BACKOFF_RPS: f64 = 0.5;
+/// Multiplier applied to the effective rate after each successful request
+/// until it recovers to the configured maximum.
+const RECOVERY_FACTOR: f64 = 1.1;
+
+pub(crate) trait Clock {
+    fn now(&self) -> Instant;
+    fn sleep(&self, duration: Duration);
+}
+
+pub(crate) struct SystemClock;
+
+impl Clock for SystemClock {
+    fn now(&self) -> Instant {
+        Instant::now()
+    }
+
+    fn sleep(&self, duration: Duration) {
+        thread::sleep(duration);
+    }
+}
+
+/// Counters reported under `--verbose`.
+#[derive(Debug, Clone, Default)]
+pub struct RateLimitStats {
+    pub requests: u64,
+    pub delayed: u64,
+    pub waited: Duration,
+    pub rate_limited: u64,
+    pub effective_rps: f64,
+}
+
+/// Token-bucket limiter shared by every request an `AptosClient` sends. A 429
+/// response halves the effective rate; successes let it recover gradually.
+pub(crate) struct RateLimiter<C: Clock = SystemClock> {
+    clock: C,
+    max_rps: f64,
+    state: Mutex<BucketState>,
+}
+
+struct BucketState {
+    tokens: f64,
+    rate: f64,
+    updated: Instant,
+    stats: RateLimitStats,
+}
+
+impl RateLimiter<SystemClock> {
+    pub(crate) fn new(max_rps: f64) -> Self {
+        Self::with_clock(max_rps, SystemClock)
+    }
+}
+
+impl<C: Clock> RateLimiter<C> {
+    /// `max_rps <= 0` disables limiting but still collects stats.
+    pub(crate) fn with_clock(max_rps: f64, clock: C) -> Self {
+        let updated = clock.now();
+        Self {
+            clock,
+            max_rps,
+            state: Mutex::new(BucketState {
+                tokens: max_rps.max(1.0),
+                rate: max_rps,
+                updated,
+                stats: RateLimitStats::default(),
+            }),
+        }
+    }
+
+    /// Blocks until a request may be sent. The lock is held while sleeping so
+    /// concurrent callers queue up behind each other instead of bursting.
+    pub(crate) fn acquire(&self) {
+        let mut state = self.state.lock().unwrap_or_else(|err| err.into_inner());
+        state.stats.requests += 1;
+        if self.max_rps <= 0.0 {
+            return;
+        }
+
+        let now = self.clock.now();
+        let elapsed = now.saturating_duration_since(state.updated).as_secs_f64();
+        let burst = self.max_rps.max(1.0);
+        state.tokens = (state.tokens + elapsed * state.rate).min(burst);
+        state.updated = now;
+
+        if state.tokens < 1.0 {
+            let wait = Duration::from_secs_f64((1.0 - state.tokens) / state.rate);
+            state.stats.delayed += 1;
+            state.stats.waited += wait;
+            self.clock.sleep(wait);
+            state.tokens = 1.0;
+            state.updated = self.clock.now();
+        }
+        state.tokens -= 1.0;
+    }
+
+    /// Records a 429, lowers the effective rate, and sleeps for `backoff`.
+    pub(crate) fn on_rate_limited(&self, backoff: Duration) {
+        let mut state = self.state.lock().unwrap_or_else(|err| err.into_inner());
+        state.stats.rate_limited += 1;
+        if self.max_rps > 0.0 {
+            state.rate = (state.rate / 2.0).max(MIN_BACKOFF_RPS.min(self.max_rps));
+            state.tokens = 0.0;
+        }
+        state.stats.waited += backoff;
+        self.clock.sleep(backoff);
+        state.updated = self.clock.now();
+    }
+
+    pub(crate) fn on_success(&self) {
+        let mut state = self.state.lock().unwrap_or_else(|err| err.into_inner());
+        if self.max_rps > 0.0 && state.rate < self.max_rps {
+            state.rate = (state.rate * RECOVERY_FACTOR).min(self.max_rps);
+        }
+    }
+
+    pub(crate) fn stats(&self) -> RateLimitStats {
+        let state = self.state.lock().unwrap_or_else(|err| err.into_inner());
+        RateLimitStats {
+            effective_rps: state.rate,
+            ..state.stats.clone()
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::cell::Cell;
+
+    struct FakeClock {
+        now: Cell<Instant>,
+    }
+
+    impl FakeClock {
+        fn new() -> Self {
+            Self {
+                now: Cell::new(Instant::now()),
+            }
+        }
+
+        fn advance(&self, duration: Duration) {
+            self.now.set(self.now.get() + duration);
+        }
+    }
+
+    impl Clock for &FakeClock {
+        fn now(&self) -> Instant {
+            self.now.get()
+        }
+
+        fn sleep(&self, duration: Duration) {
+            self.advance(duration);
+        }
+    }
+
+    fn elapsed_for(limiter: &RateLimiter<&FakeClock>, clock: &FakeClock, requests: usize) -> f64 {
+        let start = clock.now.get();
+        for _ in 0..requests {
+            limiter.acquire();
+        }
+        (clock.now.get() - start).as_secs_f64()
+    }
+
+    #[test]
+    fn allows_burst_then_paces_requests() {
+        let clock = FakeClock::new();
+        let limiter = RateLimiter::with_clock(2.0, &clock);
+
+        assert_eq!(elapsed_for(&limiter, &clock, 2), 0.0);
+        let paced = elapsed_for(&limiter, &clock, 4);
+        assert!((paced - 2.0).abs() < 1e-6, "paced {paced}");
+        assert_eq!(limiter.stats().delayed, 4);
+    }
+
+    #[test]
+    fn refills_tokens_while_idle() {
+        let clock = FakeClock::new();
+        let limiter = RateLimiter::with_clock(5.0, &clock);
+        elapsed_for(&limiter, &clock, 5);
+        clock.advance(Duration::from_secs(1));
+        assert_eq!(elapsed_for(&limiter, &clock, 5), 0.0);
+    }
+
+    #[test]
+    fn rate_limited_response_halves_rate_and_recovers() {
+        let clock = FakeClock::new();
+        let limiter = RateLimiter::with_clock(4.0, &clock);
+        limiter.on_rate_limited(Duration::from_secs(1));
+        assert_eq!(limiter.stats().effective_rps, 2.0);
+
+        let paced = elapsed_for(&limiter, &clock, 2);
+        assert!((paced - 1.0).abs() < 1e-6, "paced {paced}");
+
+        for _ in 0..20 {
+            limiter.on_success();
+        }
+        assert_eq!(limiter.stats().effective_rps, 4.0);
+        assert_eq!(limiter.stats().rate_limited, 1);
+    }
+
+    #[test]
+    fn zero_rate_disables_limiting() {
+        let clock = FakeClock::new();
+        let limiter = RateLimiter::with_clock(0.0, &clock);
+        assert_eq!(elapsed_for(&limiter, &clock, 100), 0.0);
+        assert_eq!(limiter.stats().requests, 100);
+    }
+}
@@ -0,0 +1,81 @@
+/// Calendar date and time of day in UTC.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct UtcDateTime {
+    pub year: u64,
+    pub month: u64,
+    pub day: u64,
+    pub hour: u64,
+    pub minute: u64,
+    pub second: u64,
+}
+
+impl UtcDateTime {
+    /// Converts seconds since the Unix epoch.
+    pub fn from_unix_seconds(secs: u64) -> Self {
+        let (days, secs_of_day) = (secs / 86_400, secs % 86_400);
+
+        // Civil-from-days (Howard Hinnant), valid for every date after 1970.
+        let z = days + 719_468;
+        let era = z / 146_097;
+        let day_of_era = z % 146_097;
+        let year_of_era =
+            (day_of_era - day_of_era / 1460 + day_of_era / 36_524 - day_of_era / 146_096) / 365;
+        let day_of_year = day_of_era - (365 * year_of_era + year_of_era / 4 - year_of_era / 100);
+        let mp = (5 * day_of_year + 2) / 153;
+        let day = day_of_year - (153 * mp + 2) / 5 + 1;
+        let month = if mp < 10 { mp + 3 } else { mp - 9 };
+        let year = year_of_era + era * 400 + u64::from(month <= 2);
+
+        Self {
+            year,
+            month,
+            day,
+            hour: secs_of_day / 3600,
+            minute: secs_of_day % 3600 / 60,
+            second: secs_of_day % 60,
+        }
+    }
+
+    /// Seconds since the Unix epoch; the inverse of
+    /// [`UtcDateTime::from_unix_seconds`] for dates from 1970 on.
+    pub fn to_unix_seconds(&self) -> u64 {
+        // Days-from-civil (Howard Hinnant), with March as the first month.
+        let year = self.year - u64::from(self.month <= 2);
+        let era = year / 400;
+        let year_of_era = year - era * 400;
+        let mp = (self.month + 9) % 12;
+        let day_of_year = (153 * mp + 2) / 5 + self.day - 1;
+        let day_of_era = year_of_era * 365 + year_of_era / 4 - year_of_era / 100 + day_of_year;
+        let days = era * 146_097 + day_of_era - 719_468;
+        days * 86_400 + self.hour * 3600 + self.minute * 60 + self.second
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn converts_unix_seconds() {
+        let at = |year, month, day, hour, minute, second| UtcDateTime {
+            year,
+            month,
+            day,
+            hour,
+            minute,
+            second,
+        };
+        assert_eq!(UtcDateTime::from_unix_seconds(0), at(1970, 1, 1, 0, 0, 0));
+        assert_eq!(
+            UtcDateTime::from_unix_seconds(951_782_400),
+            at(2000, 2, 29, 0, 0, 0)
+        );
+        assert_eq!(
+            UtcDateTime::from_unix_seconds(1_700_000_000),
+            at(2023, 11, 14, 22, 13, 20)
+        );
+        for secs in [0, 951_782_400, 1_700_000_000, 1_709_251_199, 4_107_542_400] {
+            assert_eq!(UtcDateTime::from_unix_seconds(secs).to_unix_seconds(), secs);
+        }
+    }
+}
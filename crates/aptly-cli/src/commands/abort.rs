@@ -0,0 +1,437 @@
+use anyhow::{anyhow, Result};
+use aptly_aptos::AptosClient;
+use serde::Serialize;
+use serde_json::{json, Value};
+use std::collections::{BTreeMap, HashMap};
+use std::fmt;
+
+use crate::commands::account::{decode_source, DEFAULT_MAX_SOURCE_BYTES, PACKAGE_REGISTRY_TYPE};
+use crate::commands::common::{normalize_address, parse_u64};
+use crate::commands::schema::{
+    integer_schema, nullable, object_schema, string_schema, OutputSchema,
+};
+
+/// `std::error` categories, stored in the upper 16 bits of a canonical abort
+/// code.
+const CATEGORIES: &[(u64, &str)] = &[
+    (0x1, "INVALID_ARGUMENT"),
+    (0x2, "OUT_OF_RANGE"),
+    (0x3, "INVALID_STATE"),
+    (0x4, "UNAUTHENTICATED"),
+    (0x5, "PERMISSION_DENIED"),
+    (0x6, "NOT_FOUND"),
+    (0x7, "ABORTED"),
+    (0x8, "ALREADY_EXISTS"),
+    (0x9, "RESOURCE_EXHAUSTED"),
+    (0xA, "CANCELLED"),
+    (0xB, "INTERNAL"),
+    (0xC, "NOT_IMPLEMENTED"),
+    (0xD, "UNAVAILABLE"),
+];
+
+/// Module and code of a Move abort, as parsed from a `vm_status` string.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub(crate) struct AbortLocation {
+    /// `<address>::<module>`.
+    pub(crate) module: String,
+    pub(crate) code: u64,
+}
+
+/// Parses the abort formats nodes have used, e.g.
+/// `Move abort in 0x1::coin: EINSUFFICIENT_BALANCE(0x10006): ...`,
+/// `Move abort in 0x1::coin: 0x10006`, and `Move abort 0x10006 in 0x1::coin`.
+pub(crate) fn parse_abort(vm_status: &str) -> Option<AbortLocation> {
+    let (_, rest) = vm_status.split_once("Move abort")?;
+    let words: Vec<&str> = rest
+        .split(|c: char| c.is_whitespace() || matches!(c, '(' | ')' | ','))
+        .map(|word| word.trim_end_matches(':'))
+        .filter(|word| !word.is_empty())
+        .collect();
+
+    let module = words.iter().find_map(|word| {
+        let (address, name) = word.split_once("::")?;
+        let valid = address.starts_with("0x")
+            && !name.is_empty()
+            && name.chars().all(|c| c.is_ascii_alphanumeric() || c == '_');
+        valid.then(|| format!("{address}::{name}"))
+    })?;
+    let code = words
+        .iter()
+        .filter(|word| !word.contains("::"))
+        .find_map(|word| match word.strip_prefix("0x") {
+            Some(hex) => u64::from_str_radix(hex, 16).ok(),
+            None => word.parse::<u64>().ok(),
+        })?;
+    Some(AbortLocation { module, code })
+}
+
+/// Error constant declared by a module.
+#[derive(Debug, Clone, PartialEq, Eq)]
+struct ErrorConstant {
+    name: String,
+    description: Option<String>,
+}
+
+/// An abort decoded to its category and, when available, constant name.
+#[derive(Debug, Clone, Serialize)]
+pub(crate) struct ResolvedAbort {
+    module: String,
+    code: String,
+    category: Option<&'static str>,
+    reason: u64,
+    name: Option<String>,
+    description: Option<String>,
+}
+
+impl OutputSchema for ResolvedAbort {
+    fn output_schema() -> Value {
+        object_schema(
+            "Move abort resolved against the aborting module's error constants",
+            &[
+                (
+                    "module",
+                    string_schema("Aborting module (`<address>::<name>`)"),
+                ),
+                ("code", string_schema("Abort code as hex (`0x...`)")),
+                (
+                    "category",
+                    json!({
+                        "description": "`std::error` category from the upper 16 bits; null for non-canonical codes",
+                        "type": ["string", "null"],
+                        "enum": CATEGORIES
+                            .iter()
+                            .map(|(_, name)| json!(name))
+                            .chain([Value::Null])
+                            .collect::<Vec<_>>(),
+                    }),
+                ),
+                (
+                    "reason",
+                    integer_schema("Module-specific reason (lower 16 bits, or the whole code)"),
+                ),
+                (
+                    "name",
+                    nullable(string_schema(
+                        "Error constant name, e.g. `EINSUFFICIENT_BALANCE`",
+                    )),
+                ),
+                (
+                    "description",
+                    nullable(string_schema("Doc comment of the error constant")),
+                ),
+            ],
+        )
+    }
+}
+
+pub(crate) fn resolved_abort_schema() -> Value {
+    ResolvedAbort::output_schema()
+}
+
+impl fmt::Display for ResolvedAbort {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        write!(f, "Move abort {} in {}", self.code, self.module)?;
+        match (&self.name, self.category) {
+            (Some(name), Some(category)) => write!(f, ": {name} ({category})")?,
+            (Some(name), None) => write!(f, ": {name}")?,
+            (None, Some(category)) => write!(f, ": reason {} ({category})", self.reason)?,
+            (None, None) => {}
+        }
+        if let Some(description) = &self.description {
+            write!(f, " - {description}")?;
+        }
+        Ok(())
+    }
+}
+
+/// Splits an abort code into its `std::error` category and reason. Codes
+/// without a known category in the upper 16 bits are returned whole.
+fn split_code(code: u64) -> (Option<&'static str>, u64) {
+    let category = CATEGORIES
+        .iter()
+        .find(|(value, _)| *value == code >> 16)
+        .map(|(_, name)| *name);
+    match category {
+        Some(category) => (Some(category), code & 0xFFFF),
+        None => (None, code),
+    }
+}
+
+/// Resolves abort codes to error constant names, reading each module's
+/// published source (or the ABI's error map) at most once.
+#[derive(Default)]
+pub(crate) struct AbortResolver {
+    modules: HashMap<String, BTreeMap<u64, ErrorConstant>>,
+}
+
+impl AbortResolver {
+    /// Resolves the abort in `vm_status`, if it is one. Lookup failures only
+    /// drop the constant name.
+    pub(crate) fn resolve_status(
+        &mut self,
+        client: &AptosClient,
+        vm_status: &str,
+    ) -> Option<ResolvedAbort> {
+        let location = parse_abort(vm_status)?;
+        Some(self.resolve(client, &location))
+    }
+
+    pub(crate) fn resolve(
+        &mut self,
+        client: &AptosClient,
+        location: &AbortLocation,
+    ) -> ResolvedAbort {
+        let (address, name) = location
+            .module
+            .split_once("::")
+            .unwrap_or((location.module.as_str(), ""));
+        let key = format!("{}::{name}", normalize_address(address));
+        let constants = self.modules.entry(key).or_insert_with(|| {
+            fetch_error_constants(client, address, name).unwrap_or_else(|err| {
+                eprintln!(
+                    "warning: cannot resolve error names for {}: {err:#}",
+                    location.module
+                );
+                BTreeMap::new()
+            })
+        });
+        describe(location, constants)
+    }
+}
+
+fn describe(location: &AbortLocation, constants: &BTreeMap<u64, ErrorConstant>) -> ResolvedAbort {
+    let (category, reason) = split_code(location.code);
+    let constant = constants.get(&reason);
+    ResolvedAbort {
+        module: location.module.clone(),
+        code: format!("0x{:x}", location.code),
+        category,
+        reason,
+        name: constant.map(|constant| constant.name.clone()),
+        description: constant.and_then(|constant| constant.description.clone()),
+    }
+}
+
+/// Error constants of `address::module`, from embedded source when published
+/// with metadata, otherwise from an `error_map` in the module ABI.
+fn fetch_error_constants(
+    client: &AptosClient,
+    address: &str,
+    module: &str,
+) -> Result<BTreeMap<u64, ErrorConstant>> {
+    if let Some(source) = fetch_module_source(client, address, module)? {
+        let constants = parse_error_constants(&source);
+        if !constants.is_empty() {
+            return Ok(constants);
+        }
+    }
+    let value = client.get_json(&format!("/accounts/{address}/module/{module}"))?;
+    let error_map = value
+        .get("abi")
+        .and_then(|abi| abi.get("error_map"))
+        .ok_or_else(|| anyhow!("module has no source metadata or ABI error map"))?;
+    Ok(parse_error_map(error_map))
+}
+
+fn fetch_module_source(
+    client: &AptosClient,
+    address: &str,
+    module: &str,
+) -> Result<Option<String>> {
+    let resource_type = urlencoding::encode(PACKAGE_REGISTRY_TYPE);
+    let registry = match client.get_json(&format!("/accounts/{address}/resource/{resource_type}")) {
+        Ok(registry) => registry,
+        Err(err) if err.to_string().contains("status 404") => return Ok(None),
+        Err(err) => return Err(err),
+    };
+    let packages = registry
+        .get("data")
+        .and_then(|data| data.get("packages"))
+        .and_then(Value::as_array);
+    let source_hex = packages
+        .into_iter()
+        .flatten()
+        .filter_map(|package| package.get("modules").and_then(Value::as_array))
+        .flatten()
+        .find(|entry| entry.get("name").and_then(Value::as_str) == Some(module))
+        .and_then(|entry| entry.get("source").and_then(Value::as_str))
+        .filter(|source| !source.is_empty() && *source != "0x");
+    source_hex
+        .map(|hex| decode_source(hex, DEFAULT_MAX_SOURCE_BYTES))
+        .transpose()
+}
+
+/// Collects `const E...: u64 = <n>;` declarations with their `///` docs.
+fn parse_error_constants(source: &str) -> BTreeMap<u64, ErrorConstant> {
+    let mut constants = BTreeMap::new();
+    let mut docs: Vec<&str> = Vec::new();
+    for line in source.lines() {
+        let line = line.trim();
+        if let Some(doc) = line.strip_prefix("///") {
+            docs.push(doc.trim());
+            continue;
+        }
+        if let Some(constant) = parse_error_constant(line) {
+            let (name, value) = constant;
+            let description = (!docs.is_empty()).then(|| docs.join(" "));
+            constants.insert(
+                value,
+                ErrorConstant {
+                    name: name.to_owned(),
+                    description,
+                },
+            );
+        }
+        if !line.starts_with("#[") {
+            docs.clear();
+        }
+    }
+    constants
+}
+
+fn parse_error_constant(line: &str) -> Option<(&str, u64)> {
+    let rest = line.strip_prefix("const ")?;
+    let (name, rest) = rest.split_once(':')?;
+    let name = name.trim();
+    let (ty, value) = rest.split_once('=')?;
+    if !name.starts_with('E') || ty.trim() != "u64" {
+        return None;
+    }
+    let value = value.trim().trim_end_matches(';').trim();
+    let value = match value.strip_prefix("0x") {
+        Some(hex) => u64::from_str_radix(hex, 16).ok()?,
+        None => value.parse().ok()?,
+    };
+    Some((name, value))
+}
+
+/// Accepts `{"<code>": {"name": ..., "description": ...}}` or a list of
+/// `{"code", "name", "description"}` entries.
+fn parse_error_map(error_map: &Value) -> BTreeMap<u64, ErrorConstant> {
+    let entries: Vec<(Option<u64>, &Value)> = match error_map {
+        Value::Object(map) => map
+            .iter()
+            .map(|(code, entry)| (code.parse().ok(), entry))
+            .collect(),
+        Value::Array(entries) => entries
+            .iter()
+            .map(|entry| (entry.get("code").and_then(parse_u64), entry))
+            .collect(),
+        _ => Vec::new(),
+    };
+    entries
+        .into_iter()
+        .filter_map(|(code, entry)| {
+            let name = entry.get("name").and_then(Value::as_str)?;
+            Some((
+                code?,
+                ErrorConstant {
+                    name: name.to_owned(),
+                    description: entry
+                        .get("description")
+                        .and_then(Value::as_str)
+                        .filter(|description| !description.is_empty())
+                        .map(str::to_owned),
+                },
+            ))
+        })
+        .collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::commands::schema::validate;
+
+    /// Error constants as declared in `aptos_framework::coin`.
+    const COIN_SOURCE: &str = "
+module aptos_framework::coin {
+    /// Address of account which is used to initialize a coin `CoinType` doesn't match the deployer of module
+    const ECOIN_INFO_ADDRESS_MISMATCH: u64 = 1;
+
+    /// `CoinType` is already initialized as a coin
+    const ECOIN_INFO_ALREADY_PUBLISHED: u64 = 2;
+
+    /// `CoinType` hasn't been initialized as a coin
+    const ECOIN_INFO_NOT_PUBLISHED: u64 = 3;
+
+    /// Account hasn't registered `CoinStore` for `CoinType`
+    const ECOIN_STORE_NOT_PUBLISHED: u64 = 5;
+
+    /// Not enough coins to complete transaction
+    const EINSUFFICIENT_BALANCE: u64 = 6;
+
+    /// CoinStore is frozen. Coins cannot be deposited or withdrawn
+    const EFROZEN: u64 = 10;
+
+    const MAX_U64: u128 = 18446744073709551615;
+}
+";
+
+    fn resolve(vm_status: &str) -> ResolvedAbort {
+        let location = parse_abort(vm_status).unwrap();
+        describe(&location, &parse_error_constants(COIN_SOURCE))
+    }
+
+    #[test]
+    fn parses_abort_formats() {
+        let expected = AbortLocation {
+            module: "0x1::coin".to_owned(),
+            code: 0x10006,
+        };
+        for status in [
+            "Move abort in 0x1::coin: EINSUFFICIENT_BALANCE(0x10006): Not enough coins to complete transaction",
+            "Move abort in 0x1::coin: 0x10006",
+            "Move abort 0x10006 in 0x1::coin",
+            "Move abort: code 65542 at 0x1::coin",
+        ] {
+            assert_eq!(parse_abort(status), Some(expected.clone()), "{status}");
+        }
+        assert_eq!(parse_abort("Executed successfully"), None);
+        assert_eq!(parse_abort("Out of gas"), None);
+    }
+
+    #[test]
+    fn resolves_coin_error_constants() {
+        let abort = resolve("Move abort in 0x1::coin: 0x10006");
+        assert_eq!(abort.category, Some("INVALID_ARGUMENT"));
+        assert_eq!(abort.reason, 6);
+        assert_eq!(abort.name.as_deref(), Some("EINSUFFICIENT_BALANCE"));
+        assert_eq!(
+            abort.to_string(),
+            "Move abort 0x10006 in 0x1::coin: EINSUFFICIENT_BALANCE (INVALID_ARGUMENT) - Not enough coins to complete transaction"
+        );
+
+        let abort = resolve("Move abort 0x60005 in 0x1::coin");
+        assert_eq!(abort.category, Some("NOT_FOUND"));
+        assert_eq!(abort.name.as_deref(), Some("ECOIN_STORE_NOT_PUBLISHED"));
+
+        let abort = resolve("Move abort 0x5000a in 0x1::coin");
+        assert_eq!(abort.category, Some("PERMISSION_DENIED"));
+        assert_eq!(abort.name.as_deref(), Some("EFROZEN"));
+        validate(
+            &resolved_abort_schema(),
+            &serde_json::to_value(&abort).unwrap(),
+        )
+        .unwrap();
+    }
+
+    #[test]
+    fn non_canonical_codes_keep_the_whole_value() {
+        assert_eq!(split_code(3), (None, 3));
+        assert_eq!(split_code(0xFF_0003), (None, 0xFF_0003));
+        assert_eq!(split_code(0x3_0001), (Some("INVALID_STATE"), 1));
+        let abort = resolve("Move abort 0x2 in 0x1::coin");
+        assert_eq!(abort.name.as_deref(), Some("ECOIN_INFO_ALREADY_PUBLISHED"));
+    }
+
+    #[test]
+    fn reads_abi_error_maps() {
+        let map = parse_error_map(&json!({
+            "6": {"name": "EINSUFFICIENT_BALANCE", "description": "Not enough coins"}
+        }));
+        assert_eq!(map[&6].name, "EINSUFFICIENT_BALANCE");
+        let list = parse_error_map(&json!([{"code": "6", "name": "EINSUFFICIENT_BALANCE"}]));
+        assert_eq!(list[&6].description, None);
+    }
+}
@@ -1,24 +1,78 @@
 use anyhow::{anyhow, Context, Result};
 use aptly_aptos::AptosClient;
-use clap::{Args, Subcommand};
+use clap::{Args, Subcommand, ValueEnum};
 use flate2::read::GzDecoder;
 use num_bigint::BigInt;
+use regex::Regex;
 use serde::Serialize;
-use serde_json::Value;
-use std::collections::HashMap;
-use std::io::Read;
+use serde_json::{json, Value};
+use std::collections::{BTreeMap, BTreeSet, HashMap, HashSet};
+use std::io::{self, IsTerminal, Read};
+use std::path::{Path, PathBuf};
 use std::str::FromStr;
+use std::sync::Mutex;
+use std::thread;
+use std::time::{SystemTime, UNIX_EPOCH};
 
+use crate::commands::account_gas::{run_account_gas, GasArgs};
+use crate::commands::account_txs::{
+    account_transactions, parse_tx_cursor, pretty_tx_lines, run_account_txs_page, TxCursor, TxOrder,
+};
+use crate::commands::account_watch::{run_account_watch, WatchArgs};
+use crate::commands::assets::{self, AssetMetadata, APTOS_COIN_TYPE, APT_METADATA_ADDRESS};
+use crate::commands::auth::{
+    run_account_auth, run_account_key_rotation, AuthArgs, KeyRotationArgs,
+};
+use crate::commands::bindings::{self, BindingLanguage};
+use crate::commands::block::version_at_timestamp;
+use crate::commands::coin;
 use crate::commands::common::{
-    get_nested_string, parse_u64, shorten_addr, value_to_string, with_optional_ledger_version,
+    check_ledger_version, explain_pruned, format_timestamp_micros, get_json_at_version,
+    get_nested_string, glob_matches, normalize_address, normalize_qualified_name, parse_time_bound,
+    parse_u64, parse_utc_offset, shorten_addr, value_to_string, with_optional_ledger_version,
+    CanonicalAddresses,
+};
+use crate::commands::disassemble::Disassembler;
+use crate::commands::events::{run_account_handles, HandlesArgs};
+use crate::commands::fa::{self, run_account_primary_store, PrimaryStoreArgs};
+use crate::commands::follow::{run_follow, FollowArgs, FollowSource};
+use crate::commands::gas_profile::{run_gas_profile, GasProfileArgs};
+use crate::commands::graph::{pair_transfers, BURN};
+use crate::commands::indexer::Indexer;
+use crate::commands::labels::{AddressLabels, LabelAddresses};
+use crate::commands::move_structs::StructLayout;
+use crate::commands::multisig::{run_account_multisig, MultisigArgs};
+use crate::commands::names::AnsNames;
+use crate::commands::objects::{run_account_objects, ObjectsArgs};
+use crate::commands::resource_history::{run_resource_history, ResourceHistoryArgs};
+use crate::commands::schema::{
+    array_schema, boolean_schema, integer_schema, nullable, object_schema, string_schema,
+    with_optional_properties, OutputSchema,
+};
+use crate::commands::source_verify::{self, run_source_verify, SourceVerifyArgs};
+use crate::commands::stake::{run_account_stake, StakeArgs};
+use crate::commands::storage::{run_account_storage, StorageArgs};
+use crate::commands::tx::{
+    extract_transfer_store_info_from_tx, query_object_owner, query_transfer_store_info,
+    transaction_balance_changes, Transaction, TransferStoreMetadata,
 };
+use crate::commands::type_tag::StructTag;
+use crate::csv_export::{koinly_csv, sends_csv, KoinlyRow, SendRow};
+use crate::output::{file_component, write_atomic};
+use crate::sqlite_export::{self, TransferRow, SOURCE_SENDS};
 
-const PACKAGE_REGISTRY_TYPE: &str = "0x1::code::PackageRegistry";
-const FUNGIBLE_METADATA_TYPE: &str = "0x1::fungible_asset::Metadata";
+pub(crate) const PACKAGE_REGISTRY_TYPE: &str = "0x1::code::PackageRegistry";
+/// Largest page the node serves from `/accounts/{address}/resources`.
+const RESOURCES_PAGE_LIMIT: u64 = 9999;
+/// Largest page the node serves from `/accounts/{address}/transactions`.
+pub(crate) const TRANSACTIONS_PAGE_LIMIT: u64 = 100;
+pub(crate) const DEFAULT_MAX_SOURCE_BYTES: u64 = 16 * 1024 * 1024;
+const DEFAULT_DEPS_DEPTH: usize = 4;
+const GZIP_MAGIC: [u8; 2] = [0x1f, 0x8b];
 
 #[derive(Args)]
 #[command(
-    after_help = "Examples:\n  aptly account 0x1\n  aptly account resources 0x1\n  aptly account resource 0x1 0x1::coin::CoinInfo<0x1::aptos_coin::AptosCoin>\n  aptly account module 0x1 coin --abi\n  aptly account balance 0x1 0x1::aptos_coin::AptosCoin\n  aptly account txs 0x1 --limit 10\n  aptly account sends 0x1 --limit 50 --pretty\n  aptly account source-code 0x1 chain_id --raw\n\nIf source metadata is unavailable:\n  aptly decompile address <address>\n  aptly decompile module <address> <module_name>"
+    after_help = "Examples:\n  aptly account 0x1\n  aptly account resources 0x1\n  aptly account resource 0x1 0x1::coin::CoinInfo<0x1::aptos_coin::AptosCoin>\n  aptly account modules 0x1 --names --sort functions\n  aptly account module 0x1 coin --abi\n  aptly account module 0x1 coin --disassemble\n  aptly account module 0x1 coin --save-bytecode coin.mv\n  aptly account module 0x1 coin --gen ts --out ./bindings\n  aptly account balance 0x1 0x1::aptos_coin::AptosCoin\n  aptly account coins 0x1 --include-zero\n  aptly account balances 0x1 --min-value 0.01 --pretty\n  aptly account auth 0x1234 --public-key 0x<key>\n  aptly account txs 0x1 --limit 10\n  aptly account txs 0x1 --follow --metrics-listen :9464\n  aptly account sends 0x1 --limit 50 --pretty\n  aptly account sends 0x1 --sends 100 --pretty\n  aptly account sends 0x1 --sends 20 --asset USDC --pretty\n  aptly account sends 0x1 --limit 100 --min-amount 1000 --pretty\n  aptly account sends 0x1 --sends 1000 --ndjson | jq -c .\n  aptly account sends 0x1 --group-by-function --pretty\n  aptly account sends 0x1 --pretty --precision 4\n  aptly account sends 0x1 --limit 500 --format csv > sends.csv\n  aptly account sends 0x1 --limit 500 --format koinly > koinly.csv\n  aptly account sends 0x1 --since 2024-03-01 --until 2024-03-31 --format koinly\n  aptly account gas-profile 0x1 --last 5000 --pretty\n  aptly account source-code 0x1 chain_id --raw\n\nIf source metadata is unavailable:\n  aptly decompile address <address>\n  aptly decompile module <address> <module_name>"
 )]
 pub(crate) struct AccountCommand {
     #[command(subcommand)]
@@ -26,40 +80,162 @@ pub(crate) struct AccountCommand {
     /// Account address (`0x...`) when no subcommand is provided.
     #[arg(value_name = "ADDRESS")]
     pub(crate) address: Option<String>,
+    /// With ADDRESS, print a summary that adds the APT balance, resource and
+    /// module counts, and whether the authentication key was rotated.
+    #[arg(long, default_value_t = false, requires = "address")]
+    pub(crate) pretty: bool,
+}
+
+/// `account <address> --pretty` summary; `None` where a lookup failed.
+#[derive(Debug, Clone, PartialEq, Eq)]
+struct AccountSummary {
+    address: String,
+    sequence_number: String,
+    authentication_key: String,
+    apt_balance: Option<String>,
+    resources: Option<usize>,
+    modules: Option<usize>,
 }
 
 #[derive(Subcommand)]
 pub(crate) enum AccountSubcommand {
     #[command(about = "List all Move resources under an account")]
-    Resources(AddressArg),
+    Resources(ResourcesArgs),
     #[command(about = "Read a Move resource by fully-qualified type")]
     Resource(ResourceArgs),
-    #[command(about = "List all Move modules published under an account")]
-    Modules(AddressArg),
+    #[command(
+        name = "resource-history",
+        about = "Binary-search ledger versions for changes to a resource field",
+        after_help = "Examples:\n  aptly account resource-history 0x1234 0x1::stake::StakePool --path locked_until_secs --from 1000000\n  aptly account resource-history 0x1234 '0x1::coin::CoinStore<0x1::aptos_coin::AptosCoin>' --path coin.value --from 1000000 --to 2000000 --max-changes 3"
+    )]
+    ResourceHistory(ResourceHistoryArgs),
+    #[command(
+        about = "List Move modules published under an account",
+        after_help = "Examples:\n  aptly account modules 0x1 --names --sort functions\n  aptly account modules 0x1 --filter '^(coin|fungible_asset)$' --exposed-only\n  aptly account modules 0x1 --names --sort size"
+    )]
+    Modules(ModulesArgs),
     #[command(about = "Read a module, its ABI only, or its raw bytecode")]
     Module(ModuleArgs),
     #[command(about = "Read fungible asset balance for an account address")]
     Balance(BalanceArgs),
+    #[command(about = "List every coin and fungible asset balance the account holds")]
+    Balances(BalancesArgs),
+    #[command(about = "List legacy CoinStore balances with their paired fungible asset balances")]
+    Coins(CoinsArgs),
+    #[command(
+        name = "primary-store",
+        about = "Derive an owner's primary fungible store address and read its state",
+        after_help = "Examples:\n  aptly account primary-store 0x1234 0xa\n  aptly account primary-store 0x1234 0x1::aptos_coin::AptosCoin"
+    )]
+    PrimaryStore(PrimaryStoreArgs),
+    #[command(
+        about = "Show delegated and directly staked APT with active, inactive and pending amounts",
+        after_help = "Examples:\n  aptly account stake 0x1234\n  aptly account stake 0x1234 --pool 0x<delegation pool> --pretty"
+    )]
+    Stake(StakeArgs),
+    #[command(
+        about = "Show a multisig account's owners, threshold and pending transactions",
+        after_help = "Examples:\n  aptly account multisig 0x1234\n  aptly account multisig 0x1234 --pending-only\n  aptly account multisig 0x1234 --tx 12"
+    )]
+    Multisig(MultisigArgs),
+    #[command(
+        about = "List objects owned by the account and the resources they hold",
+        after_help = "Examples:\n  aptly --network mainnet account objects 0x1234 --pretty\n  aptly account objects 0x1234 --type 0x1::fungible_asset::FungibleStore --limit 10\n  aptly account objects 0x1234 --type '*::token::Token' --indexer-url https://api.mainnet.aptoslabs.com/v1/graphql\n\nWithout a known indexer, recent transactions are scanned for ObjectCore writes and the result is marked partial."
+    )]
+    Objects(ObjectsArgs),
+    #[command(
+        about = "Inspect the authentication key, rotation and capability offers",
+        after_help = "Examples:\n  aptly account auth 0x1234\n  aptly account auth 0x1234 --public-key 0x<ed25519 public key>\n  aptly account auth <authentication_key> --by-auth-key"
+    )]
+    Auth(AuthArgs),
+    #[command(
+        name = "key-rotation",
+        about = "Show whether and when the authentication key was rotated",
+        after_help = "Examples:\n  aptly account key-rotation 0x1234\n  aptly account key-rotation 0x1234 --scan 5000\n\nRotations come from the account's key_rotation_events handle and from 0x1::account::KeyRotation module events in its last --scan transactions."
+    )]
+    KeyRotation(KeyRotationArgs),
+    #[command(
+        about = "List event handles in the account's resources with their creation numbers",
+        after_help = "Examples:\n  aptly account handles 0x1 --pretty\n  aptly events 0x1 <creation number> --limit 10"
+    )]
+    Handles(HandlesArgs),
+    #[command(
+        about = "Estimate state usage: resources, modules, serialized sizes and tables",
+        after_help = "Examples:\n  aptly account storage 0xcafe\n  aptly account storage 0xcafe --pretty\n\nSizes are of the node's JSON for resources and of module bytecode; they approximate, not equal, the storage fee basis."
+    )]
+    Storage(StorageArgs),
     #[command(about = "List account transactions (with --limit/--start pagination)")]
     Txs(TxsArgs),
-    #[command(about = "Summarize outgoing transfers from account transactions")]
+    #[command(
+        about = "Poll for new transactions sent by the account and print each as it lands",
+        after_help = "Examples:\n  aptly account watch 0x1234\n  aptly account watch 0x1234 --pretty --interval 2s\n  aptly account watch 0x1234 --until-sequence 42 --timeout 10m\n\nWith --until-sequence the command exits 0 once that transaction is committed and non-zero if --timeout elapses first."
+    )]
+    Watch(WatchArgs),
+    #[command(
+        about = "Summarize outgoing transfers from account transactions",
+        after_help = "Query an export:\n  aptly account sends 0x1 --limit 100 --export-sqlite sends.db\n  sqlite3 sends.db \"SELECT to_address, asset, COUNT(*) FROM transfers WHERE source = 'account sends' GROUP BY 1, 2 ORDER BY 3 DESC\""
+    )]
     Sends(SendsArgs),
+    #[command(
+        about = "List incoming transfers found through the indexer GraphQL API",
+        after_help = "Examples:\n  aptly --network mainnet account receives 0x1234 --limit 50 --pretty\n  aptly account receives 0x1234 --asset USDC --indexer-url https://api.mainnet.aptoslabs.com/v1/graphql"
+    )]
+    Receives(ReceivesArgs),
+    #[command(
+        about = "Summarize gas fees the account paid, grouped by entry function",
+        after_help = "Examples:\n  aptly account gas 0x1234 --since -7d --pretty\n  aptly account gas 0x1234 --limit 5000"
+    )]
+    Gas(GasArgs),
+    #[command(
+        name = "gas-profile",
+        about = "Profile gas used by an address's entry functions over recent transactions",
+        after_help = "Examples:\n  aptly --network mainnet account gas-profile 0x1234 --last 500 --pretty\n  aptly account gas-profile 0x1234 --indexer-url https://api.mainnet.aptoslabs.com/v1/graphql\n\nCalls are found through the indexer. When no indexer is known for the node, the last --last ledger transactions are scanned instead and the profile is marked partial."
+    )]
+    GasProfile(GasProfileArgs),
     #[command(
         name = "source-code",
         about = "Fetch published Move source metadata. If unavailable, use `aptly decompile`.",
         after_help = "Fallback when source metadata is unavailable:\n  aptly decompile address <address>\n  aptly decompile module <address> <module_name>"
     )]
     SourceCode(SourceCodeArgs),
+    #[command(
+        name = "source-verify",
+        about = "Compare a published package's sources with a local checkout",
+        after_help = "Exits non-zero when any module differs or is missing on either side:\n  aptly account source-verify 0x1 --package AptosFramework --local ./aptos-framework\n  aptly account source-verify 0xcafe --package my_app --local . --ignore-whitespace"
+    )]
+    SourceVerify(SourceVerifyArgs),
 }
 
 #[derive(Args)]
-pub(crate) struct AddressArg {
+pub(crate) struct ResourcesArgs {
     /// Account address (`0x...`).
     #[arg(value_name = "ADDRESS")]
     pub(crate) address: String,
     /// Read from a historical ledger version.
     #[arg(long)]
     pub(crate) ledger_version: Option<u64>,
+    /// Keep resources whose type matches this pattern, where `*` matches
+    /// anything (e.g. `0x1::coin::CoinStore<*>` or `*::vesting::*`).
+    /// Repeat to keep matches of any pattern.
+    #[arg(long = "type", value_name = "PATTERN")]
+    pub(crate) types: Vec<String>,
+    /// Print only the number of matching resources.
+    #[arg(long, default_value_t = false)]
+    pub(crate) count: bool,
+    /// Start from a cursor printed by an earlier call (the node's
+    /// `X-Aptos-Cursor`).
+    #[arg(long, value_name = "CURSOR")]
+    pub(crate) cursor: Option<String>,
+    /// Follow the cursor through every page and print one array.
+    #[arg(long, default_value_t = false)]
+    pub(crate) all: bool,
+    /// Print resource counts and struct names per declaring module instead
+    /// of the resources.
+    #[arg(long, default_value_t = false, conflicts_with = "count")]
+    pub(crate) summary: bool,
+    /// With `--summary`, print an aligned module/count table.
+    #[arg(long, default_value_t = false, requires = "summary")]
+    pub(crate) pretty: bool,
 }
 
 #[derive(Args)]
@@ -75,6 +251,43 @@ pub(crate) struct ResourceArgs {
     pub(crate) ledger_version: Option<u64>,
 }
 
+#[derive(Args)]
+pub(crate) struct ModulesArgs {
+    /// Account address (`0x...`).
+    #[arg(value_name = "ADDRESS")]
+    pub(crate) address: String,
+    /// Read from a historical ledger version.
+    #[arg(long)]
+    pub(crate) ledger_version: Option<u64>,
+    /// Keep modules whose name matches this regular expression.
+    #[arg(long, value_name = "REGEX")]
+    pub(crate) filter: Option<String>,
+    /// Drop modules with no exposed functions.
+    #[arg(long, default_value_t = false)]
+    pub(crate) exposed_only: bool,
+    /// Order by name, exposed function count, or bytecode size (largest
+    /// first). Node order when omitted.
+    #[arg(long, value_enum, value_name = "KEY")]
+    pub(crate) sort: Option<ModuleSort>,
+    /// Print module names only, by name unless `--sort` says otherwise.
+    #[arg(long, alias = "names-only", default_value_t = false)]
+    pub(crate) names: bool,
+    /// Print each module's name with its entry and view function counts.
+    #[arg(long, default_value_t = false, conflicts_with = "names")]
+    pub(crate) with_functions: bool,
+    /// Read the single page at a cursor printed by an earlier `--cursor`
+    /// call instead of every page.
+    #[arg(long, value_name = "CURSOR")]
+    pub(crate) cursor: Option<String>,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, ValueEnum)]
+pub(crate) enum ModuleSort {
+    Name,
+    Functions,
+    Size,
+}
+
 #[derive(Args)]
 pub(crate) struct ModuleArgs {
     /// Account address (`0x...`).
@@ -92,6 +305,47 @@ pub(crate) struct ModuleArgs {
     /// Print only bytecode from module response.
     #[arg(long)]
     pub(crate) bytecode: bool,
+    /// Print the bytecode disassembled by an external tool (default
+    /// `aptos move disassemble`). Results are cached per module and ledger
+    /// version under `$XDG_CACHE_HOME/aptly/disassembly`.
+    #[arg(long, conflicts_with_all = ["abi", "bytecode"])]
+    pub(crate) disassemble: bool,
+    /// Disassembler command, overriding `command` in
+    /// `$XDG_CONFIG_HOME/aptly/disassembler.json`. `{bytecode}` is replaced by
+    /// the `.mv` file (appended if absent) and `{out_dir}` by a directory
+    /// whose single output file is printed instead of stdout.
+    #[arg(long, value_name = "COMMAND", requires = "disassemble")]
+    pub(crate) disassembler: Option<String>,
+    /// Write the raw module bytecode (`.mv`) to FILE.
+    #[arg(long, value_name = "FILE")]
+    pub(crate) save_bytecode: Option<PathBuf>,
+    /// Generate typed payload builders for the module's entry and view functions.
+    #[arg(long, value_enum, value_name = "LANG", conflicts_with_all = ["abi", "bytecode", "disassemble"], requires = "out")]
+    pub(crate) gen: Option<BindingLanguage>,
+    /// Directory for generated bindings (written as `<module>.go` or `<module>.ts`).
+    #[arg(long, value_name = "DIR", requires = "gen")]
+    pub(crate) out: Option<PathBuf>,
+    /// List the module's entry and view functions with their type parameters
+    /// and parameters; the leading `&signer` of entry functions is omitted.
+    #[arg(long, conflicts_with_all = ["abi", "bytecode", "disassemble", "gen"])]
+    pub(crate) functions: bool,
+    /// With `--functions`, list only entry functions.
+    #[arg(long, requires = "functions", conflicts_with = "view_only")]
+    pub(crate) entry_only: bool,
+    /// With `--functions`, list only view functions.
+    #[arg(long, requires = "functions")]
+    pub(crate) view_only: bool,
+    /// List the module's structs with their abilities, type parameters and
+    /// fields.
+    #[arg(long, conflicts_with_all = ["abi", "bytecode", "disassemble", "gen", "functions"])]
+    pub(crate) structs: bool,
+    /// With `--structs`, show only the struct with this name.
+    #[arg(long = "struct", value_name = "NAME", requires = "structs")]
+    pub(crate) struct_name: Option<String>,
+    /// With `--functions`, print one signature per line instead of JSON;
+    /// with `--structs`, print Move-like declarations.
+    #[arg(long, default_value_t = false)]
+    pub(crate) pretty: bool,
 }
 
 #[derive(Args)]
@@ -102,6 +356,62 @@ pub(crate) struct BalanceArgs {
     /// Optional asset type tag; defaults to AptosCoin.
     #[arg(value_name = "ASSET_TYPE")]
     pub(crate) asset_type: Option<String>,
+    /// Comma-separated coin types and fungible asset metadata addresses to
+    /// read at once, all at the same ledger version.
+    #[arg(
+        long,
+        value_name = "ASSET,...",
+        value_delimiter = ',',
+        conflicts_with_all = ["asset_type", "at_version", "pretty", "decorate"]
+    )]
+    pub(crate) assets: Vec<String>,
+    /// Read from a historical ledger version.
+    #[arg(long)]
+    pub(crate) ledger_version: Option<u64>,
+    /// Read the balance from account state at this version instead, for
+    /// coin types as `CoinStore` plus the paired fungible asset's primary
+    /// store, and for fungible asset metadata addresses as the primary
+    /// store.
+    #[arg(long, value_name = "VERSION", conflicts_with = "ledger_version")]
+    pub(crate) at_version: Option<u64>,
+    /// Print the amount with the asset's symbol and decimals, e.g.
+    /// `123.4567 APT (12345670000 octas)`.
+    #[arg(long, default_value_t = false, conflicts_with = "decorate")]
+    pub(crate) pretty: bool,
+    /// Wrap the raw amount in an object with `symbol`, `decimals` and
+    /// `formatted` fields.
+    #[arg(long, default_value_t = false)]
+    pub(crate) decorate: bool,
+}
+
+#[derive(Args)]
+pub(crate) struct BalancesArgs {
+    /// Account address (`0x...`).
+    #[arg(value_name = "ADDRESS")]
+    pub(crate) address: String,
+    /// Hide balances below this decimal amount, e.g. `0.01`, compared
+    /// exactly against the on-chain amount.
+    #[arg(long, value_name = "AMOUNT", value_parser = parse_min_amount)]
+    pub(crate) min_value: Option<MinAmount>,
+    /// Print an aligned table sorted by symbol instead of JSON.
+    #[arg(long, default_value_t = false)]
+    pub(crate) pretty: bool,
+    /// With `--pretty`, show at most N decimal places, truncating the rest.
+    #[arg(long, value_name = "N")]
+    pub(crate) precision: Option<u8>,
+    /// Read from a historical ledger version.
+    #[arg(long)]
+    pub(crate) ledger_version: Option<u64>,
+}
+
+#[derive(Args)]
+pub(crate) struct CoinsArgs {
+    /// Account address (`0x...`).
+    #[arg(value_name = "ADDRESS")]
+    pub(crate) address: String,
+    /// Keep stores with a zero balance.
+    #[arg(long, default_value_t = false)]
+    pub(crate) include_zero: bool,
     /// Read from a historical ledger version.
     #[arg(long)]
     pub(crate) ledger_version: Option<u64>,
@@ -112,12 +422,154 @@ pub(crate) struct TxsArgs {
     /// Account address (`0x...`).
     #[arg(value_name = "ADDRESS")]
     pub(crate) address: String,
-    /// Maximum number of transactions to return.
+    /// Maximum number of transactions to return, read 100 per request.
     #[arg(long, default_value_t = 25)]
     pub(crate) limit: u64,
-    /// Start cursor (ledger version offset).
-    #[arg(long, default_value_t = 0)]
-    pub(crate) start: u64,
+    /// Read oldest first from this sequence number. Without it the
+    /// account's latest transactions are returned.
+    #[arg(long)]
+    pub(crate) start: Option<u64>,
+    /// Return every transaction instead of `--limit`.
+    #[arg(
+        long,
+        default_value_t = false,
+        conflicts_with_all = ["limit", "before_version", "after_version", "cursor", "follow", "function", "failed_only", "success_only", "vm_status"]
+    )]
+    pub(crate) all: bool,
+    /// `asc` reads oldest first from `--start`; `desc` reads newest first
+    /// from the account's latest transaction.
+    #[arg(
+        long,
+        value_enum,
+        default_value_t = TxOrder::Asc,
+        conflicts_with_all = ["before_version", "after_version", "cursor", "follow", "function", "failed_only", "success_only", "vm_status"]
+    )]
+    pub(crate) order: TxOrder,
+    /// Only transactions before this ledger version, newest first. Prints
+    /// `{transactions, next_cursor}`.
+    #[arg(
+        long,
+        value_name = "VERSION",
+        conflicts_with_all = ["start", "after_version", "cursor", "follow"]
+    )]
+    pub(crate) before_version: Option<u64>,
+    /// Only transactions after this ledger version, oldest first. Prints
+    /// `{transactions, next_cursor}`.
+    #[arg(
+        long,
+        value_name = "VERSION",
+        conflicts_with_all = ["start", "cursor", "follow"]
+    )]
+    pub(crate) after_version: Option<u64>,
+    /// Resume from a previous page's `next_cursor` (`before:<version>` or
+    /// `after:<version>`).
+    #[arg(
+        long,
+        value_parser = parse_tx_cursor,
+        conflicts_with_all = ["start", "follow"]
+    )]
+    pub(crate) cursor: Option<TxCursor>,
+    /// Print one line per transaction (version, time, outcome, entry
+    /// function, gas fee in APT) instead of JSON.
+    #[arg(long, default_value_t = false, conflicts_with = "follow")]
+    pub(crate) pretty: bool,
+    /// Only transactions calling this entry function: a full id
+    /// (`0x1::delegation_pool::add_stake`) or a pattern where `*` matches
+    /// anything (`*::add_stake`). Pages are read back from the newest
+    /// transaction, or forward from `--start`, until `--limit` matches are
+    /// found, examining at most 10,000 transactions.
+    #[arg(
+        long,
+        value_name = "FUNCTION",
+        conflicts_with_all = ["before_version", "after_version", "cursor", "follow"]
+    )]
+    pub(crate) function: Option<String>,
+    /// With `--function`, also keep transactions without an entry function
+    /// payload (scripts, multisig).
+    #[arg(long, default_value_t = false, requires = "function")]
+    pub(crate) include_scripts: bool,
+    /// Only failed transactions. Like `--function`, pages are read until
+    /// `--limit` matches are found.
+    #[arg(
+        long,
+        default_value_t = false,
+        conflicts_with_all = ["success_only", "before_version", "after_version", "cursor", "follow"]
+    )]
+    pub(crate) failed_only: bool,
+    /// Only successful transactions.
+    #[arg(
+        long,
+        default_value_t = false,
+        conflicts_with_all = ["before_version", "after_version", "cursor", "follow"]
+    )]
+    pub(crate) success_only: bool,
+    /// Only transactions whose `vm_status` contains TEXT, ignoring case and
+    /// treating `_` as a space (`OUT_OF_GAS` matches `Out of gas`).
+    #[arg(
+        long,
+        value_name = "TEXT",
+        conflicts_with_all = ["before_version", "after_version", "cursor", "follow"]
+    )]
+    pub(crate) vm_status: Option<String>,
+    #[command(flatten)]
+    pub(crate) follow: FollowArgs,
+}
+
+impl TxsArgs {
+    fn version_cursor(&self) -> Option<TxCursor> {
+        self.cursor
+            .or(self.before_version.map(TxCursor::Before))
+            .or(self.after_version.map(TxCursor::After))
+    }
+
+    /// The filters given, if any.
+    fn filter(&self) -> Option<TxFilter> {
+        let success = match (self.success_only, self.failed_only) {
+            (true, _) => Some(true),
+            (_, true) => Some(false),
+            _ => None,
+        };
+        if self.function.is_none() && success.is_none() && self.vm_status.is_none() {
+            return None;
+        }
+        Some(TxFilter {
+            function: self.function.as_deref().map(normalize_qualified_name),
+            include_scripts: self.include_scripts,
+            success,
+            vm_status: self.vm_status.as_deref().map(vm_status_key),
+        })
+    }
+}
+
+/// `account txs` filters; a transaction must pass every one given.
+#[derive(Debug, Clone, PartialEq, Eq)]
+struct TxFilter {
+    /// `--function` pattern with its leading address normalized.
+    function: Option<String>,
+    include_scripts: bool,
+    success: Option<bool>,
+    /// `--vm-status` text as compared by [`vm_status_key`].
+    vm_status: Option<String>,
+}
+
+impl TxFilter {
+    fn matches(&self, transaction: &Value) -> bool {
+        let function = self.function.as_deref().map_or(true, |pattern| {
+            entry_function_matches(pattern, self.include_scripts, transaction)
+        });
+        let success = self.success.map_or(true, |success| {
+            transaction.get("success").and_then(Value::as_bool) == Some(success)
+        });
+        let vm_status = self.vm_status.as_deref().map_or(true, |text| {
+            vm_status_key(&get_nested_string(transaction, &["vm_status"])).contains(text)
+        });
+        function && success && vm_status
+    }
+}
+
+/// Lowercase with `_` as a space, so `OUT_OF_GAS` finds `Out of gas`.
+fn vm_status_key(value: &str) -> String {
+    value.to_lowercase().replace('_', " ")
 }
 
 #[derive(Args)]
@@ -125,12 +577,109 @@ pub(crate) struct SendsArgs {
     /// Account address (`0x...`).
     #[arg(value_name = "ADDRESS")]
     pub(crate) address: String,
-    /// Maximum number of transactions to scan.
+    /// Maximum number of transactions to scan. Ignored with `--since`, which
+    /// scans back to the date instead.
     #[arg(long, default_value_t = 25)]
     pub(crate) limit: u64,
+    /// Keep scanning back until N transfers are found or the history runs
+    /// out, examining at most 10,000 transactions. Replaces `--limit`.
+    #[arg(long, value_name = "N", conflicts_with = "limit")]
+    pub(crate) sends: Option<u64>,
+    /// Only transfers of this asset: a coin type, a fungible asset metadata
+    /// address, or a symbol (case-insensitive). `--sends` counts matches only.
+    #[arg(long, value_name = "ASSET")]
+    pub(crate) asset: Option<String>,
+    /// Only transfers of at least this decimal amount, e.g. `100.5`,
+    /// compared exactly against the on-chain amount.
+    #[arg(long, value_name = "AMOUNT", value_parser = parse_min_amount)]
+    pub(crate) min_amount: Option<MinAmount>,
+    /// With `--min-amount`, also drop transfers whose asset decimals could
+    /// not be resolved; they are kept by default.
+    #[arg(long, default_value_t = false, requires = "min_amount")]
+    pub(crate) strict: bool,
+    /// Also report funds the account withdrew that were deposited to
+    /// another owner in the same transaction, whatever function moved them.
+    #[arg(long, default_value_t = false)]
+    pub(crate) from_events: bool,
+    /// Also report sends of transactions that aborted, marked `"failed":
+    /// true` (`✗` with `--pretty`); they are left out by default.
+    #[arg(long, default_value_t = false)]
+    pub(crate) include_failed: bool,
+    /// Look up the primary Aptos Name of each recipient and report it as
+    /// `to_name`; recipients without one keep the bare address.
+    #[arg(long, default_value_t = false)]
+    pub(crate) resolve_names: bool,
+    /// Only transactions at or after this date (`YYYY-MM-DD` or RFC 3339),
+    /// or a time ago such as `-7d` or `-12h`.
+    #[arg(long, value_name = "DATE", allow_hyphen_values = true)]
+    pub(crate) since: Option<String>,
+    /// Only transactions before this date; a bare `YYYY-MM-DD` includes
+    /// that whole day. Also takes a time ago, like `--since`.
+    #[arg(long, value_name = "DATE", allow_hyphen_values = true)]
+    pub(crate) until: Option<String>,
+    /// UTC offset for bare `--since`/`--until` dates, e.g. `+02:00`.
+    #[arg(long, value_name = "OFFSET", default_value = "UTC", value_parser = parse_utc_offset)]
+    pub(crate) tz: i64,
     /// Render human-friendly decimal amounts and symbols.
     #[arg(long, default_value_t = false)]
     pub(crate) pretty: bool,
+    /// Also append transfers to this SQLite database (created if missing).
+    #[arg(long, value_name = "FILE")]
+    pub(crate) export_sqlite: Option<PathBuf>,
+    /// Add `from_label`/`to_label` for known addresses (see `aptly labels`).
+    #[arg(long, default_value_t = false)]
+    pub(crate) labels: bool,
+    /// Print transfer count and total amount per (entry function, asset)
+    /// instead of individual transfers.
+    #[arg(long, default_value_t = false)]
+    pub(crate) group_by_function: bool,
+    /// With `--pretty`, end each line with the short `module::function`.
+    #[arg(long, default_value_t = false)]
+    pub(crate) show_function: bool,
+    /// With `--pretty`, print only the per-asset totals that follow the
+    /// transfer lines.
+    #[arg(long, default_value_t = false, requires = "pretty")]
+    pub(crate) summary_only: bool,
+    /// With `--pretty`, start each line with the block time instead of the
+    /// version.
+    #[arg(long, default_value_t = false)]
+    pub(crate) show_time: bool,
+    /// With `--pretty`, show at most N decimal places, truncating the rest.
+    #[arg(long, value_name = "N")]
+    pub(crate) precision: Option<u8>,
+    /// Print CSV instead of JSON (plain rows, or the layout of a tax tool),
+    /// or wrap the transfers with the resume point of `--start`.
+    #[arg(
+        long,
+        value_enum,
+        value_name = "FORMAT",
+        conflicts_with_all = ["pretty", "group_by_function"]
+    )]
+    pub(crate) format: Option<SendsFormat>,
+    /// Scan forward from this account sequence number, oldest first, up to
+    /// `--limit` transactions. The sequence number to resume from is
+    /// printed on stderr, or as `next_start` with `--format json-envelope`.
+    #[arg(
+        long,
+        value_name = "SEQUENCE_NUMBER",
+        conflicts_with_all = ["sends", "since", "until"]
+    )]
+    pub(crate) start: Option<u64>,
+    /// Print each transfer as one JSON line as soon as it is found, with a
+    /// summary on stderr. With `--sends`, lines come newest first.
+    #[arg(long, default_value_t = false, conflicts_with_all = ["pretty", "group_by_function", "format"])]
+    pub(crate) ndjson: bool,
+}
+
+/// Output layouts for `account sends` besides the plain JSON array.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, ValueEnum)]
+pub(crate) enum SendsFormat {
+    /// `version,from,to,amount,asset`, one row per transfer.
+    Csv,
+    /// Koinly universal format, also read by CoinTracker.
+    Koinly,
+    /// `{transfers, next_start, scanned}`, for resuming with `--start`.
+    JsonEnvelope,
 }
 
 #[derive(Args)]
@@ -150,6 +699,74 @@ pub(crate) struct SourceCodeArgs {
     /// Print raw package/module/source JSON.
     #[arg(long, default_value_t = false)]
     pub(crate) raw: bool,
+    /// Maximum decompressed size of a single module source, in bytes.
+    #[arg(long, default_value_t = DEFAULT_MAX_SOURCE_BYTES)]
+    pub(crate) max_source_bytes: u64,
+    /// Print `{sources, errors}` instead of the bare array, listing the
+    /// modules whose source failed to decode with the reason.
+    #[arg(long, default_value_t = false, conflicts_with_all = ["raw", "out_dir", "diff", "list", "deps"])]
+    pub(crate) with_errors: bool,
+    /// Write each module to `<DIR>/<package>/sources/<module>.move`, with a
+    /// `Move.toml` per package, instead of printing JSON. Existing files are
+    /// kept unless `--force` is given.
+    #[arg(long, value_name = "DIR", conflicts_with = "raw")]
+    pub(crate) out_dir: Option<PathBuf>,
+    /// Compare the published sources with the local package in DIR (modules
+    /// read from its `sources/`), print a unified diff per modified module
+    /// and a status per module, and exit non-zero when any differs.
+    /// Modules published without source metadata are listed as
+    /// unverifiable and do not fail the comparison.
+    #[arg(long, value_name = "DIR", conflicts_with_all = ["raw", "out_dir"])]
+    pub(crate) diff: Option<PathBuf>,
+    /// List packages with their upgrade info and which modules embed source,
+    /// without decoding any source.
+    #[arg(long, default_value_t = false, conflicts_with_all = ["raw", "out_dir", "diff"])]
+    pub(crate) list: bool,
+    /// With `--list`, print a compact tree instead of JSON.
+    #[arg(long, default_value_t = false, requires = "list")]
+    pub(crate) pretty: bool,
+    /// List each package's dependencies (publishing account and package
+    /// name) instead of sources.
+    #[arg(long, default_value_t = false, conflicts_with_all = ["raw", "out_dir", "diff", "list"])]
+    pub(crate) deps: bool,
+    /// With `--deps`, read each dependency's registry too and follow its
+    /// dependencies, reporting which modules have source.
+    #[arg(long, default_value_t = false, requires = "deps")]
+    pub(crate) recursive: bool,
+    /// With `--recursive`, how many levels of dependencies to follow.
+    #[arg(long, default_value_t = DEFAULT_DEPS_DEPTH, requires = "recursive")]
+    pub(crate) max_depth: usize,
+}
+
+/// `--deps` node: a package and the packages it depends on.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize)]
+struct PackageDeps {
+    account: String,
+    package: String,
+    /// Module counts, once the package's registry has been read.
+    modules: Option<usize>,
+    modules_with_source: Option<usize>,
+    deps: Vec<PackageDeps>,
+    /// Why `deps` was not expanded, if it was not.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    unexpanded: Option<String>,
+}
+
+/// `--list` entry: a published package.
+#[derive(Debug, Clone, Serialize)]
+struct PackageInventory {
+    name: String,
+    upgrade_number: u64,
+    upgrade_policy: String,
+    source_digest: String,
+    modules: Vec<ModuleInventory>,
+}
+
+#[derive(Debug, Clone, Serialize)]
+struct ModuleInventory {
+    name: String,
+    /// Whether the module was published with `--save-metadata` source.
+    has_source: bool,
 }
 
 #[derive(Debug, Clone, Serialize)]
@@ -159,6 +776,60 @@ struct ModuleSource {
     source: String,
 }
 
+/// Module whose source failed to decode; always noted on stderr, and
+/// listed in `errors` with `--with-errors`.
+#[derive(Debug, Clone, Serialize)]
+struct SourceDecodeError {
+    package: String,
+    module: String,
+    reason: String,
+}
+
+/// `--with-errors`.
+#[derive(Debug, Clone, Serialize)]
+struct SourceCodeOutput {
+    sources: Vec<ModuleSource>,
+    errors: Vec<SourceDecodeError>,
+}
+
+#[derive(Debug, Clone, Serialize)]
+struct CoinHolding {
+    coin_type: String,
+    symbol: String,
+    decimals: u8,
+    balance: String,
+    amount: String,
+    frozen: bool,
+    /// Fungible asset the coin migrates to, when `coin::paired_metadata`
+    /// has one.
+    paired_metadata: Option<String>,
+    /// Raw balance of the owner's primary store for `paired_metadata`.
+    fa_balance: Option<String>,
+    /// Raw `balance` plus `fa_balance`.
+    total: String,
+    total_amount: String,
+}
+
+/// Resources of one declaring module (`--summary`).
+#[derive(Debug, Clone, PartialEq, Eq, Serialize)]
+struct ModuleSummary {
+    /// `address::module`.
+    module: String,
+    count: usize,
+    /// Distinct struct names, sorted.
+    types: Vec<String>,
+}
+
+#[derive(Debug, Clone, Serialize)]
+struct AssetBalance {
+    /// Coin type, or fungible asset metadata address.
+    asset: String,
+    symbol: String,
+    decimals: u8,
+    raw_amount: String,
+    amount: String,
+}
+
 #[derive(Debug, Clone, Serialize)]
 struct Transfer {
     from: String,
@@ -166,48 +837,678 @@ struct Transfer {
     amount: String,
     asset: String,
     version: u64,
+    hash: String,
+    /// Block time, RFC 3339 in UTC.
+    timestamp: String,
+    function: String,
+    /// Coin type or metadata address behind `asset`.
+    #[serde(skip)]
+    asset_id: String,
+    /// On-chain integer amount behind `amount`.
+    #[serde(skip)]
+    raw_amount: String,
+    /// Decimals applied to `raw_amount`, when known.
+    #[serde(skip)]
+    decimals: Option<u8>,
+    /// Position among the transaction's sends before any filtering; with
+    /// `version` it keys the `--export-sqlite` row.
+    #[serde(skip)]
+    event_index: u64,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    from_label: Option<String>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    to_label: Option<String>,
+    /// Primary Aptos Name of `to` (`--resolve-names`).
+    #[serde(skip_serializing_if = "Option::is_none")]
+    to_name: Option<String>,
+    /// `Some(true)` for a send whose transaction aborted, reported only
+    /// with `--include-failed`.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    failed: Option<bool>,
 }
 
-#[derive(Debug, Clone, Default)]
-struct AssetMetadata {
-    symbol: String,
-    decimals: u8,
+impl Transfer {
+    /// `(version, to, raw amount, asset symbol)`, the same for a send found
+    /// in the payload and in the events. Coins and their paired fungible
+    /// asset have different ids, so the symbol stands for the asset.
+    fn movement(&self) -> (u64, String, String, String) {
+        (
+            self.version,
+            normalize_address(&self.to),
+            self.raw_amount.clone(),
+            self.asset.clone(),
+        )
+    }
+}
+
+/// `--format json-envelope`.
+#[derive(Debug, Clone, Serialize)]
+struct SendsEnvelope {
+    transfers: Vec<Transfer>,
+    /// Sequence number to pass to `--start` next; it stops advancing once
+    /// the account's history is read.
+    next_start: u64,
+    /// Transactions examined, with or without sends.
+    scanned: u64,
+}
+
+#[derive(Debug, Clone, Serialize)]
+struct FunctionTotal {
+    function: String,
+    asset: String,
+    count: u64,
+    amount: String,
+}
+
+impl OutputSchema for ModuleSource {
+    fn output_schema() -> Value {
+        object_schema(
+            "Decoded Move source for one module",
+            &[
+                ("package", string_schema("Package name")),
+                ("module", string_schema("Module name")),
+                ("source", string_schema("Move source text")),
+            ],
+        )
+    }
+}
+
+impl OutputSchema for PackageInventory {
+    fn output_schema() -> Value {
+        object_schema(
+            "Package published at the account (`--list`)",
+            &[
+                ("name", string_schema("Package name")),
+                (
+                    "upgrade_number",
+                    integer_schema("Times the package has been upgraded"),
+                ),
+                (
+                    "upgrade_policy",
+                    string_schema("`arbitrary`, `compatible` or `immutable`"),
+                ),
+                (
+                    "source_digest",
+                    string_schema("Digest of the package sources at build time"),
+                ),
+                (
+                    "modules",
+                    array_schema(
+                        "Modules of the package",
+                        object_schema(
+                            "Published module",
+                            &[
+                                ("name", string_schema("Module name")),
+                                (
+                                    "has_source",
+                                    boolean_schema(
+                                        "Whether source was published (`--save-metadata`)",
+                                    ),
+                                ),
+                            ],
+                        ),
+                    ),
+                ),
+            ],
+        )
+    }
+}
+
+impl OutputSchema for PackageDeps {
+    /// Nested dependencies refer back to this schema through
+    /// `#/$defs/PackageDeps`, which `source_code_output_schema` defines.
+    fn output_schema() -> Value {
+        with_optional_properties(
+            object_schema(
+                "Package and its dependencies (`--deps`)",
+                &[
+                    ("account", string_schema("Publishing account address")),
+                    ("package", string_schema("Package name")),
+                    (
+                        "modules",
+                        nullable(integer_schema(
+                            "Modules in the package; null when its registry was not read",
+                        )),
+                    ),
+                    (
+                        "modules_with_source",
+                        nullable(integer_schema(
+                            "Modules published with source; null when its registry was not read",
+                        )),
+                    ),
+                    (
+                        "deps",
+                        array_schema(
+                            "Dependencies in declaration order",
+                            json!({"$ref": "#/$defs/PackageDeps"}),
+                        ),
+                    ),
+                ],
+            ),
+            &[(
+                "unexpanded",
+                string_schema(
+                    "Why dependencies are not listed: `cycle`, `expanded above`, `max depth`, or why the registry could not be read",
+                ),
+            )],
+        )
+    }
+}
+
+impl OutputSchema for SourceDecodeError {
+    fn output_schema() -> Value {
+        object_schema(
+            "Module whose source metadata could not be decoded",
+            &[
+                ("package", string_schema("Package name")),
+                ("module", string_schema("Module name")),
+                ("reason", string_schema("Decode failure reason")),
+            ],
+        )
+    }
+}
+
+impl OutputSchema for SourceCodeOutput {
+    fn output_schema() -> Value {
+        object_schema(
+            "Decoded sources and the modules that failed to decode (`--with-errors`)",
+            &[
+                (
+                    "sources",
+                    array_schema("Decoded module sources", ModuleSource::output_schema()),
+                ),
+                (
+                    "errors",
+                    array_schema(
+                        "Modules whose source failed to decode",
+                        SourceDecodeError::output_schema(),
+                    ),
+                ),
+            ],
+        )
+    }
+}
+
+impl OutputSchema for CoinHolding {
+    fn output_schema() -> Value {
+        object_schema(
+            "Balance held in one legacy `0x1::coin::CoinStore`",
+            &[
+                (
+                    "coin_type",
+                    string_schema("Coin type parameter of the store"),
+                ),
+                (
+                    "symbol",
+                    string_schema("Coin symbol, or shortened type if unknown"),
+                ),
+                ("decimals", integer_schema("Coin decimals (0 if unknown)")),
+                ("balance", string_schema("Raw balance in base units")),
+                (
+                    "amount",
+                    string_schema("Decimal amount scaled by the coin's decimals"),
+                ),
+                ("frozen", boolean_schema("Whether the store is frozen")),
+                (
+                    "paired_metadata",
+                    nullable(string_schema(
+                        "Paired fungible asset metadata address; null before migration",
+                    )),
+                ),
+                (
+                    "fa_balance",
+                    nullable(string_schema(
+                        "Raw balance of the paired primary store; null without a pairing",
+                    )),
+                ),
+                (
+                    "total",
+                    string_schema("Raw CoinStore balance plus the paired primary store balance"),
+                ),
+                (
+                    "total_amount",
+                    string_schema("`total` scaled by the coin's decimals"),
+                ),
+            ],
+        )
+    }
+}
+
+impl OutputSchema for ModuleSummary {
+    fn output_schema() -> Value {
+        object_schema(
+            "Resources declared by one module",
+            &[
+                (
+                    "module",
+                    string_schema("Declaring module as `address::module`"),
+                ),
+                ("count", integer_schema("Number of resources")),
+                (
+                    "types",
+                    array_schema(
+                        "Distinct struct names, sorted",
+                        string_schema("Struct name"),
+                    ),
+                ),
+            ],
+        )
+    }
+}
+
+impl OutputSchema for AssetBalance {
+    fn output_schema() -> Value {
+        object_schema(
+            "Balance of one coin or fungible asset held by the account",
+            &[
+                (
+                    "asset",
+                    string_schema("Coin type, or fungible asset metadata address"),
+                ),
+                (
+                    "symbol",
+                    string_schema("Asset symbol, or shortened id if unknown"),
+                ),
+                ("decimals", integer_schema("Asset decimals (0 if unknown)")),
+                ("raw_amount", string_schema("Raw balance in base units")),
+                (
+                    "amount",
+                    string_schema("Decimal amount scaled by the asset's decimals"),
+                ),
+            ],
+        )
+    }
+}
+
+impl OutputSchema for Transfer {
+    fn output_schema() -> Value {
+        let schema = object_schema(
+            "Outgoing transfer found in an account transaction",
+            &[
+                ("from", string_schema("Sender address")),
+                ("to", string_schema("Recipient address")),
+                (
+                    "amount",
+                    string_schema("Decimal amount scaled by the asset's decimals"),
+                ),
+                (
+                    "asset",
+                    string_schema("Asset symbol, or shortened address if unknown"),
+                ),
+                (
+                    "version",
+                    integer_schema("Ledger version of the transaction"),
+                ),
+                ("hash", string_schema("Transaction hash")),
+                (
+                    "timestamp",
+                    string_schema("Block time of the transaction, RFC 3339 in UTC"),
+                ),
+                (
+                    "function",
+                    string_schema("Entry function of the transaction"),
+                ),
+            ],
+        );
+        with_optional_properties(
+            schema,
+            &[
+                (
+                    "from_label",
+                    string_schema("Known label of `from` (`--labels`)"),
+                ),
+                (
+                    "to_label",
+                    string_schema("Known label of `to` (`--labels`)"),
+                ),
+                (
+                    "to_name",
+                    string_schema("Primary Aptos Name of `to` (`--resolve-names`)"),
+                ),
+                (
+                    "failed",
+                    boolean_schema(
+                        "Present and true when the transaction aborted (`--include-failed`)",
+                    ),
+                ),
+            ],
+        )
+    }
+}
+
+impl OutputSchema for FunctionTotal {
+    fn output_schema() -> Value {
+        object_schema(
+            "Outgoing transfers of one asset made by one entry function",
+            &[
+                ("function", string_schema("Entry function")),
+                (
+                    "asset",
+                    string_schema("Asset symbol, or shortened address if unknown"),
+                ),
+                ("count", integer_schema("Number of transfers")),
+                (
+                    "amount",
+                    string_schema("Total decimal amount scaled by the asset's decimals"),
+                ),
+            ],
+        )
+    }
+}
+
+pub(crate) fn sends_output_schema() -> Value {
+    json!({
+        "oneOf": [
+            array_schema(
+                "Outgoing transfers; `--pretty` prints a text table instead",
+                Transfer::output_schema(),
+            ),
+            array_schema(
+                "Totals per entry function and asset (`--group-by-function`)",
+                FunctionTotal::output_schema(),
+            ),
+            object_schema(
+                "Transfers with the point to resume from (`--format json-envelope`)",
+                &[
+                    (
+                        "transfers",
+                        array_schema("Outgoing transfers", Transfer::output_schema()),
+                    ),
+                    (
+                        "next_start",
+                        integer_schema("Account sequence number to pass to `--start` next"),
+                    ),
+                    (
+                        "scanned",
+                        integer_schema("Transactions examined, with or without sends"),
+                    ),
+                ],
+            ),
+        ],
+    })
+}
+
+pub(crate) fn receives_output_schema() -> Value {
+    array_schema(
+        "Incoming transfers to the account, newest first",
+        Transfer::output_schema(),
+    )
+}
+
+pub(crate) fn coins_output_schema() -> Value {
+    array_schema(
+        "CoinStore balances sorted by decimal amount, descending",
+        CoinHolding::output_schema(),
+    )
+}
+
+pub(crate) fn resources_summary_output_schema() -> Value {
+    array_schema(
+        "Resources grouped by declaring module, most first (`--summary`)",
+        ModuleSummary::output_schema(),
+    )
+}
+
+pub(crate) fn balances_output_schema() -> Value {
+    array_schema(
+        "Non-zero coin and fungible asset balances sorted by symbol",
+        AssetBalance::output_schema(),
+    )
+}
+
+pub(crate) fn balance_output_schema() -> Value {
+    let decorated = with_optional_properties(
+        object_schema(
+            "Raw balance with the asset's metadata when it resolves (`--decorate`) and the version read (`--at-version`)",
+            &[("balance", string_schema("Amount in base units"))],
+        ),
+        &[
+            ("symbol", string_schema("Asset symbol")),
+            ("decimals", integer_schema("Asset decimals")),
+            ("formatted", string_schema("Amount in whole units")),
+            (
+                "ledger_version",
+                integer_schema("Version the balance was read at (`--at-version`)"),
+            ),
+        ],
+    );
+    json!({
+        "oneOf": [
+            {
+                "description": "Raw node balance response (u64 amount in base units)",
+                "type": ["integer", "string"],
+            },
+            decorated.clone(),
+            object_schema(
+                "Balances keyed by asset, read at one ledger version (`--assets`)",
+                &[
+                    (
+                        "ledger_version",
+                        integer_schema("Version every balance was read at"),
+                    ),
+                    (
+                        "balances",
+                        json!({
+                            "type": "object",
+                            "additionalProperties": {
+                                "oneOf": [
+                                    decorated,
+                                    object_schema(
+                                        "Failed lookup",
+                                        &[(
+                                            "error",
+                                            string_schema("Why the balance could not be read"),
+                                        )],
+                                    ),
+                                ],
+                            },
+                        }),
+                    ),
+                ],
+            ),
+        ],
+    })
+}
+
+pub(crate) fn source_code_output_schema() -> Value {
+    json!({
+        "$defs": { "PackageDeps": PackageDeps::output_schema() },
+        "oneOf": [
+            array_schema(
+                "Published module sources; `--raw` prints a single module's source as plain text, `--out-dir` writes files and `--diff` prints a text comparison instead",
+                ModuleSource::output_schema(),
+            ),
+            SourceCodeOutput::output_schema(),
+            array_schema(
+                "Package inventory (`--list`)",
+                PackageInventory::output_schema(),
+            ),
+            array_schema(
+                "Dependency trees of the account's packages (`--deps`)",
+                PackageDeps::output_schema(),
+            ),
+        ],
+    })
+}
+
+impl LabelAddresses for Transfer {
+    fn apply_labels(&mut self, labels: &AddressLabels) {
+        self.from_label = labels.get(&self.from).map(str::to_owned);
+        self.to_label = labels.get(&self.to).map(str::to_owned);
+    }
+}
+
+impl CanonicalAddresses for Transfer {
+    fn canonicalize_addresses(&mut self) {
+        self.from = normalize_address(&self.from);
+        self.to = normalize_address(&self.to);
+    }
 }
 
-pub(crate) fn run_account(client: &AptosClient, command: AccountCommand) -> Result<()> {
+pub(crate) fn run_account(
+    client: &AptosClient,
+    command: AccountCommand,
+    canonical_addresses: bool,
+) -> Result<()> {
     match (command.command, command.address) {
         (Some(AccountSubcommand::Resources(args)), _) => {
-            let path = with_optional_ledger_version(
+            let patterns: Vec<String> = args
+                .types
+                .iter()
+                .map(|pattern| normalize_qualified_name(pattern))
+                .collect();
+            check_ledger_version(client, args.ledger_version)?;
+            // Filters read everything, so a match is never cut off by a page.
+            let all = args.all || !patterns.is_empty() || args.count || args.summary;
+            let (resources, next) = account_pages(
+                client,
                 &format!("/accounts/{}/resources", args.address),
                 args.ledger_version,
-            );
-            let value = client.get_json(&path)?;
-            crate::print_pretty_json(&value)
+                args.cursor.clone(),
+                all,
+            )?;
+            if let Some(next) = next {
+                eprintln!("more resources follow; continue with --cursor {next} or read them all with --all");
+            }
+            let resources: Vec<Value> = resources
+                .into_iter()
+                .filter(|resource| resource_type_matches(&patterns, resource))
+                .collect();
+            if args.count {
+                return crate::print_serialized(&resources.len());
+            }
+            if args.summary {
+                let summary = summarize_resources(&resources);
+                if args.pretty {
+                    for line in pretty_module_summary_lines(&summary) {
+                        println!("{line}");
+                    }
+                    return Ok(());
+                }
+                return crate::print_serialized(&summary);
+            }
+            crate::print_serialized(&resources)
         }
         (Some(AccountSubcommand::Resource(args)), _) => {
             let encoded = urlencoding::encode(&args.resource_type);
-            let path = with_optional_ledger_version(
-                &format!("/accounts/{}/resource/{encoded}", args.address),
-                args.ledger_version,
-            );
-            let value = client.get_json(&path)?;
+            let path = format!("/accounts/{}/resource/{encoded}", args.address);
+            let value = get_json_at_version(client, &path, args.ledger_version)?;
             crate::print_pretty_json(&value)
         }
+        (Some(AccountSubcommand::ResourceHistory(args)), _) => run_resource_history(client, &args),
         (Some(AccountSubcommand::Modules(args)), _) => {
-            let path = with_optional_ledger_version(
-                &format!("/accounts/{}/modules", args.address),
-                args.ledger_version,
-            );
-            let value = client.get_json(&path)?;
-            crate::print_pretty_json(&value)
+            let modules = select_modules(list_modules(client, &args)?, &args)?;
+            if args.names {
+                let names: Vec<&str> = modules.iter().map(|module| module_name(module)).collect();
+                return crate::print_serialized(&names);
+            }
+            if args.with_functions {
+                let counts: Vec<ModuleFunctions> =
+                    modules.iter().map(ModuleFunctions::of).collect();
+                return crate::print_serialized(&counts);
+            }
+            crate::print_pretty_json(&Value::Array(modules))
         }
         (Some(AccountSubcommand::Module(args)), _) => {
+            // Disassembly is cached per ledger version, so pin the read to one.
+            let ledger_version = match args.ledger_version.or(client.pinned_ledger_version()) {
+                None if args.disassemble => Some(
+                    parse_u64(
+                        client
+                            .get_json("/")?
+                            .get("ledger_version")
+                            .unwrap_or(&Value::Null),
+                    )
+                    .ok_or_else(|| {
+                        anyhow!("failed to parse `ledger_version` from ledger response")
+                    })?,
+                ),
+                version => version,
+            };
             let path = with_optional_ledger_version(
                 &format!("/accounts/{}/module/{}", args.address, args.module_name),
-                args.ledger_version,
+                ledger_version,
             );
+            if args.pretty && !args.functions && !args.structs {
+                return Err(anyhow!("--pretty requires --functions or --structs"));
+            }
             let value = client.get_json(&path)?;
 
+            if let Some(file) = &args.save_bytecode {
+                std::fs::write(file, module_bytecode(&value)?)
+                    .with_context(|| format!("failed to write {}", file.display()))?;
+                eprintln!("wrote {}", file.display());
+                if !args.abi
+                    && !args.bytecode
+                    && !args.disassemble
+                    && args.gen.is_none()
+                    && !args.functions
+                    && !args.structs
+                {
+                    return Ok(());
+                }
+            }
+
+            if let (true, Some(ledger_version)) = (args.disassemble, ledger_version) {
+                let disassembly = Disassembler::resolve(args.disassembler.as_deref())?
+                    .disassemble_cached(
+                        &args.address,
+                        &args.module_name,
+                        ledger_version,
+                        &module_bytecode(&value)?,
+                    )?;
+                print!("{disassembly}");
+                if !disassembly.ends_with('\n') {
+                    println!();
+                }
+                return Ok(());
+            }
+
+            if let (Some(language), Some(out)) = (args.gen, &args.out) {
+                let abi = value
+                    .get("abi")
+                    .ok_or_else(|| anyhow!("module response has no ABI"))?;
+                let written = bindings::write_bindings(abi, language, out)?;
+                eprintln!("wrote {}", written.display());
+                return Ok(());
+            }
+
+            if args.functions {
+                let functions: Vec<FunctionSignature> = FunctionSignature::list(&value)
+                    .into_iter()
+                    .filter(|function| !args.entry_only || function.is_entry)
+                    .filter(|function| !args.view_only || function.is_view)
+                    .collect();
+                if args.pretty {
+                    for function in &functions {
+                        println!("{}", function.signature());
+                    }
+                    return Ok(());
+                }
+                return crate::print_serialized(&functions);
+            }
+
+            if args.structs {
+                let structs = StructLayout::list(&value, args.struct_name.as_deref())?;
+                if args.pretty {
+                    let module_id = format!(
+                        "{}::{}",
+                        get_nested_string(&value, &["abi", "address"]),
+                        get_nested_string(&value, &["abi", "name"])
+                    );
+                    for (index, layout) in structs.iter().enumerate() {
+                        if index > 0 {
+                            println!();
+                        }
+                        for line in layout.declaration(&module_id)? {
+                            println!("{line}");
+                        }
+                    }
+                    return Ok(());
+                }
+                return crate::print_serialized(&structs);
+            }
+
             if !args.abi && !args.bytecode {
                 return crate::print_pretty_json(&value);
             }
@@ -220,32 +1521,155 @@ pub(crate) fn run_account(client: &AptosClient, command: AccountCommand) -> Resu
             let bytecode = value.get("bytecode").cloned().unwrap_or(Value::Null);
             crate::print_pretty_json(&bytecode)
         }
+        (Some(AccountSubcommand::Balance(args)), _) if !args.assets.is_empty() => {
+            run_account_asset_balances(client, &args)
+        }
         (Some(AccountSubcommand::Balance(args)), _) => {
             let asset_type = args
                 .asset_type
                 .unwrap_or_else(|| "0x1::aptos_coin::AptosCoin".to_owned());
+            if let Some(version) = args.at_version {
+                let raw =
+                    balance_at_version(client, &args.address, &asset_type, version)?.to_string();
+                if args.pretty {
+                    let metadata = assets::resolver().lookup(client, &asset_type);
+                    println!(
+                        "{} at version {version}",
+                        pretty_balance(&raw, &asset_type, &metadata)
+                    );
+                    return Ok(());
+                }
+                let mut value = if args.decorate {
+                    decorated_balance(&raw, &assets::resolver().lookup(client, &asset_type))
+                } else {
+                    json!({"balance": raw})
+                };
+                value["ledger_version"] = json!(version);
+                return crate::print_pretty_json(&value);
+            }
             let encoded = urlencoding::encode(&asset_type);
-            let path = with_optional_ledger_version(
-                &format!("/accounts/{}/balance/{encoded}", args.address),
-                args.ledger_version,
-            );
-            let value = client.get_json(&path)?;
-            crate::print_pretty_json(&value)
+            let path = format!("/accounts/{}/balance/{encoded}", args.address);
+            let value = get_json_at_version(client, &path, args.ledger_version)?;
+            if !args.pretty && !args.decorate {
+                return crate::print_pretty_json(&value);
+            }
+            let raw = parse_u64(&value)
+                .ok_or_else(|| anyhow!("unexpected balance response: {value}"))?
+                .to_string();
+            let metadata = assets::resolver().lookup(client, &asset_type);
+            if args.pretty {
+                println!("{}", pretty_balance(&raw, &asset_type, &metadata));
+                Ok(())
+            } else {
+                crate::print_pretty_json(&decorated_balance(&raw, &metadata))
+            }
+        }
+        (Some(AccountSubcommand::Balances(args)), _) => {
+            let balances = list_balances(client, &args)?;
+            if args.pretty {
+                for line in pretty_balances_lines(&balances, AmountStyle::pretty(args.precision)) {
+                    println!("{line}");
+                }
+                return Ok(());
+            }
+            crate::print_serialized(&balances)
+        }
+        (Some(AccountSubcommand::Coins(args)), _) => {
+            let mut holdings = list_coins(client, &args)?;
+            add_paired_balances(client, &args.address, &mut holdings, args.ledger_version)?;
+            crate::print_serialized(&holdings)
+        }
+        (Some(AccountSubcommand::PrimaryStore(args)), _) => {
+            run_account_primary_store(client, &args)
+        }
+        (Some(AccountSubcommand::Stake(args)), _) => run_account_stake(client, &args),
+        (Some(AccountSubcommand::Multisig(args)), _) => run_account_multisig(client, &args),
+        (Some(AccountSubcommand::Objects(args)), _) => run_account_objects(client, &args),
+        (Some(AccountSubcommand::Auth(args)), _) => run_account_auth(client, &args),
+        (Some(AccountSubcommand::KeyRotation(args)), _) => run_account_key_rotation(client, &args),
+        (Some(AccountSubcommand::Handles(args)), _) => run_account_handles(client, &args),
+        (Some(AccountSubcommand::Storage(args)), _) => run_account_storage(client, &args),
+        (Some(AccountSubcommand::Watch(args)), _) => run_account_watch(client, &args),
+        (Some(AccountSubcommand::Txs(args)), _) if args.follow.follow => {
+            let start = follow_start(client, &args.address, args.start)?;
+            let source = FollowSource::AccountTransactions {
+                address: args.address.clone(),
+            };
+            run_follow(client, source, start, args.limit, &args.follow)
+        }
+        (Some(AccountSubcommand::Txs(args)), _) if args.version_cursor().is_some() => {
+            let cursor = args.version_cursor().expect("checked above");
+            run_account_txs_page(client, &args.address, cursor, args.limit, args.pretty)
+        }
+        (Some(AccountSubcommand::Txs(args)), _) if args.filter().is_some() => {
+            let filter = args.filter().expect("checked above");
+            let matches = |transaction: &Value| filter.matches(transaction);
+            let fetch_page = |start: u64, limit: u64| {
+                account_transactions_page(client, &args.address, Some(start), limit)
+            };
+            let scan = match args.start {
+                Some(start) => matching_transactions(start, args.limit, matches, fetch_page)?,
+                None => latest_matching_transactions(
+                    account_sequence_number(client, &args.address)?,
+                    args.limit,
+                    matches,
+                    fetch_page,
+                )?,
+            };
+            if scan.capped {
+                let resume = match args.start {
+                    Some(_) => format!("resume with --start {}", scan.next_start),
+                    None => format!(
+                        "transactions before sequence number {} were not examined",
+                        scan.next_start
+                    ),
+                };
+                eprintln!(
+                    "note: found {} of {} matching transactions; stopped at the {SENDS_SCAN_CAP} transaction cap ({resume})",
+                    scan.transactions.len(),
+                    args.limit,
+                );
+            }
+            if args.pretty {
+                for line in pretty_tx_lines(&scan.transactions) {
+                    println!("{line}");
+                }
+                return Ok(());
+            }
+            crate::print_serialized(&scan.transactions)
         }
         (Some(AccountSubcommand::Txs(args)), _) => {
-            let mut path = format!(
-                "/accounts/{}/transactions?limit={}",
-                args.address, args.limit
-            );
-            if args.start > 0 {
-                path.push_str(&format!("&start={}", args.start));
+            if args.order == TxOrder::Desc && args.start.is_some() {
+                return Err(anyhow!("--start applies to --order asc only"));
             }
-            let value = client.get_json(&path)?;
-            crate::print_pretty_json(&value)
+            let limit = (!args.all).then_some(args.limit);
+            let transactions =
+                account_transactions(client, &args.address, args.start, limit, args.order)?;
+            if args.pretty {
+                for line in pretty_tx_lines(&transactions) {
+                    println!("{line}");
+                }
+                return Ok(());
+            }
+            crate::print_serialized(&transactions)
         }
-        (Some(AccountSubcommand::Sends(args)), _) => run_account_sends(client, &args),
+        (Some(AccountSubcommand::Sends(args)), _) => {
+            run_account_sends(client, &args, canonical_addresses)
+        }
+        (Some(AccountSubcommand::Receives(args)), _) => {
+            run_account_receives(client, &args, canonical_addresses)
+        }
+        (Some(AccountSubcommand::Gas(args)), _) => run_account_gas(client, &args),
+        (Some(AccountSubcommand::GasProfile(args)), _) => run_gas_profile(client, &args),
         (Some(AccountSubcommand::SourceCode(args)), _) => run_account_source_code(client, &args),
+        (Some(AccountSubcommand::SourceVerify(args)), _) => run_source_verify(client, &args),
         (None, Some(address)) => {
+            if command.pretty {
+                for line in pretty_account_lines(&account_summary(client, &address)?) {
+                    println!("{line}");
+                }
+                return Ok(());
+            }
             let value = client.get_json(&format!("/accounts/{address}"))?;
             crate::print_pretty_json(&value)
         }
@@ -253,11 +1677,93 @@ pub(crate) fn run_account(client: &AptosClient, command: AccountCommand) -> Resu
     }
 }
 
-fn run_account_source_code(client: &AptosClient, args: &SourceCodeArgs) -> Result<()> {
+/// Reads the account, then its APT balance and resource and module counts
+/// concurrently; those three are best effort.
+fn account_summary(client: &AptosClient, address: &str) -> Result<AccountSummary> {
+    let count = |kind: &str| {
+        account_pages(
+            client,
+            &format!("/accounts/{address}/{kind}"),
+            None,
+            None,
+            true,
+        )
+        .ok()
+        .map(|(items, _)| items.len())
+    };
+    let balance = || {
+        let path = format!(
+            "/accounts/{address}/balance/{}",
+            urlencoding::encode(APTOS_COIN_TYPE)
+        );
+        let value = client.get_json(&path).ok()?;
+        Some(format_amount(&value_to_string(&value), 8))
+    };
+    let (account, apt_balance, resources, modules) = thread::scope(|scope| {
+        let apt_balance = scope.spawn(balance);
+        let resources = scope.spawn(|| count("resources"));
+        let modules = scope.spawn(|| count("modules"));
+        let account = client.get_json(&format!("/accounts/{address}"));
+        (
+            account,
+            apt_balance.join().expect("balance thread panicked"),
+            resources.join().expect("resources thread panicked"),
+            modules.join().expect("modules thread panicked"),
+        )
+    });
+    let account = account?;
+    Ok(AccountSummary {
+        address: address.to_owned(),
+        sequence_number: get_nested_string(&account, &["sequence_number"]),
+        authentication_key: get_nested_string(&account, &["authentication_key"]),
+        apt_balance,
+        resources,
+        modules,
+    })
+}
+
+fn pretty_account_lines(summary: &AccountSummary) -> Vec<String> {
+    let rotated =
+        normalize_address(&summary.authentication_key) != normalize_address(&summary.address);
+    let unknown = |value: Option<String>| value.unwrap_or_else(|| "unknown".to_owned());
+    vec![
+        format!("address          {}", summary.address),
+        format!("sequence number  {}", summary.sequence_number),
+        format!(
+            "auth key         {} ({})",
+            summary.authentication_key,
+            if rotated { "rotated" } else { "original" }
+        ),
+        format!(
+            "APT balance      {}",
+            unknown(
+                summary
+                    .apt_balance
+                    .as_ref()
+                    .map(|amount| format!("{amount} APT"))
+            )
+        ),
+        format!(
+            "resources        {}",
+            unknown(summary.resources.map(|count| count.to_string()))
+        ),
+        format!(
+            "modules          {}",
+            unknown(summary.modules.map(|count| count.to_string()))
+        ),
+    ]
+}
+
+/// The `packages` of the `0x1::code::PackageRegistry` at `address`.
+pub(crate) fn package_registry(
+    client: &AptosClient,
+    address: &str,
+    ledger_version: Option<u64>,
+) -> Result<Vec<Value>> {
     let resource_type = urlencoding::encode(PACKAGE_REGISTRY_TYPE);
     let path = with_optional_ledger_version(
-        &format!("/accounts/{}/resource/{resource_type}", args.address),
-        args.ledger_version,
+        &format!("/accounts/{address}/resource/{resource_type}"),
+        ledger_version,
     );
 
     let resource = match client.get_json(&path) {
@@ -266,26 +1772,91 @@ fn run_account_source_code(client: &AptosClient, args: &SourceCodeArgs) -> Resul
             let message = err.to_string();
             if message.contains("resource_not_found") || message.contains("status 404") {
                 return Err(anyhow!(
-                    "no code metadata found at address; use `aptly decompile address {}`",
-                    args.address
+                    "no code metadata found at address; use `aptly decompile address {address}`"
                 ));
             }
             return Err(err);
         }
     };
 
-    let package_filter = args.package_name.as_deref();
-    let module_filter = args.module_name.as_deref();
-    let packages = resource
+    resource
         .get("data")
         .and_then(|v| v.get("packages"))
         .and_then(Value::as_array)
-        .ok_or_else(|| anyhow!("failed to parse package registry resource"))?;
+        .cloned()
+        .ok_or_else(|| anyhow!("failed to parse package registry resource"))
+}
+
+fn run_account_source_code(client: &AptosClient, args: &SourceCodeArgs) -> Result<()> {
+    let packages = package_registry(client, &args.address, args.ledger_version)?;
+    let package_filter = args.package_name.as_deref();
+    let module_filter = args.module_name.as_deref();
+
+    if args.deps {
+        let selected: Vec<&Value> = packages
+            .iter()
+            .filter(|package| {
+                package_filter.map_or(true, |filter| {
+                    package.get("name").and_then(Value::as_str) == Some(filter)
+                })
+            })
+            .collect();
+        if let (Some(filter), true) = (package_filter, selected.is_empty()) {
+            return Err(anyhow!("package {filter:?} not found at {}", args.address));
+        }
+        let max_depth = if args.recursive { args.max_depth } else { 0 };
+        let mut walker = DepsWalker::new(max_depth, |account: &str| {
+            package_registry(client, account, args.ledger_version)
+        });
+        let trees: Vec<PackageDeps> = selected
+            .into_iter()
+            .map(|package| walker.tree(&args.address, package))
+            .collect();
+        return crate::print_serialized(&trees);
+    }
+
+    if args.list {
+        let inventory = package_inventory(&packages, package_filter, module_filter);
+        if inventory.is_empty() {
+            return Err(match (module_filter, package_filter) {
+                (Some(module), _) => anyhow!("module {module:?} not found"),
+                (None, Some(package)) => {
+                    anyhow!("package {package:?} not found at {}", args.address)
+                }
+                (None, None) => anyhow!("no packages published at {}", args.address),
+            });
+        }
+        if args.pretty {
+            for line in pretty_inventory_lines(&inventory) {
+                println!("{line}");
+            }
+            return Ok(());
+        }
+        return crate::print_serialized(&inventory);
+    }
+
+    if let Some(local) = &args.diff {
+        let selected: Vec<&Value> = packages
+            .iter()
+            .filter(|package| {
+                package_filter.map_or(true, |filter| {
+                    package.get("name").and_then(Value::as_str) == Some(filter)
+                })
+            })
+            .collect();
+        if let (Some(filter), true) = (package_filter, selected.is_empty()) {
+            return Err(anyhow!("package {filter:?} not found at {}", args.address));
+        }
+        let checks =
+            source_verify::diff_packages(&selected, module_filter, local, args.max_source_bytes)?;
+        return source_verify::print_source_diff(&checks, local);
+    }
 
     let mut sources = Vec::new();
+    let mut errors = Vec::new();
     let mut module_exists = false;
 
-    for package in packages {
+    for package in &packages {
         let package_name = package
             .get("name")
             .and_then(Value::as_str)
@@ -323,16 +1894,42 @@ fn run_account_source_code(client: &AptosClient, args: &SourceCodeArgs) -> Resul
                 continue;
             }
 
-            if let Ok(source) = decode_source(source_hex) {
-                sources.push(ModuleSource {
+            match decode_source(source_hex, args.max_source_bytes) {
+                Ok(source) => sources.push(ModuleSource {
                     package: package_name.clone(),
                     module: module_name,
                     source,
-                });
+                }),
+                Err(err) => errors.push(SourceDecodeError {
+                    package: package_name.clone(),
+                    module: module_name,
+                    reason: format!("{err:#}"),
+                }),
             }
         }
     }
 
+    for error in &errors {
+        eprintln!(
+            "skipped {}::{}: {}",
+            error.package, error.module, error.reason
+        );
+    }
+    if args.with_errors && !errors.is_empty() {
+        return crate::print_serialized(&SourceCodeOutput { sources, errors });
+    }
+    if sources.is_empty() && !errors.is_empty() {
+        let details: Vec<String> = errors
+            .iter()
+            .map(|err| format!("{}::{}: {}", err.package, err.module, err.reason))
+            .collect();
+        return Err(anyhow!(
+            "failed to decode source metadata ({}); use `aptly decompile address {}`",
+            details.join("; "),
+            args.address
+        ));
+    }
+
     if sources.is_empty() {
         if let Some(module_name) = module_filter {
             if module_exists {
@@ -350,6 +1947,43 @@ fn run_account_source_code(client: &AptosClient, args: &SourceCodeArgs) -> Resul
         ));
     }
 
+    if let Some(dir) = &args.out_dir {
+        let files = source_tree_files(&args.address, &packages, &sources, args.max_source_bytes)?;
+        let force = crate::output::force();
+        let files: Vec<(PathBuf, Vec<u8>)> = files
+            .into_iter()
+            .map(|(path, bytes)| (dir.join(path), bytes))
+            .collect();
+        if !force {
+            if let Some((path, _)) = files.iter().find(|(path, _)| path.exists()) {
+                return Err(anyhow!(
+                    "refusing to overwrite {}; pass --force",
+                    path.display()
+                ));
+            }
+        }
+        for (path, bytes) in &files {
+            if let Some(parent) = path.parent() {
+                std::fs::create_dir_all(parent)
+                    .with_context(|| format!("failed to create {}", parent.display()))?;
+            }
+            write_atomic(path, bytes, force)?;
+            eprintln!("wrote {}", path.display());
+        }
+        let packages: BTreeSet<&str> = sources
+            .iter()
+            .map(|source| source.package.as_str())
+            .collect();
+        println!(
+            "wrote {} files for {} modules in {} packages under {}",
+            files.len(),
+            sources.len(),
+            packages.len(),
+            dir.display()
+        );
+        return Ok(());
+    }
+
     if args.raw {
         if sources.len() != 1 {
             return Err(anyhow!(
@@ -361,255 +1995,4892 @@ fn run_account_source_code(client: &AptosClient, args: &SourceCodeArgs) -> Resul
         return Ok(());
     }
 
+    if args.with_errors {
+        return crate::print_serialized(&SourceCodeOutput { sources, errors });
+    }
     crate::print_serialized(&sources)
 }
 
-fn decode_source(hex_source: &str) -> Result<String> {
-    let trimmed = hex_source.strip_prefix("0x").unwrap_or(hex_source);
-    let gzipped = hex::decode(trimmed).context("failed to decode source hex")?;
-    let mut decoder = GzDecoder::new(gzipped.as_slice());
-    let mut output = String::new();
-    decoder
-        .read_to_string(&mut output)
-        .context("failed to decompress source")?;
-    Ok(output)
+/// Packages and modules of a `PackageRegistry`, honoring the `--package`
+/// and module filters; packages without a matching module are dropped.
+fn package_inventory(
+    packages: &[Value],
+    package_filter: Option<&str>,
+    module_filter: Option<&str>,
+) -> Vec<PackageInventory> {
+    packages
+        .iter()
+        .map(|package| PackageInventory {
+            name: get_nested_string(package, &["name"]),
+            upgrade_number: parse_u64(package.get("upgrade_number").unwrap_or(&Value::Null))
+                .unwrap_or_default(),
+            upgrade_policy: upgrade_policy_name(package).unwrap_or("unknown").to_owned(),
+            source_digest: get_nested_string(package, &["source_digest"]),
+            modules: package
+                .get("modules")
+                .and_then(Value::as_array)
+                .map(Vec::as_slice)
+                .unwrap_or_default()
+                .iter()
+                .map(|module| ModuleInventory {
+                    name: get_nested_string(module, &["name"]),
+                    has_source: !get_nested_string(module, &["source"])
+                        .trim_start_matches("0x")
+                        .is_empty(),
+                })
+                .filter(|module| module_filter.map_or(true, |filter| module.name == filter))
+                .collect(),
+        })
+        .filter(|package| package_filter.map_or(true, |filter| package.name == filter))
+        .filter(|package| module_filter.is_none() || !package.modules.is_empty())
+        .collect()
 }
 
-fn run_account_sends(client: &AptosClient, args: &SendsArgs) -> Result<()> {
-    let path = format!(
-        "/accounts/{}/transactions?limit={}",
-        args.address, args.limit
-    );
-    let txs = client.get_json(&path)?;
-    let tx_array = txs
-        .as_array()
-        .ok_or_else(|| anyhow!("unexpected transactions response format"))?;
+/// One line per package with its upgrade info, then its modules indented,
+/// marking those published without source.
+fn pretty_inventory_lines(inventory: &[PackageInventory]) -> Vec<String> {
+    let mut lines = Vec::new();
+    for package in inventory {
+        let with_source = package
+            .modules
+            .iter()
+            .filter(|module| module.has_source)
+            .count();
+        lines.push(format!(
+            "{}  upgrade {}  {}  {}/{} modules with source",
+            package.name,
+            package.upgrade_number,
+            package.upgrade_policy,
+            with_source,
+            package.modules.len()
+        ));
+        for module in &package.modules {
+            if module.has_source {
+                lines.push(format!("  {}", module.name));
+            } else {
+                lines.push(format!("  {}  (no source)", module.name));
+            }
+        }
+    }
+    lines
+}
 
-    let mut metadata_cache: HashMap<String, AssetMetadata> = HashMap::new();
-    let mut transfers = Vec::new();
+/// Follows package dependencies through each account's `PackageRegistry`,
+/// reading every registry once. A package is expanded the first time it is
+/// reached; later occurrences, cycles and packages beyond `max_depth` are
+/// listed with the reason they are not expanded.
+struct DepsWalker<F> {
+    max_depth: usize,
+    fetch: F,
+    /// Registries by normalized account, or why one could not be read.
+    registries: HashMap<String, std::result::Result<Vec<Value>, String>>,
+    expanded: HashSet<(String, String)>,
+}
 
-    for tx in tx_array {
-        if let Some(transfer) = extract_transfer(client, tx, &mut metadata_cache) {
-            transfers.push(transfer);
+impl<F: FnMut(&str) -> Result<Vec<Value>>> DepsWalker<F> {
+    fn new(max_depth: usize, fetch: F) -> Self {
+        Self {
+            max_depth,
+            fetch,
+            registries: HashMap::new(),
+            expanded: HashSet::new(),
         }
     }
 
-    if args.pretty {
-        print_pretty_sends(&transfers);
-        return Ok(());
+    /// Tree of `package`, published at `account`.
+    fn tree(&mut self, account: &str, package: &Value) -> PackageDeps {
+        let mut path = Vec::new();
+        self.node(account, package, 0, &mut path)
     }
 
-    crate::print_serialized(&transfers)
-}
+    fn node(
+        &mut self,
+        account: &str,
+        package: &Value,
+        depth: usize,
+        path: &mut Vec<(String, String)>,
+    ) -> PackageDeps {
+        let name = get_nested_string(package, &["name"]);
+        let modules = package
+            .get("modules")
+            .and_then(Value::as_array)
+            .map(Vec::as_slice)
+            .unwrap_or_default();
+        let with_source = modules
+            .iter()
+            .filter(|module| {
+                !get_nested_string(module, &["source"])
+                    .trim_start_matches("0x")
+                    .is_empty()
+            })
+            .count();
+        let mut node = PackageDeps {
+            account: account.to_owned(),
+            package: name.clone(),
+            modules: Some(modules.len()),
+            modules_with_source: Some(with_source),
+            deps: Vec::new(),
+            unexpanded: None,
+        };
+        let key = (normalize_address(account), name);
+        if !self.expanded.insert(key.clone()) {
+            node.unexpanded = Some("expanded above".to_owned());
+            return node;
+        }
+        path.push(key);
+        let deps = package
+            .get("deps")
+            .and_then(Value::as_array)
+            .map(Vec::as_slice)
+            .unwrap_or_default();
+        for dep in deps {
+            let dep_account = get_nested_string(dep, &["account"]);
+            let dep_name = get_nested_string(dep, &["package_name"]);
+            let mut child = PackageDeps {
+                account: dep_account.clone(),
+                package: dep_name.clone(),
+                modules: None,
+                modules_with_source: None,
+                deps: Vec::new(),
+                unexpanded: None,
+            };
+            let dep_key = (normalize_address(&dep_account), dep_name.clone());
+            if path.contains(&dep_key) {
+                child.unexpanded = Some("cycle".to_owned());
+            } else if depth >= self.max_depth {
+                if self.max_depth > 0 {
+                    child.unexpanded = Some("max depth".to_owned());
+                }
+            } else {
+                match self.registry(&dep_account) {
+                    Ok(packages) => {
+                        match packages
+                            .iter()
+                            .find(|package| get_nested_string(package, &["name"]) == dep_name)
+                        {
+                            Some(package) => {
+                                let package = package.clone();
+                                child = self.node(&dep_account, &package, depth + 1, path);
+                            }
+                            None => {
+                                child.unexpanded = Some(format!(
+                                    "package not found in the registry of {dep_account}"
+                                ));
+                            }
+                        }
+                    }
+                    Err(err) => child.unexpanded = Some(err),
+                }
+            }
+            node.deps.push(child);
+        }
+        path.pop();
+        node
+    }
 
-fn extract_transfer(
-    client: &AptosClient,
-    tx: &Value,
-    metadata_cache: &mut HashMap<String, AssetMetadata>,
-) -> Option<Transfer> {
-    if tx.get("type")?.as_str()? != "user_transaction" {
-        return None;
+    fn registry(&mut self, account: &str) -> std::result::Result<Vec<Value>, String> {
+        let key = normalize_address(account);
+        if !self.registries.contains_key(&key) {
+            let registry = (self.fetch)(account).map_err(|err| format!("{err:#}"));
+            self.registries.insert(key.clone(), registry);
+        }
+        self.registries[&key].clone()
     }
+}
 
-    let payload = tx.get("payload")?;
-    if payload.get("type")?.as_str()? != "entry_function_payload" {
-        return None;
+/// `upgrade_policy.policy` of a package as named in `Move.toml`.
+fn upgrade_policy_name(package: &Value) -> Option<&'static str> {
+    match package
+        .pointer("/upgrade_policy/policy")
+        .and_then(Value::as_u64)
+    {
+        Some(0) => Some("arbitrary"),
+        Some(1) => Some("compatible"),
+        Some(2) => Some("immutable"),
+        _ => None,
     }
+}
 
-    let function = payload.get("function")?.as_str()?;
-    let args = payload.get("arguments")?.as_array()?;
-    let type_args: Vec<String> = payload
-        .get("type_arguments")
-        .and_then(Value::as_array)
-        .map(|items| {
-            items
-                .iter()
-                .filter_map(|item| item.as_str().map(|s| s.to_owned()))
-                .collect()
-        })
-        .unwrap_or_default();
+/// Framework packages published at `0x1` and their directory in the
+/// `aptos-framework` repository, for generated `Move.toml` dependencies.
+const FRAMEWORK_PACKAGES: &[(&str, &str)] = &[
+    ("AptosFramework", "aptos-framework"),
+    ("AptosStdlib", "aptos-stdlib"),
+    ("AptosToken", "aptos-token"),
+    ("AptosTokenObjects", "aptos-token-objects"),
+    ("MoveStdlib", "move-stdlib"),
+];
 
-    let (to, amount_str, asset, is_fungible_asset) = match function {
-        "0x1::aptos_account::transfer_coins" | "0x1::coin::transfer" => {
-            if args.len() < 2 || type_args.is_empty() {
-                return None;
-            }
-            (
-                value_to_string(&args[0]),
-                value_to_string(&args[1]),
-                type_args[0].clone(),
-                false,
-            )
+/// Files of `--out-dir`, relative to it: per package the decoded sources, a
+/// generated `Move.toml`, and, when published, the original manifest as
+/// `Move.published.toml` and source maps under `source_maps/`.
+fn source_tree_files(
+    address: &str,
+    packages: &[Value],
+    sources: &[ModuleSource],
+    max_bytes: u64,
+) -> Result<Vec<(PathBuf, Vec<u8>)>> {
+    let mut files = Vec::new();
+    for package in packages {
+        let name = get_nested_string(package, &["name"]);
+        let package_sources: Vec<&ModuleSource> = sources
+            .iter()
+            .filter(|source| source.package == name)
+            .collect();
+        if package_sources.is_empty() {
+            continue;
         }
-        "0x1::primary_fungible_store::transfer" => {
-            if args.len() < 3 {
-                return None;
+        let dir = PathBuf::from(file_component(&name));
+        files.push((
+            dir.join("Move.toml"),
+            move_toml(address, package, &package_sources).into_bytes(),
+        ));
+        let manifest = get_nested_string(package, &["manifest"]);
+        if !manifest.trim_start_matches("0x").is_empty() {
+            files.push((
+                dir.join("Move.published.toml"),
+                decode_source(&manifest, max_bytes)
+                    .with_context(|| format!("failed to decode the manifest of {name}"))?
+                    .into_bytes(),
+            ));
+        }
+        let modules = package
+            .get("modules")
+            .and_then(Value::as_array)
+            .map(Vec::as_slice)
+            .unwrap_or_default();
+        for source in &package_sources {
+            let module = file_component(&source.module);
+            files.push((
+                dir.join("sources").join(format!("{module}.move")),
+                source.source.clone().into_bytes(),
+            ));
+            let source_map = modules
+                .iter()
+                .find(|entry| get_nested_string(entry, &["name"]) == source.module)
+                .map(|entry| get_nested_string(entry, &["source_map"]))
+                .unwrap_or_default();
+            if !source_map.trim_start_matches("0x").is_empty() {
+                files.push((
+                    dir.join("source_maps").join(format!("{module}.mvsm")),
+                    decode_blob(&source_map, max_bytes).with_context(|| {
+                        format!(
+                            "failed to decode the source map of {name}::{}",
+                            source.module
+                        )
+                    })?,
+                ));
             }
-            (
-                value_to_string(&args[1]),
-                value_to_string(&args[2]),
-                get_inner_or_string(&args[0]),
-                true,
-            )
         }
-        _ => return None,
-    };
+    }
+    Ok(files)
+}
 
-    if to.is_empty() || amount_str.is_empty() || asset.is_empty() {
-        return None;
+/// Minimal manifest that builds the extracted sources: every named address
+/// the modules are declared under maps to `address`, framework dependencies
+/// come from the `aptos-framework` repository, and packages of the same
+/// account are sibling directories.
+fn move_toml(address: &str, package: &Value, sources: &[&ModuleSource]) -> String {
+    let name = get_nested_string(package, &["name"]);
+    let mut toml = format!("[package]\nname = \"{name}\"\nversion = \"1.0.0\"\n");
+    let policy = upgrade_policy_name(package);
+    if let Some(policy) = policy {
+        toml.push_str(&format!("upgrade_policy = \"{policy}\"\n"));
     }
 
-    let metadata = get_asset_metadata(client, metadata_cache, &asset, is_fungible_asset);
-    let sender = tx
-        .get("sender")
-        .and_then(Value::as_str)
-        .unwrap_or_default()
-        .to_owned();
-    let version = parse_u64(tx.get("version").unwrap_or(&Value::Null)).unwrap_or(0);
+    let declaration = Regex::new(r"\bmodule\s+([A-Za-z_][A-Za-z0-9_]*)\s*::").expect("valid regex");
+    let named: BTreeSet<&str> = sources
+        .iter()
+        .flat_map(|source| declaration.captures_iter(&source.source))
+        .filter_map(|captures| captures.get(1))
+        .map(|name| name.as_str())
+        .collect();
+    toml.push_str("\n[addresses]\n");
+    for named_address in named {
+        toml.push_str(&format!("{named_address} = \"{address}\"\n"));
+    }
 
-    Some(Transfer {
-        from: sender,
-        to,
-        amount: format_amount(&amount_str, metadata.decimals),
-        asset: metadata.symbol,
-        version,
-    })
+    toml.push_str("\n[dependencies]\n");
+    let deps = package
+        .get("deps")
+        .and_then(Value::as_array)
+        .map(Vec::as_slice)
+        .unwrap_or_default();
+    for dep in deps {
+        let dep_name = get_nested_string(dep, &["package_name"]);
+        let account = normalize_address(&get_nested_string(dep, &["account"]));
+        let framework = FRAMEWORK_PACKAGES
+            .iter()
+            .find(|(package, _)| *package == dep_name)
+            .filter(|_| account == normalize_address("0x1"));
+        if let Some((_, subdir)) = framework {
+            toml.push_str(&format!(
+                "{dep_name} = {{ git = \"https://github.com/aptos-labs/aptos-framework.git\", rev = \"mainnet\", subdir = \"{subdir}\" }}\n"
+            ));
+        } else if account == normalize_address(address) {
+            toml.push_str(&format!(
+                "{dep_name} = {{ local = \"../{}\" }}\n",
+                file_component(&dep_name)
+            ));
+        } else {
+            toml.push_str(&format!("# {dep_name} is published at {account}\n"));
+        }
+    }
+    toml
 }
 
-fn get_asset_metadata(
-    client: &AptosClient,
-    cache: &mut HashMap<String, AssetMetadata>,
-    asset: &str,
-    is_fungible_asset: bool,
-) -> AssetMetadata {
-    if let Some(cached) = cache.get(asset) {
-        return cached.clone();
-    }
+/// Decodes a hex-encoded module source blob. Sources are normally gzip
+/// compressed, but some older packages store plain UTF-8 text. `max_bytes`
+/// caps the decompressed size so a hostile payload cannot exhaust memory.
+pub(crate) fn decode_source(hex_source: &str, max_bytes: u64) -> Result<String> {
+    String::from_utf8(decode_blob(hex_source, max_bytes)?).context("source is not valid UTF-8")
+}
 
-    let metadata = if is_fungible_asset {
-        query_fungible_asset_metadata(client, asset)
+/// Hex-decodes a package metadata blob and decompresses it when gzipped.
+fn decode_blob(hex_source: &str, max_bytes: u64) -> Result<Vec<u8>> {
+    let trimmed = hex_source
+        .strip_prefix("0x")
+        .or_else(|| hex_source.strip_prefix("0X"))
+        .unwrap_or(hex_source);
+    let bytes = hex::decode(trimmed).context("failed to decode source hex")?;
+
+    let raw = if bytes.starts_with(&GZIP_MAGIC) {
+        let mut output = Vec::new();
+        GzDecoder::new(bytes.as_slice())
+            .take(max_bytes.saturating_add(1))
+            .read_to_end(&mut output)
+            .context("failed to decompress source")?;
+        output
     } else {
-        query_coin_metadata(client, asset)
+        bytes
     };
-    cache.insert(asset.to_owned(), metadata.clone());
-    metadata
+
+    if raw.len() as u64 > max_bytes {
+        return Err(anyhow!(
+            "decoded source exceeds {max_bytes} bytes (raise --max-source-bytes)"
+        ));
+    }
+
+    Ok(raw)
 }
 
-fn query_fungible_asset_metadata(client: &AptosClient, metadata_addr: &str) -> AssetMetadata {
-    let mut metadata = AssetMetadata {
-        symbol: shorten_addr(metadata_addr),
-        decimals: 0,
+fn run_account_sends(
+    client: &AptosClient,
+    args: &SendsArgs,
+    canonical_addresses: bool,
+) -> Result<()> {
+    let range = sends_version_range(client, args)?;
+    let labels = if args.labels {
+        Some(AddressLabels::load()?)
+    } else {
+        None
     };
+    let asset_filter = args.asset.as_deref().map(AssetFilter::new);
+    let names = args.resolve_names.then(|| AnsNames::new(client));
+    let options = SendsOptions {
+        canonical_addresses,
+        labels: labels.as_ref(),
+        asset: asset_filter.as_ref(),
+        min_amount: args.min_amount.as_ref(),
+        strict: args.strict,
+        from_events: args.from_events.then_some(args.address.as_str()),
+        include_failed: args.include_failed,
+        names: names.as_ref(),
+    };
+    // With `--ndjson`, transfers already printed, in print order.
+    let mut streamed = Vec::new();
 
-    let encoded_resource = urlencoding::encode(FUNGIBLE_METADATA_TYPE);
-    let path = format!("/accounts/{metadata_addr}/resource/{encoded_resource}");
-
-    if let Ok(resource) = client.get_json(&path) {
-        let symbol = get_nested_string(&resource, &["data", "symbol"]);
-        if !symbol.is_empty() {
-            metadata.symbol = symbol;
+    // Stderr note of how far a `--sends` scan went, the transactions
+    // examined and the sequence number a later `--start` resumes from.
+    let (tx_array, scan_summary, scanned, next_start) = if let Some(wanted) = args.sends {
+        let sequence_number = account_sequence_number(client, &args.address)?;
+        let scan = scan_for_sends(
+            sequence_number,
+            range.unwrap_or_default(),
+            wanted,
+            SENDS_SCAN_CAP,
+            |start, limit| account_transactions_page(client, &args.address, Some(start), limit),
+            |tx| {
+                // Stored multisig calls are only visible through their events.
+                if !options.filters()
+                    && !args.ndjson
+                    && !args.from_events
+                    && stored_multisig_payload_address(tx).is_none()
+                {
+                    if !args.include_failed && transaction_failed(tx) {
+                        return Ok(0);
+                    }
+                    return Ok(
+                        transfer_payload(tx).map_or(0, |payload| payload.recipients.len() as u64)
+                    );
+                }
+                let transfers = sends_of(client, tx, &options);
+                let found = transfers.len() as u64;
+                if args.ndjson {
+                    // Newest first, as the scan finds them.
+                    for transfer in transfers {
+                        if streamed.len() as u64 >= wanted {
+                            break;
+                        }
+                        println!("{}", serde_json::to_string(&transfer)?);
+                        streamed.push(transfer);
+                    }
+                }
+                Ok(found)
+            },
+        )?;
+        let mut summary = format!("examined {} transactions", scan.scanned);
+        if scan.capped {
+            summary.push_str(&format!(
+                "; stopped at the {SENDS_SCAN_CAP} transaction cap"
+            ));
         }
+        (
+            scan.transactions,
+            Some(summary),
+            scan.scanned,
+            sequence_number,
+        )
+    } else if let Some(start) = args.start {
+        let (txs, next_start) = sends_from(start, args.limit, |start, limit| {
+            account_transactions_page(client, &args.address, Some(start), limit)
+        })?;
+        let scanned = txs.len() as u64;
+        (txs, None, scanned, next_start)
+    } else {
+        let txs = match range {
+            Some(range) => {
+                let sequence_number = account_sequence_number(client, &args.address)?;
+                scan_account_transactions(sequence_number, range, args.limit, |start, limit| {
+                    account_transactions_page(client, &args.address, Some(start), limit)
+                })?
+            }
+            None => account_transactions_page(client, &args.address, None, args.limit)?,
+        };
+        let next_start = txs
+            .iter()
+            .filter_map(|tx| parse_u64(tx.get("sequence_number")?))
+            .max()
+            .map_or(0, |newest| newest + 1);
+        let scanned = txs.len() as u64;
+        (txs, None, scanned, next_start)
+    };
+    let tx_array = tx_array.as_slice();
 
-        if let Some(decimals) = parse_u64(
-            resource
-                .get("data")
-                .and_then(|d| d.get("decimals"))
-                .unwrap_or(&Value::Null),
-        ) {
-            metadata.decimals = decimals as u8;
+    if args.ndjson {
+        if args.sends.is_none() {
+            warm_sends_metadata(client, tx_array);
+            for tx in tx_array {
+                for transfer in sends_of(client, tx, &options) {
+                    println!("{}", serde_json::to_string(&transfer)?);
+                    streamed.push(transfer);
+                }
+            }
         }
+        let mut summary =
+            scan_summary.unwrap_or_else(|| format!("examined {} transactions", tx_array.len()));
+        summary.push_str(&format!("; found {} sends", streamed.len()));
+        if args.start.is_some() {
+            summary.push_str(&format!("; next start {next_start}"));
+        }
+        eprintln!("{summary}");
+        if let Some(path) = &args.export_sqlite {
+            export_sends(client, path, &streamed)?;
+        }
+        return Ok(());
     }
-
-    metadata
-}
-
-fn query_coin_metadata(client: &AptosClient, coin_type: &str) -> AssetMetadata {
-    if coin_type == "0x1::aptos_coin::AptosCoin" {
-        return AssetMetadata {
-            symbol: "APT".to_owned(),
-            decimals: 8,
-        };
+    if let Some(summary) = scan_summary {
+        eprintln!("{summary}");
+    }
+    if args.start.is_some() && args.format != Some(SendsFormat::JsonEnvelope) {
+        eprintln!("examined {scanned} transactions; next start {next_start}");
     }
 
-    let mut metadata = AssetMetadata {
-        symbol: shorten_addr(coin_type),
-        decimals: 0,
-    };
-
-    let Some(issuer) = coin_type.split("::").next() else {
-        return metadata;
-    };
-    if issuer.is_empty() {
-        return metadata;
+    warm_sends_metadata(client, tx_array);
+    let mut transfers = Vec::new();
+    for tx in tx_array {
+        transfers.extend(sends_of(client, tx, &options));
+    }
+    // The oldest matching transaction may be a batch that overshoots.
+    if let Some(wanted) = args.sends {
+        let excess = transfers.len().saturating_sub(wanted as usize);
+        transfers.drain(..excess);
     }
 
-    let resource_type = format!("0x1::coin::CoinInfo<{coin_type}>");
-    let encoded_resource = urlencoding::encode(&resource_type);
-    let path = format!("/accounts/{issuer}/resource/{encoded_resource}");
+    if let Some(path) = &args.export_sqlite {
+        export_sends(client, path, &transfers)?;
+    }
 
-    if let Ok(resource) = client.get_json(&path) {
-        let symbol = get_nested_string(&resource, &["data", "symbol"]);
-        if !symbol.is_empty() {
-            metadata.symbol = symbol;
+    match args.format {
+        Some(SendsFormat::Csv) => {
+            let rows: Vec<SendRow> = transfers
+                .iter()
+                .map(|transfer| SendRow {
+                    version: transfer.version,
+                    from: &transfer.from,
+                    to: &transfer.to,
+                    amount: &transfer.amount,
+                    asset: &transfer.asset,
+                })
+                .collect();
+            print!("{}", sends_csv(&rows));
+            return Ok(());
         }
-
-        if let Some(decimals) = parse_u64(
-            resource
-                .get("data")
-                .and_then(|d| d.get("decimals"))
-                .unwrap_or(&Value::Null),
-        ) {
-            metadata.decimals = decimals as u8;
+        Some(SendsFormat::Koinly) => {
+            print!("{}", koinly_sends(tx_array, &transfers));
+            return Ok(());
         }
+        Some(SendsFormat::JsonEnvelope) => {
+            return crate::print_serialized(&SendsEnvelope {
+                transfers,
+                next_start,
+                scanned,
+            });
+        }
+        None => {}
     }
 
-    metadata
-}
-
-fn format_amount(amount: &str, decimals: u8) -> String {
-    if decimals == 0 {
-        return amount.to_owned();
+    let style = AmountStyle::pretty(args.precision);
+    if args.group_by_function {
+        let totals = group_by_function(&transfers);
+        if args.pretty {
+            print_pretty_function_totals(&totals, style);
+            return Ok(());
+        }
+        return crate::print_serialized(&totals);
     }
 
-    let Ok(raw) = BigInt::from_str(amount) else {
-        return amount.to_owned();
-    };
-
-    let divisor = BigInt::from(10u8).pow(decimals as u32);
-    let int_part = &raw / &divisor;
-    let frac_part = &raw % &divisor;
-    let mut frac_str = format!("{:0width$}", frac_part, width = decimals as usize);
-    while frac_str.ends_with('0') {
-        frac_str.pop();
+    if args.pretty {
+        print_pretty_sends(
+            &transfers,
+            args.show_function,
+            args.show_time,
+            args.summary_only,
+            style,
+        );
+        return Ok(());
     }
 
-    if frac_str.is_empty() {
-        int_part.to_string()
-    } else {
-        format!("{int_part}.{frac_str}")
-    }
+    crate::print_serialized(&transfers)
 }
 
-fn print_pretty_sends(transfers: &[Transfer]) {
-    let max_amount_len = transfers.iter().map(|t| t.amount.len()).max().unwrap_or(0);
-    let max_asset_len = transfers.iter().map(|t| t.asset.len()).max().unwrap_or(0);
+/// How `account sends` filters and rewrites each transaction's transfers.
+struct SendsOptions<'a> {
+    canonical_addresses: bool,
+    labels: Option<&'a AddressLabels>,
+    asset: Option<&'a AssetFilter>,
+    min_amount: Option<&'a MinAmount>,
+    /// Drop transfers of unknown decimals under `min_amount`.
+    strict: bool,
+    /// `--from-events`: the queried account, whose withdraw/deposit pairs
+    /// are added to the payload-detected sends.
+    from_events: Option<&'a str>,
+    /// Keep sends of aborted transactions, marked `failed`.
+    include_failed: bool,
+    /// `--resolve-names`: fills `to_name`.
+    names: Option<&'a AnsNames<'a>>,
+}
 
-    for transfer in transfers {
-        println!(
-            "[{}] {:>amount_width$} {:<asset_width$} → {}",
-            transfer.version,
-            transfer.amount,
-            transfer.asset,
-            transfer.to,
-            amount_width = max_amount_len,
-            asset_width = max_asset_len
-        );
+impl SendsOptions<'_> {
+    /// Whether some transfers may be dropped.
+    fn filters(&self) -> bool {
+        self.asset.is_some() || self.min_amount.is_some()
+    }
+
+    fn keeps(&self, transfer: &Transfer) -> bool {
+        if let Some(filter) = self.asset {
+            if !filter.matches(&transfer.asset_id, &transfer.asset) {
+                return false;
+            }
+        }
+        let Some(min_amount) = self.min_amount else {
+            return true;
+        };
+        match (transfer.decimals, BigInt::from_str(&transfer.raw_amount)) {
+            (Some(decimals), Ok(raw)) => min_amount.allows(&raw, decimals),
+            _ => !self.strict,
+        }
     }
 }
 
-fn get_inner_or_string(value: &Value) -> String {
-    if let Some(inner) = value.get("inner").and_then(Value::as_str) {
-        return inner.to_owned();
+/// Transfers of one transaction that pass `--asset`, `--min-amount` and
+/// `--include-failed`, with the `--canonical-addresses` and `--labels`
+/// rewrites applied.
+fn sends_of(client: &AptosClient, tx: &Value, options: &SendsOptions<'_>) -> Vec<Transfer> {
+    if !options.include_failed && transaction_failed(tx) {
+        return Vec::new();
+    }
+    let mut transfers = extract_transfers(client, tx);
+    if let Some(account) = options.from_events {
+        let found: HashSet<_> = transfers.iter().map(Transfer::movement).collect();
+        // Numbered after every payload send, so both kinds keep their
+        // positions whatever the filters drop.
+        let offset = transfers.len() as u64;
+        let extra: Vec<Transfer> = event_transfers(client, tx, account)
+            .into_iter()
+            .filter(|transfer| !found.contains(&transfer.movement()))
+            .map(|mut transfer| {
+                transfer.event_index += offset;
+                transfer
+            })
+            .collect();
+        transfers.extend(extra);
+    }
+    transfers.retain(|transfer| options.keeps(transfer));
+    if options.canonical_addresses {
+        transfers.canonicalize_addresses();
+    }
+    if let Some(labels) = options.labels {
+        transfers.apply_labels(labels);
+    }
+    if let Some(names) = options.names {
+        for transfer in &mut transfers {
+            transfer.to_name = names.primary_name(&transfer.to);
+        }
+    }
+    transfers
+}
+
+/// `--asset`: a coin type, a fungible asset metadata address, or a symbol.
+#[derive(Debug, Clone, PartialEq, Eq)]
+struct AssetFilter {
+    /// The value with its address in long form, compared to asset ids.
+    id: String,
+    /// The value as given, compared to symbols ignoring case.
+    symbol: String,
+}
+
+impl AssetFilter {
+    fn new(value: &str) -> Self {
+        let value = value.trim();
+        Self {
+            id: normalize_asset_id(value),
+            symbol: value.to_owned(),
+        }
+    }
+
+    /// Whether an asset with id `asset_id` (coin type or metadata address)
+    /// and resolved `symbol` is the one asked for.
+    fn matches(&self, asset_id: &str, symbol: &str) -> bool {
+        normalize_asset_id(asset_id) == self.id || symbol.eq_ignore_ascii_case(&self.symbol)
+    }
+}
+
+#[derive(Args)]
+pub(crate) struct ReceivesArgs {
+    /// Account address (`0x...`).
+    #[arg(value_name = "ADDRESS")]
+    pub(crate) address: String,
+    /// Maximum number of transfers to return, newest first.
+    #[arg(long, default_value_t = 25)]
+    pub(crate) limit: u64,
+    /// Only transfers of this asset: a coin type, a fungible asset metadata
+    /// address, or a symbol (case-insensitive).
+    #[arg(long, value_name = "ASSET")]
+    pub(crate) asset: Option<String>,
+    /// Print one aligned line per transfer and per-asset totals.
+    #[arg(long, default_value_t = false)]
+    pub(crate) pretty: bool,
+    /// With `--pretty`, show at most N decimal places, truncating the rest.
+    #[arg(long, value_name = "N")]
+    pub(crate) precision: Option<u8>,
+    /// Indexer GraphQL endpoint. Defaults to the indexer of the network
+    /// selected with `--network` (or whose default `--rpc-url` is in use).
+    #[arg(long, value_name = "URL")]
+    pub(crate) indexer_url: Option<String>,
+}
+
+/// `--min-amount`: a non-negative decimal held as `digits / 10^scale`.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub(crate) struct MinAmount {
+    digits: BigInt,
+    scale: u32,
+}
+
+impl MinAmount {
+    /// Whether `raw` base units of an asset with `decimals` are at least
+    /// this amount: `raw / 10^decimals >= digits / 10^scale`, cross-multiplied
+    /// so no precision is lost.
+    fn allows(&self, raw: &BigInt, decimals: u8) -> bool {
+        let ten = BigInt::from(10u8);
+        raw * ten.pow(self.scale) >= &self.digits * ten.pow(u32::from(decimals))
+    }
+}
+
+/// Parses `100`, `100.5` or `.25`. Used as a clap `value_parser`.
+pub(crate) fn parse_min_amount(value: &str) -> Result<MinAmount, String> {
+    let value = value.trim();
+    let (int_part, frac_part) = value.split_once('.').unwrap_or((value, ""));
+    let is_digits = |part: &str| part.bytes().all(|byte| byte.is_ascii_digit());
+    if int_part.len() + frac_part.len() == 0 || !is_digits(int_part) || !is_digits(frac_part) {
+        return Err(format!(
+            "invalid amount `{value}`; expected a non-negative decimal such as 100.5"
+        ));
+    }
+    let digits = BigInt::from_str(&format!("{int_part}{frac_part}"))
+        .map_err(|_| format!("invalid amount `{value}`"))?;
+    Ok(MinAmount {
+        digits,
+        scale: frac_part.len() as u32,
+    })
+}
+
+/// Long-form address of a metadata address, or of a coin type's leading
+/// address, so `0x1` and `0x0…01` compare equal.
+fn normalize_asset_id(value: &str) -> String {
+    if value.contains("::") {
+        normalize_qualified_name(value)
+    } else {
+        normalize_address(value)
+    }
+}
+
+/// Ledger versions `since <= version < until`; either side may be open.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq)]
+struct VersionRange {
+    since: Option<u64>,
+    until: Option<u64>,
+}
+
+/// Converts `--since`/`--until` into version bounds, or `None` when neither
+/// is given.
+fn sends_version_range(client: &AptosClient, args: &SendsArgs) -> Result<Option<VersionRange>> {
+    if args.since.is_none() && args.until.is_none() {
+        return Ok(None);
+    }
+    let now_micros = SystemTime::now()
+        .duration_since(UNIX_EPOCH)
+        .map_or(0, |elapsed| elapsed.as_micros() as u64);
+    let since = args
+        .since
+        .as_deref()
+        .map(|date| parse_time_bound(date, args.tz, false, now_micros))
+        .transpose()?;
+    let until = args
+        .until
+        .as_deref()
+        .map(|date| parse_time_bound(date, args.tz, true, now_micros))
+        .transpose()?;
+    if let (Some(since), Some(until)) = (since, until) {
+        if since >= until {
+            return Err(anyhow!("--since must be earlier than --until"));
+        }
+    }
+    Ok(Some(VersionRange {
+        since: since
+            .map(|timestamp| version_at_timestamp(client, timestamp))
+            .transpose()?,
+        until: until
+            .map(|timestamp| version_at_timestamp(client, timestamp))
+            .transpose()?,
+    }))
+}
+
+pub(crate) fn account_transactions_page(
+    client: &AptosClient,
+    address: &str,
+    start: Option<u64>,
+    limit: u64,
+) -> Result<Vec<Value>> {
+    let mut path = format!("/accounts/{address}/transactions?limit={limit}");
+    if let Some(start) = start {
+        path.push_str(&format!("&start={start}"));
+    }
+    match client.get_json(&path)? {
+        Value::Array(txs) => Ok(txs),
+        _ => Err(anyhow!("unexpected transactions response format")),
+    }
+}
+
+/// Next sequence number of `address`, the number of transactions it has
+/// sent; 0 for an account not created yet.
+pub(crate) fn account_sequence_number(client: &AptosClient, address: &str) -> Result<u64> {
+    match client.get_json(&format!("/accounts/{address}")) {
+        Ok(account) => account
+            .get("sequence_number")
+            .and_then(parse_u64)
+            .ok_or_else(|| anyhow!("failed to parse account sequence number")),
+        Err(err) if err.to_string().contains("status 404") => Ok(0),
+        Err(err) => Err(err).with_context(|| format!("failed to fetch account {address}")),
+    }
+}
+
+/// Where `account txs --follow` starts: `--start`, or the account's next
+/// sequence number so only new transactions are printed.
+fn follow_start(client: &AptosClient, address: &str, start: Option<u64>) -> Result<u64> {
+    match start {
+        Some(start) => Ok(start),
+        None => account_sequence_number(client, address),
+    }
+}
+
+/// `account txs` result with filters.
+#[derive(Debug)]
+struct TxScan {
+    transactions: Vec<Value>,
+    /// Sequence number after the last transaction examined; for a scan back
+    /// from the newest, the oldest one examined.
+    next_start: u64,
+    /// Whether the scan stopped at `SENDS_SCAN_CAP` short of `limit` matches.
+    capped: bool,
+}
+
+/// Reads transactions from sequence number `start`, oldest first, keeping
+/// those `matches` accepts until `limit` are kept, the history runs out, or
+/// `SENDS_SCAN_CAP` have been examined. `fetch_page(start, limit)` reads
+/// transactions by sequence number.
+fn matching_transactions(
+    start: u64,
+    limit: u64,
+    matches: impl Fn(&Value) -> bool,
+    mut fetch_page: impl FnMut(u64, u64) -> Result<Vec<Value>>,
+) -> Result<TxScan> {
+    let mut transactions = Vec::new();
+    let mut next_start = start;
+    while (transactions.len() as u64) < limit {
+        let scanned = next_start - start;
+        if scanned >= SENDS_SCAN_CAP {
+            return Ok(TxScan {
+                transactions,
+                next_start,
+                capped: true,
+            });
+        }
+        let page = fetch_page(
+            next_start,
+            TRANSACTIONS_PAGE_LIMIT.min(SENDS_SCAN_CAP - scanned),
+        )?;
+        if page.is_empty() {
+            break;
+        }
+        for transaction in page {
+            next_start += 1;
+            if matches(&transaction) {
+                transactions.push(transaction);
+                if transactions.len() as u64 == limit {
+                    break;
+                }
+            }
+        }
+    }
+    Ok(TxScan {
+        transactions,
+        next_start,
+        capped: false,
+    })
+}
+
+/// Without `--start`: the newest `limit` transactions `matches` accepts,
+/// oldest first, paging back from the account's `sequence_number` and
+/// examining at most `SENDS_SCAN_CAP`.
+fn latest_matching_transactions(
+    sequence_number: u64,
+    limit: u64,
+    matches: impl Fn(&Value) -> bool,
+    fetch_page: impl FnMut(u64, u64) -> Result<Vec<Value>>,
+) -> Result<TxScan> {
+    let scan = scan_for_sends(
+        sequence_number,
+        VersionRange::default(),
+        limit,
+        SENDS_SCAN_CAP,
+        fetch_page,
+        |transaction| Ok(u64::from(matches(transaction))),
+    )?;
+    Ok(TxScan {
+        transactions: scan.transactions,
+        next_start: sequence_number.saturating_sub(scan.scanned),
+        capped: scan.capped,
+    })
+}
+
+/// Whether `transaction` calls an entry function matching `pattern`, whose
+/// leading address is normalized already. Other payloads match only with
+/// `include_scripts`.
+fn entry_function_matches(pattern: &str, include_scripts: bool, transaction: &Value) -> bool {
+    if get_nested_string(transaction, &["payload", "type"]) != "entry_function_payload" {
+        return include_scripts;
+    }
+    let function = get_nested_string(transaction, &["payload", "function"]);
+    glob_matches(pattern, &normalize_qualified_name(&function))
+}
+
+/// `--start`: up to `limit` transactions from sequence number `start`,
+/// oldest first, and the sequence number after the last one read. That
+/// stays at `start` once the account has sent nothing newer.
+/// `fetch_page(start, limit)` reads transactions by sequence number.
+fn sends_from(
+    start: u64,
+    limit: u64,
+    mut fetch_page: impl FnMut(u64, u64) -> Result<Vec<Value>>,
+) -> Result<(Vec<Value>, u64)> {
+    let mut transactions = Vec::new();
+    let mut next = start;
+    while (transactions.len() as u64) < limit {
+        let wanted = (limit - transactions.len() as u64).min(TRANSACTIONS_PAGE_LIMIT);
+        let page = fetch_page(next, wanted)?;
+        if page.is_empty() {
+            break;
+        }
+        next += page.len() as u64;
+        let short = (page.len() as u64) < wanted;
+        transactions.extend(page);
+        if short {
+            break;
+        }
+    }
+    Ok((transactions, next))
+}
+
+/// Pages back from the account's newest transaction and keeps those inside
+/// `range`, oldest first. Stops at the first transaction older than
+/// `range.since`, or, without a lower bound, after `limit` matches.
+/// `fetch_page(start, limit)` reads transactions by sequence number.
+fn scan_account_transactions(
+    sequence_number: u64,
+    range: VersionRange,
+    limit: u64,
+    mut fetch_page: impl FnMut(u64, u64) -> Result<Vec<Value>>,
+) -> Result<Vec<Value>> {
+    let mut matched = Vec::new();
+    let mut end = sequence_number;
+    'pages: while end > 0 {
+        let start = end.saturating_sub(TRANSACTIONS_PAGE_LIMIT);
+        let page = fetch_page(start, end - start)?;
+        if page.is_empty() {
+            break;
+        }
+        for tx in page.into_iter().rev() {
+            let version = parse_u64(tx.get("version").unwrap_or(&Value::Null)).unwrap_or(0);
+            if range.until.is_some_and(|until| version >= until) {
+                continue;
+            }
+            if range.since.is_some_and(|since| version < since) {
+                break 'pages;
+            }
+            matched.push(tx);
+            if range.since.is_none() && matched.len() as u64 >= limit {
+                break 'pages;
+            }
+        }
+        end = start;
+    }
+    matched.reverse();
+    Ok(matched)
+}
+
+/// Most transactions `account sends --sends` examines before giving up.
+const SENDS_SCAN_CAP: u64 = 10_000;
+
+/// Transactions with sends found by [`scan_for_sends`].
+#[derive(Debug)]
+struct SendsScan {
+    /// Oldest first.
+    transactions: Vec<Value>,
+    scanned: u64,
+    /// Whether the scan stopped at `max_scanned` rather than finding
+    /// enough sends or reaching the first transaction.
+    capped: bool,
+}
+
+/// Pages back from the account's newest transaction, keeping those inside
+/// `range` that send assets, until they hold `wanted` transfers, the
+/// history runs out, the scan passes `range.since`, or `max_scanned`
+/// transactions have been examined. `count_sends` sees each transaction in
+/// `range` as soon as it is read, newest first, and returns how many of its
+/// sends count towards `wanted`; transactions with none are dropped.
+fn scan_for_sends(
+    sequence_number: u64,
+    range: VersionRange,
+    wanted: u64,
+    max_scanned: u64,
+    mut fetch_page: impl FnMut(u64, u64) -> Result<Vec<Value>>,
+    mut count_sends: impl FnMut(&Value) -> Result<u64>,
+) -> Result<SendsScan> {
+    let mut scan = SendsScan {
+        transactions: Vec::new(),
+        scanned: 0,
+        capped: false,
+    };
+    let mut found = 0;
+    let mut end = sequence_number;
+    'pages: while end > 0 && found < wanted {
+        if scan.scanned >= max_scanned {
+            scan.capped = true;
+            break;
+        }
+        let page_limit = TRANSACTIONS_PAGE_LIMIT.min(max_scanned - scan.scanned);
+        let start = end.saturating_sub(page_limit);
+        let page = fetch_page(start, end - start)?;
+        if page.is_empty() {
+            break;
+        }
+        for tx in page.into_iter().rev() {
+            scan.scanned += 1;
+            let version = parse_u64(tx.get("version").unwrap_or(&Value::Null)).unwrap_or(0);
+            if range.until.is_some_and(|until| version >= until) {
+                continue;
+            }
+            if range.since.is_some_and(|since| version < since) {
+                break 'pages;
+            }
+            let sends = count_sends(&tx)?;
+            if sends > 0 {
+                found += sends;
+                scan.transactions.push(tx);
+                if found >= wanted {
+                    break 'pages;
+                }
+            }
+        }
+        end = start;
+    }
+    scan.transactions.reverse();
+    Ok(scan)
+}
+
+/// One Koinly row per transfer. The APT gas fee of each transaction goes on
+/// the first of its rows only.
+fn koinly_sends(txs: &[Value], transfers: &[Transfer]) -> String {
+    let mut tx_info: HashMap<u64, (u64, String, String)> = HashMap::new();
+    for tx in txs {
+        let Some(version) = parse_u64(tx.get("version").unwrap_or(&Value::Null)) else {
+            continue;
+        };
+        let timestamp = parse_u64(tx.get("timestamp").unwrap_or(&Value::Null)).unwrap_or(0);
+        let gas_used = parse_u64(tx.get("gas_used").unwrap_or(&Value::Null)).unwrap_or(0);
+        let gas_unit_price =
+            parse_u64(tx.get("gas_unit_price").unwrap_or(&Value::Null)).unwrap_or(0);
+        let fee = BigInt::from(gas_used) * BigInt::from(gas_unit_price);
+        tx_info.insert(
+            version,
+            (
+                timestamp,
+                get_nested_string(tx, &["hash"]),
+                format_amount(&fee.to_string(), 8),
+            ),
+        );
+    }
+
+    let mut fee_charged: HashSet<u64> = HashSet::new();
+    let rows: Vec<KoinlyRow> = transfers
+        .iter()
+        .filter_map(|transfer| {
+            let (timestamp, hash, fee) = tx_info.get(&transfer.version)?;
+            let charge_fee = fee != "0" && fee_charged.insert(transfer.version);
+            Some(KoinlyRow {
+                timestamp_us: *timestamp,
+                sent: Some((&transfer.amount, koinly_currency(transfer))),
+                received: None,
+                fee: charge_fee.then_some((fee.as_str(), "APT")),
+                tx_hash: hash,
+            })
+        })
+        .collect();
+    koinly_csv(&rows)
+}
+
+/// The asset's symbol, or its coin type or metadata address when the symbol
+/// is unknown.
+fn koinly_currency(transfer: &Transfer) -> &str {
+    if transfer.asset.is_empty() || transfer.asset == shorten_addr(&transfer.asset_id) {
+        &transfer.asset_id
+    } else {
+        &transfer.asset
+    }
+}
+
+/// Totals transfers per (function, asset), ordered by function then asset.
+fn group_by_function(transfers: &[Transfer]) -> Vec<FunctionTotal> {
+    let mut groups: BTreeMap<(&str, &str), Vec<&str>> = BTreeMap::new();
+    for transfer in transfers {
+        groups
+            .entry((&transfer.function, &transfer.asset))
+            .or_default()
+            .push(&transfer.amount);
+    }
+    groups
+        .into_iter()
+        .map(|((function, asset), amounts)| FunctionTotal {
+            function: function.to_owned(),
+            asset: asset.to_owned(),
+            count: amounts.len() as u64,
+            amount: sum_decimal_amounts(&amounts),
+        })
+        .collect()
+}
+
+/// Exact sum of decimal strings such as `1.5` and `0.25`. Amounts that fail
+/// to parse count as zero.
+fn sum_decimal_amounts(amounts: &[&str]) -> String {
+    let scale = amounts
+        .iter()
+        .map(|amount| amount.split_once('.').map_or(0, |(_, frac)| frac.len()))
+        .max()
+        .unwrap_or(0);
+    let total = amounts
+        .iter()
+        .map(|amount| {
+            let (int_part, frac_part) = amount.split_once('.').unwrap_or((amount, ""));
+            let digits = format!("{int_part}{frac_part:0<scale$}");
+            BigInt::from_str(&digits).unwrap_or_default()
+        })
+        .fold(BigInt::default(), |sum, amount| sum + amount);
+    format_amount(&total.to_string(), scale as u8)
+}
+
+fn export_sends(client: &AptosClient, path: &Path, transfers: &[Transfer]) -> Result<()> {
+    let mut conn = sqlite_export::open(path)?;
+    let rows: Vec<TransferRow> = transfers
+        .iter()
+        .map(|transfer| TransferRow {
+            source: SOURCE_SENDS,
+            version: transfer.version,
+            event_index: transfer.event_index,
+            from: &transfer.from,
+            to: &transfer.to,
+            amount: &transfer.amount,
+            asset: &transfer.asset_id,
+        })
+        .collect();
+    let inserted = sqlite_export::insert_transfers(&mut conn, &rows)?;
+    // Already resolved while the transfers were extracted.
+    let ids: BTreeSet<&str> = transfers
+        .iter()
+        .map(|transfer| transfer.asset_id.as_str())
+        .collect();
+    sqlite_export::export_assets(&mut conn, client, &ids)?;
+    eprintln!(
+        "exported {inserted} new transfer(s) to {} ({} already present)",
+        path.display(),
+        rows.len() - inserted
+    );
+    Ok(())
+}
+
+/// The sends in `tx`: one per recipient, so a batch transfer yields a
+/// `Transfer` for each `(recipient, amount)` pair.
+fn extract_transfers(client: &AptosClient, tx: &Value) -> Vec<Transfer> {
+    let sends = match transfer_payload(tx) {
+        Some(payload) if payload.stores => store_sends(client, tx, payload),
+        Some(payload) => payload.into_sends(),
+        None => match stored_multisig_payload_address(tx) {
+            Some(multisig) => event_sends(client, tx, multisig, MULTISIG_PAYLOAD_FUNCTION),
+            None => return Vec::new(),
+        },
+    };
+    resolve_sends(client, tx, sends)
+}
+
+/// `--from-events`: everything `account` withdrew in `tx` and that was
+/// deposited to another owner, whatever function moved it.
+fn event_transfers(client: &AptosClient, tx: &Value, account: &str) -> Vec<Transfer> {
+    if tx.get("type").and_then(Value::as_str) != Some("user_transaction") {
+        return Vec::new();
+    }
+    let sends = event_sends(client, tx, account, &payload_function(tx));
+    resolve_sends(client, tx, sends)
+}
+
+/// Entry function `tx` called (inside a multisig payload too), or the
+/// payload type when there is none.
+fn payload_function(tx: &Value) -> String {
+    let payload = tx.get("payload").unwrap_or(&Value::Null);
+    [
+        get_nested_string(payload, &["function"]),
+        get_nested_string(payload, &["transaction_payload", "function"]),
+        get_nested_string(payload, &["type"]),
+    ]
+    .into_iter()
+    .find(|function| !function.is_empty())
+    .unwrap_or_default()
+}
+
+fn run_account_receives(
+    client: &AptosClient,
+    args: &ReceivesArgs,
+    canonical_addresses: bool,
+) -> Result<()> {
+    let indexer = Indexer::for_node(client, args.indexer_url.as_deref())?;
+    let mut transfers = collect_receives(client, &indexer, args)?;
+    if canonical_addresses {
+        transfers.canonicalize_addresses();
+    }
+    if args.pretty {
+        let style = AmountStyle::pretty(args.precision);
+        for line in pretty_receives_lines(&transfers, style) {
+            println!("{line}");
+        }
+        if !transfers.is_empty() {
+            println!();
+        }
+        for line in pretty_asset_totals_lines(&asset_totals(&transfers), style) {
+            println!("{line}");
+        }
+        return Ok(());
+    }
+    crate::print_serialized(&transfers)
+}
+
+/// Deposits the indexer lists fetched per page while collecting receives.
+const RECEIVES_PAGE_LIMIT: u64 = 100;
+
+/// Incoming transfers to `args.address`, newest first. The indexer finds
+/// the transactions that deposited into the account's stores; each is then
+/// read from the node and paired like `--from-events`, so the transfers
+/// carry the same fields as sends. Pages until `--limit` transfers match,
+/// examining at most `SENDS_SCAN_CAP` deposits.
+fn collect_receives(
+    client: &AptosClient,
+    indexer: &Indexer,
+    args: &ReceivesArgs,
+) -> Result<Vec<Transfer>> {
+    let owner = normalize_address(&args.address);
+    let filter = args.asset.as_deref().map(AssetFilter::new);
+    let mut transfers = Vec::new();
+    let mut seen = HashSet::new();
+    let mut offset = 0;
+    while (transfers.len() as u64) < args.limit && offset < SENDS_SCAN_CAP {
+        let versions = indexer.deposit_versions(&owner, offset, RECEIVES_PAGE_LIMIT)?;
+        if versions.is_empty() {
+            break;
+        }
+        offset += versions.len() as u64;
+        for version in versions {
+            if !seen.insert(version) {
+                continue;
+            }
+            let tx = client.get_json(&format!("/transactions/by_version/{version}"))?;
+            transfers.extend(received_transfers(client, &tx, &owner).into_iter().filter(
+                |transfer| {
+                    filter
+                        .as_ref()
+                        .is_none_or(|filter| filter.matches(&transfer.asset_id, &transfer.asset))
+                },
+            ));
+            if transfers.len() as u64 >= args.limit {
+                break;
+            }
+        }
+    }
+    transfers.truncate(args.limit as usize);
+    Ok(transfers)
+}
+
+/// Funds deposited to `owner` in `tx` from any other owner. Deposits with
+/// no matching withdraw (staking rewards, mints) come from `mint`.
+fn received_transfers(client: &AptosClient, tx: &Value, owner: &str) -> Vec<Transfer> {
+    let changes = transaction_balance_changes(client, &Transaction::new(tx.clone()));
+    let function = payload_function(tx);
+    let receives = pair_transfers(&changes)
+        .into_iter()
+        .filter(|leg| normalize_address(&leg.to) == owner)
+        .map(|leg| RawSend {
+            from: leg.from,
+            to: leg.to,
+            amount: leg.amount.to_string(),
+            asset: leg.asset,
+            function: function.clone(),
+        })
+        .collect();
+    resolve_sends(client, tx, receives)
+}
+
+/// Attaches asset metadata and the transaction's version, hash and time.
+fn resolve_sends(client: &AptosClient, tx: &Value, sends: Vec<RawSend>) -> Vec<Transfer> {
+    let version = parse_u64(tx.get("version").unwrap_or(&Value::Null)).unwrap_or(0);
+    let hash = get_nested_string(tx, &["hash"]);
+    let timestamp = format_timestamp_micros(
+        parse_u64(tx.get("timestamp").unwrap_or(&Value::Null)).unwrap_or(0),
+    );
+    let failed = transaction_failed(tx);
+
+    sends
+        .into_iter()
+        .enumerate()
+        .filter(|(_, send)| !send.to.is_empty() && !send.amount.is_empty())
+        .map(|(index, send)| {
+            let metadata = assets::resolver().lookup(client, &send.asset);
+            Transfer {
+                from: send.from,
+                to: send.to,
+                amount: format_amount(&send.amount, metadata.decimals),
+                asset: metadata.symbol.clone(),
+                version,
+                hash: hash.clone(),
+                timestamp: timestamp.clone(),
+                function: send.function,
+                asset_id: send.asset,
+                raw_amount: send.amount,
+                decimals: metadata.decimals_known.then_some(metadata.decimals),
+                event_index: index as u64,
+                from_label: None,
+                to_label: None,
+                to_name: None,
+                failed: failed.then_some(true),
+            }
+        })
+        .collect()
+}
+
+/// One send before its asset metadata is resolved.
+struct RawSend {
+    from: String,
+    to: String,
+    /// Raw amount in the asset's smallest unit.
+    amount: String,
+    asset: String,
+    function: String,
+}
+
+/// A recognized transfer entry function call.
+struct TransferPayload {
+    function: String,
+    /// Account the funds leave: the sender, or the multisig account whose
+    /// approved call the sender executes.
+    from: String,
+    /// Coin type, or fungible asset metadata address.
+    asset: String,
+    /// `(recipient, raw amount)` pairs.
+    recipients: Vec<(String, String)>,
+    /// Whether `from` and the recipients are fungible store objects rather
+    /// than accounts, with `asset` left to the stores to tell.
+    stores: bool,
+}
+
+impl TransferPayload {
+    fn into_sends(self) -> Vec<RawSend> {
+        self.recipients
+            .into_iter()
+            .map(|(to, amount)| RawSend {
+                from: self.from.clone(),
+                to,
+                amount,
+                asset: self.asset.clone(),
+                function: self.function.clone(),
+            })
+            .collect()
+    }
+}
+
+/// Owner and asset of each fungible store met by `fungible_asset::transfer`
+/// sends, by node URL and store, for the rest of the invocation.
+static STORE_OWNERS: Mutex<BTreeMap<(String, String), TransferStoreMetadata>> =
+    Mutex::new(BTreeMap::new());
+
+/// Levels of objects owning a store that are followed up to an account.
+const STORE_OWNER_DEPTH: usize = 2;
+
+/// Sends of a `fungible_asset::transfer`, with each store reported as the
+/// account owning it and the asset read from the stores.
+fn store_sends(client: &AptosClient, tx: &Value, payload: TransferPayload) -> Vec<RawSend> {
+    let version = parse_u64(tx.get("version").unwrap_or(&Value::Null)).unwrap_or(0);
+    let written: HashMap<String, TransferStoreMetadata> = extract_transfer_store_info_from_tx(tx)
+        .into_iter()
+        .map(|(store, info)| (normalize_address(&store), info))
+        .collect();
+    let resolve = |store: &str| resolve_store(client, version, &written, store);
+
+    let from = resolve(&payload.from);
+    payload
+        .into_sends()
+        .into_iter()
+        .filter_map(|mut send| {
+            let to = resolve(&send.to);
+            send.asset = [&from.asset, &to.asset]
+                .into_iter()
+                .find(|asset| !asset.is_empty())?
+                .clone();
+            send.from = from.owner.clone();
+            send.to = to.owner;
+            Some(send)
+        })
+        .collect()
+}
+
+/// The account owning `store` and the asset it holds, from the write set of
+/// the transaction when it is there and from the node at `version`
+/// otherwise. The store address stands in for an owner that cannot be
+/// traced to an account.
+fn resolve_store(
+    client: &AptosClient,
+    version: u64,
+    written: &HashMap<String, TransferStoreMetadata>,
+    store: &str,
+) -> TransferStoreMetadata {
+    let store = normalize_address(store);
+    let key = (client.base_url().to_owned(), store.clone());
+    if let Some(info) = STORE_OWNERS
+        .lock()
+        .ok()
+        .and_then(|cache| cache.get(&key).cloned())
+    {
+        return info;
+    }
+
+    let mut info = written
+        .get(&store)
+        .cloned()
+        .unwrap_or_else(|| query_transfer_store_info(client, &store, version));
+    info.owner = if info.owner.is_empty() {
+        None
+    } else {
+        owning_account(info.owner, |object| {
+            query_object_owner(client, object, version)
+        })
+    }
+    .unwrap_or_else(|| store.clone());
+
+    if let Ok(mut cache) = STORE_OWNERS.lock() {
+        cache.insert(key, info.clone());
+    }
+    info
+}
+
+/// Follows `owner` up its chain of owning objects to an account, at most
+/// `STORE_OWNER_DEPTH` levels; `None` when the chain goes deeper.
+fn owning_account(
+    mut owner: String,
+    mut parent_of: impl FnMut(&str) -> Option<String>,
+) -> Option<String> {
+    for _ in 0..STORE_OWNER_DEPTH {
+        match parent_of(&owner) {
+            Some(parent) => owner = parent,
+            None => return Some(owner),
+        }
+    }
+    parent_of(&owner).is_none().then_some(owner)
+}
+
+/// Whether `tx` aborted, so its payload moved nothing.
+fn transaction_failed(tx: &Value) -> bool {
+    tx.get("success").and_then(Value::as_bool) == Some(false)
+}
+
+/// `function` of sends found through the events of a multisig execution
+/// whose call is not in the transaction.
+const MULTISIG_PAYLOAD_FUNCTION: &str = "multisig_payload";
+
+/// The multisig account of a transaction executing a call that was stored
+/// on chain when it was proposed; the REST API then omits the call itself.
+fn stored_multisig_payload_address(tx: &Value) -> Option<&str> {
+    if tx.get("type")?.as_str()? != "user_transaction" {
+        return None;
+    }
+    let payload = tx.get("payload")?;
+    if payload.get("type")?.as_str()? != "multisig_payload"
+        || payload
+            .get("transaction_payload")
+            .is_some_and(|inner| !inner.is_null())
+    {
+        return None;
+    }
+    payload.get("multisig_address")?.as_str()
+}
+
+/// Sends out of `owner` read from the withdraw and deposit events of `tx`,
+/// with store owners resolved as `tx balance-change` does.
+fn event_sends(client: &AptosClient, tx: &Value, owner: &str, function: &str) -> Vec<RawSend> {
+    let owner = normalize_address(owner);
+    let changes = transaction_balance_changes(client, &Transaction::new(tx.clone()));
+    pair_transfers(&changes)
+        .into_iter()
+        .filter(|leg| normalize_address(&leg.from) == owner && leg.to != BURN)
+        .map(|leg| RawSend {
+            from: leg.from,
+            to: leg.to,
+            amount: leg.amount.to_string(),
+            asset: leg.asset,
+            function: function.to_owned(),
+        })
+        .collect()
+}
+
+fn transfer_payload(tx: &Value) -> Option<TransferPayload> {
+    if tx.get("type")?.as_str()? != "user_transaction" {
+        return None;
+    }
+
+    let sender = tx.get("sender").and_then(Value::as_str).unwrap_or_default();
+    let payload = tx.get("payload")?;
+    let (payload, from) = match payload.get("type")?.as_str()? {
+        "entry_function_payload" => (payload, sender),
+        // The sender only executes the call; the funds leave the multisig
+        // account that approved it.
+        "multisig_payload" => (
+            payload
+                .get("transaction_payload")
+                .filter(|inner| !inner.is_null())?,
+            payload.get("multisig_address")?.as_str()?,
+        ),
+        _ => return None,
+    };
+    if payload.get("type")?.as_str()? != "entry_function_payload" {
+        return None;
+    }
+
+    let function = payload.get("function")?.as_str()?;
+    let args = payload.get("arguments")?.as_array()?;
+    let type_args: Vec<String> = payload
+        .get("type_arguments")
+        .and_then(Value::as_array)
+        .map(|items| {
+            items
+                .iter()
+                .filter_map(|item| item.as_str().map(|s| s.to_owned()))
+                .collect()
+        })
+        .unwrap_or_default();
+
+    let (asset, recipients) = match function {
+        "0x1::aptos_account::transfer_coins" | "0x1::coin::transfer" => {
+            if args.len() < 2 || type_args.is_empty() {
+                return None;
+            }
+            (
+                type_args[0].clone(),
+                vec![(value_to_string(&args[0]), value_to_string(&args[1]))],
+            )
+        }
+        // APT-only sends with no type argument; `fungible_transfer_only`
+        // moves it between primary stores, but it is still APT.
+        "0x1::aptos_account::transfer" | "0x1::aptos_account::fungible_transfer_only" => {
+            if args.len() < 2 {
+                return None;
+            }
+            (
+                APTOS_COIN_TYPE.to_owned(),
+                vec![(value_to_string(&args[0]), value_to_string(&args[1]))],
+            )
+        }
+        "0x1::aptos_account::batch_transfer" => {
+            if args.len() < 2 {
+                return None;
+            }
+            (
+                APTOS_COIN_TYPE.to_owned(),
+                batch_recipients(tx, function, &args[0], &args[1])?,
+            )
+        }
+        "0x1::aptos_account::batch_transfer_coins" => {
+            if args.len() < 2 || type_args.is_empty() {
+                return None;
+            }
+            (
+                type_args[0].clone(),
+                batch_recipients(tx, function, &args[0], &args[1])?,
+            )
+        }
+        "0x1::primary_fungible_store::transfer" => {
+            if args.len() < 3 {
+                return None;
+            }
+            (
+                get_inner_or_string(&args[0]),
+                vec![(value_to_string(&args[1]), value_to_string(&args[2]))],
+            )
+        }
+        // Store to store: the funds leave the first store, whichever
+        // account owns it.
+        "0x1::fungible_asset::transfer" => {
+            if args.len() < 3 {
+                return None;
+            }
+            return Some(TransferPayload {
+                function: function.to_owned(),
+                from: get_inner_or_string(&args[0]),
+                asset: String::new(),
+                recipients: vec![(get_inner_or_string(&args[1]), value_to_string(&args[2]))],
+                stores: true,
+            });
+        }
+        _ => return None,
+    };
+
+    if asset.is_empty() {
+        return None;
+    }
+    Some(TransferPayload {
+        function: function.to_owned(),
+        from: from.to_owned(),
+        asset,
+        recipients,
+        stores: false,
+    })
+}
+
+/// Pairs the `recipients` and `amounts` vector arguments of a batch
+/// transfer. Vectors of different lengths are skipped with a note on
+/// stderr, since no pairing of them is trustworthy.
+fn batch_recipients(
+    tx: &Value,
+    function: &str,
+    recipients: &Value,
+    amounts: &Value,
+) -> Option<Vec<(String, String)>> {
+    let recipients = recipients.as_array()?;
+    let amounts = amounts.as_array()?;
+    if recipients.len() != amounts.len() {
+        eprintln!(
+            "skipping {function} at version {}: {} recipients but {} amounts",
+            value_to_string(tx.get("version").unwrap_or(&Value::Null)),
+            recipients.len(),
+            amounts.len()
+        );
+        return None;
+    }
+    Some(
+        recipients
+            .iter()
+            .zip(amounts)
+            .map(|(to, amount)| (value_to_string(to), value_to_string(amount)))
+            .collect(),
+    )
+}
+
+/// Reads every module under an account, following pagination, or the one
+/// page at `--cursor`.
+fn list_modules(client: &AptosClient, args: &ModulesArgs) -> Result<Vec<Value>> {
+    let (modules, next) = account_pages(
+        client,
+        &format!("/accounts/{}/modules", args.address),
+        args.ledger_version,
+        args.cursor.clone(),
+        args.cursor.is_none(),
+    )?;
+    if let Some(next) = next {
+        eprintln!("more modules follow; continue with --cursor {next}");
+    }
+    Ok(modules)
+}
+
+/// Applies `--filter`, `--exposed-only` and `--sort`. Sorts are stable and
+/// break ties by name.
+fn select_modules(modules: Vec<Value>, args: &ModulesArgs) -> Result<Vec<Value>> {
+    let filter = args
+        .filter
+        .as_deref()
+        .map(Regex::new)
+        .transpose()
+        .context("invalid --filter regex")?;
+    let mut modules: Vec<Value> = modules
+        .into_iter()
+        .filter(|module| {
+            filter
+                .as_ref()
+                .map_or(true, |filter| filter.is_match(module_name(module)))
+        })
+        .filter(|module| !args.exposed_only || exposed_function_count(module) > 0)
+        .collect();
+    // Listings without the modules themselves read best by name.
+    let sort = args
+        .sort
+        .or((args.names || args.with_functions).then_some(ModuleSort::Name));
+    match sort {
+        Some(ModuleSort::Name) => modules.sort_by(|a, b| module_name(a).cmp(module_name(b))),
+        Some(ModuleSort::Functions) => modules.sort_by(|a, b| {
+            exposed_function_count(b)
+                .cmp(&exposed_function_count(a))
+                .then_with(|| module_name(a).cmp(module_name(b)))
+        }),
+        Some(ModuleSort::Size) => modules.sort_by(|a, b| {
+            bytecode_size(b)
+                .cmp(&bytecode_size(a))
+                .then_with(|| module_name(a).cmp(module_name(b)))
+        }),
+        None => {}
+    }
+    Ok(modules)
+}
+
+/// `--with-functions` row.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize)]
+struct ModuleFunctions {
+    name: String,
+    entry_functions: usize,
+    view_functions: usize,
+}
+
+impl ModuleFunctions {
+    fn of(module: &Value) -> Self {
+        let functions = module
+            .pointer("/abi/exposed_functions")
+            .and_then(Value::as_array)
+            .map(Vec::as_slice)
+            .unwrap_or_default();
+        let count = |flag: &str| {
+            functions
+                .iter()
+                .filter(|function| function.get(flag).and_then(Value::as_bool) == Some(true))
+                .count()
+        };
+        Self {
+            name: module_name(module).to_owned(),
+            entry_functions: count("is_entry"),
+            view_functions: count("is_view"),
+        }
+    }
+}
+
+impl OutputSchema for ModuleFunctions {
+    fn output_schema() -> Value {
+        object_schema(
+            "Entry and view function counts of one module (`--with-functions`)",
+            &[
+                ("name", string_schema("Module name")),
+                (
+                    "entry_functions",
+                    integer_schema("Number of entry functions"),
+                ),
+                ("view_functions", integer_schema("Number of view functions")),
+            ],
+        )
+    }
+}
+
+pub(crate) fn module_functions_output_schema() -> Value {
+    array_schema(
+        "Modules with their entry and view function counts",
+        ModuleFunctions::output_schema(),
+    )
+}
+
+/// `--functions` row: a callable function of a module.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize)]
+struct FunctionSignature {
+    name: String,
+    is_entry: bool,
+    is_view: bool,
+    type_params: Vec<TypeParam>,
+    /// Parameter types, without the leading `&signer` of entry functions.
+    params: Vec<String>,
+    #[serde(rename = "return")]
+    returns: Vec<String>,
+}
+
+/// A generic type parameter with its ability constraints.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize)]
+pub(crate) struct TypeParam {
+    pub(crate) name: String,
+    pub(crate) constraints: Vec<String>,
+}
+
+impl TypeParam {
+    /// `T{index}` with the constraints listed in an ABI's `generic_type_params`.
+    pub(crate) fn of(index: usize, param: &Value) -> Self {
+        Self {
+            name: format!("T{index}"),
+            constraints: param
+                .get("constraints")
+                .and_then(Value::as_array)
+                .map(Vec::as_slice)
+                .unwrap_or_default()
+                .iter()
+                .filter_map(Value::as_str)
+                .map(str::to_owned)
+                .collect(),
+        }
+    }
+
+    /// `T0` or `T0: copy + drop`.
+    pub(crate) fn declaration(&self) -> String {
+        match self.constraints.as_slice() {
+            [] => self.name.clone(),
+            constraints => format!("{}: {}", self.name, constraints.join(" + ")),
+        }
+    }
+}
+
+impl OutputSchema for TypeParam {
+    fn output_schema() -> Value {
+        object_schema(
+            "Generic type parameter",
+            &[
+                ("name", string_schema("Parameter name")),
+                (
+                    "constraints",
+                    array_schema("Required abilities", string_schema("Ability, e.g. `store`")),
+                ),
+            ],
+        )
+    }
+}
+
+impl FunctionSignature {
+    /// Entry and view functions of a module response, in ABI order.
+    fn list(module: &Value) -> Vec<Self> {
+        module
+            .pointer("/abi/exposed_functions")
+            .and_then(Value::as_array)
+            .map(Vec::as_slice)
+            .unwrap_or_default()
+            .iter()
+            .map(Self::of)
+            .filter(|function| function.is_entry || function.is_view)
+            .collect()
+    }
+
+    fn of(function: &Value) -> Self {
+        let flag = |name: &str| function.get(name).and_then(Value::as_bool) == Some(true);
+        let strings = |value: Option<&Value>| -> Vec<String> {
+            value
+                .and_then(Value::as_array)
+                .map(Vec::as_slice)
+                .unwrap_or_default()
+                .iter()
+                .filter_map(Value::as_str)
+                .map(str::to_owned)
+                .collect()
+        };
+        let is_entry = flag("is_entry");
+        let mut params = strings(function.get("params"));
+        if is_entry {
+            let signers = params
+                .iter()
+                .take_while(|param| matches!(param.as_str(), "&signer" | "signer"))
+                .count();
+            params.drain(..signers);
+        }
+        let type_params = function
+            .get("generic_type_params")
+            .and_then(Value::as_array)
+            .map(Vec::as_slice)
+            .unwrap_or_default()
+            .iter()
+            .enumerate()
+            .map(|(index, param)| TypeParam::of(index, param))
+            .collect();
+        Self {
+            name: get_nested_string(function, &["name"]),
+            is_entry,
+            is_view: flag("is_view"),
+            type_params,
+            params,
+            returns: strings(function.get("return")),
+        }
+    }
+
+    /// `--pretty` line, e.g. `entry transfer<T0: key + store>(address, u64)`.
+    fn signature(&self) -> String {
+        let mut markers = Vec::new();
+        if self.is_entry {
+            markers.push("entry ");
+        }
+        if self.is_view {
+            markers.push("view ");
+        }
+        let generics = if self.type_params.is_empty() {
+            String::new()
+        } else {
+            let params: Vec<String> = self
+                .type_params
+                .iter()
+                .map(TypeParam::declaration)
+                .collect();
+            format!("<{}>", params.join(", "))
+        };
+        let returns = match self.returns.as_slice() {
+            [] => String::new(),
+            [single] => format!(": {single}"),
+            many => format!(": ({})", many.join(", ")),
+        };
+        format!(
+            "{}{}{generics}({}){returns}",
+            markers.concat(),
+            self.name,
+            self.params.join(", ")
+        )
+    }
+}
+
+impl OutputSchema for FunctionSignature {
+    fn output_schema() -> Value {
+        object_schema(
+            "Entry or view function of a module (`--functions`)",
+            &[
+                ("name", string_schema("Function name")),
+                (
+                    "is_entry",
+                    boolean_schema("Whether it is an entry function"),
+                ),
+                ("is_view", boolean_schema("Whether it is a view function")),
+                (
+                    "type_params",
+                    array_schema(
+                        "Generic type parameters, named `T0`, `T1`, ...",
+                        TypeParam::output_schema(),
+                    ),
+                ),
+                (
+                    "params",
+                    array_schema(
+                        "Parameter types; the leading `&signer` of entry functions is omitted",
+                        string_schema("Move type"),
+                    ),
+                ),
+                (
+                    "return",
+                    array_schema("Return types", string_schema("Move type")),
+                ),
+            ],
+        )
+    }
+}
+
+pub(crate) fn module_functions_list_output_schema() -> Value {
+    array_schema(
+        "Entry and view functions of the module",
+        FunctionSignature::output_schema(),
+    )
+}
+
+fn module_name(module: &Value) -> &str {
+    module
+        .pointer("/abi/name")
+        .and_then(Value::as_str)
+        .unwrap_or_default()
+}
+
+fn exposed_function_count(module: &Value) -> usize {
+    module
+        .pointer("/abi/exposed_functions")
+        .and_then(Value::as_array)
+        .map_or(0, Vec::len)
+}
+
+/// Bytecode length in bytes, measured from the hex string.
+/// Raw bytes of a module response's `bytecode` hex.
+fn module_bytecode(module: &Value) -> Result<Vec<u8>> {
+    let hex = module
+        .get("bytecode")
+        .and_then(Value::as_str)
+        .ok_or_else(|| anyhow!("module response has no bytecode"))?;
+    hex::decode(hex.strip_prefix("0x").unwrap_or(hex))
+        .context("failed to decode module bytecode hex")
+}
+
+fn bytecode_size(module: &Value) -> usize {
+    module
+        .get("bytecode")
+        .and_then(Value::as_str)
+        .map_or(0, |hex| hex.trim_start_matches("0x").len() / 2)
+}
+
+/// Reads every resource under an account, following pagination.
+pub(crate) fn list_resources(
+    client: &AptosClient,
+    address: &str,
+    ledger_version: Option<u64>,
+) -> Result<Vec<Value>> {
+    let path = format!("/accounts/{address}/resources");
+    Ok(account_pages(client, &path, ledger_version, None, true)?.0)
+}
+
+/// Every module published at `address`, read page by page.
+pub(crate) fn list_published_modules(
+    client: &AptosClient,
+    address: &str,
+    ledger_version: Option<u64>,
+) -> Result<Vec<Value>> {
+    let path = format!("/accounts/{address}/modules");
+    Ok(account_pages(client, &path, ledger_version, None, true)?.0)
+}
+
+/// Items of a paginated account listing (`/accounts/{address}/resources`
+/// or `/modules`) from `cursor`, or the start. With `all` every page is
+/// read; otherwise one page, along with the cursor of the next if any.
+fn account_pages(
+    client: &AptosClient,
+    path: &str,
+    ledger_version: Option<u64>,
+    mut cursor: Option<String>,
+    all: bool,
+) -> Result<(Vec<Value>, Option<String>)> {
+    let mut items = Vec::new();
+    loop {
+        let mut page_path = format!("{path}?limit={RESOURCES_PAGE_LIMIT}");
+        if let Some(cursor) = &cursor {
+            page_path.push_str(&format!("&start={}", urlencoding::encode(cursor)));
+        }
+        let (page, next) = client
+            .get_json_page(&with_optional_ledger_version(&page_path, ledger_version))
+            .map_err(|err| explain_pruned(err, ledger_version))?;
+        let Value::Array(page) = page else {
+            return Err(anyhow!("unexpected response format from {path}"));
+        };
+        let done = page.is_empty();
+        items.extend(page);
+        match next {
+            Some(next) if !done && all => cursor = Some(next),
+            Some(next) if !done => return Ok((items, Some(next))),
+            _ => return Ok((items, None)),
+        }
+    }
+}
+
+/// Groups resources by the module declaring their type, most resources
+/// first. A type that does not parse is its own group.
+fn summarize_resources(resources: &[Value]) -> Vec<ModuleSummary> {
+    let mut modules: BTreeMap<String, (usize, BTreeSet<String>)> = BTreeMap::new();
+    for resource in resources {
+        let resource_type = get_nested_string(resource, &["type"]);
+        let (module, name) = match StructTag::parse(&resource_type) {
+            Ok(tag) => (tag.module_id(), tag.name),
+            Err(_) => (resource_type.clone(), resource_type),
+        };
+        let entry = modules.entry(module).or_default();
+        entry.0 += 1;
+        entry.1.insert(name);
+    }
+    let mut summary: Vec<ModuleSummary> = modules
+        .into_iter()
+        .map(|(module, (count, types))| ModuleSummary {
+            module,
+            count,
+            types: types.into_iter().collect(),
+        })
+        .collect();
+    summary.sort_by(|a, b| b.count.cmp(&a.count).then_with(|| a.module.cmp(&b.module)));
+    summary
+}
+
+fn pretty_module_summary_lines(summary: &[ModuleSummary]) -> Vec<String> {
+    let module_width = summary.iter().map(|m| m.module.len()).max().unwrap_or(0);
+    let count_width = summary
+        .iter()
+        .map(|m| m.count.to_string().len())
+        .max()
+        .unwrap_or(0);
+    summary
+        .iter()
+        .map(|m| format!("{:<module_width$}  {:>count_width$}", m.module, m.count))
+        .collect()
+}
+
+/// Whether `resource`'s type matches any of the `--type` `patterns`, which
+/// have their leading address normalized already. No patterns match all.
+fn resource_type_matches(patterns: &[String], resource: &Value) -> bool {
+    let resource_type = normalize_qualified_name(&get_nested_string(resource, &["type"]));
+    patterns.is_empty()
+        || patterns
+            .iter()
+            .any(|pattern| glob_matches(pattern, &resource_type))
+}
+
+/// Reads every `CoinStore` under an account.
+fn list_coins(client: &AptosClient, args: &CoinsArgs) -> Result<Vec<CoinHolding>> {
+    let mut holdings = Vec::new();
+    for resource in list_resources(client, &args.address, args.ledger_version)? {
+        let resource_type = get_nested_string(&resource, &["type"]);
+        let Some(coin_type) = coin_store_coin_type(&resource_type) else {
+            continue;
+        };
+        let balance = get_nested_string(&resource, &["data", "coin", "value"]);
+        if !args.include_zero && balance.trim_start_matches('0').is_empty() {
+            continue;
+        }
+        let metadata = assets::resolver().lookup(client, coin_type);
+        holdings.push(CoinHolding {
+            coin_type: coin_type.to_owned(),
+            amount: format_amount(&balance, metadata.decimals),
+            symbol: metadata.symbol,
+            decimals: metadata.decimals,
+            total: balance.clone(),
+            total_amount: format_amount(&balance, metadata.decimals),
+            balance,
+            frozen: resource
+                .pointer("/data/frozen")
+                .and_then(Value::as_bool)
+                .unwrap_or(false),
+            paired_metadata: None,
+            fa_balance: None,
+        });
+    }
+    sort_by_amount(&mut holdings);
+    Ok(holdings)
+}
+
+/// Fills in the paired fungible asset side of each holding: the pairing
+/// from `coin::paired_metadata` and the owner's primary store balance,
+/// read concurrently. The pairing is read at the latest ledger version.
+fn add_paired_balances(
+    client: &AptosClient,
+    address: &str,
+    holdings: &mut [CoinHolding],
+    ledger_version: Option<u64>,
+) -> Result<()> {
+    let paired: Vec<Result<Option<(String, u64)>>> = thread::scope(|scope| {
+        let handles: Vec<_> = holdings
+            .iter()
+            .map(|holding| {
+                let coin_type = holding.coin_type.as_str();
+                scope.spawn(move || {
+                    let Some(metadata) = coin::paired_metadata(client, coin_type)? else {
+                        return Ok(None);
+                    };
+                    let balance =
+                        fa::primary_store_balance(client, address, &metadata, ledger_version)?;
+                    Ok(Some((metadata, balance.unwrap_or(0))))
+                })
+            })
+            .collect();
+        handles
+            .into_iter()
+            .map(|handle| handle.join().expect("paired balance lookup panicked"))
+            .collect()
+    });
+    for (holding, paired) in holdings.iter_mut().zip(paired) {
+        if let Some((metadata, fa_balance)) = paired? {
+            holding.set_paired_balance(metadata, fa_balance);
+        }
+    }
+    Ok(())
+}
+
+impl CoinHolding {
+    fn set_paired_balance(&mut self, metadata: String, fa_balance: u64) {
+        let total = BigInt::from_str(&self.balance).unwrap_or_default() + BigInt::from(fa_balance);
+        self.total = total.to_string();
+        self.total_amount = format_amount(&self.total, self.decimals);
+        self.paired_metadata = Some(normalize_address(&metadata));
+        self.fa_balance = Some(fa_balance.to_string());
+    }
+}
+
+/// Every non-zero `CoinStore` of the account plus its APT primary fungible
+/// store. The node API cannot list the other objects an account owns, so
+/// fungible assets besides APT are not found.
+fn list_balances(client: &AptosClient, args: &BalancesArgs) -> Result<Vec<AssetBalance>> {
+    let coins = list_coins(
+        client,
+        &CoinsArgs {
+            address: args.address.clone(),
+            include_zero: false,
+            ledger_version: args.ledger_version,
+        },
+    )?;
+    let mut balances: Vec<AssetBalance> = coins
+        .into_iter()
+        .map(|coin| AssetBalance {
+            asset: coin.coin_type,
+            symbol: coin.symbol,
+            decimals: coin.decimals,
+            raw_amount: coin.balance,
+            amount: coin.amount,
+        })
+        .collect();
+
+    let apt = fa::primary_store_balance(
+        client,
+        &args.address,
+        APT_METADATA_ADDRESS,
+        args.ledger_version,
+    )?;
+    if let Some(raw_amount) = apt.filter(|balance| *balance > 0) {
+        let metadata = assets::resolver().lookup(client, APT_METADATA_ADDRESS);
+        let raw_amount = raw_amount.to_string();
+        balances.push(AssetBalance {
+            asset: APT_METADATA_ADDRESS.to_owned(),
+            amount: format_amount(&raw_amount, metadata.decimals),
+            symbol: metadata.symbol,
+            decimals: metadata.decimals,
+            raw_amount,
+        });
+    }
+
+    if let Some(min_value) = &args.min_value {
+        balances.retain(|balance| {
+            BigInt::from_str(&balance.raw_amount)
+                .is_ok_and(|raw| min_value.allows(&raw, balance.decimals))
+        });
+    }
+    sort_by_symbol(&mut balances);
+    Ok(balances)
+}
+
+fn sort_by_symbol(balances: &mut [AssetBalance]) {
+    balances.sort_by(|a, b| {
+        a.symbol
+            .to_lowercase()
+            .cmp(&b.symbol.to_lowercase())
+            .then_with(|| a.asset.cmp(&b.asset))
+    });
+}
+
+/// `SYMBOL  amount  asset` rows with symbols left-aligned and amounts
+/// right-aligned.
+fn pretty_balances_lines(balances: &[AssetBalance], style: AmountStyle) -> Vec<String> {
+    let amounts: Vec<String> = balances
+        .iter()
+        .map(|balance| style.apply(&balance.amount))
+        .collect();
+    let symbol_width = balances.iter().map(|b| b.symbol.len()).max().unwrap_or(0);
+    let amount_width = amounts.iter().map(String::len).max().unwrap_or(0);
+    balances
+        .iter()
+        .zip(&amounts)
+        .map(|(balance, amount)| {
+            format!(
+                "{:<symbol_width$}  {amount:>amount_width$}  {}",
+                balance.symbol, balance.asset
+            )
+        })
+        .collect()
+}
+
+/// Coin type `T` of a `0x1::coin::CoinStore<T>` resource type. `T` may itself
+/// be generic (`LP<A, B>`), so the brackets must balance with no top-level
+/// comma.
+fn coin_store_coin_type(resource_type: &str) -> Option<&str> {
+    let (address, rest) = resource_type.split_once("::")?;
+    if normalize_address(address) != normalize_address("0x1") {
+        return None;
+    }
+    let inner = rest.strip_prefix("coin::CoinStore<")?.strip_suffix('>')?;
+    let mut depth = 0usize;
+    for ch in inner.chars() {
+        match ch {
+            '<' => depth += 1,
+            '>' => depth = depth.checked_sub(1)?,
+            ',' if depth == 0 => return None,
+            _ => {}
+        }
+    }
+    (depth == 0 && !inner.is_empty()).then_some(inner)
+}
+
+/// Sorts by decimal value, largest first, comparing across decimals exactly.
+fn sort_by_amount(holdings: &mut [CoinHolding]) {
+    let max_decimals = holdings
+        .iter()
+        .map(|holding| holding.decimals)
+        .max()
+        .unwrap_or(0);
+    let scaled = |holding: &CoinHolding| {
+        let raw = BigInt::from_str(&holding.balance).unwrap_or_default();
+        raw * BigInt::from(10u8).pow(u32::from(max_decimals - holding.decimals))
+    };
+    holdings.sort_by(|a, b| {
+        scaled(b)
+            .cmp(&scaled(a))
+            .then_with(|| a.coin_type.cmp(&b.coin_type))
+    });
+}
+
+/// Resolves the assets sent in `txs` up front, concurrently, instead of one
+/// lookup at a time as extraction meets them.
+fn warm_sends_metadata(client: &AptosClient, txs: &[Value]) {
+    let payloads: Vec<TransferPayload> = txs.iter().filter_map(transfer_payload).collect();
+    let assets: BTreeSet<&str> = payloads
+        .iter()
+        .map(|payload| payload.asset.as_str())
+        .filter(|asset| !asset.is_empty())
+        .collect();
+    assets::resolver().warm(client, &assets);
+}
+
+/// `account balance --assets`: every requested balance read concurrently at
+/// one ledger version, `--ledger-version` or the current one.
+fn run_account_asset_balances(client: &AptosClient, args: &BalanceArgs) -> Result<()> {
+    let version = match args.ledger_version.or(client.pinned_ledger_version()) {
+        Some(version) => {
+            check_ledger_version(client, Some(version))?;
+            version
+        }
+        None => parse_u64(
+            client
+                .get_json("/")?
+                .get("ledger_version")
+                .unwrap_or(&Value::Null),
+        )
+        .ok_or_else(|| anyhow!("failed to parse `ledger_version` from ledger response"))?,
+    };
+    let mut wanted: Vec<&str> = Vec::new();
+    for asset in &args.assets {
+        let asset = asset.trim();
+        if !asset.is_empty() && !wanted.contains(&asset) {
+            wanted.push(asset);
+        }
+    }
+    let balances: Vec<Result<u64>> = thread::scope(|scope| {
+        let handles: Vec<_> = wanted
+            .iter()
+            .map(|asset| {
+                scope.spawn(move || {
+                    let encoded = urlencoding::encode(asset);
+                    let path = format!("/accounts/{}/balance/{encoded}", args.address);
+                    let value = client
+                        .get_json(&with_optional_ledger_version(&path, Some(version)))
+                        .map_err(|err| explain_pruned(err, Some(version)))?;
+                    parse_u64(&value).ok_or_else(|| anyhow!("unexpected balance response: {value}"))
+                })
+            })
+            .collect();
+        handles
+            .into_iter()
+            .map(|handle| handle.join().expect("balance lookup panicked"))
+            .collect()
+    });
+    let resolver = assets::resolver();
+    resolver.warm(client, &wanted.iter().copied().collect());
+    let output = asset_balances(version, wanted.into_iter().zip(balances), |asset| {
+        resolver.lookup(client, asset)
+    });
+    crate::print_pretty_json(&output)
+}
+
+/// `{"ledger_version", "balances"}` keyed by asset, each entry shaped like
+/// `--decorate` output or holding the lookup's `error`.
+fn asset_balances<'a>(
+    version: u64,
+    balances: impl IntoIterator<Item = (&'a str, Result<u64>)>,
+    mut metadata: impl FnMut(&str) -> AssetMetadata,
+) -> Value {
+    let balances: serde_json::Map<String, Value> = balances
+        .into_iter()
+        .map(|(asset, balance)| {
+            let entry = match balance {
+                Ok(balance) => decorated_balance(&balance.to_string(), &metadata(asset)),
+                Err(err) => json!({"error": format!("{err:#}")}),
+            };
+            (asset.to_owned(), entry)
+        })
+        .collect();
+    json!({"ledger_version": version, "balances": balances})
+}
+
+/// `account balance --at-version`: the raw balance read from account state
+/// at `version`. A coin type sums its `CoinStore` and, once paired, its
+/// fungible asset's primary store; anything else is taken as a fungible
+/// asset metadata address.
+fn balance_at_version(
+    client: &AptosClient,
+    address: &str,
+    asset_type: &str,
+    version: u64,
+) -> Result<u64> {
+    check_ledger_version(client, Some(version))?;
+    let read = || -> Result<u64> {
+        if !asset_type.contains("::") {
+            let store = fa::primary_store_balance(client, address, asset_type, Some(version))?;
+            return Ok(store.unwrap_or(0));
+        }
+        let coin_store = coin::coin_store_balance(client, address, asset_type, Some(version))?;
+        let paired = if asset_type == APTOS_COIN_TYPE {
+            Some(APT_METADATA_ADDRESS.to_owned())
+        } else {
+            coin::paired_metadata(client, asset_type)?
+        };
+        let primary_store = match paired {
+            Some(metadata) => {
+                fa::primary_store_balance(client, address, &metadata, Some(version))?.unwrap_or(0)
+            }
+            None => 0,
+        };
+        Ok(coin_store + primary_store)
+    };
+    read().map_err(|err| explain_pruned(err, Some(version)))
+}
+
+/// `account balance --pretty`: the amount in whole units next to the raw
+/// one, or the raw amount and a shortened asset id when the asset's
+/// decimals could not be resolved.
+fn pretty_balance(raw: &str, asset_type: &str, metadata: &AssetMetadata) -> String {
+    if !metadata.decimals_known {
+        return format!("{raw} {}", shorten_addr(asset_type));
+    }
+    let unit = if asset_type == APTOS_COIN_TYPE {
+        "octas"
+    } else {
+        "base units"
+    };
+    format!(
+        "{} {} ({raw} {unit})",
+        format_amount(raw, metadata.decimals),
+        metadata.symbol
+    )
+}
+
+/// `account balance --decorate`: the raw amount plus, when resolved, the
+/// asset's symbol and decimals and the amount in whole units.
+fn decorated_balance(raw: &str, metadata: &AssetMetadata) -> Value {
+    if !metadata.decimals_known {
+        return json!({"balance": raw});
+    }
+    json!({
+        "balance": raw,
+        "symbol": metadata.symbol,
+        "decimals": metadata.decimals,
+        "formatted": format_amount(raw, metadata.decimals),
+    })
+}
+
+pub(crate) fn format_amount(amount: &str, decimals: u8) -> String {
+    if decimals == 0 {
+        return amount.to_owned();
+    }
+
+    let Ok(raw) = BigInt::from_str(amount) else {
+        return amount.to_owned();
+    };
+
+    let divisor = BigInt::from(10u8).pow(decimals as u32);
+    let int_part = &raw / &divisor;
+    let frac_part = &raw % &divisor;
+    let mut frac_str = format!("{:0width$}", frac_part, width = decimals as usize);
+    while frac_str.ends_with('0') {
+        frac_str.pop();
+    }
+
+    if frac_str.is_empty() {
+        int_part.to_string()
+    } else {
+        format!("{int_part}.{frac_str}")
+    }
+}
+
+/// Display options for decimal amounts in `--pretty` output. JSON output
+/// keeps the plain [`format_amount`] string.
+#[derive(Debug, Clone, Copy, Default)]
+pub(crate) struct AmountStyle {
+    /// Group integer digits in threes with `,`, whatever the locale.
+    pub(crate) separators: bool,
+    /// Maximum fraction digits shown; the rest are truncated, never rounded.
+    pub(crate) precision: Option<u8>,
+}
+
+impl AmountStyle {
+    pub(crate) fn pretty(precision: Option<u8>) -> Self {
+        Self {
+            separators: true,
+            precision,
+        }
+    }
+
+    /// Restyles a decimal string such as `1234567.891` from
+    /// [`format_amount`]. Non-zero amounts that truncate to zero render as
+    /// `<0.000001`; anything that isn't a decimal number is returned as is.
+    pub(crate) fn apply(&self, amount: &str) -> String {
+        let (sign, digits) = match amount.strip_prefix('-') {
+            Some(digits) => ("-", digits),
+            None => ("", amount),
+        };
+        let (int_part, frac_part) = digits.split_once('.').unwrap_or((digits, ""));
+        let is_digits = |part: &str| part.bytes().all(|byte| byte.is_ascii_digit());
+        if int_part.is_empty() || !is_digits(int_part) || !is_digits(frac_part) {
+            return amount.to_owned();
+        }
+
+        let shown = match self.precision {
+            Some(precision) => &frac_part[..frac_part.len().min(usize::from(precision))],
+            None => frac_part,
+        }
+        .trim_end_matches('0');
+        let is_zero = |part: &str| part.trim_start_matches('0').is_empty();
+        if let Some(precision) = self.precision {
+            if shown.is_empty() && is_zero(int_part) && !is_zero(frac_part) {
+                let cutoff = match precision {
+                    0 => "1".to_owned(),
+                    _ => format!("0.{}1", "0".repeat(usize::from(precision) - 1)),
+                };
+                return match sign {
+                    "-" => format!(">-{cutoff}"),
+                    _ => format!("<{cutoff}"),
+                };
+            }
+        }
+
+        let int_part = if self.separators {
+            group_thousands(int_part)
+        } else {
+            int_part.to_owned()
+        };
+        if shown.is_empty() {
+            format!("{sign}{int_part}")
+        } else {
+            format!("{sign}{int_part}.{shown}")
+        }
+    }
+}
+
+/// `1234567` -> `1,234,567`.
+fn group_thousands(digits: &str) -> String {
+    let mut grouped = String::with_capacity(digits.len() + digits.len() / 3);
+    for (index, digit) in digits.chars().enumerate() {
+        if index > 0 && (digits.len() - index) % 3 == 0 {
+            grouped.push(',');
+        }
+        grouped.push(digit);
+    }
+    grouped
+}
+
+/// Transfer lines, then the per-asset totals after a blank line.
+fn print_pretty_sends(
+    transfers: &[Transfer],
+    show_function: bool,
+    show_time: bool,
+    summary_only: bool,
+    style: AmountStyle,
+) {
+    if !summary_only {
+        let dim = io::stdout().is_terminal();
+        for line in pretty_sends_lines(transfers, show_function, show_time, dim, style) {
+            println!("{line}");
+        }
+        if !transfers.is_empty() {
+            println!();
+        }
+    }
+    for line in pretty_asset_totals_lines(&asset_totals(transfers), style) {
+        println!("{line}");
+    }
+}
+
+/// Everything sent of one asset.
+#[derive(Debug, Clone, PartialEq, Eq)]
+struct AssetTotal {
+    asset: String,
+    count: u64,
+    /// Sum of the raw on-chain amounts.
+    raw: BigInt,
+    decimals: u8,
+}
+
+impl AssetTotal {
+    /// Compares the decimal totals of assets with different decimals.
+    fn cmp_amount(&self, other: &Self) -> std::cmp::Ordering {
+        let scale = |total: &Self, other: &Self| {
+            BigInt::from(10u8).pow(u32::from(other.decimals.saturating_sub(total.decimals)))
+        };
+        (&self.raw * scale(self, other)).cmp(&(&other.raw * scale(other, self)))
+    }
+}
+
+/// Per-asset totals of `transfers`, biggest first. Sends of aborted
+/// transactions moved nothing and are not counted.
+fn asset_totals(transfers: &[Transfer]) -> Vec<AssetTotal> {
+    let mut totals: BTreeMap<(&str, &str), AssetTotal> = BTreeMap::new();
+    for transfer in transfers.iter().filter(|t| t.failed != Some(true)) {
+        let total = totals
+            .entry((&transfer.asset_id, &transfer.asset))
+            .or_insert_with(|| AssetTotal {
+                asset: transfer.asset.clone(),
+                count: 0,
+                raw: BigInt::default(),
+                decimals: transfer.decimals.unwrap_or(0),
+            });
+        total.count += 1;
+        total.raw += BigInt::from_str(&transfer.raw_amount).unwrap_or_default();
+    }
+    let mut totals: Vec<AssetTotal> = totals.into_values().collect();
+    totals.sort_by(|a, b| b.cmp_amount(a).then_with(|| a.asset.cmp(&b.asset)));
+    totals
+}
+
+fn pretty_asset_totals_lines(totals: &[AssetTotal], style: AmountStyle) -> Vec<String> {
+    let amounts: Vec<String> = totals
+        .iter()
+        .map(|t| style.apply(&format_amount(&t.raw.to_string(), t.decimals)))
+        .collect();
+    let max_amount_len = amounts.iter().map(String::len).max().unwrap_or(0);
+    let max_asset_len = totals.iter().map(|t| t.asset.len()).max().unwrap_or(0);
+    let max_count_len = totals
+        .iter()
+        .map(|t| t.count.to_string().len())
+        .max()
+        .unwrap_or(0);
+
+    totals
+        .iter()
+        .zip(&amounts)
+        .map(|(total, amount)| {
+            format!(
+                "total {:>amount_width$} {:<asset_width$} in {:>count_width$} {}",
+                amount,
+                total.asset,
+                total.count,
+                if total.count == 1 {
+                    "transfer"
+                } else {
+                    "transfers"
+                },
+                amount_width = max_amount_len,
+                asset_width = max_asset_len,
+                count_width = max_count_len
+            )
+        })
+        .collect()
+}
+
+/// `[version] amount asset ← from` lines.
+fn pretty_receives_lines(transfers: &[Transfer], style: AmountStyle) -> Vec<String> {
+    let amounts: Vec<String> = transfers.iter().map(|t| style.apply(&t.amount)).collect();
+    let max_amount_len = amounts.iter().map(String::len).max().unwrap_or(0);
+    let max_asset_len = transfers.iter().map(|t| t.asset.len()).max().unwrap_or(0);
+    transfers
+        .iter()
+        .zip(&amounts)
+        .map(|(transfer, amount)| {
+            format!(
+                "[{}] {:>amount_width$} {:<asset_width$} ← {}",
+                transfer.version,
+                amount,
+                transfer.asset,
+                transfer.from,
+                amount_width = max_amount_len,
+                asset_width = max_asset_len
+            )
+        })
+        .collect()
+}
+
+fn pretty_sends_lines(
+    transfers: &[Transfer],
+    show_function: bool,
+    show_time: bool,
+    dim: bool,
+    style: AmountStyle,
+) -> Vec<String> {
+    let amounts: Vec<String> = transfers.iter().map(|t| style.apply(&t.amount)).collect();
+    let max_amount_len = amounts.iter().map(String::len).max().unwrap_or(0);
+    let max_asset_len = transfers.iter().map(|t| t.asset.len()).max().unwrap_or(0);
+    // Indent the other rows to keep columns aligned past the `✗` marks.
+    let any_failed = transfers.iter().any(|t| t.failed == Some(true));
+
+    transfers
+        .iter()
+        .zip(&amounts)
+        .map(|(transfer, amount)| {
+            let mut to = match &transfer.to_name {
+                Some(name) => format!("{name} ({})", shorten_addr(&transfer.to)),
+                None => transfer.to.clone(),
+            };
+            if let Some(label) = &transfer.to_label {
+                to.push_str(&format!(" ({label})"));
+            }
+            let when = if show_time {
+                transfer.timestamp.clone()
+            } else {
+                transfer.version.to_string()
+            };
+            let marker = match (transfer.failed == Some(true), any_failed) {
+                (true, _) => "✗ ",
+                (false, true) => "  ",
+                (false, false) => "",
+            };
+            let mut line = format!(
+                "{marker}[{}] {:>amount_width$} {:<asset_width$} → {}",
+                when,
+                amount,
+                transfer.asset,
+                to,
+                amount_width = max_amount_len,
+                asset_width = max_asset_len
+            );
+            if show_function {
+                let function = short_function(&transfer.function);
+                if dim {
+                    line.push_str(&format!("  \x1b[2m{function}\x1b[0m"));
+                } else {
+                    line.push_str(&format!("  {function}"));
+                }
+            }
+            line
+        })
+        .collect()
+}
+
+fn print_pretty_function_totals(totals: &[FunctionTotal], style: AmountStyle) {
+    let max_function_len = totals.iter().map(|t| t.function.len()).max().unwrap_or(0);
+    let max_count_len = totals
+        .iter()
+        .map(|t| t.count.to_string().len())
+        .max()
+        .unwrap_or(0);
+    let amounts: Vec<String> = totals.iter().map(|t| style.apply(&t.amount)).collect();
+    let max_amount_len = amounts.iter().map(String::len).max().unwrap_or(0);
+
+    for (total, amount) in totals.iter().zip(&amounts) {
+        println!(
+            "{:<function_width$} {:>count_width$}x {:>amount_width$} {}",
+            total.function,
+            total.count,
+            amount,
+            total.asset,
+            function_width = max_function_len,
+            count_width = max_count_len,
+            amount_width = max_amount_len
+        );
+    }
+}
+
+/// `0x1::coin::transfer` -> `coin::transfer`.
+fn short_function(function: &str) -> &str {
+    function
+        .split_once("::")
+        .map_or(function, |(_, module_function)| module_function)
+}
+
+fn get_inner_or_string(value: &Value) -> String {
+    if let Some(inner) = value.get("inner").and_then(Value::as_str) {
+        return inner.to_owned();
     }
     value_to_string(value)
 }
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::commands::schema::validate;
+    use flate2::write::GzEncoder;
+    use flate2::Compression;
+    use std::io::Write;
+
+    fn gzip_hex(text: &[u8]) -> String {
+        let mut encoder = GzEncoder::new(Vec::new(), Compression::default());
+        encoder.write_all(text).unwrap();
+        format!("0x{}", hex::encode(encoder.finish().unwrap()))
+    }
+
+    #[test]
+    fn decodes_gzipped_source() {
+        let encoded = gzip_hex(b"module 0x1::m {}");
+        assert_eq!(
+            decode_source(&encoded, DEFAULT_MAX_SOURCE_BYTES).unwrap(),
+            "module 0x1::m {}"
+        );
+    }
+
+    #[test]
+    fn decodes_uncompressed_source() {
+        let encoded = format!("0x{}", hex::encode("module 0x1::m {}"));
+        assert_eq!(
+            decode_source(&encoded, DEFAULT_MAX_SOURCE_BYTES).unwrap(),
+            "module 0x1::m {}"
+        );
+    }
+
+    #[test]
+    fn rejects_oversized_gzip_payload() {
+        let encoded = gzip_hex(&vec![b'a'; 1024 * 1024]);
+        let err = decode_source(&encoded, 1024).unwrap_err();
+        assert!(err.to_string().contains("exceeds 1024 bytes"));
+    }
+
+    #[test]
+    fn rejects_invalid_hex_and_utf8() {
+        assert!(decode_source("0xzz", DEFAULT_MAX_SOURCE_BYTES).is_err());
+        assert!(decode_source("0xfffe", DEFAULT_MAX_SOURCE_BYTES).is_err());
+    }
+
+    #[test]
+    fn inventories_packages_without_decoding() {
+        let packages = vec![
+            json!({
+                "name": "MoveStdlib",
+                "upgrade_number": "3",
+                "upgrade_policy": {"policy": 1},
+                "source_digest": "ABC123",
+                "modules": [
+                    {"name": "bcs", "source": "0x1f8b00"},
+                    {"name": "hash", "source": "0x"},
+                ],
+            }),
+            json!({
+                "name": "Locked",
+                "upgrade_number": "0",
+                "upgrade_policy": {"policy": 2},
+                "source_digest": "",
+                "modules": [{"name": "vault", "source": ""}],
+            }),
+        ];
+        let inventory = package_inventory(&packages, None, None);
+        assert_eq!(
+            pretty_inventory_lines(&inventory),
+            [
+                "MoveStdlib  upgrade 3  compatible  1/2 modules with source",
+                "  bcs",
+                "  hash  (no source)",
+                "Locked  upgrade 0  immutable  0/1 modules with source",
+                "  vault  (no source)",
+            ]
+        );
+        validate(
+            &source_code_output_schema(),
+            &serde_json::to_value(&inventory).unwrap(),
+        )
+        .unwrap();
+
+        let hash = package_inventory(&packages, None, Some("hash"));
+        assert_eq!(hash.len(), 1);
+        assert_eq!(hash[0].modules.len(), 1);
+        assert!(package_inventory(&packages, Some("Locked"), Some("hash")).is_empty());
+    }
+
+    #[test]
+    fn filters_transactions_by_entry_function() {
+        let tx = |function: Option<&str>| match function {
+            Some(function) => {
+                json!({"payload": {"type": "entry_function_payload", "function": function}})
+            }
+            None => json!({"payload": {"type": "script_payload"}}),
+        };
+        let history = [
+            tx(Some("0x1::aptos_account::transfer")),
+            tx(Some("0x0000000000000000000000000000000000000000000000000000000000000001::delegation_pool::add_stake")),
+            tx(None),
+            tx(Some("0xcafe::vault::add_stake")),
+            tx(Some("0x1::delegation_pool::unlock")),
+            tx(Some("0x1::delegation_pool::add_stake")),
+        ];
+        let mut requests = Vec::new();
+        let mut fetch = |start: u64, limit: u64| -> Result<Vec<Value>> {
+            requests.push((start, limit));
+            let start = (start as usize).min(history.len());
+            let end = (start + 2).min(history.len());
+            Ok(history[start..end].to_vec())
+        };
+
+        let full = normalize_qualified_name("0x1::delegation_pool::add_stake");
+        let matches = |tx: &Value| entry_function_matches(&full, false, tx);
+        let scan = matching_transactions(0, 2, matches, &mut fetch).unwrap();
+        assert_eq!(scan.transactions, [history[1].clone(), history[5].clone()]);
+        assert_eq!(scan.next_start, 6);
+        assert!(!scan.capped);
+
+        let suffix = normalize_qualified_name("*::add_stake");
+        let matches = |tx: &Value| entry_function_matches(&suffix, false, tx);
+        let scan = matching_transactions(2, 10, matches, &mut fetch).unwrap();
+        assert_eq!(scan.transactions, [history[3].clone(), history[5].clone()]);
+        assert_eq!(scan.next_start, 6, "stops when the history runs out");
+
+        let scripts = |tx: &Value| entry_function_matches(&suffix, true, tx);
+        let scan = matching_transactions(0, 2, scripts, &mut fetch).unwrap();
+        assert_eq!(scan.transactions, [history[1].clone(), history[2].clone()]);
+        assert_eq!(scan.next_start, 3);
+        drop(fetch);
+        assert_eq!(
+            requests[..3],
+            [
+                (0, TRANSACTIONS_PAGE_LIMIT),
+                (2, TRANSACTIONS_PAGE_LIMIT),
+                (4, TRANSACTIONS_PAGE_LIMIT)
+            ]
+        );
+
+        let endless = |start: u64, limit: u64| -> Result<Vec<Value>> {
+            Ok((start..start + limit).map(|_| tx(None)).collect())
+        };
+        let scan = matching_transactions(
+            5,
+            1,
+            |tx: &Value| entry_function_matches(&full, false, tx),
+            endless,
+        )
+        .unwrap();
+        assert!(scan.capped);
+        assert_eq!(scan.next_start, 5 + SENDS_SCAN_CAP);
+    }
+
+    #[test]
+    fn filters_scan_back_from_the_newest_without_start() {
+        let history: Vec<Value> = (0..250)
+            .map(|sequence| json!({"sequence_number": sequence, "success": sequence % 3 != 0}))
+            .collect();
+        let mut requests = Vec::new();
+        let mut fetch = |start: u64, limit: u64| -> Result<Vec<Value>> {
+            requests.push((start, limit));
+            let start = (start as usize).min(history.len());
+            let end = (start + limit as usize).min(history.len());
+            Ok(history[start..end].to_vec())
+        };
+        let failed = |tx: &Value| tx["success"] == json!(false);
+
+        let scan = latest_matching_transactions(250, 3, failed, &mut fetch).unwrap();
+        let sequences: Vec<&Value> = scan
+            .transactions
+            .iter()
+            .map(|tx| &tx["sequence_number"])
+            .collect();
+        assert_eq!(sequences, [&json!(243), &json!(246), &json!(249)]);
+        assert!(!scan.capped);
+        drop(fetch);
+        assert_eq!(requests, [(150, 100)], "one page back from the newest");
+    }
+
+    #[test]
+    fn follow_starts_at_the_next_sequence_number() {
+        let (node, requests) = paged_node(vec![r#"{"sequence_number":"42"}"#]);
+        let client = AptosClient::new(&node).unwrap();
+        assert_eq!(follow_start(&client, "0x1", Some(3)).unwrap(), 3);
+        assert_eq!(follow_start(&client, "0x1", None).unwrap(), 42);
+        let seen: Vec<String> = requests.try_iter().collect();
+        assert_eq!(seen.len(), 1, "--start needs no account read: {seen:?}");
+        assert!(seen[0].starts_with("GET /v1/accounts/0x1 "), "{seen:?}");
+    }
+
+    #[test]
+    fn filters_transactions_by_outcome() {
+        let tx = |success: bool, vm_status: &str| {
+            json!({
+                "success": success,
+                "vm_status": vm_status,
+                "payload": {"type": "entry_function_payload", "function": "0x1::coin::transfer"},
+            })
+        };
+        let ok = tx(true, "Executed successfully");
+        let out_of_gas = tx(false, "Out of gas");
+        let abort = tx(
+            false,
+            "Move abort in 0x1::coin: EINSUFFICIENT_BALANCE(0x10006): ",
+        );
+        #[derive(clap::Parser)]
+        struct TxsCli {
+            #[command(flatten)]
+            args: TxsArgs,
+        }
+        let parse = |flags: &[&str]| {
+            let argv = ["txs", "0x1"].iter().chain(flags).copied();
+            <TxsCli as clap::Parser>::try_parse_from(argv).map(|cli| cli.args)
+        };
+        let filter = |flags: &[&str]| parse(flags).unwrap().filter();
+        assert_eq!(filter(&[]), None);
+
+        let failed = filter(&["--failed-only"]).unwrap();
+        assert!(!failed.matches(&ok));
+        assert!(failed.matches(&out_of_gas));
+        assert!(failed.matches(&abort));
+
+        let gas = filter(&["--failed-only", "--vm-status", "OUT_OF_GAS"]).unwrap();
+        assert!(gas.matches(&out_of_gas));
+        assert!(!gas.matches(&abort));
+        let insufficient = filter(&["--vm-status", "einsufficient balance"]).unwrap();
+        assert!(insufficient.matches(&abort));
+
+        let successful = filter(&["--success-only", "--function", "*::transfer"]).unwrap();
+        assert!(successful.matches(&ok));
+        assert!(!successful.matches(&abort));
+
+        let err = parse(&["--failed-only", "--success-only"]).err().unwrap();
+        assert_eq!(err.kind(), clap::error::ErrorKind::ArgumentConflict);
+    }
+
+    #[test]
+    fn summarizes_accounts_with_unknowns() {
+        let summary = AccountSummary {
+            address: "0xcafe".to_owned(),
+            sequence_number: "12".to_owned(),
+            authentication_key:
+                "0x000000000000000000000000000000000000000000000000000000000000cafe".to_owned(),
+            apt_balance: Some("1.5".to_owned()),
+            resources: Some(4),
+            modules: None,
+        };
+        assert_eq!(
+            pretty_account_lines(&summary),
+            [
+                "address          0xcafe",
+                "sequence number  12",
+                "auth key         0x000000000000000000000000000000000000000000000000000000000000cafe (original)",
+                "APT balance      1.5 APT",
+                "resources        4",
+                "modules          unknown",
+            ]
+        );
+        let rotated = AccountSummary {
+            authentication_key: "0xbeef".to_owned(),
+            apt_balance: None,
+            ..summary
+        };
+        let lines = pretty_account_lines(&rotated);
+        assert_eq!(lines[2], "auth key         0xbeef (rotated)");
+        assert_eq!(lines[3], "APT balance      unknown");
+    }
+
+    #[test]
+    fn walks_package_dependencies() {
+        let package = |name: &str, deps: &[(&str, &str)], sources: &[&str]| {
+            json!({
+                "name": name,
+                "modules": sources
+                    .iter()
+                    .map(|source| json!({"name": "m", "source": source}))
+                    .collect::<Vec<_>>(),
+                "deps": deps
+                    .iter()
+                    .map(|(account, name)| json!({"account": account, "package_name": name}))
+                    .collect::<Vec<_>>(),
+            })
+        };
+        let registries: HashMap<&str, Vec<Value>> = [
+            (
+                "0x1",
+                vec![
+                    package("MoveStdlib", &[], &["0x1f", "0x"]),
+                    package("AptosFramework", &[("0x1", "MoveStdlib")], &["0x1f"]),
+                ],
+            ),
+            (
+                "0xcafe",
+                vec![
+                    package(
+                        "Core",
+                        &[("0x1", "AptosFramework"), ("0xcafe", "Dex")],
+                        &["0x"],
+                    ),
+                    package(
+                        "Dex",
+                        &[("0xcafe", "Core"), ("0x1", "MoveStdlib")],
+                        &["0x1f"],
+                    ),
+                ],
+            ),
+        ]
+        .into_iter()
+        .collect();
+        let mut fetches = Vec::new();
+        let mut walker = DepsWalker::new(DEFAULT_DEPS_DEPTH, |account: &str| {
+            fetches.push(account.to_owned());
+            registries
+                .get(account)
+                .cloned()
+                .ok_or_else(|| anyhow!("no code metadata found at address"))
+        });
+        let root = package(
+            "App",
+            &[
+                ("0xcafe", "Core"),
+                ("0x1", "MoveStdlib"),
+                ("0xdead", "Gone"),
+            ],
+            &["0x1f"],
+        );
+        let tree = walker.tree("0xbeef", &root);
+        let summary = |node: &PackageDeps| {
+            (
+                node.package.clone(),
+                node.modules_with_source,
+                node.unexpanded.clone(),
+            )
+        };
+        assert_eq!(
+            tree.deps.iter().map(summary).collect::<Vec<_>>(),
+            [
+                ("Core".to_owned(), Some(0), None),
+                (
+                    "MoveStdlib".to_owned(),
+                    Some(1),
+                    Some("expanded above".to_owned())
+                ),
+                (
+                    "Gone".to_owned(),
+                    None,
+                    Some("no code metadata found at address".to_owned())
+                ),
+            ]
+        );
+        let core = &tree.deps[0];
+        assert_eq!(core.deps[0].deps[0].package, "MoveStdlib");
+        let dex = &core.deps[1];
+        assert_eq!(
+            dex.deps.iter().map(summary).collect::<Vec<_>>(),
+            [
+                ("Core".to_owned(), None, Some("cycle".to_owned())),
+                (
+                    "MoveStdlib".to_owned(),
+                    Some(1),
+                    Some("expanded above".to_owned())
+                ),
+            ]
+        );
+        drop(walker);
+        assert_eq!(fetches, ["0xcafe", "0x1", "0xdead"]);
+        validate(
+            &source_code_output_schema(),
+            &serde_json::to_value([&tree]).unwrap(),
+        )
+        .unwrap();
+
+        let mut shallow = DepsWalker::new(0, |_: &str| -> Result<Vec<Value>> {
+            panic!("non-recursive listings read no registries")
+        });
+        let tree = shallow.tree("0xbeef", &root);
+        assert_eq!(tree.deps.len(), 3);
+        assert!(tree
+            .deps
+            .iter()
+            .all(|dep| dep.unexpanded.is_none() && dep.modules.is_none()));
+    }
+
+    #[test]
+    fn lays_out_source_trees() {
+        let source = "module dex::pool {\n}\n";
+        let packages = vec![
+            json!({
+                "name": "Dex",
+                "upgrade_policy": {"policy": 1},
+                "manifest": gzip_hex(b"[package]\nname = \"Dex\"\n"),
+                "modules": [{"name": "pool", "source": gzip_hex(source.as_bytes()), "source_map": gzip_hex(&[1, 2, 3])}],
+                "deps": [
+                    {"account": "0x1", "package_name": "AptosFramework"},
+                    {"account": "0xcafe", "package_name": "DexCore"},
+                    {"account": "0xbeef", "package_name": "Oracle"},
+                ],
+            }),
+            json!({"name": "Unused", "manifest": "0x", "modules": [], "deps": []}),
+        ];
+        let sources = vec![ModuleSource {
+            package: "Dex".to_owned(),
+            module: "pool".to_owned(),
+            source: source.to_owned(),
+        }];
+        let files =
+            source_tree_files("0xcafe", &packages, &sources, DEFAULT_MAX_SOURCE_BYTES).unwrap();
+        let paths: Vec<String> = files
+            .iter()
+            .map(|(path, _)| path.display().to_string())
+            .collect();
+        assert_eq!(
+            paths,
+            [
+                "Dex/Move.toml",
+                "Dex/Move.published.toml",
+                "Dex/sources/pool.move",
+                "Dex/source_maps/pool.mvsm",
+            ]
+        );
+        assert_eq!(
+            String::from_utf8(files[0].1.clone()).unwrap(),
+            "[package]
+name = \"Dex\"
+version = \"1.0.0\"
+upgrade_policy = \"compatible\"
+
+[addresses]
+dex = \"0xcafe\"
+
+[dependencies]
+AptosFramework = { git = \"https://github.com/aptos-labs/aptos-framework.git\", rev = \"mainnet\", subdir = \"aptos-framework\" }
+DexCore = { local = \"../DexCore\" }
+# Oracle is published at 0x000000000000000000000000000000000000000000000000000000000000beef
+"
+        );
+        assert_eq!(files[1].1, b"[package]\nname = \"Dex\"\n");
+        assert_eq!(files[2].1, source.as_bytes());
+        assert_eq!(files[3].1, [1, 2, 3]);
+    }
+
+    fn sample_transfer() -> Transfer {
+        Transfer {
+            from: "0x2".to_owned(),
+            to: "0x0a".to_owned(),
+            amount: "1.5".to_owned(),
+            asset: "APT".to_owned(),
+            version: 7,
+            hash: "0x7e57".to_owned(),
+            timestamp: "2023-11-14T22:13:20Z".to_owned(),
+            function: "0x1::aptos_account::transfer_coins".to_owned(),
+            asset_id: "0x1::aptos_coin::AptosCoin".to_owned(),
+            raw_amount: "150000000".to_owned(),
+            decimals: Some(8),
+            event_index: 0,
+            from_label: None,
+            to_label: None,
+            to_name: None,
+            failed: None,
+        }
+    }
+
+    fn swap_transfer(amount: &str) -> Transfer {
+        Transfer {
+            asset: "USDC".to_owned(),
+            amount: amount.to_owned(),
+            function: "0xbeef::router::swap_exact_input".to_owned(),
+            ..sample_transfer()
+        }
+    }
+
+    #[test]
+    fn date_range_scan_stops_at_the_lower_bound() {
+        // Sequence number `n` committed at version `10n`.
+        let history = |start: u64, limit: u64| -> Vec<Value> {
+            (start..start + limit)
+                .map(|seq| json!({"sequence_number": seq.to_string(), "version": (seq * 10).to_string()}))
+                .collect()
+        };
+        let scan = |range: VersionRange, limit: u64| {
+            let mut pages = Vec::new();
+            let txs = scan_account_transactions(1000, range, limit, |start, limit| {
+                pages.push(start);
+                Ok(history(start, limit))
+            })
+            .unwrap();
+            let versions: Vec<u64> = txs
+                .iter()
+                .map(|tx| parse_u64(&tx["version"]).unwrap())
+                .collect();
+            (versions, pages)
+        };
+
+        let (versions, pages) = scan(
+            VersionRange {
+                since: Some(9_000),
+                until: Some(9_800),
+            },
+            25,
+        );
+        assert_eq!(versions.len(), 80);
+        assert_eq!((versions[0], versions[79]), (9_000, 9_790));
+        assert_eq!(pages, [900, 800], "stops at the first older transaction");
+
+        let (versions, pages) = scan(
+            VersionRange {
+                since: None,
+                until: Some(5_005),
+            },
+            3,
+        );
+        assert_eq!(versions, [4_980, 4_990, 5_000]);
+        assert_eq!(pages, [900, 800, 700, 600, 500, 400]);
+    }
+
+    #[test]
+    fn start_resumes_mid_history() {
+        let history: Vec<Value> = (0..250u64)
+            .map(|sequence| json!({"sequence_number": sequence.to_string()}))
+            .collect();
+        let mut requests = Vec::new();
+        let mut window = |start: u64, limit: u64| {
+            sends_from(start, limit, |start, limit| {
+                requests.push((start, limit));
+                let end = (start + limit).min(history.len() as u64);
+                Ok(history[(start as usize).min(history.len())..end as usize].to_vec())
+            })
+            .unwrap()
+        };
+        let sequences = |txs: &[Value]| -> Vec<u64> {
+            txs.iter()
+                .map(|tx| parse_u64(&tx["sequence_number"]).unwrap())
+                .collect()
+        };
+
+        let (txs, next_start) = window(0, 120);
+        assert_eq!(sequences(&txs), (0..120).collect::<Vec<_>>());
+        assert_eq!(next_start, 120);
+        let (txs, next_start) = window(next_start, 120);
+        assert_eq!(sequences(&txs), (120..240).collect::<Vec<_>>());
+        let (txs, next_start) = window(next_start, 120);
+        assert_eq!(sequences(&txs), (240..250).collect::<Vec<_>>());
+        assert_eq!(next_start, 250);
+        // Caught up: nothing new, and the cursor stays put.
+        let (txs, next_start) = window(next_start, 120);
+        assert!(txs.is_empty());
+        assert_eq!(next_start, 250);
+        drop(window);
+        assert_eq!(
+            requests,
+            [
+                (0, 100),
+                (100, 20),
+                (120, 100),
+                (220, 20),
+                (240, 100),
+                (250, 100)
+            ]
+        );
+    }
+
+    #[test]
+    fn json_envelope_matches_schema() {
+        let envelope = SendsEnvelope {
+            transfers: vec![sample_transfer()],
+            next_start: 42,
+            scanned: 25,
+        };
+        let output = serde_json::to_value(&envelope).unwrap();
+        assert_eq!(
+            (output["next_start"].as_u64(), output["scanned"].as_u64()),
+            (Some(42), Some(25))
+        );
+        validate(&sends_output_schema(), &output).unwrap();
+    }
+
+    #[test]
+    fn sends_scan_collects_until_enough_transfers() {
+        // Sequence number `n` committed at version `10n`; every tenth
+        // transaction is an APT transfer and every hundredth a two-way batch.
+        let history = |start: u64, limit: u64| -> Vec<Value> {
+            (start..start + limit)
+                .map(|seq| {
+                    let payload = match seq {
+                        seq if seq % 100 == 0 => json!({
+                            "type": "entry_function_payload",
+                            "function": "0x1::aptos_account::batch_transfer",
+                            "arguments": [["0x3", "0x4"], ["1", "2"]],
+                        }),
+                        seq if seq % 10 == 0 => json!({
+                            "type": "entry_function_payload",
+                            "function": "0x1::aptos_account::transfer",
+                            "arguments": ["0x3", "1"],
+                        }),
+                        _ => json!({
+                            "type": "entry_function_payload",
+                            "function": "0x1::object::transfer_call",
+                            "arguments": ["0x3", "0x4"],
+                        }),
+                    };
+                    json!({
+                        "type": "user_transaction",
+                        "version": (seq * 10).to_string(),
+                        "payload": payload,
+                    })
+                })
+                .collect()
+        };
+        let scan = |range: VersionRange, wanted: u64, cap: u64| {
+            let mut found = Vec::new();
+            let scan = scan_for_sends(
+                1000,
+                range,
+                wanted,
+                cap,
+                |start, limit| Ok(history(start, limit)),
+                |tx| {
+                    let sends = transfer_payload(tx).map_or(0, |payload| payload.recipients.len());
+                    if sends > 0 {
+                        found.push(parse_u64(&tx["version"]).unwrap());
+                    }
+                    Ok(sends as u64)
+                },
+            )
+            .unwrap();
+            found.reverse();
+            let versions: Vec<u64> = scan
+                .transactions
+                .iter()
+                .map(|tx| parse_u64(&tx["version"]).unwrap())
+                .collect();
+            assert_eq!(found, versions, "reports each match as it is found");
+            (versions, scan.scanned, scan.capped)
+        };
+
+        let (versions, scanned, capped) = scan(VersionRange::default(), 3, 10_000);
+        assert_eq!(versions, [9_700, 9_800, 9_900]);
+        assert_eq!((scanned, capped), (30, false), "stops at the third send");
+
+        let (versions, scanned, capped) = scan(VersionRange::default(), 500, 10_000);
+        assert_eq!(versions.len(), 100);
+        assert_eq!((scanned, capped), (1000, false), "history exhausted");
+
+        let (versions, scanned, capped) = scan(VersionRange::default(), 500, 250);
+        assert_eq!(versions.len(), 25);
+        assert_eq!((scanned, capped), (250, true));
+
+        let range = VersionRange {
+            since: Some(9_000),
+            until: Some(9_500),
+        };
+        let (versions, _, capped) = scan(range, 500, 10_000);
+        assert_eq!(versions, [9_000, 9_100, 9_200, 9_300, 9_400]);
+        assert!(!capped);
+    }
+
+    #[test]
+    fn direct_transfer_records_entry_function() {
+        let client = AptosClient::new("http://127.0.0.1:9/v1").unwrap();
+        let tx = serde_json::json!({
+            "type": "user_transaction",
+            "version": "42",
+            "sender": "0x2",
+            "payload": {
+                "type": "entry_function_payload",
+                "function": "0x1::coin::transfer",
+                "type_arguments": ["0x1::aptos_coin::AptosCoin"],
+                "arguments": ["0x3", "150000000"],
+            },
+        });
+        let transfers = extract_transfers(&client, &tx);
+        assert_eq!(transfers.len(), 1);
+        let transfer = &transfers[0];
+        assert_eq!(transfer.function, "0x1::coin::transfer");
+        assert_eq!(transfer.amount, "1.5");
+        assert_eq!(transfer.asset, "APT");
+    }
+
+    /// Entry function payloads as the node returns them for APT sends from
+    /// ordinary wallets.
+    #[test]
+    fn apt_only_transfers_are_recorded_as_apt() {
+        let client = AptosClient::new("http://127.0.0.1:9/v1").unwrap();
+        for (function, arguments) in [
+            (
+                "0x1::aptos_account::transfer",
+                serde_json::json!([
+                    "0x9f7c3a8e5d2b1a0c4e6f8d9b7a5c3e1f2d4b6a8c0e9f7d5b3a1c2e4f6d8b0a9c",
+                    "2500000"
+                ]),
+            ),
+            (
+                "0x1::aptos_account::fungible_transfer_only",
+                serde_json::json!([
+                    "0x9f7c3a8e5d2b1a0c4e6f8d9b7a5c3e1f2d4b6a8c0e9f7d5b3a1c2e4f6d8b0a9c",
+                    "2500000"
+                ]),
+            ),
+        ] {
+            let tx = serde_json::json!({
+                "type": "user_transaction",
+                "version": "2145093887",
+                "sender": "0x5e8b0c1d2a3f4e5d6c7b8a9f0e1d2c3b4a5f6e7d8c9b0a1f2e3d4c5b6a7f8e9d",
+                "success": true,
+                "payload": {
+                    "type": "entry_function_payload",
+                    "function": function,
+                    "type_arguments": [],
+                    "arguments": arguments,
+                },
+            });
+            let transfers = extract_transfers(&client, &tx);
+            assert_eq!(transfers.len(), 1);
+            let transfer = &transfers[0];
+            assert_eq!(
+                transfer.to,
+                "0x9f7c3a8e5d2b1a0c4e6f8d9b7a5c3e1f2d4b6a8c0e9f7d5b3a1c2e4f6d8b0a9c"
+            );
+            assert_eq!(transfer.amount, "0.025");
+            assert_eq!(transfer.asset, "APT");
+            assert_eq!(transfer.asset_id, APTOS_COIN_TYPE);
+            assert_eq!(transfer.function, function);
+            assert_eq!(transfer.version, 2_145_093_887);
+        }
+
+        let short = serde_json::json!({
+            "type": "user_transaction",
+            "payload": {
+                "type": "entry_function_payload",
+                "function": "0x1::aptos_account::transfer",
+                "type_arguments": [],
+                "arguments": ["0x3"],
+            },
+        });
+        assert!(extract_transfers(&client, &short).is_empty());
+    }
+
+    #[test]
+    fn asset_filter_matches_ids_and_symbols() {
+        let usdc = "0xbae207659db88bea0cbead6da0ed00aac12edcdda169e591cd41c94180b46f3b";
+        let by_symbol = AssetFilter::new("usdc");
+        assert!(by_symbol.matches(usdc, "USDC"));
+        assert!(!by_symbol.matches(usdc, "USDT"));
+
+        let by_address = AssetFilter::new(&usdc.to_uppercase().replacen("0X", "0x", 1));
+        assert!(by_address.matches(usdc, "USDC"));
+        assert!(!by_address.matches("0xa", "USDC2"));
+
+        let by_coin_type = AssetFilter::new("0x1::aptos_coin::AptosCoin");
+        assert!(by_coin_type.matches(
+            "0x0000000000000000000000000000000000000000000000000000000000000001::aptos_coin::AptosCoin",
+            "APT"
+        ));
+        assert!(by_coin_type.matches(APTOS_COIN_TYPE, "APT"));
+        assert!(!by_coin_type.matches("0x1::aptos_coin::Other", "OTHER"));
+
+        // Short and long forms of a metadata address are the same asset.
+        assert!(AssetFilter::new("0x00a").matches("0xa", "APT"));
+    }
+
+    #[test]
+    fn min_amount_compares_raw_amounts_exactly() {
+        let min = parse_min_amount("100.5").unwrap();
+        let raw = |value: &str| BigInt::from_str(value).unwrap();
+        // 6 decimals: 100.5 is 100_500_000 base units.
+        assert!(min.allows(&raw("100500000"), 6));
+        assert!(!min.allows(&raw("100499999"), 6));
+        // More threshold digits than the asset has decimals.
+        assert!(parse_min_amount("0.015").unwrap().allows(&raw("2"), 2));
+        assert!(!parse_min_amount("0.015").unwrap().allows(&raw("1"), 2));
+        assert!(parse_min_amount("0").unwrap().allows(&raw("0"), 0));
+        for bad in ["", ".", "-1", "1e3", "1.2.3", "abc"] {
+            assert!(parse_min_amount(bad).is_err(), "{bad}");
+        }
+
+        let transfer = |raw_amount: &str, decimals: Option<u8>| Transfer {
+            raw_amount: raw_amount.to_owned(),
+            decimals,
+            event_index: 0,
+            ..sample_transfer()
+        };
+        let mut options = SendsOptions {
+            canonical_addresses: false,
+            labels: None,
+            asset: None,
+            min_amount: Some(&min),
+            strict: false,
+            from_events: None,
+            include_failed: false,
+            names: None,
+        };
+        assert!(options.keeps(&transfer("10050000000", Some(8))));
+        assert!(!options.keeps(&transfer("10049999999", Some(8))));
+        assert!(options.keeps(&transfer("5", None)), "unknown decimals kept");
+        options.strict = true;
+        assert!(!options.keeps(&transfer("5", None)));
+        assert!(options.keeps(&transfer("10050000000", Some(8))));
+    }
+
+    #[test]
+    fn sends_metadata_is_warmed_for_every_asset() {
+        // Nothing listens on port 9, so every lookup falls back at once.
+        let client = AptosClient::new("http://127.0.0.1:9/v1").unwrap();
+        let txs: Vec<Value> = (0..20)
+            .map(|index| {
+                json!({
+                    "type": "user_transaction",
+                    "version": index.to_string(),
+                    "sender": "0x2",
+                    "payload": {
+                        "type": "entry_function_payload",
+                        "function": "0x1::coin::transfer",
+                        // Two transactions per asset.
+                        "type_arguments": [format!("0x{:x}::warm::T", 0xa0 + index / 2)],
+                        "arguments": ["0x3", "1000000"],
+                    },
+                })
+            })
+            .collect();
+
+        warm_sends_metadata(&client, &txs);
+        for index in 0..10 {
+            let asset = format!("0x{:x}::warm::T", 0xa0 + index);
+            assert!(
+                assets::resolver()
+                    .cached(client.base_url(), &asset)
+                    .is_some(),
+                "{asset} not warmed"
+            );
+        }
+
+        // Extraction then reads the cache, with the same output as lazy lookups.
+        let transfers = extract_transfers(&client, &txs[3]);
+        assert_eq!(
+            (transfers[0].amount.as_str(), transfers[0].asset.as_str()),
+            ("1000000", "0xa1::...m::T")
+        );
+    }
+
+    /// Write set entries for a fungible store of `metadata` owned by `owner`.
+    fn fungible_store_changes(address: &str, owner: &str, metadata: &str) -> [Value; 2] {
+        [
+            serde_json::json!({
+                "type": "write_resource",
+                "address": address,
+                "data": {"type": "0x1::object::ObjectCore", "data": {"owner": owner}},
+            }),
+            serde_json::json!({
+                "type": "write_resource",
+                "address": address,
+                "data": {
+                    "type": "0x1::fungible_asset::FungibleStore",
+                    "data": {"metadata": {"inner": metadata}, "balance": "0"},
+                },
+            }),
+        ]
+    }
+
+    /// Resolves the APT fungible asset at `0xa` without asking the node.
+    fn seed_apt_fungible_asset(client: &AptosClient) {
+        assets::resolver().insert(
+            client.base_url(),
+            "0xa",
+            AssetMetadata {
+                symbol: "APT".to_owned(),
+                decimals: 8,
+                decimals_known: true,
+            },
+        );
+    }
+
+    fn event(kind: &str, store: &str, amount: &str) -> Value {
+        serde_json::json!({
+            "type": format!("0x1::fungible_asset::{kind}"),
+            "data": {"store": store, "amount": amount},
+        })
+    }
+
+    #[test]
+    fn from_events_adds_nonstandard_sends_once() {
+        let client = AptosClient::new("http://127.0.0.1:9/v1").unwrap();
+        seed_apt_fungible_asset(&client);
+        let changes: Vec<Value> = [
+            ("0x51", "0x5", "0xa"),
+            ("0x61", "0x6", "0xa"),
+            ("0x52", "0x5", "0xbeef"),
+            ("0x72", "0x7", "0xbeef"),
+        ]
+        .into_iter()
+        .flat_map(|(store, owner, metadata)| fungible_store_changes(store, owner, metadata))
+        .collect();
+        let tx = |function: &str, arguments: Value, events: Vec<Value>| {
+            serde_json::json!({
+                "type": "user_transaction",
+                "version": "1935300000",
+                "sender": "0x5",
+                "success": true,
+                "payload": {
+                    "type": "entry_function_payload",
+                    "function": function,
+                    "type_arguments": [],
+                    "arguments": arguments,
+                },
+                "changes": changes,
+                "events": events,
+            })
+        };
+        let mut options = SendsOptions {
+            canonical_addresses: false,
+            labels: None,
+            asset: None,
+            min_amount: None,
+            strict: false,
+            from_events: Some("0x5"),
+            include_failed: false,
+            names: None,
+        };
+        let rows = |tx: &Value, options: &SendsOptions<'_>| -> Vec<(String, String, String)> {
+            sends_of(&client, tx, options)
+                .into_iter()
+                .map(|t| (t.to, t.amount, t.function))
+                .collect()
+        };
+
+        // A router swap: APT into the pool, the other asset back.
+        let swap = tx(
+            "0xc0de::router::swap_exact_input",
+            serde_json::json!(["100000000", "1"]),
+            vec![
+                event("Withdraw", "0x51", "100000000"),
+                event("Deposit", "0x61", "100000000"),
+                event("Withdraw", "0x72", "42"),
+                event("Deposit", "0x52", "42"),
+            ],
+        );
+        assert_eq!(
+            rows(&swap, &options),
+            [(
+                "0x6".to_owned(),
+                "1".to_owned(),
+                "0xc0de::router::swap_exact_input".to_owned()
+            )]
+        );
+
+        // The same movement is found in the payload and in the events.
+        let transfer = tx(
+            "0x1::aptos_account::transfer",
+            serde_json::json!(["0x6", "100000000"]),
+            vec![
+                event("Withdraw", "0x51", "100000000"),
+                event("Deposit", "0x61", "100000000"),
+            ],
+        );
+        assert_eq!(
+            rows(&transfer, &options),
+            [(
+                "0x6".to_owned(),
+                "1".to_owned(),
+                "0x1::aptos_account::transfer".to_owned()
+            )]
+        );
+
+        options.from_events = None;
+        assert!(rows(&swap, &options).is_empty());
+    }
+
+    #[test]
+    fn receives_pair_deposits_with_their_senders() {
+        let client = AptosClient::new("http://127.0.0.1:9/v1").unwrap();
+        seed_apt_fungible_asset(&client);
+        let changes: Vec<Value> = [
+            ("0x51", "0x5", "0xa"),
+            ("0x61", "0x6", "0xa"),
+            ("0x52", "0x5", "0xbeef"),
+            ("0x72", "0x7", "0xbeef"),
+        ]
+        .into_iter()
+        .flat_map(|(store, owner, metadata)| fungible_store_changes(store, owner, metadata))
+        .collect();
+        let swap = serde_json::json!({
+            "type": "user_transaction",
+            "version": "1935300000",
+            "hash": "0x5ab0",
+            "sender": "0x5",
+            "success": true,
+            "payload": {
+                "type": "entry_function_payload",
+                "function": "0xc0de::router::swap_exact_input",
+                "type_arguments": [],
+                "arguments": ["100000000", "1"],
+            },
+            "changes": changes,
+            "events": [
+                event("Withdraw", "0x51", "100000000"),
+                event("Deposit", "0x61", "100000000"),
+                event("Withdraw", "0x72", "42"),
+                event("Deposit", "0x52", "42"),
+            ],
+        });
+        let rows = |owner: &str| -> Vec<(String, String, String)> {
+            received_transfers(&client, &swap, &normalize_address(owner))
+                .into_iter()
+                .map(|t| (t.from, t.raw_amount, t.asset_id))
+                .collect()
+        };
+        assert_eq!(
+            rows("0x5"),
+            [("0x7".to_owned(), "42".to_owned(), "0xbeef".to_owned())]
+        );
+        assert_eq!(
+            rows("0x6"),
+            [("0x5".to_owned(), "100000000".to_owned(), "0xa".to_owned())]
+        );
+
+        let received = received_transfers(&client, &swap, &normalize_address("0x6"));
+        assert_eq!(received[0].hash, "0x5ab0");
+        assert_eq!(received[0].function, "0xc0de::router::swap_exact_input");
+        assert_eq!(
+            pretty_receives_lines(&received, AmountStyle::default()),
+            ["[1935300000] 1 APT ← 0x5"]
+        );
+        validate(
+            &receives_output_schema(),
+            &serde_json::to_value(&received).unwrap(),
+        )
+        .unwrap();
+    }
+
+    /// A `coin::transfer` that aborted with `EINSUFFICIENT_BALANCE`.
+    #[test]
+    fn failed_transactions_are_left_out_unless_asked_for() {
+        let client = AptosClient::new("http://127.0.0.1:9/v1").unwrap();
+        let tx = serde_json::json!({
+            "type": "user_transaction",
+            "version": "1935310000",
+            "sender": "0x2",
+            "success": false,
+            "vm_status": "Move abort in 0x1::coin: EINSUFFICIENT_BALANCE(0x10006): Not enough coins to complete transaction",
+            "payload": {
+                "type": "entry_function_payload",
+                "function": "0x1::coin::transfer",
+                "type_arguments": ["0x1::aptos_coin::AptosCoin"],
+                "arguments": ["0x3", "900000000000"],
+            },
+        });
+        let mut options = SendsOptions {
+            canonical_addresses: false,
+            labels: None,
+            asset: None,
+            min_amount: None,
+            strict: false,
+            from_events: None,
+            include_failed: false,
+            names: None,
+        };
+        assert!(sends_of(&client, &tx, &options).is_empty());
+
+        options.include_failed = true;
+        let transfers = sends_of(&client, &tx, &options);
+        assert_eq!(transfers.len(), 1);
+        assert_eq!(transfers[0].failed, Some(true));
+        assert_eq!(serde_json::to_value(&transfers[0]).unwrap()["failed"], true);
+
+        let mut succeeded = tx.clone();
+        succeeded["success"] = Value::Bool(true);
+        let transfers = sends_of(&client, &succeeded, &options);
+        assert_eq!(transfers[0].failed, None);
+        assert!(serde_json::to_value(&transfers[0])
+            .unwrap()
+            .get("failed")
+            .is_none());
+    }
+
+    /// A `coin::transfer` executed through `0x1::multisig_account`, in the
+    /// shape the node returns it: the executor signs, the multisig pays.
+    #[test]
+    fn multisig_transfers_are_sent_from_the_multisig_account() {
+        let client = AptosClient::new("http://127.0.0.1:9/v1").unwrap();
+        let multisig = "0x2b7d6f1a9c3e5b8d0f4a6c2e8b1d3f5a7c9e0b2d4f6a8c1e3b5d7f9a0c2e4b6d";
+        let executor = "0x7e1a3c5b9d2f4e6a8c0b1d3f5e7a9c2b4d6f8e0a1c3b5d7f9e2a4c6b8d0f1e3a";
+        let tx = serde_json::json!({
+            "type": "user_transaction",
+            "version": "1935284716",
+            "hash": "0x4f0c2a6e8b1d3f5a7c9e2b4d6f8a0c1e3b5d7f9a2c4e6b8d0f1a3c5e7b9d2f4a",
+            "sender": executor,
+            "success": true,
+            "timestamp": "1727186491000000",
+            "payload": {
+                "type": "multisig_payload",
+                "multisig_address": multisig,
+                "transaction_payload": {
+                    "type": "entry_function_payload",
+                    "function": "0x1::coin::transfer",
+                    "type_arguments": ["0x1::aptos_coin::AptosCoin"],
+                    "arguments": [
+                        "0x9f7c3a8e5d2b1a0c4e6f8d9b7a5c3e1f2d4b6a8c0e9f7d5b3a1c2e4f6d8b0a9c",
+                        "5000000000"
+                    ],
+                },
+            },
+        });
+        let transfers = extract_transfers(&client, &tx);
+        assert_eq!(transfers.len(), 1);
+        let transfer = &transfers[0];
+        assert_eq!(transfer.from, multisig);
+        assert_eq!(
+            transfer.to,
+            "0x9f7c3a8e5d2b1a0c4e6f8d9b7a5c3e1f2d4b6a8c0e9f7d5b3a1c2e4f6d8b0a9c"
+        );
+        assert_eq!(
+            (transfer.amount.as_str(), transfer.asset.as_str()),
+            ("50", "APT")
+        );
+        assert_eq!(transfer.function, "0x1::coin::transfer");
+        assert!(stored_multisig_payload_address(&tx).is_none());
+    }
+
+    #[test]
+    fn store_transfers_are_reported_between_owners() {
+        let client = AptosClient::new("http://127.0.0.1:9/v1").unwrap();
+        seed_apt_fungible_asset(&client);
+        let sender = "0x3c1e5a7b9d0f2a4c6e8b1d3f5a7c9e0b2d4f6a8c1e3b5d7f9a0c2e4b6d8f1a3c";
+        let recipient = "0x8d2f4a6c8e0b1d3f5a7c9e2b4d6f8a0c1e3b5d7f9a2c4e6b8d0f1a3c5e7b9d2f";
+        let from_store = "0x51a3c5e7b9d0f2a4c6e8b1d3f5a7c9e0b2d4f6a8c1e3b5d7f9a0c2e4b6d8f1a3";
+        let to_store = "0x62b4d6f8a0c1e3b5d7f9a2c4e6b8d0f1a3c5e7b9d2f4a6c8e0b1d3f5a7c9e2b4";
+        let mut changes = fungible_store_changes(from_store, sender, "0xa").to_vec();
+        changes.extend(fungible_store_changes(to_store, recipient, "0xa"));
+        let tx = serde_json::json!({
+            "type": "user_transaction",
+            "version": "2048117305",
+            "hash": "0x9a1c3e5b7d0f2a4c6e8b1d3f5a7c9e2b4d6f8a0c1e3b5d7f9a2c4e6b8d0f1a3c",
+            "sender": sender,
+            "success": true,
+            "timestamp": "1729001234000000",
+            "payload": {
+                "type": "entry_function_payload",
+                "function": "0x1::fungible_asset::transfer",
+                "type_arguments": ["0x1::fungible_asset::FungibleStore"],
+                "arguments": [{"inner": from_store}, {"inner": to_store}, "120000000"],
+            },
+            "changes": changes,
+        });
+
+        let transfers = extract_transfers(&client, &tx);
+        assert_eq!(transfers.len(), 1);
+        let transfer = &transfers[0];
+        assert_eq!(
+            (transfer.from.as_str(), transfer.to.as_str()),
+            (sender, recipient)
+        );
+        assert_eq!(
+            (transfer.amount.as_str(), transfer.asset.as_str()),
+            ("1.2", "APT")
+        );
+        assert_eq!(transfer.asset_id, "0xa");
+        assert_eq!(transfer.function, "0x1::fungible_asset::transfer");
+    }
+
+    #[test]
+    fn store_owners_follow_objects_two_levels() {
+        let parents: HashMap<&str, &str> = [
+            ("0xo1", "0xo2"),
+            ("0xo2", "0xalice"),
+            ("0xd1", "0xd2"),
+            ("0xd2", "0xd3"),
+            ("0xd3", "0xd4"),
+        ]
+        .into_iter()
+        .collect();
+        let parent_of = |address: &str| parents.get(address).map(|parent| parent.to_string());
+        assert_eq!(
+            owning_account("0xbob".to_owned(), parent_of).as_deref(),
+            Some("0xbob")
+        );
+        assert_eq!(
+            owning_account("0xo1".to_owned(), parent_of).as_deref(),
+            Some("0xalice")
+        );
+        assert!(
+            owning_account("0xd1".to_owned(), parent_of).is_none(),
+            "three levels deep"
+        );
+    }
+
+    /// Executions of a call stored at proposal time carry no inner payload,
+    /// so the sends come from the withdraw and deposit events.
+    #[test]
+    fn stored_multisig_calls_fall_back_to_events() {
+        let client = AptosClient::new("http://127.0.0.1:9/v1").unwrap();
+        let changes: Vec<Value> = fungible_store_changes("0x51", "0x5", "0xa")
+            .into_iter()
+            .chain(fungible_store_changes("0x61", "0x6", "0xa"))
+            .collect();
+        let tx = serde_json::json!({
+            "type": "user_transaction",
+            "version": "1935290002",
+            "sender": "0x7",
+            "success": true,
+            "payload": {
+                "type": "multisig_payload",
+                "multisig_address": "0x5",
+                "transaction_payload": null,
+            },
+            "changes": changes,
+            "events": [
+                {"type": "0x1::fungible_asset::Withdraw", "data": {"store": "0x51", "amount": "250000000"}},
+                {"type": "0x1::fungible_asset::Deposit", "data": {"store": "0x61", "amount": "250000000"}},
+            ],
+        });
+        assert_eq!(stored_multisig_payload_address(&tx), Some("0x5"));
+
+        seed_apt_fungible_asset(&client);
+        let transfers = extract_transfers(&client, &tx);
+        let rows: Vec<(&str, &str, &str, &str, &str)> = transfers
+            .iter()
+            .map(|t| {
+                (
+                    t.from.as_str(),
+                    t.to.as_str(),
+                    t.amount.as_str(),
+                    t.asset.as_str(),
+                    t.function.as_str(),
+                )
+            })
+            .collect();
+        assert_eq!(
+            rows,
+            [("0x5", "0x6", "2.5", "APT", MULTISIG_PAYLOAD_FUNCTION)]
+        );
+    }
+
+    #[test]
+    fn batch_transfers_expand_per_recipient() {
+        let client = AptosClient::new("http://127.0.0.1:9/v1").unwrap();
+        let batch = |function: &str, type_arguments: Value, amounts: Value| {
+            serde_json::json!({
+                "type": "user_transaction",
+                "version": "1870021354",
+                "sender": "0x2",
+                "payload": {
+                    "type": "entry_function_payload",
+                    "function": function,
+                    "type_arguments": type_arguments,
+                    "arguments": [["0x3", "0x4", "0x5"], amounts],
+                },
+            })
+        };
+
+        let tx = batch(
+            "0x1::aptos_account::batch_transfer",
+            serde_json::json!([]),
+            serde_json::json!(["100000000", "25000000", "1"]),
+        );
+        let transfers = extract_transfers(&client, &tx);
+        let rows: Vec<(&str, &str, &str, u64)> = transfers
+            .iter()
+            .map(|t| {
+                (
+                    t.to.as_str(),
+                    t.amount.as_str(),
+                    t.asset.as_str(),
+                    t.version,
+                )
+            })
+            .collect();
+        assert_eq!(
+            rows,
+            [
+                ("0x3", "1", "APT", 1_870_021_354),
+                ("0x4", "0.25", "APT", 1_870_021_354),
+                ("0x5", "0.00000001", "APT", 1_870_021_354),
+            ]
+        );
+        assert!(transfers
+            .iter()
+            .all(|t| t.function == "0x1::aptos_account::batch_transfer" && t.from == "0x2"));
+        // Export keys come from the payload position, so a filter that drops
+        // the first send leaves the others' indexes alone.
+        let kept: Vec<u64> = transfers
+            .iter()
+            .filter(|t| t.to != "0x3")
+            .map(|t| t.event_index)
+            .collect();
+        assert_eq!(kept, [1, 2]);
+
+        let tx = batch(
+            "0x1::aptos_account::batch_transfer_coins",
+            serde_json::json!(["0x1::aptos_coin::AptosCoin"]),
+            serde_json::json!(["100000000", "200000000", "300000000"]),
+        );
+        let amounts: Vec<String> = extract_transfers(&client, &tx)
+            .into_iter()
+            .map(|t| t.amount)
+            .collect();
+        assert_eq!(amounts, ["1", "2", "3"]);
+
+        let mismatched = batch(
+            "0x1::aptos_account::batch_transfer",
+            serde_json::json!([]),
+            serde_json::json!(["1", "2"]),
+        );
+        assert!(extract_transfers(&client, &mismatched).is_empty());
+    }
+
+    #[test]
+    fn groups_amounts_per_function_and_asset() {
+        let transfers = vec![
+            sample_transfer(),
+            swap_transfer("10.25"),
+            sample_transfer(),
+            swap_transfer("0.755"),
+        ];
+        let totals = group_by_function(&transfers);
+        let output = serde_json::to_value(&totals).unwrap();
+        assert_eq!(
+            output,
+            serde_json::json!([
+                {"function": "0x1::aptos_account::transfer_coins", "asset": "APT", "count": 2, "amount": "3"},
+                {"function": "0xbeef::router::swap_exact_input", "asset": "USDC", "count": 2, "amount": "11.005"},
+            ])
+        );
+        validate(&sends_output_schema(), &output).unwrap();
+
+        let lines = pretty_sends_lines(
+            &[swap_transfer("1")],
+            true,
+            false,
+            false,
+            AmountStyle::default(),
+        );
+        assert!(lines[0].ends_with("→ 0x0a  router::swap_exact_input"));
+        let lines = pretty_sends_lines(
+            &[swap_transfer("1")],
+            true,
+            false,
+            true,
+            AmountStyle::default(),
+        );
+        assert!(lines[0].ends_with("\x1b[2mrouter::swap_exact_input\x1b[0m"));
+        assert!(!pretty_sends_lines(
+            &[swap_transfer("1")],
+            false,
+            false,
+            true,
+            AmountStyle::default()
+        )[0]
+        .contains("router"));
+    }
+
+    #[test]
+    fn transfer_json_keeps_addresses_by_default() {
+        let rendered = serde_json::to_string(&sample_transfer()).unwrap();
+        assert_eq!(
+            rendered,
+            r#"{"from":"0x2","to":"0x0a","amount":"1.5","asset":"APT","version":7,"hash":"0x7e57","timestamp":"2023-11-14T22:13:20Z","function":"0x1::aptos_account::transfer_coins"}"#
+        );
+    }
+
+    #[test]
+    fn transfer_json_matches_golden_output() {
+        let client = AptosClient::new("http://127.0.0.1:9/v1").unwrap();
+        let tx = serde_json::json!({
+            "type": "user_transaction",
+            "version": "1870021354",
+            "hash": "0x4c1f0e7b2d9a8c6f5e3b1a0d9c8e7f6a5b4c3d2e1f0a9b8c7d6e5f4a3b2c1d0e",
+            "timestamp": "1717171717123456",
+            "sender": "0x2",
+            "payload": {
+                "type": "entry_function_payload",
+                "function": "0x1::aptos_account::transfer",
+                "type_arguments": [],
+                "arguments": ["0x3", "150000000"],
+            },
+        });
+        let transfers = extract_transfers(&client, &tx);
+        assert_eq!(
+            serde_json::to_string_pretty(&transfers).unwrap(),
+            r#"[
+  {
+    "from": "0x2",
+    "to": "0x3",
+    "amount": "1.5",
+    "asset": "APT",
+    "version": 1870021354,
+    "hash": "0x4c1f0e7b2d9a8c6f5e3b1a0d9c8e7f6a5b4c3d2e1f0a9b8c7d6e5f4a3b2c1d0e",
+    "timestamp": "2024-05-31T16:08:37Z",
+    "function": "0x1::aptos_account::transfer"
+  }
+]"#
+        );
+        validate(
+            &sends_output_schema(),
+            &serde_json::to_value(&transfers).unwrap(),
+        )
+        .unwrap();
+
+        let lines = pretty_sends_lines(&transfers, false, true, false, AmountStyle::default());
+        assert_eq!(lines, ["[2024-05-31T16:08:37Z] 1.5 APT → 0x3"]);
+        let lines = pretty_sends_lines(&transfers, false, false, false, AmountStyle::default());
+        assert_eq!(lines, ["[1870021354] 1.5 APT → 0x3"]);
+    }
+
+    #[test]
+    fn asset_totals_sum_raw_amounts_biggest_first() {
+        let usdc = |raw: &str| Transfer {
+            asset: "USDC".to_owned(),
+            asset_id: "0xbae207659db88bea0cbead6da0ed00aac12edcdda169e591cd41c94180b46f3b"
+                .to_owned(),
+            raw_amount: raw.to_owned(),
+            decimals: Some(6),
+            event_index: 0,
+            ..sample_transfer()
+        };
+        let transfers = vec![
+            sample_transfer(),
+            usdc("2000000"),
+            sample_transfer(),
+            usdc("250000"),
+            Transfer {
+                failed: Some(true),
+                raw_amount: "900000000000".to_owned(),
+                ..sample_transfer()
+            },
+        ];
+        let totals = asset_totals(&transfers);
+        let rows: Vec<(&str, u64, String)> = totals
+            .iter()
+            .map(|t| (t.asset.as_str(), t.count, t.raw.to_string()))
+            .collect();
+        assert_eq!(
+            rows,
+            [
+                ("APT", 2, "300000000".to_owned()),
+                ("USDC", 2, "2250000".to_owned())
+            ]
+        );
+        assert_eq!(
+            pretty_asset_totals_lines(&totals, AmountStyle::default()),
+            [
+                "total    3 APT  in 2 transfers",
+                "total 2.25 USDC in 2 transfers"
+            ]
+        );
+
+        // 3 APT against 20.25 USDC: the decimals differ, the order follows
+        // the decimal totals.
+        let totals = asset_totals(&[sample_transfer(), sample_transfer(), usdc("20250000")]);
+        assert_eq!(totals[0].asset, "USDC");
+        assert!(asset_totals(&[]).is_empty());
+    }
+
+    #[test]
+    fn pretty_sends_show_recipient_names() {
+        let named = Transfer {
+            to: "0x8f4e2b7a1c3d5e6f8a9b0c1d2e3f4a5b6c7d8e9f0a1b2c3d4e5f6a7b8c9dabcd".to_owned(),
+            to_name: Some("alice.apt".to_owned()),
+            to_label: Some("Alice".to_owned()),
+            ..sample_transfer()
+        };
+        let lines = pretty_sends_lines(
+            &[named, sample_transfer()],
+            false,
+            false,
+            false,
+            AmountStyle::default(),
+        );
+        assert_eq!(
+            lines,
+            [
+                format!(
+                    "[7] 1.5 APT → alice.apt ({}) (Alice)",
+                    shorten_addr(
+                        "0x8f4e2b7a1c3d5e6f8a9b0c1d2e3f4a5b6c7d8e9f0a1b2c3d4e5f6a7b8c9dabcd"
+                    )
+                ),
+                "[7] 1.5 APT → 0x0a".to_owned()
+            ]
+        );
+    }
+
+    #[test]
+    fn pretty_sends_mark_failed_rows() {
+        let failed = Transfer {
+            version: 8,
+            failed: Some(true),
+            ..sample_transfer()
+        };
+        let lines = pretty_sends_lines(
+            &[sample_transfer(), failed],
+            false,
+            false,
+            false,
+            AmountStyle::default(),
+        );
+        assert_eq!(lines, ["  [7] 1.5 APT → 0x0a", "✗ [8] 1.5 APT → 0x0a"]);
+    }
+
+    #[test]
+    fn transfer_json_with_canonical_addresses() {
+        let mut transfer = sample_transfer();
+        transfer.canonicalize_addresses();
+        let rendered = serde_json::to_string(&transfer).unwrap();
+        assert_eq!(
+            rendered,
+            format!(
+                r#"{{"from":"0x{:0>64}","to":"0x{:0>64}","amount":"1.5","asset":"APT","version":7,"hash":"0x7e57","timestamp":"2023-11-14T22:13:20Z","function":"0x1::aptos_account::transfer_coins"}}"#,
+                "2", "a"
+            )
+        );
+    }
+
+    #[test]
+    fn labels_augment_without_replacing_addresses() {
+        let mut transfer = sample_transfer();
+        transfer.to = "0x1".to_owned();
+        transfer.apply_labels(&AddressLabels::default());
+        assert_eq!(transfer.to_label, None);
+
+        let mut labelled = vec![transfer];
+        labelled.apply_labels(&AddressLabels::load().unwrap_or_default());
+        let output = serde_json::to_value(&labelled).unwrap();
+        assert_eq!(output[0]["to"], "0x1");
+        crate::commands::schema::validate(&sends_output_schema(), &output).unwrap();
+    }
+
+    #[test]
+    fn sends_output_matches_schema() {
+        let output = serde_json::to_value(vec![sample_transfer()]).unwrap();
+        crate::commands::schema::validate(&sends_output_schema(), &output).unwrap();
+    }
+
+    #[test]
+    fn source_code_output_matches_schema() {
+        let sources = vec![ModuleSource {
+            package: "MoveStdlib".to_owned(),
+            module: "vector".to_owned(),
+            source: "module std::vector {}".to_owned(),
+        }];
+        let output = serde_json::to_value(&sources).unwrap();
+        crate::commands::schema::validate(&source_code_output_schema(), &output).unwrap();
+        let output = serde_json::to_value(SourceCodeOutput {
+            sources,
+            errors: vec![SourceDecodeError {
+                package: "MoveStdlib".to_owned(),
+                module: "bcs".to_owned(),
+                reason: "failed to decode source hex".to_owned(),
+            }],
+        })
+        .unwrap();
+        crate::commands::schema::validate(&source_code_output_schema(), &output).unwrap();
+    }
+
+    #[test]
+    fn fuzz_decode_source_never_panics() {
+        // Deterministic xorshift so failures are reproducible without a fuzz harness.
+        let mut state: u64 = 0x9e37_79b9_7f4a_7c15;
+        let mut next = || {
+            state ^= state << 13;
+            state ^= state >> 7;
+            state ^= state << 17;
+            state
+        };
+
+        let valid = gzip_hex(b"module 0x1::fuzz { fun f() {} }");
+        for _ in 0..2_000 {
+            let len = (next() % 64) as usize;
+            let mut bytes: Vec<u8> = (0..len).map(|_| next() as u8).collect();
+            if next() % 2 == 0 {
+                bytes.splice(0..0, GZIP_MAGIC);
+            }
+            let _ = decode_source(&hex::encode(&bytes), 4096);
+
+            let mut mutated = valid.clone().into_bytes();
+            let index = 2 + (next() as usize) % (mutated.len() - 2);
+            mutated[index] = b"0123456789abcdefxyz"[(next() % 19) as usize];
+            mutated.truncate(2 + (next() as usize) % (mutated.len() - 1));
+            let _ = decode_source(&String::from_utf8(mutated).unwrap(), 4096);
+        }
+    }
+
+    #[test]
+    fn parses_nested_coin_store_types() {
+        assert_eq!(
+            coin_store_coin_type("0x1::coin::CoinStore<0x1::aptos_coin::AptosCoin>"),
+            Some("0x1::aptos_coin::AptosCoin")
+        );
+        let lp = "0xbeef::swap::LPCoin<0x1::aptos_coin::AptosCoin, 0xcafe::usdc::USDC<0x2::x::Y>>";
+        assert_eq!(
+            coin_store_coin_type(&format!(
+                "0x0000000000000000000000000000000000000000000000000000000000000001::coin::CoinStore<{lp}>"
+            )),
+            Some(lp)
+        );
+        assert_eq!(coin_store_coin_type("0x1::coin::CoinInfo<0x1::a::B>"), None);
+        assert_eq!(
+            coin_store_coin_type("0x2::coin::CoinStore<0x1::a::B>"),
+            None
+        );
+        assert_eq!(
+            coin_store_coin_type("0x1::coin::CoinStore<0x1::a::B<C>"),
+            None
+        );
+    }
+
+    #[test]
+    fn sorts_coins_by_decimal_amount() {
+        let holding = |coin_type: &str, balance: &str, decimals: u8| CoinHolding {
+            coin_type: coin_type.to_owned(),
+            symbol: coin_type.to_owned(),
+            decimals,
+            balance: balance.to_owned(),
+            amount: format_amount(balance, decimals),
+            frozen: false,
+            paired_metadata: None,
+            fa_balance: None,
+            total: balance.to_owned(),
+            total_amount: format_amount(balance, decimals),
+        };
+        let mut holdings = vec![
+            holding("a", "150000000", 8),
+            holding("b", "2000000", 6),
+            holding("c", "3", 0),
+        ];
+        sort_by_amount(&mut holdings);
+        let order: Vec<(&str, &str)> = holdings
+            .iter()
+            .map(|holding| (holding.coin_type.as_str(), holding.amount.as_str()))
+            .collect();
+        assert_eq!(order, [("c", "3"), ("b", "2"), ("a", "1.5")]);
+        holdings[2].set_paired_balance("0xa".to_owned(), 50_000_000);
+        assert_eq!(holdings[2].total, "200000000");
+        assert_eq!(holdings[2].total_amount, "2");
+        assert_eq!(holdings[2].fa_balance.as_deref(), Some("50000000"));
+        assert_eq!(
+            holdings[2].paired_metadata.as_deref(),
+            Some(normalize_address("0xa").as_str())
+        );
+        validate(
+            &coins_output_schema(),
+            &serde_json::to_value(&holdings).unwrap(),
+        )
+        .unwrap();
+    }
+
+    /// Mock node serving `pages` in order, each with the cursor of the next
+    /// in `X-Aptos-Cursor`; the request lines it saw are sent to the channel.
+    fn paged_node(pages: Vec<&'static str>) -> (String, std::sync::mpsc::Receiver<String>) {
+        use std::io::Write;
+        let listener = std::net::TcpListener::bind("127.0.0.1:0").unwrap();
+        let addr = listener.local_addr().unwrap();
+        let (seen, requests) = std::sync::mpsc::channel();
+        std::thread::spawn(move || {
+            for (index, stream) in listener.incoming().enumerate() {
+                let Ok(mut stream) = stream else { break };
+                let mut buf = [0u8; 4096];
+                let read = stream.read(&mut buf).unwrap_or(0);
+                let request = String::from_utf8_lossy(&buf[..read]);
+                let _ = seen.send(request.lines().next().unwrap_or_default().to_owned());
+                let body = pages.get(index).copied().unwrap_or("[]");
+                let cursor = if index + 1 < pages.len() {
+                    format!("X-Aptos-Cursor: page{}\r\n", index + 1)
+                } else {
+                    String::new()
+                };
+                let response = format!(
+                    "HTTP/1.1 200 OK\r\nContent-Type: application/json\r\n{cursor}Content-Length: {}\r\nConnection: close\r\n\r\n{body}",
+                    body.len()
+                );
+                let _ = stream.write_all(response.as_bytes());
+            }
+        });
+        (format!("http://{addr}/v1"), requests)
+    }
+
+    #[test]
+    fn account_pages_follow_the_cursor() {
+        let pages = vec![r#"[{"type":"0x1::a::A"}]"#, r#"[{"type":"0x1::b::B"}]"#];
+        let (node, requests) = paged_node(pages.clone());
+        let client = AptosClient::new(&node).unwrap();
+        let (items, next) =
+            account_pages(&client, "/accounts/0x1/resources", None, None, true).unwrap();
+        assert_eq!(items.len(), 2, "--all concatenates every page");
+        assert!(next.is_none());
+        let seen: Vec<String> = requests.try_iter().collect();
+        assert!(seen[1].contains("&start=page1"), "{seen:?}");
+
+        let (node, _) = paged_node(pages);
+        let client = AptosClient::new(&node).unwrap();
+        let (items, next) =
+            account_pages(&client, "/accounts/0x1/modules", None, None, false).unwrap();
+        assert_eq!(items.len(), 1);
+        assert_eq!(
+            next.as_deref(),
+            Some("page1"),
+            "one page and where to resume"
+        );
+    }
+
+    #[test]
+    fn summarizes_resources_per_module() {
+        let resources: Vec<Value> = [
+            "0x1::coin::CoinStore<0x1::aptos_coin::AptosCoin>",
+            "0x1::coin::CoinStore<0xcafe::usd::USD>",
+            "0x1::account::Account",
+            "0x1::coin::CoinInfo<0xcafe::usd::USD>",
+            "0x4::token::Token",
+        ]
+        .into_iter()
+        .map(|resource_type| serde_json::json!({"type": resource_type, "data": {}}))
+        .collect();
+        let summary = summarize_resources(&resources);
+        assert_eq!(
+            summary[0],
+            ModuleSummary {
+                module: "0x1::coin".to_owned(),
+                count: 3,
+                types: vec!["CoinInfo".to_owned(), "CoinStore".to_owned()],
+            }
+        );
+        assert_eq!(
+            pretty_module_summary_lines(&summary),
+            ["0x1::coin     3", "0x1::account  1", "0x4::token    1"]
+        );
+        validate(
+            &resources_summary_output_schema(),
+            &serde_json::to_value(&summary).unwrap(),
+        )
+        .unwrap();
+    }
+
+    #[test]
+    fn resource_type_patterns_or_together() {
+        let resource = |resource_type: &str| serde_json::json!({"type": resource_type, "data": {}});
+        let patterns = |patterns: &[&str]| -> Vec<String> {
+            patterns
+                .iter()
+                .map(|pattern| normalize_qualified_name(pattern))
+                .collect()
+        };
+        let coin_store = resource("0x1::coin::CoinStore<0x1::aptos_coin::AptosCoin>");
+        let vesting = resource("0xcafe::vesting::VestingContract");
+        let stake = resource("0x1::stake::StakePool");
+
+        let coins = patterns(&["0x1::coin::CoinStore<*>"]);
+        assert!(resource_type_matches(&coins, &coin_store));
+        assert!(!resource_type_matches(&coins, &stake));
+
+        let either = patterns(&["*::vesting::*", "0x0001::stake::*"]);
+        assert!(resource_type_matches(&either, &vesting));
+        assert!(
+            resource_type_matches(&either, &stake),
+            "long and short addresses match"
+        );
+        assert!(!resource_type_matches(&either, &coin_store));
+
+        assert!(
+            resource_type_matches(&[], &stake),
+            "--count alone counts everything"
+        );
+    }
+
+    #[test]
+    fn asset_balances_keep_per_asset_errors() {
+        let output = asset_balances(
+            4_200,
+            [
+                (APTOS_COIN_TYPE, Ok(150_000_000)),
+                (
+                    "0xbad",
+                    Err(anyhow!("API error (status 404): resource not found")),
+                ),
+            ],
+            |asset| {
+                assert_eq!(asset, APTOS_COIN_TYPE, "failed lookups skip metadata");
+                AssetMetadata {
+                    symbol: "APT".to_owned(),
+                    decimals: 8,
+                    decimals_known: true,
+                }
+            },
+        );
+        assert_eq!(
+            output,
+            json!({
+                "ledger_version": 4200,
+                "balances": {
+                    APTOS_COIN_TYPE: {
+                        "balance": "150000000",
+                        "symbol": "APT",
+                        "decimals": 8,
+                        "formatted": "1.5",
+                    },
+                    "0xbad": {"error": "API error (status 404): resource not found"},
+                },
+            })
+        );
+        validate(&balance_output_schema(), &output).unwrap();
+    }
+
+    #[test]
+    fn balance_decorations_fall_back_to_raw() {
+        let apt = AssetMetadata {
+            symbol: "APT".to_owned(),
+            decimals: 8,
+            decimals_known: true,
+        };
+        assert_eq!(
+            pretty_balance("12345670000", APTOS_COIN_TYPE, &apt),
+            "123.4567 APT (12345670000 octas)"
+        );
+        let unknown = AssetMetadata {
+            symbol: "0x5e1:...Cake".to_owned(),
+            decimals: 0,
+            decimals_known: false,
+        };
+        assert_eq!(
+            pretty_balance("7", "0x5e1f00d::coin::Cake", &unknown),
+            "7 0x5e1f...Cake"
+        );
+        for (decorated, expected) in [
+            (
+                decorated_balance("12345670000", &apt),
+                json!({"balance": "12345670000", "symbol": "APT", "decimals": 8, "formatted": "123.4567"}),
+            ),
+            (decorated_balance("7", &unknown), json!({"balance": "7"})),
+        ] {
+            assert_eq!(decorated, expected);
+            validate(&balance_output_schema(), &decorated).unwrap();
+        }
+        validate(&balance_output_schema(), &json!("12345670000")).unwrap();
+    }
+
+    #[test]
+    fn pretty_balances_align_by_symbol() {
+        let balance = |asset: &str, symbol: &str, raw: &str, decimals: u8| AssetBalance {
+            asset: asset.to_owned(),
+            symbol: symbol.to_owned(),
+            decimals,
+            raw_amount: raw.to_owned(),
+            amount: format_amount(raw, decimals),
+        };
+        let mut balances = vec![
+            balance("0xbae2", "USDC", "1250000000", 6),
+            balance(APT_METADATA_ADDRESS, "APT", "150000000", 8),
+            balance("0x5e1::coin::Cake", "cake", "7", 0),
+        ];
+        sort_by_symbol(&mut balances);
+        assert_eq!(
+            pretty_balances_lines(&balances, AmountStyle::pretty(None)),
+            [
+                "APT     1.5  0xa",
+                "cake      7  0x5e1::coin::Cake",
+                "USDC  1,250  0xbae2",
+            ]
+        );
+        balances[0].amount = format_amount("151234567", 8);
+        assert_eq!(
+            pretty_balances_lines(&balances, AmountStyle::pretty(Some(2))),
+            [
+                "APT    1.51  0xa",
+                "cake      7  0x5e1::coin::Cake",
+                "USDC  1,250  0xbae2",
+            ]
+        );
+        validate(
+            &balances_output_schema(),
+            &serde_json::to_value(&balances).unwrap(),
+        )
+        .unwrap();
+    }
+
+    fn module_fixture() -> Vec<Value> {
+        [
+            ("account", 6, 4000),
+            ("aptos_coin", 3, 900),
+            ("chain_id", 1, 120),
+            ("code", 2, 2500),
+            ("coin", 14, 6000),
+            ("event", 0, 300),
+            ("fungible_asset", 14, 9000),
+            ("guid", 0, 200),
+            ("object", 9, 3100),
+            ("option", 0, 700),
+            ("primary_fungible_store", 7, 1500),
+            ("string_utils", 0, 450),
+        ]
+        .into_iter()
+        .map(|(name, functions, size)| {
+            let exposed: Vec<Value> = (0..functions)
+                .map(|index| json!({"name": format!("f{index}")}))
+                .collect();
+            json!({
+                "bytecode": format!("0x{}", "ab".repeat(size)),
+                "abi": {"address": "0x1", "name": name, "exposed_functions": exposed},
+            })
+        })
+        .collect()
+    }
+
+    fn modules_args() -> ModulesArgs {
+        ModulesArgs {
+            address: "0x1".to_owned(),
+            ledger_version: None,
+            filter: None,
+            exposed_only: false,
+            sort: None,
+            names: false,
+            with_functions: false,
+            cursor: None,
+        }
+    }
+
+    fn selected_names(args: &ModulesArgs) -> Vec<String> {
+        select_modules(module_fixture(), args)
+            .unwrap()
+            .iter()
+            .map(|module| module_name(module).to_owned())
+            .collect()
+    }
+
+    #[test]
+    fn filters_and_sorts_modules() {
+        assert_eq!(selected_names(&modules_args()).len(), 12);
+
+        let args = ModulesArgs {
+            filter: Some("coin|fungible".to_owned()),
+            ..modules_args()
+        };
+        assert_eq!(
+            selected_names(&args),
+            [
+                "aptos_coin",
+                "coin",
+                "fungible_asset",
+                "primary_fungible_store"
+            ]
+        );
+
+        let args = ModulesArgs {
+            exposed_only: true,
+            sort: Some(ModuleSort::Functions),
+            ..modules_args()
+        };
+        let names = selected_names(&args);
+        assert_eq!(names.len(), 8);
+        assert_eq!(names[..3], ["coin", "fungible_asset", "object"]);
+
+        let args = ModulesArgs {
+            filter: Some("^(event|guid|option|object)$".to_owned()),
+            sort: Some(ModuleSort::Size),
+            ..modules_args()
+        };
+        assert_eq!(selected_names(&args), ["object", "option", "event", "guid"]);
+
+        let args = ModulesArgs {
+            filter: Some("^[a-c]".to_owned()),
+            sort: Some(ModuleSort::Name),
+            names: true,
+            ..modules_args()
+        };
+        assert_eq!(
+            selected_names(&args),
+            ["account", "aptos_coin", "chain_id", "code", "coin"]
+        );
+
+        let args = ModulesArgs {
+            filter: Some("(".to_owned()),
+            ..modules_args()
+        };
+        assert!(select_modules(module_fixture(), &args).is_err());
+    }
+
+    #[test]
+    fn name_listings_sort_by_name() {
+        let mut modules = module_fixture();
+        modules.reverse();
+        let args = ModulesArgs {
+            filter: Some("^c".to_owned()),
+            names: true,
+            ..modules_args()
+        };
+        let names: Vec<String> = select_modules(modules.clone(), &args)
+            .unwrap()
+            .iter()
+            .map(|module| module_name(module).to_owned())
+            .collect();
+        assert_eq!(names, ["chain_id", "code", "coin"]);
+
+        let module = json!({"abi": {"name": "router", "exposed_functions": [
+            {"name": "swap", "is_entry": true, "is_view": false},
+            {"name": "quote", "is_entry": false, "is_view": true},
+            {"name": "add_liquidity", "is_entry": true, "is_view": false},
+            {"name": "pool_address", "is_entry": false, "is_view": false},
+        ]}});
+        let counts = vec![ModuleFunctions::of(&module)];
+        assert_eq!(
+            counts[0],
+            ModuleFunctions {
+                name: "router".to_owned(),
+                entry_functions: 2,
+                view_functions: 1,
+            }
+        );
+        validate(
+            &module_functions_output_schema(),
+            &serde_json::to_value(&counts).unwrap(),
+        )
+        .unwrap();
+    }
+
+    #[test]
+    fn lists_function_signatures() {
+        let module = json!({"abi": {"name": "router", "exposed_functions": [
+            {
+                "name": "swap",
+                "visibility": "public",
+                "is_entry": true,
+                "is_view": false,
+                "generic_type_params": [{"constraints": []}, {"constraints": ["key", "store"]}],
+                "params": ["&signer", "u64", "vector<address>"],
+                "return": [],
+            },
+            {
+                "name": "quote",
+                "visibility": "public",
+                "is_entry": false,
+                "is_view": true,
+                "generic_type_params": [],
+                "params": ["address", "u64"],
+                "return": ["u64", "bool"],
+            },
+            {
+                "name": "pool_address",
+                "visibility": "public",
+                "is_entry": false,
+                "is_view": false,
+                "generic_type_params": [],
+                "params": ["&signer"],
+                "return": ["address"],
+            },
+        ]}});
+        let functions = FunctionSignature::list(&module);
+        let lines: Vec<String> = functions.iter().map(FunctionSignature::signature).collect();
+        assert_eq!(
+            lines,
+            [
+                "entry swap<T0, T1: key + store>(u64, vector<address>)",
+                "view quote(address, u64): (u64, bool)",
+            ]
+        );
+        assert_eq!(functions[0].type_params[1].constraints, ["key", "store"]);
+        validate(
+            &module_functions_list_output_schema(),
+            &serde_json::to_value(&functions).unwrap(),
+        )
+        .unwrap();
+    }
+
+    #[test]
+    fn styles_pretty_amounts() {
+        let full = AmountStyle::pretty(None);
+        let six = AmountStyle::pretty(Some(6));
+        let cases = [
+            (full, "0", 8, "0"),
+            (six, "0", 8, "0"),
+            (full, "1", 8, "0.00000001"),
+            (six, "1", 8, "<0.000001"),
+            (six, "99", 8, "<0.000001"),
+            (six, "100", 8, "0.000001"),
+            (AmountStyle::pretty(Some(0)), "5", 1, "<1"),
+            (AmountStyle::pretty(Some(0)), "15", 1, "1"),
+            (full, "123456789123456", 8, "1,234,567.89123456"),
+            (six, "123456789123456", 8, "1,234,567.891234"),
+            (
+                AmountStyle::pretty(Some(2)),
+                "123456789999999",
+                8,
+                "1,234,567.89",
+            ),
+            (AmountStyle::pretty(Some(1)), "100009999", 8, "1"),
+            (six, "100", 0, "100"),
+            (full, "1000", 0, "1,000"),
+            (full, "999999", 3, "999.999"),
+            (
+                full,
+                "18446744073709551615",
+                0,
+                "18,446,744,073,709,551,615",
+            ),
+            (six, "18446744073709551615", 8, "184,467,440,737.095516"),
+            (full, "18446744073709551615", 18, "18.446744073709551615"),
+            (six, "18446744073709551615", 18, "18.446744"),
+        ];
+        for (style, raw, decimals, expected) in cases {
+            assert_eq!(
+                style.apply(&format_amount(raw, decimals)),
+                expected,
+                "{raw} with {decimals} decimals"
+            );
+        }
+
+        let max_scale = format!("0.{}18446744073709551615", "0".repeat(235));
+        assert_eq!(full.apply(&max_scale), max_scale);
+        assert_eq!(six.apply(&max_scale), "<0.000001");
+        assert_eq!(AmountStyle::pretty(Some(255)).apply(&max_scale), max_scale);
+        assert_eq!(full.apply("-1234.5"), "-1,234.5");
+        assert_eq!(six.apply("-0.0000001"), ">-0.000001");
+        assert_eq!(full.apply("abc"), "abc");
+        assert_eq!(AmountStyle::default().apply("1234567.5"), "1234567.5");
+        assert_eq!(AmountStyle::default().apply("1234567"), "1234567");
+    }
+
+    #[test]
+    fn koinly_csv_matches_golden_file() {
+        let txs = serde_json::json!([
+            {"version": "100", "hash": "0xaa01", "timestamp": "1700000000000000", "gas_used": "12", "gas_unit_price": "100"},
+            {"version": "101", "hash": "0xaa02", "timestamp": "1700003600500000", "gas_used": "7", "gas_unit_price": "100"},
+            {"version": "102", "hash": "0xaa03", "timestamp": "1704067199999999", "gas_used": "0", "gas_unit_price": "100"},
+        ]);
+        let unknown = "0x5e1f2b3c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5e6f708192a3b4c5d6e7f8";
+        let transfers = vec![
+            Transfer {
+                version: 100,
+                ..sample_transfer()
+            },
+            Transfer {
+                version: 101,
+                amount: "250.75".to_owned(),
+                ..swap_transfer("0")
+            },
+            Transfer {
+                version: 101,
+                amount: "0.00001".to_owned(),
+                asset: shorten_addr(unknown),
+                asset_id: unknown.to_owned(),
+                ..sample_transfer()
+            },
+            Transfer {
+                version: 102,
+                amount: "3".to_owned(),
+                ..sample_transfer()
+            },
+        ];
+        let golden = format!(
+            "Date,Sent Amount,Sent Currency,Received Amount,Received Currency,Fee Amount,Fee Currency,TxHash
+2023-11-14 22:13,1.5,APT,,,0.000012,APT,0xaa01
+2023-11-14 23:13,250.75,USDC,,,0.000007,APT,0xaa02
+2023-11-14 23:13,0.00001,{unknown},,,,,0xaa02
+2023-12-31 23:59,3,APT,,,,,0xaa03
+"
+        );
+        assert_eq!(koinly_sends(txs.as_array().unwrap(), &transfers), golden);
+    }
+}
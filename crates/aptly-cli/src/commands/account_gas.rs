@@ -0,0 +1,369 @@
+use anyhow::Result;
+use aptly_aptos::AptosClient;
+use clap::Args;
+use serde::Serialize;
+use serde_json::Value;
+use std::collections::HashMap;
+use std::time::{SystemTime, UNIX_EPOCH};
+
+use crate::commands::account::{account_sequence_number, account_transactions_page, format_amount};
+use crate::commands::account_txs::walk_back;
+use crate::commands::common::{
+    get_nested_string, normalize_address, parse_time_bound, parse_u64, parse_utc_offset,
+};
+use crate::commands::schema::{
+    array_schema, integer_schema, nullable, object_schema, string_schema, OutputSchema,
+};
+
+#[derive(Args)]
+pub(crate) struct GasArgs {
+    /// Account address (`0x...`).
+    #[arg(value_name = "ADDRESS")]
+    pub(crate) address: String,
+    /// Maximum number of recent transactions to scan, newest first.
+    #[arg(long, default_value_t = 1000)]
+    pub(crate) limit: u64,
+    /// Stop at transactions before this date (`YYYY-MM-DD` or RFC 3339),
+    /// or a time ago such as `-7d` or `-12h`.
+    #[arg(long, value_name = "DATE", allow_hyphen_values = true)]
+    pub(crate) since: Option<String>,
+    /// UTC offset for a bare `--since` date, e.g. `+02:00`.
+    #[arg(long, value_name = "OFFSET", default_value = "UTC", value_parser = parse_utc_offset)]
+    pub(crate) tz: i64,
+    /// Print an aligned table instead of JSON.
+    #[arg(long, default_value_t = false)]
+    pub(crate) pretty: bool,
+}
+
+#[derive(Debug, Clone, PartialEq, Eq, Serialize)]
+struct GasSpend {
+    address: String,
+    transactions: u64,
+    total_fee_octas: String,
+    total_fee_apt: String,
+    avg_fee_octas: String,
+    max_fee_octas: String,
+    /// Version of the most expensive transaction.
+    max_fee_version: Option<u64>,
+    functions: Vec<FunctionSpend>,
+}
+
+#[derive(Debug, Clone, PartialEq, Eq, Serialize)]
+struct FunctionSpend {
+    /// Entry function id, or the payload type of other transactions.
+    function: String,
+    transactions: u64,
+    total_fee_octas: String,
+    total_fee_apt: String,
+    avg_fee_octas: String,
+    max_fee_octas: String,
+}
+
+impl OutputSchema for FunctionSpend {
+    fn output_schema() -> Value {
+        object_schema(
+            "Gas spent calling one entry function",
+            &[
+                (
+                    "function",
+                    string_schema("Entry function id, or the payload type of scripts"),
+                ),
+                ("transactions", integer_schema("Transactions in the group")),
+                (
+                    "total_fee_octas",
+                    string_schema("Sum of gas_used * gas_unit_price (u128 as string)"),
+                ),
+                ("total_fee_apt", string_schema("`total_fee_octas` in APT")),
+                (
+                    "avg_fee_octas",
+                    string_schema("Fee per transaction, rounded down"),
+                ),
+                (
+                    "max_fee_octas",
+                    string_schema("Largest fee of one transaction"),
+                ),
+            ],
+        )
+    }
+}
+
+impl OutputSchema for GasSpend {
+    fn output_schema() -> Value {
+        object_schema(
+            "Gas fees paid by an account over its recent transactions",
+            &[
+                ("address", string_schema("Account address (64-hex)")),
+                ("transactions", integer_schema("Transactions counted")),
+                (
+                    "total_fee_octas",
+                    string_schema("Sum of gas_used * gas_unit_price (u128 as string)"),
+                ),
+                ("total_fee_apt", string_schema("`total_fee_octas` in APT")),
+                (
+                    "avg_fee_octas",
+                    string_schema("Fee per transaction, rounded down"),
+                ),
+                (
+                    "max_fee_octas",
+                    string_schema("Largest fee of one transaction"),
+                ),
+                (
+                    "max_fee_version",
+                    nullable(integer_schema(
+                        "Version of the most expensive transaction; null without transactions",
+                    )),
+                ),
+                (
+                    "functions",
+                    array_schema(
+                        "Per-function totals sorted by total fee, descending",
+                        FunctionSpend::output_schema(),
+                    ),
+                ),
+            ],
+        )
+    }
+}
+
+pub(crate) fn gas_output_schema() -> Value {
+    GasSpend::output_schema()
+}
+
+/// Running fee totals; integer arithmetic only.
+#[derive(Debug, Default)]
+struct Totals {
+    transactions: u64,
+    fee: u128,
+    max_fee: u128,
+}
+
+impl Totals {
+    fn add(&mut self, fee: u128) {
+        self.transactions += 1;
+        self.fee += fee;
+        self.max_fee = self.max_fee.max(fee);
+    }
+
+    fn avg(&self) -> u128 {
+        self.fee
+            .checked_div(u128::from(self.transactions))
+            .unwrap_or(0)
+    }
+}
+
+/// `account gas`: fees the account paid over its newest transactions.
+pub(crate) fn run_account_gas(client: &AptosClient, args: &GasArgs) -> Result<()> {
+    let address = normalize_address(&args.address);
+    let since = args
+        .since
+        .as_deref()
+        .map(|date| {
+            let now_micros = SystemTime::now()
+                .duration_since(UNIX_EPOCH)
+                .map_or(0, |elapsed| elapsed.as_micros() as u64);
+            parse_time_bound(date, args.tz, false, now_micros)
+        })
+        .transpose()?;
+    let sequence_number = account_sequence_number(client, &address)?;
+    let transactions = walk_back(
+        sequence_number,
+        Some(args.limit),
+        |tx| not_before(since, tx),
+        |start, limit| account_transactions_page(client, &address, Some(start), limit),
+    )?;
+    let spend = gas_spend(&address, &transactions);
+    if args.pretty {
+        for line in pretty_gas_lines(&spend) {
+            println!("{line}");
+        }
+        return Ok(());
+    }
+    crate::print_serialized(&spend)
+}
+
+/// Whether `tx` was committed at or after `since` (microseconds), if given.
+fn not_before(since: Option<u64>, tx: &Value) -> bool {
+    let timestamp = parse_u64(tx.get("timestamp").unwrap_or(&Value::Null)).unwrap_or(0);
+    !since.is_some_and(|since| timestamp < since)
+}
+
+fn gas_spend(address: &str, transactions: &[Value]) -> GasSpend {
+    let mut total = Totals::default();
+    let mut max_fee_version = None;
+    let mut functions: HashMap<String, Totals> = HashMap::new();
+    for tx in transactions {
+        let gas = |key: &str| parse_u64(tx.get(key).unwrap_or(&Value::Null)).unwrap_or(0);
+        let fee = u128::from(gas("gas_used")) * u128::from(gas("gas_unit_price"));
+        if max_fee_version.is_none() || fee > total.max_fee {
+            max_fee_version = tx.get("version").and_then(parse_u64);
+        }
+        total.add(fee);
+        let mut function = get_nested_string(tx, &["payload", "function"]);
+        if function.is_empty() {
+            function = get_nested_string(tx, &["payload", "type"]);
+        }
+        functions.entry(function).or_default().add(fee);
+    }
+    let mut functions: Vec<FunctionSpend> = functions
+        .into_iter()
+        .map(|(function, totals)| FunctionSpend {
+            function,
+            transactions: totals.transactions,
+            total_fee_octas: totals.fee.to_string(),
+            total_fee_apt: format_amount(&totals.fee.to_string(), 8),
+            avg_fee_octas: totals.avg().to_string(),
+            max_fee_octas: totals.max_fee.to_string(),
+        })
+        .collect();
+    functions.sort_by(|a, b| {
+        let fee = |spend: &FunctionSpend| spend.total_fee_octas.parse::<u128>().unwrap_or(0);
+        fee(b)
+            .cmp(&fee(a))
+            .then_with(|| a.function.cmp(&b.function))
+    });
+    GasSpend {
+        address: address.to_owned(),
+        transactions: total.transactions,
+        total_fee_octas: total.fee.to_string(),
+        total_fee_apt: format_amount(&total.fee.to_string(), 8),
+        avg_fee_octas: total.avg().to_string(),
+        max_fee_octas: total.max_fee.to_string(),
+        max_fee_version,
+        functions,
+    }
+}
+
+/// `--pretty`: one row per function with fees in APT, then a total row.
+fn pretty_gas_lines(spend: &GasSpend) -> Vec<String> {
+    let apt = |octas: &str| format!("{} APT", format_amount(octas, 8));
+    let mut rows: Vec<[String; 5]> = vec![[
+        "function".to_owned(),
+        "txs".to_owned(),
+        "total".to_owned(),
+        "avg".to_owned(),
+        "max".to_owned(),
+    ]];
+    rows.extend(spend.functions.iter().map(|function| {
+        [
+            function.function.clone(),
+            function.transactions.to_string(),
+            apt(&function.total_fee_octas),
+            apt(&function.avg_fee_octas),
+            apt(&function.max_fee_octas),
+        ]
+    }));
+    rows.push([
+        "total".to_owned(),
+        spend.transactions.to_string(),
+        apt(&spend.total_fee_octas),
+        apt(&spend.avg_fee_octas),
+        apt(&spend.max_fee_octas),
+    ]);
+    let width = |column: usize| {
+        rows.iter()
+            .map(|row| row[column].chars().count())
+            .max()
+            .unwrap_or(0)
+    };
+    let widths = [width(0), width(1), width(2), width(3), width(4)];
+    rows.iter()
+        .map(|[function, txs, total, avg, max]| {
+            format!(
+                "{function:<w0$}  {txs:>w1$}  {total:>w2$}  {avg:>w3$}  {max:>w4$}",
+                w0 = widths[0],
+                w1 = widths[1],
+                w2 = widths[2],
+                w3 = widths[3],
+                w4 = widths[4],
+            )
+        })
+        .collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::commands::schema::validate;
+    use serde_json::json;
+
+    fn tx(sequence: u64, function: &str, gas_used: u64) -> Value {
+        json!({
+            "version": (1000 + sequence).to_string(),
+            "timestamp": (sequence * 1_000_000).to_string(),
+            "gas_used": gas_used.to_string(),
+            "gas_unit_price": "100",
+            "payload": {"type": "entry_function_payload", "function": function},
+        })
+    }
+
+    #[test]
+    fn walks_back_to_limit_or_cutoff() {
+        let history: Vec<Value> = (0..250)
+            .map(|sequence| tx(sequence, "0x1::m::f", 1))
+            .collect();
+        let fetch =
+            |start: u64, limit: u64| Ok(history[start as usize..(start + limit) as usize].to_vec());
+        let mut requests = Vec::new();
+        let recent = walk_back(
+            250,
+            Some(120),
+            |tx| not_before(None, tx),
+            |start, limit| {
+                requests.push((start, limit));
+                fetch(start, limit)
+            },
+        )
+        .unwrap();
+        assert_eq!(recent.len(), 120);
+        assert_eq!(recent[0]["version"], "1249");
+        assert_eq!(recent[119]["version"], "1130");
+        assert_eq!(requests, [(150, 100), (130, 20)]);
+
+        let since = Some(200 * 1_000_000);
+        let recent = walk_back(250, Some(1000), |tx| not_before(since, tx), fetch).unwrap();
+        assert_eq!(recent.len(), 50);
+        assert_eq!(recent[49]["version"], "1200");
+    }
+
+    #[test]
+    fn groups_fees_by_function() {
+        let transactions = [
+            tx(3, "0x1::aptos_account::transfer", 10),
+            tx(2, "0xcafe::bot::rebalance", 900),
+            tx(1, "0xcafe::bot::rebalance", 300),
+            json!({"version": "7", "gas_used": "5", "gas_unit_price": "100", "payload": {"type": "script_payload"}}),
+        ];
+        let spend = gas_spend("0xb07", &transactions);
+        assert_eq!(spend.transactions, 4);
+        assert_eq!(spend.total_fee_octas, "121500");
+        assert_eq!(spend.total_fee_apt, "0.001215");
+        assert_eq!(spend.avg_fee_octas, "30375");
+        assert_eq!(spend.max_fee_octas, "90000");
+        assert_eq!(spend.max_fee_version, Some(1002));
+        let functions: Vec<(&str, u64)> = spend
+            .functions
+            .iter()
+            .map(|function| (function.function.as_str(), function.transactions))
+            .collect();
+        assert_eq!(
+            functions,
+            [
+                ("0xcafe::bot::rebalance", 2),
+                ("0x1::aptos_account::transfer", 1),
+                ("script_payload", 1),
+            ]
+        );
+        assert_eq!(spend.functions[0].avg_fee_octas, "60000");
+        validate(&gas_output_schema(), &serde_json::to_value(&spend).unwrap()).unwrap();
+
+        let lines = pretty_gas_lines(&spend);
+        assert_eq!(lines.len(), 5);
+        assert!(lines[0].starts_with("function                      txs"));
+        assert!(lines[1].starts_with("0xcafe::bot::rebalance          2"));
+        assert!(lines[4].starts_with("total                           4  0.001215 APT"));
+
+        let empty = gas_spend("0xb07", &[]);
+        assert_eq!(empty.max_fee_version, None);
+        assert_eq!(empty.avg_fee_octas, "0");
+    }
+}
@@ -0,0 +1,553 @@
+use anyhow::{anyhow, Result};
+use aptly_aptos::AptosClient;
+use clap::ValueEnum;
+use serde::Serialize;
+use serde_json::Value;
+use std::fmt;
+
+use crate::commands::abort::parse_abort;
+use crate::commands::account::{
+    account_sequence_number, account_transactions_page, format_amount, TRANSACTIONS_PAGE_LIMIT,
+};
+use crate::commands::common::{format_timestamp_micros, get_nested_string, parse_u64};
+use crate::commands::schema::{array_schema, nullable, object_schema, string_schema};
+
+/// A resume point for `account txs`: the transactions strictly before or
+/// after a ledger version. Written as `before:<version>` or
+/// `after:<version>`, the form of `next_cursor`.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub(crate) enum TxCursor {
+    Before(u64),
+    After(u64),
+}
+
+impl fmt::Display for TxCursor {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            TxCursor::Before(version) => write!(f, "before:{version}"),
+            TxCursor::After(version) => write!(f, "after:{version}"),
+        }
+    }
+}
+
+pub(crate) fn parse_tx_cursor(value: &str) -> Result<TxCursor, String> {
+    let (direction, version) = value.trim().split_once(':').ok_or_else(|| {
+        format!("invalid cursor {value:?}; expected before:<version> or after:<version>")
+    })?;
+    let version = version
+        .parse::<u64>()
+        .map_err(|_| format!("invalid cursor version {version:?}"))?;
+    match direction {
+        "before" => Ok(TxCursor::Before(version)),
+        "after" => Ok(TxCursor::After(version)),
+        _ => Err(format!(
+            "invalid cursor direction {direction:?}; expected before or after"
+        )),
+    }
+}
+
+/// One page of account transactions: newest first for `before`, oldest
+/// first for `after`. `next_cursor` is null once a `before` walk reaches
+/// the first transaction; an `after` cursor is always set so callers can
+/// poll for newer ones.
+#[derive(Debug, Clone, Serialize)]
+struct TxPage {
+    transactions: Vec<Value>,
+    next_cursor: Option<String>,
+}
+
+pub(crate) fn page_output_schema(transaction: Value) -> Value {
+    object_schema(
+        "Transactions sent by the account around a ledger version (`--before-version`, `--after-version` or `--cursor`)",
+        &[
+            (
+                "transactions",
+                array_schema(
+                    "Newest first for a `before` cursor, oldest first for `after`",
+                    transaction,
+                ),
+            ),
+            (
+                "next_cursor",
+                nullable(string_schema(
+                    "Pass to `--cursor` for the next page; null when no older transactions remain",
+                )),
+            ),
+        ],
+    )
+}
+
+pub(crate) fn run_account_txs_page(
+    client: &AptosClient,
+    address: &str,
+    cursor: TxCursor,
+    limit: u64,
+    pretty: bool,
+) -> Result<()> {
+    let sequence_number = account_sequence_number(client, address)?;
+    let page = cursor_page(sequence_number, cursor, limit, |start, limit| {
+        account_transactions_page(client, address, Some(start), limit)
+    })?;
+    if pretty {
+        for line in pretty_tx_lines(&page.transactions) {
+            println!("{line}");
+        }
+        if let Some(next_cursor) = &page.next_cursor {
+            eprintln!("next page: --cursor {next_cursor}");
+        }
+        return Ok(());
+    }
+    crate::print_serialized(&page)
+}
+
+/// `--pretty` table, one line per transaction: version, time, outcome (with
+/// the abort code or VM status of failures), entry function as
+/// `module::function`, and gas fee in APT.
+pub(crate) fn pretty_tx_lines(transactions: &[Value]) -> Vec<String> {
+    let rows: Vec<[String; 5]> = transactions
+        .iter()
+        .map(|tx| {
+            let timestamp = parse_u64(tx.get("timestamp").unwrap_or(&Value::Null))
+                .map(format_timestamp_micros)
+                .unwrap_or_default();
+            let outcome = if tx.get("success").and_then(Value::as_bool) == Some(true) {
+                "ok".to_owned()
+            } else {
+                let vm_status = get_nested_string(tx, &["vm_status"]);
+                match parse_abort(&vm_status) {
+                    Some(abort) => format!("failed 0x{:x}", abort.code),
+                    None => format!("failed {vm_status}"),
+                }
+            };
+            let function = get_nested_string(tx, &["payload", "function"]);
+            let function = match function.split_once("::") {
+                Some((_, short)) => short.to_owned(),
+                None => get_nested_string(tx, &["payload", "type"]),
+            };
+            let gas = |key: &str| parse_u64(tx.get(key).unwrap_or(&Value::Null)).unwrap_or(0);
+            let fee = u128::from(gas("gas_used")) * u128::from(gas("gas_unit_price"));
+            [
+                get_nested_string(tx, &["version"]),
+                timestamp,
+                outcome,
+                function,
+                format!("{} APT", format_amount(&fee.to_string(), 8)),
+            ]
+        })
+        .collect();
+    let width = |column: usize| {
+        rows.iter()
+            .map(|row| row[column].chars().count())
+            .max()
+            .unwrap_or(0)
+    };
+    let (version, time, outcome, function) = (width(0), width(1), width(2), width(3));
+    rows.iter()
+        .map(|[v, t, o, f, fee]| {
+            format!("{v:>version$}  {t:<time$}  {o:<outcome$}  {f:<function$}  {fee}")
+        })
+        .collect()
+}
+
+/// Direction of `account txs` listings.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, ValueEnum)]
+pub(crate) enum TxOrder {
+    /// Oldest first, from `--start`.
+    Asc,
+    /// Newest first, from the account's latest transaction.
+    Desc,
+}
+
+/// Up to `limit` transactions of `address` (every one with `None`), read in
+/// node-sized pages. Ascending reads start at `start`, or return the latest
+/// transactions without it. Page progress goes to stderr under `--verbose`.
+pub(crate) fn account_transactions(
+    client: &AptosClient,
+    address: &str,
+    start: Option<u64>,
+    limit: Option<u64>,
+    order: TxOrder,
+) -> Result<Vec<Value>> {
+    let sequence_number = || account_sequence_number(client, address);
+    let mut pages = 0;
+    let mut read = 0;
+    let mut fetch_page = |start: Option<u64>, limit: u64| {
+        let page = account_transactions_page(client, address, start, limit)?;
+        pages += 1;
+        read += page.len();
+        if client.verbose() {
+            eprintln!("account txs: fetched page {pages} ({read} transactions)");
+        }
+        Ok(page)
+    };
+    match (order, start, limit) {
+        (TxOrder::Asc, None, Some(limit)) => {
+            latest_transactions(limit, sequence_number, fetch_page)
+        }
+        (TxOrder::Asc, start, limit) => {
+            collect_transactions(None, start.unwrap_or(0), limit, |start, limit| {
+                fetch_page(Some(start), limit)
+            })
+        }
+        (TxOrder::Desc, _, limit) => {
+            collect_transactions(Some(sequence_number()?), 0, limit, |start, limit| {
+                fetch_page(Some(start), limit)
+            })
+        }
+    }
+}
+
+/// The newest `limit` transactions, oldest first. A single page is read
+/// without `start`, as the node's own default; more are read back from the
+/// account's sequence number.
+fn latest_transactions(
+    limit: u64,
+    sequence_number: impl FnOnce() -> Result<u64>,
+    mut fetch_page: impl FnMut(Option<u64>, u64) -> Result<Vec<Value>>,
+) -> Result<Vec<Value>> {
+    if limit <= TRANSACTIONS_PAGE_LIMIT {
+        return fetch_page(None, limit);
+    }
+    let mut transactions =
+        collect_transactions(Some(sequence_number()?), 0, Some(limit), |start, limit| {
+            fetch_page(Some(start), limit)
+        })?;
+    transactions.reverse();
+    Ok(transactions)
+}
+
+/// Oldest first from `start` when `sequence_number` is `None`; otherwise
+/// newest first, ending at the account's `sequence_number`.
+fn collect_transactions(
+    sequence_number: Option<u64>,
+    start: u64,
+    limit: Option<u64>,
+    mut fetch_page: impl FnMut(u64, u64) -> Result<Vec<Value>>,
+) -> Result<Vec<Value>> {
+    match sequence_number {
+        None => {
+            let end = limit.map_or(u64::MAX, |limit| start.saturating_add(limit));
+            fetch_range(start, end, &mut fetch_page)
+        }
+        Some(sequence_number) => walk_back(sequence_number, limit, |_| true, fetch_page),
+    }
+}
+
+/// Transactions with sequence numbers below `end`, newest first, read back
+/// a page at a time: at most `limit` (every one without it), stopping at
+/// the first that `keep` rejects. `fetch_page(start, limit)` reads
+/// transactions by sequence number.
+pub(crate) fn walk_back(
+    end: u64,
+    limit: Option<u64>,
+    mut keep: impl FnMut(&Value) -> bool,
+    mut fetch_page: impl FnMut(u64, u64) -> Result<Vec<Value>>,
+) -> Result<Vec<Value>> {
+    let floor = limit.map_or(0, |limit| end.saturating_sub(limit));
+    let mut transactions = Vec::new();
+    let mut end = end;
+    while end > floor {
+        let start = end.saturating_sub(TRANSACTIONS_PAGE_LIMIT).max(floor);
+        let page = fetch_page(start, end - start)?;
+        if page.is_empty() {
+            break;
+        }
+        end = start;
+        for tx in page.into_iter().rev() {
+            if !keep(&tx) {
+                return Ok(transactions);
+            }
+            transactions.push(tx);
+        }
+    }
+    Ok(transactions)
+}
+
+/// Reads the page after `cursor` from an account that has sent
+/// `sequence_number` transactions. Versions grow with sequence numbers, so
+/// a binary search finds where the cursor falls without scanning from
+/// either end. `fetch_page(start, limit)` reads transactions by sequence
+/// number.
+fn cursor_page(
+    sequence_number: u64,
+    cursor: TxCursor,
+    limit: u64,
+    mut fetch_page: impl FnMut(u64, u64) -> Result<Vec<Value>>,
+) -> Result<TxPage> {
+    // The first sequence number past the cursor's boundary; the boundary
+    // transaction itself is never repeated.
+    let boundary = partition_point(sequence_number, |sequence| {
+        let version = fetch_page(sequence, 1)?
+            .first()
+            .and_then(transaction_version)
+            .ok_or_else(|| anyhow!("missing transaction at sequence number {sequence}"))?;
+        Ok(match cursor {
+            TxCursor::Before(before) => version >= before,
+            TxCursor::After(after) => version > after,
+        })
+    })?;
+
+    match cursor {
+        TxCursor::Before(_) => {
+            let start = boundary.saturating_sub(limit);
+            let mut transactions = fetch_range(start, boundary, &mut fetch_page)?;
+            transactions.reverse();
+            let next_cursor = match transactions.last().and_then(transaction_version) {
+                Some(oldest) if start > 0 => Some(TxCursor::Before(oldest).to_string()),
+                _ => None,
+            };
+            Ok(TxPage {
+                transactions,
+                next_cursor,
+            })
+        }
+        TxCursor::After(after) => {
+            let end = boundary.saturating_add(limit).min(sequence_number);
+            let transactions = fetch_range(boundary, end, &mut fetch_page)?;
+            let newest = transactions
+                .last()
+                .and_then(transaction_version)
+                .unwrap_or(after);
+            Ok(TxPage {
+                transactions,
+                next_cursor: Some(TxCursor::After(newest).to_string()),
+            })
+        }
+    }
+}
+
+/// The first index in `0..len` where `is_past` holds, assuming it holds
+/// for every later index too; `len` when it never does.
+fn partition_point(len: u64, mut is_past: impl FnMut(u64) -> Result<bool>) -> Result<u64> {
+    let (mut low, mut high) = (0, len);
+    while low < high {
+        let mid = low + (high - low) / 2;
+        if is_past(mid)? {
+            high = mid;
+        } else {
+            low = mid + 1;
+        }
+    }
+    Ok(low)
+}
+
+/// Transactions with sequence numbers `start..end`, oldest first.
+fn fetch_range(
+    start: u64,
+    end: u64,
+    fetch_page: &mut impl FnMut(u64, u64) -> Result<Vec<Value>>,
+) -> Result<Vec<Value>> {
+    let mut transactions = Vec::new();
+    let mut next = start;
+    while next < end {
+        let page = fetch_page(next, (end - next).min(TRANSACTIONS_PAGE_LIMIT))?;
+        if page.is_empty() {
+            break;
+        }
+        next += page.len() as u64;
+        transactions.extend(page);
+    }
+    Ok(transactions)
+}
+
+fn transaction_version(tx: &Value) -> Option<u64> {
+    parse_u64(tx.get("version")?)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::commands::schema::validate;
+    use serde_json::json;
+
+    #[test]
+    fn collects_pages_in_either_order() {
+        let history: Vec<Value> = (0..250)
+            .map(|sequence| json!({"sequence_number": sequence}))
+            .collect();
+        let mut requests = Vec::new();
+        let mut fetch = |start: u64, limit: u64| -> Result<Vec<Value>> {
+            requests.push((start, limit));
+            let start = (start as usize).min(history.len());
+            let end = (start + limit as usize).min(history.len());
+            Ok(history[start..end].to_vec())
+        };
+        let sequences = |transactions: Vec<Value>| -> Vec<u64> {
+            transactions
+                .iter()
+                .map(|tx| tx["sequence_number"].as_u64().unwrap())
+                .collect()
+        };
+
+        let oldest = collect_transactions(None, 10, Some(150), &mut fetch).unwrap();
+        assert_eq!(sequences(oldest), (10..160).collect::<Vec<_>>());
+        let all = collect_transactions(None, 0, None, &mut fetch).unwrap();
+        assert_eq!(all.len(), 250);
+        let newest = collect_transactions(Some(250), 0, Some(120), &mut fetch).unwrap();
+        assert_eq!(sequences(newest), (130..250).rev().collect::<Vec<_>>());
+        let everything = collect_transactions(Some(250), 0, None, &mut fetch).unwrap();
+        assert_eq!(everything.len(), 250);
+        drop(fetch);
+        assert_eq!(requests[..2], [(10, 100), (110, 50)]);
+        assert_eq!(
+            requests[2..6],
+            [(0, 100), (100, 100), (200, 100), (250, 100)]
+        );
+        assert_eq!(requests[6..8], [(150, 100), (130, 20)]);
+    }
+
+    #[test]
+    fn reads_the_latest_transactions_without_start() {
+        let mut requests = Vec::new();
+        let mut fetch = |start: Option<u64>, limit: u64| -> Result<Vec<Value>> {
+            requests.push((start, limit));
+            let first = start.unwrap_or(250 - limit);
+            Ok((first..(first + limit).min(250))
+                .map(|sequence| json!({"sequence_number": sequence}))
+                .collect())
+        };
+        let sequences = |transactions: Vec<Value>| -> Vec<u64> {
+            transactions
+                .iter()
+                .map(|tx| tx["sequence_number"].as_u64().unwrap())
+                .collect()
+        };
+
+        let page = latest_transactions(25, || panic!("one page needs no account read"), &mut fetch)
+            .unwrap();
+        assert_eq!(sequences(page), (225..250).collect::<Vec<_>>());
+        let more = latest_transactions(150, || Ok(250), &mut fetch).unwrap();
+        assert_eq!(sequences(more), (100..250).collect::<Vec<_>>());
+        drop(fetch);
+        assert_eq!(requests, [(None, 25), (Some(150), 100), (Some(100), 50)]);
+    }
+
+    #[test]
+    fn prints_one_line_per_transaction() {
+        let transactions = vec![
+            json!({
+                "version": "1234567",
+                "timestamp": "1700000000000000",
+                "success": true,
+                "vm_status": "Executed successfully",
+                "gas_used": "12",
+                "gas_unit_price": "100",
+                "payload": {"type": "entry_function_payload", "function": "0x1::aptos_account::transfer"},
+            }),
+            json!({
+                "version": "89",
+                "timestamp": "1700000060000000",
+                "success": false,
+                "vm_status": "Move abort in 0x1::coin: EINSUFFICIENT_BALANCE(0x10006): ",
+                "gas_used": "5",
+                "gas_unit_price": "150",
+                "payload": {"type": "entry_function_payload", "function": "0xcafe::pool::swap"},
+            }),
+            json!({
+                "version": "90",
+                "timestamp": "1700000120000000",
+                "success": false,
+                "vm_status": "Out of gas",
+                "gas_used": "0",
+                "gas_unit_price": "100",
+                "payload": {"type": "script_payload"},
+            }),
+        ];
+        assert_eq!(
+            pretty_tx_lines(&transactions),
+            [
+                "1234567  2023-11-14T22:13:20Z  ok                 aptos_account::transfer  0.000012 APT",
+                "     89  2023-11-14T22:14:20Z  failed 0x10006     pool::swap               0.0000075 APT",
+                "     90  2023-11-14T22:15:20Z  failed Out of gas  script_payload           0 APT",
+            ]
+        );
+    }
+
+    const VERSIONS: [u64; 7] = [10, 25, 26, 40, 55, 70, 90];
+
+    /// Pages of a simulated account whose transactions landed at `VERSIONS`.
+    fn page(cursor: TxCursor, limit: u64) -> TxPage {
+        cursor_page(VERSIONS.len() as u64, cursor, limit, |start, limit| {
+            Ok(VERSIONS
+                .iter()
+                .skip(start as usize)
+                .take(limit as usize)
+                .map(|version| json!({"version": version.to_string()}))
+                .collect())
+        })
+        .unwrap()
+    }
+
+    fn versions(page: &TxPage) -> Vec<u64> {
+        page.transactions
+            .iter()
+            .filter_map(transaction_version)
+            .collect()
+    }
+
+    /// Follows `next_cursor` through its string form, as a script would.
+    fn walk(cursor: TxCursor, limit: u64, pages: usize) -> Vec<(Vec<u64>, Option<String>)> {
+        let mut cursor = Some(cursor);
+        let mut seen = Vec::new();
+        for _ in 0..pages {
+            let page = page(cursor.expect("walk ended early"), limit);
+            cursor = page
+                .next_cursor
+                .as_deref()
+                .map(|next| parse_tx_cursor(next).unwrap());
+            seen.push((versions(&page), page.next_cursor));
+        }
+        seen
+    }
+
+    #[test]
+    fn walks_backwards_across_pages() {
+        assert_eq!(
+            walk(TxCursor::Before(80), 2, 3),
+            [
+                (vec![70, 55], Some("before:55".to_owned())),
+                (vec![40, 26], Some("before:26".to_owned())),
+                (vec![25, 10], None),
+            ]
+        );
+        // A boundary on an existing version excludes that transaction.
+        assert_eq!(versions(&page(TxCursor::Before(40), 10)), [26, 25, 10]);
+        assert_eq!(versions(&page(TxCursor::Before(10), 10)), Vec::<u64>::new());
+        assert_eq!(page(TxCursor::Before(10), 10).next_cursor, None);
+    }
+
+    #[test]
+    fn walks_forwards_and_keeps_polling_at_the_head() {
+        assert_eq!(
+            walk(TxCursor::After(0), 3, 3),
+            [
+                (vec![10, 25, 26], Some("after:26".to_owned())),
+                (vec![40, 55, 70], Some("after:70".to_owned())),
+                (vec![90], Some("after:90".to_owned())),
+            ]
+        );
+        let head = page(TxCursor::After(90), 3);
+        assert!(head.transactions.is_empty());
+        assert_eq!(head.next_cursor.as_deref(), Some("after:90"));
+        assert_eq!(versions(&page(TxCursor::After(25), 2)), [26, 40]);
+    }
+
+    #[test]
+    fn parses_cursors() {
+        assert_eq!(parse_tx_cursor("before:12"), Ok(TxCursor::Before(12)));
+        assert_eq!(parse_tx_cursor(" after:0 "), Ok(TxCursor::After(0)));
+        for cursor in [TxCursor::Before(7), TxCursor::After(8)] {
+            assert_eq!(parse_tx_cursor(&cursor.to_string()), Ok(cursor));
+        }
+        assert!(parse_tx_cursor("12").is_err());
+        assert!(parse_tx_cursor("since:12").is_err());
+        assert!(parse_tx_cursor("before:x").is_err());
+    }
+
+    #[test]
+    fn page_matches_schema() {
+        let schema = page_output_schema(json!({"type": "object"}));
+        let value = serde_json::to_value(page(TxCursor::Before(30), 2)).unwrap();
+        validate(&schema, &value).unwrap();
+    }
+}
@@ -0,0 +1,76 @@
+use anyhow::{anyhow, Result};
+use aptly_aptos::AptosClient;
+use clap::Args;
+use std::time::Duration;
+
+use crate::commands::account::{account_sequence_number, TRANSACTIONS_PAGE_LIMIT};
+use crate::commands::account_txs::pretty_tx_lines;
+use crate::commands::common::{normalize_address, parse_duration};
+use crate::commands::follow::{run_follow_until, FollowArgs, FollowEnd, FollowSource, FollowUntil};
+
+#[derive(Args)]
+pub(crate) struct WatchArgs {
+    /// Account address (`0x...`).
+    #[arg(value_name = "ADDRESS")]
+    pub(crate) address: String,
+    /// Delay between polls, e.g. `2s` or `500ms`.
+    #[arg(long, default_value = "5s", value_parser = parse_duration)]
+    pub(crate) interval: Duration,
+    /// Exit once the transaction with this sequence number is committed.
+    #[arg(long, value_name = "N")]
+    pub(crate) until_sequence: Option<u64>,
+    /// Stop watching after this long, e.g. `10m`; an error when
+    /// `--until-sequence` has not been reached by then.
+    #[arg(long, value_parser = parse_duration)]
+    pub(crate) timeout: Option<Duration>,
+    /// Print a one-line summary per transaction instead of JSON lines.
+    #[arg(long, default_value_t = false)]
+    pub(crate) pretty: bool,
+}
+
+/// `account watch`: prints transactions the account sends from now on, as
+/// they are committed.
+pub(crate) fn run_account_watch(client: &AptosClient, args: &WatchArgs) -> Result<()> {
+    let address = normalize_address(&args.address);
+    let start = account_sequence_number(client, &address)?;
+    if let Some(until) = args.until_sequence.filter(|until| *until < start) {
+        eprintln!("sequence number {until} of {address} is already committed");
+        return Ok(());
+    }
+    eprintln!("watching {address} from sequence number {start}");
+
+    let follow = FollowArgs {
+        follow: true,
+        poll_interval: args.interval,
+        metrics_listen: None,
+    };
+    let until = FollowUntil {
+        through: args.until_sequence,
+        timeout: args.timeout,
+        fail_on_error: true,
+    };
+    let source = FollowSource::AccountTransactions {
+        address: address.clone(),
+    };
+    let end = run_follow_until(
+        client,
+        source,
+        start,
+        TRANSACTIONS_PAGE_LIMIT,
+        &follow,
+        &until,
+        |transaction| {
+            if args.pretty {
+                Ok(pretty_tx_lines(std::slice::from_ref(transaction)).concat())
+            } else {
+                Ok(serde_json::to_string(transaction)?)
+            }
+        },
+    )?;
+    match (end, args.until_sequence) {
+        (FollowEnd::TimedOut, Some(until)) => Err(anyhow!(
+            "timed out waiting for sequence number {until} of {address}"
+        )),
+        _ => Ok(()),
+    }
+}
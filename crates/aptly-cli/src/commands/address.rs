@@ -3,6 +3,7 @@ use clap::Args;
 use reqwest::StatusCode;
 use std::collections::HashMap;
 
+/// Curated mainnet label list; `aptly labels sync` caches it for `--labels`.
 const LABELS_URL: &str =
     "https://raw.githubusercontent.com/ThalaLabs/aptos-labels/main/mainnet.json";
 
@@ -15,6 +16,19 @@ pub(crate) struct AddressCommand {
 }
 
 pub(crate) fn run_address(command: AddressCommand) -> Result<()> {
+    let labels = fetch_curated_labels()?;
+
+    let query = command.query.to_lowercase();
+    let matches: HashMap<String, String> = labels
+        .into_iter()
+        .filter(|(_, label)| label.to_lowercase().contains(&query))
+        .collect();
+
+    crate::print_serialized(&matches)
+}
+
+/// Fetches the curated label list as `{address: label}`.
+pub(crate) fn fetch_curated_labels() -> Result<HashMap<String, String>> {
     let response =
         reqwest::blocking::get(LABELS_URL).context("failed to fetch address labels source")?;
     let status = response.status();
@@ -26,14 +40,5 @@ pub(crate) fn run_address(command: AddressCommand) -> Result<()> {
         return Err(anyhow!("API error (status {}): {}", status.as_u16(), body));
     }
 
-    let labels: HashMap<String, String> =
-        serde_json::from_str(&body).context("failed to decode labels response")?;
-
-    let query = command.query.to_lowercase();
-    let matches: HashMap<String, String> = labels
-        .into_iter()
-        .filter(|(_, label)| label.to_lowercase().contains(&query))
-        .collect();
-
-    crate::print_serialized(&matches)
+    serde_json::from_str(&body).context("failed to decode labels response")
 }
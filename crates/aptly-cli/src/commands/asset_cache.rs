@@ -0,0 +1,173 @@
+use anyhow::Result;
+use serde::{Deserialize, Serialize};
+use std::collections::BTreeMap;
+use std::fs;
+use std::io::ErrorKind;
+use std::path::PathBuf;
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::sync::Mutex;
+use std::time::{SystemTime, UNIX_EPOCH};
+
+use crate::commands::assets::AssetMetadata;
+use crate::commands::common::user_cache_path;
+use crate::output::write_atomic;
+
+const CACHE_FILE: &str = "assets.json";
+/// How long a looked-up symbol and decimals are trusted.
+const TTL_SECS: u64 = 24 * 60 * 60;
+
+/// Off under `--no-cache`, `--record` and `--replay`, and in tests, which
+/// must not read or write the user's cache.
+static ENABLED: AtomicBool = AtomicBool::new(!cfg!(test));
+static CACHE: Mutex<Option<AssetCache>> = Mutex::new(None);
+
+pub(crate) fn disable() {
+    ENABLED.store(false, Ordering::Relaxed);
+}
+
+/// Cached metadata of `asset` on the node at `network`, if still fresh.
+pub(crate) fn get(network: &str, asset: &str) -> Option<AssetMetadata> {
+    with_cache(|cache| cache.get(network, asset, unix_now())).flatten()
+}
+
+/// Records a successful lookup and writes the cache file.
+pub(crate) fn put(network: &str, asset: &str, metadata: &AssetMetadata) {
+    with_cache(|cache| {
+        cache.put(network, asset, metadata, unix_now());
+        // A cache that cannot be written only costs a lookup next time.
+        if let Err(err) = cache.save() {
+            eprintln!("failed to write asset cache: {err:#}");
+        }
+    });
+}
+
+fn with_cache<T>(f: impl FnOnce(&mut AssetCache) -> T) -> Option<T> {
+    if !ENABLED.load(Ordering::Relaxed) {
+        return None;
+    }
+    let mut guard = CACHE.lock().ok()?;
+    if guard.is_none() {
+        *guard = Some(AssetCache::load(user_cache_path(CACHE_FILE).ok()?));
+    }
+    guard.as_mut().map(f)
+}
+
+fn unix_now() -> u64 {
+    SystemTime::now()
+        .duration_since(UNIX_EPOCH)
+        .map_or(0, |elapsed| elapsed.as_secs())
+}
+
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+struct CachedAsset {
+    symbol: String,
+    decimals: u8,
+    /// Unix seconds of the lookup.
+    fetched_at: u64,
+}
+
+/// `assets.json`: entries keyed by node URL, then asset identifier.
+struct AssetCache {
+    path: PathBuf,
+    entries: BTreeMap<String, BTreeMap<String, CachedAsset>>,
+}
+
+impl AssetCache {
+    /// Reads `path`; a missing or unreadable file starts an empty cache that
+    /// replaces it on the next write.
+    fn load(path: PathBuf) -> Self {
+        let entries = match fs::read_to_string(&path) {
+            Ok(body) => serde_json::from_str(&body).unwrap_or_else(|err| {
+                eprintln!("ignoring corrupt asset cache {}: {err}", path.display());
+                BTreeMap::new()
+            }),
+            Err(err) if err.kind() == ErrorKind::NotFound => BTreeMap::new(),
+            Err(err) => {
+                eprintln!("ignoring unreadable asset cache {}: {err}", path.display());
+                BTreeMap::new()
+            }
+        };
+        Self { path, entries }
+    }
+
+    fn get(&self, network: &str, asset: &str, now: u64) -> Option<AssetMetadata> {
+        let cached = self.entries.get(network)?.get(asset)?;
+        if now.saturating_sub(cached.fetched_at) >= TTL_SECS {
+            return None;
+        }
+        Some(AssetMetadata {
+            symbol: cached.symbol.clone(),
+            decimals: cached.decimals,
+            decimals_known: true,
+        })
+    }
+
+    fn put(&mut self, network: &str, asset: &str, metadata: &AssetMetadata, now: u64) {
+        self.entries.entry(network.to_owned()).or_default().insert(
+            asset.to_owned(),
+            CachedAsset {
+                symbol: metadata.symbol.clone(),
+                decimals: metadata.decimals,
+                fetched_at: now,
+            },
+        );
+    }
+
+    fn save(&self) -> Result<()> {
+        if let Some(dir) = self.path.parent().filter(|dir| !dir.as_os_str().is_empty()) {
+            fs::create_dir_all(dir)?;
+        }
+        let body = serde_json::to_string_pretty(&self.entries)?;
+        write_atomic(&self.path, body.as_bytes(), true)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const MAINNET: &str = "https://fullnode.mainnet.aptoslabs.com/v1";
+    const USDC: &str = "0xbae207659db88bea0cbead6da0ed00aac12edcdda169e591cd41c94180b46f3b";
+
+    fn usdc() -> AssetMetadata {
+        AssetMetadata {
+            symbol: "USDC".to_owned(),
+            decimals: 6,
+            decimals_known: true,
+        }
+    }
+
+    #[test]
+    fn round_trips_and_expires_entries() {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("aptly").join(CACHE_FILE);
+        let mut cache = AssetCache::load(path.clone());
+        assert!(cache.get(MAINNET, USDC, 1_000).is_none());
+        cache.put(MAINNET, USDC, &usdc(), 1_000);
+        cache.save().unwrap();
+
+        let cache = AssetCache::load(path);
+        let hit = cache.get(MAINNET, USDC, 1_000 + TTL_SECS - 1).unwrap();
+        assert_eq!((hit.symbol.as_str(), hit.decimals), ("USDC", 6));
+        assert!(cache.get(MAINNET, USDC, 1_000 + TTL_SECS).is_none());
+        assert!(
+            cache
+                .get("https://api.testnet.aptoslabs.com/v1", USDC, 1_000)
+                .is_none(),
+            "keyed by network"
+        );
+    }
+
+    #[test]
+    fn replaces_a_corrupt_file() {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join(CACHE_FILE);
+        fs::write(&path, "{not json").unwrap();
+        let mut cache = AssetCache::load(path.clone());
+        assert!(cache.get(MAINNET, USDC, 0).is_none());
+
+        cache.put(MAINNET, USDC, &usdc(), 0);
+        cache.save().unwrap();
+        assert!(AssetCache::load(path).get(MAINNET, USDC, 0).is_some());
+    }
+}
@@ -0,0 +1,335 @@
+use aptly_aptos::AptosClient;
+use serde_json::Value;
+use std::collections::{BTreeMap, BTreeSet};
+use std::sync::atomic::{AtomicUsize, Ordering};
+use std::sync::Mutex;
+use std::thread;
+
+use crate::commands::asset_cache;
+use crate::commands::common::{get_nested_string, parse_u64, shorten_addr};
+
+pub(crate) const APTOS_COIN_TYPE: &str = "0x1::aptos_coin::AptosCoin";
+/// Fungible asset metadata object of APT, paired with `APTOS_COIN_TYPE`.
+pub(crate) const APT_METADATA_ADDRESS: &str = "0xa";
+const FUNGIBLE_METADATA_TYPE: &str = "0x1::fungible_asset::Metadata";
+/// Lookups [`AssetResolver::warm`] keeps in flight at once.
+pub(crate) const METADATA_CONCURRENCY: usize = 8;
+
+/// One resolver for the whole process, so every command that formats
+/// amounts shares its lookups.
+static RESOLVER: AssetResolver = AssetResolver::new();
+
+#[derive(Debug, Clone, Default)]
+pub(crate) struct AssetMetadata {
+    pub(crate) symbol: String,
+    pub(crate) decimals: u8,
+    /// Whether `decimals` came from the chain rather than the `0` fallback.
+    pub(crate) decimals_known: bool,
+}
+
+pub(crate) fn resolver() -> &'static AssetResolver {
+    &RESOLVER
+}
+
+/// Symbol and decimals of coin types and fungible assets, cached per node
+/// URL and asset. Safe to share between threads.
+pub(crate) struct AssetResolver {
+    entries: Mutex<BTreeMap<(String, String), AssetMetadata>>,
+}
+
+impl AssetResolver {
+    pub(crate) const fn new() -> Self {
+        Self {
+            entries: Mutex::new(BTreeMap::new()),
+        }
+    }
+
+    /// Metadata of `asset`, a coin type such as `0x1::aptos_coin::AptosCoin`
+    /// or a fungible asset metadata address. A failed lookup falls back to
+    /// the shortened identifier with no decimals, and is not retried.
+    pub(crate) fn lookup(&self, client: &AptosClient, asset: &str) -> AssetMetadata {
+        self.lookup_with(client.base_url(), asset, |asset| {
+            query_asset_metadata(client, asset)
+        })
+    }
+
+    /// Resolves every asset not cached yet with up to
+    /// `METADATA_CONCURRENCY` lookups in flight, so a batch costs about as
+    /// long as its slowest lookup.
+    pub(crate) fn warm(&self, client: &AptosClient, assets: &BTreeSet<&str>) {
+        let network = client.base_url();
+        let missing: Vec<&str> = assets
+            .iter()
+            .copied()
+            .filter(|asset| self.cached(network, asset).is_none())
+            .collect();
+        let next = AtomicUsize::new(0);
+        thread::scope(|scope| {
+            for _ in 0..missing.len().min(METADATA_CONCURRENCY) {
+                scope.spawn(|| {
+                    while let Some(asset) = missing.get(next.fetch_add(1, Ordering::Relaxed)) {
+                        self.insert(network, asset, query_asset_metadata(client, asset));
+                    }
+                });
+            }
+        });
+    }
+
+    pub(crate) fn cached(&self, network: &str, asset: &str) -> Option<AssetMetadata> {
+        let entries = self.entries.lock().ok()?;
+        entries
+            .get(&(network.to_owned(), asset.to_owned()))
+            .cloned()
+    }
+
+    pub(crate) fn insert(&self, network: &str, asset: &str, metadata: AssetMetadata) {
+        // A poisoned lock only costs the lookup again next time.
+        if let Ok(mut entries) = self.entries.lock() {
+            entries.insert((network.to_owned(), asset.to_owned()), metadata);
+        }
+    }
+
+    /// [`Self::lookup`] with the node query supplied by the caller. The lock
+    /// is not held while `query` runs.
+    fn lookup_with(
+        &self,
+        network: &str,
+        asset: &str,
+        query: impl FnOnce(&str) -> AssetMetadata,
+    ) -> AssetMetadata {
+        if let Some(cached) = self.cached(network, asset) {
+            return cached;
+        }
+        let metadata = query(asset);
+        self.insert(network, asset, metadata.clone());
+        metadata
+    }
+}
+
+/// Resolves one asset, consulting the on-disk cache first. Only lookups
+/// that found the decimals are written to it.
+fn query_asset_metadata(client: &AptosClient, asset: &str) -> AssetMetadata {
+    let network = client.base_url();
+    if let Some(cached) = asset_cache::get(network, asset) {
+        return cached;
+    }
+    let queried = if asset.contains("::") {
+        query_coin_metadata(client, asset)
+    } else {
+        query_fungible_asset_metadata(client, asset)
+    };
+    match queried {
+        Some(metadata) => {
+            if metadata.decimals_known {
+                asset_cache::put(network, asset, &metadata);
+            }
+            metadata
+        }
+        None => AssetMetadata {
+            symbol: shorten_addr(asset),
+            decimals: 0,
+            decimals_known: false,
+        },
+    }
+}
+
+fn query_fungible_asset_metadata(
+    client: &AptosClient,
+    metadata_addr: &str,
+) -> Option<AssetMetadata> {
+    let encoded_resource = urlencoding::encode(FUNGIBLE_METADATA_TYPE);
+    let path = format!("/accounts/{metadata_addr}/resource/{encoded_resource}");
+    let resource = client.get_json(&path).ok()?;
+    Some(metadata_from_resource(&resource, metadata_addr))
+}
+
+fn query_coin_metadata(client: &AptosClient, coin_type: &str) -> Option<AssetMetadata> {
+    if coin_type == APTOS_COIN_TYPE {
+        return Some(AssetMetadata {
+            symbol: "APT".to_owned(),
+            decimals: 8,
+            decimals_known: true,
+        });
+    }
+
+    let issuer = coin_type
+        .split("::")
+        .next()
+        .filter(|issuer| !issuer.is_empty())?;
+    let resource_type = format!("0x1::coin::CoinInfo<{coin_type}>");
+    let encoded_resource = urlencoding::encode(&resource_type);
+    let path = format!("/accounts/{issuer}/resource/{encoded_resource}");
+    let resource = client.get_json(&path).ok()?;
+    Some(metadata_from_resource(&resource, coin_type))
+}
+
+/// Symbol and decimals of a `CoinInfo` or fungible asset `Metadata`
+/// resource; a missing symbol falls back to the shortened `asset`.
+fn metadata_from_resource(resource: &Value, asset: &str) -> AssetMetadata {
+    let mut metadata = AssetMetadata {
+        symbol: shorten_addr(asset),
+        decimals: 0,
+        decimals_known: false,
+    };
+    let symbol = get_nested_string(resource, &["data", "symbol"]);
+    if !symbol.is_empty() {
+        metadata.symbol = symbol;
+    }
+    if let Some(decimals) = parse_u64(
+        resource
+            .get("data")
+            .and_then(|d| d.get("decimals"))
+            .unwrap_or(&Value::Null),
+    ) {
+        metadata.decimals = decimals as u8;
+        metadata.decimals_known = true;
+    }
+    metadata
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::io::{Read, Write};
+    use std::net::TcpListener;
+    use std::sync::Arc;
+    use std::time::Duration;
+
+    const NODE: &str = "http://127.0.0.1:9/v1";
+
+    fn tok(symbol: &str, decimals: u8) -> AssetMetadata {
+        AssetMetadata {
+            symbol: symbol.to_owned(),
+            decimals,
+            decimals_known: true,
+        }
+    }
+
+    #[test]
+    fn lookups_are_cached_per_node_and_asset() {
+        let resolver = AssetResolver::new();
+        let queries = AtomicUsize::new(0);
+        let query = |asset: &str| {
+            queries.fetch_add(1, Ordering::SeqCst);
+            tok(&format!("T{}", asset.len()), 6)
+        };
+
+        assert_eq!(resolver.lookup_with(NODE, "0xa1", query).symbol, "T4");
+        assert_eq!(resolver.lookup_with(NODE, "0xa1", query).symbol, "T4");
+        assert_eq!(queries.load(Ordering::SeqCst), 1);
+
+        resolver.lookup_with("http://127.0.0.1:10/v1", "0xa1", query);
+        resolver.lookup_with(NODE, "0x1::tok::T", query);
+        assert_eq!(queries.load(Ordering::SeqCst), 3);
+    }
+
+    #[test]
+    fn shared_between_threads() {
+        let resolver = AssetResolver::new();
+        let queries = AtomicUsize::new(0);
+        thread::scope(|scope| {
+            for _ in 0..8 {
+                scope.spawn(|| {
+                    for index in 0..16 {
+                        let asset = format!("0x{index:x}0");
+                        let metadata = resolver.lookup_with(NODE, &asset, |asset| {
+                            queries.fetch_add(1, Ordering::SeqCst);
+                            tok(asset, 6)
+                        });
+                        assert_eq!(metadata.symbol, asset);
+                    }
+                });
+            }
+        });
+        // Threads racing on an empty entry may both query it; every asset
+        // still ends up cached once.
+        assert!((16..=128).contains(&queries.load(Ordering::SeqCst)));
+        for index in 0..16 {
+            let asset = format!("0x{index:x}0");
+            assert_eq!(resolver.cached(NODE, &asset).unwrap().symbol, asset);
+        }
+    }
+
+    #[test]
+    fn falls_back_to_the_shortened_identifier() {
+        // Nothing listens on port 9, so every lookup fails at once.
+        let client = AptosClient::new(NODE).unwrap();
+        let resolver = AssetResolver::new();
+        let metadata = resolver.lookup(&client, "0xb0");
+        assert_eq!((metadata.symbol.as_str(), metadata.decimals), ("0xb0", 0));
+        assert!(!metadata.decimals_known);
+
+        let apt = resolver.lookup(&client, APTOS_COIN_TYPE);
+        assert_eq!((apt.symbol.as_str(), apt.decimals), ("APT", 8));
+    }
+
+    #[test]
+    fn warms_every_missing_asset() {
+        // Nothing listens on port 9, so every lookup falls back at once.
+        let client = AptosClient::new(NODE).unwrap();
+        let resolver = AssetResolver::new();
+        resolver.insert(NODE, "0xa", tok("APT", 8));
+        let assets: Vec<String> = (0..12).map(|index| format!("0x{index:x}0")).collect();
+        let mut wanted: BTreeSet<&str> = assets.iter().map(String::as_str).collect();
+        wanted.insert("0xa");
+        resolver.warm(&client, &wanted);
+
+        assert_eq!(resolver.entries.lock().unwrap().len(), 13);
+        assert_eq!(resolver.cached(NODE, "0xa").unwrap().symbol, "APT");
+        assert_eq!(resolver.cached(NODE, "0xb0").unwrap().symbol, "0xb0");
+    }
+
+    /// A node answering every `CoinInfo` request after 50ms, counting the
+    /// most requests it had in flight at once.
+    fn slow_coin_info_node() -> (String, Arc<AtomicUsize>) {
+        let listener = TcpListener::bind("127.0.0.1:0").unwrap();
+        let addr = listener.local_addr().unwrap();
+        let in_flight = Arc::new(AtomicUsize::new(0));
+        let peak = Arc::new(AtomicUsize::new(0));
+        let seen = Arc::clone(&peak);
+        thread::spawn(move || {
+            for stream in listener.incoming() {
+                let Ok(mut stream) = stream else { break };
+                let (in_flight, peak) = (Arc::clone(&in_flight), Arc::clone(&peak));
+                thread::spawn(move || {
+                    let now = in_flight.fetch_add(1, Ordering::SeqCst) + 1;
+                    peak.fetch_max(now, Ordering::SeqCst);
+                    let mut buf = [0u8; 4096];
+                    let _ = stream.read(&mut buf);
+                    thread::sleep(Duration::from_millis(50));
+                    let body = r#"{"data":{"symbol":"TOK","decimals":6}}"#;
+                    in_flight.fetch_sub(1, Ordering::SeqCst);
+                    let _ = stream.write_all(
+                        format!(
+                            "HTTP/1.1 200 OK\r\nContent-Type: application/json\r\nContent-Length: {}\r\nConnection: close\r\n\r\n{body}",
+                            body.len()
+                        )
+                        .as_bytes(),
+                    );
+                });
+            }
+        });
+        (format!("http://{addr}"), seen)
+    }
+
+    #[test]
+    fn warms_assets_concurrently() {
+        let (node, peak) = slow_coin_info_node();
+        let client = AptosClient::new(&node).unwrap();
+        let resolver = AssetResolver::new();
+        let assets: Vec<String> = (0..10)
+            .map(|index| format!("0x{:x}::tok::T", 0xa0 + index))
+            .collect();
+        resolver.warm(&client, &assets.iter().map(String::as_str).collect());
+
+        let peak = peak.load(Ordering::SeqCst);
+        assert!(
+            (2..=METADATA_CONCURRENCY).contains(&peak),
+            "{peak} lookups in flight"
+        );
+        for asset in &assets {
+            let cached = resolver.cached(client.base_url(), asset).unwrap();
+            assert_eq!((cached.symbol.as_str(), cached.decimals), ("TOK", 6));
+        }
+    }
+}
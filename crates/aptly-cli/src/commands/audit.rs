@@ -0,0 +1,198 @@
+use anyhow::{Context, Result};
+use clap::{Args, Subcommand};
+use serde::Serialize;
+use serde_json::{json, Value};
+use std::collections::BTreeMap;
+use std::fs;
+use std::path::PathBuf;
+
+use crate::commands::schema::{
+    array_schema, integer_schema, nullable, object_schema, string_schema, OutputSchema,
+};
+
+#[derive(Args)]
+#[command(
+    after_help = "Examples:\n  aptly --audit-log audit.jsonl account coins 0x1\n  APTLY_AUDIT_LOG=audit.jsonl aptly tx graph 123456\n  aptly audit summarize audit.jsonl"
+)]
+pub(crate) struct AuditCommand {
+    #[command(subcommand)]
+    pub(crate) command: AuditSubcommand,
+}
+
+#[derive(Subcommand)]
+pub(crate) enum AuditSubcommand {
+    #[command(about = "Aggregate an audit log by endpoint and status")]
+    Summarize(SummarizeArgs),
+}
+
+#[derive(Args)]
+pub(crate) struct SummarizeArgs {
+    /// JSONL file written by `--audit-log`.
+    #[arg(value_name = "FILE")]
+    pub(crate) file: PathBuf,
+}
+
+#[derive(Debug, Clone, Serialize)]
+struct EndpointSummary {
+    method: String,
+    endpoint: String,
+    status: Option<u64>,
+    count: u64,
+    avg_latency_ms: f64,
+    max_latency_ms: f64,
+}
+
+impl OutputSchema for EndpointSummary {
+    fn output_schema() -> Value {
+        object_schema(
+            "Requests to one endpoint that ended with one status",
+            &[
+                ("method", string_schema("HTTP method")),
+                (
+                    "endpoint",
+                    string_schema(
+                        "URL path with addresses, numbers and type tags replaced by placeholders",
+                    ),
+                ),
+                (
+                    "status",
+                    nullable(integer_schema(
+                        "HTTP status; null when the request failed to send",
+                    )),
+                ),
+                ("count", integer_schema("Number of requests")),
+                (
+                    "avg_latency_ms",
+                    json!({ "description": "Mean latency in milliseconds", "type": "number" }),
+                ),
+                (
+                    "max_latency_ms",
+                    json!({ "description": "Highest latency in milliseconds", "type": "number" }),
+                ),
+            ],
+        )
+    }
+}
+
+pub(crate) fn summarize_output_schema() -> Value {
+    array_schema(
+        "Audit log totals sorted by endpoint, method and status",
+        EndpointSummary::output_schema(),
+    )
+}
+
+pub(crate) fn run_audit(command: AuditCommand) -> Result<()> {
+    match command.command {
+        AuditSubcommand::Summarize(args) => {
+            let body = fs::read_to_string(&args.file)
+                .with_context(|| format!("failed to read {}", args.file.display()))?;
+            let summary = summarize(&body)
+                .with_context(|| format!("failed to parse {}", args.file.display()))?;
+            crate::print_serialized(&summary)
+        }
+    }
+}
+
+fn summarize(log: &str) -> Result<Vec<EndpointSummary>> {
+    let mut groups: BTreeMap<(String, String, Option<u64>), (u64, f64, f64)> = BTreeMap::new();
+    for (index, line) in log.lines().enumerate() {
+        if line.trim().is_empty() {
+            continue;
+        }
+        let record: Value =
+            serde_json::from_str(line).with_context(|| format!("line {}", index + 1))?;
+        let method = record
+            .get("method")
+            .and_then(Value::as_str)
+            .unwrap_or_default()
+            .to_owned();
+        let endpoint = endpoint_template(
+            record
+                .get("url")
+                .and_then(Value::as_str)
+                .unwrap_or_default(),
+        );
+        let status = record.get("status").and_then(Value::as_u64);
+        let latency = record
+            .get("latency_ms")
+            .and_then(Value::as_f64)
+            .unwrap_or(0.0);
+
+        let (count, total, max) = groups
+            .entry((endpoint, method, status))
+            .or_insert((0, 0.0, 0.0));
+        *count += 1;
+        *total += latency;
+        *max = max.max(latency);
+    }
+
+    Ok(groups
+        .into_iter()
+        .map(
+            |((endpoint, method, status), (count, total, max))| EndpointSummary {
+                method,
+                endpoint,
+                status,
+                count,
+                avg_latency_ms: total / count as f64,
+                max_latency_ms: max,
+            },
+        )
+        .collect())
+}
+
+/// `https://node/v1/accounts/0x1/resource/0x1%3A%3Acoin%3A%3AX?ledger_version=5`
+/// -> `/v1/accounts/{address}/resource/{type}`.
+fn endpoint_template(url: &str) -> String {
+    let path = url.split_once("://").map_or(url, |(_, rest)| {
+        rest.find('/').map_or("/", |start| &rest[start..])
+    });
+    let path = path.split('?').next().unwrap_or_default();
+    path.split('/')
+        .map(|segment| {
+            let decoded = urlencoding::decode(segment)
+                .map(|decoded| decoded.into_owned())
+                .unwrap_or_else(|_| segment.to_owned());
+            if decoded.contains("::") {
+                "{type}".to_owned()
+            } else if decoded.starts_with("0x") {
+                "{address}".to_owned()
+            } else if !decoded.is_empty() && decoded.chars().all(|ch| ch.is_ascii_digit()) {
+                "{number}".to_owned()
+            } else {
+                decoded
+            }
+        })
+        .collect::<Vec<_>>()
+        .join("/")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::commands::schema::validate;
+
+    #[test]
+    fn groups_by_endpoint_and_status() {
+        let log = [
+            r#"{"method":"GET","url":"https://n/v1/accounts/0x1/resource/0x1%3A%3Aaccount%3A%3AAccount?ledger_version=9","status":200,"latency_ms":10.0}"#,
+            r#"{"method":"GET","url":"https://n/v1/accounts/0x2/resource/0x1::coin::CoinInfo","status":200,"latency_ms":30.0}"#,
+            r#"{"method":"GET","url":"https://n/v1/accounts/0x3/resource/0x1::coin::CoinInfo","status":404,"latency_ms":5.0}"#,
+            "",
+            r#"{"method":"GET","url":"http://127.0.0.1:9/v1/transactions/by_version/12","status":null,"latency_ms":1.5,"error":"connect"}"#,
+        ]
+        .join("\n");
+        let summary = summarize(&log).unwrap();
+        let output = serde_json::to_value(&summary).unwrap();
+        assert_eq!(
+            output,
+            json!([
+                {"method": "GET", "endpoint": "/v1/accounts/{address}/resource/{type}", "status": 200, "count": 2, "avg_latency_ms": 20.0, "max_latency_ms": 30.0},
+                {"method": "GET", "endpoint": "/v1/accounts/{address}/resource/{type}", "status": 404, "count": 1, "avg_latency_ms": 5.0, "max_latency_ms": 5.0},
+                {"method": "GET", "endpoint": "/v1/transactions/by_version/{number}", "status": null, "count": 1, "avg_latency_ms": 1.5, "max_latency_ms": 1.5},
+            ])
+        );
+        validate(&summarize_output_schema(), &output).unwrap();
+        assert!(summarize("not json").is_err());
+    }
+}
@@ -0,0 +1,695 @@
+use anyhow::{anyhow, Context, Result};
+use aptly_aptos::AptosClient;
+use clap::Args;
+use serde::Serialize;
+use serde_json::{json, Value};
+
+use crate::commands::account_txs::{account_transactions, TxOrder};
+use crate::commands::common::{
+    get_nested_string, normalize_address, parse_u64, with_optional_ledger_version,
+};
+use crate::commands::schema::{
+    array_schema, boolean_schema, integer_schema, nullable, object_schema, string_schema,
+    with_optional_properties, OutputSchema,
+};
+
+const ACCOUNT_TYPE: &str = "0x1::account::Account";
+const ORIGINATING_ADDRESS_TYPE: &str = "0x1::account::OriginatingAddress";
+/// Authentication key scheme byte appended to a single Ed25519 public key.
+const ED25519_SCHEME: u8 = 0x00;
+/// Module event `account::rotate_authentication_key` emits once event
+/// handles are migrated.
+const KEY_ROTATION_EVENT_TYPE: &str = "0x1::account::KeyRotation";
+/// Largest page the node serves for event queries.
+const EVENTS_PAGE_LIMIT: u64 = 100;
+
+#[derive(Args)]
+pub(crate) struct AuthArgs {
+    /// Account address (`0x...`), or an authentication key with `--by-auth-key`.
+    #[arg(value_name = "ADDRESS")]
+    pub(crate) address: String,
+    /// Treat the argument as an authentication key and resolve the account it
+    /// controls through `0x1::account::OriginatingAddress`.
+    #[arg(long, default_value_t = false)]
+    pub(crate) by_auth_key: bool,
+    /// Ed25519 public key (`0x...`) to check against the current
+    /// authentication key.
+    #[arg(long, value_name = "HEX")]
+    pub(crate) public_key: Option<String>,
+    /// Read from a historical ledger version.
+    #[arg(long)]
+    pub(crate) ledger_version: Option<u64>,
+}
+
+#[derive(Args)]
+pub(crate) struct KeyRotationArgs {
+    /// Account address (`0x...`).
+    #[arg(value_name = "ADDRESS")]
+    pub(crate) address: String,
+    /// Recent account transactions to scan for `0x1::account::KeyRotation`
+    /// module events, which newer rotations emit instead of handle events.
+    #[arg(long, value_name = "N", default_value_t = 1000)]
+    pub(crate) scan: u64,
+}
+
+/// `account key-rotation` output.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize)]
+struct KeyRotationReport {
+    address: String,
+    authentication_key: String,
+    /// Whether the current key differs from the address.
+    rotated: bool,
+    /// Whether the key was ever rotated, even if later rotated back.
+    ever_rotated: bool,
+    /// Account `OriginatingAddress` maps the current key to; null when the
+    /// key is not in the table.
+    originating_address: Option<String>,
+    last_rotation_version: Option<u64>,
+    /// Rotations found, oldest first.
+    rotations: Vec<KeyRotation>,
+}
+
+#[derive(Debug, Clone, PartialEq, Eq, Serialize)]
+struct KeyRotation {
+    version: u64,
+    old_authentication_key: String,
+    new_authentication_key: String,
+    /// `handle` for `key_rotation_events`, `module` for `KeyRotation`.
+    source: &'static str,
+}
+
+impl OutputSchema for KeyRotationReport {
+    fn output_schema() -> Value {
+        object_schema(
+            "Authentication key rotation state and history of an account",
+            &[
+                ("address", string_schema("Account address (64-hex)")),
+                (
+                    "authentication_key",
+                    string_schema("Current authentication key"),
+                ),
+                (
+                    "rotated",
+                    boolean_schema("Whether the current key differs from the address"),
+                ),
+                (
+                    "ever_rotated",
+                    boolean_schema("Whether the key is rotated now or any rotation was found"),
+                ),
+                (
+                    "originating_address",
+                    nullable(string_schema(
+                        "Address `0x1::account::OriginatingAddress` maps the current key to",
+                    )),
+                ),
+                (
+                    "last_rotation_version",
+                    nullable(integer_schema("Version of the newest rotation found")),
+                ),
+                (
+                    "rotations",
+                    array_schema(
+                        "Rotations found, oldest first",
+                        object_schema(
+                            "One key rotation",
+                            &[
+                                ("version", integer_schema("Ledger version of the rotation")),
+                                (
+                                    "old_authentication_key",
+                                    string_schema("Key before the rotation"),
+                                ),
+                                (
+                                    "new_authentication_key",
+                                    string_schema("Key after the rotation"),
+                                ),
+                                (
+                                    "source",
+                                    string_schema(
+                                        "`handle` (key_rotation_events) or `module` (KeyRotation event)",
+                                    ),
+                                ),
+                            ],
+                        ),
+                    ),
+                ),
+            ],
+        )
+    }
+}
+
+pub(crate) fn key_rotation_output_schema() -> Value {
+    KeyRotationReport::output_schema()
+}
+
+#[derive(Debug, Clone, Serialize)]
+struct AccountAuth {
+    address: String,
+    authentication_key: String,
+    rotated: bool,
+    sequence_number: String,
+    rotation_capability_offer: Option<String>,
+    signer_capability_offer: Option<String>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    auth_key_lookup: Option<AuthKeyLookup>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    public_key_check: Option<PublicKeyCheck>,
+}
+
+/// How `--by-auth-key` resolved the account.
+#[derive(Debug, Clone, Serialize)]
+struct AuthKeyLookup {
+    authentication_key: String,
+    in_originating_table: bool,
+}
+
+#[derive(Debug, Clone, Serialize)]
+struct PublicKeyCheck {
+    public_key: String,
+    derived_authentication_key: String,
+    controls_account: bool,
+}
+
+impl OutputSchema for AuthKeyLookup {
+    fn output_schema() -> Value {
+        object_schema(
+            "Reverse lookup of an authentication key (`--by-auth-key`)",
+            &[
+                (
+                    "authentication_key",
+                    string_schema("Authentication key looked up"),
+                ),
+                (
+                    "in_originating_table",
+                    boolean_schema(
+                        "Whether the key was found in `OriginatingAddress`; if not, the key is taken as an unrotated address",
+                    ),
+                ),
+            ],
+        )
+    }
+}
+
+impl OutputSchema for PublicKeyCheck {
+    fn output_schema() -> Value {
+        object_schema(
+            "Whether a public key controls the account (`--public-key`)",
+            &[
+                ("public_key", string_schema("Ed25519 public key checked")),
+                (
+                    "derived_authentication_key",
+                    string_schema("SHA3-256 of the public key and the Ed25519 scheme byte"),
+                ),
+                (
+                    "controls_account",
+                    boolean_schema("Whether signing with this key authorizes the account"),
+                ),
+            ],
+        )
+    }
+}
+
+impl OutputSchema for AccountAuth {
+    fn output_schema() -> Value {
+        let schema = object_schema(
+            "Authentication state of an account",
+            &[
+                ("address", string_schema("Account address")),
+                (
+                    "authentication_key",
+                    string_schema("Current authentication key"),
+                ),
+                (
+                    "rotated",
+                    boolean_schema("Whether the authentication key differs from the address"),
+                ),
+                ("sequence_number", string_schema("Account sequence number")),
+                (
+                    "rotation_capability_offer",
+                    nullable(string_schema(
+                        "Recipient of an outstanding rotation capability offer",
+                    )),
+                ),
+                (
+                    "signer_capability_offer",
+                    nullable(string_schema(
+                        "Recipient of an outstanding signer capability offer",
+                    )),
+                ),
+            ],
+        );
+        with_optional_properties(
+            schema,
+            &[
+                ("auth_key_lookup", AuthKeyLookup::output_schema()),
+                ("public_key_check", PublicKeyCheck::output_schema()),
+            ],
+        )
+    }
+}
+
+pub(crate) fn auth_output_schema() -> Value {
+    AccountAuth::output_schema()
+}
+
+pub(crate) fn run_account_auth(client: &AptosClient, args: &AuthArgs) -> Result<()> {
+    let (address, auth_key_lookup) = if args.by_auth_key {
+        let auth_key = normalize_address(&args.address);
+        let originating = lookup_originating_address(client, &auth_key, args.ledger_version)?;
+        let lookup = AuthKeyLookup {
+            authentication_key: auth_key.clone(),
+            in_originating_table: originating.is_some(),
+        };
+        (originating.unwrap_or(auth_key), Some(lookup))
+    } else {
+        (args.address.clone(), None)
+    };
+
+    let path = with_optional_ledger_version(
+        &format!(
+            "/accounts/{address}/resource/{}",
+            urlencoding::encode(ACCOUNT_TYPE)
+        ),
+        args.ledger_version,
+    );
+    let account = client.get_json(&path).map_err(|err| {
+        if err.to_string().contains("status 404") {
+            anyhow!("account {address} does not exist")
+        } else {
+            err
+        }
+    })?;
+    let mut report = account_auth(&address, &account)?;
+    report.auth_key_lookup = auth_key_lookup;
+    if let Some(public_key) = &args.public_key {
+        report.public_key_check = Some(check_public_key(public_key, &report.authentication_key)?);
+    }
+    crate::print_serialized(&report)
+}
+
+/// `account key-rotation`: the current key, its `OriginatingAddress`
+/// entry, and rotations from the account's `key_rotation_events` handle and
+/// the `KeyRotation` module events of its recent transactions.
+pub(crate) fn run_account_key_rotation(client: &AptosClient, args: &KeyRotationArgs) -> Result<()> {
+    let address = normalize_address(&args.address);
+    let account = client
+        .get_json(&format!(
+            "/accounts/{address}/resource/{}",
+            urlencoding::encode(ACCOUNT_TYPE)
+        ))
+        .map_err(|err| {
+            if err.to_string().contains("status 404") {
+                anyhow!("account {address} does not exist")
+            } else {
+                err
+            }
+        })?;
+    let auth = account_auth(&address, &account)?;
+    let originating = lookup_originating_address(client, &auth.authentication_key, None)?;
+    let handle_events = key_rotation_handle_events(client, &address)?;
+    let transactions = if args.scan == 0 {
+        Vec::new()
+    } else {
+        account_transactions(client, &address, None, Some(args.scan), TxOrder::Desc)?
+    };
+    let rotations = rotation_history(&address, &handle_events, &transactions);
+    crate::print_serialized(&key_rotation_report(auth, originating, rotations))
+}
+
+/// Every event of the account's `key_rotation_events` handle; none when the
+/// handle is gone.
+fn key_rotation_handle_events(client: &AptosClient, address: &str) -> Result<Vec<Value>> {
+    let mut events = Vec::new();
+    loop {
+        let path = format!(
+            "/accounts/{address}/events/{}/key_rotation_events?start={}&limit={EVENTS_PAGE_LIMIT}",
+            urlencoding::encode(ACCOUNT_TYPE),
+            events.len()
+        );
+        let page = match client.get_json(&path) {
+            Ok(Value::Array(page)) => page,
+            Ok(_) => return Err(anyhow!("unexpected events response format")),
+            Err(err) if err.to_string().contains("status 404") => break,
+            Err(err) => return Err(err).context("failed to read key_rotation_events"),
+        };
+        let full = page.len() as u64 == EVENTS_PAGE_LIMIT;
+        events.extend(page);
+        if !full {
+            break;
+        }
+    }
+    Ok(events)
+}
+
+/// Rotations from `handle_events` and from `KeyRotation` events of
+/// `address` in `transactions`, oldest first. A rotation reported both
+/// ways is listed once, from the handle.
+fn rotation_history(
+    address: &str,
+    handle_events: &[Value],
+    transactions: &[Value],
+) -> Vec<KeyRotation> {
+    let rotation = |version: Option<u64>, data: &Value, source| {
+        Some(KeyRotation {
+            version: version?,
+            old_authentication_key: get_nested_string(data, &["old_authentication_key"]),
+            new_authentication_key: get_nested_string(data, &["new_authentication_key"]),
+            source,
+        })
+    };
+    let mut rotations: Vec<KeyRotation> = handle_events
+        .iter()
+        .filter_map(|event| {
+            rotation(
+                event.get("version").and_then(parse_u64),
+                event.get("data").unwrap_or(&Value::Null),
+                "handle",
+            )
+        })
+        .collect();
+    for tx in transactions {
+        let version = tx.get("version").and_then(parse_u64);
+        let events = tx
+            .get("events")
+            .and_then(Value::as_array)
+            .map(Vec::as_slice)
+            .unwrap_or_default();
+        for event in events {
+            let data = event.get("data").unwrap_or(&Value::Null);
+            if get_nested_string(event, &["type"]) != KEY_ROTATION_EVENT_TYPE
+                || normalize_address(&get_nested_string(data, &["account"])) != address
+            {
+                continue;
+            }
+            if let Some(found) = rotation(version, data, "module") {
+                if !rotations.iter().any(|known| {
+                    known.version == found.version
+                        && known.new_authentication_key == found.new_authentication_key
+                }) {
+                    rotations.push(found);
+                }
+            }
+        }
+    }
+    rotations.sort_by_key(|rotation| rotation.version);
+    rotations
+}
+
+fn key_rotation_report(
+    auth: AccountAuth,
+    originating_address: Option<String>,
+    rotations: Vec<KeyRotation>,
+) -> KeyRotationReport {
+    KeyRotationReport {
+        ever_rotated: auth.rotated || !rotations.is_empty(),
+        last_rotation_version: rotations.last().map(|rotation| rotation.version),
+        address: normalize_address(&auth.address),
+        authentication_key: auth.authentication_key,
+        rotated: auth.rotated,
+        originating_address: originating_address.map(|address| normalize_address(&address)),
+        rotations,
+    }
+}
+
+fn account_auth(address: &str, account: &Value) -> Result<AccountAuth> {
+    let authentication_key = get_nested_string(account, &["data", "authentication_key"]);
+    if authentication_key.is_empty() {
+        return Err(anyhow!("unexpected {ACCOUNT_TYPE} format"));
+    }
+    Ok(AccountAuth {
+        address: address.to_owned(),
+        rotated: normalize_address(&authentication_key) != normalize_address(address),
+        authentication_key,
+        sequence_number: get_nested_string(account, &["data", "sequence_number"]),
+        rotation_capability_offer: capability_offer(account, "rotation_capability_offer"),
+        signer_capability_offer: capability_offer(account, "signer_capability_offer"),
+        auth_key_lookup: None,
+        public_key_check: None,
+    })
+}
+
+/// Recipient of a `CapabilityOffer { for: Option<address> }`.
+fn capability_offer(account: &Value, field: &str) -> Option<String> {
+    account
+        .get("data")?
+        .get(field)?
+        .pointer("/for/vec/0")?
+        .as_str()
+        .map(str::to_owned)
+}
+
+/// Address recorded for `auth_key` in the `OriginatingAddress` table, which
+/// only holds accounts that rotated their key.
+fn lookup_originating_address(
+    client: &AptosClient,
+    auth_key: &str,
+    ledger_version: Option<u64>,
+) -> Result<Option<String>> {
+    let path = with_optional_ledger_version(
+        &format!(
+            "/accounts/0x1/resource/{}",
+            urlencoding::encode(ORIGINATING_ADDRESS_TYPE)
+        ),
+        ledger_version,
+    );
+    let resource = client.get_json(&path)?;
+    let handle = get_nested_string(&resource, &["data", "address_map", "handle"]);
+    if handle.is_empty() {
+        return Err(anyhow!("unexpected {ORIGINATING_ADDRESS_TYPE} format"));
+    }
+    let item = client.post_json(
+        &with_optional_ledger_version(&format!("/tables/{handle}/item"), ledger_version),
+        &json!({
+            "key_type": "address",
+            "value_type": "address",
+            "key": auth_key,
+        }),
+    );
+    match item {
+        Ok(value) => Ok(value.as_str().map(str::to_owned)),
+        Err(err) if err.to_string().contains("status 404") => Ok(None),
+        Err(err) => Err(err),
+    }
+}
+
+fn check_public_key(public_key: &str, authentication_key: &str) -> Result<PublicKeyCheck> {
+    let bytes =
+        hex::decode(public_key.trim_start_matches("0x")).context("public key is not valid hex")?;
+    if bytes.len() != 32 {
+        return Err(anyhow!(
+            "expected a 32-byte Ed25519 public key, got {} bytes",
+            bytes.len()
+        ));
+    }
+    let derived = format!("0x{}", hex::encode(ed25519_authentication_key(&bytes)));
+    Ok(PublicKeyCheck {
+        public_key: format!("0x{}", hex::encode(&bytes)),
+        controls_account: derived == normalize_address(authentication_key),
+        derived_authentication_key: derived,
+    })
+}
+
+fn ed25519_authentication_key(public_key: &[u8]) -> [u8; 32] {
+    let mut preimage = public_key.to_vec();
+    preimage.push(ED25519_SCHEME);
+    sha3_256(&preimage)
+}
+
+const KECCAK_ROUND_CONSTANTS: [u64; 24] = [
+    0x0000_0000_0000_0001,
+    0x0000_0000_0000_8082,
+    0x8000_0000_0000_808a,
+    0x8000_0000_8000_8000,
+    0x0000_0000_0000_808b,
+    0x0000_0000_8000_0001,
+    0x8000_0000_8000_8081,
+    0x8000_0000_0000_8009,
+    0x0000_0000_0000_008a,
+    0x0000_0000_0000_0088,
+    0x0000_0000_8000_8009,
+    0x0000_0000_8000_000a,
+    0x0000_0000_8000_808b,
+    0x8000_0000_0000_008b,
+    0x8000_0000_0000_8089,
+    0x8000_0000_0000_8003,
+    0x8000_0000_0000_8002,
+    0x8000_0000_0000_0080,
+    0x0000_0000_0000_800a,
+    0x8000_0000_8000_000a,
+    0x8000_0000_8000_8081,
+    0x8000_0000_0000_8080,
+    0x0000_0000_8000_0001,
+    0x8000_0000_8000_8008,
+];
+
+/// Rho rotation offsets, indexed by lane `x + 5 * y`.
+const KECCAK_ROTATIONS: [u32; 25] = [
+    0, 1, 62, 28, 27, 36, 44, 6, 55, 20, 3, 10, 43, 25, 39, 41, 45, 15, 21, 8, 18, 2, 61, 56, 14,
+];
+
+/// SHA3-256 (FIPS 202), the hash Aptos derives authentication keys and
+/// object addresses with. Kept inline rather than pulling in a hash crate.
+pub(crate) fn sha3_256(input: &[u8]) -> [u8; 32] {
+    const RATE: usize = 136;
+    let mut state = [0u64; 25];
+    let mut padded = input.to_vec();
+    padded.push(0x06);
+    padded.resize(padded.len().div_ceil(RATE) * RATE, 0);
+    *padded.last_mut().expect("padded input is never empty") |= 0x80;
+
+    for block in padded.chunks(RATE) {
+        for (lane, bytes) in state.iter_mut().zip(block.chunks(8)) {
+            *lane ^= u64::from_le_bytes(bytes.try_into().expect("8-byte lane"));
+        }
+        keccak_f(&mut state);
+    }
+
+    let mut digest = [0u8; 32];
+    for (bytes, lane) in digest.chunks_mut(8).zip(state) {
+        bytes.copy_from_slice(&lane.to_le_bytes());
+    }
+    digest
+}
+
+fn keccak_f(state: &mut [u64; 25]) {
+    for round_constant in KECCAK_ROUND_CONSTANTS {
+        let mut columns = [0u64; 5];
+        for (x, column) in columns.iter_mut().enumerate() {
+            *column = state[x] ^ state[x + 5] ^ state[x + 10] ^ state[x + 15] ^ state[x + 20];
+        }
+        for x in 0..5 {
+            let d = columns[(x + 4) % 5] ^ columns[(x + 1) % 5].rotate_left(1);
+            for y in 0..5 {
+                state[x + 5 * y] ^= d;
+            }
+        }
+
+        let mut moved = [0u64; 25];
+        for x in 0..5 {
+            for y in 0..5 {
+                moved[y + 5 * ((2 * x + 3 * y) % 5)] =
+                    state[x + 5 * y].rotate_left(KECCAK_ROTATIONS[x + 5 * y]);
+            }
+        }
+
+        for x in 0..5 {
+            for y in 0..5 {
+                state[x + 5 * y] =
+                    moved[x + 5 * y] ^ (!moved[(x + 1) % 5 + 5 * y] & moved[(x + 2) % 5 + 5 * y]);
+            }
+        }
+        state[0] ^= round_constant;
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::commands::schema::validate;
+
+    #[test]
+    fn sha3_matches_fips_202_vectors() {
+        assert_eq!(
+            hex::encode(sha3_256(b"")),
+            "a7ffc6f8bf1ed76651c14756a061d662f580ff4de43b49fa82d80a4b80f8434a"
+        );
+        assert_eq!(
+            hex::encode(sha3_256(b"abc")),
+            "3a985da74fe225b2045c172d6bd390bd855f086e3e9d525b46bfe24511431532"
+        );
+        let long = vec![b'a'; 200];
+        assert_eq!(sha3_256(&long).len(), 32);
+    }
+
+    #[test]
+    fn reports_rotation_and_key_control() {
+        let public_key = format!("0x{}", "11".repeat(32));
+        let derived = format!("0x{}", hex::encode(ed25519_authentication_key(&[0x11; 32])));
+        let account = json!({
+            "type": ACCOUNT_TYPE,
+            "data": {
+                "authentication_key": derived,
+                "sequence_number": "12",
+                "rotation_capability_offer": {"for": {"vec": ["0x5"]}},
+                "signer_capability_offer": {"for": {"vec": []}},
+            },
+        });
+        let mut report = account_auth("0x1234", &account).unwrap();
+        assert!(report.rotated);
+        assert_eq!(report.rotation_capability_offer.as_deref(), Some("0x5"));
+        assert_eq!(report.signer_capability_offer, None);
+
+        let check = check_public_key(&public_key, &report.authentication_key).unwrap();
+        assert!(check.controls_account);
+        let other = check_public_key(&format!("0x{}", "22".repeat(32)), &derived).unwrap();
+        assert!(!other.controls_account);
+        assert!(check_public_key("0x1122", &derived).is_err());
+
+        report.public_key_check = Some(check);
+        let output = serde_json::to_value(&report).unwrap();
+        validate(&auth_output_schema(), &output).unwrap();
+    }
+
+    #[test]
+    fn merges_handle_and_module_rotations() {
+        let address = normalize_address("0xa11ce");
+        let rotated = |old: &str, new: &str| json!({"old_authentication_key": old, "new_authentication_key": new});
+        let handle_events = [json!({
+            "version": "120",
+            "sequence_number": "0",
+            "type": "0x1::account::KeyRotationEvent",
+            "data": rotated("0xa11ce", "0xb0b"),
+        })];
+        let transactions = [
+            json!({"version": "900", "events": [
+                {"type": KEY_ROTATION_EVENT_TYPE, "data": {"account": "0xa11ce", "old_authentication_key": "0xb0b", "new_authentication_key": "0xc0c"}},
+                {"type": KEY_ROTATION_EVENT_TYPE, "data": {"account": "0xdead", "old_authentication_key": "0x1", "new_authentication_key": "0x2"}},
+            ]}),
+            json!({"version": "120", "events": [
+                {"type": KEY_ROTATION_EVENT_TYPE, "data": {"account": "0xa11ce", "old_authentication_key": "0xa11ce", "new_authentication_key": "0xb0b"}},
+            ]}),
+        ];
+        let rotations = rotation_history(&address, &handle_events, &transactions);
+        let summary: Vec<(u64, &str, &str)> = rotations
+            .iter()
+            .map(|rotation| {
+                (
+                    rotation.version,
+                    rotation.new_authentication_key.as_str(),
+                    rotation.source,
+                )
+            })
+            .collect();
+        assert_eq!(
+            summary,
+            [(120, "0xb0b", "handle"), (900, "0xc0c", "module")]
+        );
+
+        let account = json!({"data": {"authentication_key": "0xc0c", "sequence_number": "3"}});
+        let report = key_rotation_report(
+            account_auth(&address, &account).unwrap(),
+            Some("0xa11ce".to_owned()),
+            rotations,
+        );
+        assert!(report.rotated && report.ever_rotated);
+        assert_eq!(report.last_rotation_version, Some(900));
+        assert_eq!(
+            report.originating_address.as_deref(),
+            Some(address.as_str())
+        );
+        validate(
+            &key_rotation_output_schema(),
+            &serde_json::to_value(&report).unwrap(),
+        )
+        .unwrap();
+
+        // Rotated and later rotated back to the address.
+        let account = json!({"data": {"authentication_key": address, "sequence_number": "5"}});
+        let report = key_rotation_report(
+            account_auth(&address, &account).unwrap(),
+            None,
+            rotation_history(&address, &handle_events, &[]),
+        );
+        assert!(!report.rotated);
+        assert!(report.ever_rotated);
+    }
+}
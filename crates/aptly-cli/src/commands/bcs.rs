@@ -0,0 +1,382 @@
+use anyhow::{anyhow, Context, Result};
+use aptly_aptos::AptosClient;
+use serde_json::{json, Map, Value};
+use std::collections::HashMap;
+
+use crate::commands::bindings::{split_type_args, MoveType};
+use crate::commands::common::value_to_string;
+
+/// Field names and type strings of `address::module::Name`, from the module ABI.
+pub(crate) fn struct_fields(client: &AptosClient, name: &str) -> Result<Vec<(String, String)>> {
+    let mut parts = name.splitn(3, "::");
+    let (Some(address), Some(module), Some(struct_name)) =
+        (parts.next(), parts.next(), parts.next())
+    else {
+        return Err(anyhow!("unrecognized struct `{name}`"));
+    };
+    let module_json = client
+        .get_json(&format!("/accounts/{address}/module/{module}"))
+        .with_context(|| format!("failed to read ABI of {address}::{module}"))?;
+    let layout = module_json
+        .pointer("/abi/structs")
+        .and_then(Value::as_array)
+        .and_then(|structs| {
+            structs
+                .iter()
+                .find(|item| item.get("name").and_then(Value::as_str) == Some(struct_name))
+        })
+        .ok_or_else(|| anyhow!("struct {name} not found in module ABI"))?;
+    Ok(layout
+        .get("fields")
+        .and_then(Value::as_array)
+        .map(Vec::as_slice)
+        .unwrap_or_default()
+        .iter()
+        .map(|field| {
+            (
+                value_to_string(field.get("name").unwrap_or(&Value::Null)),
+                value_to_string(field.get("type").unwrap_or(&Value::Null)),
+            )
+        })
+        .collect())
+}
+
+/// Resolves struct layouts through `fields` (cached per struct) and decodes
+/// BCS values into the JSON the node prints for them.
+pub(crate) struct StructLayouts<F> {
+    fields: F,
+    layouts: HashMap<String, Vec<(String, String)>>,
+}
+
+impl<F: FnMut(&str) -> Result<Vec<(String, String)>>> StructLayouts<F> {
+    pub(crate) fn new(fields: F) -> Self {
+        Self {
+            fields,
+            layouts: HashMap::new(),
+        }
+    }
+
+    /// Field names and resolved types of a struct instantiation. Errors name
+    /// the field whose type could not be resolved.
+    pub(crate) fn layout(&mut self, tag: &str) -> Result<Vec<(String, MoveType)>> {
+        let (base, type_args) = split_type_args(tag)?;
+        let type_args = type_args
+            .into_iter()
+            .map(MoveType::parse)
+            .collect::<Result<Vec<_>>>()?;
+        if !self.layouts.contains_key(base) {
+            let fields = (self.fields)(base)?;
+            self.layouts.insert(base.to_owned(), fields);
+        }
+        self.layouts[base]
+            .iter()
+            .map(|(name, ty)| {
+                let resolved = MoveType::parse(ty)
+                    .with_context(|| format!("unsupported type `{ty}`"))
+                    .and_then(|ty| substitute(ty, &type_args));
+                match resolved {
+                    Ok(ty) => Ok((name.clone(), ty)),
+                    Err(err) => Err(anyhow!("field `{name}` of {base}: {err:#}")),
+                }
+            })
+            .collect()
+    }
+
+    /// Reads one value of type `ty` from the front of `input`. `path` names
+    /// the value in errors, e.g. `data.coin.value`.
+    pub(crate) fn read(&mut self, ty: &MoveType, input: &mut &[u8], path: &str) -> Result<Value> {
+        match ty {
+            MoveType::Bool => match take(input, 1, path)?[0] {
+                0 => Ok(Value::Bool(false)),
+                1 => Ok(Value::Bool(true)),
+                other => Err(anyhow!("{path}: invalid bool byte {other}")),
+            },
+            MoveType::U8 => Ok(json!(take(input, 1, path)?[0])),
+            MoveType::U16 => Ok(json!(u16::from_le_bytes(
+                take(input, 2, path)?.try_into().unwrap()
+            ))),
+            MoveType::U32 => Ok(json!(u32::from_le_bytes(
+                take(input, 4, path)?.try_into().unwrap()
+            ))),
+            MoveType::U64 => Ok(Value::String(le_to_decimal(&take(input, 8, path)?))),
+            MoveType::U128 => Ok(Value::String(le_to_decimal(&take(input, 16, path)?))),
+            MoveType::U256 => Ok(Value::String(le_to_decimal(&take(input, 32, path)?))),
+            MoveType::Address => Ok(Value::String(hex_literal(&take(input, 32, path)?))),
+            MoveType::Object => Ok(json!({ "inner": hex_literal(&take(input, 32, path)?) })),
+            MoveType::String => {
+                let len = read_uleb128(input, path)?;
+                let bytes = take(input, len, path)?;
+                String::from_utf8(bytes)
+                    .map(Value::String)
+                    .map_err(|_| anyhow!("{path}: string is not valid UTF-8"))
+            }
+            MoveType::Vector(inner) if **inner == MoveType::U8 => {
+                let len = read_uleb128(input, path)?;
+                let bytes = take(input, len, path)?;
+                Ok(Value::String(format!("0x{}", hex::encode(bytes))))
+            }
+            MoveType::Vector(inner) => {
+                let len = read_uleb128(input, path)?;
+                (0..len)
+                    .map(|index| self.read(inner, input, &format!("{path}[{index}]")))
+                    .collect::<Result<Vec<_>>>()
+                    .map(Value::Array)
+            }
+            MoveType::Option(inner) => match read_uleb128(input, path)? {
+                0 => Ok(json!({ "vec": [] })),
+                1 => Ok(json!({ "vec": [self.read(inner, input, &format!("{path}.vec[0]"))?] })),
+                other => Err(anyhow!("{path}: option with {other} elements")),
+            },
+            MoveType::Struct(tag) => {
+                let layout = self.layout(tag).map_err(|err| anyhow!("{path}: {err:#}"))?;
+                let mut object = Map::new();
+                for (name, field_type) in layout {
+                    let value = self.read(&field_type, input, &format!("{path}.{name}"))?;
+                    object.insert(name, value);
+                }
+                Ok(Value::Object(object))
+            }
+            MoveType::Signer | MoveType::Reference(_) | MoveType::Generic(_) => {
+                Err(anyhow!("{path}: {ty:?} has no BCS layout"))
+            }
+        }
+    }
+}
+
+/// Replaces `T0`, `T1`, ... with the struct's type arguments.
+pub(crate) fn substitute(ty: MoveType, type_args: &[MoveType]) -> Result<MoveType> {
+    Ok(match ty {
+        MoveType::Generic(index) => type_args
+            .get(index)
+            .cloned()
+            .ok_or_else(|| anyhow!("missing type argument T{index}"))?,
+        MoveType::Vector(inner) => MoveType::Vector(Box::new(substitute(*inner, type_args)?)),
+        MoveType::Option(inner) => MoveType::Option(Box::new(substitute(*inner, type_args)?)),
+        MoveType::Struct(tag) if tag.contains('<') => {
+            let (base, args) = split_type_args(&tag)?;
+            let args = args
+                .into_iter()
+                .map(|arg| Ok(render(&substitute(MoveType::parse(arg)?, type_args)?)))
+                .collect::<Result<Vec<_>>>()?;
+            MoveType::Struct(format!("{base}<{}>", args.join(", ")))
+        }
+        other => other,
+    })
+}
+
+/// Type tag text of a parsed type; only needed for nested struct arguments.
+fn render(ty: &MoveType) -> String {
+    match ty {
+        MoveType::Bool => "bool".to_owned(),
+        MoveType::U8 => "u8".to_owned(),
+        MoveType::U16 => "u16".to_owned(),
+        MoveType::U32 => "u32".to_owned(),
+        MoveType::U64 => "u64".to_owned(),
+        MoveType::U128 => "u128".to_owned(),
+        MoveType::U256 => "u256".to_owned(),
+        MoveType::Address => "address".to_owned(),
+        MoveType::Signer => "signer".to_owned(),
+        MoveType::String => "0x1::string::String".to_owned(),
+        // The inner type of `Object<T>` does not affect its encoding.
+        MoveType::Object => "0x1::object::Object<0x1::object::ObjectCore>".to_owned(),
+        MoveType::Vector(inner) => format!("vector<{}>", render(inner)),
+        MoveType::Option(inner) => format!("0x1::option::Option<{}>", render(inner)),
+        MoveType::Reference(inner) => format!("&{}", render(inner)),
+        MoveType::Generic(index) => format!("T{index}"),
+        MoveType::Struct(tag) => tag.clone(),
+    }
+}
+
+/// `0x` followed by the address without leading zeros, as the node prints
+/// addresses inside Move values.
+fn hex_literal(bytes: &[u8]) -> String {
+    let digits = hex::encode(bytes);
+    let trimmed = digits.trim_start_matches('0');
+    format!("0x{}", if trimmed.is_empty() { "0" } else { trimmed })
+}
+
+pub(crate) fn take(input: &mut &[u8], count: usize, path: &str) -> Result<Vec<u8>> {
+    if input.len() < count {
+        return Err(anyhow!("{path}: BCS input ends early"));
+    }
+    let (head, rest) = input.split_at(count);
+    *input = rest;
+    Ok(head.to_vec())
+}
+
+pub(crate) fn read_uleb128(input: &mut &[u8], path: &str) -> Result<usize> {
+    let mut value: u64 = 0;
+    for shift in (0..64).step_by(7) {
+        let (&byte, rest) = input
+            .split_first()
+            .ok_or_else(|| anyhow!("{path}: BCS input ends inside a length"))?;
+        *input = rest;
+        value |= u64::from(byte & 0x7f) << shift;
+        if byte & 0x80 == 0 {
+            return usize::try_from(value).map_err(|_| anyhow!("{path}: length too large"));
+        }
+    }
+    Err(anyhow!("{path}: malformed ULEB128 length"))
+}
+
+/// Decimal string of a little-endian unsigned integer of any width.
+pub(crate) fn le_to_decimal(bytes: &[u8]) -> String {
+    let mut digits = bytes.to_vec();
+    digits.reverse();
+    let mut out = Vec::new();
+    while digits.iter().any(|&byte| byte != 0) {
+        let mut remainder = 0u32;
+        for byte in digits.iter_mut() {
+            let current = (remainder << 8) | u32::from(*byte);
+            *byte = (current / 10) as u8;
+            remainder = current % 10;
+        }
+        out.push(b'0' + remainder as u8);
+    }
+    if out.is_empty() {
+        return "0".to_owned();
+    }
+    out.reverse();
+    String::from_utf8(out).unwrap()
+}
+
+pub(crate) fn decode_hex(value: &str) -> Option<Vec<u8>> {
+    hex::decode(value.strip_prefix("0x").unwrap_or(value)).ok()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn layouts() -> StructLayouts<impl FnMut(&str) -> Result<Vec<(String, String)>>> {
+        let fields = |pairs: &[(&str, &str)]| {
+            Ok(pairs
+                .iter()
+                .map(|(name, ty)| (name.to_string(), ty.to_string()))
+                .collect())
+        };
+        StructLayouts::new(move |name: &str| match name {
+            "0x1::coin::Coin" => fields(&[("value", "u64")]),
+            "0x1::guid::ID" => fields(&[("creation_num", "u64"), ("addr", "address")]),
+            "0x1::guid::GUID" => fields(&[("id", "0x1::guid::ID")]),
+            "0x1::event::EventHandle" => fields(&[("counter", "u64"), ("guid", "0x1::guid::GUID")]),
+            "0xabc::pool::Pair" => fields(&[("left", "T0"), ("right", "vector<T1>")]),
+            "0xabc::pool::Wrapped" => fields(&[("inner", "0xabc::pool::Pair<T0, u8>")]),
+            "0xabc::pool::Bad" => fields(&[("ok", "u8"), ("oops", "vector<u8")]),
+            other => Err(anyhow!("unknown struct {other}")),
+        })
+    }
+
+    fn decode(ty: &str, hex: &str) -> Result<Value> {
+        let bytes = decode_hex(hex).unwrap();
+        let mut input = bytes.as_slice();
+        let value = layouts().read(&MoveType::parse(ty)?, &mut input, "data")?;
+        assert!(input.is_empty(), "{ty}: {} bytes left", input.len());
+        Ok(value)
+    }
+
+    #[test]
+    fn decodes_known_vectors() {
+        let max_u64 = u64::MAX.to_string();
+        let max_u128 = u128::MAX.to_string();
+        let cases = [
+            ("bool", "01", json!(true)),
+            ("bool", "00", json!(false)),
+            ("u8", "ff", json!(255)),
+            ("u16", "3412", json!(0x1234)),
+            ("u32", "78563412", json!(0x1234_5678)),
+            ("u64", "0100000000000000", json!("1")),
+            ("u64", "ffffffffffffffff", json!(max_u64)),
+            ("u128", &"ff".repeat(16), json!(max_u128)),
+            ("u256", &format!("02{}", "00".repeat(31)), json!("2")),
+            ("address", &format!("{}01", "00".repeat(31)), json!("0x1")),
+            (
+                "address",
+                &"ab".repeat(32),
+                json!(format!("0x{}", "ab".repeat(32))),
+            ),
+            ("0x1::string::String", "0568656c6c6f", json!("hello")),
+            ("vector<u8>", "0301ff7f", json!("0x01ff7f")),
+            (
+                "vector<u64>",
+                &format!("02{}{}", "0100000000000000", "0200000000000000"),
+                json!(["1", "2"]),
+            ),
+            ("vector<bool>", "00", json!([])),
+            ("0x1::option::Option<u8>", "00", json!({"vec": []})),
+            ("0x1::option::Option<u8>", "0107", json!({"vec": [7]})),
+            (
+                "0x1::object::Object<0x1::object::ObjectCore>",
+                &format!("{}0a", "00".repeat(31)),
+                json!({"inner": "0xa"}),
+            ),
+            (
+                "0xabc::pool::Pair<address, 0x1::option::Option<u8>>",
+                &format!("{}02{}", "00".repeat(32), "000105"),
+                json!({"left": "0x0", "right": [{"vec": []}, {"vec": [5]}]}),
+            ),
+            (
+                "0xabc::pool::Wrapped<bool>",
+                "01020304",
+                json!({"inner": {"left": true, "right": "0x0304"}}),
+            ),
+        ];
+        for (ty, hex, expected) in cases {
+            assert_eq!(decode(ty, hex).unwrap(), expected, "{ty} {hex}");
+        }
+        // 300 bytes: a two-byte ULEB128 length.
+        let long = decode("vector<u8>", &format!("ac02{}", "11".repeat(300))).unwrap();
+        assert_eq!(long.as_str().unwrap().len(), 2 + 600);
+    }
+
+    #[test]
+    fn decodes_nested_framework_structs() {
+        // EventHandle { counter: 3, guid: GUID { id: ID { creation_num: 2, addr: 0x1 } } }
+        let hex = format!(
+            "0300000000000000{}{}01",
+            "0200000000000000",
+            "00".repeat(31)
+        );
+        assert_eq!(
+            decode("0x1::event::EventHandle", &hex).unwrap(),
+            json!({"counter": "3", "guid": {"id": {"creation_num": "2", "addr": "0x1"}}})
+        );
+    }
+
+    #[test]
+    fn errors_name_the_failing_field() {
+        let cases = [
+            ("u64", "0100", "data: BCS input ends early"),
+            ("bool", "02", "data: invalid bool byte 2"),
+            ("0x1::option::Option<u8>", "0201", "data: option with 2 elements"),
+            ("0x1::string::String", "02ff00", "data: string is not valid UTF-8"),
+            ("vector<u64>", "80", "data: BCS input ends inside a length"),
+            (
+                "vector<0x1::coin::Coin>",
+                "020100000000000000",
+                "data[1].value: BCS input ends early",
+            ),
+            (
+                "0xabc::pool::Pair",
+                "00",
+                "data: field `left` of 0xabc::pool::Pair: missing type argument T0",
+            ),
+            (
+                "0xabc::pool::Bad",
+                "0001",
+                "data: field `oops` of 0xabc::pool::Bad: unsupported type `vector<u8`: malformed Move type `vector<u8`",
+            ),
+            ("0xabc::pool::Nope", "00", "data: unknown struct 0xabc::pool::Nope"),
+        ];
+        for (ty, hex, message) in cases {
+            let err = decode(ty, hex).unwrap_err();
+            assert_eq!(format!("{err:#}"), message, "{ty}");
+        }
+    }
+
+    #[test]
+    fn converts_wide_integers() {
+        assert_eq!(le_to_decimal(&[0x39, 0x30]), "12345");
+        assert_eq!(le_to_decimal(&[0; 8]), "0");
+    }
+}
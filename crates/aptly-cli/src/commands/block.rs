@@ -1,20 +1,27 @@
 use anyhow::{anyhow, Result};
 use aptly_aptos::AptosClient;
 use clap::{Args, Subcommand};
+use serde_json::Value;
+
+use crate::commands::common::{get_nested_string, parse_u64};
+use crate::commands::follow::{run_follow, FollowArgs, FollowSource};
 
 #[derive(Args)]
 #[command(
-    after_help = "Examples:\n  aptly block 1000\n  aptly block 1000 --with-transactions\n  aptly block by-version 4300326632"
+    after_help = "Examples:\n  aptly block 1000\n  aptly block 1000 --with-transactions\n  aptly block by-version 4300326632\n  aptly block --follow --metrics-listen :9464"
 )]
 pub(crate) struct BlockCommand {
     #[command(subcommand)]
     pub(crate) command: Option<BlockSubcommand>,
-    /// Block height used when no subcommand is provided.
+    /// Block height used when no subcommand is provided; with `--follow`, the
+    /// first height to emit (defaults to the latest block).
     #[arg(value_name = "HEIGHT")]
     pub(crate) height: Option<String>,
     /// Include full transaction payloads in block response.
     #[arg(long, default_value_t = false)]
     pub(crate) with_transactions: bool,
+    #[command(flatten)]
+    pub(crate) follow: FollowArgs,
 }
 
 #[derive(Subcommand)]
@@ -34,6 +41,24 @@ pub(crate) struct ByVersionArgs {
 }
 
 pub(crate) fn run_block(client: &AptosClient, command: BlockCommand) -> Result<()> {
+    if command.follow.follow && command.command.is_none() {
+        let start = match &command.height {
+            Some(height) => height
+                .parse::<u64>()
+                .map_err(|_| anyhow!("invalid block height `{height}`"))?,
+            None => {
+                let ledger = client.get_json("/")?;
+                get_nested_string(&ledger, &["block_height"])
+                    .parse::<u64>()
+                    .map_err(|_| anyhow!("failed to parse `block_height` from ledger response"))?
+            }
+        };
+        let source = FollowSource::Blocks {
+            with_transactions: command.with_transactions,
+        };
+        return run_follow(client, source, start, 1, &command.follow);
+    }
+
     match command.command {
         Some(BlockSubcommand::ByVersion(args)) => {
             let path = format!(
@@ -56,3 +81,100 @@ pub(crate) fn run_block(client: &AptosClient, command: BlockCommand) -> Result<(
         }
     }
 }
+
+/// Timestamp and version span of one block.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub(crate) struct BlockSpan {
+    pub(crate) timestamp_us: u64,
+    pub(crate) first_version: u64,
+    pub(crate) last_version: u64,
+}
+
+/// First ledger version at or after `timestamp_us`, found by binary search
+/// over the block heights the node still serves. A timestamp past the latest
+/// block maps to the next version to be committed.
+pub(crate) fn version_at_timestamp(client: &AptosClient, timestamp_us: u64) -> Result<u64> {
+    let ledger = client.get_json("/")?;
+    let height = |field: &str| {
+        get_nested_string(&ledger, &[field])
+            .parse::<u64>()
+            .map_err(|_| anyhow!("failed to parse `{field}` from ledger response"))
+    };
+    first_version_at(
+        height("oldest_block_height")?,
+        height("block_height")?,
+        timestamp_us,
+        |height| fetch_block_span(client, height),
+    )
+}
+
+/// Searches heights `low..=high`; `block_at` is called O(log n) times.
+pub(crate) fn first_version_at(
+    low: u64,
+    high: u64,
+    timestamp_us: u64,
+    mut block_at: impl FnMut(u64) -> Result<BlockSpan>,
+) -> Result<u64> {
+    let latest = block_at(high)?;
+    if latest.timestamp_us < timestamp_us {
+        return Ok(latest.last_version + 1);
+    }
+    let (mut low, mut high, mut found) = (low, high, latest);
+    while low < high {
+        let mid = low + (high - low) / 2;
+        let block = block_at(mid)?;
+        if block.timestamp_us >= timestamp_us {
+            (high, found) = (mid, block);
+        } else {
+            low = mid + 1;
+        }
+    }
+    Ok(found.first_version)
+}
+
+fn fetch_block_span(client: &AptosClient, height: u64) -> Result<BlockSpan> {
+    let block = client.get_json(&format!(
+        "/blocks/by_height/{height}?with_transactions=false"
+    ))?;
+    let field = |name: &str| {
+        parse_u64(block.get(name).unwrap_or(&Value::Null))
+            .ok_or_else(|| anyhow!("block {height} has no `{name}`"))
+    };
+    Ok(BlockSpan {
+        timestamp_us: field("block_timestamp")?,
+        first_version: field("first_version")?,
+        last_version: field("last_version")?,
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    /// Block `h` holds versions `10h..10h+9` and was committed at `1000h` us.
+    fn block(height: u64) -> Result<BlockSpan> {
+        Ok(BlockSpan {
+            timestamp_us: height * 1000,
+            first_version: height * 10,
+            last_version: height * 10 + 9,
+        })
+    }
+
+    #[test]
+    fn finds_first_version_at_timestamp() {
+        let mut calls = 0;
+        let mut counted = |height| {
+            calls += 1;
+            block(height)
+        };
+        assert_eq!(
+            first_version_at(0, 1_000_000, 420_500, &mut counted).unwrap(),
+            4210
+        );
+        assert!(calls <= 22, "{calls} block fetches");
+
+        assert_eq!(first_version_at(0, 100, 42_000, block).unwrap(), 420);
+        assert_eq!(first_version_at(50, 100, 0, block).unwrap(), 500);
+        assert_eq!(first_version_at(0, 100, 100_001, block).unwrap(), 1010);
+    }
+}
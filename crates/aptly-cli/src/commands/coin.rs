@@ -0,0 +1,290 @@
+use anyhow::{anyhow, Context, Result};
+use aptly_aptos::AptosClient;
+use clap::{Args, Subcommand};
+use serde::Serialize;
+use serde_json::{json, Value};
+
+use crate::commands::common::{normalize_address, parse_u64, with_optional_ledger_version};
+use crate::commands::schema::{
+    boolean_schema, nullable, object_schema, string_schema, OutputSchema,
+};
+
+const FUNGIBLE_METADATA_TYPE: &str = "0x1::fungible_asset::Metadata";
+
+#[derive(Args)]
+#[command(
+    after_help = "Examples:\n  aptly coin migration 0x1::aptos_coin::AptosCoin\n  aptly coin migration 0x1::aptos_coin::AptosCoin --account 0x1234"
+)]
+pub(crate) struct CoinCommand {
+    #[command(subcommand)]
+    pub(crate) command: CoinSubcommand,
+}
+
+#[derive(Subcommand)]
+pub(crate) enum CoinSubcommand {
+    #[command(
+        about = "Report coin-to-fungible-asset migration status and split balances",
+        after_help = "With --account, `coin_store` + `primary_store` must equal `0x1::coin::balance`; a mismatch is printed and exits non-zero."
+    )]
+    Migration(CoinMigrationArgs),
+}
+
+#[derive(Args)]
+pub(crate) struct CoinMigrationArgs {
+    /// Coin type, e.g. `0x1::aptos_coin::AptosCoin`.
+    #[arg(value_name = "COIN_TYPE")]
+    pub(crate) coin_type: String,
+    /// Also report this account's balance in each representation.
+    #[arg(long, value_name = "ADDRESS")]
+    pub(crate) account: Option<String>,
+}
+
+#[derive(Debug, Clone, Serialize)]
+struct MigrationReport {
+    coin_type: String,
+    paired: bool,
+    metadata_address: Option<String>,
+    account: Option<SplitBalance>,
+}
+
+/// One account's holdings of an asset that may live in a legacy `CoinStore`,
+/// the paired fungible asset's primary store, or both.
+#[derive(Debug, Clone, Serialize)]
+pub(crate) struct SplitBalance {
+    address: String,
+    coin_store: String,
+    primary_store: String,
+    combined: String,
+    coin_balance_view: String,
+    consistent: bool,
+}
+
+impl OutputSchema for SplitBalance {
+    fn output_schema() -> Value {
+        object_schema(
+            "Account balance split between coin and fungible asset stores",
+            &[
+                ("address", string_schema("Account address")),
+                (
+                    "coin_store",
+                    string_schema("Balance in the legacy `0x1::coin::CoinStore` (base units)"),
+                ),
+                (
+                    "primary_store",
+                    string_schema("Balance in the paired FA primary store (base units)"),
+                ),
+                ("combined", string_schema("coin_store + primary_store")),
+                (
+                    "coin_balance_view",
+                    string_schema("What `0x1::coin::balance` reports"),
+                ),
+                (
+                    "consistent",
+                    boolean_schema("Whether `combined` equals `coin_balance_view`"),
+                ),
+            ],
+        )
+    }
+}
+
+impl OutputSchema for MigrationReport {
+    fn output_schema() -> Value {
+        object_schema(
+            "Coin to fungible asset migration status",
+            &[
+                ("coin_type", string_schema("Coin type queried")),
+                (
+                    "paired",
+                    boolean_schema("Whether the coin has paired FA metadata"),
+                ),
+                (
+                    "metadata_address",
+                    nullable(string_schema(
+                        "Paired `0x1::fungible_asset::Metadata` object",
+                    )),
+                ),
+                ("account", nullable(SplitBalance::output_schema())),
+            ],
+        )
+    }
+}
+
+pub(crate) fn migration_output_schema() -> Value {
+    MigrationReport::output_schema()
+}
+
+pub(crate) fn run_coin(client: &AptosClient, command: CoinCommand) -> Result<()> {
+    match command.command {
+        CoinSubcommand::Migration(args) => {
+            let metadata_address = paired_metadata(client, &args.coin_type)?;
+            let account = match &args.account {
+                Some(address) => Some(split_balance(
+                    client,
+                    address,
+                    &args.coin_type,
+                    metadata_address.as_deref(),
+                )?),
+                None => None,
+            };
+            let report = MigrationReport {
+                coin_type: args.coin_type,
+                paired: metadata_address.is_some(),
+                metadata_address,
+                account,
+            };
+            crate::print_serialized(&report)?;
+
+            match &report.account {
+                Some(split) if !split.consistent => Err(anyhow!(
+                    "MISMATCH: coin_store + primary_store = {} but 0x1::coin::balance reports {}",
+                    split.combined,
+                    split.coin_balance_view
+                )),
+                _ => Ok(()),
+            }
+        }
+    }
+}
+
+/// Paired FA metadata address of `coin_type`, or `None` before migration.
+pub(crate) fn paired_metadata(client: &AptosClient, coin_type: &str) -> Result<Option<String>> {
+    let value = view(client, "0x1::coin::paired_metadata", &[coin_type], vec![])
+        .with_context(|| format!("failed to read paired metadata of {coin_type}"))?;
+    Ok(parse_paired_metadata(&value))
+}
+
+/// Reads an account's holdings of `coin_type` in both representations.
+/// `metadata_address` is the coin's paired FA, if any.
+pub(crate) fn split_balance(
+    client: &AptosClient,
+    address: &str,
+    coin_type: &str,
+    metadata_address: Option<&str>,
+) -> Result<SplitBalance> {
+    let coin_store = coin_store_balance(client, address, coin_type, None)?;
+    let primary_store = match metadata_address {
+        Some(metadata) => first_u64(&view(
+            client,
+            "0x1::primary_fungible_store::balance",
+            &[FUNGIBLE_METADATA_TYPE],
+            vec![json!(address), json!(metadata)],
+        )?)
+        .ok_or_else(|| anyhow!("unexpected primary_fungible_store::balance response"))?,
+        None => 0,
+    };
+    let coin_balance_view = first_u64(&view(
+        client,
+        "0x1::coin::balance",
+        &[coin_type],
+        vec![json!(address)],
+    )?)
+    .ok_or_else(|| anyhow!("unexpected coin::balance response"))?;
+    Ok(reconcile(
+        address,
+        coin_store,
+        primary_store,
+        coin_balance_view,
+    ))
+}
+
+fn reconcile(address: &str, coin_store: u64, primary_store: u64, view: u64) -> SplitBalance {
+    let combined = u128::from(coin_store) + u128::from(primary_store);
+    SplitBalance {
+        address: normalize_address(address),
+        coin_store: coin_store.to_string(),
+        primary_store: primary_store.to_string(),
+        combined: combined.to_string(),
+        coin_balance_view: view.to_string(),
+        consistent: combined == u128::from(view),
+    }
+}
+
+/// Legacy `CoinStore<T>` value; a missing store holds nothing.
+/// Raw `CoinStore<coin_type>` balance of `address`, 0 without a store.
+pub(crate) fn coin_store_balance(
+    client: &AptosClient,
+    address: &str,
+    coin_type: &str,
+    ledger_version: Option<u64>,
+) -> Result<u64> {
+    let resource_type =
+        urlencoding::encode(&format!("0x1::coin::CoinStore<{coin_type}>")).into_owned();
+    let path = with_optional_ledger_version(
+        &format!("/accounts/{address}/resource/{resource_type}"),
+        ledger_version,
+    );
+    match client.get_json(&path) {
+        Ok(resource) => resource
+            .pointer("/data/coin/value")
+            .and_then(parse_u64)
+            .ok_or_else(|| anyhow!("unexpected CoinStore resource format")),
+        Err(err) if err.to_string().contains("status 404") => Ok(0),
+        Err(err) => Err(err),
+    }
+}
+
+fn view(
+    client: &AptosClient,
+    function: &str,
+    type_arguments: &[&str],
+    arguments: Vec<Value>,
+) -> Result<Value> {
+    client.post_json(
+        "/view",
+        &json!({
+            "function": function,
+            "type_arguments": type_arguments,
+            "arguments": arguments,
+        }),
+    )
+}
+
+fn first_u64(value: &Value) -> Option<u64> {
+    value.as_array()?.first().and_then(parse_u64)
+}
+
+/// Decodes `[Option<Object<Metadata>>]`, i.e. `[{"vec": [{"inner": "0x..."}]}]`.
+fn parse_paired_metadata(value: &Value) -> Option<String> {
+    value
+        .pointer("/0/vec/0/inner")
+        .and_then(Value::as_str)
+        .map(str::to_owned)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::commands::schema::validate;
+
+    #[test]
+    fn decodes_paired_metadata_option() {
+        assert_eq!(
+            parse_paired_metadata(&json!([{"vec": [{"inner": "0xa"}]}])).as_deref(),
+            Some("0xa")
+        );
+        assert_eq!(parse_paired_metadata(&json!([{"vec": []}])), None);
+    }
+
+    #[test]
+    fn reconciles_split_balances() {
+        let split = reconcile("0x1", 40, 60, 100);
+        assert!(split.consistent);
+        assert_eq!(split.combined, "100");
+
+        let split = reconcile("0x1", u64::MAX, 1, u64::MAX);
+        assert!(!split.consistent);
+        assert_eq!(split.combined, "18446744073709551616");
+
+        let report = MigrationReport {
+            coin_type: "0x1::aptos_coin::AptosCoin".to_owned(),
+            paired: true,
+            metadata_address: Some("0xa".to_owned()),
+            account: Some(split),
+        };
+        validate(
+            &migration_output_schema(),
+            &serde_json::to_value(&report).unwrap(),
+        )
+        .unwrap();
+    }
+}
@@ -1,4 +1,9 @@
+use anyhow::{anyhow, Result};
+use aptly_aptos::{AptosClient, UtcDateTime};
 use serde_json::Value;
+use std::env;
+use std::path::PathBuf;
+use std::time::Duration;
 
 pub(crate) fn parse_u64(value: &Value) -> Option<u64> {
     match value {
@@ -44,3 +49,418 @@ pub(crate) fn with_optional_ledger_version(path: &str, ledger_version: Option<u6
         None => path.to_owned(),
     }
 }
+
+/// Reads `path` at `--ledger-version`, after checking the node can serve
+/// that version.
+pub(crate) fn get_json_at_version(
+    client: &AptosClient,
+    path: &str,
+    ledger_version: Option<u64>,
+) -> Result<Value> {
+    check_ledger_version(client, ledger_version)?;
+    client
+        .get_json(&with_optional_ledger_version(path, ledger_version))
+        .map_err(|err| explain_pruned(err, ledger_version))
+}
+
+/// Rejects a `--ledger-version` ahead of the node's ledger or older than
+/// the oldest version it keeps.
+pub(crate) fn check_ledger_version(
+    client: &AptosClient,
+    ledger_version: Option<u64>,
+) -> Result<()> {
+    let Some(version) = ledger_version else {
+        return Ok(());
+    };
+    let ledger = client.get_json("/")?;
+    match ledger_version_error(&ledger, version) {
+        Some(err) => Err(err),
+        None => Ok(()),
+    }
+}
+
+fn ledger_version_error(ledger: &Value, version: u64) -> Option<anyhow::Error> {
+    let head = ledger.get("ledger_version").and_then(parse_u64)?;
+    let oldest = ledger
+        .get("oldest_ledger_version")
+        .and_then(parse_u64)
+        .unwrap_or(0);
+    if version > head {
+        return Some(anyhow!(
+            "--ledger-version {version} is ahead of the ledger (current version {head})"
+        ));
+    }
+    (version < oldest).then(|| {
+        anyhow!(
+            "--ledger-version {version} is pruned on this node; the oldest available version is {oldest} (an archive node keeps full history)"
+        )
+    })
+}
+
+/// Rewords the node's 410 for state pruned at `ledger_version`, which the
+/// oldest ledger version alone does not rule out. The 410 may sit under
+/// added context.
+pub(crate) fn explain_pruned(err: anyhow::Error, ledger_version: Option<u64>) -> anyhow::Error {
+    match ledger_version {
+        Some(version) if format!("{err:#}").contains("status 410") => anyhow!(
+            "state at ledger version {version} has been pruned on this node; query an archive node with --rpc-url"
+        ),
+        _ => err,
+    }
+}
+
+/// Path of a user config file under `$XDG_CONFIG_HOME/aptly` (default
+/// `~/.config/aptly`).
+pub(crate) fn user_config_path(file_name: &str) -> Result<PathBuf> {
+    let config_dir = match env::var_os("XDG_CONFIG_HOME") {
+        Some(dir) if !dir.is_empty() => PathBuf::from(dir),
+        _ => env::var_os("HOME")
+            .map(|home| PathBuf::from(home).join(".config"))
+            .ok_or_else(|| anyhow!("cannot locate {file_name}: HOME is not set"))?,
+    };
+    Ok(config_dir.join("aptly").join(file_name))
+}
+
+/// Path of a cache entry under `$XDG_CACHE_HOME/aptly` (default
+/// `~/.cache/aptly`).
+pub(crate) fn user_cache_path(file_name: &str) -> Result<PathBuf> {
+    let cache_dir = match env::var_os("XDG_CACHE_HOME") {
+        Some(dir) if !dir.is_empty() => PathBuf::from(dir),
+        _ => env::var_os("HOME")
+            .map(|home| PathBuf::from(home).join(".cache"))
+            .ok_or_else(|| anyhow!("cannot locate {file_name}: HOME is not set"))?,
+    };
+    Ok(cache_dir.join("aptly").join(file_name))
+}
+
+/// Normalizes an account address to the AIP-40 long form (`0x` + 64 lowercase
+/// hex digits). Values that are not hex addresses are returned unchanged.
+pub(crate) fn normalize_address(value: &str) -> String {
+    let hex = value
+        .strip_prefix("0x")
+        .or_else(|| value.strip_prefix("0X"))
+        .unwrap_or(value);
+    if hex.is_empty() || hex.len() > 64 || !hex.chars().all(|ch| ch.is_ascii_hexdigit()) {
+        return value.to_owned();
+    }
+    format!("0x{:0>64}", hex.to_ascii_lowercase())
+}
+
+/// Normalizes the leading address of a `0x...::module::name` identifier so
+/// short and long forms compare equal. Nested type arguments are unchanged.
+pub(crate) fn normalize_qualified_name(value: &str) -> String {
+    match value.split_once("::") {
+        Some((address, rest)) => format!("{}::{rest}", normalize_address(address)),
+        None => value.to_owned(),
+    }
+}
+
+/// Matches `value` against `pattern` in full, where `*` matches any run of
+/// characters.
+pub(crate) fn glob_matches(pattern: &str, value: &str) -> bool {
+    let parts: Vec<&str> = pattern.split('*').collect();
+    let (first, last) = (parts[0], parts[parts.len() - 1]);
+    if parts.len() == 1 {
+        return value == pattern;
+    }
+    if !value.starts_with(first) {
+        return false;
+    }
+    let mut rest = &value[first.len()..];
+    for part in &parts[1..parts.len() - 1] {
+        match rest.find(part) {
+            Some(index) => rest = &rest[index + part.len()..],
+            None => return false,
+        }
+    }
+    rest.len() >= last.len() && rest.ends_with(last)
+}
+
+/// Implemented by output structs whose address fields can be rewritten to the
+/// canonical long form by the global `--canonical-addresses` flag.
+pub(crate) trait CanonicalAddresses {
+    fn canonicalize_addresses(&mut self);
+}
+
+impl<T: CanonicalAddresses> CanonicalAddresses for Vec<T> {
+    fn canonicalize_addresses(&mut self) {
+        for item in self {
+            item.canonicalize_addresses();
+        }
+    }
+}
+
+/// Parses `500ms`, `30s`, `2m`, or `1h`; a bare number is seconds. Used as a
+/// clap `value_parser`.
+pub(crate) fn parse_duration(value: &str) -> Result<Duration, String> {
+    let value = value.trim();
+    let split = value
+        .find(|c: char| !c.is_ascii_digit() && c != '.')
+        .unwrap_or(value.len());
+    let (amount, unit) = value.split_at(split);
+    let amount: f64 = amount
+        .parse()
+        .map_err(|_| format!("invalid duration `{value}`"))?;
+    let secs = match unit.trim() {
+        "ms" => amount / 1000.0,
+        "" | "s" => amount,
+        "m" => amount * 60.0,
+        "h" => amount * 3600.0,
+        other => {
+            return Err(format!(
+                "unknown duration unit `{other}` (use ms, s, m, or h)"
+            ))
+        }
+    };
+    Ok(Duration::from_secs_f64(secs))
+}
+
+/// Parses `UTC`, `Z`, or a fixed `+HH:MM`/`-HH:MM` offset into seconds east
+/// of UTC. Used as a clap `value_parser`.
+pub(crate) fn parse_utc_offset(value: &str) -> Result<i64, String> {
+    let value = value.trim();
+    if value.eq_ignore_ascii_case("utc") || value.eq_ignore_ascii_case("z") {
+        return Ok(0);
+    }
+    let invalid = || format!("invalid UTC offset `{value}` (use UTC or +HH:MM)");
+    let (sign, rest) = match value.as_bytes().first() {
+        Some(b'+') => (1, &value[1..]),
+        Some(b'-') => (-1, &value[1..]),
+        _ => return Err(invalid()),
+    };
+    let (hours, minutes) = rest.split_once(':').unwrap_or((rest, "0"));
+    let hours: i64 = hours.parse().map_err(|_| invalid())?;
+    let minutes: i64 = minutes.parse().map_err(|_| invalid())?;
+    if hours > 14 || minutes > 59 {
+        return Err(invalid());
+    }
+    Ok(sign * (hours * 3600 + minutes * 60))
+}
+
+/// Parses an RFC 3339 timestamp, or a `YYYY-MM-DD` date taken as midnight at
+/// `offset` seconds east of UTC, into microseconds since the Unix epoch.
+/// With `end_of_day`, a bare date means the following midnight, so an
+/// exclusive upper bound still covers the whole day.
+pub(crate) fn parse_date_bound(value: &str, offset: i64, end_of_day: bool) -> Result<u64> {
+    let invalid = || anyhow!("invalid date `{value}` (use YYYY-MM-DD or RFC 3339)");
+    let value = value.trim();
+    let (date, time) = match value.find(['T', 't', ' ']) {
+        Some(index) => (&value[..index], Some(&value[index + 1..])),
+        None => (value, None),
+    };
+    let mut parts = date.splitn(3, '-').map(str::parse::<u64>);
+    let (Some(Ok(year)), Some(Ok(month)), Some(Ok(day))) =
+        (parts.next(), parts.next(), parts.next())
+    else {
+        return Err(invalid());
+    };
+    if year < 1970 || !(1..=12).contains(&month) || !(1..=31).contains(&day) {
+        return Err(invalid());
+    }
+
+    let (clock, micros, offset, next_day) = match time {
+        None => ((0, 0, 0), 0, offset, end_of_day),
+        Some(time) => {
+            let zone_at = time.find(['Z', 'z', '+', '-']).ok_or_else(invalid)?;
+            let (clock, zone) = time.split_at(zone_at);
+            let offset = parse_utc_offset(zone).map_err(|_| invalid())?;
+            let (clock, fraction) = clock.split_once('.').unwrap_or((clock, ""));
+            let mut fields = clock.splitn(3, ':').map(str::parse::<u64>);
+            let (Some(Ok(hour)), Some(Ok(minute)), Some(Ok(second))) =
+                (fields.next(), fields.next(), fields.next())
+            else {
+                return Err(invalid());
+            };
+            if hour > 23
+                || minute > 59
+                || second > 60
+                || !fraction.bytes().all(|b| b.is_ascii_digit())
+            {
+                return Err(invalid());
+            }
+            let micros = format!("{fraction:0<6}")[..6].parse::<u64>().unwrap_or(0);
+            ((hour, minute, second), micros, offset, false)
+        }
+    };
+    let local = UtcDateTime {
+        year,
+        month,
+        day,
+        hour: clock.0,
+        minute: clock.1,
+        second: clock.2,
+    }
+    .to_unix_seconds()
+        + u64::from(next_day) * 86_400;
+    let secs = i64::try_from(local).map_err(|_| invalid())? - offset;
+    let secs = u64::try_from(secs).map_err(|_| invalid())?;
+    Ok(secs * 1_000_000 + micros)
+}
+
+/// RFC 3339 UTC time, to the second, of a timestamp in microseconds since
+/// the Unix epoch.
+pub(crate) fn format_timestamp_micros(micros: u64) -> String {
+    let at = UtcDateTime::from_unix_seconds(micros / 1_000_000);
+    format!(
+        "{:04}-{:02}-{:02}T{:02}:{:02}:{:02}Z",
+        at.year, at.month, at.day, at.hour, at.minute, at.second
+    )
+}
+
+/// [`parse_date_bound`], or a time relative to `now_micros` such as `-7d`,
+/// `-12h` or `-90m`: a minus sign and a [`parse_duration`] value, which may
+/// also count whole days with `d`.
+pub(crate) fn parse_time_bound(
+    value: &str,
+    offset: i64,
+    end_of_day: bool,
+    now_micros: u64,
+) -> Result<u64> {
+    let Some(ago) = value.trim().strip_prefix('-') else {
+        return parse_date_bound(value, offset, end_of_day);
+    };
+    let invalid = || anyhow!("invalid relative time `{value}` (e.g. -7d, -12h, -90m)");
+    let ago = match ago.strip_suffix('d') {
+        Some(days) => Duration::from_secs(days.parse::<u64>().map_err(|_| invalid())? * 86_400),
+        None => parse_duration(ago).map_err(|_| invalid())?,
+    };
+    let ago = u64::try_from(ago.as_micros()).map_err(|_| invalid())?;
+    now_micros.checked_sub(ago).ok_or_else(invalid)
+}
+
+/// A client answering from `fixtures/replay`, recorded with `--record`
+/// against a node; requests outside the recording fail.
+#[cfg(test)]
+pub(crate) fn replay_client() -> aptly_aptos::AptosClient {
+    let dir = PathBuf::from(env!("CARGO_MANIFEST_DIR")).join("fixtures/replay");
+    let options = aptly_aptos::ClientOptions {
+        fixtures: Some(std::sync::Arc::new(
+            aptly_aptos::Fixtures::replay(&dir).unwrap(),
+        )),
+        ..aptly_aptos::ClientOptions::default()
+    };
+    aptly_aptos::AptosClient::with_options("http://127.0.0.1:9/v1", options).unwrap()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn normalizes_short_and_padded_addresses() {
+        let long = format!("0x{:0>64}", "2");
+        assert_eq!(normalize_address("0x2"), long);
+        assert_eq!(normalize_address("0x02"), long);
+        assert_eq!(normalize_address(&long), long);
+        assert_eq!(normalize_address("0xABC"), format!("0x{:0>64}", "abc"));
+    }
+
+    #[test]
+    fn leaves_non_addresses_untouched() {
+        assert_eq!(normalize_address(""), "");
+        assert_eq!(normalize_address("APT"), "APT");
+        assert_eq!(normalize_address("1.5"), "1.5");
+        assert_eq!(
+            normalize_address("0x1::aptos_coin::AptosCoin"),
+            "0x1::aptos_coin::AptosCoin"
+        );
+        let too_long = format!("0x{}", "1".repeat(65));
+        assert_eq!(normalize_address(&too_long), too_long);
+    }
+
+    #[test]
+    fn parses_durations_with_units() {
+        assert_eq!(parse_duration("120s"), Ok(Duration::from_secs(120)));
+        assert_eq!(parse_duration("90"), Ok(Duration::from_secs(90)));
+        assert_eq!(parse_duration("2m"), Ok(Duration::from_secs(120)));
+        assert_eq!(parse_duration("250ms"), Ok(Duration::from_millis(250)));
+        assert_eq!(parse_duration("1.5s"), Ok(Duration::from_millis(1500)));
+        assert!(parse_duration("soon").is_err());
+        assert!(parse_duration("10d").is_err());
+    }
+
+    #[test]
+    fn parses_date_bounds_and_offsets() {
+        let march = 1_709_251_200_000_000;
+        assert_eq!(parse_date_bound("2024-03-01", 0, false).unwrap(), march);
+        assert_eq!(
+            parse_date_bound("2024-02-29", 0, true).unwrap(),
+            march,
+            "an --until date covers the whole day"
+        );
+        let plus_two = parse_utc_offset("+02:00").unwrap();
+        assert_eq!(
+            parse_date_bound("2024-03-01", plus_two, false).unwrap(),
+            march - 7_200_000_000
+        );
+        assert_eq!(
+            parse_date_bound("2024-03-01T00:00:00.25Z", plus_two, true).unwrap(),
+            march + 250_000
+        );
+        assert_eq!(
+            parse_date_bound("2024-02-29T19:00:00-05:00", 0, false).unwrap(),
+            march
+        );
+        assert_eq!(parse_utc_offset("utc"), Ok(0));
+        assert_eq!(parse_utc_offset("-09:30"), Ok(-34_200));
+        for bad in ["2024-13-01", "2024-03", "March", "2024-03-01T10:00"] {
+            assert!(parse_date_bound(bad, 0, false).is_err(), "{bad}");
+        }
+        assert!(parse_utc_offset("CET").is_err());
+    }
+
+    #[test]
+    fn parses_relative_time_bounds() {
+        let now = 1_709_251_200_000_000;
+        let day = 86_400_000_000;
+        assert_eq!(
+            parse_time_bound("-7d", 0, false, now).unwrap(),
+            now - 7 * day
+        );
+        assert_eq!(
+            parse_time_bound(" -12h", 0, true, now).unwrap(),
+            now - day / 2
+        );
+        assert_eq!(
+            parse_time_bound("-90m", 0, false, now).unwrap(),
+            now - 5_400_000_000
+        );
+        assert_eq!(
+            parse_time_bound("2024-02-29", 0, true, 0).unwrap(),
+            now,
+            "absolute dates ignore now"
+        );
+        for bad in ["-d", "-7x", "-1.5d", "-"] {
+            assert!(parse_time_bound(bad, 0, false, now).is_err(), "{bad}");
+        }
+        assert!(parse_time_bound("-100000d", 0, false, day).is_err());
+    }
+
+    #[test]
+    fn rejects_ledger_versions_the_node_cannot_serve() {
+        let ledger = serde_json::json!({"ledger_version": "2000", "oldest_ledger_version": "500"});
+        assert!(ledger_version_error(&ledger, 1500).is_none());
+        assert_eq!(
+            ledger_version_error(&ledger, 2001).unwrap().to_string(),
+            "--ledger-version 2001 is ahead of the ledger (current version 2000)"
+        );
+        assert!(ledger_version_error(&ledger, 499)
+            .unwrap()
+            .to_string()
+            .starts_with("--ledger-version 499 is pruned on this node"));
+
+        let gone = explain_pruned(anyhow!("API error (status 410): state pruned"), Some(499));
+        assert!(gone
+            .to_string()
+            .starts_with("state at ledger version 499 has been pruned"));
+        let wrapped = explain_pruned(
+            anyhow!("API error (status 410): state pruned").context("failed to read primary store"),
+            Some(499),
+        );
+        assert!(wrapped
+            .to_string()
+            .starts_with("state at ledger version 499 has been pruned"));
+        let other = explain_pruned(anyhow!("API error (status 404): not found"), Some(499));
+        assert_eq!(other.to_string(), "API error (status 404): not found");
+    }
+}
@@ -0,0 +1,185 @@
+use anyhow::{anyhow, Result};
+use aptly_aptos::AptosClient;
+use clap::{Args, Subcommand};
+use serde_json::{json, Value};
+
+use crate::commands::bcs::{decode_hex, struct_fields, StructLayouts};
+use crate::commands::bindings::MoveType;
+use crate::commands::schema::{object_schema, string_schema};
+use crate::commands::type_tag::{check_type_tag_arg, TypeTag};
+
+#[derive(Args)]
+#[command(
+    after_help = "Examples:\n  aptly decode resource 0x0100000000000000 --type 0x1::coin::Coin<0x1::aptos_coin::AptosCoin>\n  aptly decode resource <hex> --type '0x1::coin::CoinStore<0x1::aptos_coin::AptosCoin>'"
+)]
+pub(crate) struct DecodeCommand {
+    #[command(subcommand)]
+    pub(crate) command: DecodeSubcommand,
+}
+
+#[derive(Subcommand)]
+pub(crate) enum DecodeSubcommand {
+    #[command(about = "Decode a BCS-encoded resource into the node's JSON shape")]
+    Resource(DecodeResourceArgs),
+}
+
+#[derive(Args)]
+pub(crate) struct DecodeResourceArgs {
+    /// BCS bytes of the resource as hex (`0x...`).
+    #[arg(value_name = "HEX")]
+    pub(crate) hex: String,
+    /// Struct tag of the resource; field layouts are read from the ABIs of
+    /// the defining modules.
+    #[arg(long = "type", value_name = "STRUCT_TAG")]
+    pub(crate) resource_type: String,
+}
+
+pub(crate) fn resource_output_schema() -> Value {
+    object_schema(
+        "Decoded resource, shaped like `account resource` output",
+        &[
+            ("type", string_schema("Struct tag as given in `--type`")),
+            (
+                "data",
+                json!({
+                    "description": "Field values: u8-u32 as numbers, u64 and wider as decimal strings, `vector<u8>` as hex, options as `{\"vec\": [...]}`",
+                    "type": "object",
+                }),
+            ),
+        ],
+    )
+}
+
+pub(crate) fn run_decode(client: &AptosClient, command: DecodeCommand) -> Result<()> {
+    match command.command {
+        DecodeSubcommand::Resource(args) => {
+            let mut layouts = StructLayouts::new(|name: &str| struct_fields(client, name));
+            let value = decode_resource(&mut layouts, &args.resource_type, &args.hex)?;
+            crate::print_serialized(&value)
+        }
+    }
+}
+
+fn decode_resource<F: FnMut(&str) -> Result<Vec<(String, String)>>>(
+    layouts: &mut StructLayouts<F>,
+    resource_type: &str,
+    hex: &str,
+) -> Result<Value> {
+    let resource_type = resource_type.trim();
+    if !matches!(
+        check_type_tag_arg("--type", resource_type)?,
+        TypeTag::Struct(_)
+    ) {
+        return Err(anyhow!(
+            "--type must be a struct tag, got `{resource_type}`"
+        ));
+    }
+    let bytes = decode_hex(hex).ok_or_else(|| anyhow!("resource bytes are not valid hex"))?;
+    let mut input = bytes.as_slice();
+    let data = layouts.read(&MoveType::parse(resource_type)?, &mut input, "data")?;
+    if !input.is_empty() {
+        return Err(anyhow!(
+            "{} trailing byte(s) after the {resource_type} value",
+            input.len()
+        ));
+    }
+    Ok(json!({ "type": resource_type, "data": data }))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::commands::schema::validate;
+
+    const COIN_STORE: &str = "0x1::coin::CoinStore<0x1::aptos_coin::AptosCoin>";
+
+    fn layouts() -> StructLayouts<impl FnMut(&str) -> Result<Vec<(String, String)>>> {
+        StructLayouts::new(|name: &str| {
+            let fields: &[(&str, &str)] = match name {
+                "0x1::coin::CoinStore" => &[
+                    ("coin", "0x1::coin::Coin<T0>"),
+                    ("frozen", "bool"),
+                    (
+                        "deposit_events",
+                        "0x1::event::EventHandle<0x1::coin::DepositEvent>",
+                    ),
+                    (
+                        "withdraw_events",
+                        "0x1::event::EventHandle<0x1::coin::WithdrawEvent>",
+                    ),
+                ],
+                "0x1::coin::Coin" => &[("value", "u64")],
+                "0x1::event::EventHandle" => &[("counter", "u64"), ("guid", "0x1::guid::GUID")],
+                "0x1::guid::GUID" => &[("id", "0x1::guid::ID")],
+                "0x1::guid::ID" => &[("creation_num", "u64"), ("addr", "address")],
+                other => return Err(anyhow!("unknown struct {other}")),
+            };
+            Ok(fields
+                .iter()
+                .map(|(name, ty)| (name.to_string(), ty.to_string()))
+                .collect())
+        })
+    }
+
+    fn event_handle(counter: u8, creation_num: u8, addr: &str) -> String {
+        format!(
+            "{counter:02x}{}{creation_num:02x}{}{addr}",
+            "00".repeat(7),
+            "00".repeat(7)
+        )
+    }
+
+    #[test]
+    fn decodes_coin_store_like_the_node() {
+        let owner = "cafe".repeat(16);
+        // value 150000000, not frozen, then two event handles.
+        let hex = format!(
+            "0x80d1f00800000000{}{}{}",
+            "00",
+            event_handle(4, 2, &owner),
+            event_handle(1, 3, &owner)
+        );
+        let resource = decode_resource(&mut layouts(), COIN_STORE, &hex).unwrap();
+        assert_eq!(
+            resource,
+            json!({
+                "type": COIN_STORE,
+                "data": {
+                    "coin": {"value": "150000000"},
+                    "frozen": false,
+                    "deposit_events": {
+                        "counter": "4",
+                        "guid": {"id": {"creation_num": "2", "addr": format!("0x{owner}")}},
+                    },
+                    "withdraw_events": {
+                        "counter": "1",
+                        "guid": {"id": {"creation_num": "3", "addr": format!("0x{owner}")}},
+                    },
+                },
+            })
+        );
+        validate(&resource_output_schema(), &resource).unwrap();
+    }
+
+    #[test]
+    fn rejects_bad_input() {
+        let err = decode_resource(&mut layouts(), "u64", "0x01").unwrap_err();
+        assert_eq!(err.to_string(), "--type must be a struct tag, got `u64`");
+        let err = decode_resource(&mut layouts(), "0x1::coin::Coin<", "0x01").unwrap_err();
+        assert!(err
+            .to_string()
+            .starts_with("invalid --type `0x1::coin::Coin<`"));
+        let err = decode_resource(
+            &mut layouts(),
+            "0x1::coin::Coin<u8>",
+            "0x0100000000000000ff",
+        )
+        .unwrap_err();
+        assert_eq!(
+            err.to_string(),
+            "1 trailing byte(s) after the 0x1::coin::Coin<u8> value"
+        );
+        let err = decode_resource(&mut layouts(), COIN_STORE, "0x0100000000000000").unwrap_err();
+        assert_eq!(err.to_string(), "data.frozen: BCS input ends early");
+    }
+}
@@ -86,13 +86,13 @@ pub(crate) struct DecompileAddressArgs {
     pub(crate) decompiler_args: Vec<String>,
 }
 
-pub(crate) fn run_decompile(rpc_url: &str, command: DecompileCommand) -> Result<()> {
+pub(crate) fn run_decompile(client: &AptosClient, command: DecompileCommand) -> Result<()> {
     match command.command {
         DecompileSubcommand::Raw(args) => {
             run_move_decompiler(args.decompiler_bin.as_deref(), &args.args)
         }
         DecompileSubcommand::Module(args) => run_decompile_for_modules(
-            rpc_url,
+            client,
             &args.address,
             vec![args.module],
             args.decompiler_bin.as_deref(),
@@ -102,15 +102,14 @@ pub(crate) fn run_decompile(rpc_url: &str, command: DecompileCommand) -> Result<
             &args.decompiler_args,
         ),
         DecompileSubcommand::Address(args) => {
-            let client = AptosClient::new(rpc_url)?;
             let modules = if args.modules.is_empty() {
-                fetch_account_module_names(&client, &args.address)?
+                fetch_account_module_names(client, &args.address)?
             } else {
                 args.modules
             };
 
             run_decompile_for_modules(
-                rpc_url,
+                client,
                 &args.address,
                 modules,
                 args.decompiler_bin.as_deref(),
@@ -124,7 +123,7 @@ pub(crate) fn run_decompile(rpc_url: &str, command: DecompileCommand) -> Result<
 }
 
 fn run_decompile_for_modules(
-    rpc_url: &str,
+    client: &AptosClient,
     address: &str,
     modules: Vec<String>,
     decompiler_bin: Option<&str>,
@@ -137,7 +136,6 @@ fn run_decompile_for_modules(
         return Err(anyhow!("no modules provided for decompilation"));
     }
 
-    let client = AptosClient::new(rpc_url)?;
     let output_dir = out_dir.unwrap_or_else(|| default_decompile_output_dir(address));
     fs::create_dir_all(&output_dir).with_context(|| {
         format!(
@@ -157,7 +155,7 @@ fn run_decompile_for_modules(
             continue;
         }
 
-        let bytecode_hex = fetch_module_bytecode(&client, address, &module_name)?;
+        let bytecode_hex = fetch_module_bytecode(client, address, &module_name)?;
         let file_stem = sanitize_file_component(&module_name);
         let mv_path = bytecode_dir.join(format!("{file_stem}.mv"));
         write_mv_file(&mv_path, &bytecode_hex)?;
@@ -0,0 +1,300 @@
+use anyhow::{anyhow, Context, Result};
+use serde::Deserialize;
+use std::ffi::OsStr;
+use std::fs;
+use std::io::ErrorKind;
+use std::path::{Path, PathBuf};
+use std::process::{Command, Stdio};
+use tempfile::tempdir;
+
+use crate::commands::common::{normalize_address, user_cache_path, user_config_path};
+
+/// Used when neither `--disassembler` nor the config file names a command.
+pub(crate) const DEFAULT_DISASSEMBLER: &str =
+    "aptos move disassemble --bytecode-path {bytecode} --output-dir {out_dir}";
+
+const CONFIG_FILE: &str = "disassembler.json";
+
+#[derive(Deserialize)]
+struct DisassemblerConfig {
+    command: String,
+}
+
+/// An external disassembler invocation: whitespace-separated words where
+/// `{bytecode}` is replaced by the `.mv` file and `{out_dir}` by an empty
+/// directory. Without `{bytecode}`, the file is appended as the last
+/// argument. Output is the single file the tool leaves in `{out_dir}`, or
+/// its stdout.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub(crate) struct Disassembler {
+    command: String,
+}
+
+impl Disassembler {
+    /// The `--disassembler` flag, else `command` from
+    /// `$XDG_CONFIG_HOME/aptly/disassembler.json`, else the Aptos CLI.
+    pub(crate) fn resolve(flag: Option<&str>) -> Result<Self> {
+        if let Some(command) = flag.filter(|command| !command.trim().is_empty()) {
+            return Ok(Self::new(command));
+        }
+        let path = user_config_path(CONFIG_FILE)?;
+        let body = match fs::read_to_string(&path) {
+            Ok(body) => body,
+            Err(err) if err.kind() == ErrorKind::NotFound => {
+                return Ok(Self::new(DEFAULT_DISASSEMBLER))
+            }
+            Err(err) => {
+                return Err(err).with_context(|| format!("failed to read {}", path.display()))
+            }
+        };
+        let config: DisassemblerConfig = serde_json::from_str(&body)
+            .with_context(|| format!("failed to parse {}", path.display()))?;
+        Ok(Self::new(&config.command))
+    }
+
+    fn new(command: &str) -> Self {
+        Self {
+            command: command.trim().to_owned(),
+        }
+    }
+
+    /// Disassembles `bytecode`, reusing the cached text for the same module,
+    /// ledger version and command.
+    pub(crate) fn disassemble_cached(
+        &self,
+        address: &str,
+        module: &str,
+        ledger_version: u64,
+        bytecode: &[u8],
+    ) -> Result<String> {
+        let cache = user_cache_path(&self.cache_file(address, module, ledger_version))?;
+        self.disassemble_with_cache(&cache, bytecode, None)
+    }
+
+    fn cache_file(&self, address: &str, module: &str, ledger_version: u64) -> String {
+        // The command is part of the key so switching tools never serves
+        // another tool's output.
+        let command_hash = self
+            .command
+            .bytes()
+            .fold(0xcbf2_9ce4_8422_2325_u64, |hash, byte| {
+                (hash ^ u64::from(byte)).wrapping_mul(0x0100_0000_01b3)
+            });
+        format!(
+            "disassembly/{}/{module}@{ledger_version}-{command_hash:016x}.asm",
+            normalize_address(address)
+        )
+    }
+
+    fn disassemble_with_cache(
+        &self,
+        cache: &Path,
+        bytecode: &[u8],
+        search_path: Option<&OsStr>,
+    ) -> Result<String> {
+        if let Ok(cached) = fs::read_to_string(cache) {
+            return Ok(cached);
+        }
+        let text = self.run(bytecode, search_path)?;
+        // A cache that cannot be written only costs a rerun next time.
+        let written = cache
+            .parent()
+            .map_or(Ok(()), fs::create_dir_all)
+            .and_then(|()| fs::write(cache, &text));
+        if let Err(err) = written {
+            eprintln!("failed to cache disassembly at {}: {err}", cache.display());
+        }
+        Ok(text)
+    }
+
+    /// Runs the tool once. `search_path` replaces `PATH` for the lookup and
+    /// the child, for tests.
+    fn run(&self, bytecode: &[u8], search_path: Option<&OsStr>) -> Result<String> {
+        let temp_dir = tempdir().context("failed to create temporary bytecode directory")?;
+        let bytecode_path = temp_dir.path().join("module.mv");
+        fs::write(&bytecode_path, bytecode)
+            .with_context(|| format!("failed to write {}", bytecode_path.display()))?;
+        let out_dir = temp_dir.path().join("out");
+        fs::create_dir_all(&out_dir)?;
+
+        let mut words = self.command.split_whitespace();
+        let program = words
+            .next()
+            .ok_or_else(|| anyhow!("disassembler command is empty"))?;
+        let mut args: Vec<String> = words
+            .map(|word| {
+                word.replace("{bytecode}", &bytecode_path.display().to_string())
+                    .replace("{out_dir}", &out_dir.display().to_string())
+            })
+            .collect();
+        if !self.command.contains("{bytecode}") {
+            args.push(bytecode_path.display().to_string());
+        }
+
+        let mut command = Command::new(program);
+        if let Some(path) = search_path {
+            command.env("PATH", path);
+        }
+        let output = match command.args(&args).stdin(Stdio::null()).output() {
+            Ok(output) => output,
+            Err(err) if err.kind() == ErrorKind::NotFound => {
+                return Err(anyhow!(
+                    "disassembler `{program}` is not installed.\n{}",
+                    disassembler_install_hint()
+                ))
+            }
+            Err(err) => return Err(err).with_context(|| format!("failed to execute `{program}`")),
+        };
+        if !output.status.success() {
+            let stderr = String::from_utf8_lossy(&output.stderr);
+            let stdout = String::from_utf8_lossy(&output.stdout);
+            let details = if !stderr.trim().is_empty() {
+                stderr.trim()
+            } else {
+                stdout.trim()
+            };
+            if details.is_empty() {
+                return Err(anyhow!("`{program}` exited with status {}", output.status));
+            }
+            return Err(anyhow!(
+                "`{program}` exited with status {}: {details}",
+                output.status
+            ));
+        }
+
+        let text = match single_file(&out_dir)? {
+            Some(file) => fs::read_to_string(&file)
+                .with_context(|| format!("failed to read {}", file.display()))?,
+            None => String::from_utf8(output.stdout)
+                .with_context(|| format!("`{program}` returned non-UTF-8 output"))?,
+        };
+        if text.trim().is_empty() {
+            return Err(anyhow!("`{program}` produced no disassembly"));
+        }
+        Ok(text)
+    }
+}
+
+/// The one file a tool wrote under `dir`, searched recursively.
+fn single_file(dir: &Path) -> Result<Option<PathBuf>> {
+    let mut files = Vec::new();
+    let mut pending = vec![dir.to_owned()];
+    while let Some(dir) = pending.pop() {
+        for entry in fs::read_dir(&dir)? {
+            let path = entry?.path();
+            if path.is_dir() {
+                pending.push(path);
+            } else {
+                files.push(path);
+            }
+        }
+    }
+    match files.len() {
+        0 => Ok(None),
+        1 => Ok(files.pop()),
+        n => Err(anyhow!(
+            "disassembler wrote {n} files to {{out_dir}}; expected one"
+        )),
+    }
+}
+
+fn disassembler_install_hint() -> String {
+    [
+        "Install the Aptos CLI, which provides `aptos move disassemble`:",
+        "  https://aptos.dev/en/build/cli",
+        "or point aptly at another disassembler:",
+        "  aptly account module <address> <module> --disassemble --disassembler 'move-disassembler --bytecode {bytecode}'",
+        "or set {\"command\": \"...\"} in $XDG_CONFIG_HOME/aptly/disassembler.json (default ~/.config/aptly/disassembler.json)",
+        "or save the bytecode with --save-bytecode <file> and run your own tooling",
+    ]
+    .join("\n")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::ffi::OsString;
+    use std::os::unix::fs::PermissionsExt;
+
+    /// Installs `body` as an executable `name` in a fresh directory.
+    fn fake_bin(name: &str, body: &str) -> tempfile::TempDir {
+        let dir = tempdir().unwrap();
+        let path = dir.path().join(name);
+        fs::write(&path, format!("#!/bin/sh\n{body}\n")).unwrap();
+        fs::set_permissions(&path, fs::Permissions::from_mode(0o755)).unwrap();
+        dir
+    }
+
+    /// `PATH` with `dir` searched first.
+    fn path_with(dir: &Path) -> OsString {
+        let system = std::env::var_os("PATH").unwrap_or_default();
+        std::env::join_paths(std::iter::once(dir.to_owned()).chain(std::env::split_paths(&system)))
+            .unwrap()
+    }
+
+    #[test]
+    fn reads_output_dir_or_stdout() {
+        // Mimics `aptos move disassemble`: writes `<name>.asm` into the
+        // output directory.
+        let bin = fake_bin(
+            "aptos",
+            r#"[ "$1 $2" = "move disassemble" ] || exit 2
+bytes=$(od -An -tx1 "$4" | tr -d ' \n')
+echo "// Move bytecode v6 $bytes" > "$6/module.asm""#,
+        );
+        let text = Disassembler::new(DEFAULT_DISASSEMBLER)
+            .run(&[0xa1, 0x1c], Some(&path_with(bin.path())))
+            .unwrap();
+        assert_eq!(text, "// Move bytecode v6 a11c\n");
+
+        let bin = fake_bin("dis", r#"echo "module $1""#);
+        let text = Disassembler::new("dis")
+            .run(&[1], Some(&path_with(bin.path())))
+            .unwrap();
+        assert!(text.starts_with("module /") && text.trim_end().ends_with("module.mv"));
+    }
+
+    #[test]
+    fn explains_missing_and_failing_tools() {
+        let empty = tempdir().unwrap();
+        let err = Disassembler::new(DEFAULT_DISASSEMBLER)
+            .run(&[1], Some(empty.path().as_os_str()))
+            .unwrap_err()
+            .to_string();
+        assert!(err.starts_with("disassembler `aptos` is not installed.\n"));
+        assert!(err.contains("--save-bytecode"));
+
+        let bin = fake_bin("dis", "echo 'bad magic' >&2; exit 3");
+        let err = Disassembler::new("dis {bytecode}")
+            .run(&[1], Some(&path_with(bin.path())))
+            .unwrap_err();
+        assert_eq!(
+            err.to_string(),
+            "`dis` exited with status exit status: 3: bad magic"
+        );
+    }
+
+    #[test]
+    fn caches_per_module_version_and_command() {
+        let bin = fake_bin(
+            "dis",
+            r#"echo run >> "$(dirname "$0")/runs"; echo disassembly"#,
+        );
+        let runs = bin.path().join("runs");
+        let cache_dir = tempdir().unwrap();
+        let dis = Disassembler::new("dis");
+        let cache = cache_dir.path().join(dis.cache_file("0x1", "coin", 7));
+        for _ in 0..2 {
+            let text = dis
+                .disassemble_with_cache(&cache, &[1], Some(&path_with(bin.path())))
+                .unwrap();
+            assert_eq!(text, "disassembly\n");
+        }
+        assert_eq!(fs::read_to_string(&runs).unwrap(), "run\n");
+
+        let key = dis.cache_file("0x1", "coin", 7);
+        assert!(key.starts_with(&format!("disassembly/{}/coin@7-", normalize_address("0x1"))));
+        assert_ne!(key, dis.cache_file("0x1", "coin", 8));
+        assert_ne!(key, Disassembler::new("other").cache_file("0x1", "coin", 7));
+    }
+}
@@ -1,30 +1,311 @@
-use anyhow::Result;
+use anyhow::{anyhow, Context, Result};
 use aptly_aptos::AptosClient;
-use clap::Args;
+use clap::{Args, Subcommand};
+use serde::{Deserialize, Serialize};
+use serde_json::Value;
+use std::fs::{self, File, OpenOptions};
+use std::io::{BufWriter, Read, Seek, SeekFrom, Write};
+use std::path::{Path, PathBuf};
+
+use crate::commands::account::list_resources;
+use crate::commands::common::{get_nested_string, normalize_address, parse_u64};
+use crate::commands::follow::{run_follow, FollowArgs, FollowSource};
+use crate::commands::schema::{
+    array_schema, integer_schema, nullable, object_schema, string_schema, OutputSchema,
+};
+
+/// Largest page the node serves for event queries.
+const EVENTS_PAGE_LIMIT: u64 = 100;
+/// Bytes read at a time from the end of an archive when looking for its
+/// last complete record.
+const ARCHIVE_TAIL_WINDOW: u64 = 64 * 1024;
 
 #[derive(Args)]
 #[command(
-    after_help = "Examples:\n  aptly events 0x1 0 --limit 10\n  aptly events 0x1 0 --start 100 --limit 25"
+    after_help = "Examples:\n  aptly events 0x1 0 --limit 10\n  aptly events 0x1 0 --start 100 --limit 25\n  aptly events 0x1 0 --follow --metrics-listen :9464\n  aptly events sync 0x1 0 --archive events.jsonl\n  aptly events sync 0xcafe '0x1::coin::CoinStore<0x1::aptos_coin::AptosCoin>/deposit_events' --archive deposits.jsonl"
 )]
 pub(crate) struct EventsCommand {
+    #[command(subcommand)]
+    pub(crate) command: Option<EventsSubcommand>,
     /// Account address that owns the event handle.
     #[arg(value_name = "ADDRESS")]
-    pub(crate) address: String,
+    pub(crate) address: Option<String>,
     /// Event handle creation number.
     #[arg(value_name = "CREATION_NUMBER")]
-    pub(crate) creation_number: String,
+    pub(crate) creation_number: Option<String>,
     /// Maximum number of events to return.
     #[arg(long, default_value_t = 25)]
     pub(crate) limit: u64,
     /// Start cursor (ledger version offset).
     #[arg(long, default_value_t = 0)]
     pub(crate) start: u64,
+    #[command(flatten)]
+    pub(crate) follow: FollowArgs,
+}
+
+#[derive(Subcommand)]
+pub(crate) enum EventsSubcommand {
+    #[command(
+        about = "Append new events of a handle to a JSONL archive",
+        long_about = "Append events newer than the archive's last record to a JSONL archive, one event per line, paging as needed. A truncated or corrupt last line is cut off before resuming. The last synced sequence number and ledger version are written to `<archive>.state.json`."
+    )]
+    Sync(EventsSyncArgs),
+}
+
+#[derive(Args)]
+pub(crate) struct EventsSyncArgs {
+    /// Account address that owns the event handle.
+    #[arg(value_name = "ADDRESS")]
+    pub(crate) address: String,
+    /// Event handle creation number, or `<struct tag>/<field name>` of an
+    /// event handle field.
+    #[arg(value_name = "CREATION_NUMBER_OR_HANDLE")]
+    pub(crate) handle: String,
+    /// JSONL archive to create or extend.
+    #[arg(long, value_name = "FILE")]
+    pub(crate) archive: PathBuf,
+}
+
+#[derive(Args)]
+pub(crate) struct HandlesArgs {
+    /// Account address (`0x...`).
+    #[arg(value_name = "ADDRESS")]
+    pub(crate) address: String,
+    /// Read from a historical ledger version.
+    #[arg(long)]
+    pub(crate) ledger_version: Option<u64>,
+    /// Print an aligned table instead of JSON.
+    #[arg(long, default_value_t = false)]
+    pub(crate) pretty: bool,
+}
+
+/// An `EventHandle` found inside one of the account's resources.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize)]
+struct EventHandleEntry {
+    creation_number: u64,
+    counter: u64,
+    /// Account the handle's GUID was created by.
+    address: String,
+    resource_type: String,
+    /// Dotted path of the handle inside the resource data, with `[i]` for
+    /// vector elements, e.g. `deposit_events`.
+    field_path: String,
+}
+
+impl OutputSchema for EventHandleEntry {
+    fn output_schema() -> Value {
+        object_schema(
+            "Event handle stored in a resource",
+            &[
+                (
+                    "creation_number",
+                    integer_schema("GUID creation number, as `aptly events` takes it"),
+                ),
+                ("counter", integer_schema("Events emitted so far")),
+                ("address", string_schema("GUID account address (64-hex)")),
+                (
+                    "resource_type",
+                    string_schema("Resource holding the handle"),
+                ),
+                (
+                    "field_path",
+                    string_schema("Path of the handle inside the resource data"),
+                ),
+            ],
+        )
+    }
+}
+
+pub(crate) fn handles_output_schema() -> Value {
+    array_schema(
+        "Event handles sorted by creation number",
+        EventHandleEntry::output_schema(),
+    )
+}
+
+/// `account handles`: every event handle in the account's resources.
+pub(crate) fn run_account_handles(client: &AptosClient, args: &HandlesArgs) -> Result<()> {
+    let resources = list_resources(client, &args.address, args.ledger_version)?;
+    let handles = event_handles(&resources);
+    if args.pretty {
+        for line in pretty_handle_lines(&handles) {
+            println!("{line}");
+        }
+        return Ok(());
+    }
+    crate::print_serialized(&handles)
+}
+
+/// Handles in `resources`, sorted by creation number.
+fn event_handles(resources: &[Value]) -> Vec<EventHandleEntry> {
+    let mut handles = Vec::new();
+    for resource in resources {
+        let resource_type = get_nested_string(resource, &["type"]);
+        if let Some(data) = resource.get("data") {
+            collect_handles(data, &resource_type, "", &mut handles);
+        }
+    }
+    handles.sort_by(|a, b| {
+        (
+            a.creation_number,
+            &a.address,
+            &a.resource_type,
+            &a.field_path,
+        )
+            .cmp(&(
+                b.creation_number,
+                &b.address,
+                &b.resource_type,
+                &b.field_path,
+            ))
+    });
+    handles
+}
+
+/// Walks `value` for objects shaped like
+/// `{counter, guid: {id: {addr, creation_num}}}`.
+fn collect_handles(
+    value: &Value,
+    resource_type: &str,
+    path: &str,
+    handles: &mut Vec<EventHandleEntry>,
+) {
+    match value {
+        Value::Object(fields) => {
+            let id = value.pointer("/guid/id");
+            let creation_number = id.and_then(|id| id.get("creation_num")).and_then(parse_u64);
+            let address = id.and_then(|id| id.get("addr")).and_then(Value::as_str);
+            let counter = fields.get("counter").and_then(parse_u64);
+            if let (Some(creation_number), Some(address), Some(counter)) =
+                (creation_number, address, counter)
+            {
+                handles.push(EventHandleEntry {
+                    creation_number,
+                    counter,
+                    address: normalize_address(address),
+                    resource_type: resource_type.to_owned(),
+                    field_path: path.to_owned(),
+                });
+                return;
+            }
+            for (name, field) in fields {
+                let path = if path.is_empty() {
+                    name.clone()
+                } else {
+                    format!("{path}.{name}")
+                };
+                collect_handles(field, resource_type, &path, handles);
+            }
+        }
+        Value::Array(items) => {
+            for (index, item) in items.iter().enumerate() {
+                collect_handles(item, resource_type, &format!("{path}[{index}]"), handles);
+            }
+        }
+        _ => {}
+    }
+}
+
+/// `--pretty`: creation number and counter right-aligned, then the
+/// resource type and field path.
+fn pretty_handle_lines(handles: &[EventHandleEntry]) -> Vec<String> {
+    let width = |value: fn(&EventHandleEntry) -> u64, header: &str| {
+        handles
+            .iter()
+            .map(|handle| value(handle).to_string().len())
+            .chain([header.len()])
+            .max()
+            .unwrap_or(0)
+    };
+    let number = width(|handle| handle.creation_number, "creation");
+    let counter = width(|handle| handle.counter, "counter");
+    let mut lines = vec![format!(
+        "{:>number$}  {:>counter$}  resource / field",
+        "creation", "counter"
+    )];
+    lines.extend(handles.iter().map(|handle| {
+        format!(
+            "{:>number$}  {:>counter$}  {} / {}",
+            handle.creation_number, handle.counter, handle.resource_type, handle.field_path
+        )
+    }));
+    lines
+}
+
+/// Sidecar written next to an archive after each sync.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+struct ArchiveState {
+    last_sequence_number: Option<u64>,
+    ledger_version: u64,
+}
+
+/// What `events sync` prints once done.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize)]
+struct SyncSummary {
+    archive: String,
+    appended: u64,
+    last_sequence_number: Option<u64>,
+    ledger_version: u64,
+    repaired_bytes: u64,
+}
+
+impl OutputSchema for SyncSummary {
+    fn output_schema() -> Value {
+        object_schema(
+            "Result of one archive sync",
+            &[
+                ("archive", string_schema("Archive path")),
+                ("appended", integer_schema("Events appended by this run")),
+                (
+                    "last_sequence_number",
+                    nullable(integer_schema(
+                        "Sequence number of the archive's last event; null while empty",
+                    )),
+                ),
+                (
+                    "ledger_version",
+                    integer_schema("Ledger version observed before paging"),
+                ),
+                (
+                    "repaired_bytes",
+                    integer_schema("Bytes of an incomplete or corrupt last record cut off"),
+                ),
+            ],
+        )
+    }
+}
+
+pub(crate) fn sync_output_schema() -> Value {
+    SyncSummary::output_schema()
 }
 
 pub(crate) fn run_events(client: &AptosClient, command: EventsCommand) -> Result<()> {
+    if let Some(EventsSubcommand::Sync(args)) = command.command {
+        return run_events_sync(client, &args);
+    }
+    let (Some(address), Some(creation_number)) = (command.address, command.creation_number) else {
+        return Err(anyhow!(
+            "missing address and creation number, or subcommand"
+        ));
+    };
+
+    if command.follow.follow {
+        let source = FollowSource::Events {
+            address,
+            creation_number,
+        };
+        return run_follow(
+            client,
+            source,
+            command.start,
+            command.limit,
+            &command.follow,
+        );
+    }
+
     let mut path = format!(
-        "/accounts/{}/events/{}?limit={}",
-        command.address, command.creation_number, command.limit
+        "/accounts/{address}/events/{creation_number}?limit={}",
+        command.limit
     );
     if command.start > 0 {
         path.push_str(&format!("&start={}", command.start));
@@ -33,3 +314,357 @@ pub(crate) fn run_events(client: &AptosClient, command: EventsCommand) -> Result
     let value = client.get_json(&path)?;
     crate::print_pretty_json(&value)
 }
+
+fn run_events_sync(client: &AptosClient, args: &EventsSyncArgs) -> Result<()> {
+    let source = FollowSource::Events {
+        address: args.address.clone(),
+        creation_number: handle_path(&args.handle)?,
+    };
+    let ledger = client.get_json("/")?;
+    let ledger_version = parse_u64(ledger.get("ledger_version").unwrap_or(&Value::Null))
+        .ok_or_else(|| anyhow!("failed to parse `ledger_version` from ledger response"))?;
+    let summary = sync_archive(&args.archive, ledger_version, |start| {
+        match client.get_json(&source.path(start, EVENTS_PAGE_LIMIT))? {
+            Value::Array(events) => Ok(events),
+            _ => Err(anyhow!("unexpected events response format")),
+        }
+    })?;
+    crate::print_serialized(&summary)
+}
+
+/// Path segment(s) after `/events/`: a creation number as is, or
+/// `<struct tag>/<field>` with the struct tag URL-encoded.
+fn handle_path(handle: &str) -> Result<String> {
+    let handle = handle.trim();
+    if !handle.is_empty() && handle.bytes().all(|byte| byte.is_ascii_digit()) {
+        return Ok(handle.to_owned());
+    }
+    match handle.rsplit_once('/') {
+        Some((struct_tag, field)) if struct_tag.contains("::") && !field.is_empty() => {
+            Ok(format!("{}/{field}", urlencoding::encode(struct_tag)))
+        }
+        _ => Err(anyhow!(
+            "invalid event handle `{handle}`; expected a creation number or `<struct tag>/<field name>`"
+        )),
+    }
+}
+
+/// Repairs `archive`, then appends every event after its last record.
+/// `fetch_page(start)` returns up to [`EVENTS_PAGE_LIMIT`] events from
+/// sequence number `start`.
+fn sync_archive(
+    archive: &Path,
+    ledger_version: u64,
+    mut fetch_page: impl FnMut(u64) -> Result<Vec<Value>>,
+) -> Result<SyncSummary> {
+    let mut file = OpenOptions::new()
+        .read(true)
+        .append(true)
+        .create(true)
+        .open(archive)
+        .with_context(|| format!("failed to open archive {}", archive.display()))?;
+    let (mut last_sequence_number, repaired_bytes) = repair_archive(&mut file, ARCHIVE_TAIL_WINDOW)
+        .with_context(|| format!("failed to read archive {}", archive.display()))?;
+    if repaired_bytes > 0 {
+        eprintln!(
+            "repaired {}: cut {repaired_bytes} byte(s) of an incomplete last record",
+            archive.display()
+        );
+    }
+    let state_path = state_path(archive);
+    if let Some(state) = read_state(&state_path) {
+        if state.last_sequence_number > last_sequence_number {
+            eprintln!(
+                "{} is behind {}; resuming from the archive",
+                archive.display(),
+                state_path.display()
+            );
+        }
+    }
+
+    let mut writer = BufWriter::new(file);
+    let mut appended = 0;
+    loop {
+        let start = last_sequence_number.map_or(0, |sequence| sequence + 1);
+        let events = fetch_page(start)?;
+        let page_len = events.len() as u64;
+        for event in events {
+            let sequence = event
+                .get("sequence_number")
+                .and_then(parse_u64)
+                .ok_or_else(|| anyhow!("event has no `sequence_number`"))?;
+            // Pages start at `start`; anything older is already archived.
+            if sequence < start {
+                continue;
+            }
+            serde_json::to_writer(&mut writer, &event)?;
+            writer.write_all(b"\n")?;
+            last_sequence_number = Some(sequence);
+            appended += 1;
+        }
+        // Flush per page so an interrupted sync keeps whole pages.
+        writer
+            .flush()
+            .with_context(|| format!("failed to write archive {}", archive.display()))?;
+        if page_len < EVENTS_PAGE_LIMIT {
+            break;
+        }
+    }
+
+    let state = ArchiveState {
+        last_sequence_number,
+        ledger_version,
+    };
+    let mut text = serde_json::to_string_pretty(&state)?;
+    text.push('\n');
+    fs::write(&state_path, text)
+        .with_context(|| format!("failed to write {}", state_path.display()))?;
+
+    Ok(SyncSummary {
+        archive: archive.display().to_string(),
+        appended,
+        last_sequence_number,
+        ledger_version,
+        repaired_bytes,
+    })
+}
+
+fn state_path(archive: &Path) -> PathBuf {
+    let mut name = archive.as_os_str().to_owned();
+    name.push(".state.json");
+    PathBuf::from(name)
+}
+
+/// The sidecar, if present and readable; the archive itself stays the
+/// source of truth.
+fn read_state(path: &Path) -> Option<ArchiveState> {
+    serde_json::from_str(&fs::read_to_string(path).ok()?).ok()
+}
+
+/// Truncates `file` after its last complete record and returns that
+/// record's sequence number with the number of bytes cut. A record is
+/// complete when it is newline-terminated JSON with a `sequence_number`.
+/// Only the tail is read, `window` bytes at a time.
+fn repair_archive(file: &mut File, window: u64) -> Result<(Option<u64>, u64)> {
+    let len = file.metadata()?.len();
+    let mut end = len;
+    let mut window = window.max(1);
+    let last = loop {
+        if end == 0 {
+            break None;
+        }
+        let start = end.saturating_sub(window);
+        let mut tail = vec![0; (end - start) as usize];
+        file.seek(SeekFrom::Start(start))?;
+        file.read_exact(&mut tail)?;
+
+        // A last line without its newline is a partial write.
+        if tail.last() != Some(&b'\n') {
+            match tail.iter().rposition(|byte| *byte == b'\n') {
+                Some(newline) => end = start + newline as u64 + 1,
+                None if start == 0 => end = 0,
+                None => window *= 2,
+            }
+            continue;
+        }
+        let body = &tail[..tail.len() - 1];
+        let line_start = match body.iter().rposition(|byte| *byte == b'\n') {
+            Some(newline) => newline + 1,
+            None if start == 0 => 0,
+            None => {
+                window *= 2;
+                continue;
+            }
+        };
+        let sequence = serde_json::from_slice::<Value>(&body[line_start..])
+            .ok()
+            .and_then(|event| event.get("sequence_number").and_then(parse_u64));
+        match sequence {
+            Some(sequence) => break Some(sequence),
+            None => end = start + line_start as u64,
+        }
+    };
+    if end < len {
+        file.set_len(end)?;
+    }
+    Ok((last, len - end))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::commands::schema::validate;
+    use serde_json::json;
+
+    #[test]
+    fn finds_nested_event_handles() {
+        let handle = |creation_num: &str, counter: &str| json!({"counter": counter, "guid": {"id": {"addr": "0xa", "creation_num": creation_num}}});
+        let resources = [
+            json!({
+                "type": "0x1::coin::CoinStore<0x1::aptos_coin::AptosCoin>",
+                "data": {
+                    "coin": {"value": "5"},
+                    "deposit_events": handle("3", "12"),
+                    "withdraw_events": handle("2", "7"),
+                },
+            }),
+            json!({
+                "type": "0xcafe::pool::Pools",
+                "data": {"pools": [{"info": {"swaps": handle("10", "0")}}], "guid": {"id": {}}},
+            }),
+            json!({"type": "0x1::account::Account", "data": {"key_rotation_events": handle("1", "0")}}),
+        ];
+        let handles = event_handles(&resources);
+        let found: Vec<(u64, &str)> = handles
+            .iter()
+            .map(|handle| (handle.creation_number, handle.field_path.as_str()))
+            .collect();
+        assert_eq!(
+            found,
+            [
+                (1, "key_rotation_events"),
+                (2, "withdraw_events"),
+                (3, "deposit_events"),
+                (10, "pools[0].info.swaps"),
+            ]
+        );
+        assert_eq!(handles[2].counter, 12);
+        validate(
+            &handles_output_schema(),
+            &serde_json::to_value(&handles).unwrap(),
+        )
+        .unwrap();
+
+        let lines = pretty_handle_lines(&handles);
+        assert_eq!(lines[0], "creation  counter  resource / field");
+        assert_eq!(
+            lines[3],
+            "       3       12  0x1::coin::CoinStore<0x1::aptos_coin::AptosCoin> / deposit_events"
+        );
+    }
+
+    fn event(sequence: u64) -> Value {
+        json!({
+            "version": (1000 + sequence).to_string(),
+            "guid": {"creation_number": "0", "account_address": "0x1"},
+            "sequence_number": sequence.to_string(),
+            "type": "0x1::market::ListingFilled",
+            "data": {"price": "100"},
+        })
+    }
+
+    /// Serves `total` events in node-sized pages, recording each `start`.
+    fn node(total: u64, starts: &mut Vec<u64>) -> impl FnMut(u64) -> Result<Vec<Value>> + '_ {
+        move |start| {
+            starts.push(start);
+            Ok((start..total.min(start + EVENTS_PAGE_LIMIT))
+                .map(event)
+                .collect())
+        }
+    }
+
+    fn archived_sequences(archive: &Path) -> Vec<u64> {
+        fs::read_to_string(archive)
+            .unwrap()
+            .lines()
+            .map(|line| {
+                parse_u64(&serde_json::from_str::<Value>(line).unwrap()["sequence_number"]).unwrap()
+            })
+            .collect()
+    }
+
+    #[test]
+    fn syncs_incrementally() {
+        let dir = tempfile::tempdir().unwrap();
+        let archive = dir.path().join("events.jsonl");
+
+        let mut starts = Vec::new();
+        let summary = sync_archive(&archive, 5000, node(250, &mut starts)).unwrap();
+        assert_eq!(starts, [0, 100, 200]);
+        assert_eq!(
+            (summary.appended, summary.last_sequence_number),
+            (250, Some(249))
+        );
+        validate(
+            &SyncSummary::output_schema(),
+            &serde_json::to_value(&summary).unwrap(),
+        )
+        .unwrap();
+
+        let mut starts = Vec::new();
+        let summary = sync_archive(&archive, 5100, node(260, &mut starts)).unwrap();
+        assert_eq!(starts, [250]);
+        assert_eq!(summary.appended, 10);
+        assert_eq!(archived_sequences(&archive), (0..260).collect::<Vec<_>>());
+        assert_eq!(
+            read_state(&state_path(&archive)),
+            Some(ArchiveState {
+                last_sequence_number: Some(259),
+                ledger_version: 5100,
+            })
+        );
+
+        let summary = sync_archive(&archive, 5200, node(260, &mut Vec::new())).unwrap();
+        assert_eq!((summary.appended, summary.repaired_bytes), (0, 0));
+    }
+
+    #[test]
+    fn repairs_partial_and_corrupt_tails() {
+        let dir = tempfile::tempdir().unwrap();
+        let archive = dir.path().join("events.jsonl");
+        sync_archive(&archive, 1, node(3, &mut Vec::new())).unwrap();
+        let complete = fs::metadata(&archive).unwrap().len();
+
+        // A write cut mid-record, after a line that is not an event.
+        let mut file = OpenOptions::new().append(true).open(&archive).unwrap();
+        file.write_all(b"{\"oops\": true}\n{\"version\": \"10")
+            .unwrap();
+        drop(file);
+
+        let mut starts = Vec::new();
+        let summary = sync_archive(&archive, 2, node(5, &mut starts)).unwrap();
+        assert_eq!(summary.repaired_bytes, 30);
+        assert_eq!(starts, [3]);
+        assert_eq!(archived_sequences(&archive), [0, 1, 2, 3, 4]);
+        assert!(fs::metadata(&archive).unwrap().len() > complete);
+    }
+
+    #[test]
+    fn finds_last_record_with_small_windows() {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("events.jsonl");
+        let lines = format!("{}\n{}\n{{\"partial", event(0), event(1));
+        for window in [1, 7, 4096] {
+            fs::write(&path, &lines).unwrap();
+            let mut file = OpenOptions::new()
+                .read(true)
+                .write(true)
+                .open(&path)
+                .unwrap();
+            assert_eq!(
+                repair_archive(&mut file, window).unwrap(),
+                (Some(1), 9),
+                "window {window}"
+            );
+        }
+
+        fs::write(&path, "not json\n").unwrap();
+        let mut file = OpenOptions::new()
+            .read(true)
+            .write(true)
+            .open(&path)
+            .unwrap();
+        assert_eq!(repair_archive(&mut file, 4).unwrap(), (None, 9));
+        assert_eq!(fs::metadata(&path).unwrap().len(), 0);
+    }
+
+    #[test]
+    fn parses_handles() {
+        assert_eq!(handle_path("12").unwrap(), "12");
+        assert_eq!(
+            handle_path("0x1::coin::CoinStore<0x1::aptos_coin::AptosCoin>/deposit_events").unwrap(),
+            "0x1%3A%3Acoin%3A%3ACoinStore%3C0x1%3A%3Aaptos_coin%3A%3AAptosCoin%3E/deposit_events"
+        );
+        assert!(handle_path("deposit_events").is_err());
+    }
+}
@@ -0,0 +1,490 @@
+use anyhow::{anyhow, Context, Result};
+use aptly_aptos::AptosClient;
+use clap::{Args, Subcommand};
+use serde::Serialize;
+use serde_json::Value;
+
+use crate::commands::account::format_amount;
+use crate::commands::auth::sha3_256;
+use crate::commands::coin::paired_metadata;
+use crate::commands::common::{normalize_address, parse_u64, with_optional_ledger_version};
+use crate::commands::schema::{
+    array_schema, boolean_schema, integer_schema, nullable, object_schema, string_schema,
+    OutputSchema,
+};
+
+const FUNGIBLE_STORE_TYPE: &str = "0x1::fungible_asset::FungibleStore";
+const CONCURRENT_BALANCE_TYPE: &str = "0x1::fungible_asset::ConcurrentFungibleBalance";
+const METADATA_TYPE: &str = "0x1::fungible_asset::Metadata";
+/// Domain separator of `object::create_user_derived_object_address`.
+const OBJECT_DERIVED_SCHEME: u8 = 0xfc;
+
+/// Resources on a metadata object that shape how the asset behaves, with the
+/// name reported for each.
+const METADATA_FEATURES: &[(&str, &str)] = &[
+    ("0x1::fungible_asset::ConcurrentSupply", "concurrent_supply"),
+    ("0x1::fungible_asset::Supply", "supply"),
+    ("0x1::fungible_asset::Untransferable", "untransferable"),
+    (
+        "0x1::fungible_asset::DispatchFunctionStore",
+        "dispatch_functions",
+    ),
+    (
+        "0x1::fungible_asset::DeriveSupply",
+        "dispatch_derived_supply",
+    ),
+    ("0x1::object::Untransferable", "object_untransferable"),
+];
+
+#[derive(Args)]
+#[command(
+    after_help = "Examples:\n  aptly fa status 0x1234 0xa\n  aptly fa status 0x1234 0xbae207659db88bea0cbead6da0ed00aac12edcdda169e591cd41c94180b46f3b --pretty"
+)]
+pub(crate) struct FaCommand {
+    #[command(subcommand)]
+    pub(crate) command: FaSubcommand,
+}
+
+#[derive(Subcommand)]
+pub(crate) enum FaSubcommand {
+    #[command(
+        about = "Report an owner's primary store: existence, balance and frozen flag",
+        after_help = "`frozen` is read from the store itself. Whether the issuer can freeze stores is not visible on chain (it depends on who holds a `TransferRef`); `metadata_features` lists the metadata object's supply and dispatch resources as a hint."
+    )]
+    Status(FaStatusArgs),
+}
+
+#[derive(Args)]
+pub(crate) struct FaStatusArgs {
+    /// Owner of the primary store (`0x...`).
+    #[arg(value_name = "OWNER")]
+    pub(crate) owner: String,
+    /// Fungible asset metadata object address (`0x...`).
+    #[arg(value_name = "METADATA_ADDRESS")]
+    pub(crate) metadata_address: String,
+    /// Print a one-line summary instead of JSON.
+    #[arg(long, default_value_t = false)]
+    pub(crate) pretty: bool,
+}
+
+#[derive(Args)]
+pub(crate) struct PrimaryStoreArgs {
+    /// Owner of the primary store (`0x...`).
+    #[arg(value_name = "ADDRESS")]
+    pub(crate) address: String,
+    /// Fungible asset metadata address, or a coin type whose paired
+    /// metadata is looked up.
+    #[arg(value_name = "ASSET")]
+    pub(crate) asset: String,
+    /// Read from a historical ledger version.
+    #[arg(long)]
+    pub(crate) ledger_version: Option<u64>,
+}
+
+#[derive(Debug, Clone, PartialEq, Eq, Serialize)]
+struct PrimaryStore {
+    owner: String,
+    metadata_address: String,
+    /// Derived primary store object address.
+    address: String,
+    exists: bool,
+    balance: Option<String>,
+    frozen: Option<bool>,
+}
+
+impl OutputSchema for PrimaryStore {
+    fn output_schema() -> Value {
+        object_schema(
+            "Derived primary fungible store of an owner and its state",
+            &[
+                ("owner", string_schema("Store owner (64-hex)")),
+                (
+                    "metadata_address",
+                    string_schema("Fungible asset metadata object (64-hex)"),
+                ),
+                (
+                    "address",
+                    string_schema("Derived primary store object address (64-hex)"),
+                ),
+                (
+                    "exists",
+                    boolean_schema("Whether the store has been created"),
+                ),
+                (
+                    "balance",
+                    nullable(string_schema("Balance in base units; null without a store")),
+                ),
+                (
+                    "frozen",
+                    nullable(boolean_schema("Store frozen flag; null without a store")),
+                ),
+            ],
+        )
+    }
+}
+
+pub(crate) fn primary_store_output_schema() -> Value {
+    PrimaryStore::output_schema()
+}
+
+/// `account primary-store`: the derived store address is printed whether
+/// or not the store exists yet.
+pub(crate) fn run_account_primary_store(
+    client: &AptosClient,
+    args: &PrimaryStoreArgs,
+) -> Result<()> {
+    let metadata_address = if args.asset.contains("::") {
+        paired_metadata(client, &args.asset)?
+            .ok_or_else(|| anyhow!("{} has no paired fungible asset", args.asset))?
+    } else {
+        args.asset.clone()
+    };
+    let store = primary_store_address(&args.address, &metadata_address)?;
+    let store_resources = read_store_resources(client, &store, args.ledger_version)?;
+    let state = store_balance(&store_resources)?;
+    crate::print_serialized(&PrimaryStore {
+        owner: normalize_address(&args.address),
+        metadata_address: normalize_address(&metadata_address),
+        address: store,
+        exists: state.is_some(),
+        balance: state.map(|(balance, _)| balance.to_string()),
+        frozen: state.map(|(_, frozen)| frozen),
+    })
+}
+
+#[derive(Debug, Clone, PartialEq, Eq, Serialize)]
+struct StoreStatus {
+    owner: String,
+    metadata_address: String,
+    symbol: String,
+    decimals: u8,
+    primary_store: String,
+    store_exists: bool,
+    balance: Option<String>,
+    balance_formatted: Option<String>,
+    frozen: Option<bool>,
+    metadata_features: Vec<String>,
+}
+
+impl OutputSchema for StoreStatus {
+    fn output_schema() -> Value {
+        object_schema(
+            "Primary fungible store of an owner for one asset",
+            &[
+                ("owner", string_schema("Store owner (64-hex)")),
+                (
+                    "metadata_address",
+                    string_schema("Fungible asset metadata object (64-hex)"),
+                ),
+                ("symbol", string_schema("Asset symbol")),
+                ("decimals", integer_schema("Asset decimals")),
+                (
+                    "primary_store",
+                    string_schema("Derived primary store object address (64-hex)"),
+                ),
+                (
+                    "store_exists",
+                    boolean_schema("Whether the store has been created"),
+                ),
+                (
+                    "balance",
+                    nullable(string_schema("Balance in base units; null without a store")),
+                ),
+                (
+                    "balance_formatted",
+                    nullable(string_schema("Balance scaled by `decimals`")),
+                ),
+                (
+                    "frozen",
+                    nullable(boolean_schema("Store frozen flag; null without a store")),
+                ),
+                (
+                    "metadata_features",
+                    array_schema(
+                        "Supply and dispatch resources on the metadata object",
+                        string_schema("Feature name"),
+                    ),
+                ),
+            ],
+        )
+    }
+}
+
+pub(crate) fn status_output_schema() -> Value {
+    StoreStatus::output_schema()
+}
+
+pub(crate) fn run_fa(client: &AptosClient, command: FaCommand) -> Result<()> {
+    match command.command {
+        FaSubcommand::Status(args) => {
+            let status = store_status(client, &args.owner, &args.metadata_address)?;
+            if args.pretty {
+                println!("{}", render_line(&status));
+                return Ok(());
+            }
+            crate::print_serialized(&status)
+        }
+    }
+}
+
+/// Address of `owner`'s primary store for `metadata`, as
+/// `primary_fungible_store::primary_store_address` derives it.
+pub(crate) fn primary_store_address(owner: &str, metadata: &str) -> Result<String> {
+    let mut preimage = address_bytes(owner)?.to_vec();
+    preimage.extend_from_slice(&address_bytes(metadata)?);
+    preimage.push(OBJECT_DERIVED_SCHEME);
+    Ok(format!("0x{}", hex::encode(sha3_256(&preimage))))
+}
+
+fn address_bytes(address: &str) -> Result<[u8; 32]> {
+    let normalized = normalize_address(address);
+    hex::decode(&normalized[2..])
+        .ok()
+        .filter(|_| normalized.len() == 66)
+        .and_then(|bytes| bytes.try_into().ok())
+        .ok_or_else(|| anyhow!("`{address}` is not an account address"))
+}
+
+fn store_status(client: &AptosClient, owner: &str, metadata_address: &str) -> Result<StoreStatus> {
+    let store = primary_store_address(owner, metadata_address)?;
+    let metadata_address = normalize_address(metadata_address);
+    let metadata_resources = client
+        .get_json(&format!("/accounts/{metadata_address}/resources"))
+        .with_context(|| format!("failed to read metadata object {metadata_address}"))?;
+    let store_resources = read_store_resources(client, &store, None)?;
+    build_status(
+        owner,
+        &metadata_address,
+        &store,
+        &metadata_resources,
+        &store_resources,
+    )
+}
+
+/// Raw balance of `owner`'s primary store for `metadata`, or `None` when
+/// the store was never created.
+pub(crate) fn primary_store_balance(
+    client: &AptosClient,
+    owner: &str,
+    metadata: &str,
+    ledger_version: Option<u64>,
+) -> Result<Option<u64>> {
+    let store = primary_store_address(owner, metadata)?;
+    let store_resources = read_store_resources(client, &store, ledger_version)?;
+    Ok(store_balance(&store_resources)?.map(|(balance, _)| balance))
+}
+
+/// Resources at a store address. A store that was never created is a 404
+/// or an address without a `FungibleStore`; any other failure is the node's.
+fn read_store_resources(
+    client: &AptosClient,
+    store: &str,
+    ledger_version: Option<u64>,
+) -> Result<Value> {
+    let path =
+        with_optional_ledger_version(&format!("/accounts/{store}/resources"), ledger_version);
+    match client.get_json(&path) {
+        Ok(resources) => Ok(resources),
+        Err(err) if err.to_string().contains("status 404") => Ok(Value::Array(Vec::new())),
+        Err(err) => Err(err).with_context(|| format!("failed to read primary store {store}")),
+    }
+}
+
+/// Balance and frozen flag of the `FungibleStore` among `store_resources`.
+fn store_balance(store_resources: &Value) -> Result<Option<(u64, bool)>> {
+    let Some(data) = resource_data(store_resources, FUNGIBLE_STORE_TYPE) else {
+        return Ok(None);
+    };
+    // Concurrent stores keep the balance aside and leave `balance` 0.
+    let balance = resource_data(store_resources, CONCURRENT_BALANCE_TYPE)
+        .and_then(|concurrent| concurrent.pointer("/balance/value"))
+        .or_else(|| data.get("balance"))
+        .and_then(parse_u64)
+        .ok_or_else(|| anyhow!("unexpected {FUNGIBLE_STORE_TYPE} format"))?;
+    let frozen = data
+        .get("frozen")
+        .and_then(Value::as_bool)
+        .ok_or_else(|| anyhow!("unexpected {FUNGIBLE_STORE_TYPE} format"))?;
+    Ok(Some((balance, frozen)))
+}
+
+fn build_status(
+    owner: &str,
+    metadata_address: &str,
+    store: &str,
+    metadata_resources: &Value,
+    store_resources: &Value,
+) -> Result<StoreStatus> {
+    let metadata = resource_data(metadata_resources, METADATA_TYPE).ok_or_else(|| {
+        anyhow!("{metadata_address} has no {METADATA_TYPE}; is it a fungible asset?")
+    })?;
+    let decimals = parse_u64(metadata.get("decimals").unwrap_or(&Value::Null))
+        .and_then(|decimals| u8::try_from(decimals).ok())
+        .ok_or_else(|| anyhow!("unexpected {METADATA_TYPE} format"))?;
+    let symbol = metadata
+        .get("symbol")
+        .and_then(Value::as_str)
+        .unwrap_or_default()
+        .to_owned();
+    let metadata_features = METADATA_FEATURES
+        .iter()
+        .filter(|(resource_type, _)| resource_data(metadata_resources, resource_type).is_some())
+        .map(|(_, feature)| (*feature).to_owned())
+        .collect();
+
+    let fungible_store = store_balance(store_resources)?;
+    let (balance, frozen) = match fungible_store {
+        Some((balance, frozen)) => (Some(balance.to_string()), Some(frozen)),
+        None => (None, None),
+    };
+
+    Ok(StoreStatus {
+        owner: normalize_address(owner),
+        metadata_address: metadata_address.to_owned(),
+        symbol,
+        decimals,
+        primary_store: store.to_owned(),
+        store_exists: fungible_store.is_some(),
+        balance_formatted: balance
+            .as_deref()
+            .map(|balance| format_amount(balance, decimals)),
+        balance,
+        frozen,
+        metadata_features,
+    })
+}
+
+fn resource_data<'a>(resources: &'a Value, resource_type: &str) -> Option<&'a Value> {
+    resources
+        .as_array()?
+        .iter()
+        .find(|resource| resource.get("type").and_then(Value::as_str) == Some(resource_type))?
+        .get("data")
+}
+
+/// One line for support macros, with full addresses so it can be pasted
+/// into an explorer.
+fn render_line(status: &StoreStatus) -> String {
+    let state = match (&status.balance_formatted, &status.balance, status.frozen) {
+        (Some(formatted), Some(raw), Some(frozen)) => format!(
+            "holds {formatted} {} ({raw} raw), {}",
+            status.symbol,
+            if frozen { "FROZEN" } else { "not frozen" }
+        ),
+        _ => "never created".to_owned(),
+    };
+    format!(
+        "{} {} primary store {}: {state}",
+        status.owner, status.symbol, status.primary_store
+    )
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::commands::schema::validate;
+    use serde_json::json;
+
+    const USDC: &str = "0xbae207659db88bea0cbead6da0ed00aac12edcdda169e591cd41c94180b46f3b";
+
+    fn metadata_resources() -> Value {
+        json!([
+            {"type": "0x1::object::ObjectCore", "data": {"allow_ungated_transfer": false}},
+            {"type": METADATA_TYPE, "data": {"decimals": 6, "symbol": "USDC", "name": "USDC"}},
+            {"type": "0x1::fungible_asset::ConcurrentSupply", "data": {"current": {"value": "1"}}},
+            {"type": "0x1::fungible_asset::DispatchFunctionStore", "data": {}},
+        ])
+    }
+
+    #[test]
+    fn derives_primary_store_addresses() {
+        // sha3_256(owner || metadata || 0xfc), checked with Python's hashlib.
+        assert_eq!(
+            primary_store_address("0x1", "0xa").unwrap(),
+            "0xc6d3d69a9810647845a5ca5ebe905256dc37327c1c39c1d673de00caaac0e3a8"
+        );
+        assert!(primary_store_address("alice", "0xa").is_err());
+    }
+
+    #[test]
+    fn reads_store_balance_and_frozen_flag() {
+        let plain = json!([
+            {"type": FUNGIBLE_STORE_TYPE, "data": {"metadata": {"inner": "0xa"}, "balance": "700", "frozen": false}},
+        ]);
+        assert_eq!(store_balance(&plain).unwrap(), Some((700, false)));
+        let concurrent = json!([
+            {"type": FUNGIBLE_STORE_TYPE, "data": {"metadata": {"inner": USDC}, "balance": "0", "frozen": true}},
+            {"type": CONCURRENT_BALANCE_TYPE, "data": {"balance": {"value": "12500000", "max_value": "0"}}},
+        ]);
+        assert_eq!(
+            store_balance(&concurrent).unwrap(),
+            Some((12_500_000, true))
+        );
+        assert_eq!(store_balance(&json!([])).unwrap(), None, "never created");
+
+        let missing = PrimaryStore {
+            owner: normalize_address("0x1"),
+            metadata_address: normalize_address("0xa"),
+            address: primary_store_address("0x1", "0xa").unwrap(),
+            exists: false,
+            balance: None,
+            frozen: None,
+        };
+        validate(
+            &primary_store_output_schema(),
+            &serde_json::to_value(&missing).unwrap(),
+        )
+        .unwrap();
+    }
+
+    #[test]
+    fn reports_existing_and_missing_stores() {
+        let store_resources = json!([
+            {"type": FUNGIBLE_STORE_TYPE, "data": {"metadata": {"inner": USDC}, "balance": "0", "frozen": true}},
+            {"type": CONCURRENT_BALANCE_TYPE, "data": {"balance": {"value": "12500000", "max_value": "0"}}},
+        ]);
+        let status = build_status(
+            "0x1234",
+            USDC,
+            "0x5",
+            &metadata_resources(),
+            &store_resources,
+        )
+        .unwrap();
+        assert_eq!(
+            (
+                status.balance.as_deref(),
+                status.balance_formatted.as_deref(),
+                status.frozen
+            ),
+            (Some("12500000"), Some("12.5"), Some(true))
+        );
+        assert_eq!(
+            status.metadata_features,
+            ["concurrent_supply", "dispatch_functions"]
+        );
+        validate(
+            &StoreStatus::output_schema(),
+            &serde_json::to_value(&status).unwrap(),
+        )
+        .unwrap();
+        assert_eq!(
+            render_line(&status),
+            format!(
+                "{} USDC primary store 0x5: holds 12.5 USDC (12500000 raw), FROZEN",
+                normalize_address("0x1234")
+            )
+        );
+
+        let missing =
+            build_status("0x1234", USDC, "0x5", &metadata_resources(), &json!([])).unwrap();
+        assert!(!missing.store_exists);
+        assert_eq!((&missing.balance, missing.frozen), (&None, None));
+        assert!(render_line(&missing).ends_with("primary store 0x5: never created"));
+
+        let err = build_status("0x1234", "0x9", "0x5", &json!([]), &json!([])).unwrap_err();
+        assert!(err
+            .to_string()
+            .starts_with("0x9 has no 0x1::fungible_asset::Metadata"));
+    }
+}
@@ -0,0 +1,81 @@
+use anyhow::{anyhow, Context, Result};
+use aptly_aptos::AptosClient;
+use clap::Args;
+use serde_json::{json, Value};
+
+use crate::commands::schema::{array_schema, string_schema};
+use crate::network::Network;
+
+/// One APT in octas.
+const DEFAULT_AMOUNT: u64 = 100_000_000;
+
+#[derive(Args)]
+#[command(
+    after_help = "Examples:\n  aptly --network local faucet 0x1234\n  aptly --network devnet faucet 0x1234 --amount 500000000\n  aptly --rpc-url http://127.0.0.1:8080/v1 faucet 0x1234 --faucet-url http://127.0.0.1:8081"
+)]
+pub(crate) struct FaucetCommand {
+    /// Account to fund (`0x...`); created if it does not exist.
+    #[arg(value_name = "ADDRESS")]
+    pub(crate) address: String,
+    /// Amount in octas.
+    #[arg(long, default_value_t = DEFAULT_AMOUNT)]
+    pub(crate) amount: u64,
+    /// Faucet endpoint. Defaults to the faucet of the network selected with
+    /// `--network` (or whose default `--rpc-url` is in use).
+    #[arg(long, value_name = "URL")]
+    pub(crate) faucet_url: Option<String>,
+}
+
+pub(crate) fn faucet_output_schema() -> Value {
+    array_schema(
+        "Hashes of the funding transactions",
+        string_schema("Transaction hash"),
+    )
+}
+
+pub(crate) fn run_faucet(client: &AptosClient, command: FaucetCommand) -> Result<()> {
+    let faucet_url = match &command.faucet_url {
+        Some(url) => url.clone(),
+        None => default_faucet(client.base_url())?.to_owned(),
+    };
+    let faucet = AptosClient::with_options(&faucet_url, client.options().clone())?;
+    let hashes = faucet
+        .post_json(
+            &format!(
+                "/mint?amount={}&address={}",
+                command.amount, command.address
+            ),
+            &json!({}),
+        )
+        .with_context(|| format!("faucet request to {faucet_url} failed"))?;
+    crate::print_pretty_json(&hashes)
+}
+
+fn default_faucet(rpc_url: &str) -> Result<&'static str> {
+    match Network::from_rpc_url(rpc_url) {
+        Some(network) => network.faucet_url().ok_or_else(|| {
+            anyhow!(
+                "{} has no programmatic faucet; pass --faucet-url",
+                format!("{network:?}").to_lowercase()
+            )
+        }),
+        None => Err(anyhow!(
+            "no known faucet for {rpc_url}; pass --network local|devnet or --faucet-url"
+        )),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn routes_to_the_network_faucet() {
+        assert_eq!(
+            default_faucet(Network::Local.rpc_url()).unwrap(),
+            "http://127.0.0.1:8081"
+        );
+        assert!(default_faucet(Network::Mainnet.rpc_url()).is_err());
+        assert!(default_faucet("https://rpc.sentio.xyz/aptos/v1").is_err());
+    }
+}
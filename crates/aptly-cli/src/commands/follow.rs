@@ -0,0 +1,460 @@
+use anyhow::{anyhow, Result};
+use aptly_aptos::AptosClient;
+use clap::Args;
+use serde_json::Value;
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::sync::Arc;
+use std::thread;
+use std::time::{Duration, Instant};
+
+use crate::commands::common::{parse_duration, parse_u64};
+use crate::metrics::{MetricsServer, WatchMetrics};
+
+/// Flags shared by commands that support `--follow`.
+#[derive(Args)]
+pub(crate) struct FollowArgs {
+    /// Keep polling for new items and print each one as a JSON line.
+    #[arg(long, default_value_t = false)]
+    pub(crate) follow: bool,
+    /// Delay between polls once caught up, e.g. `2s`.
+    #[arg(long, default_value = "2s", value_parser = parse_duration, requires = "follow")]
+    pub(crate) poll_interval: Duration,
+    /// Serve OpenMetrics counters on this address (e.g. `:9464`) while following.
+    #[arg(long, value_name = "ADDR", requires = "follow")]
+    pub(crate) metrics_listen: Option<String>,
+}
+
+/// What a follow loop pages through; each variant knows its cursor.
+#[derive(Debug)]
+pub(crate) enum FollowSource {
+    /// Events of one handle; the cursor is the event sequence number.
+    Events {
+        address: String,
+        creation_number: String,
+    },
+    /// Transactions sent by an account; the cursor is the sequence number.
+    AccountTransactions { address: String },
+    /// Every committed transaction; the cursor is the ledger version.
+    Transactions,
+    /// Blocks in height order; the cursor is the block height.
+    Blocks { with_transactions: bool },
+}
+
+impl FollowSource {
+    pub(crate) fn path(&self, cursor: u64, limit: u64) -> String {
+        match self {
+            Self::Events {
+                address,
+                creation_number,
+            } => {
+                format!("/accounts/{address}/events/{creation_number}?start={cursor}&limit={limit}")
+            }
+            Self::AccountTransactions { address } => {
+                format!("/accounts/{address}/transactions?start={cursor}&limit={limit}")
+            }
+            Self::Transactions => format!("/transactions?start={cursor}&limit={limit}"),
+            Self::Blocks { with_transactions } => {
+                format!("/blocks/by_height/{cursor}?with_transactions={with_transactions}")
+            }
+        }
+    }
+
+    /// Cursor following `item`, or `None` if the item lacks its cursor field.
+    pub(crate) fn next_cursor(&self, item: &Value) -> Option<u64> {
+        let key = match self {
+            Self::Events { .. } | Self::AccountTransactions { .. } => "sequence_number",
+            Self::Transactions => "version",
+            Self::Blocks { .. } => "block_height",
+        };
+        parse_u64(item.get(key)?).map(|cursor| cursor + 1)
+    }
+
+    /// Whether a 404 means the next item does not exist yet rather than a
+    /// node failure.
+    fn pending_on_404(&self) -> bool {
+        matches!(self, Self::Blocks { .. } | Self::AccountTransactions { .. })
+    }
+
+    fn record(&self, item: &Value, metrics: &WatchMetrics) {
+        match self {
+            Self::Events { .. } => record_event(item, metrics),
+            Self::AccountTransactions { .. } | Self::Transactions => {
+                record_transaction(item, metrics)
+            }
+            Self::Blocks { .. } => {
+                if let Some(version) = item.get("last_version").and_then(parse_u64) {
+                    metrics.record_version(version);
+                }
+                let transactions = item.get("transactions").and_then(Value::as_array);
+                for transaction in transactions.into_iter().flatten() {
+                    record_transaction(transaction, metrics);
+                }
+            }
+        }
+    }
+}
+
+fn record_event(event: &Value, metrics: &WatchMetrics) {
+    metrics.record_event(
+        event
+            .get("type")
+            .and_then(Value::as_str)
+            .unwrap_or_default(),
+    );
+    if let Some(version) = event.get("version").and_then(parse_u64) {
+        metrics.record_version(version);
+    }
+}
+
+fn record_transaction(transaction: &Value, metrics: &WatchMetrics) {
+    metrics.record_transactions(1);
+    if let Some(version) = transaction.get("version").and_then(parse_u64) {
+        metrics.record_version(version);
+    }
+    let events = transaction.get("events").and_then(Value::as_array);
+    for event in events.into_iter().flatten() {
+        metrics.record_event(
+            event
+                .get("type")
+                .and_then(Value::as_str)
+                .unwrap_or_default(),
+        );
+    }
+}
+
+/// When a follow loop ends on its own; the default follows until the process
+/// is interrupted and retries node errors.
+#[derive(Debug, Default)]
+pub(crate) struct FollowUntil {
+    /// Stop after emitting the item at this cursor position.
+    pub(crate) through: Option<u64>,
+    /// Stop once caught up after this long.
+    pub(crate) timeout: Option<Duration>,
+    /// Return node errors instead of counting and retrying them.
+    pub(crate) fail_on_error: bool,
+}
+
+/// Why [`follow`] returned.
+#[derive(Debug, PartialEq, Eq)]
+pub(crate) enum FollowEnd {
+    Stopped,
+    /// The [`FollowUntil::through`] item was emitted.
+    Reached,
+    TimedOut,
+}
+
+/// Runs a follow loop until the process is interrupted, serving metrics when
+/// `--metrics-listen` is set.
+pub(crate) fn run_follow(
+    client: &AptosClient,
+    source: FollowSource,
+    start: u64,
+    limit: u64,
+    args: &FollowArgs,
+) -> Result<()> {
+    run_follow_until(
+        client,
+        source,
+        start,
+        limit,
+        args,
+        &FollowUntil::default(),
+        |item| Ok(serde_json::to_string(item)?),
+    )?;
+    Ok(())
+}
+
+/// [`run_follow`] that ends as `until` says and prints each item as
+/// `render` formats it.
+pub(crate) fn run_follow_until(
+    client: &AptosClient,
+    source: FollowSource,
+    start: u64,
+    limit: u64,
+    args: &FollowArgs,
+    until: &FollowUntil,
+    mut render: impl FnMut(&Value) -> Result<String>,
+) -> Result<FollowEnd> {
+    let metrics = Arc::new(WatchMetrics::default());
+    let _server = match &args.metrics_listen {
+        Some(listen) => {
+            let server = MetricsServer::start(listen, Arc::clone(&metrics))?;
+            eprintln!("serving metrics on http://{}/metrics", server.local_addr());
+            Some(server)
+        }
+        None => None,
+    };
+    let stop = AtomicBool::new(false);
+    follow(
+        client,
+        &source,
+        start,
+        limit,
+        args.poll_interval,
+        until,
+        &metrics,
+        &stop,
+        |item| {
+            println!("{}", render(item)?);
+            Ok(())
+        },
+    )
+}
+
+/// Pages through `source` from `start`, passing each new item to `emit`.
+/// Node errors are counted and retried unless `until` says otherwise; the
+/// loop ends when `stop` is set or `until` is met.
+#[allow(clippy::too_many_arguments)]
+pub(crate) fn follow(
+    client: &AptosClient,
+    source: &FollowSource,
+    start: u64,
+    limit: u64,
+    interval: Duration,
+    until: &FollowUntil,
+    metrics: &WatchMetrics,
+    stop: &AtomicBool,
+    mut emit: impl FnMut(&Value) -> Result<()>,
+) -> Result<FollowEnd> {
+    let started = Instant::now();
+    let mut cursor = start;
+    while !stop.load(Ordering::Relaxed) {
+        let items = match client.get_json(&source.path(cursor, limit)) {
+            Ok(Value::Array(items)) => items,
+            Ok(item @ Value::Object(_)) => vec![item],
+            Ok(_) => Vec::new(),
+            // A block not produced yet, or an account not created yet, is not
+            // a node failure.
+            Err(err) if source.pending_on_404() && err.to_string().contains("status 404") => {
+                Vec::new()
+            }
+            Err(err) if until.fail_on_error => return Err(err),
+            Err(err) => {
+                metrics.record_node_error();
+                eprintln!("follow: {err:#}");
+                Vec::new()
+            }
+        };
+
+        for item in &items {
+            let next = source
+                .next_cursor(item)
+                .ok_or_else(|| anyhow!("follow: item is missing its cursor field"))?;
+            // A page may overlap what was already emitted if the node lags.
+            if next <= cursor {
+                continue;
+            }
+            source.record(item, metrics);
+            emit(item)?;
+            cursor = next;
+            if until.through.is_some_and(|through| next > through) {
+                return Ok(FollowEnd::Reached);
+            }
+        }
+
+        // A short page means we are caught up with the ledger.
+        if (items.len() as u64) < limit {
+            let delay = match until.timeout {
+                Some(timeout) => match timeout.checked_sub(started.elapsed()) {
+                    Some(remaining) if !remaining.is_zero() => interval.min(remaining),
+                    _ => return Ok(FollowEnd::TimedOut),
+                },
+                None => interval,
+            };
+            thread::sleep(delay);
+        }
+    }
+    Ok(FollowEnd::Stopped)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::io::{Read, Write};
+    use std::net::{TcpListener, TcpStream};
+    use std::sync::mpsc;
+
+    /// Mock node answering each request with the next scripted body; once the
+    /// script is exhausted it returns empty pages.
+    fn mock_node(pages: Vec<&'static str>) -> String {
+        let listener = TcpListener::bind("127.0.0.1:0").unwrap();
+        let addr = listener.local_addr().unwrap();
+        thread::spawn(move || {
+            let mut pages = pages.into_iter();
+            for stream in listener.incoming() {
+                let Ok(mut stream) = stream else { break };
+                let mut buf = [0u8; 4096];
+                let _ = stream.read(&mut buf);
+                let (status, body) = match pages.next() {
+                    Some("500") => ("500 Internal Server Error", "{}"),
+                    Some(body) => ("200 OK", body),
+                    None => ("200 OK", "[]"),
+                };
+                let response = format!(
+                    "HTTP/1.1 {status}\r\nContent-Type: application/json\r\nContent-Length: {}\r\nConnection: close\r\n\r\n{body}",
+                    body.len()
+                );
+                let _ = stream.write_all(response.as_bytes());
+            }
+        });
+        format!("http://{addr}")
+    }
+
+    fn scrape(addr: std::net::SocketAddr) -> String {
+        let mut stream = TcpStream::connect(addr).unwrap();
+        stream
+            .write_all(b"GET /metrics HTTP/1.1\r\nHost: localhost\r\n\r\n")
+            .unwrap();
+        let mut response = String::new();
+        stream.read_to_string(&mut response).unwrap();
+        response
+    }
+
+    #[test]
+    fn follow_run_exposes_counters() {
+        let node = mock_node(vec![
+            r#"[{"type":"0x1::coin::DepositEvent","sequence_number":"0","version":"10"},
+                {"type":"0x1::coin::WithdrawEvent","sequence_number":"1","version":"11"}]"#,
+            "500",
+            r#"[{"type":"0x1::coin::DepositEvent","sequence_number":"2","version":"15"}]"#,
+        ]);
+        let client = AptosClient::new(&node).unwrap();
+        let metrics = Arc::new(WatchMetrics::default());
+        let server = MetricsServer::start("127.0.0.1:0", Arc::clone(&metrics)).unwrap();
+        let source = FollowSource::Events {
+            address: "0x1".to_owned(),
+            creation_number: "0".to_owned(),
+        };
+
+        let stop = AtomicBool::new(false);
+        let (seen_tx, seen_rx) = mpsc::channel();
+        thread::scope(|scope| {
+            scope.spawn(|| {
+                follow(
+                    &client,
+                    &source,
+                    0,
+                    2,
+                    Duration::from_millis(5),
+                    &FollowUntil::default(),
+                    &metrics,
+                    &stop,
+                    |item| {
+                        seen_tx.send(item["sequence_number"].clone()).unwrap();
+                        Ok(())
+                    },
+                )
+                .unwrap();
+            });
+
+            let received = (0..3).all(|_| seen_rx.recv_timeout(Duration::from_secs(5)).is_ok());
+            let body = if received {
+                scrape(server.local_addr())
+            } else {
+                String::new()
+            };
+            stop.store(true, Ordering::Relaxed);
+
+            assert!(received, "follow loop did not emit every scripted item");
+            assert!(body.contains("application/openmetrics-text"), "{body}");
+            assert!(body.contains("events_seen_total{type=\"0x1::coin::DepositEvent\"} 2\n"));
+            assert!(body.contains("events_seen_total{type=\"0x1::coin::WithdrawEvent\"} 1\n"));
+            assert!(body.contains("last_seen_version 15\n"));
+            assert!(body.contains("node_errors_total 1\n"));
+        });
+        drop(server);
+    }
+
+    fn account_source() -> FollowSource {
+        FollowSource::AccountTransactions {
+            address: "0x1".to_owned(),
+        }
+    }
+
+    fn follow_account(
+        node: &str,
+        start: u64,
+        until: &FollowUntil,
+    ) -> (Result<FollowEnd>, Vec<Value>) {
+        let client = AptosClient::new(node).unwrap();
+        let mut emitted = Vec::new();
+        let end = follow(
+            &client,
+            &account_source(),
+            start,
+            25,
+            Duration::from_millis(1),
+            until,
+            &WatchMetrics::default(),
+            &AtomicBool::new(false),
+            |item| {
+                emitted.push(item["sequence_number"].clone());
+                Ok(())
+            },
+        );
+        (end, emitted)
+    }
+
+    #[test]
+    fn stops_after_the_through_item_skipping_overlap() {
+        let node = mock_node(vec![
+            "[]",
+            r#"[{"sequence_number":"7"}]"#,
+            r#"[{"sequence_number":"7"},{"sequence_number":"8"},{"sequence_number":"9"}]"#,
+        ]);
+        let until = FollowUntil {
+            through: Some(8),
+            ..FollowUntil::default()
+        };
+        let (end, emitted) = follow_account(&node, 7, &until);
+        assert_eq!(end.unwrap(), FollowEnd::Reached);
+        assert_eq!(emitted, [serde_json::json!("7"), serde_json::json!("8")]);
+    }
+
+    #[test]
+    fn times_out_without_new_items() {
+        let until = FollowUntil {
+            through: Some(3),
+            timeout: Some(Duration::from_millis(20)),
+            ..FollowUntil::default()
+        };
+        let (end, emitted) = follow_account(&mock_node(Vec::new()), 3, &until);
+        assert_eq!(end.unwrap(), FollowEnd::TimedOut);
+        assert!(emitted.is_empty());
+    }
+
+    #[test]
+    fn fail_on_error_returns_node_errors() {
+        let until = FollowUntil {
+            fail_on_error: true,
+            ..FollowUntil::default()
+        };
+        let (end, emitted) = follow_account(&mock_node(vec!["500"]), 0, &until);
+        assert!(end.is_err());
+        assert!(emitted.is_empty());
+    }
+
+    #[test]
+    fn block_cursor_advances_by_height() {
+        let source = FollowSource::Blocks {
+            with_transactions: true,
+        };
+        let block = serde_json::json!({
+            "block_height": "41",
+            "last_version": "900",
+            "transactions": [{ "version": "899", "events": [{ "type": "0x1::a::B" }] }],
+        });
+        assert_eq!(source.next_cursor(&block), Some(42));
+        assert_eq!(
+            source.path(42, 25),
+            "/blocks/by_height/42?with_transactions=true"
+        );
+
+        let metrics = WatchMetrics::default();
+        source.record(&block, &metrics);
+        let text = metrics.render();
+        assert!(text.contains("transactions_seen_total 1\n"));
+        assert!(text.contains("last_seen_version 900\n"));
+        assert!(text.contains("events_seen_total{type=\"0x1::a::B\"} 1\n"));
+    }
+}
@@ -0,0 +1,491 @@
+use anyhow::{anyhow, Result};
+use aptly_aptos::AptosClient;
+use clap::Args;
+use serde::Serialize;
+use serde_json::{json, Value};
+use std::collections::HashMap;
+
+use crate::commands::common::{normalize_address, parse_u64};
+use crate::commands::indexer::Indexer;
+use crate::commands::schema::{
+    array_schema, boolean_schema, integer_schema, nullable, object_schema, string_schema,
+    OutputSchema,
+};
+
+/// The node caps `/transactions` pages at 100.
+const PAGE_LIMIT: u64 = 100;
+/// Calls the indexer lists per page.
+const INDEXER_PAGE_LIMIT: u64 = 100;
+
+#[derive(Args)]
+pub(crate) struct GasProfileArgs {
+    /// Address whose modules are profiled (`0x...`).
+    #[arg(value_name = "ADDRESS")]
+    pub(crate) address: String,
+    /// Number of most recent calls to profile, or of ledger transactions to
+    /// scan when no indexer is known for the node.
+    #[arg(long, default_value_t = 1000)]
+    pub(crate) last: u64,
+    /// Render a table instead of JSON.
+    #[arg(long, default_value_t = false)]
+    pub(crate) pretty: bool,
+    /// Indexer GraphQL endpoint. Defaults to the indexer of the network
+    /// selected with `--network` (or whose default `--rpc-url` is in use).
+    #[arg(long, value_name = "URL")]
+    pub(crate) indexer_url: Option<String>,
+}
+
+#[derive(Debug, Clone, Serialize)]
+struct GasProfile {
+    address: String,
+    /// `indexer` or `ledger`.
+    source: &'static str,
+    /// Whether calls may be missing: the ledger scan only sees calls among
+    /// the last `--last` transactions.
+    partial: bool,
+    from_version: Option<u64>,
+    to_version: Option<u64>,
+    scanned_transactions: u64,
+    matched_transactions: u64,
+    functions: Vec<FunctionGas>,
+}
+
+#[derive(Debug, Clone, Serialize)]
+struct FunctionGas {
+    function: String,
+    calls: u64,
+    failures: u64,
+    failure_rate_bps: u64,
+    total_gas_used: u64,
+    avg_gas_used: u64,
+    avg_gas_unit_price: u64,
+    total_fee_octas: String,
+}
+
+impl OutputSchema for FunctionGas {
+    fn output_schema() -> Value {
+        object_schema(
+            "Gas usage of one entry function",
+            &[
+                ("function", string_schema("Entry function id")),
+                ("calls", integer_schema("Transactions calling the function")),
+                ("failures", integer_schema("Calls that did not succeed")),
+                (
+                    "failure_rate_bps",
+                    integer_schema("Failures per 10,000 calls (basis points, rounded down)"),
+                ),
+                ("total_gas_used", integer_schema("Sum of gas units used")),
+                (
+                    "avg_gas_used",
+                    integer_schema("Gas units per call, rounded down"),
+                ),
+                (
+                    "avg_gas_unit_price",
+                    integer_schema("Octas per gas unit paid per call, rounded down"),
+                ),
+                (
+                    "total_fee_octas",
+                    string_schema("Sum of gas_used * gas_unit_price (u128 as string)"),
+                ),
+            ],
+        )
+    }
+}
+
+impl OutputSchema for GasProfile {
+    fn output_schema() -> Value {
+        object_schema(
+            "Gas used by entry functions of an address over recent transactions",
+            &[
+                ("address", string_schema("Profiled address (64-hex)")),
+                (
+                    "source",
+                    json!({
+                        "description": "`indexer` for the address's newest calls, or `ledger` for a scan of the newest ledger transactions when no indexer is known",
+                        "type": "string",
+                        "enum": ["indexer", "ledger"],
+                    }),
+                ),
+                (
+                    "partial",
+                    boolean_schema("Whether calls may be missing (always true for `ledger`)"),
+                ),
+                (
+                    "from_version",
+                    nullable(integer_schema(
+                        "First ledger version read; null when nothing was read",
+                    )),
+                ),
+                (
+                    "to_version",
+                    nullable(integer_schema(
+                        "Last ledger version read; null when nothing was read",
+                    )),
+                ),
+                (
+                    "scanned_transactions",
+                    integer_schema("Transactions read from the node"),
+                ),
+                (
+                    "matched_transactions",
+                    integer_schema("User transactions calling the address's modules"),
+                ),
+                (
+                    "functions",
+                    array_schema(
+                        "Per-function totals sorted by total gas used, descending",
+                        FunctionGas::output_schema(),
+                    ),
+                ),
+            ],
+        )
+    }
+}
+
+pub(crate) fn gas_profile_output_schema() -> Value {
+    GasProfile::output_schema()
+}
+
+/// Running totals for one function; integer arithmetic only.
+#[derive(Debug, Default)]
+struct Totals {
+    calls: u64,
+    failures: u64,
+    gas_used: u64,
+    gas_unit_price: u128,
+    fee: u128,
+}
+
+/// Folds transactions into per-function totals one page at a time, so scans
+/// never hold more than a page in memory.
+struct Profiler {
+    address: String,
+    scanned: u64,
+    matched: u64,
+    functions: HashMap<String, Totals>,
+}
+
+impl Profiler {
+    fn new(address: &str) -> Self {
+        Self {
+            address: normalize_address(address),
+            scanned: 0,
+            matched: 0,
+            functions: HashMap::new(),
+        }
+    }
+
+    fn add(&mut self, tx: &Value) {
+        self.scanned += 1;
+        if tx.get("type").and_then(Value::as_str) != Some("user_transaction") {
+            return;
+        }
+        let Some(function) = tx
+            .get("payload")
+            .filter(|payload| {
+                payload.get("type").and_then(Value::as_str) == Some("entry_function_payload")
+            })
+            .and_then(|payload| payload.get("function"))
+            .and_then(Value::as_str)
+        else {
+            return;
+        };
+        let Some((address, _)) = function.split_once("::") else {
+            return;
+        };
+        if normalize_address(address) != self.address {
+            return;
+        }
+
+        let field = |key: &str| tx.get(key).and_then(parse_u64).unwrap_or(0);
+        let (gas_used, gas_unit_price) = (field("gas_used"), field("gas_unit_price"));
+        let success = tx.get("success").and_then(Value::as_bool) == Some(true);
+
+        self.matched += 1;
+        let totals = self.functions.entry(function.to_owned()).or_default();
+        totals.calls += 1;
+        totals.failures += u64::from(!success);
+        totals.gas_used = totals.gas_used.saturating_add(gas_used);
+        totals.gas_unit_price += u128::from(gas_unit_price);
+        totals.fee += u128::from(gas_used) * u128::from(gas_unit_price);
+    }
+
+    fn finish(
+        self,
+        source: &'static str,
+        from_version: Option<u64>,
+        to_version: Option<u64>,
+    ) -> GasProfile {
+        let mut functions: Vec<FunctionGas> = self
+            .functions
+            .into_iter()
+            .map(|(function, totals)| FunctionGas {
+                function,
+                calls: totals.calls,
+                failures: totals.failures,
+                failure_rate_bps: totals.failures * 10_000 / totals.calls,
+                total_gas_used: totals.gas_used,
+                avg_gas_used: totals.gas_used / totals.calls,
+                avg_gas_unit_price: (totals.gas_unit_price / u128::from(totals.calls)) as u64,
+                total_fee_octas: totals.fee.to_string(),
+            })
+            .collect();
+        functions.sort_by(|a, b| {
+            b.total_gas_used
+                .cmp(&a.total_gas_used)
+                .then_with(|| a.function.cmp(&b.function))
+        });
+        GasProfile {
+            address: self.address,
+            source,
+            partial: source == "ledger",
+            from_version,
+            to_version,
+            scanned_transactions: self.scanned,
+            matched_transactions: self.matched,
+            functions,
+        }
+    }
+}
+
+/// Profiles the newest `--last` calls to the address's entry functions,
+/// found through the indexer. Without a known indexer it falls back to
+/// scanning the last `--last` ledger transactions, labelled partial.
+pub(crate) fn run_gas_profile(client: &AptosClient, args: &GasProfileArgs) -> Result<()> {
+    if args.last == 0 {
+        return Err(anyhow!("--last must be at least 1"));
+    }
+    let address = normalize_address(&args.address);
+    let profile = match Indexer::for_node(client, args.indexer_url.as_deref()) {
+        Ok(indexer) => profile_calls(
+            &address,
+            args.last,
+            |offset, limit| indexer.entry_function_versions(&address, offset, limit),
+            |version| client.get_json(&format!("/transactions/by_version/{version}")),
+        )?,
+        Err(err) => {
+            eprintln!(
+                "note: {err:#}; scanning the last {} ledger transactions instead (partial)",
+                args.last
+            );
+            profile_ledger(client, &address, args.last)?
+        }
+    };
+
+    if args.pretty {
+        print_pretty_profile(&profile);
+        return Ok(());
+    }
+    crate::print_serialized(&profile)
+}
+
+/// Reads up to `last` call versions, newest first, through
+/// `next_page(offset, limit)` and folds each transaction from `fetch`.
+fn profile_calls(
+    address: &str,
+    last: u64,
+    mut next_page: impl FnMut(u64, u64) -> Result<Vec<u64>>,
+    mut fetch: impl FnMut(u64) -> Result<Value>,
+) -> Result<GasProfile> {
+    let mut versions = Vec::new();
+    while (versions.len() as u64) < last {
+        let offset = versions.len() as u64;
+        let page = next_page(offset, INDEXER_PAGE_LIMIT.min(last - offset))?;
+        if page.is_empty() {
+            break;
+        }
+        versions.extend(page);
+    }
+    versions.truncate(last as usize);
+
+    let mut profiler = Profiler::new(address);
+    for &version in &versions {
+        profiler.add(&fetch(version)?);
+    }
+    Ok(profiler.finish(
+        "indexer",
+        versions.iter().min().copied(),
+        versions.iter().max().copied(),
+    ))
+}
+
+/// Scans the last `last` ledger transactions; the node API cannot filter by
+/// called module, so every transaction in the window is read.
+fn profile_ledger(client: &AptosClient, address: &str, last: u64) -> Result<GasProfile> {
+    let ledger = client.get_json("/")?;
+    let head = ledger
+        .get("ledger_version")
+        .and_then(parse_u64)
+        .ok_or_else(|| anyhow!("failed to parse ledger version"))?;
+    let oldest = ledger
+        .get("oldest_ledger_version")
+        .and_then(parse_u64)
+        .unwrap_or(0);
+    let from_version = head.saturating_sub(last - 1).max(oldest);
+
+    let mut profiler = Profiler::new(address);
+    let mut cursor = from_version;
+    while cursor <= head {
+        let limit = PAGE_LIMIT.min(head - cursor + 1);
+        let page = client.get_json(&format!("/transactions?start={cursor}&limit={limit}"))?;
+        let txs = page
+            .as_array()
+            .ok_or_else(|| anyhow!("unexpected transactions response format"))?;
+        if txs.is_empty() {
+            break;
+        }
+        for tx in txs {
+            profiler.add(tx);
+        }
+        cursor += txs.len() as u64;
+    }
+
+    let to_version = cursor.checked_sub(1).filter(|&to| to >= from_version);
+    Ok(profiler.finish("ledger", to_version.map(|_| from_version), to_version))
+}
+
+fn print_pretty_profile(profile: &GasProfile) {
+    let versions = match (profile.from_version, profile.to_version) {
+        (Some(from), Some(to)) => format!("versions {from}..={to}"),
+        _ => "no versions".to_owned(),
+    };
+    if profile.partial {
+        println!(
+            "{} of the last {} ledger transactions ({versions}) called {} (partial: no indexer)",
+            profile.matched_transactions, profile.scanned_transactions, profile.address
+        );
+    } else {
+        println!(
+            "{} newest calls to {} ({versions}, from the indexer)",
+            profile.matched_transactions, profile.address
+        );
+    }
+    if profile.functions.is_empty() {
+        return;
+    }
+    let width = profile
+        .functions
+        .iter()
+        .map(|function| function.function.len())
+        .max()
+        .unwrap_or(0)
+        .max(8);
+    println!(
+        "{:<width$}  {:>8}  {:>7}  {:>14}  {:>10}  {:>9}",
+        "FUNCTION", "CALLS", "FAIL%", "TOTAL GAS", "AVG GAS", "AVG PRICE"
+    );
+    for function in &profile.functions {
+        println!(
+            "{:<width$}  {:>8}  {:>7}  {:>14}  {:>10}  {:>9}",
+            function.function,
+            function.calls,
+            format!(
+                "{}.{:02}",
+                function.failure_rate_bps / 100,
+                function.failure_rate_bps % 100
+            ),
+            function.total_gas_used,
+            function.avg_gas_used,
+            function.avg_gas_unit_price
+        );
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::commands::schema::validate;
+
+    fn user_tx(function: &str, gas_used: u64, price: u64, success: bool) -> Value {
+        json!({
+            "type": "user_transaction",
+            "success": success,
+            "gas_used": gas_used.to_string(),
+            "gas_unit_price": price.to_string(),
+            "payload": {"type": "entry_function_payload", "function": function},
+        })
+    }
+
+    #[test]
+    fn aggregates_per_function_with_integer_math() {
+        let pool = format!("{}::pool::swap", normalize_address("0xabc"));
+        let mut profiler = Profiler::new("0xabc");
+        for tx in [
+            user_tx(&pool, 100, 100, true),
+            user_tx(&pool, 201, 150, false),
+            user_tx(&pool, 300, 100, true),
+            user_tx("0xabc::vault::deposit", 1000, 100, true),
+            user_tx("0x1::coin::transfer", 5000, 100, true),
+            json!({"type": "block_metadata_transaction"}),
+        ] {
+            profiler.add(&tx);
+        }
+        let profile = profiler.finish("ledger", Some(10), Some(15));
+
+        assert_eq!(profile.scanned_transactions, 6);
+        assert_eq!(profile.matched_transactions, 4);
+        let names: Vec<&str> = profile
+            .functions
+            .iter()
+            .map(|function| function.function.as_str())
+            .collect();
+        assert_eq!(names, ["0xabc::vault::deposit", pool.as_str()]);
+
+        let swap = &profile.functions[1];
+        assert_eq!((swap.calls, swap.failures), (3, 1));
+        assert_eq!(swap.failure_rate_bps, 3333);
+        assert_eq!(swap.total_gas_used, 601);
+        assert_eq!(swap.avg_gas_used, 200);
+        assert_eq!(swap.avg_gas_unit_price, 116);
+        assert_eq!(swap.total_fee_octas, "70150");
+
+        validate(
+            &gas_profile_output_schema(),
+            &serde_json::to_value(&profile).unwrap(),
+        )
+        .unwrap();
+    }
+
+    #[test]
+    fn indexer_profile_reads_only_the_newest_calls() {
+        let pool = format!("{}::pool::swap", normalize_address("0xabc"));
+        let mut pages = vec![vec![105, 104, 103], vec![90, 80]];
+        let mut requests = Vec::new();
+        let mut fetched = Vec::new();
+        let profile = profile_calls(
+            &normalize_address("0xabc"),
+            4,
+            |offset, limit| {
+                requests.push((offset, limit));
+                Ok(if pages.is_empty() {
+                    Vec::new()
+                } else {
+                    pages.remove(0)
+                })
+            },
+            |version| {
+                fetched.push(version);
+                Ok(user_tx(&pool, 100, 100, version != 90))
+            },
+        )
+        .unwrap();
+
+        assert_eq!(requests, [(0, 4), (3, 1)]);
+        assert_eq!(fetched, [105, 104, 103, 90]);
+        assert_eq!((profile.source, profile.partial), ("indexer", false));
+        assert_eq!(
+            (profile.from_version, profile.to_version),
+            (Some(90), Some(105))
+        );
+        assert_eq!(profile.matched_transactions, 4);
+        assert_eq!(profile.functions[0].failures, 1);
+        validate(
+            &gas_profile_output_schema(),
+            &serde_json::to_value(&profile).unwrap(),
+        )
+        .unwrap();
+
+        let empty = profile_calls("0xabc", 10, |_, _| Ok(Vec::new()), |_| unreachable!()).unwrap();
+        assert_eq!((empty.from_version, empty.to_version), (None, None));
+    }
+}
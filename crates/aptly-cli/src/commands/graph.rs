@@ -0,0 +1,949 @@
+use anyhow::Result;
+use aptly_aptos::AptosClient;
+use clap::Args;
+use num_bigint::BigInt;
+use serde::Serialize;
+use serde_json::{json, Value};
+use std::collections::{BTreeMap, BTreeSet, HashMap, VecDeque};
+use std::path::{Path, PathBuf};
+use std::str::FromStr;
+use std::thread;
+
+use crate::commands::account::{format_amount, AmountStyle};
+use crate::commands::assets;
+use crate::commands::common::{normalize_address, shorten_addr, CanonicalAddresses};
+use crate::commands::labels::{AddressLabels, LabelAddresses};
+use crate::commands::price::{PriceBook, PriceOracle};
+use crate::commands::schema::{
+    array_schema, integer_schema, nullable, object_schema, string_schema, with_optional_properties,
+    OutputSchema,
+};
+use crate::commands::tx::{
+    block_transactions, get_transaction, transaction_balance_changes, BalanceChange, Transaction,
+};
+use crate::sqlite_export::{self, TransferRow, SOURCE_GRAPH, SOURCE_GRAPH_SIMPLIFIED};
+
+/// Endpoint of a deposit with no matching withdraw.
+pub(crate) const MINT: &str = "mint";
+/// Endpoint of a withdraw with no matching deposit.
+pub(crate) const BURN: &str = "burn";
+
+#[derive(Args)]
+pub(crate) struct TxGraphArgs {
+    /// Transaction version (u64) or hash (0x...).
+    /// If omitted, reads full transaction JSON from stdin.
+    #[arg(value_name = "VERSION_OR_HASH")]
+    pub(crate) version_or_hash: Option<String>,
+    /// Build the graph of every transaction in the block at this height
+    /// instead, printing those with transfers in order.
+    #[arg(long, value_name = "HEIGHT", conflicts_with = "version_or_hash")]
+    pub(crate) block: Option<u64>,
+    /// Print one line per transfer with symbols and decimal amounts.
+    #[arg(long, default_value_t = false, conflicts_with = "dot")]
+    pub(crate) pretty: bool,
+    /// Print a Graphviz DOT digraph.
+    #[arg(long, default_value_t = false)]
+    pub(crate) dot: bool,
+    /// Annotate transfers with `usd_value` at the oracle's current price.
+    #[arg(long, value_enum, value_name = "ORACLE")]
+    pub(crate) price_with: Option<PriceOracle>,
+    /// With `--pretty`, show at most N decimal places, truncating the rest.
+    #[arg(long, value_name = "N")]
+    pub(crate) precision: Option<u8>,
+    /// Collapse owners that forward all they receive of an asset, such as
+    /// aggregator routers, into single edges listing them in `via`.
+    #[arg(long, default_value_t = false)]
+    pub(crate) simplify: bool,
+    /// Add `from_label`/`to_label` for known addresses (see `aptly labels`);
+    /// `--pretty` and `--dot` show them next to the address.
+    #[arg(long, default_value_t = false)]
+    pub(crate) labels: bool,
+    /// Also append the edges to the `transfers` table of this SQLite
+    /// database, keyed by their position in the graph; `source` tells
+    /// simplified edges apart.
+    #[arg(long, value_name = "FILE")]
+    pub(crate) export_sqlite: Option<PathBuf>,
+}
+
+#[derive(Debug, Clone, Serialize)]
+struct TxGraph {
+    version: u64,
+    transfers: Vec<GraphTransfer>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pricing: Option<Pricing>,
+}
+
+/// Funds moved from one owner to another, summed per asset.
+#[derive(Debug, Clone, Serialize)]
+struct GraphTransfer {
+    from: String,
+    to: String,
+    asset: String,
+    symbol: String,
+    amount: String,
+    /// Owners the funds passed through, in order (`--simplify`).
+    #[serde(skip_serializing_if = "Vec::is_empty")]
+    via: Vec<String>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    from_label: Option<String>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    to_label: Option<String>,
+    /// `Some(None)` serializes as `null`: prices were requested but the asset
+    /// has no feed.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    usd_value: Option<Option<String>>,
+}
+
+#[derive(Debug, Clone, Serialize)]
+struct Pricing {
+    oracle: &'static str,
+    price_basis: &'static str,
+}
+
+/// Raw transfer between two owners before metadata is resolved.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub(crate) struct Leg {
+    pub(crate) from: String,
+    pub(crate) to: String,
+    pub(crate) asset: String,
+    pub(crate) amount: BigInt,
+    pub(crate) via: Vec<String>,
+}
+
+impl OutputSchema for GraphTransfer {
+    fn output_schema() -> Value {
+        let schema = object_schema(
+            "Funds moved between two owners, summed per asset",
+            &[
+                (
+                    "from",
+                    string_schema("Owner of the withdrawing store, or `mint`"),
+                ),
+                (
+                    "to",
+                    string_schema("Owner of the depositing store, or `burn`"),
+                ),
+                ("asset", string_schema("Fungible asset metadata address")),
+                (
+                    "symbol",
+                    string_schema("Asset symbol, or shortened address if unknown"),
+                ),
+                (
+                    "amount",
+                    string_schema("Decimal amount scaled by the asset's decimals"),
+                ),
+            ],
+        );
+        with_optional_properties(
+            schema,
+            &[
+                (
+                    "via",
+                    array_schema(
+                        "Owners that forwarded the funds, in order (`--simplify`)",
+                        string_schema("Owner address"),
+                    ),
+                ),
+                (
+                    "from_label",
+                    string_schema("Known label of `from` (`--labels`)"),
+                ),
+                (
+                    "to_label",
+                    string_schema("Known label of `to` (`--labels`)"),
+                ),
+                (
+                    "usd_value",
+                    nullable(string_schema(
+                        "USD value at the current oracle price (`--price-with`); null when the asset has no feed",
+                    )),
+                ),
+            ],
+        )
+    }
+}
+
+impl OutputSchema for TxGraph {
+    fn output_schema() -> Value {
+        let schema = object_schema(
+            "Transfer graph of a transaction; `--pretty` and `--dot` print text instead",
+            &[
+                (
+                    "version",
+                    integer_schema("Ledger version of the transaction"),
+                ),
+                (
+                    "transfers",
+                    array_schema(
+                        "Graph edges in first-seen order",
+                        GraphTransfer::output_schema(),
+                    ),
+                ),
+            ],
+        );
+        with_optional_properties(
+            schema,
+            &[(
+                "pricing",
+                object_schema(
+                    "Price source of `usd_value` (`--price-with`)",
+                    &[
+                        ("oracle", string_schema("Oracle queried")),
+                        (
+                            "price_basis",
+                            json!({
+                                "description": "Prices are read at query time, not at the transaction's version",
+                                "type": "string",
+                                "enum": ["current"],
+                            }),
+                        ),
+                    ],
+                ),
+            )],
+        )
+    }
+}
+
+pub(crate) fn graph_output_schema() -> Value {
+    json!({
+        "oneOf": [
+            TxGraph::output_schema(),
+            array_schema(
+                "Graphs of the block's transactions with transfers, in order (`--block`)",
+                TxGraph::output_schema(),
+            ),
+        ],
+    })
+}
+
+impl LabelAddresses for GraphTransfer {
+    fn apply_labels(&mut self, labels: &AddressLabels) {
+        self.from_label = labels.get(&self.from).map(str::to_owned);
+        self.to_label = labels.get(&self.to).map(str::to_owned);
+    }
+}
+
+impl LabelAddresses for TxGraph {
+    fn apply_labels(&mut self, labels: &AddressLabels) {
+        self.transfers.apply_labels(labels);
+    }
+}
+
+/// `mint` and `burn` endpoints are not addresses and stay as they are.
+impl CanonicalAddresses for GraphTransfer {
+    fn canonicalize_addresses(&mut self) {
+        self.from = normalize_address(&self.from);
+        self.to = normalize_address(&self.to);
+        self.asset = normalize_address(&self.asset);
+        for owner in &mut self.via {
+            *owner = normalize_address(owner);
+        }
+    }
+}
+
+impl CanonicalAddresses for TxGraph {
+    fn canonicalize_addresses(&mut self) {
+        self.transfers.canonicalize_addresses();
+    }
+}
+
+pub(crate) fn run_tx_graph(
+    client: &AptosClient,
+    args: &TxGraphArgs,
+    canonical_addresses: bool,
+) -> Result<()> {
+    let mut prices = match args.price_with {
+        Some(oracle) => Some(PriceBook::load(client, oracle)?),
+        None => None,
+    };
+    let mut graphs = match args.block {
+        Some(height) => {
+            let mut graphs = Vec::new();
+            for tx in block_transactions(client, height)? {
+                let graph = transaction_graph(client, &tx, args.simplify, prices.as_mut())?;
+                if !graph.transfers.is_empty() {
+                    graphs.push(graph);
+                }
+            }
+            graphs
+        }
+        None => {
+            let tx = get_transaction(client, args.version_or_hash.as_deref())?;
+            let graph = transaction_graph(client, &tx, args.simplify, prices.as_mut())?;
+            tx.note_if_empty(graph.transfers.is_empty(), "transfers");
+            vec![graph]
+        }
+    };
+
+    if canonical_addresses {
+        graphs.canonicalize_addresses();
+    }
+    if let Some(path) = &args.export_sqlite {
+        export_graphs(client, path, &graphs, args.simplify)?;
+    }
+    if args.labels {
+        graphs.apply_labels(&AddressLabels::load()?);
+    }
+    if args.dot {
+        for graph in &graphs {
+            print!("{}", render_dot(graph));
+        }
+        return Ok(());
+    }
+    if args.pretty {
+        let style = AmountStyle::pretty(args.precision);
+        for (index, graph) in graphs.iter().enumerate() {
+            if args.block.is_some() {
+                if index > 0 {
+                    println!();
+                }
+                println!("version {}", graph.version);
+            }
+            print!("{}", render_pretty(graph, style));
+        }
+        return Ok(());
+    }
+    match args.block {
+        Some(_) => crate::print_serialized(&graphs),
+        None => crate::print_serialized(&graphs[0]),
+    }
+}
+
+/// Owner-to-owner transfers of `tx`, priced with `prices` when given.
+fn transaction_graph(
+    client: &AptosClient,
+    tx: &Transaction,
+    simplify_hops: bool,
+    mut prices: Option<&mut PriceBook>,
+) -> Result<TxGraph> {
+    let changes = transaction_balance_changes(client, tx);
+    // Warm asset metadata while the withdraws and deposits are paired.
+    let assets: BTreeSet<&str> = changes
+        .iter()
+        .filter(|change| change.event_type != "gas_fee")
+        .map(|change| change.asset.as_str())
+        .collect();
+    let legs = thread::scope(|scope| {
+        scope.spawn(|| assets::resolver().warm(client, &assets));
+        pair_transfers(&changes)
+    });
+    let legs = if simplify_hops { simplify(legs) } else { legs };
+    let mut transfers = Vec::new();
+    for leg in legs {
+        let metadata = assets::resolver().lookup(client, &leg.asset);
+        let usd_value = match prices.as_deref_mut() {
+            Some(prices) => Some(
+                prices
+                    .price(&leg.asset)?
+                    .map(|price| price.value_of(&leg.amount, metadata.decimals)),
+            ),
+            None => None,
+        };
+        transfers.push(GraphTransfer {
+            from: leg.from,
+            to: leg.to,
+            asset: leg.asset,
+            symbol: metadata.symbol,
+            amount: format_amount(&leg.amount.to_string(), metadata.decimals),
+            via: leg.via,
+            from_label: None,
+            to_label: None,
+            usd_value,
+        });
+    }
+    Ok(TxGraph {
+        version: tx.version(),
+        transfers,
+        pricing: prices.map(|prices| Pricing {
+            oracle: prices.oracle().name(),
+            price_basis: "current",
+        }),
+    })
+}
+
+fn export_graphs(
+    client: &AptosClient,
+    path: &Path,
+    graphs: &[TxGraph],
+    simplified: bool,
+) -> Result<()> {
+    let source = if simplified {
+        SOURCE_GRAPH_SIMPLIFIED
+    } else {
+        SOURCE_GRAPH
+    };
+    let mut conn = sqlite_export::open(path)?;
+    let rows: Vec<TransferRow> = graphs
+        .iter()
+        .flat_map(|graph| {
+            graph
+                .transfers
+                .iter()
+                .enumerate()
+                .map(|(index, transfer)| TransferRow {
+                    source,
+                    version: graph.version,
+                    event_index: index as u64,
+                    from: &transfer.from,
+                    to: &transfer.to,
+                    amount: &transfer.amount,
+                    asset: &transfer.asset,
+                })
+        })
+        .collect();
+    let inserted = sqlite_export::insert_transfers(&mut conn, &rows)?;
+    let ids: BTreeSet<&str> = graphs
+        .iter()
+        .flat_map(|graph| graph.transfers.iter())
+        .map(|transfer| transfer.asset.as_str())
+        .collect();
+    sqlite_export::export_assets(&mut conn, client, &ids)?;
+    eprintln!(
+        "exported {inserted} new transfer(s) to {} ({} already present)",
+        path.display(),
+        rows.len() - inserted
+    );
+    Ok(())
+}
+
+/// Matches each deposit against earlier withdraws of the same asset, first in
+/// first out, then sums legs per `(from, to, asset)`. Gas fees and transfers
+/// an owner makes to itself are left out.
+pub(crate) fn pair_transfers(changes: &[BalanceChange]) -> Vec<Leg> {
+    let mut pending: HashMap<&str, VecDeque<(&str, BigInt)>> = HashMap::new();
+    let mut legs = Vec::new();
+    for change in changes {
+        let amount = BigInt::from_str(&change.amount).unwrap_or_default();
+        let queue = pending.entry(change.asset.as_str()).or_default();
+        match change.event_type.as_str() {
+            "withdraw" => queue.push_back((change.account.as_str(), amount)),
+            "deposit" => {
+                let mut remaining = amount;
+                while remaining > BigInt::default() {
+                    let Some((from, available)) = queue.front_mut() else {
+                        break;
+                    };
+                    let moved = if *available > remaining {
+                        remaining.clone()
+                    } else {
+                        available.clone()
+                    };
+                    legs.push(leg(from, &change.account, &change.asset, moved.clone()));
+                    *available -= moved.clone();
+                    remaining -= moved;
+                    if *available == BigInt::default() {
+                        queue.pop_front();
+                    }
+                }
+                if remaining > BigInt::default() {
+                    legs.push(leg(MINT, &change.account, &change.asset, remaining));
+                }
+            }
+            _ => {}
+        }
+    }
+    let mut unmatched: Vec<_> = pending.into_iter().collect();
+    unmatched.sort_by(|a, b| a.0.cmp(b.0));
+    for (asset, queue) in unmatched {
+        for (from, amount) in queue {
+            legs.push(leg(from, BURN, asset, amount));
+        }
+    }
+
+    merge_legs(legs)
+}
+
+/// Sums legs per `(from, to, asset, via)` in first-seen order, dropping
+/// transfers an owner makes to itself.
+fn merge_legs(legs: Vec<Leg>) -> Vec<Leg> {
+    let mut merged: Vec<Leg> = Vec::new();
+    let mut index: BTreeMap<(String, String, String, Vec<String>), usize> = BTreeMap::new();
+    for leg in legs {
+        if leg.from == leg.to {
+            continue;
+        }
+        let key = (
+            leg.from.clone(),
+            leg.to.clone(),
+            leg.asset.clone(),
+            leg.via.clone(),
+        );
+        match index.get(&key) {
+            Some(&position) => merged[position].amount += leg.amount,
+            None => {
+                index.insert(key, merged.len());
+                merged.push(leg);
+            }
+        }
+    }
+    merged
+}
+
+/// Collapses owners that pass on their entire inflow of an asset: each path
+/// through such an owner becomes one edge from the original source to the
+/// final destination, with the owner recorded in `via`. Owners that keep any
+/// part of the asset stay in the graph, as do `mint` and `burn`.
+fn simplify(mut legs: Vec<Leg>) -> Vec<Leg> {
+    while let Some((hop, asset)) = pass_through(&legs) {
+        let mut incoming = VecDeque::new();
+        let mut outgoing = VecDeque::new();
+        let mut position = None;
+        let mut kept = Vec::with_capacity(legs.len());
+        for leg in legs {
+            if leg.asset == asset && leg.to == hop {
+                position.get_or_insert(kept.len());
+                incoming.push_back(leg);
+            } else if leg.asset == asset && leg.from == hop {
+                position.get_or_insert(kept.len());
+                outgoing.push_back(leg);
+            } else {
+                kept.push(leg);
+            }
+        }
+
+        // Inflow equals outflow, so pairing first in, first out uses up
+        // both sides exactly.
+        let mut routed = Vec::new();
+        while let (Some(inbound), Some(outbound)) = (incoming.front_mut(), outgoing.front_mut()) {
+            let moved = inbound.amount.clone().min(outbound.amount.clone());
+            let mut via = inbound.via.clone();
+            via.push(hop.clone());
+            via.extend(outbound.via.iter().cloned());
+            routed.push(Leg {
+                from: inbound.from.clone(),
+                to: outbound.to.clone(),
+                asset: asset.clone(),
+                amount: moved.clone(),
+                via,
+            });
+            inbound.amount -= &moved;
+            outbound.amount -= &moved;
+            if inbound.amount == BigInt::default() {
+                incoming.pop_front();
+            }
+            if outbound.amount == BigInt::default() {
+                outgoing.pop_front();
+            }
+        }
+        let at = position.unwrap_or(kept.len());
+        kept.splice(at..at, routed);
+        legs = merge_legs(kept);
+    }
+    legs
+}
+
+/// The first owner, in first-seen order, whose outflow of an asset equals
+/// its inflow.
+fn pass_through(legs: &[Leg]) -> Option<(String, String)> {
+    let mut order: Vec<(&str, &str)> = Vec::new();
+    let mut flows: HashMap<(&str, &str), (BigInt, BigInt)> = HashMap::new();
+    for leg in legs {
+        for (owner, inbound) in [(leg.to.as_str(), true), (leg.from.as_str(), false)] {
+            let key = (owner, leg.asset.as_str());
+            let flow = flows.entry(key).or_insert_with(|| {
+                order.push(key);
+                (BigInt::default(), BigInt::default())
+            });
+            if inbound {
+                flow.0 += &leg.amount;
+            } else {
+                flow.1 += &leg.amount;
+            }
+        }
+    }
+    order
+        .into_iter()
+        .find(|key| {
+            let (inflow, outflow) = &flows[key];
+            key.0 != MINT && key.0 != BURN && *inflow > BigInt::default() && inflow == outflow
+        })
+        .map(|(owner, asset)| (owner.to_owned(), asset.to_owned()))
+}
+
+fn leg(from: &str, to: &str, asset: &str, amount: BigInt) -> Leg {
+    Leg {
+        from: from.to_owned(),
+        to: to.to_owned(),
+        asset: asset.to_owned(),
+        amount,
+        via: Vec::new(),
+    }
+}
+
+/// One line per transfer, sorted by sender, then asset, then recipient, so
+/// the text is stable however the transaction ordered its events.
+fn render_pretty(graph: &TxGraph, style: AmountStyle) -> String {
+    let mut sorted: Vec<&GraphTransfer> = graph.transfers.iter().collect();
+    sorted.sort_by(|a, b| {
+        (&a.from, &a.symbol, &a.asset, &a.to).cmp(&(&b.from, &b.symbol, &b.asset, &b.to))
+    });
+    let max_from_len = sorted
+        .iter()
+        .map(|t| endpoint(&t.from, t.from_label.as_deref()).chars().count())
+        .max()
+        .unwrap_or(0);
+    let max_to_len = sorted
+        .iter()
+        .map(|t| endpoint(&t.to, t.to_label.as_deref()).chars().count())
+        .max()
+        .unwrap_or(0);
+    let amounts: Vec<String> = sorted.iter().map(|t| style.apply(&t.amount)).collect();
+    let max_amount_len = amounts.iter().map(String::len).max().unwrap_or(0);
+
+    let mut out = String::new();
+    for (transfer, amount) in sorted.iter().zip(&amounts) {
+        let mut line = format!(
+            "{:<from_width$} → {:<to_width$} {:>amount_width$} {}",
+            endpoint(&transfer.from, transfer.from_label.as_deref()),
+            endpoint(&transfer.to, transfer.to_label.as_deref()),
+            amount,
+            transfer.symbol,
+            from_width = max_from_len,
+            to_width = max_to_len,
+            amount_width = max_amount_len
+        );
+        match &transfer.usd_value {
+            Some(Some(usd)) => {
+                line.push_str(&format!("  ≈ ${}", AmountStyle::pretty(None).apply(usd)))
+            }
+            Some(None) => line.push_str("  ≈ $?"),
+            None => {}
+        }
+        if !transfer.via.is_empty() {
+            line.push_str(&format!("  via {}", via_path(&transfer.via)));
+        }
+        out.push_str(line.trim_end());
+        out.push('\n');
+    }
+    if let Some(pricing) = &graph.pricing {
+        out.push_str(&format!(
+            "USD values use current {} prices, not prices at version {}.\n",
+            pricing.oracle, graph.version
+        ));
+    }
+    out
+}
+
+/// Shortened address, followed by its `--labels` label.
+fn endpoint(address: &str, label: Option<&str>) -> String {
+    match label {
+        Some(label) => format!("{} ({label})", shorten_addr(address)),
+        None => shorten_addr(address),
+    }
+}
+
+fn render_dot(graph: &TxGraph) -> String {
+    let mut out = format!("digraph tx_{} {{\n", graph.version);
+    if let Some(pricing) = &graph.pricing {
+        out.push_str(&format!(
+            "  label=\"edge widths use current {} prices, not prices at version {}\";\n",
+            pricing.oracle, graph.version
+        ));
+    }
+    let mut nodes: Vec<(&str, Option<&str>)> = Vec::new();
+    for transfer in &graph.transfers {
+        for node in [
+            (transfer.from.as_str(), transfer.from_label.as_deref()),
+            (transfer.to.as_str(), transfer.to_label.as_deref()),
+        ] {
+            if !nodes.iter().any(|(address, _)| *address == node.0) {
+                nodes.push(node);
+            }
+        }
+    }
+    for (node, label) in nodes {
+        let text = match label {
+            Some(label) => format!("{}\\n{}", shorten_addr(node), label.replace('"', "\\\"")),
+            None => shorten_addr(node),
+        };
+        out.push_str(&format!("  \"{node}\" [label=\"{text}\"];\n"));
+    }
+    for transfer in &graph.transfers {
+        let mut label = format!("{} {}", transfer.amount, transfer.symbol);
+        let mut penwidth = 1.0;
+        if let Some(Some(usd)) = &transfer.usd_value {
+            label.push_str(&format!(" (${usd})"));
+            penwidth = usd_penwidth(usd);
+        }
+        if !transfer.via.is_empty() {
+            label.push_str(&format!("\\nvia {}", via_path(&transfer.via)));
+        }
+        out.push_str(&format!(
+            "  \"{}\" -> \"{}\" [label=\"{label}\", penwidth={penwidth:.1}];\n",
+            transfer.from, transfer.to
+        ));
+    }
+    out.push_str("}\n");
+    out
+}
+
+fn via_path(via: &[String]) -> String {
+    via.iter()
+        .map(|owner| shorten_addr(owner))
+        .collect::<Vec<_>>()
+        .join(" → ")
+}
+
+/// Log-scaled so a $1M edge is visibly heavier than a $100 one without
+/// swallowing the graph.
+fn usd_penwidth(usd: &str) -> f64 {
+    let usd: f64 = usd.parse().unwrap_or(0.0);
+    (1.0 + (1.0 + usd.max(0.0)).log10()).min(8.0)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::commands::schema::validate;
+
+    fn change(kind: &str, account: &str, asset: &str, amount: &str) -> BalanceChange {
+        BalanceChange {
+            event_type: kind.to_owned(),
+            account: account.to_owned(),
+            fungible_store: String::new(),
+            asset: asset.to_owned(),
+            amount: amount.to_owned(),
+            account_label: None,
+        }
+    }
+
+    #[test]
+    fn pairs_swap_legs_per_asset() {
+        let changes = vec![
+            change("gas_fee", "0xuser", "0xa", "500"),
+            change("withdraw", "0xuser", "0xa", "190000000"),
+            change("deposit", "0xpool", "0xa", "190000000"),
+            change("withdraw", "0xpool", "0xusdc", "2000000000"),
+            change("deposit", "0xuser", "0xusdc", "1999000000"),
+            change("deposit", "0xfees", "0xusdc", "1000000"),
+            change("deposit", "0xuser", "0xreward", "7"),
+        ];
+        assert_eq!(
+            pair_transfers(&changes),
+            vec![
+                leg("0xuser", "0xpool", "0xa", BigInt::from(190_000_000u64)),
+                leg("0xpool", "0xuser", "0xusdc", BigInt::from(1_999_000_000u64)),
+                leg("0xpool", "0xfees", "0xusdc", BigInt::from(1_000_000u64)),
+                leg(MINT, "0xuser", "0xreward", BigInt::from(7u8)),
+            ]
+        );
+    }
+
+    /// An aggregator swap of 100 APT for USDC routed through two router
+    /// stores each way; the pool takes a 5 USDC protocol fee on the way out.
+    fn two_hop_aggregator() -> Vec<BalanceChange> {
+        vec![
+            change("gas_fee", "0xuser", "0xa", "500"),
+            change("withdraw", "0xuser", "0xa", "100"),
+            change("deposit", "0xrouter", "0xa", "100"),
+            change("withdraw", "0xrouter", "0xa", "100"),
+            change("deposit", "0xhop", "0xa", "100"),
+            change("withdraw", "0xhop", "0xa", "100"),
+            change("deposit", "0xpool", "0xa", "100"),
+            change("withdraw", "0xpool", "0xusdc", "500"),
+            change("deposit", "0xhop", "0xusdc", "500"),
+            change("withdraw", "0xhop", "0xusdc", "500"),
+            change("deposit", "0xrouter", "0xusdc", "500"),
+            change("withdraw", "0xrouter", "0xusdc", "495"),
+            change("deposit", "0xuser", "0xusdc", "490"),
+            change("deposit", "0xfees", "0xusdc", "5"),
+        ]
+    }
+
+    fn routed(from: &str, to: &str, asset: &str, amount: u64, via: &[&str]) -> Leg {
+        Leg {
+            via: via.iter().map(|owner| (*owner).to_owned()).collect(),
+            ..leg(from, to, asset, BigInt::from(amount))
+        }
+    }
+
+    #[test]
+    fn simplify_collapses_forwarding_hops() {
+        let legs = pair_transfers(&two_hop_aggregator());
+        assert_eq!(legs.len(), 7);
+        // The router keeps 5 USDC, so the USDC path stops there; the hop
+        // forwards everything and is collapsed.
+        assert_eq!(
+            simplify(legs),
+            vec![
+                routed("0xuser", "0xpool", "0xa", 100, &["0xrouter", "0xhop"]),
+                routed("0xpool", "0xrouter", "0xusdc", 500, &["0xhop"]),
+                routed("0xrouter", "0xuser", "0xusdc", 490, &[]),
+                routed("0xrouter", "0xfees", "0xusdc", 5, &[]),
+            ]
+        );
+    }
+
+    #[test]
+    fn simplify_splits_fan_out_and_drops_round_trips() {
+        let changes = vec![
+            change("withdraw", "0xuser", "0xa", "60"),
+            change("withdraw", "0xwhale", "0xa", "40"),
+            change("deposit", "0xrouter", "0xa", "100"),
+            change("withdraw", "0xrouter", "0xa", "100"),
+            change("deposit", "0xpool1", "0xa", "70"),
+            change("deposit", "0xuser", "0xa", "30"),
+        ];
+        assert_eq!(
+            simplify(pair_transfers(&changes)),
+            vec![
+                routed("0xuser", "0xpool1", "0xa", 60, &["0xrouter"]),
+                routed("0xwhale", "0xpool1", "0xa", 10, &["0xrouter"]),
+                routed("0xwhale", "0xuser", "0xa", 30, &["0xrouter"]),
+            ]
+        );
+        // Nothing forwards its whole inflow here.
+        let swap = vec![leg("0xuser", "0xpool", "0xa", BigInt::from(5u8))];
+        assert_eq!(simplify(swap.clone()), swap);
+    }
+
+    #[test]
+    fn renders_via_paths() {
+        let graph = TxGraph {
+            version: 7,
+            transfers: vec![GraphTransfer {
+                from: "0x1".to_owned(),
+                to: "0x2".to_owned(),
+                asset: "0xa".to_owned(),
+                symbol: "APT".to_owned(),
+                amount: "1".to_owned(),
+                via: vec!["0x3".to_owned(), "0x4".to_owned()],
+                from_label: None,
+                to_label: None,
+                usd_value: None,
+            }],
+            pricing: None,
+        };
+        let output = serde_json::to_value(&graph).unwrap();
+        assert_eq!(output["transfers"][0]["via"], json!(["0x3", "0x4"]));
+        validate(&graph_output_schema(), &output).unwrap();
+        validate(&graph_output_schema(), &json!([output])).unwrap();
+        assert_eq!(
+            render_pretty(&graph, AmountStyle::default()),
+            "0x1 → 0x2 1 APT  via 0x3 → 0x4\n"
+        );
+        assert!(render_dot(&graph).contains("[label=\"1 APT\\nvia 0x3 → 0x4\", penwidth=1.0]"));
+    }
+
+    #[test]
+    fn canonicalizes_and_labels_addresses_but_not_mint() {
+        let mut graph = TxGraph {
+            version: 3,
+            transfers: vec![GraphTransfer {
+                from: MINT.to_owned(),
+                to: "0x1".to_owned(),
+                asset: "0xa".to_owned(),
+                symbol: "APT".to_owned(),
+                amount: "1".to_owned(),
+                via: vec!["0x2".to_owned()],
+                from_label: None,
+                to_label: None,
+                usd_value: None,
+            }],
+            pricing: None,
+        };
+        graph.canonicalize_addresses();
+        graph.apply_labels(&AddressLabels::builtin());
+        let transfer = &graph.transfers[0];
+        assert_eq!(transfer.from, MINT);
+        assert_eq!(transfer.to, normalize_address("0x1"));
+        assert_eq!(transfer.asset, normalize_address("0xa"));
+        assert_eq!(transfer.via, [normalize_address("0x2")]);
+        assert_eq!(transfer.from_label, None);
+        assert_eq!(transfer.to_label.as_deref(), Some("Aptos Framework"));
+
+        let output = serde_json::to_value(&graph).unwrap();
+        assert_eq!(output["transfers"][0]["to_label"], "Aptos Framework");
+        validate(&graph_output_schema(), &output).unwrap();
+        assert_eq!(
+            render_pretty(&graph, AmountStyle::default()),
+            "mint → 0x0000...0001 (Aptos Framework) 1 APT  via 0x0000...0002\n"
+        );
+        assert!(render_dot(&graph).contains("[label=\"0x0000...0001\\nAptos Framework\"];"));
+    }
+
+    #[test]
+    fn renders_usd_values_and_penwidth() {
+        let graph = TxGraph {
+            version: 42,
+            transfers: vec![
+                GraphTransfer {
+                    from: "0x1".to_owned(),
+                    to: "0x2".to_owned(),
+                    asset: "0xa".to_owned(),
+                    symbol: "APT".to_owned(),
+                    amount: "1.9".to_owned(),
+                    via: Vec::new(),
+                    from_label: None,
+                    to_label: None,
+                    usd_value: Some(Some("11.63".to_owned())),
+                },
+                GraphTransfer {
+                    from: "0x2".to_owned(),
+                    to: "0x1".to_owned(),
+                    asset: "0xb".to_owned(),
+                    symbol: "MEME".to_owned(),
+                    amount: "5".to_owned(),
+                    via: Vec::new(),
+                    from_label: None,
+                    to_label: None,
+                    usd_value: Some(None),
+                },
+            ],
+            pricing: Some(Pricing {
+                oracle: "pyth",
+                price_basis: "current",
+            }),
+        };
+        let output = serde_json::to_value(&graph).unwrap();
+        assert_eq!(output["transfers"][1]["usd_value"], Value::Null);
+        validate(&graph_output_schema(), &output).unwrap();
+
+        let dot = render_dot(&graph);
+        assert!(dot.contains("\"0x1\" -> \"0x2\" [label=\"1.9 APT ($11.63)\", penwidth=2.1];"));
+        assert!(dot.contains("\"0x2\" -> \"0x1\" [label=\"5 MEME\", penwidth=1.0];"));
+        assert!(dot.contains("current pyth prices"));
+
+        let pretty = render_pretty(&graph, AmountStyle::pretty(None));
+        assert!(pretty.contains("0x1 → 0x2 1.9 APT  ≈ $11.63"));
+        assert!(pretty.contains("≈ $?"));
+    }
+
+    #[test]
+    fn pretty_output_is_sorted_and_stable() {
+        let transfer = |from: &str, to: &str, asset: &str, symbol: &str| GraphTransfer {
+            from: from.to_owned(),
+            to: to.to_owned(),
+            asset: asset.to_owned(),
+            symbol: symbol.to_owned(),
+            amount: "1".to_owned(),
+            via: Vec::new(),
+            from_label: None,
+            to_label: None,
+            usd_value: None,
+        };
+        let mut transfers = vec![
+            transfer("0x3", "0x1", "0xc", "USDT"),
+            transfer("0x1", "0x3", "0xb", "USDC"),
+            transfer("0x2", "0x1", "0xa", "APT"),
+            transfer("0x1", "0x2", "0xa", "APT"),
+            transfer("mint", "0x1", "0xd", "LP"),
+        ];
+        let expected = "\
+0x1  → 0x2 1 APT
+0x1  → 0x3 1 USDC
+0x2  → 0x1 1 APT
+0x3  → 0x1 1 USDT
+mint → 0x1 1 LP
+";
+        for _ in 0..transfers.len() {
+            let graph = TxGraph {
+                version: 1,
+                transfers: transfers.clone(),
+                pricing: None,
+            };
+            assert_eq!(render_pretty(&graph, AmountStyle::default()), expected);
+            transfers.rotate_left(1);
+        }
+    }
+}
@@ -0,0 +1,239 @@
+use anyhow::{anyhow, Context, Result};
+use aptly_aptos::{AptosClient, ClientOptions};
+use serde_json::{json, Value};
+
+use crate::commands::common::parse_u64;
+use crate::network::Network;
+
+/// Activity types of funds arriving in a coin store or fungible store.
+const DEPOSIT_ACTIVITY_TYPES: &[&str] = &[
+    "0x1::coin::DepositEvent",
+    "0x1::fungible_asset::Deposit",
+    "0x1::fungible_asset::DepositEvent",
+];
+
+/// Newest successful deposits into an owner's stores, one row per activity.
+const DEPOSITS_QUERY: &str =
+    "query Deposits($owner: String!, $types: [String!], $offset: Int!, $limit: Int!) {
+  fungible_asset_activities(
+    where: {owner_address: {_eq: $owner}, type: {_in: $types}, is_transaction_success: {_eq: true}}
+    order_by: [{transaction_version: desc}, {event_index: desc}]
+    offset: $offset
+    limit: $limit
+  ) {
+    transaction_version
+  }
+}";
+
+/// Objects currently owned by an account, most recently changed first.
+const OWNED_OBJECTS_QUERY: &str =
+    "query OwnedObjects($owner: String!, $offset: Int!, $limit: Int!) {
+  current_objects(
+    where: {owner_address: {_eq: $owner}}
+    order_by: [{last_transaction_version: desc}, {object_address: asc}]
+    offset: $offset
+    limit: $limit
+  ) {
+    object_address
+    is_deleted
+  }
+}";
+
+/// Newest user transactions calling an entry function published at an
+/// address.
+const ENTRY_FUNCTION_CALLS_QUERY: &str =
+    "query EntryFunctionCalls($address: String!, $offset: Int!, $limit: Int!) {
+  user_transactions(
+    where: {entry_function_contract_address: {_eq: $address}}
+    order_by: {version: desc}
+    offset: $offset
+    limit: $limit
+  ) {
+    version
+  }
+}";
+
+/// The Aptos indexer GraphQL API.
+pub(crate) struct Indexer {
+    /// Client of the endpoint's parent, so requests keep the exact URL
+    /// (the node client joins paths with `/`).
+    client: AptosClient,
+    path: String,
+    url: String,
+}
+
+impl Indexer {
+    /// `url` is the GraphQL endpoint itself, e.g.
+    /// `https://api.mainnet.aptoslabs.com/v1/graphql`.
+    pub(crate) fn new(url: &str, options: ClientOptions) -> Result<Self> {
+        let url = url.trim_end_matches('/');
+        let (base, path) = url
+            .rsplit_once('/')
+            .filter(|(base, _)| base.contains("://"))
+            .ok_or_else(|| anyhow!("invalid indexer URL `{url}`"))?;
+        Ok(Self {
+            client: AptosClient::with_options(base, options)?,
+            path: path.to_owned(),
+            url: url.to_owned(),
+        })
+    }
+
+    /// `url` when given, otherwise the indexer of the network whose default
+    /// endpoint `node` uses.
+    pub(crate) fn for_node(node: &AptosClient, url: Option<&str>) -> Result<Self> {
+        let url = match url {
+            Some(url) => url,
+            None => default_indexer(node.base_url())?,
+        };
+        Self::new(url, node.options().clone())
+    }
+
+    /// Runs `query` and returns its `data`; GraphQL errors become an error.
+    pub(crate) fn query(&self, query: &str, variables: Value) -> Result<Value> {
+        let response = self
+            .client
+            .post_json(&self.path, &json!({"query": query, "variables": variables}))
+            .with_context(|| format!("indexer request to {} failed", self.url))?;
+        graphql_data(response)
+    }
+
+    /// Versions of the newest `limit` deposits into `owner`'s stores after
+    /// skipping `offset`, newest first. A version repeats when one
+    /// transaction deposited more than once.
+    pub(crate) fn deposit_versions(
+        &self,
+        owner: &str,
+        offset: u64,
+        limit: u64,
+    ) -> Result<Vec<u64>> {
+        let data = self.query(
+            DEPOSITS_QUERY,
+            json!({
+                "owner": owner,
+                "types": DEPOSIT_ACTIVITY_TYPES,
+                "offset": offset,
+                "limit": limit,
+            }),
+        )?;
+        versions(&data, "fungible_asset_activities", "transaction_version")
+    }
+
+    /// Versions of the newest `limit` user transactions calling an entry
+    /// function of a module at `address` after skipping `offset`, newest
+    /// first.
+    pub(crate) fn entry_function_versions(
+        &self,
+        address: &str,
+        offset: u64,
+        limit: u64,
+    ) -> Result<Vec<u64>> {
+        let data = self.query(
+            ENTRY_FUNCTION_CALLS_QUERY,
+            json!({"address": address, "offset": offset, "limit": limit}),
+        )?;
+        versions(&data, "user_transactions", "version")
+    }
+
+    /// `(object_address, is_deleted)` of objects owned by `owner` after
+    /// skipping `offset`, most recently changed first.
+    pub(crate) fn owned_objects(
+        &self,
+        owner: &str,
+        offset: u64,
+        limit: u64,
+    ) -> Result<Vec<(String, bool)>> {
+        let data = self.query(
+            OWNED_OBJECTS_QUERY,
+            json!({"owner": owner, "offset": offset, "limit": limit}),
+        )?;
+        data.get("current_objects")
+            .and_then(Value::as_array)
+            .ok_or_else(|| anyhow!("unexpected current_objects response"))?
+            .iter()
+            .map(|object| {
+                let address = object.get("object_address").and_then(Value::as_str);
+                let deleted = object.get("is_deleted").and_then(Value::as_bool);
+                match (address, deleted) {
+                    (Some(address), Some(deleted)) => Ok((address.to_owned(), deleted)),
+                    _ => Err(anyhow!("unexpected current_objects response")),
+                }
+            })
+            .collect()
+    }
+}
+
+fn default_indexer(rpc_url: &str) -> Result<&'static str> {
+    // The default `--rpc-url` serves mainnet.
+    let network = if rpc_url.trim_end_matches('/') == crate::DEFAULT_RPC_URL {
+        Some(Network::Mainnet)
+    } else {
+        Network::from_rpc_url(rpc_url)
+    };
+    network
+        .map(Network::indexer_url)
+        .ok_or_else(|| {
+            anyhow!(
+                "no indexer endpoint known for {rpc_url}; pass --network mainnet|testnet|devnet|local or --indexer-url <graphql url>"
+            )
+        })
+}
+
+/// The `field` version of every row of `table` in a query's `data`.
+fn versions(data: &Value, table: &str, field: &str) -> Result<Vec<u64>> {
+    data.get(table)
+        .and_then(Value::as_array)
+        .ok_or_else(|| anyhow!("unexpected {table} response"))?
+        .iter()
+        .map(|row| {
+            parse_u64(row.get(field).unwrap_or(&Value::Null))
+                .ok_or_else(|| anyhow!("unexpected {table} response"))
+        })
+        .collect()
+}
+
+fn graphql_data(response: Value) -> Result<Value> {
+    if let Some(errors) = response.get("errors").and_then(Value::as_array) {
+        let messages: Vec<&str> = errors
+            .iter()
+            .filter_map(|error| error.get("message").and_then(Value::as_str))
+            .collect();
+        return Err(anyhow!("indexer query failed: {}", messages.join("; ")));
+    }
+    response
+        .get("data")
+        .cloned()
+        .ok_or_else(|| anyhow!("indexer response has no data"))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn routes_to_the_network_indexer() {
+        assert_eq!(
+            default_indexer(Network::Mainnet.rpc_url()).unwrap(),
+            "https://api.mainnet.aptoslabs.com/v1/graphql"
+        );
+        assert_eq!(
+            default_indexer(crate::DEFAULT_RPC_URL).unwrap(),
+            Network::Mainnet.indexer_url()
+        );
+        let err = default_indexer("https://fullnode.example.com/v1").unwrap_err();
+        assert!(err.to_string().contains("--indexer-url"));
+    }
+
+    #[test]
+    fn surfaces_graphql_errors() {
+        let data = graphql_data(json!({"data": {"fungible_asset_activities": []}})).unwrap();
+        assert_eq!(data, json!({"fungible_asset_activities": []}));
+        let err = graphql_data(json!({
+            "errors": [{"message": "field 'nope' not found in type: 'query_root'"}],
+        }))
+        .unwrap_err();
+        assert_eq!(
+            err.to_string(),
+            "indexer query failed: field 'nope' not found in type: 'query_root'"
+        );
+    }
+}
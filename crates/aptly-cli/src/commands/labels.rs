@@ -0,0 +1,280 @@
+use anyhow::{anyhow, Context, Result};
+use clap::{Args, Subcommand};
+use serde::Serialize;
+use serde_json::{json, Value};
+use std::collections::{BTreeMap, HashMap};
+use std::fs;
+use std::path::{Path, PathBuf};
+
+use crate::commands::address::fetch_curated_labels;
+use crate::commands::common::{normalize_address, user_cache_path, user_config_path};
+use crate::commands::schema::{array_schema, object_schema, string_schema, OutputSchema};
+
+/// Well-known mainnet addresses shipped with the binary. Curated labels from
+/// `aptly labels sync` and the user labels file override these. Exchange hot
+/// wallets rotate, so they come from the curated list rather than this table.
+const BUILTIN_LABELS: &[(&str, &str)] = &[
+    ("0x1", "Aptos Framework"),
+    ("0x3", "Aptos Token (legacy)"),
+    ("0x4", "Aptos Token Objects"),
+    ("0xa", "APT Fungible Asset"),
+    (
+        "0x190d44266241744264b964a37b8f09863167a12d3e70cda39376cfb4e3561e12",
+        "Liquidswap",
+    ),
+    (
+        "0xc7efb4076dbe143cbcd98cfaaa929ecfc8f299203dfff63b95ccb6bfe19850fa",
+        "PancakeSwap",
+    ),
+];
+
+#[derive(Args)]
+#[command(
+    after_help = "Examples:\n  aptly labels list\n  aptly labels sync\n  aptly labels add 0x1234 \"My hot wallet\"\n  aptly account sends 0x1234 --labels --pretty\n\nUser labels live in $XDG_CONFIG_HOME/aptly/labels.json (default ~/.config/aptly/labels.json) as {\"<address>\": \"<label>\"}. `labels sync` caches the curated list behind `aptly address` (exchanges, bridges, protocols) in $XDG_CACHE_HOME/aptly/curated_labels.json."
+)]
+pub(crate) struct LabelsCommand {
+    #[command(subcommand)]
+    pub(crate) command: LabelsSubcommand,
+}
+
+#[derive(Subcommand)]
+pub(crate) enum LabelsSubcommand {
+    #[command(about = "List built-in, curated and user address labels")]
+    List,
+    #[command(about = "Download the curated mainnet label list (exchanges, bridges, protocols)")]
+    Sync,
+    #[command(about = "Add or replace a label in the user labels file")]
+    Add(LabelsAddArgs),
+}
+
+#[derive(Args)]
+pub(crate) struct LabelsAddArgs {
+    /// Account address (`0x...`).
+    #[arg(value_name = "ADDRESS")]
+    pub(crate) address: String,
+    /// Human-readable label.
+    #[arg(value_name = "LABEL")]
+    pub(crate) label: String,
+}
+
+#[derive(Debug, Clone, Serialize)]
+struct LabelEntry {
+    address: String,
+    label: String,
+    source: &'static str,
+}
+
+impl OutputSchema for LabelEntry {
+    fn output_schema() -> Value {
+        object_schema(
+            "Address label",
+            &[
+                ("address", string_schema("Normalized 64-hex address")),
+                ("label", string_schema("Human-readable label")),
+                (
+                    "source",
+                    json!({
+                        "description": "Where the label comes from; user labels override curated ones, which override built-in ones",
+                        "type": "string",
+                        "enum": ["builtin", "curated", "user"],
+                    }),
+                ),
+            ],
+        )
+    }
+}
+
+pub(crate) fn list_output_schema() -> Value {
+    array_schema(
+        "Known labels sorted by address",
+        LabelEntry::output_schema(),
+    )
+}
+
+pub(crate) fn entry_output_schema() -> Value {
+    LabelEntry::output_schema()
+}
+
+/// Address-to-label lookup keyed by normalized address.
+#[derive(Debug, Default)]
+pub(crate) struct AddressLabels {
+    labels: HashMap<String, (String, &'static str)>,
+}
+
+impl AddressLabels {
+    /// Built-in labels overlaid with the synced curated list and the user
+    /// labels file, if present.
+    pub(crate) fn load() -> Result<Self> {
+        let mut labels = Self::builtin();
+        for (address, label) in read_labels_file(&curated_labels_path()?)? {
+            labels.insert(&address, label, "curated");
+        }
+        for (address, label) in read_user_labels()? {
+            labels.insert(&address, label, "user");
+        }
+        Ok(labels)
+    }
+
+    /// Only the labels shipped with the binary.
+    pub(crate) fn builtin() -> Self {
+        let mut labels = Self::default();
+        for (address, label) in BUILTIN_LABELS {
+            labels.insert(address, (*label).to_owned(), "builtin");
+        }
+        labels
+    }
+
+    fn insert(&mut self, address: &str, label: String, source: &'static str) {
+        self.labels
+            .insert(normalize_address(address), (label, source));
+    }
+
+    pub(crate) fn get(&self, address: &str) -> Option<&str> {
+        self.labels
+            .get(&normalize_address(address))
+            .map(|(label, _)| label.as_str())
+    }
+}
+
+/// Implemented by output structs that gain `*_label` fields under `--labels`.
+/// Raw address fields are never rewritten.
+pub(crate) trait LabelAddresses {
+    fn apply_labels(&mut self, labels: &AddressLabels);
+}
+
+impl<T: LabelAddresses> LabelAddresses for Vec<T> {
+    fn apply_labels(&mut self, labels: &AddressLabels) {
+        for item in self {
+            item.apply_labels(labels);
+        }
+    }
+}
+
+pub(crate) fn run_labels(command: LabelsCommand) -> Result<()> {
+    match command.command {
+        LabelsSubcommand::List => {
+            let labels = AddressLabels::load()?;
+            let mut entries: Vec<LabelEntry> = labels
+                .labels
+                .into_iter()
+                .map(|(address, (label, source))| LabelEntry {
+                    address,
+                    label,
+                    source,
+                })
+                .collect();
+            entries.sort_by(|a, b| a.address.cmp(&b.address));
+            crate::print_serialized(&entries)
+        }
+        LabelsSubcommand::Sync => {
+            let curated: BTreeMap<String, String> = fetch_curated_labels()?
+                .into_iter()
+                .map(|(address, label)| (normalize_address(&address), label))
+                .collect();
+            write_labels_file(&curated_labels_path()?, &curated)?;
+
+            let entries: Vec<LabelEntry> = curated
+                .into_iter()
+                .map(|(address, label)| LabelEntry {
+                    address,
+                    label,
+                    source: "curated",
+                })
+                .collect();
+            crate::print_serialized(&entries)
+        }
+        LabelsSubcommand::Add(args) => {
+            let address = normalize_address(args.address.trim());
+            if !address.starts_with("0x") || address.len() != 66 {
+                return Err(anyhow!("invalid address `{}`", args.address));
+            }
+            let label = args.label.trim().to_owned();
+            if label.is_empty() {
+                return Err(anyhow!("label cannot be empty"));
+            }
+
+            let path = user_labels_path()?;
+            let mut user = read_labels_file(&path)?;
+            user.insert(address.clone(), label.clone());
+            write_labels_file(&path, &user)?;
+
+            crate::print_serialized(&LabelEntry {
+                address,
+                label,
+                source: "user",
+            })
+        }
+    }
+}
+
+fn user_labels_path() -> Result<PathBuf> {
+    user_config_path("labels.json")
+}
+
+fn curated_labels_path() -> Result<PathBuf> {
+    user_cache_path("curated_labels.json")
+}
+
+fn read_user_labels() -> Result<BTreeMap<String, String>> {
+    read_labels_file(&user_labels_path()?)
+}
+
+/// Reads a `{address: label}` file, keyed by normalized address. A missing
+/// file is an empty set.
+fn read_labels_file(path: &Path) -> Result<BTreeMap<String, String>> {
+    let body = match fs::read_to_string(path) {
+        Ok(body) => body,
+        Err(err) if err.kind() == std::io::ErrorKind::NotFound => return Ok(BTreeMap::new()),
+        Err(err) => return Err(err).with_context(|| format!("failed to read {}", path.display())),
+    };
+    let labels: BTreeMap<String, String> = serde_json::from_str(&body)
+        .with_context(|| format!("failed to parse {}", path.display()))?;
+    Ok(labels
+        .into_iter()
+        .map(|(address, label)| (normalize_address(&address), label))
+        .collect())
+}
+
+fn write_labels_file(path: &Path, labels: &BTreeMap<String, String>) -> Result<()> {
+    if let Some(dir) = path.parent() {
+        fs::create_dir_all(dir).with_context(|| format!("failed to create {}", dir.display()))?;
+    }
+    let body = serde_json::to_string_pretty(labels)?;
+    fs::write(path, format!("{body}\n"))
+        .with_context(|| format!("failed to write {}", path.display()))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn lookup_normalizes_addresses() {
+        let labels = AddressLabels::builtin();
+        assert_eq!(labels.get("0x1"), Some("Aptos Framework"));
+        assert_eq!(
+            labels.get(&format!("0x{:0>64}", "1")),
+            Some("Aptos Framework")
+        );
+        assert_eq!(labels.get("0x0001"), Some("Aptos Framework"));
+        assert_eq!(labels.get("0x2"), None);
+    }
+
+    #[test]
+    fn labels_files_round_trip_with_normalized_addresses() {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("nested").join("curated_labels.json");
+        assert!(read_labels_file(&path).unwrap().is_empty());
+
+        fs::create_dir_all(path.parent().unwrap()).unwrap();
+        fs::write(&path, r#"{"0x0a": "Exchange hot wallet"}"#).unwrap();
+        let labels = read_labels_file(&path).unwrap();
+        assert_eq!(
+            labels.get(&format!("0x{:0>64}", "a")).map(String::as_str),
+            Some("Exchange hot wallet")
+        );
+
+        write_labels_file(&path, &labels).unwrap();
+        assert_eq!(read_labels_file(&path).unwrap(), labels);
+    }
+}
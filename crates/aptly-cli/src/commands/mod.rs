@@ -1,11 +1,53 @@
+pub(crate) mod abort;
 pub(crate) mod account;
+pub(crate) mod account_gas;
+pub(crate) mod account_txs;
+pub(crate) mod account_watch;
 pub(crate) mod address;
+pub(crate) mod asset_cache;
+pub(crate) mod assets;
+pub(crate) mod audit;
+pub(crate) mod auth;
+pub(crate) mod bcs;
+pub(crate) mod bindings;
 pub(crate) mod block;
+pub(crate) mod coin;
 pub(crate) mod common;
+pub(crate) mod decode;
 pub(crate) mod decompile;
+pub(crate) mod disassemble;
 pub(crate) mod events;
+pub(crate) mod fa;
+pub(crate) mod faucet;
+pub(crate) mod follow;
+pub(crate) mod gas_profile;
+pub(crate) mod graph;
+pub(crate) mod indexer;
+pub(crate) mod labels;
+pub(crate) mod move_structs;
+pub(crate) mod multisig;
+pub(crate) mod names;
 pub(crate) mod node;
+pub(crate) mod objects;
+pub(crate) mod openapi;
 pub(crate) mod plugin;
+pub(crate) mod poll;
+pub(crate) mod price;
+pub(crate) mod repl;
+pub(crate) mod resource_history;
+pub(crate) mod schema;
+pub(crate) mod signers;
+pub(crate) mod simulate_compare;
+pub(crate) mod source_verify;
+pub(crate) mod stake;
+pub(crate) mod state_diff;
+pub(crate) mod storage;
+pub(crate) mod swaps;
 pub(crate) mod table;
+pub(crate) mod text_diff;
 pub(crate) mod tx;
+pub(crate) mod tx_cost;
+pub(crate) mod tx_pretty;
+pub(crate) mod type_tag;
 pub(crate) mod view;
+pub(crate) mod watch;
@@ -0,0 +1,333 @@
+use anyhow::{anyhow, Result};
+use serde::Serialize;
+use serde_json::Value;
+
+use crate::commands::account::TypeParam;
+use crate::commands::bindings::split_type_args;
+use crate::commands::common::{get_nested_string, normalize_address};
+use crate::commands::schema::{
+    array_schema, boolean_schema, object_schema, string_schema, OutputSchema,
+};
+
+/// Primitive Move types, written unqualified.
+const PRIMITIVES: &[&str] = &[
+    "bool", "u8", "u16", "u32", "u64", "u128", "u256", "address", "signer",
+];
+
+/// `account module --structs` entry: a struct declared by the module.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize)]
+pub(crate) struct StructLayout {
+    name: String,
+    is_native: bool,
+    abilities: Vec<String>,
+    type_params: Vec<TypeParam>,
+    fields: Vec<StructField>,
+}
+
+#[derive(Debug, Clone, PartialEq, Eq, Serialize)]
+pub(crate) struct StructField {
+    name: String,
+    /// Field type as the node prints it, e.g.
+    /// `0x1::table::Table<address, vector<u8>>`.
+    #[serde(rename = "type")]
+    field_type: String,
+}
+
+impl StructLayout {
+    /// Structs of a module response in ABI order, or only `name` when given.
+    pub(crate) fn list(module: &Value, name: Option<&str>) -> Result<Vec<Self>> {
+        let layouts: Vec<Self> = module
+            .pointer("/abi/structs")
+            .and_then(Value::as_array)
+            .map(Vec::as_slice)
+            .unwrap_or_default()
+            .iter()
+            .map(Self::of)
+            .filter(|layout| name.map_or(true, |name| layout.name == name))
+            .collect();
+        match name {
+            Some(name) if layouts.is_empty() => Err(anyhow!(
+                "module `{}` has no struct `{name}`",
+                get_nested_string(module, &["abi", "name"])
+            )),
+            _ => Ok(layouts),
+        }
+    }
+
+    fn of(value: &Value) -> Self {
+        let strings = |key: &str| -> Vec<String> {
+            value
+                .get(key)
+                .and_then(Value::as_array)
+                .map(Vec::as_slice)
+                .unwrap_or_default()
+                .iter()
+                .filter_map(Value::as_str)
+                .map(str::to_owned)
+                .collect()
+        };
+        let entries = |key: &str| -> &[Value] {
+            value
+                .get(key)
+                .and_then(Value::as_array)
+                .map(Vec::as_slice)
+                .unwrap_or_default()
+        };
+        Self {
+            name: get_nested_string(value, &["name"]),
+            is_native: value.get("is_native").and_then(Value::as_bool) == Some(true),
+            abilities: strings("abilities"),
+            type_params: entries("generic_type_params")
+                .iter()
+                .enumerate()
+                .map(|(index, param)| TypeParam::of(index, param))
+                .collect(),
+            fields: entries("fields")
+                .iter()
+                .map(|field| StructField {
+                    name: get_nested_string(field, &["name"]),
+                    field_type: get_nested_string(field, &["type"]),
+                })
+                .collect(),
+        }
+    }
+
+    /// Move-like declaration for `--pretty`. Types from framework addresses
+    /// and from `module_id` (`0x1::coin`) are written by name alone.
+    pub(crate) fn declaration(&self, module_id: &str) -> Result<Vec<String>> {
+        let native = if self.is_native { "native " } else { "" };
+        let generics = if self.type_params.is_empty() {
+            String::new()
+        } else {
+            let params: Vec<String> = self
+                .type_params
+                .iter()
+                .map(TypeParam::declaration)
+                .collect();
+            format!("<{}>", params.join(", "))
+        };
+        let abilities = if self.abilities.is_empty() {
+            String::new()
+        } else {
+            format!(" has {}", self.abilities.join(", "))
+        };
+        let head = format!("{native}struct {}{generics}{abilities}", self.name);
+        if self.is_native {
+            return Ok(vec![format!("{head};")]);
+        }
+        if self.fields.is_empty() {
+            return Ok(vec![format!("{head} {{}}")]);
+        }
+        let mut lines = vec![format!("{head} {{")];
+        for field in &self.fields {
+            lines.push(format!(
+                "    {}: {},",
+                field.name,
+                short_type(&field.field_type, module_id)?
+            ));
+        }
+        lines.push("}".to_owned());
+        Ok(lines)
+    }
+}
+
+impl OutputSchema for StructLayout {
+    fn output_schema() -> Value {
+        object_schema(
+            "Struct declared by the module (`--structs`)",
+            &[
+                ("name", string_schema("Struct name")),
+                (
+                    "is_native",
+                    boolean_schema("Whether the struct is native and has no visible fields"),
+                ),
+                (
+                    "abilities",
+                    array_schema(
+                        "Abilities of the struct",
+                        string_schema("`copy`, `drop`, `store` or `key`"),
+                    ),
+                ),
+                (
+                    "type_params",
+                    array_schema(
+                        "Generic type parameters, named `T0`, `T1`, ...",
+                        TypeParam::output_schema(),
+                    ),
+                ),
+                (
+                    "fields",
+                    array_schema(
+                        "Fields in declaration order",
+                        object_schema(
+                            "Struct field",
+                            &[
+                                ("name", string_schema("Field name")),
+                                ("type", string_schema("Field type as the node prints it")),
+                            ],
+                        ),
+                    ),
+                ),
+            ],
+        )
+    }
+}
+
+pub(crate) fn structs_output_schema() -> Value {
+    array_schema(
+        "Structs declared by the module",
+        StructLayout::output_schema(),
+    )
+}
+
+/// Rewrites a Move type string with type arguments separated by `, ` and
+/// structs shortened to their name when they live at a framework address
+/// (`0x1`..`0xf`) or in `module_id`; other structs stay fully qualified.
+fn short_type(value: &str, module_id: &str) -> Result<String> {
+    let value = value.trim();
+    if let Some(inner) = value.strip_prefix("&mut ") {
+        return Ok(format!("&mut {}", short_type(inner, module_id)?));
+    }
+    if let Some(inner) = value.strip_prefix('&') {
+        return Ok(format!("&{}", short_type(inner, module_id)?));
+    }
+    let (base, type_args) = split_type_args(value)?;
+    let base = base.trim();
+    let name = match base.rsplit_once("::") {
+        Some((module, name)) if is_local(module, module_id) => name.to_owned(),
+        Some(_) => base.to_owned(),
+        None if base == "vector" || PRIMITIVES.contains(&base) || is_generic(base) => {
+            base.to_owned()
+        }
+        None => return Err(anyhow!("unrecognized Move type `{value}`")),
+    };
+    if type_args.is_empty() {
+        return Ok(name);
+    }
+    let args = type_args
+        .iter()
+        .map(|arg| short_type(arg, module_id))
+        .collect::<Result<Vec<_>>>()?;
+    Ok(format!("{name}<{}>", args.join(", ")))
+}
+
+/// Whether structs of `module` (`0x1::table`) are written by name alone.
+fn is_local(module: &str, module_id: &str) -> bool {
+    let Some((address, name)) = module.split_once("::") else {
+        return false;
+    };
+    let address = normalize_address(address);
+    let framework = address.len() == 66 && address[2..65].bytes().all(|b| b == b'0');
+    framework
+        || module_id
+            .split_once("::")
+            .is_some_and(|(own_address, own_name)| {
+                normalize_address(own_address) == address && own_name == name
+            })
+}
+
+fn is_generic(value: &str) -> bool {
+    value
+        .strip_prefix('T')
+        .is_some_and(|index| !index.is_empty() && index.bytes().all(|b| b.is_ascii_digit()))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::commands::schema::validate;
+    use serde_json::json;
+
+    #[test]
+    fn shortens_nested_type_strings() {
+        let cases = [
+            ("u64", "u64"),
+            ("vector<vector<u8>>", "vector<vector<u8>>"),
+            (
+                "0x1::table::Table<address,vector<u8>>",
+                "Table<address, vector<u8>>",
+            ),
+            (
+                "0x1::option::Option<0x1::object::Object<0x1::fungible_asset::Metadata>>",
+                "Option<Object<Metadata>>",
+            ),
+            (
+                "0x0000000000000000000000000000000000000000000000000000000000000001::string::String",
+                "String",
+            ),
+            ("0xcafe::pool::Pool<T0, T1>", "Pool<T0, T1>"),
+            (
+                "0x1::simple_map::SimpleMap<0xbeef::pool::Pool<T0, 0x1::aptos_coin::AptosCoin>, u64>",
+                "SimpleMap<0xbeef::pool::Pool<T0, AptosCoin>, u64>",
+            ),
+            ("&mut 0xcafe::pool::Pool<T0, T1>", "&mut Pool<T0, T1>"),
+        ];
+        for (input, expected) in cases {
+            assert_eq!(
+                short_type(input, "0xcafe::pool").unwrap(),
+                expected,
+                "{input}"
+            );
+        }
+        assert!(short_type("Table<u8>", "0x1::table").is_err());
+        assert!(short_type("0x1::table::Table<u8", "0x1::table").is_err());
+    }
+
+    #[test]
+    fn renders_struct_layouts() {
+        let module = json!({"abi": {"address": "0x1", "name": "table", "structs": [
+            {
+                "name": "Table",
+                "is_native": false,
+                "abilities": ["store"],
+                "generic_type_params": [{"constraints": ["copy", "drop"]}, {"constraints": []}],
+                "fields": [{"name": "handle", "type": "address"}],
+            },
+            {
+                "name": "Box",
+                "is_native": false,
+                "abilities": ["drop", "store"],
+                "generic_type_params": [{"constraints": []}],
+                "fields": [
+                    {"name": "val", "type": "T0"},
+                    {"name": "index", "type": "0x1::table::Table<address, vector<u8>>"},
+                ],
+            },
+            {
+                "name": "Marker",
+                "is_native": false,
+                "abilities": ["drop"],
+                "generic_type_params": [],
+                "fields": [],
+            },
+        ]}});
+        let layouts = StructLayout::list(&module, None).unwrap();
+        let lines: Vec<String> = layouts
+            .iter()
+            .flat_map(|layout| layout.declaration("0x1::table").unwrap())
+            .collect();
+        assert_eq!(
+            lines,
+            [
+                "struct Table<T0: copy + drop, T1> has store {",
+                "    handle: address,",
+                "}",
+                "struct Box<T0> has drop, store {",
+                "    val: T0,",
+                "    index: Table<address, vector<u8>>,",
+                "}",
+                "struct Marker has drop {}",
+            ]
+        );
+        validate(
+            &structs_output_schema(),
+            &serde_json::to_value(&layouts).unwrap(),
+        )
+        .unwrap();
+
+        let one = StructLayout::list(&module, Some("Box")).unwrap();
+        assert_eq!(one.len(), 1);
+        let err = StructLayout::list(&module, Some("Missing")).unwrap_err();
+        assert_eq!(err.to_string(), "module `table` has no struct `Missing`");
+    }
+}
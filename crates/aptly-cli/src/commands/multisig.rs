@@ -0,0 +1,562 @@
+use anyhow::{anyhow, Context, Result};
+use aptly_aptos::AptosClient;
+use clap::Args;
+use serde::Serialize;
+use serde_json::{json, Value};
+
+use crate::commands::bcs::{
+    decode_hex, read_uleb128, struct_fields, substitute, take, StructLayouts,
+};
+use crate::commands::bindings::MoveType;
+use crate::commands::common::{get_nested_string, normalize_address, parse_u64};
+use crate::commands::schema::{
+    array_schema, integer_schema, nullable, object_schema, string_schema, with_optional_properties,
+    OutputSchema,
+};
+use crate::commands::type_tag::{read_bcs_str, TypeTag};
+
+const MULTISIG_ACCOUNT_TYPE: &str = "0x1::multisig_account::MultisigAccount";
+const MULTISIG_TRANSACTION_TYPE: &str = "0x1::multisig_account::MultisigTransaction";
+
+#[derive(Args)]
+pub(crate) struct MultisigArgs {
+    /// Multisig account address (`0x...`).
+    #[arg(value_name = "ADDRESS")]
+    pub(crate) address: String,
+    /// Print only the pending transactions.
+    #[arg(long, default_value_t = false, conflicts_with = "tx")]
+    pub(crate) pending_only: bool,
+    /// Show one pending transaction, with its entry function decoded when
+    /// the payload is stored on chain.
+    #[arg(long, value_name = "SEQUENCE_NUMBER")]
+    pub(crate) tx: Option<u64>,
+}
+
+#[derive(Debug, Clone, PartialEq, Serialize)]
+struct MultisigOverview {
+    address: String,
+    owners: Vec<String>,
+    num_signatures_required: u64,
+    last_executed_sequence_number: u64,
+    next_sequence_number: u64,
+    pending: Vec<PendingTransaction>,
+}
+
+#[derive(Debug, Clone, PartialEq, Serialize)]
+struct PendingTransaction {
+    sequence_number: u64,
+    creator: String,
+    creation_time_secs: u64,
+    /// BCS-encoded `MultisigTransactionPayload`, when stored on chain.
+    payload: Option<String>,
+    /// SHA3-256 of the payload, when only the hash is stored.
+    payload_hash: Option<String>,
+    approvals: Vec<String>,
+    rejections: Vec<String>,
+    /// Decoded payload; only filled in for `--tx`.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    entry_function: Option<EntryFunctionCall>,
+}
+
+/// Entry function inside a `MultisigTransactionPayload::EntryFunction`.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+struct EntryFunctionCall {
+    function: String,
+    type_arguments: Vec<String>,
+    /// BCS-encoded arguments as hex.
+    arguments: Vec<String>,
+    /// Arguments decoded against the function ABI; null when the ABI could
+    /// not be read or does not match.
+    decoded_arguments: Option<Vec<Value>>,
+}
+
+impl OutputSchema for PendingTransaction {
+    fn output_schema() -> Value {
+        let addresses =
+            |description: &str| array_schema(description, string_schema("Owner address"));
+        with_optional_properties(
+            object_schema(
+                "Multisig transaction that has not been executed yet",
+                &[
+                    (
+                        "sequence_number",
+                        integer_schema("Multisig sequence number"),
+                    ),
+                    (
+                        "creator",
+                        string_schema("Owner that proposed the transaction"),
+                    ),
+                    (
+                        "creation_time_secs",
+                        integer_schema("Unix time of the proposal"),
+                    ),
+                    (
+                        "payload",
+                        nullable(string_schema(
+                            "BCS payload as hex; null when only the hash is stored",
+                        )),
+                    ),
+                    (
+                        "payload_hash",
+                        nullable(string_schema(
+                            "Payload SHA3-256 as hex; null when the payload is stored",
+                        )),
+                    ),
+                    ("approvals", addresses("Owners that voted to approve")),
+                    ("rejections", addresses("Owners that voted to reject")),
+                ],
+            ),
+            &[("entry_function", EntryFunctionCall::output_schema())],
+        )
+    }
+}
+
+impl OutputSchema for EntryFunctionCall {
+    fn output_schema() -> Value {
+        object_schema(
+            "Entry function of the payload (`--tx` only)",
+            &[
+                ("function", string_schema("`address::module::function`")),
+                (
+                    "type_arguments",
+                    array_schema("Type arguments", string_schema("Type tag")),
+                ),
+                (
+                    "arguments",
+                    array_schema("BCS-encoded arguments", string_schema("Argument as hex")),
+                ),
+                (
+                    "decoded_arguments",
+                    nullable(array_schema(
+                        "Arguments decoded against the function ABI",
+                        json!({"description": "Argument in the node's JSON encoding"}),
+                    )),
+                ),
+            ],
+        )
+    }
+}
+
+impl OutputSchema for MultisigOverview {
+    fn output_schema() -> Value {
+        object_schema(
+            "Multisig account state and its pending transactions",
+            &[
+                (
+                    "address",
+                    string_schema("Multisig account address (64-hex)"),
+                ),
+                (
+                    "owners",
+                    array_schema("Owners of the account", string_schema("Owner address")),
+                ),
+                (
+                    "num_signatures_required",
+                    integer_schema("Approvals needed to execute a transaction"),
+                ),
+                (
+                    "last_executed_sequence_number",
+                    integer_schema("Sequence number of the last executed or rejected transaction"),
+                ),
+                (
+                    "next_sequence_number",
+                    integer_schema("Sequence number the next proposal will get"),
+                ),
+                (
+                    "pending",
+                    array_schema(
+                        "Pending transactions in sequence order",
+                        PendingTransaction::output_schema(),
+                    ),
+                ),
+            ],
+        )
+    }
+}
+
+pub(crate) fn multisig_output_schema() -> Value {
+    json!({
+        "oneOf": [
+            MultisigOverview::output_schema(),
+            array_schema(
+                "Pending transactions (`--pending-only`)",
+                PendingTransaction::output_schema(),
+            ),
+            PendingTransaction::output_schema(),
+        ]
+    })
+}
+
+/// `account multisig`: the `MultisigAccount` resource and the pending part
+/// of its `transactions` table.
+pub(crate) fn run_account_multisig(client: &AptosClient, args: &MultisigArgs) -> Result<()> {
+    let address = normalize_address(&args.address);
+    let resource = match client.get_json(&format!(
+        "/accounts/{address}/resource/{MULTISIG_ACCOUNT_TYPE}"
+    )) {
+        Ok(resource) => resource,
+        Err(err) if err.to_string().contains("status 404") => {
+            return Err(anyhow!("{address} is not a multisig account"));
+        }
+        Err(err) => return Err(err).context("failed to read MultisigAccount"),
+    };
+    let data = resource.get("data").unwrap_or(&Value::Null);
+    let handle = get_nested_string(data, &["transactions", "handle"]);
+    let read_item = |sequence_number: u64| -> Result<Value> {
+        client
+            .post_json(
+                &format!("/tables/{handle}/item"),
+                &json!({
+                    "key_type": "u64",
+                    "value_type": MULTISIG_TRANSACTION_TYPE,
+                    "key": sequence_number.to_string(),
+                }),
+            )
+            .with_context(|| format!("failed to read multisig transaction {sequence_number}"))
+    };
+
+    if let Some(sequence_number) = args.tx {
+        let (last_executed, next) = sequence_bounds(data)?;
+        if sequence_number <= last_executed || sequence_number >= next {
+            return Err(anyhow!(
+                "transaction {sequence_number} is not pending (pending range is {}..{next})",
+                last_executed + 1
+            ));
+        }
+        let mut transaction =
+            PendingTransaction::of(sequence_number, &read_item(sequence_number)?)?;
+        if let Some(payload) = transaction.payload.as_deref() {
+            let bytes = decode_hex(payload)
+                .ok_or_else(|| anyhow!("payload of transaction {sequence_number} is not hex"))?;
+            let mut call = decode_payload(&bytes)?;
+            call.decoded_arguments = decode_arguments(client, &call).ok();
+            transaction.entry_function = Some(call);
+        }
+        return crate::print_serialized(&transaction);
+    }
+
+    let overview = overview(&address, data, read_item)?;
+    if args.pending_only {
+        return crate::print_serialized(&overview.pending);
+    }
+    crate::print_serialized(&overview)
+}
+
+/// Builds the overview from the resource `data`, reading each pending
+/// transaction through `read_item`.
+fn overview(
+    address: &str,
+    data: &Value,
+    mut read_item: impl FnMut(u64) -> Result<Value>,
+) -> Result<MultisigOverview> {
+    let (last_executed, next) = sequence_bounds(data)?;
+    let pending = (last_executed + 1..next)
+        .map(|sequence_number| {
+            PendingTransaction::of(sequence_number, &read_item(sequence_number)?)
+        })
+        .collect::<Result<Vec<_>>>()?;
+    Ok(MultisigOverview {
+        address: address.to_owned(),
+        owners: data
+            .get("owners")
+            .and_then(Value::as_array)
+            .map(Vec::as_slice)
+            .unwrap_or_default()
+            .iter()
+            .filter_map(Value::as_str)
+            .map(normalize_address)
+            .collect(),
+        num_signatures_required: u64_field(data, "num_signatures_required")?,
+        last_executed_sequence_number: last_executed,
+        next_sequence_number: next,
+        pending,
+    })
+}
+
+/// `(last_executed_sequence_number, next_sequence_number)`.
+fn sequence_bounds(data: &Value) -> Result<(u64, u64)> {
+    Ok((
+        u64_field(data, "last_executed_sequence_number")?,
+        u64_field(data, "next_sequence_number")?,
+    ))
+}
+
+fn u64_field(data: &Value, key: &str) -> Result<u64> {
+    data.get(key)
+        .and_then(parse_u64)
+        .ok_or_else(|| anyhow!("unexpected {MULTISIG_ACCOUNT_TYPE} format: missing `{key}`"))
+}
+
+impl PendingTransaction {
+    /// From a `MultisigTransaction` table item, where `payload` and
+    /// `payload_hash` are `Option<vector<u8>>` and `votes` a
+    /// `SimpleMap<address, bool>`.
+    fn of(sequence_number: u64, item: &Value) -> Result<Self> {
+        let option = |key: &str| {
+            item.pointer(&format!("/{key}/vec/0"))
+                .and_then(Value::as_str)
+                .map(str::to_owned)
+        };
+        let mut approvals = Vec::new();
+        let mut rejections = Vec::new();
+        for vote in item
+            .pointer("/votes/data")
+            .and_then(Value::as_array)
+            .map(Vec::as_slice)
+            .unwrap_or_default()
+        {
+            let owner = normalize_address(&get_nested_string(vote, &["key"]));
+            match vote.get("value").and_then(Value::as_bool) {
+                Some(true) => approvals.push(owner),
+                Some(false) => rejections.push(owner),
+                None => return Err(anyhow!("unexpected vote format: {vote}")),
+            }
+        }
+        Ok(Self {
+            sequence_number,
+            creator: normalize_address(&get_nested_string(item, &["creator"])),
+            creation_time_secs: item
+                .get("creation_time_secs")
+                .and_then(parse_u64)
+                .ok_or_else(|| anyhow!("unexpected {MULTISIG_TRANSACTION_TYPE} format"))?,
+            payload: option("payload"),
+            payload_hash: option("payload_hash"),
+            approvals,
+            rejections,
+            entry_function: None,
+        })
+    }
+}
+
+/// Decodes a BCS `MultisigTransactionPayload`, whose only variant is
+/// `EntryFunction(module, function, ty_args, args)`.
+fn decode_payload(bytes: &[u8]) -> Result<EntryFunctionCall> {
+    let input = &mut &bytes[..];
+    let variant = read_uleb128(input, "payload")?;
+    if variant != 0 {
+        return Err(anyhow!(
+            "payload: unknown MultisigTransactionPayload variant {variant}"
+        ));
+    }
+    let address = take(input, 32, "payload.module.address")?;
+    let module = read_bcs_str(input, "payload.module.name")?;
+    let function = read_bcs_str(input, "payload.function")?;
+    let type_arguments = (0..read_uleb128(input, "payload.ty_args")?)
+        .map(|index| {
+            TypeTag::read_bcs(input, &format!("payload.ty_args[{index}]"))
+                .map(|tag| tag.to_string())
+        })
+        .collect::<Result<Vec<_>>>()?;
+    let arguments = (0..read_uleb128(input, "payload.args")?)
+        .map(|index| {
+            let path = format!("payload.args[{index}]");
+            let len = read_uleb128(input, &path)?;
+            Ok(format!("0x{}", hex::encode(take(input, len, &path)?)))
+        })
+        .collect::<Result<Vec<_>>>()?;
+    if !input.is_empty() {
+        return Err(anyhow!("payload: {} trailing bytes", input.len()));
+    }
+    Ok(EntryFunctionCall {
+        function: format!(
+            "{}::{module}::{function}",
+            normalize_address(&format!("0x{}", hex::encode(address)))
+        ),
+        type_arguments,
+        arguments,
+        decoded_arguments: None,
+    })
+}
+
+/// Decodes the arguments of `call` against its function's ABI.
+fn decode_arguments(client: &AptosClient, call: &EntryFunctionCall) -> Result<Vec<Value>> {
+    let (module_id, function) = call
+        .function
+        .rsplit_once("::")
+        .ok_or_else(|| anyhow!("unexpected function `{}`", call.function))?;
+    let (address, module) = module_id
+        .split_once("::")
+        .ok_or_else(|| anyhow!("unexpected function `{}`", call.function))?;
+    let module_json = client.get_json(&format!("/accounts/{address}/module/{module}"))?;
+    let params: Vec<String> = module_json
+        .pointer("/abi/exposed_functions")
+        .and_then(Value::as_array)
+        .and_then(|functions| {
+            functions
+                .iter()
+                .find(|item| item.get("name").and_then(Value::as_str) == Some(function))
+        })
+        .and_then(|item| item.get("params"))
+        .and_then(Value::as_array)
+        .ok_or_else(|| anyhow!("{} not found in module ABI", call.function))?
+        .iter()
+        .filter_map(Value::as_str)
+        .map(str::to_owned)
+        .collect();
+    let mut layouts = StructLayouts::new(|name: &str| struct_fields(client, name));
+    decode_with_params(&mut layouts, &params, call)
+}
+
+fn decode_with_params<F: FnMut(&str) -> Result<Vec<(String, String)>>>(
+    layouts: &mut StructLayouts<F>,
+    params: &[String],
+    call: &EntryFunctionCall,
+) -> Result<Vec<Value>> {
+    let type_args = call
+        .type_arguments
+        .iter()
+        .map(|tag| MoveType::parse(tag))
+        .collect::<Result<Vec<_>>>()?;
+    // The VM supplies the signers; they are not part of the payload.
+    let params: Vec<&String> = params
+        .iter()
+        .skip_while(|param| matches!(param.as_str(), "signer" | "&signer"))
+        .collect();
+    if params.len() != call.arguments.len() {
+        return Err(anyhow!(
+            "{} takes {} arguments, payload has {}",
+            call.function,
+            params.len(),
+            call.arguments.len()
+        ));
+    }
+    params
+        .iter()
+        .zip(&call.arguments)
+        .enumerate()
+        .map(|(index, (param, argument))| {
+            let path = format!("arguments[{index}]");
+            let ty = substitute(MoveType::parse(param)?, &type_args)?;
+            let bytes = decode_hex(argument).ok_or_else(|| anyhow!("{path}: not hex"))?;
+            let mut input = bytes.as_slice();
+            let value = layouts.read(&ty, &mut input, &path)?;
+            if !input.is_empty() {
+                return Err(anyhow!("{path}: {} trailing bytes", input.len()));
+            }
+            Ok(value)
+        })
+        .collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::commands::schema::validate;
+
+    fn item(payload: Option<&str>, votes: Value) -> Value {
+        json!({
+            "creator": "0xa",
+            "creation_time_secs": "1700000000",
+            "payload": {"vec": payload.into_iter().collect::<Vec<_>>()},
+            "payload_hash": {"vec": if payload.is_some() { vec![] } else { vec!["0xfeed"] }},
+            "votes": {"data": votes},
+        })
+    }
+
+    /// `0x1::aptos_account::transfer_coins<AptosCoin>(0xb, 250)`.
+    fn transfer_payload() -> Vec<u8> {
+        let mut bytes = vec![0];
+        bytes.extend([0; 31]);
+        bytes.push(1);
+        bytes.push(13);
+        bytes.extend(b"aptos_account");
+        bytes.push(14);
+        bytes.extend(b"transfer_coins");
+        bytes.push(1);
+        bytes.extend(
+            TypeTag::parse("0x1::aptos_coin::AptosCoin")
+                .unwrap()
+                .to_bcs(),
+        );
+        bytes.push(2);
+        bytes.push(32);
+        bytes.extend([0; 31]);
+        bytes.push(0xb);
+        bytes.push(8);
+        bytes.extend(250u64.to_le_bytes());
+        bytes
+    }
+
+    #[test]
+    fn lists_pending_transactions_with_votes() {
+        let data = json!({
+            "owners": ["0xa", "0xb", "0xc"],
+            "num_signatures_required": "2",
+            "last_executed_sequence_number": "4",
+            "next_sequence_number": "7",
+            "transactions": {"handle": "0x99"},
+        });
+        let mut read = Vec::new();
+        let overview = overview("0xm", &data, |sequence_number| {
+            read.push(sequence_number);
+            Ok(match sequence_number {
+                5 => item(
+                    Some("0x00"),
+                    json!([{"key": "0xa", "value": true}, {"key": "0xc", "value": false}]),
+                ),
+                _ => item(None, json!([])),
+            })
+        })
+        .unwrap();
+        assert_eq!(read, [5, 6]);
+        assert_eq!(overview.owners.len(), 3);
+        assert_eq!(overview.num_signatures_required, 2);
+        let first = &overview.pending[0];
+        assert_eq!(first.approvals, [normalize_address("0xa")]);
+        assert_eq!(first.rejections, [normalize_address("0xc")]);
+        assert_eq!(first.payload.as_deref(), Some("0x00"));
+        assert_eq!(overview.pending[1].payload_hash.as_deref(), Some("0xfeed"));
+        validate(
+            &multisig_output_schema(),
+            &serde_json::to_value(&overview).unwrap(),
+        )
+        .unwrap();
+        validate(
+            &multisig_output_schema(),
+            &serde_json::to_value(&overview.pending).unwrap(),
+        )
+        .unwrap();
+    }
+
+    #[test]
+    fn decodes_entry_function_payloads() {
+        let mut call = decode_payload(&transfer_payload()).unwrap();
+        assert_eq!(
+            call.function,
+            format!(
+                "{}::aptos_account::transfer_coins",
+                normalize_address("0x1")
+            )
+        );
+        assert_eq!(
+            call.type_arguments,
+            [format!(
+                "{}::aptos_coin::AptosCoin",
+                normalize_address("0x1")
+            )]
+        );
+        assert_eq!(call.arguments[1], "0xfa00000000000000");
+
+        let mut layouts = StructLayouts::new(|name: &str| Err(anyhow!("no layout for {name}")));
+        let params = ["&signer".to_owned(), "address".to_owned(), "u64".to_owned()];
+        call.decoded_arguments = Some(decode_with_params(&mut layouts, &params, &call).unwrap());
+        assert_eq!(
+            call.decoded_arguments,
+            Some(vec![json!("0xb"), json!("250")])
+        );
+        assert!(decode_with_params(&mut layouts, &params[..2], &call).is_err());
+
+        let mut transaction = PendingTransaction::of(5, &item(Some("0x00"), json!([]))).unwrap();
+        transaction.entry_function = Some(call);
+        validate(
+            &multisig_output_schema(),
+            &serde_json::to_value(&transaction).unwrap(),
+        )
+        .unwrap();
+
+        let mut truncated = transfer_payload();
+        truncated.pop();
+        assert!(decode_payload(&truncated).is_err());
+        assert!(decode_payload(&[1]).is_err());
+    }
+}
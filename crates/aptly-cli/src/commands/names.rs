@@ -0,0 +1,127 @@
+use aptly_aptos::AptosClient;
+use serde_json::{json, Value};
+use std::collections::HashMap;
+use std::sync::{Mutex, OnceLock};
+
+use crate::commands::common::normalize_address;
+
+/// Aptos Names router on mainnet (chain id 1).
+const MAINNET_ANS_ADDRESS: &str =
+    "0x867ed1f6bf916171b1de3ee92849b8978b7d1b9e0a8cc982a3d19d535dfd9c0c";
+/// Aptos Names router on testnet (chain id 2).
+const TESTNET_ANS_ADDRESS: &str =
+    "0x5f8fd2347449685cf41d4db97926ec3a096eaf381332be4f1318ad4d16a8497c";
+
+/// Primary Aptos Names of addresses, looked up once each per invocation.
+/// Every failure reads as "no name", so callers fall back to the address.
+pub(crate) struct AnsNames<'a> {
+    client: &'a AptosClient,
+    /// Router address for the node's chain, found on first use.
+    registry: OnceLock<Option<&'static str>>,
+    names: Mutex<HashMap<String, Option<String>>>,
+}
+
+impl<'a> AnsNames<'a> {
+    pub(crate) fn new(client: &'a AptosClient) -> Self {
+        Self {
+            client,
+            registry: OnceLock::new(),
+            names: Mutex::new(HashMap::new()),
+        }
+    }
+
+    /// `name.apt` or `sub.name.apt` set as the primary name of `address`.
+    pub(crate) fn primary_name(&self, address: &str) -> Option<String> {
+        let address = normalize_address(address);
+        if let Some(name) = self.names.lock().ok()?.get(&address) {
+            return name.clone();
+        }
+        let name = self
+            .registry()
+            .and_then(|registry| self.query_primary_name(registry, &address));
+        self.names.lock().ok()?.insert(address, name.clone());
+        name
+    }
+
+    /// Chosen by chain id rather than URL, so custom endpoints resolve too.
+    fn registry(&self) -> Option<&'static str> {
+        *self.registry.get_or_init(|| {
+            let ledger = self.client.get_json("/").ok()?;
+            registry_for_chain(ledger.get("chain_id").and_then(Value::as_u64)?)
+        })
+    }
+
+    fn query_primary_name(&self, registry: &str, address: &str) -> Option<String> {
+        let value = self
+            .client
+            .post_json(
+                "/view",
+                &json!({
+                    "function": format!("{registry}::router::get_primary_name"),
+                    "type_arguments": [],
+                    "arguments": [address],
+                }),
+            )
+            .ok()?;
+        parse_primary_name(&value)
+    }
+}
+
+fn registry_for_chain(chain_id: u64) -> Option<&'static str> {
+    match chain_id {
+        1 => Some(MAINNET_ANS_ADDRESS),
+        2 => Some(TESTNET_ANS_ADDRESS),
+        _ => None,
+    }
+}
+
+/// Decodes `router::get_primary_name`'s `(Option<String>, Option<String>)`
+/// of subdomain and domain, i.e. `[{"vec": []}, {"vec": ["name"]}]`.
+fn parse_primary_name(value: &Value) -> Option<String> {
+    let part = |index: usize| {
+        value
+            .pointer(&format!("/{index}/vec/0"))
+            .and_then(Value::as_str)
+            .filter(|part| !part.is_empty())
+    };
+    let domain = part(1)?;
+    Some(match part(0) {
+        Some(subdomain) => format!("{subdomain}.{domain}.apt"),
+        None => format!("{domain}.apt"),
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn decodes_primary_names() {
+        assert_eq!(
+            parse_primary_name(&json!([{"vec": []}, {"vec": ["aptoslabs"]}])).as_deref(),
+            Some("aptoslabs.apt")
+        );
+        assert_eq!(
+            parse_primary_name(&json!([{"vec": ["pay"]}, {"vec": ["aptoslabs"]}])).as_deref(),
+            Some("pay.aptoslabs.apt")
+        );
+        assert!(parse_primary_name(&json!([{"vec": []}, {"vec": []}])).is_none());
+        assert!(parse_primary_name(&json!({"error": "not found"})).is_none());
+    }
+
+    #[test]
+    fn registry_follows_the_chain() {
+        assert_eq!(registry_for_chain(1), Some(MAINNET_ANS_ADDRESS));
+        assert_eq!(registry_for_chain(2), Some(TESTNET_ANS_ADDRESS));
+        assert!(registry_for_chain(4).is_none(), "no ANS on localnet");
+    }
+
+    #[test]
+    fn failures_fall_back_to_no_name() {
+        // Nothing listens on port 9, so the chain id lookup fails at once.
+        let client = AptosClient::new("http://127.0.0.1:9/v1").unwrap();
+        let names = AnsNames::new(&client);
+        assert!(names.primary_name("0x1").is_none());
+        assert_eq!(names.names.lock().unwrap().len(), 1, "the miss is cached");
+    }
+}
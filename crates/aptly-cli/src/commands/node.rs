@@ -1,10 +1,23 @@
-use anyhow::Result;
-use aptly_aptos::AptosClient;
+use anyhow::{anyhow, Result};
+use aptly_aptos::{AptosClient, ClientOptions};
 use clap::{Args, Subcommand};
+use serde::Serialize;
+use serde_json::{json, Value};
+use std::thread;
+use std::time::{Duration, SystemTime, UNIX_EPOCH};
+
+use crate::commands::common::{get_nested_string, parse_duration, parse_u64};
+use crate::commands::openapi::OpenApiSpec;
+use crate::commands::poll::{poll_until, PollOptions, PollStatus};
+use crate::commands::schema::{
+    array_schema, boolean_schema, integer_schema, nullable, object_schema, string_schema,
+    OutputSchema,
+};
+use crate::ExitStatusError;
 
 #[derive(Args)]
 #[command(
-    after_help = "Examples:\n  aptly node ledger\n  aptly node health\n  aptly --rpc-url https://rpc.sentio.xyz/aptos/v1 node estimate-gas-price"
+    after_help = "Examples:\n  aptly node ledger\n  aptly node spec --summary\n  aptly node spec --paths '/accounts/*/balance/*' --full\n  aptly node health\n  aptly node health --max-stale-secs 30 --quiet\n  aptly node wait-synced --timeout 5m --min-version 1000\n  aptly node gas --limit 100 --pretty\n  aptly node compare https://api.mainnet.aptoslabs.com/v1 --pretty\n  aptly --rpc-url https://rpc.sentio.xyz/aptos/v1 node estimate-gas-price"
 )]
 pub(crate) struct NodeCommand {
     #[command(subcommand)]
@@ -15,24 +28,932 @@ pub(crate) struct NodeCommand {
 pub(crate) enum NodeSubcommand {
     #[command(about = "Get ledger info from `/`")]
     Ledger,
-    #[command(about = "Get OpenAPI spec JSON")]
-    Spec,
-    #[command(about = "Check node health")]
-    Health,
+    #[command(
+        about = "Get OpenAPI spec JSON",
+        long_about = "Get the node OpenAPI spec. With --summary or --paths, list path, method, operationId, and a one-line description instead of the raw document; add --full for the matching operations' parameters, responses, and referenced schemas."
+    )]
+    Spec(NodeSpecArgs),
+    #[command(
+        about = "Check node health",
+        long_about = "Check node health. With --max-stale-secs, also compare the ledger timestamp to wall-clock time and exit 0 when fresh, 1 when stale (the node reports its health check failed, or the ledger lags), and 4 when unreachable (connection errors, timeouts and any other error response)."
+    )]
+    Health(NodeHealthArgs),
     #[command(about = "Get node build/runtime info")]
     Info,
     #[command(name = "estimate-gas-price", about = "Estimate current gas price")]
     EstimateGasPrice,
+    #[command(
+        name = "wait-synced",
+        about = "Block until the node is healthy and caught up",
+        long_about = "Poll the ledger endpoint until the node responds and its ledger timestamp is within --max-stale-secs of wall-clock time (or its ledger version reaches --min-version). Progress goes to stderr; exits non-zero on timeout."
+    )]
+    WaitSynced(NodeWaitSyncedArgs),
+    #[command(about = "Gas price estimates plus prices paid by recent user transactions")]
+    Gas(NodeGasArgs),
+    #[command(about = "Compare ledger progress of the configured node against other endpoints")]
+    Compare(NodeCompareArgs),
+}
+
+#[derive(Args)]
+pub(crate) struct NodeSpecArgs {
+    /// Only include paths containing this text, or matching it as a `*` glob.
+    #[arg(long, value_name = "PATTERN")]
+    pub(crate) paths: Option<String>,
+    /// List every path and method with its operationId and description.
+    #[arg(long, default_value_t = false, conflicts_with = "full")]
+    pub(crate) summary: bool,
+    /// Print parameters, responses, and referenced schemas of matching paths.
+    #[arg(long, default_value_t = false, requires = "paths")]
+    pub(crate) full: bool,
+}
+
+#[derive(Args)]
+pub(crate) struct NodeHealthArgs {
+    /// Fail when the node's ledger is older than this many seconds.
+    #[arg(long)]
+    pub(crate) max_stale_secs: Option<u64>,
+    /// Print nothing; rely on the exit code.
+    #[arg(long, default_value_t = false)]
+    pub(crate) quiet: bool,
+}
+
+#[derive(Args)]
+pub(crate) struct NodeWaitSyncedArgs {
+    /// Give up after this long, e.g. `120s` or `5m`.
+    #[arg(long, default_value = "120s", value_parser = parse_duration)]
+    pub(crate) timeout: Duration,
+    /// Wait for this ledger version instead of a fresh ledger timestamp.
+    #[arg(long)]
+    pub(crate) min_version: Option<u64>,
+    /// Ledger timestamp freshness bound in seconds.
+    #[arg(long, default_value_t = 30)]
+    pub(crate) max_stale_secs: u64,
+    /// Delay before the first retry.
+    #[arg(long, default_value = "1s", value_parser = parse_duration)]
+    pub(crate) interval: Duration,
+    /// Upper bound for the retry delay.
+    #[arg(long, default_value = "10s", value_parser = parse_duration)]
+    pub(crate) max_interval: Duration,
+    /// Multiplier applied to the retry delay after each attempt.
+    #[arg(long, default_value_t = 1.5)]
+    pub(crate) backoff: f64,
+}
+
+#[derive(Args)]
+pub(crate) struct NodeCompareArgs {
+    /// Endpoints to compare; the configured `--rpc-url` is always included first.
+    #[arg(value_name = "URL", required = true)]
+    pub(crate) urls: Vec<String>,
+    /// Exit non-zero if any endpoint is more than this many versions behind.
+    #[arg(long)]
+    pub(crate) max_lag_versions: Option<u64>,
+    /// Render a table instead of JSON.
+    #[arg(long, default_value_t = false)]
+    pub(crate) pretty: bool,
+}
+
+#[derive(Args)]
+pub(crate) struct NodeGasArgs {
+    /// Number of recent transactions to scan, read 100 per request.
+    #[arg(long, default_value_t = 100)]
+    pub(crate) limit: u64,
+    /// Render a human-readable summary instead of JSON.
+    #[arg(long, default_value_t = false)]
+    pub(crate) pretty: bool,
+}
+
+/// Largest page the node serves from `/transactions`.
+const TRANSACTIONS_PAGE_LIMIT: u64 = 100;
+
+const HEALTH_EXIT_STALE: i32 = 1;
+const HEALTH_EXIT_UNREACHABLE: i32 = 4;
+
+#[derive(Debug, Clone, Serialize)]
+struct HealthReport {
+    status: String,
+    healthy: bool,
+    max_stale_secs: u64,
+    block_height: Option<u64>,
+    ledger_version: Option<u64>,
+    ledger_timestamp: Option<u64>,
+    lag_secs: Option<u64>,
+    error: Option<String>,
+}
+
+#[derive(Debug, Clone, Serialize)]
+struct EndpointLedger {
+    url: String,
+    chain_id: Option<u64>,
+    ledger_version: Option<u64>,
+    block_height: Option<u64>,
+    ledger_timestamp: Option<u64>,
+    versions_behind: Option<u64>,
+    chain_id_mismatch: bool,
+    error: Option<String>,
+}
+
+#[derive(Debug, Clone, Serialize)]
+struct GasPercentiles {
+    min: u64,
+    p10: u64,
+    p50: u64,
+    p90: u64,
+    max: u64,
+}
+
+#[derive(Debug, Clone, Serialize)]
+struct GasReport {
+    deprioritized_gas_estimate: Option<u64>,
+    gas_estimate: Option<u64>,
+    prioritized_gas_estimate: Option<u64>,
+    scanned_transactions: usize,
+    user_transactions: usize,
+    gas_unit_price: Option<GasPercentiles>,
+}
+
+impl OutputSchema for HealthReport {
+    fn output_schema() -> Value {
+        object_schema(
+            "Node freshness report (`--max-stale-secs`); exit code 0 ok, 1 stale, 4 unreachable",
+            &[
+                (
+                    "status",
+                    json!({
+                        "description": "Overall result",
+                        "type": "string",
+                        "enum": ["ok", "stale", "unreachable"],
+                    }),
+                ),
+                ("healthy", boolean_schema("Whether status is `ok`")),
+                (
+                    "max_stale_secs",
+                    integer_schema("Staleness threshold in seconds"),
+                ),
+                (
+                    "block_height",
+                    nullable(integer_schema("Node block height")),
+                ),
+                (
+                    "ledger_version",
+                    nullable(integer_schema("Node ledger version")),
+                ),
+                (
+                    "ledger_timestamp",
+                    nullable(integer_schema("Ledger timestamp in microseconds")),
+                ),
+                (
+                    "lag_secs",
+                    nullable(integer_schema(
+                        "Wall-clock seconds behind the ledger timestamp",
+                    )),
+                ),
+                ("error", nullable(string_schema("Failure details"))),
+            ],
+        )
+    }
+}
+
+pub(crate) fn health_output_schema() -> Value {
+    HealthReport::output_schema()
+}
+
+impl OutputSchema for EndpointLedger {
+    fn output_schema() -> Value {
+        object_schema(
+            "Ledger info of one endpoint relative to the most advanced endpoint",
+            &[
+                ("url", string_schema("Endpoint URL")),
+                ("chain_id", nullable(integer_schema("Chain id"))),
+                ("ledger_version", nullable(integer_schema("Ledger version"))),
+                ("block_height", nullable(integer_schema("Block height"))),
+                (
+                    "ledger_timestamp",
+                    nullable(integer_schema("Ledger timestamp in microseconds")),
+                ),
+                (
+                    "versions_behind",
+                    nullable(integer_schema("Versions behind the max observed")),
+                ),
+                (
+                    "chain_id_mismatch",
+                    boolean_schema("Chain id differs from the first reachable endpoint"),
+                ),
+                (
+                    "error",
+                    nullable(string_schema("Request failure, if unreachable")),
+                ),
+            ],
+        )
+    }
+}
+
+pub(crate) fn compare_output_schema() -> Value {
+    array_schema(
+        "One entry per endpoint; `--pretty` prints a table instead",
+        EndpointLedger::output_schema(),
+    )
+}
+
+impl OutputSchema for GasPercentiles {
+    fn output_schema() -> Value {
+        object_schema(
+            "Distribution of gas unit prices paid, in octas per gas unit",
+            &[
+                ("min", integer_schema("Lowest price paid")),
+                ("p10", integer_schema("10th percentile")),
+                ("p50", integer_schema("Median")),
+                ("p90", integer_schema("90th percentile")),
+                ("max", integer_schema("Highest price paid")),
+            ],
+        )
+    }
+}
+
+impl OutputSchema for GasReport {
+    fn output_schema() -> Value {
+        object_schema(
+            "Gas price estimates and recently observed gas unit prices; `--pretty` prints text instead",
+            &[
+                (
+                    "deprioritized_gas_estimate",
+                    nullable(integer_schema("Node low-priority estimate")),
+                ),
+                ("gas_estimate", nullable(integer_schema("Node estimate"))),
+                (
+                    "prioritized_gas_estimate",
+                    nullable(integer_schema("Node high-priority estimate")),
+                ),
+                (
+                    "scanned_transactions",
+                    integer_schema("Transactions fetched from `/transactions`"),
+                ),
+                (
+                    "user_transactions",
+                    integer_schema("User transactions among the scanned ones"),
+                ),
+                ("gas_unit_price", nullable(GasPercentiles::output_schema())),
+            ],
+        )
+    }
+}
+
+pub(crate) fn gas_output_schema() -> Value {
+    GasReport::output_schema()
 }
 
 pub(crate) fn run_node(client: &AptosClient, command: NodeCommand) -> Result<()> {
     let value = match command.command {
         NodeSubcommand::Ledger => client.get_json("/")?,
-        NodeSubcommand::Spec => client.get_json("/spec.json")?,
-        NodeSubcommand::Health => client.get_json("/-/healthy")?,
+        NodeSubcommand::Spec(args) => {
+            let spec = client.get_json("/spec.json")?;
+            if !args.summary && args.paths.is_none() {
+                spec
+            } else {
+                let spec = OpenApiSpec::from_value(spec)?;
+                return if args.full {
+                    crate::print_serialized(&spec.details(args.paths.as_deref())?)
+                } else {
+                    crate::print_serialized(&spec.summaries(args.paths.as_deref())?)
+                };
+            }
+        }
+        NodeSubcommand::Health(args) => match args.max_stale_secs {
+            Some(max_stale_secs) => return run_node_health(client, max_stale_secs, args.quiet),
+            None if args.quiet => {
+                client.get_json("/-/healthy")?;
+                return Ok(());
+            }
+            None => client.get_json("/-/healthy")?,
+        },
         NodeSubcommand::Info => client.get_json("/info")?,
         NodeSubcommand::EstimateGasPrice => client.get_json("/estimate_gas_price")?,
+        NodeSubcommand::WaitSynced(args) => wait_synced(client, &args)?,
+        NodeSubcommand::Gas(args) => return run_node_gas(client, &args),
+        NodeSubcommand::Compare(args) => return run_node_compare(client, &args),
     };
 
     crate::print_pretty_json(&value)
 }
+
+fn run_node_health(client: &AptosClient, max_stale_secs: u64, quiet: bool) -> Result<()> {
+    let report = check_node_health(client, max_stale_secs);
+    if !quiet {
+        crate::print_serialized(&report)?;
+    }
+
+    let code = match report.status.as_str() {
+        "ok" => return Ok(()),
+        "stale" => HEALTH_EXIT_STALE,
+        _ => HEALTH_EXIT_UNREACHABLE,
+    };
+    Err(ExitStatusError {
+        code,
+        message: format!(
+            "node is {}: {}",
+            report.status,
+            report.error.as_deref().unwrap_or_default()
+        ),
+    }
+    .into())
+}
+
+fn check_node_health(client: &AptosClient, max_stale_secs: u64) -> HealthReport {
+    let mut report = HealthReport {
+        status: "unreachable".to_owned(),
+        healthy: false,
+        max_stale_secs,
+        block_height: None,
+        ledger_version: None,
+        ledger_timestamp: None,
+        lag_secs: None,
+        error: None,
+    };
+
+    let ledger = match client.get_json("/") {
+        Ok(ledger) => ledger,
+        Err(err) => {
+            report.error = Some(format!("{err:#}"));
+            return report;
+        }
+    };
+    let field = |key: &str| parse_u64(ledger.get(key).unwrap_or(&Value::Null));
+    report.block_height = field("block_height");
+    report.ledger_version = field("ledger_version");
+    report.ledger_timestamp = field("ledger_timestamp");
+    report.lag_secs = report.ledger_timestamp.map(|micros| {
+        let now_secs = SystemTime::now()
+            .duration_since(UNIX_EPOCH)
+            .map(|elapsed| elapsed.as_secs())
+            .unwrap_or_default();
+        now_secs.saturating_sub(micros / 1_000_000)
+    });
+
+    if let Err(err) = client.get_json(&format!("/-/healthy?duration_secs={max_stale_secs}")) {
+        let error = format!("{err:#}");
+        // A lagging node answers 503 `health_check_failed`; resets, timeouts
+        // and other errors say nothing about its ledger.
+        if error.contains("status 503") && error.contains("health_check_failed") {
+            report.status = "stale".to_owned();
+        }
+        report.error = Some(error);
+        return report;
+    }
+    report.status = "stale".to_owned();
+    if report.lag_secs.map_or(true, |lag| lag > max_stale_secs) {
+        report.error = Some(format!(
+            "ledger is more than {max_stale_secs}s behind wall-clock time"
+        ));
+        return report;
+    }
+
+    report.status = "ok".to_owned();
+    report.healthy = true;
+    report
+}
+
+/// Polls `/` and returns the first ledger info that satisfies the target.
+fn wait_synced(client: &AptosClient, args: &NodeWaitSyncedArgs) -> Result<Value> {
+    let options = PollOptions {
+        timeout: args.timeout,
+        interval: args.interval,
+        max_interval: args.max_interval,
+        backoff: args.backoff,
+    };
+    poll_until("node to sync", &options, || {
+        let ledger = match client.get_json("/") {
+            Ok(ledger) => ledger,
+            Err(err) => return PollStatus::Pending(format!("node unreachable: {err:#}")),
+        };
+        let field = |key: &str| parse_u64(ledger.get(key).unwrap_or(&Value::Null));
+        match sync_pending_reason(
+            field("ledger_version"),
+            field("ledger_timestamp"),
+            args.min_version,
+            args.max_stale_secs,
+            SystemTime::now(),
+        ) {
+            None => PollStatus::Ready(ledger),
+            Some(reason) => PollStatus::Pending(reason),
+        }
+    })
+}
+
+fn sync_pending_reason(
+    ledger_version: Option<u64>,
+    ledger_timestamp: Option<u64>,
+    min_version: Option<u64>,
+    max_stale_secs: u64,
+    now: SystemTime,
+) -> Option<String> {
+    if let Some(min_version) = min_version {
+        return match ledger_version {
+            Some(version) if version >= min_version => None,
+            Some(version) => Some(format!("ledger version {version} < {min_version}")),
+            None => Some("ledger version missing from response".to_owned()),
+        };
+    }
+
+    let Some(micros) = ledger_timestamp else {
+        return Some("ledger timestamp missing from response".to_owned());
+    };
+    let now_secs = now
+        .duration_since(UNIX_EPOCH)
+        .map(|elapsed| elapsed.as_secs())
+        .unwrap_or_default();
+    let lag = now_secs.saturating_sub(micros / 1_000_000);
+    (lag > max_stale_secs).then(|| format!("ledger is {lag}s behind (max {max_stale_secs}s)"))
+}
+
+fn run_node_compare(client: &AptosClient, args: &NodeCompareArgs) -> Result<()> {
+    let mut urls = vec![client.base_url().to_owned()];
+    for url in &args.urls {
+        let url = url.trim().trim_end_matches('/').to_owned();
+        if !url.is_empty() && !urls.contains(&url) {
+            urls.push(url);
+        }
+    }
+
+    let options = client.options();
+    let mut ledgers: Vec<EndpointLedger> = thread::scope(|scope| {
+        let handles: Vec<_> = urls
+            .iter()
+            .map(|url| scope.spawn(move || fetch_endpoint_ledger(url, options)))
+            .collect();
+        handles
+            .into_iter()
+            .zip(&urls)
+            .map(|(handle, url)| {
+                handle.join().unwrap_or_else(|_| EndpointLedger {
+                    error: Some("ledger fetch panicked".to_owned()),
+                    ..empty_endpoint_ledger(url)
+                })
+            })
+            .collect()
+    });
+    annotate_ledger_deltas(&mut ledgers);
+
+    for ledger in ledgers.iter().filter(|ledger| ledger.chain_id_mismatch) {
+        eprintln!(
+            "WARNING: {} reports chain id {} which differs from the other endpoints",
+            ledger.url,
+            ledger.chain_id.unwrap_or_default()
+        );
+    }
+
+    if args.pretty {
+        print_pretty_compare(&ledgers);
+    } else {
+        crate::print_serialized(&ledgers)?;
+    }
+
+    if let Some(max_lag) = args.max_lag_versions {
+        let lagging: Vec<&str> = ledgers
+            .iter()
+            .filter(|ledger| {
+                ledger
+                    .versions_behind
+                    .map_or(true, |behind| behind > max_lag)
+            })
+            .map(|ledger| ledger.url.as_str())
+            .collect();
+        if !lagging.is_empty() {
+            return Err(anyhow!(
+                "endpoints more than {max_lag} versions behind or unreachable: {}",
+                lagging.join(", ")
+            ));
+        }
+    }
+
+    Ok(())
+}
+
+fn empty_endpoint_ledger(url: &str) -> EndpointLedger {
+    EndpointLedger {
+        url: url.to_owned(),
+        chain_id: None,
+        ledger_version: None,
+        block_height: None,
+        ledger_timestamp: None,
+        versions_behind: None,
+        chain_id_mismatch: false,
+        error: None,
+    }
+}
+
+/// Reads the ledger of `url` with the invocation's client `options`.
+fn fetch_endpoint_ledger(url: &str, options: &ClientOptions) -> EndpointLedger {
+    let ledger =
+        AptosClient::with_options(url, options.clone()).and_then(|client| client.get_json("/"));
+    match ledger {
+        Ok(ledger) => {
+            let field = |key: &str| get_nested_string(&ledger, &[key]).parse::<u64>().ok();
+            EndpointLedger {
+                chain_id: field("chain_id"),
+                ledger_version: field("ledger_version"),
+                block_height: field("block_height"),
+                ledger_timestamp: field("ledger_timestamp"),
+                ..empty_endpoint_ledger(url)
+            }
+        }
+        Err(err) => EndpointLedger {
+            error: Some(format!("{err:#}")),
+            ..empty_endpoint_ledger(url)
+        },
+    }
+}
+
+fn annotate_ledger_deltas(ledgers: &mut [EndpointLedger]) {
+    let max_version = ledgers
+        .iter()
+        .filter_map(|ledger| ledger.ledger_version)
+        .max();
+    let reference_chain = ledgers.iter().find_map(|ledger| ledger.chain_id);
+    for ledger in ledgers {
+        ledger.versions_behind = ledger
+            .ledger_version
+            .zip(max_version)
+            .map(|(version, max)| max - version);
+        ledger.chain_id_mismatch = matches!(
+            (ledger.chain_id, reference_chain),
+            (Some(chain), Some(reference)) if chain != reference
+        );
+    }
+}
+
+fn print_pretty_compare(ledgers: &[EndpointLedger]) {
+    let show = |value: Option<u64>| {
+        value
+            .map(|v| v.to_string())
+            .unwrap_or_else(|| "-".to_owned())
+    };
+    let url_width = ledgers
+        .iter()
+        .map(|ledger| ledger.url.len())
+        .max()
+        .unwrap_or(0)
+        .max(3);
+
+    println!(
+        "{:<url_width$}  {:>5}  {:>14}  {:>12}  {:>16}  {:>10}",
+        "URL", "CHAIN", "VERSION", "HEIGHT", "TIMESTAMP", "BEHIND"
+    );
+    for ledger in ledgers {
+        if let Some(error) = &ledger.error {
+            println!("{:<url_width$}  unreachable: {error}", ledger.url);
+            continue;
+        }
+        let chain = if ledger.chain_id_mismatch {
+            format!("{}!", show(ledger.chain_id))
+        } else {
+            show(ledger.chain_id)
+        };
+        println!(
+            "{:<url_width$}  {:>5}  {:>14}  {:>12}  {:>16}  {:>10}",
+            ledger.url,
+            chain,
+            show(ledger.ledger_version),
+            show(ledger.block_height),
+            show(ledger.ledger_timestamp),
+            show(ledger.versions_behind)
+        );
+    }
+}
+
+/// The node's `/estimate_gas_price` tiers, in octas per gas unit.
+#[derive(Debug, Clone, Copy, Default, PartialEq, Eq)]
+pub(crate) struct GasEstimate {
+    pub(crate) deprioritized: Option<u64>,
+    pub(crate) normal: Option<u64>,
+    pub(crate) prioritized: Option<u64>,
+}
+
+pub(crate) fn fetch_gas_estimate(client: &AptosClient) -> Result<GasEstimate> {
+    let estimate = client.get_json("/estimate_gas_price")?;
+    let field = |key: &str| parse_u64(estimate.get(key).unwrap_or(&Value::Null));
+    Ok(GasEstimate {
+        deprioritized: field("deprioritized_gas_estimate"),
+        normal: field("gas_estimate"),
+        prioritized: field("prioritized_gas_estimate"),
+    })
+}
+
+fn run_node_gas(client: &AptosClient, args: &NodeGasArgs) -> Result<()> {
+    let estimate = fetch_gas_estimate(client)?;
+    let tx_array = latest_ledger_transactions(args.limit, |start, limit| {
+        let path = match start {
+            Some(start) => format!("/transactions?start={start}&limit={limit}"),
+            None => format!("/transactions?limit={limit}"),
+        };
+        client
+            .get_json(&path)?
+            .as_array()
+            .cloned()
+            .ok_or_else(|| anyhow!("unexpected transactions response format"))
+    })?;
+
+    let mut prices: Vec<u64> = tx_array
+        .iter()
+        .filter(|tx| tx.get("type").and_then(Value::as_str) == Some("user_transaction"))
+        .filter_map(|tx| parse_u64(tx.get("gas_unit_price").unwrap_or(&Value::Null)))
+        .collect();
+    prices.sort_unstable();
+
+    let report = GasReport {
+        deprioritized_gas_estimate: estimate.deprioritized,
+        gas_estimate: estimate.normal,
+        prioritized_gas_estimate: estimate.prioritized,
+        scanned_transactions: tx_array.len(),
+        user_transactions: prices.len(),
+        gas_unit_price: gas_percentiles(&prices),
+    };
+
+    if args.pretty {
+        print_pretty_gas(&report);
+        return Ok(());
+    }
+
+    crate::print_serialized(&report)
+}
+
+/// Reads the newest `limit` ledger transactions, oldest first, paging back
+/// from the head. `fetch_page(None, n)` returns the newest `n` transactions
+/// and `fetch_page(Some(start), n)` the `n` from version `start`. Stops
+/// early at genesis or where the node has pruned history.
+fn latest_ledger_transactions(
+    limit: u64,
+    mut fetch_page: impl FnMut(Option<u64>, u64) -> Result<Vec<Value>>,
+) -> Result<Vec<Value>> {
+    let mut txs = fetch_page(None, limit.min(TRANSACTIONS_PAGE_LIMIT))?;
+    loop {
+        let remaining = limit.saturating_sub(txs.len() as u64);
+        let oldest = txs
+            .first()
+            .and_then(|tx| tx.get("version"))
+            .and_then(parse_u64);
+        let Some(oldest) = oldest.filter(|oldest| *oldest > 0 && remaining > 0) else {
+            return Ok(txs);
+        };
+        let size = remaining.min(TRANSACTIONS_PAGE_LIMIT).min(oldest);
+        let mut page = match fetch_page(Some(oldest - size), size) {
+            Ok(page) if !page.is_empty() => page,
+            Ok(_) => return Ok(txs),
+            Err(err) if format!("{err:#}").contains("status 410") => return Ok(txs),
+            Err(err) => return Err(err),
+        };
+        page.append(&mut txs);
+        txs = page;
+    }
+}
+
+fn gas_percentiles(sorted: &[u64]) -> Option<GasPercentiles> {
+    Some(GasPercentiles {
+        min: *sorted.first()?,
+        p10: percentile(sorted, 10),
+        p50: percentile(sorted, 50),
+        p90: percentile(sorted, 90),
+        max: *sorted.last()?,
+    })
+}
+
+/// Nearest-rank percentile over an ascending slice.
+fn percentile(sorted: &[u64], pct: usize) -> u64 {
+    if sorted.is_empty() {
+        return 0;
+    }
+    let rank = (pct * sorted.len()).div_ceil(100).max(1);
+    sorted[rank - 1]
+}
+
+fn print_pretty_gas(report: &GasReport) {
+    let show = |value: Option<u64>| {
+        value
+            .map(|v| v.to_string())
+            .unwrap_or_else(|| "-".to_owned())
+    };
+    println!("Estimates (octas/gas unit)");
+    println!(
+        "  deprioritized  {}",
+        show(report.deprioritized_gas_estimate)
+    );
+    println!("  normal         {}", show(report.gas_estimate));
+    println!("  prioritized    {}", show(report.prioritized_gas_estimate));
+    println!(
+        "Paid by last {} user txs (of {} scanned)",
+        report.user_transactions, report.scanned_transactions
+    );
+    match &report.gas_unit_price {
+        Some(prices) => {
+            println!("  min  {}", prices.min);
+            println!("  p10  {}", prices.p10);
+            println!("  p50  {}", prices.p50);
+            println!("  p90  {}", prices.p90);
+            println!("  max  {}", prices.max);
+        }
+        None => println!("  no user transactions found"),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn nearest_rank_percentiles() {
+        let prices: Vec<u64> = (1..=10).map(|n| n * 100).collect();
+        let summary = gas_percentiles(&prices).unwrap();
+        assert_eq!(
+            (
+                summary.min,
+                summary.p10,
+                summary.p50,
+                summary.p90,
+                summary.max
+            ),
+            (100, 100, 500, 900, 1000)
+        );
+        assert_eq!(percentile(&[150], 90), 150);
+        assert!(gas_percentiles(&[]).is_none());
+    }
+
+    /// Mock node answering `/` with a current ledger, then every
+    /// `/-/healthy` request with `health`, or by closing the connection when
+    /// it is `None`.
+    fn health_node(health: Option<(&'static str, &'static str)>) -> AptosClient {
+        use std::io::{Read, Write};
+        let listener = std::net::TcpListener::bind("127.0.0.1:0").unwrap();
+        let addr = listener.local_addr().unwrap();
+        let now_micros = SystemTime::now()
+            .duration_since(UNIX_EPOCH)
+            .unwrap()
+            .as_micros();
+        let ledger = format!(
+            r#"{{"block_height":"5","ledger_version":"9","ledger_timestamp":"{now_micros}"}}"#
+        );
+        thread::spawn(move || {
+            for (index, stream) in listener.incoming().enumerate() {
+                let Ok(mut stream) = stream else { break };
+                let mut buf = [0u8; 4096];
+                let _ = stream.read(&mut buf);
+                let (status, body) = match (index, health) {
+                    (0, _) => ("200 OK", ledger.as_str()),
+                    (_, Some(reply)) => reply,
+                    (_, None) => continue,
+                };
+                let response = format!(
+                    "HTTP/1.1 {status}\r\nContent-Type: application/json\r\nContent-Length: {}\r\nConnection: close\r\n\r\n{body}",
+                    body.len()
+                );
+                let _ = stream.write_all(response.as_bytes());
+            }
+        });
+        AptosClient::new(&format!("http://{addr}/v1")).unwrap()
+    }
+
+    fn health_exit_code(client: &AptosClient) -> Option<i32> {
+        run_node_health(client, 30, true)
+            .err()
+            .map(|err| err.downcast_ref::<ExitStatusError>().unwrap().code)
+    }
+
+    #[test]
+    fn health_reports_ok_for_a_current_node() {
+        let client = health_node(Some(("200 OK", r#"{"message":"aptos-node:ok"}"#)));
+        let report = check_node_health(&client, 30);
+        assert_eq!(report.status, "ok");
+        assert_eq!(report.ledger_version, Some(9));
+        assert_eq!(health_exit_code(&health_node(Some(("200 OK", "{}")))), None);
+    }
+
+    #[test]
+    fn health_is_stale_only_when_the_node_says_so() {
+        let unhealthy = (
+            "503 Service Unavailable",
+            r#"{"message":"The latest ledger info timestamp is beyond the allowed skew","error_code":"health_check_failed"}"#,
+        );
+        assert_eq!(
+            check_node_health(&health_node(Some(unhealthy)), 30).status,
+            "stale"
+        );
+        assert_eq!(
+            health_exit_code(&health_node(Some(unhealthy))),
+            Some(HEALTH_EXIT_STALE)
+        );
+    }
+
+    #[test]
+    fn health_is_unreachable_on_server_errors() {
+        let failing = ("500 Internal Server Error", r#"{"message":"oops"}"#);
+        let report = check_node_health(&health_node(Some(failing)), 30);
+        assert_eq!(report.status, "unreachable");
+        assert!(report.error.unwrap().contains("status 500"));
+        let bad_gateway = ("502 Bad Gateway", "upstream unavailable");
+        assert_eq!(
+            health_exit_code(&health_node(Some(bad_gateway))),
+            Some(HEALTH_EXIT_UNREACHABLE)
+        );
+    }
+
+    #[test]
+    fn health_is_unreachable_on_dropped_connections() {
+        let report = check_node_health(&health_node(None), 30);
+        assert_eq!(report.status, "unreachable");
+        assert!(report.error.is_some());
+        assert_eq!(
+            health_exit_code(&health_node(None)),
+            Some(HEALTH_EXIT_UNREACHABLE)
+        );
+    }
+
+    /// Page of a ledger holding versions 0..250.
+    fn ledger_page(start: Option<u64>, limit: u64) -> Result<Vec<Value>> {
+        let start = start.unwrap_or(250 - limit);
+        Ok((start..(start + limit).min(250))
+            .map(|version| json!({"version": version.to_string()}))
+            .collect())
+    }
+
+    #[test]
+    fn gas_scan_pages_back_from_the_head() {
+        let mut requests = Vec::new();
+        let txs = latest_ledger_transactions(230, |start, limit| {
+            requests.push((start, limit));
+            ledger_page(start, limit)
+        })
+        .unwrap();
+        let versions: Vec<u64> = txs
+            .iter()
+            .filter_map(|tx| parse_u64(&tx["version"]))
+            .collect();
+        assert_eq!(versions, (20..250).collect::<Vec<_>>());
+        assert_eq!(requests, [(None, 100), (Some(50), 100), (Some(20), 30)]);
+
+        requests.clear();
+        let txs = latest_ledger_transactions(400, |start, limit| {
+            requests.push((start, limit));
+            ledger_page(start, limit)
+        })
+        .unwrap();
+        assert_eq!(txs.len(), 250, "stops at genesis");
+        assert_eq!(requests.last(), Some(&(Some(0), 50)));
+        assert_eq!(latest_ledger_transactions(5, ledger_page).unwrap().len(), 5);
+    }
+
+    #[test]
+    fn sync_requires_min_version_or_fresh_timestamp() {
+        let now = UNIX_EPOCH + Duration::from_secs(1_000);
+        let fresh = Some(995 * 1_000_000);
+        let stale = Some(900 * 1_000_000);
+
+        assert_eq!(sync_pending_reason(Some(1), fresh, None, 30, now), None);
+        assert_eq!(
+            sync_pending_reason(Some(1), stale, None, 30, now).as_deref(),
+            Some("ledger is 100s behind (max 30s)")
+        );
+        assert_eq!(
+            sync_pending_reason(Some(50), stale, Some(50), 30, now),
+            None
+        );
+        assert!(sync_pending_reason(Some(49), fresh, Some(50), 30, now).is_some());
+    }
+
+    #[test]
+    fn annotates_lag_and_chain_mismatch() {
+        let ledger = |url: &str, chain_id: u64, version: u64| EndpointLedger {
+            chain_id: Some(chain_id),
+            ledger_version: Some(version),
+            ..empty_endpoint_ledger(url)
+        };
+        let mut ledgers = vec![
+            ledger("a", 1, 100),
+            ledger("b", 1, 130),
+            ledger("c", 2, 5),
+            EndpointLedger {
+                error: Some("connection refused".to_owned()),
+                ..empty_endpoint_ledger("d")
+            },
+        ];
+        annotate_ledger_deltas(&mut ledgers);
+
+        let behind: Vec<Option<u64>> = ledgers.iter().map(|l| l.versions_behind).collect();
+        assert_eq!(behind, vec![Some(30), Some(0), Some(125), None]);
+        let mismatched: Vec<bool> = ledgers.iter().map(|l| l.chain_id_mismatch).collect();
+        assert_eq!(mismatched, vec![false, false, true, false]);
+
+        let value = serde_json::to_value(&ledgers).unwrap();
+        crate::commands::schema::validate(&compare_output_schema(), &value).unwrap();
+    }
+
+    #[test]
+    fn gas_report_matches_schema() {
+        let report = GasReport {
+            deprioritized_gas_estimate: Some(100),
+            gas_estimate: Some(100),
+            prioritized_gas_estimate: None,
+            scanned_transactions: 3,
+            user_transactions: 2,
+            gas_unit_price: gas_percentiles(&[100, 150]),
+        };
+        let value = serde_json::to_value(&report).unwrap();
+        crate::commands::schema::validate(&gas_output_schema(), &value).unwrap();
+    }
+}
@@ -0,0 +1,421 @@
+use anyhow::{Context, Result};
+use aptly_aptos::AptosClient;
+use clap::Args;
+use serde::Serialize;
+use serde_json::{json, Value};
+use std::collections::HashSet;
+
+use crate::commands::account_txs::{account_transactions, TxOrder};
+use crate::commands::common::{get_nested_string, glob_matches, normalize_address};
+use crate::commands::indexer::Indexer;
+use crate::commands::schema::{
+    array_schema, boolean_schema, object_schema, string_schema, OutputSchema,
+};
+
+const OBJECT_CORE_TYPE: &str = "0x1::object::ObjectCore";
+/// Objects read from the indexer per request.
+const OBJECTS_PAGE_LIMIT: u64 = 100;
+/// Objects whose resources are read before `--type` gives up looking for
+/// more matches.
+const OBJECTS_SCAN_CAP: u64 = 1_000;
+/// Recent transactions scanned for `ObjectCore` writes without an indexer.
+const FALLBACK_TRANSACTIONS: u64 = 500;
+
+#[derive(Args)]
+pub(crate) struct ObjectsArgs {
+    /// Owner address (`0x...`).
+    #[arg(value_name = "ADDRESS")]
+    pub(crate) address: String,
+    /// Maximum number of objects to return, most recently changed first.
+    #[arg(long, default_value_t = 25)]
+    pub(crate) limit: u64,
+    /// Keep objects holding a resource whose type matches this pattern,
+    /// where `*` matches anything (e.g. `0x1::fungible_asset::FungibleStore`
+    /// or `*::token::Token`). Repeat to keep matches of any pattern.
+    #[arg(long = "type", value_name = "PATTERN")]
+    pub(crate) types: Vec<String>,
+    /// Print one line per object instead of JSON.
+    #[arg(long, default_value_t = false)]
+    pub(crate) pretty: bool,
+    /// Indexer GraphQL endpoint. Defaults to the indexer of the network
+    /// selected with `--network` (or whose default `--rpc-url` is in use).
+    #[arg(long, value_name = "URL")]
+    pub(crate) indexer_url: Option<String>,
+}
+
+#[derive(Debug, Clone, PartialEq, Eq, Serialize)]
+struct OwnedObjects {
+    /// `indexer` or `transactions`.
+    source: &'static str,
+    /// Whether objects may be missing: found by scanning recent
+    /// transactions, or `--type` stopped at the scan cap.
+    partial: bool,
+    objects: Vec<OwnedObject>,
+}
+
+#[derive(Debug, Clone, PartialEq, Eq, Serialize)]
+struct OwnedObject {
+    address: String,
+    is_deleted: bool,
+    /// Resource types at the object other than `ObjectCore`.
+    types: Vec<String>,
+}
+
+impl OutputSchema for OwnedObjects {
+    fn output_schema() -> Value {
+        object_schema(
+            "Objects owned by the account",
+            &[
+                (
+                    "source",
+                    string_schema(
+                        "`indexer`, or `transactions` when recent transactions were scanned instead",
+                    ),
+                ),
+                (
+                    "partial",
+                    boolean_schema("Whether owned objects may be missing from the list"),
+                ),
+                (
+                    "objects",
+                    array_schema(
+                        "Owned objects, most recently changed first",
+                        object_schema(
+                            "Owned object",
+                            &[
+                                ("address", string_schema("Object address (64-hex)")),
+                                (
+                                    "is_deleted",
+                                    boolean_schema("Whether the object has been deleted"),
+                                ),
+                                (
+                                    "types",
+                                    array_schema(
+                                        "Resource types at the object other than ObjectCore",
+                                        string_schema("Struct type"),
+                                    ),
+                                ),
+                            ],
+                        ),
+                    ),
+                ),
+            ],
+        )
+    }
+}
+
+pub(crate) fn objects_output_schema() -> Value {
+    OwnedObjects::output_schema()
+}
+
+/// `account objects`: owned objects from the indexer's `current_objects`,
+/// or from `ObjectCore` writes in the account's recent transactions when no
+/// indexer is known for the node.
+pub(crate) fn run_account_objects(client: &AptosClient, args: &ObjectsArgs) -> Result<()> {
+    let owner = normalize_address(&args.address);
+    let types_of = |address: &str| object_types(client, address);
+    let listing = match Indexer::for_node(client, args.indexer_url.as_deref()) {
+        Ok(indexer) => {
+            let (objects, capped) = collect_objects(
+                args.limit,
+                &args.types,
+                |offset| indexer.owned_objects(&owner, offset, OBJECTS_PAGE_LIMIT),
+                types_of,
+            )?;
+            OwnedObjects {
+                source: "indexer",
+                partial: capped,
+                objects,
+            }
+        }
+        Err(err) => {
+            eprintln!(
+                "note: {err:#}; scanning the last {FALLBACK_TRANSACTIONS} transactions of {owner} instead (partial)"
+            );
+            let transactions = match account_transactions(
+                client,
+                &owner,
+                None,
+                Some(FALLBACK_TRANSACTIONS),
+                TxOrder::Desc,
+            ) {
+                Ok(transactions) => transactions,
+                Err(err) if format!("{err:#}").contains("status 404") => Vec::new(),
+                Err(err) => return Err(err),
+            };
+            let mut candidates = Some(objects_from_transactions(&owner, &transactions));
+            let (objects, _) = collect_objects(
+                args.limit,
+                &args.types,
+                |_| Ok(candidates.take().unwrap_or_default()),
+                types_of,
+            )?;
+            OwnedObjects {
+                source: "transactions",
+                partial: true,
+                objects,
+            }
+        }
+    };
+    if args.pretty {
+        for line in pretty_object_lines(&listing) {
+            println!("{line}");
+        }
+        return Ok(());
+    }
+    crate::print_serialized(&listing)
+}
+
+/// Reads `(address, is_deleted)` pages through `next_page(offset)` until
+/// `limit` objects are kept. With `patterns`, only objects holding a
+/// matching resource are kept, and the scan stops after `OBJECTS_SCAN_CAP`
+/// objects; the flag is whether it did.
+fn collect_objects(
+    limit: u64,
+    patterns: &[String],
+    mut next_page: impl FnMut(u64) -> Result<Vec<(String, bool)>>,
+    mut types_of: impl FnMut(&str) -> Result<Vec<String>>,
+) -> Result<(Vec<OwnedObject>, bool)> {
+    let mut objects = Vec::new();
+    let mut scanned = 0;
+    while (objects.len() as u64) < limit {
+        if !patterns.is_empty() && scanned >= OBJECTS_SCAN_CAP {
+            eprintln!(
+                "note: stopped after {OBJECTS_SCAN_CAP} objects; {} matched --type",
+                objects.len()
+            );
+            return Ok((objects, true));
+        }
+        let page = next_page(scanned)?;
+        if page.is_empty() {
+            break;
+        }
+        scanned += page.len() as u64;
+        for (address, is_deleted) in page {
+            let types = if is_deleted {
+                Vec::new()
+            } else {
+                types_of(&address)?
+            };
+            let keep = patterns.is_empty()
+                || types
+                    .iter()
+                    .any(|ty| patterns.iter().any(|pattern| glob_matches(pattern, ty)));
+            if keep {
+                objects.push(OwnedObject {
+                    address: normalize_address(&address),
+                    is_deleted,
+                    types,
+                });
+                if objects.len() as u64 >= limit {
+                    break;
+                }
+            }
+        }
+    }
+    Ok((objects, false))
+}
+
+/// Objects whose newest `ObjectCore` change in `transactions` (newest
+/// first) leaves them owned by `owner`, as `(address, is_deleted)`.
+fn objects_from_transactions(owner: &str, transactions: &[Value]) -> Vec<(String, bool)> {
+    let mut seen = HashSet::new();
+    let mut objects = Vec::new();
+    for tx in transactions {
+        let changes = tx
+            .get("changes")
+            .and_then(Value::as_array)
+            .map(Vec::as_slice)
+            .unwrap_or_default();
+        for change in changes {
+            let address = normalize_address(&get_nested_string(change, &["address"]));
+            let state = match get_nested_string(change, &["type"]).as_str() {
+                "write_resource"
+                    if get_nested_string(change, &["data", "type"]) == OBJECT_CORE_TYPE =>
+                {
+                    let current =
+                        normalize_address(&get_nested_string(change, &["data", "data", "owner"]));
+                    Some(current == owner)
+                }
+                "delete_resource"
+                    if get_nested_string(change, &["resource"]) == OBJECT_CORE_TYPE =>
+                {
+                    None
+                }
+                _ => continue,
+            };
+            if !seen.insert(address.clone()) {
+                continue;
+            }
+            match state {
+                Some(true) => objects.push((address, false)),
+                Some(false) => {}
+                None => objects.push((address, true)),
+            }
+        }
+    }
+    objects
+}
+
+/// Resource types at an object, without `ObjectCore`; none once deleted.
+fn object_types(client: &AptosClient, address: &str) -> Result<Vec<String>> {
+    let resources = match client.get_json(&format!("/accounts/{address}/resources")) {
+        Ok(resources) => resources,
+        Err(err) if err.to_string().contains("status 404") => json!([]),
+        Err(err) => {
+            return Err(err).with_context(|| format!("failed to read resources of {address}"))
+        }
+    };
+    Ok(resources
+        .as_array()
+        .map(Vec::as_slice)
+        .unwrap_or_default()
+        .iter()
+        .map(|resource| get_nested_string(resource, &["type"]))
+        .filter(|ty| ty != OBJECT_CORE_TYPE)
+        .collect())
+}
+
+/// `--pretty`: one line per object, and a closing note when the list may
+/// be incomplete.
+fn pretty_object_lines(listing: &OwnedObjects) -> Vec<String> {
+    let mut lines: Vec<String> = listing
+        .objects
+        .iter()
+        .map(|object| {
+            let types = if object.is_deleted {
+                "(deleted)".to_owned()
+            } else if object.types.is_empty() {
+                "-".to_owned()
+            } else {
+                object.types.join(", ")
+            };
+            format!("{}  {types}", object.address)
+        })
+        .collect();
+    if lines.is_empty() {
+        lines.push("no objects".to_owned());
+    }
+    if listing.partial {
+        lines.push(match listing.source {
+            "transactions" => format!(
+                "(partial: found in the last {FALLBACK_TRANSACTIONS} transactions; pass --indexer-url for the full list)"
+            ),
+            _ => format!("(partial: stopped after {OBJECTS_SCAN_CAP} objects)"),
+        });
+    }
+    lines
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::commands::schema::validate;
+
+    #[test]
+    fn filters_objects_by_resource_type() {
+        let store = "0x1::fungible_asset::FungibleStore";
+        let mut pages = vec![
+            vec![
+                ("0xa1".to_owned(), false),
+                ("0xa2".to_owned(), true),
+                ("0xa3".to_owned(), false),
+            ],
+            vec![("0xa4".to_owned(), false)],
+        ]
+        .into_iter();
+        let mut offsets = Vec::new();
+        let (objects, capped) = collect_objects(
+            5,
+            &[store.to_owned()],
+            |offset| {
+                offsets.push(offset);
+                Ok(pages.next().unwrap_or_default())
+            },
+            |address| {
+                Ok(match address {
+                    "0xa2" => unreachable!("deleted objects are not read"),
+                    "0xa3" => vec!["0x4::token::Token".to_owned()],
+                    _ => vec![store.to_owned()],
+                })
+            },
+        )
+        .unwrap();
+        assert!(!capped);
+        assert_eq!(offsets, [0, 3, 4]);
+        let addresses: Vec<&str> = objects.iter().map(|object| &object.address[62..]).collect();
+        assert_eq!(addresses, ["00a1", "00a4"]);
+
+        let (objects, _) = collect_objects(
+            2,
+            &[],
+            |_| {
+                Ok(vec![
+                    ("0xa1".to_owned(), false),
+                    ("0xa2".to_owned(), true),
+                    ("0xa3".to_owned(), false),
+                ])
+            },
+            |_| Ok(Vec::new()),
+        )
+        .unwrap();
+        assert_eq!(objects.len(), 2);
+        assert!(objects[1].is_deleted);
+
+        let listing = OwnedObjects {
+            source: "indexer",
+            partial: false,
+            objects,
+        };
+        validate(
+            &objects_output_schema(),
+            &serde_json::to_value(&listing).unwrap(),
+        )
+        .unwrap();
+        assert_eq!(
+            pretty_object_lines(&listing)[1],
+            format!("{}  (deleted)", normalize_address("0xa2"))
+        );
+    }
+
+    #[test]
+    fn finds_owned_objects_in_transactions() {
+        let owner = normalize_address("0xa11ce");
+        let core = |address: &str, owner: &str| {
+            json!({
+                "type": "write_resource",
+                "address": address,
+                "data": {"type": OBJECT_CORE_TYPE, "data": {"owner": owner}},
+            })
+        };
+        // Newest first: 0xb2 was transferred away after being created.
+        let transactions = [
+            json!({"changes": [
+                core("0xb2", "0xbeef"),
+                {"type": "delete_resource", "address": "0xb3", "resource": OBJECT_CORE_TYPE},
+            ]}),
+            json!({"changes": [
+                core("0xb1", "0xa11ce"),
+                core("0xb2", "0xa11ce"),
+                core("0xb3", "0xa11ce"),
+                {"type": "write_resource", "address": "0xb4", "data": {"type": "0x1::coin::CoinStore<0x1::aptos_coin::AptosCoin>", "data": {}}},
+            ]}),
+        ];
+        assert_eq!(
+            objects_from_transactions(&owner, &transactions),
+            [
+                (normalize_address("0xb3"), true),
+                (normalize_address("0xb1"), false),
+            ]
+        );
+
+        let listing = OwnedObjects {
+            source: "transactions",
+            partial: true,
+            objects: Vec::new(),
+        };
+        let lines = pretty_object_lines(&listing);
+        assert_eq!(lines[0], "no objects");
+        assert!(lines[1].starts_with("(partial: found in the last 500 transactions"));
+    }
+}
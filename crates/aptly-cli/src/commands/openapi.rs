@@ -0,0 +1,371 @@
+use anyhow::{Context, Result};
+use serde::{Deserialize, Serialize};
+use serde_json::{json, Map, Value};
+use std::collections::BTreeMap;
+
+use crate::commands::common::glob_matches;
+use crate::commands::schema::{array_schema, nullable, object_schema, string_schema, OutputSchema};
+
+/// HTTP methods an OpenAPI path item may define, in display order.
+const HTTP_METHODS: &[&str] = &[
+    "get", "post", "put", "patch", "delete", "head", "options", "trace",
+];
+const SCHEMA_REF_PREFIX: &str = "#/components/schemas/";
+
+/// The parts of a node OpenAPI document needed to answer "does this node
+/// support endpoint X"; everything else is ignored while parsing.
+#[derive(Debug, Deserialize)]
+pub(crate) struct OpenApiSpec {
+    #[serde(default)]
+    paths: BTreeMap<String, BTreeMap<String, Value>>,
+    #[serde(default)]
+    components: Components,
+}
+
+#[derive(Debug, Default, Deserialize)]
+struct Components {
+    #[serde(default)]
+    schemas: Map<String, Value>,
+}
+
+#[derive(Debug, Deserialize)]
+struct Operation {
+    #[serde(rename = "operationId")]
+    operation_id: Option<String>,
+    summary: Option<String>,
+    description: Option<String>,
+    #[serde(default)]
+    parameters: Vec<Value>,
+    #[serde(rename = "requestBody")]
+    request_body: Option<Value>,
+    #[serde(default)]
+    responses: Map<String, Value>,
+}
+
+#[derive(Debug, Clone, Serialize)]
+pub(crate) struct OperationSummary {
+    path: String,
+    method: String,
+    operation_id: Option<String>,
+    summary: Option<String>,
+}
+
+#[derive(Debug, Clone, Serialize)]
+pub(crate) struct OperationDetail {
+    path: String,
+    method: String,
+    operation_id: Option<String>,
+    summary: Option<String>,
+    parameters: Vec<Value>,
+    request_body: Option<Value>,
+    responses: Map<String, Value>,
+}
+
+/// `--full` output: matching operations plus every component schema they
+/// reference, directly or transitively.
+#[derive(Debug, Clone, Serialize)]
+pub(crate) struct SpecDetail {
+    operations: Vec<OperationDetail>,
+    schemas: Map<String, Value>,
+}
+
+impl OutputSchema for OperationSummary {
+    fn output_schema() -> Value {
+        object_schema(
+            "One path and method of the node API",
+            &[
+                (
+                    "path",
+                    string_schema("Path template, e.g. `/accounts/{address}`"),
+                ),
+                ("method", string_schema("Upper-case HTTP method")),
+                (
+                    "operation_id",
+                    nullable(string_schema("OpenAPI operationId")),
+                ),
+                (
+                    "summary",
+                    nullable(string_schema("One-line description of the operation")),
+                ),
+            ],
+        )
+    }
+}
+
+impl OutputSchema for SpecDetail {
+    fn output_schema() -> Value {
+        let operation = object_schema(
+            "Operation with its full parameter and response definitions",
+            &[
+                ("path", string_schema("Path template")),
+                ("method", string_schema("Upper-case HTTP method")),
+                (
+                    "operation_id",
+                    nullable(string_schema("OpenAPI operationId")),
+                ),
+                ("summary", nullable(string_schema("One-line description"))),
+                (
+                    "parameters",
+                    json!({ "description": "OpenAPI parameter objects", "type": "array" }),
+                ),
+                (
+                    "request_body",
+                    json!({ "description": "OpenAPI request body", "type": ["object", "null"] }),
+                ),
+                (
+                    "responses",
+                    json!({ "description": "OpenAPI responses keyed by status", "type": "object" }),
+                ),
+            ],
+        );
+        object_schema(
+            "Operations matching `--paths` with referenced component schemas",
+            &[
+                ("operations", array_schema("Matching operations", operation)),
+                (
+                    "schemas",
+                    json!({
+                        "description": "Referenced `#/components/schemas` entries by name",
+                        "type": "object",
+                    }),
+                ),
+            ],
+        )
+    }
+}
+
+pub(crate) fn summary_output_schema() -> Value {
+    array_schema(
+        "Operations listed by `--summary` or `--paths`",
+        OperationSummary::output_schema(),
+    )
+}
+
+pub(crate) fn detail_output_schema() -> Value {
+    SpecDetail::output_schema()
+}
+
+impl OpenApiSpec {
+    pub(crate) fn from_value(value: Value) -> Result<Self> {
+        serde_json::from_value(value).context("failed to parse OpenAPI spec")
+    }
+
+    /// Operations whose path matches `pattern` (all when `None`), sorted by
+    /// path then method.
+    pub(crate) fn summaries(&self, pattern: Option<&str>) -> Result<Vec<OperationSummary>> {
+        Ok(self
+            .operations(pattern)?
+            .into_iter()
+            .map(|(path, method, operation)| OperationSummary {
+                path: path.to_owned(),
+                method: method.to_ascii_uppercase(),
+                operation_id: operation.operation_id.clone(),
+                summary: one_line(&operation),
+            })
+            .collect())
+    }
+
+    pub(crate) fn details(&self, pattern: Option<&str>) -> Result<SpecDetail> {
+        let mut operations = Vec::new();
+        let mut schemas = Map::new();
+        for (path, method, operation) in self.operations(pattern)? {
+            let detail = OperationDetail {
+                path: path.to_owned(),
+                method: method.to_ascii_uppercase(),
+                operation_id: operation.operation_id.clone(),
+                summary: one_line(&operation),
+                parameters: operation.parameters,
+                request_body: operation.request_body,
+                responses: operation.responses,
+            };
+            let mut pending = Vec::new();
+            collect_schema_refs(&serde_json::to_value(&detail)?, &mut pending);
+            while let Some(name) = pending.pop() {
+                if schemas.contains_key(&name) {
+                    continue;
+                }
+                if let Some(schema) = self.components.schemas.get(&name) {
+                    collect_schema_refs(schema, &mut pending);
+                    schemas.insert(name, schema.clone());
+                }
+            }
+            operations.push(detail);
+        }
+        Ok(SpecDetail {
+            operations,
+            schemas,
+        })
+    }
+
+    fn operations(&self, pattern: Option<&str>) -> Result<Vec<(&str, &'static str, Operation)>> {
+        let mut operations = Vec::new();
+        for (path, item) in &self.paths {
+            if !pattern.map_or(true, |pattern| path_matches(pattern, path)) {
+                continue;
+            }
+            for method in HTTP_METHODS {
+                let Some(operation) = item.get(*method) else {
+                    continue;
+                };
+                let operation: Operation = serde_json::from_value(operation.clone())
+                    .with_context(|| format!("failed to parse {} {path}", method.to_uppercase()))?;
+                operations.push((path.as_str(), *method, operation));
+            }
+        }
+        Ok(operations)
+    }
+}
+
+fn one_line(operation: &Operation) -> Option<String> {
+    operation
+        .summary
+        .as_deref()
+        .or(operation.description.as_deref())
+        .and_then(|text| text.lines().map(str::trim).find(|line| !line.is_empty()))
+        .map(str::to_owned)
+}
+
+fn collect_schema_refs(value: &Value, names: &mut Vec<String>) {
+    match value {
+        Value::Object(object) => {
+            for (key, field) in object {
+                match field
+                    .as_str()
+                    .and_then(|r| r.strip_prefix(SCHEMA_REF_PREFIX))
+                {
+                    Some(name) if key == "$ref" => names.push(name.to_owned()),
+                    _ => collect_schema_refs(field, names),
+                }
+            }
+        }
+        Value::Array(items) => items
+            .iter()
+            .for_each(|item| collect_schema_refs(item, names)),
+        _ => {}
+    }
+}
+
+/// Matches a path template against a `*` glob, or as a substring when the
+/// pattern has no wildcard.
+fn path_matches(pattern: &str, path: &str) -> bool {
+    if pattern.contains('*') {
+        glob_matches(pattern, path)
+    } else {
+        path.contains(pattern)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::commands::schema::validate;
+
+    /// Trimmed from a real node `/spec.json`.
+    const SPEC_FIXTURE: &str = r##"{
+        "openapi": "3.0.0",
+        "info": { "title": "Aptos Node API", "version": "1.2.0" },
+        "paths": {
+            "/accounts/{address}": {
+                "get": {
+                    "operationId": "get_account",
+                    "summary": "Get account",
+                    "description": "Return the authentication key and the sequence number for an account.",
+                    "parameters": [
+                        { "name": "address", "in": "path", "required": true,
+                          "schema": { "$ref": "#/components/schemas/Address" } }
+                    ],
+                    "responses": {
+                        "200": { "content": { "application/json": {
+                            "schema": { "$ref": "#/components/schemas/AccountData" } } } }
+                    }
+                }
+            },
+            "/accounts/{address}/resources": {
+                "get": { "operationId": "get_account_resources", "summary": "Get account resources" }
+            },
+            "/transactions": {
+                "get": { "operationId": "get_transactions", "summary": "Get transactions" },
+                "post": {
+                    "operationId": "submit_transaction",
+                    "description": "Submit transaction\n\nThis endpoint accepts transaction submissions in two formats."
+                }
+            }
+        },
+        "components": {
+            "schemas": {
+                "Address": { "type": "string" },
+                "AccountData": {
+                    "type": "object",
+                    "properties": {
+                        "sequence_number": { "$ref": "#/components/schemas/U64" },
+                        "authentication_key": { "type": "string" }
+                    }
+                },
+                "U64": { "type": "string" },
+                "Unused": { "type": "string" }
+            }
+        }
+    }"##;
+
+    fn fixture() -> OpenApiSpec {
+        OpenApiSpec::from_value(serde_json::from_str(SPEC_FIXTURE).unwrap()).unwrap()
+    }
+
+    #[test]
+    fn summarizes_every_operation() {
+        let summaries = fixture().summaries(None).unwrap();
+        let lines: Vec<String> = summaries
+            .iter()
+            .map(|op| format!("{} {} {:?}", op.method, op.path, op.summary))
+            .collect();
+        assert_eq!(
+            lines,
+            vec![
+                r#"GET /accounts/{address} Some("Get account")"#,
+                r#"GET /accounts/{address}/resources Some("Get account resources")"#,
+                r#"GET /transactions Some("Get transactions")"#,
+                r#"POST /transactions Some("Submit transaction")"#,
+            ]
+        );
+
+        let value = serde_json::to_value(&summaries).unwrap();
+        for item in value.as_array().unwrap() {
+            validate(&OperationSummary::output_schema(), item).unwrap();
+        }
+    }
+
+    #[test]
+    fn filters_paths_by_substring_or_glob() {
+        let spec = fixture();
+        let paths = |pattern| -> Vec<String> {
+            spec.summaries(Some(pattern))
+                .unwrap()
+                .into_iter()
+                .map(|op| op.path)
+                .collect()
+        };
+        assert_eq!(
+            paths("transactions"),
+            vec!["/transactions", "/transactions"]
+        );
+        assert_eq!(
+            paths("/accounts/*"),
+            vec!["/accounts/{address}", "/accounts/{address}/resources"]
+        );
+        assert_eq!(paths("/accounts/*}"), vec!["/accounts/{address}"]);
+        assert!(paths("/blocks*").is_empty());
+    }
+
+    #[test]
+    fn full_details_include_transitive_schema_refs() {
+        let detail = fixture().details(Some("/accounts/*}")).unwrap();
+        assert_eq!(detail.operations.len(), 1);
+        assert_eq!(detail.operations[0].parameters[0]["name"], "address");
+        let names: Vec<&String> = detail.schemas.keys().collect();
+        assert_eq!(names, vec!["AccountData", "Address", "U64"]);
+
+        let value = serde_json::to_value(&detail).unwrap();
+        validate(&SpecDetail::output_schema(), &value).unwrap();
+        assert_eq!(value["operations"][0]["request_body"], json!(null));
+    }
+}
@@ -1,9 +1,13 @@
+use crate::commands::schema::{
+    array_schema, boolean_schema, nullable, object_schema, string_schema,
+};
 use crate::plugin_tools::{
     discover_aptos_script_compose, discover_aptos_tracer, discover_move_decompiler,
     doctor_aptos_script_compose, doctor_aptos_tracer, doctor_move_decompiler,
 };
 use anyhow::{anyhow, Result};
 use clap::{Args, Subcommand};
+use serde_json::Value;
 
 #[derive(Args)]
 #[command(
@@ -63,3 +67,64 @@ pub(crate) fn run_plugin(command: PluginCommand) -> Result<()> {
         }
     }
 }
+
+fn plugin_status_schema() -> Value {
+    object_schema(
+        "Discovered plugin binary",
+        &[
+            ("name", string_schema("Plugin name")),
+            ("description", string_schema("Plugin description")),
+            ("installed", boolean_schema("Whether the binary was found")),
+            (
+                "binary_path",
+                nullable(string_schema("Resolved binary path")),
+            ),
+            (
+                "source",
+                nullable(string_schema("Where the binary was found (flag or PATH)")),
+            ),
+        ],
+    )
+}
+
+pub(crate) fn list_output_schema() -> Value {
+    array_schema("Discovered plugins", plugin_status_schema())
+}
+
+pub(crate) fn doctor_output_schema() -> Value {
+    let check = object_schema(
+        "Single health check",
+        &[
+            ("name", string_schema("Check name")),
+            ("ok", boolean_schema("Whether the check passed")),
+            ("message", string_schema("Check result details")),
+        ],
+    );
+    let report = object_schema(
+        "Health report for one plugin",
+        &[
+            ("plugin", plugin_status_schema()),
+            ("checks", array_schema("Health checks", check)),
+            (
+                "install_hint",
+                nullable(string_schema("Remediation steps when a check fails")),
+            ),
+        ],
+    );
+    array_schema("Plugin health reports", report)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn plugin_outputs_match_schema() {
+        let validate = crate::commands::schema::validate;
+        let list = serde_json::to_value(vec![discover_move_decompiler(None)]).unwrap();
+        validate(&list_output_schema(), &list).unwrap();
+
+        let doctor = serde_json::to_value(vec![doctor_aptos_tracer(None)]).unwrap();
+        validate(&doctor_output_schema(), &doctor).unwrap();
+    }
+}
@@ -0,0 +1,91 @@
+use anyhow::{anyhow, Result};
+use std::thread;
+use std::time::{Duration, Instant};
+
+/// Timing for [`poll_until`]: the delay starts at `interval` and grows by
+/// `backoff` after every pending check, capped at `max_interval`.
+#[derive(Debug, Clone)]
+pub(crate) struct PollOptions {
+    pub(crate) timeout: Duration,
+    pub(crate) interval: Duration,
+    pub(crate) max_interval: Duration,
+    pub(crate) backoff: f64,
+}
+
+pub(crate) enum PollStatus<T> {
+    Ready(T),
+    /// Not ready yet; the message is printed as a progress line.
+    Pending(String),
+}
+
+/// Calls `check` until it reports ready or `timeout` elapses, printing one
+/// progress line per pending check to stderr.
+pub(crate) fn poll_until<T>(
+    what: &str,
+    options: &PollOptions,
+    mut check: impl FnMut() -> PollStatus<T>,
+) -> Result<T> {
+    let start = Instant::now();
+    let mut delay = options.interval;
+    loop {
+        let reason = match check() {
+            PollStatus::Ready(value) => return Ok(value),
+            PollStatus::Pending(reason) => reason,
+        };
+
+        let elapsed = start.elapsed();
+        if elapsed >= options.timeout {
+            return Err(anyhow!(
+                "timed out after {:.1}s waiting for {what}: {reason}",
+                elapsed.as_secs_f64()
+            ));
+        }
+        eprintln!(
+            "waiting for {what} ({:.1}s elapsed): {reason}",
+            elapsed.as_secs_f64()
+        );
+
+        thread::sleep(delay.min(options.timeout - elapsed));
+        delay = delay
+            .mul_f64(options.backoff.max(1.0))
+            .min(options.max_interval);
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn options(timeout_ms: u64) -> PollOptions {
+        PollOptions {
+            timeout: Duration::from_millis(timeout_ms),
+            interval: Duration::from_millis(1),
+            max_interval: Duration::from_millis(4),
+            backoff: 2.0,
+        }
+    }
+
+    #[test]
+    fn returns_once_ready() {
+        let mut calls = 0;
+        let value = poll_until("test", &options(5_000), || {
+            calls += 1;
+            if calls < 3 {
+                PollStatus::Pending(format!("attempt {calls}"))
+            } else {
+                PollStatus::Ready(calls)
+            }
+        })
+        .unwrap();
+        assert_eq!(value, 3);
+    }
+
+    #[test]
+    fn times_out_with_last_reason() {
+        let err = poll_until::<()>("test", &options(20), || {
+            PollStatus::Pending("still syncing".to_owned())
+        })
+        .unwrap_err();
+        assert!(err.to_string().contains("still syncing"), "{err}");
+    }
+}
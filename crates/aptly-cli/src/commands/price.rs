@@ -0,0 +1,283 @@
+use anyhow::{anyhow, Context, Result};
+use aptly_aptos::AptosClient;
+use clap::ValueEnum;
+use num_bigint::BigInt;
+use serde::Deserialize;
+use serde_json::{json, Value};
+use std::collections::{BTreeMap, HashMap};
+use std::fs;
+use std::str::FromStr;
+
+use crate::commands::common::{get_nested_string, normalize_address, user_config_path};
+
+const PYTH_ADDRESS: &str = "0x7e783b349d3e89cf5931af376ebeadbfab855b3fa239b7ada8f5a92fbea6b387";
+const SWITCHBOARD_ADDRESS: &str =
+    "0x7d7e436f0b2aafde60774efb26ccc432cf881b677aca7faaf2a01879bd19fb8";
+const FEEDS_FILE: &str = "price_feeds.json";
+
+/// Pyth USD feed ids for well-known mainnet fungible assets, keyed by
+/// metadata address. The user feeds file overrides and extends these.
+const BUILTIN_PYTH_FEEDS: &[(&str, &str)] = &[
+    (
+        "0xa",
+        "0x03ae4db29ed4ae33d323568895aa00337e658e348b37509f5372ae51f0af00d5",
+    ),
+    (
+        "0xbae207659db88bea0cbead6da0ed00aac12edcdda169e591cd41c94180b46f3b",
+        "0xeaa020c61cc479712813461ce153894a96a6c00b21ed0cfc2798d1f9a9e9c94a",
+    ),
+    (
+        "0x357b0b74bc833e95a115ad22604854d6b0fca151cecd94111770e5d6ffc9dc2b",
+        "0x2b89b9dc8fdf9f34709a5b106b472f0f39bb6ca9ce04b0fd7f2e971688e2e53b",
+    ),
+];
+
+/// On-chain price oracle used by `--price-with`.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, ValueEnum)]
+pub(crate) enum PriceOracle {
+    Pyth,
+    Switchboard,
+}
+
+impl PriceOracle {
+    pub(crate) fn name(self) -> &'static str {
+        match self {
+            Self::Pyth => "pyth",
+            Self::Switchboard => "switchboard",
+        }
+    }
+}
+
+/// One entry of the user feeds file: a Pyth feed id and/or a Switchboard
+/// aggregator address.
+#[derive(Debug, Default, Deserialize)]
+struct FeedEntry {
+    pyth: Option<String>,
+    switchboard: Option<String>,
+}
+
+/// USD price as `mantissa / 10^scale`.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub(crate) struct UsdPrice {
+    mantissa: BigInt,
+    scale: u32,
+}
+
+impl UsdPrice {
+    /// USD value of `amount` base units of an asset with `decimals`,
+    /// truncated to cents.
+    pub(crate) fn value_of(&self, amount: &BigInt, decimals: u8) -> String {
+        let scale = u32::from(decimals) + self.scale;
+        let cents = amount * &self.mantissa * BigInt::from(100u8) / BigInt::from(10u8).pow(scale);
+        let dollars = &cents / BigInt::from(100u8);
+        let remainder = &cents % BigInt::from(100u8);
+        format!("{dollars}.{remainder:0>2}")
+    }
+}
+
+/// Current oracle prices for the assets of one run, fetched at most once each.
+pub(crate) struct PriceBook<'a> {
+    client: &'a AptosClient,
+    oracle: PriceOracle,
+    feeds: HashMap<String, String>,
+    prices: HashMap<String, Option<UsdPrice>>,
+    pyth_table: Option<String>,
+}
+
+impl<'a> PriceBook<'a> {
+    /// Built-in feeds overlaid with `$XDG_CONFIG_HOME/aptly/price_feeds.json`,
+    /// if present.
+    pub(crate) fn load(client: &'a AptosClient, oracle: PriceOracle) -> Result<Self> {
+        let mut feeds = HashMap::new();
+        if oracle == PriceOracle::Pyth {
+            for (asset, feed) in BUILTIN_PYTH_FEEDS {
+                feeds.insert(normalize_address(asset), (*feed).to_owned());
+            }
+        }
+        for (asset, entry) in read_user_feeds()? {
+            let feed = match oracle {
+                PriceOracle::Pyth => entry.pyth,
+                PriceOracle::Switchboard => entry.switchboard,
+            };
+            if let Some(feed) = feed {
+                feeds.insert(asset, feed);
+            }
+        }
+        Ok(Self {
+            client,
+            oracle,
+            feeds,
+            prices: HashMap::new(),
+            pyth_table: None,
+        })
+    }
+
+    pub(crate) fn oracle(&self) -> PriceOracle {
+        self.oracle
+    }
+
+    /// Current USD price of a fungible asset, or `None` if it has no feed.
+    pub(crate) fn price(&mut self, asset: &str) -> Result<Option<UsdPrice>> {
+        let asset = normalize_address(asset);
+        if let Some(cached) = self.prices.get(&asset) {
+            return Ok(cached.clone());
+        }
+        let price = match self.feeds.get(&asset).cloned() {
+            Some(feed) => Some(self.fetch(&feed).with_context(|| {
+                format!("failed to read {} price for {asset}", self.oracle.name())
+            })?),
+            None => None,
+        };
+        self.prices.insert(asset, price.clone());
+        Ok(price)
+    }
+
+    fn fetch(&mut self, feed: &str) -> Result<UsdPrice> {
+        match self.oracle {
+            PriceOracle::Pyth => {
+                let table = self.pyth_table()?;
+                let info = self.client.post_json(
+                    &format!("/tables/{table}/item"),
+                    &json!({
+                        "key_type": format!("{PYTH_ADDRESS}::price_identifier::PriceIdentifier"),
+                        "value_type": format!("{PYTH_ADDRESS}::price_info::PriceInfo"),
+                        "key": { "bytes": feed },
+                    }),
+                )?;
+                parse_pyth_price(&info)
+            }
+            PriceOracle::Switchboard => {
+                let value = self.client.post_json(
+                    "/view",
+                    &json!({
+                        "function": format!("{SWITCHBOARD_ADDRESS}::aggregator::latest_value"),
+                        "type_arguments": [],
+                        "arguments": [feed],
+                    }),
+                )?;
+                parse_switchboard_value(&value)
+            }
+        }
+    }
+
+    /// Handle of the `Table<PriceIdentifier, PriceInfo>` Pyth keeps its
+    /// latest prices in.
+    fn pyth_table(&mut self) -> Result<String> {
+        if let Some(handle) = &self.pyth_table {
+            return Ok(handle.clone());
+        }
+        let resource_type =
+            urlencoding::encode(&format!("{PYTH_ADDRESS}::state::LatestPriceInfo")).into_owned();
+        let resource = self.client.get_json(&format!(
+            "/accounts/{PYTH_ADDRESS}/resource/{resource_type}"
+        ))?;
+        let handle = get_nested_string(&resource, &["data", "info", "handle"]);
+        if handle.is_empty() {
+            return Err(anyhow!("unexpected Pyth LatestPriceInfo format"));
+        }
+        self.pyth_table = Some(handle.clone());
+        Ok(handle)
+    }
+}
+
+/// Decodes `PriceInfo.price_feed.price`: an `I64` price scaled by an `I64`
+/// exponent.
+fn parse_pyth_price(info: &Value) -> Result<UsdPrice> {
+    let price = info
+        .pointer("/price_feed/price")
+        .ok_or_else(|| anyhow!("unexpected Pyth PriceInfo format"))?;
+    let (negative, magnitude) = parse_pyth_i64(price.get("price"))?;
+    if negative {
+        return Err(anyhow!("negative price"));
+    }
+    let (expo_negative, expo) = parse_pyth_i64(price.get("expo"))?;
+    let expo = u32::try_from(expo).map_err(|_| anyhow!("price exponent out of range"))?;
+    let mantissa = BigInt::from(magnitude);
+    if expo_negative {
+        Ok(UsdPrice {
+            mantissa,
+            scale: expo,
+        })
+    } else {
+        Ok(UsdPrice {
+            mantissa: mantissa * BigInt::from(10u8).pow(expo),
+            scale: 0,
+        })
+    }
+}
+
+fn parse_pyth_i64(value: Option<&Value>) -> Result<(bool, u64)> {
+    let value = value.ok_or_else(|| anyhow!("unexpected Pyth I64 format"))?;
+    let negative = value
+        .get("negative")
+        .and_then(Value::as_bool)
+        .ok_or_else(|| anyhow!("unexpected Pyth I64 format"))?;
+    let magnitude = get_nested_string(value, &["magnitude"])
+        .parse::<u64>()
+        .map_err(|_| anyhow!("unexpected Pyth I64 format"))?;
+    Ok((negative, magnitude))
+}
+
+/// Decodes the `[SwitchboardDecimal]` returned by `aggregator::latest_value`.
+fn parse_switchboard_value(value: &Value) -> Result<UsdPrice> {
+    let decimal = value
+        .get(0)
+        .ok_or_else(|| anyhow!("unexpected Switchboard value format"))?;
+    if decimal.get("neg").and_then(Value::as_bool) == Some(true) {
+        return Err(anyhow!("negative price"));
+    }
+    let mantissa = BigInt::from_str(&get_nested_string(decimal, &["value"]))
+        .map_err(|_| anyhow!("unexpected Switchboard value format"))?;
+    let scale = decimal
+        .get("dec")
+        .and_then(Value::as_u64)
+        .and_then(|dec| u32::try_from(dec).ok())
+        .ok_or_else(|| anyhow!("unexpected Switchboard value format"))?;
+    Ok(UsdPrice { mantissa, scale })
+}
+
+/// Reads the user feeds file, keyed by normalized asset address. A missing
+/// file is an empty set.
+fn read_user_feeds() -> Result<BTreeMap<String, FeedEntry>> {
+    let path = user_config_path(FEEDS_FILE)?;
+    let body = match fs::read_to_string(&path) {
+        Ok(body) => body,
+        Err(err) if err.kind() == std::io::ErrorKind::NotFound => return Ok(BTreeMap::new()),
+        Err(err) => return Err(err).with_context(|| format!("failed to read {}", path.display())),
+    };
+    let feeds: BTreeMap<String, FeedEntry> = serde_json::from_str(&body)
+        .with_context(|| format!("failed to parse {}", path.display()))?;
+    Ok(feeds
+        .into_iter()
+        .map(|(asset, entry)| (normalize_address(&asset), entry))
+        .collect())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn decodes_oracle_prices() {
+        let info = json!({
+            "price_feed": {
+                "price": {
+                    "price": {"negative": false, "magnitude": "612345678"},
+                    "expo": {"negative": true, "magnitude": "8"},
+                },
+            },
+        });
+        let price = parse_pyth_price(&info).unwrap();
+        assert_eq!(price.value_of(&BigInt::from(190_000_000u64), 8), "11.63");
+
+        let value = json!([{"value": "1000200000", "dec": 9, "neg": false}]);
+        let price = parse_switchboard_value(&value).unwrap();
+        assert_eq!(
+            price.value_of(&BigInt::from(2_000_000_000u64), 6),
+            "2000.40"
+        );
+
+        let negative = json!([{"value": "1", "dec": 0, "neg": true}]);
+        assert!(parse_switchboard_value(&negative).is_err());
+    }
+}
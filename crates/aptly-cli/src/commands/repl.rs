@@ -0,0 +1,269 @@
+use anyhow::{anyhow, Context, Result};
+use aptly_aptos::{AptosClient, ClientOptions};
+use clap::{CommandFactory, Parser};
+use std::io::{self, BufRead, IsTerminal, Write};
+use std::sync::atomic::{AtomicBool, Ordering};
+
+use crate::commands::schema::schema_path_from_args;
+use crate::commands::tx::TxSubcommand;
+use crate::network::{check_localnet, Network};
+use crate::output::OutputTarget;
+use crate::{Cli, Command};
+
+const PROMPT: &str = "aptly> ";
+
+/// Global flags that configure the shared client; changed with `set` instead.
+const SESSION_FLAGS: &[&str] = &[
+    "--rpc-url",
+    "--network",
+    "--strict-endpoint",
+    "--max-rps",
+    "--verbose",
+    "--audit-log",
+    "--audit-bodies",
+];
+
+const SESSION_HELP: &str = "Session commands:
+  set                    Show the current session settings
+  set rpc-url <url>      Switch endpoint (comma-separated fallbacks allowed)
+  set network <name>     Switch to mainnet, testnet, devnet, or local
+  history                List commands entered in this session
+  exit | quit            Leave the session (EOF works too)
+Any other line is parsed as an aptly command, e.g. `account balance 0x1`.";
+
+static ACTIVE: AtomicBool = AtomicBool::new(false);
+
+/// True while a `repl` session owns stdin, so commands must not read
+/// payloads from it.
+pub(crate) fn is_active() -> bool {
+    ACTIVE.load(Ordering::Relaxed)
+}
+
+enum Flow {
+    Continue,
+    Exit,
+}
+
+struct ReplSession {
+    rpc_url: String,
+    options: ClientOptions,
+    canonical_addresses: bool,
+    client: AptosClient,
+    history: Vec<String>,
+}
+
+pub(crate) fn run_repl(
+    rpc_url: &str,
+    options: ClientOptions,
+    canonical_addresses: bool,
+) -> Result<()> {
+    let mut session = ReplSession::new(rpc_url, options, canonical_addresses)?;
+    let stdin = io::stdin();
+    let interactive = stdin.is_terminal();
+    ACTIVE.store(true, Ordering::Relaxed);
+    let result = session.run(stdin.lock(), interactive);
+    ACTIVE.store(false, Ordering::Relaxed);
+    result
+}
+
+impl ReplSession {
+    fn new(rpc_url: &str, options: ClientOptions, canonical_addresses: bool) -> Result<Self> {
+        Ok(Self {
+            client: AptosClient::with_options(rpc_url, options.clone())?,
+            rpc_url: rpc_url.to_owned(),
+            options,
+            canonical_addresses,
+            history: Vec::new(),
+        })
+    }
+
+    fn run(&mut self, mut input: impl BufRead, interactive: bool) -> Result<()> {
+        if interactive {
+            eprintln!("aptly repl on {}; type `help` for commands", self.rpc_url);
+        }
+        let mut line = String::new();
+        loop {
+            if interactive {
+                eprint!("{PROMPT}");
+                io::stderr().flush()?;
+            }
+            line.clear();
+            if input.read_line(&mut line).context("failed to read input")? == 0 {
+                if interactive {
+                    eprintln!();
+                }
+                return Ok(());
+            }
+
+            match self.handle_line(&line) {
+                Ok(Flow::Exit) => return Ok(()),
+                Ok(Flow::Continue) => {}
+                Err(err) => eprintln!("error: {err:#}"),
+            }
+            io::stdout().flush()?;
+        }
+    }
+
+    fn handle_line(&mut self, line: &str) -> Result<Flow> {
+        let line = line.trim();
+        if line.is_empty() || line.starts_with('#') {
+            return Ok(Flow::Continue);
+        }
+        self.history.push(line.to_owned());
+
+        let mut words = shell_words::split(line).context("failed to parse command line")?;
+        if words.first().map(String::as_str) == Some("aptly") {
+            words.remove(0);
+        }
+
+        match words
+            .iter()
+            .map(String::as_str)
+            .collect::<Vec<_>>()
+            .as_slice()
+        {
+            ["exit"] | ["quit"] => return Ok(Flow::Exit),
+            ["help"] => {
+                println!("{SESSION_HELP}\n");
+                Cli::command().print_help()?;
+            }
+            ["history"] => {
+                for (index, entry) in self.history.iter().enumerate() {
+                    println!("{:>4}  {entry}", index + 1);
+                }
+            }
+            ["set"] => {
+                println!("rpc-url: {}", self.rpc_url);
+                println!("canonical-addresses: {}", self.canonical_addresses);
+            }
+            ["set", "rpc-url", url] => self.connect(url)?,
+            ["set", "network", name] => {
+                let network = Network::from_name(name)?;
+                self.connect(network.rpc_url())?;
+                if network == Network::Local {
+                    check_localnet(&self.client)?;
+                }
+            }
+            ["set", ..] => {
+                return Err(anyhow!(
+                    "usage: set rpc-url <url> | set network <mainnet|testnet|devnet|local>"
+                ))
+            }
+            _ => self.run_command(words)?,
+        }
+        Ok(Flow::Continue)
+    }
+
+    fn connect(&mut self, rpc_url: &str) -> Result<()> {
+        self.client = AptosClient::with_options(rpc_url, self.options.clone())?;
+        self.rpc_url = rpc_url.to_owned();
+        eprintln!("rpc-url: {rpc_url}");
+        Ok(())
+    }
+
+    fn run_command(&mut self, words: Vec<String>) -> Result<()> {
+        if let Some(flag) = words.iter().find(|word| {
+            SESSION_FLAGS
+                .iter()
+                .any(|flag| word == flag || word.starts_with(&format!("{flag}=")))
+        }) {
+            return Err(anyhow!(
+                "{flag} configures the session client; use `set` instead (see `help`)"
+            ));
+        }
+
+        let args: Vec<String> = std::iter::once("aptly".to_owned()).chain(words).collect();
+        if args
+            .iter()
+            .take_while(|arg| *arg != "--")
+            .any(|arg| arg == "--schema")
+        {
+            return crate::print_command_schema(&args);
+        }
+
+        // Usage errors and `--help` are printed by clap in its usual format.
+        let cli = match Cli::try_parse_from(&args) {
+            Ok(cli) => cli,
+            Err(err) => {
+                err.print()?;
+                return Ok(());
+            }
+        };
+        if cli.pin_ledger {
+            return Err(anyhow!("--pin-ledger is not supported inside repl"));
+        }
+        if reads_payload_from_stdin(&cli.command) {
+            return Err(anyhow!(
+                "this command reads its payload from stdin, which the repl session owns; run it outside repl"
+            ));
+        }
+
+        if let Some(audit) = &self.options.audit {
+            audit.set_command(&schema_path_from_args(&Cli::command(), &args).join(" "));
+        }
+        let canonical_addresses = self.canonical_addresses || cli.canonical_addresses;
+        crate::output::set_target(cli.output_target());
+        crate::output::set_force(cli.force);
+        let result = crate::run_command(&self.client, cli.command, canonical_addresses);
+        crate::output::set_target(OutputTarget::Stdout);
+        crate::output::set_force(false);
+        self.client.log_stats();
+        result
+    }
+}
+
+fn reads_payload_from_stdin(command: &Command) -> bool {
+    matches!(
+        command,
+        Command::Tx(tx) if matches!(
+            tx.command,
+            Some(TxSubcommand::Encode)
+                | Some(TxSubcommand::Submit)
+                | Some(TxSubcommand::Simulate(_))
+                | Some(TxSubcommand::Cost(_))
+                | Some(TxSubcommand::Compose(_))
+        )
+    )
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn session() -> ReplSession {
+        ReplSession::new("http://127.0.0.1:9/v1", ClientOptions::default(), false).unwrap()
+    }
+
+    #[test]
+    fn session_commands_update_state_and_survive_errors() {
+        let mut session = session();
+        let script = "\
+            set network testnet\n\
+            account balance\n\
+            not-a-command\n\
+            set rpc-url http://localhost:8080/v1\n\
+            node ledger --rpc-url http://other\n\
+            version\n";
+        session.run(script.as_bytes(), false).unwrap();
+
+        assert_eq!(session.rpc_url, "http://localhost:8080/v1");
+        assert_eq!(session.client.base_url(), "http://localhost:8080/v1");
+        assert_eq!(session.history.len(), 6);
+    }
+
+    #[test]
+    fn exit_stops_reading() {
+        let mut session = session();
+        session.run("exit\nversion\n".as_bytes(), false).unwrap();
+        assert_eq!(session.history, vec!["exit"]);
+    }
+
+    #[test]
+    fn rejects_unknown_network() {
+        assert_eq!(
+            Network::from_name("MAINNET").unwrap().rpc_url(),
+            "https://api.mainnet.aptoslabs.com/v1"
+        );
+        assert!(Network::from_name("moonnet").is_err());
+    }
+}
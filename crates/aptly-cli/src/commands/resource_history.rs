@@ -0,0 +1,408 @@
+use anyhow::{anyhow, Context, Result};
+use aptly_aptos::AptosClient;
+use clap::Args;
+use serde::Serialize;
+use serde_json::{json, Value};
+use std::collections::HashMap;
+use std::io::{self, IsTerminal, Write};
+
+use crate::commands::common::parse_u64;
+use crate::commands::schema::{
+    array_schema, boolean_schema, integer_schema, object_schema, string_schema, OutputSchema,
+};
+
+#[derive(Args)]
+pub(crate) struct ResourceHistoryArgs {
+    /// Account address (`0x...`).
+    #[arg(value_name = "ADDRESS")]
+    pub(crate) address: String,
+    /// Fully-qualified resource type.
+    #[arg(value_name = "RESOURCE_TYPE")]
+    pub(crate) resource_type: String,
+    /// Field inside the resource data: `coin.value`, `$.coin.value`,
+    /// `items[0]` or a JSON pointer (`/coin/value`). Whole resource when omitted.
+    #[arg(long, value_name = "JSON_PATH")]
+    pub(crate) path: Option<String>,
+    /// First ledger version to search.
+    #[arg(long, value_name = "VERSION")]
+    pub(crate) from: u64,
+    /// Last ledger version to search. Defaults to the current ledger version.
+    #[arg(long, value_name = "VERSION")]
+    pub(crate) to: Option<u64>,
+    /// Stop after this many changes.
+    #[arg(long, default_value_t = 10)]
+    pub(crate) max_changes: usize,
+}
+
+/// One transition of the watched value. The old value held for
+/// `old_from_version..=version - 1`; `version` is the first with the new one.
+#[derive(Debug, Clone, PartialEq, Serialize)]
+struct Change {
+    old_from_version: u64,
+    old_to_version: u64,
+    version: u64,
+    old: Value,
+    old_exists: bool,
+    new: Value,
+    new_exists: bool,
+}
+
+#[derive(Debug, Clone, Serialize)]
+struct ResourceHistory {
+    address: String,
+    resource_type: String,
+    path: String,
+    from_version: u64,
+    to_version: u64,
+    probes: u64,
+    truncated: bool,
+    changes: Vec<Change>,
+}
+
+impl OutputSchema for Change {
+    fn output_schema() -> Value {
+        object_schema(
+            "One change of the value at the path",
+            &[
+                (
+                    "old_from_version",
+                    integer_schema("First version known to hold the old value"),
+                ),
+                (
+                    "old_to_version",
+                    integer_schema("Last version holding the old value"),
+                ),
+                (
+                    "version",
+                    integer_schema("Version of the transaction that wrote the new value"),
+                ),
+                (
+                    "old",
+                    json!({ "description": "Old value; null when absent" }),
+                ),
+                (
+                    "old_exists",
+                    boolean_schema("False when the resource did not exist"),
+                ),
+                (
+                    "new",
+                    json!({ "description": "New value; null when absent" }),
+                ),
+                (
+                    "new_exists",
+                    boolean_schema("False when the resource was deleted"),
+                ),
+            ],
+        )
+    }
+}
+
+impl OutputSchema for ResourceHistory {
+    fn output_schema() -> Value {
+        object_schema(
+            "Versions at which a resource field changed",
+            &[
+                ("address", string_schema("Account address")),
+                ("resource_type", string_schema("Resource type searched")),
+                (
+                    "path",
+                    string_schema("JSON pointer searched; empty for the whole resource"),
+                ),
+                ("from_version", integer_schema("First version searched")),
+                ("to_version", integer_schema("Last version searched")),
+                ("probes", integer_schema("Resource reads performed")),
+                (
+                    "truncated",
+                    boolean_schema("Whether --max-changes stopped the search early"),
+                ),
+                (
+                    "changes",
+                    array_schema("Changes in version order", Change::output_schema()),
+                ),
+            ],
+        )
+    }
+}
+
+pub(crate) fn resource_history_output_schema() -> Value {
+    ResourceHistory::output_schema()
+}
+
+pub(crate) fn run_resource_history(client: &AptosClient, args: &ResourceHistoryArgs) -> Result<()> {
+    if args.max_changes == 0 {
+        return Err(anyhow!("--max-changes must be at least 1"));
+    }
+    let pointer = parse_path(args.path.as_deref().unwrap_or(""))?;
+
+    let ledger = client.get_json("/")?;
+    let head = ledger
+        .get("ledger_version")
+        .and_then(parse_u64)
+        .ok_or_else(|| anyhow!("failed to parse ledger version"))?;
+    let oldest = ledger
+        .get("oldest_ledger_version")
+        .and_then(parse_u64)
+        .unwrap_or(0);
+    let to = args.to.unwrap_or(head);
+    if args.from < oldest {
+        return Err(anyhow!(
+            "--from {} is pruned on this node; the oldest available version is {oldest}",
+            args.from
+        ));
+    }
+    if to > head {
+        return Err(anyhow!(
+            "--to {to} is ahead of the ledger (current version {head})"
+        ));
+    }
+    if args.from > to {
+        return Err(anyhow!("--from {} is after --to {to}", args.from));
+    }
+
+    let resource = urlencoding::encode(&args.resource_type).into_owned();
+    let read = |version: u64| -> Result<Option<Value>> {
+        let path = format!(
+            "/accounts/{}/resource/{resource}?ledger_version={version}",
+            args.address
+        );
+        match client.get_json(&path) {
+            Ok(value) => Ok(Some(
+                value
+                    .get("data")
+                    .and_then(|data| data.pointer(&pointer))
+                    .cloned()
+                    .unwrap_or(Value::Null),
+            )),
+            Err(err) if err.to_string().contains("status 404") => Ok(None),
+            Err(err) => Err(err).with_context(|| format!("failed to read resource at {version}")),
+        }
+    };
+
+    let mut search = Search::new(read, io::stderr().is_terminal() && to - args.from > 1024);
+    let (changes, truncated) = search.run(args.from, to, args.max_changes)?;
+    let history = ResourceHistory {
+        address: args.address.clone(),
+        resource_type: args.resource_type.clone(),
+        path: pointer.clone(),
+        from_version: args.from,
+        to_version: to,
+        probes: search.probes,
+        truncated,
+        changes,
+    };
+    crate::print_serialized(&history)
+}
+
+/// Converts `$.a.b[0]`, `a.b.0` or `/a/b/0` into a JSON pointer.
+fn parse_path(path: &str) -> Result<String> {
+    if path.is_empty() || path.starts_with('/') {
+        return Ok(path.to_owned());
+    }
+    let path = path.strip_prefix('$').unwrap_or(path);
+    let path = path.strip_prefix('.').unwrap_or(path);
+    let mut pointer = String::new();
+    for segment in path.split('.') {
+        let (key, indexes) = match segment.find('[') {
+            Some(at) => segment.split_at(at),
+            None => (segment, ""),
+        };
+        if key.is_empty() && indexes.is_empty() {
+            return Err(anyhow!("invalid --path `{path}`: empty segment"));
+        }
+        if !key.is_empty() {
+            pointer.push('/');
+            pointer.push_str(&key.replace('~', "~0").replace('/', "~1"));
+        }
+        let mut rest = indexes;
+        while let Some(inner) = rest.strip_prefix('[') {
+            let (index, tail) = inner
+                .split_once(']')
+                .ok_or_else(|| anyhow!("invalid --path `{path}`: unclosed `[`"))?;
+            index
+                .parse::<usize>()
+                .map_err(|_| anyhow!("invalid --path `{path}`: bad index `{index}`"))?;
+            pointer.push('/');
+            pointer.push_str(index);
+            rest = tail;
+        }
+        if !rest.is_empty() {
+            return Err(anyhow!("invalid --path `{path}`: unexpected `{rest}`"));
+        }
+    }
+    Ok(pointer)
+}
+
+/// Bisects ledger versions for transitions of a value, where `None` means the
+/// resource did not exist. Each change costs `O(log range)` reads.
+///
+/// Only ranges whose endpoints differ are searched, so a value that changes
+/// and reverts entirely between two probes is not reported.
+struct Search<F> {
+    read: F,
+    cache: HashMap<u64, Option<Value>>,
+    probes: u64,
+    progress: bool,
+}
+
+impl<F: FnMut(u64) -> Result<Option<Value>>> Search<F> {
+    fn new(read: F, progress: bool) -> Self {
+        Self {
+            read,
+            cache: HashMap::new(),
+            probes: 0,
+            progress,
+        }
+    }
+
+    fn value(&mut self, version: u64) -> Result<Option<Value>> {
+        if let Some(value) = self.cache.get(&version) {
+            return Ok(value.clone());
+        }
+        let value = (self.read)(version)?;
+        self.probes += 1;
+        self.cache.insert(version, value.clone());
+        Ok(value)
+    }
+
+    fn run(&mut self, from: u64, to: u64, max_changes: usize) -> Result<(Vec<Change>, bool)> {
+        let mut changes = Vec::new();
+        let mut start = from;
+        let mut current = self.value(from)?;
+        let last = self.value(to)?;
+
+        while start < to && current != last {
+            if changes.len() == max_changes {
+                self.finish_progress();
+                return Ok((changes, true));
+            }
+            // Invariant: value(lo) == current, value(hi) != current.
+            let (mut lo, mut hi) = (start, to);
+            while hi - lo > 1 {
+                let mid = lo + (hi - lo) / 2;
+                if self.value(mid)? == current {
+                    lo = mid;
+                } else {
+                    hi = mid;
+                }
+                self.report_progress(changes.len(), lo, hi);
+            }
+            let next = self.value(hi)?;
+            changes.push(Change {
+                old_from_version: start,
+                old_to_version: lo,
+                version: hi,
+                old_exists: current.is_some(),
+                old: current.unwrap_or(Value::Null),
+                new_exists: next.is_some(),
+                new: next.clone().unwrap_or(Value::Null),
+            });
+            start = hi;
+            current = next;
+        }
+        self.finish_progress();
+        Ok((changes, false))
+    }
+
+    fn report_progress(&self, found: usize, lo: u64, hi: u64) {
+        if self.progress {
+            eprint!(
+                "\r{} probes, {found} changes found, narrowing {lo}..{hi}          ",
+                self.probes
+            );
+            let _ = io::stderr().flush();
+        }
+    }
+
+    fn finish_progress(&self) {
+        if self.progress {
+            eprintln!();
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::commands::schema::validate;
+
+    /// Absent until 100, `"1"` from 100, `"2"` from 250, deleted at 900 and
+    /// recreated as `"3"` at 950.
+    fn history(version: u64) -> Result<Option<Value>> {
+        Ok(match version {
+            0..=99 | 900..=949 => None,
+            100..=249 => Some(json!("1")),
+            250..=899 => Some(json!("2")),
+            _ => Some(json!("3")),
+        })
+    }
+
+    #[test]
+    fn finds_every_transition_in_log_reads() {
+        let mut search = Search::new(history, false);
+        let (changes, truncated) = search.run(0, 1000, 10).unwrap();
+        assert!(!truncated);
+        let summary: Vec<(u64, u64, u64, bool, bool)> = changes
+            .iter()
+            .map(|change| {
+                (
+                    change.old_from_version,
+                    change.old_to_version,
+                    change.version,
+                    change.old_exists,
+                    change.new_exists,
+                )
+            })
+            .collect();
+        assert_eq!(
+            summary,
+            [
+                (0, 99, 100, false, true),
+                (100, 249, 250, true, true),
+                (250, 899, 900, true, false),
+                (900, 949, 950, false, true),
+            ]
+        );
+        assert_eq!(changes[1].old, json!("1"));
+        assert_eq!(changes[1].new, json!("2"));
+        assert!(search.probes <= 2 + 4 * 10, "{} probes", search.probes);
+
+        let report = ResourceHistory {
+            address: "0x1".to_owned(),
+            resource_type: "0x1::coin::CoinStore<0x1::aptos_coin::AptosCoin>".to_owned(),
+            path: "/coin/value".to_owned(),
+            from_version: 0,
+            to_version: 1000,
+            probes: search.probes,
+            truncated,
+            changes,
+        };
+        validate(
+            &resource_history_output_schema(),
+            &serde_json::to_value(&report).unwrap(),
+        )
+        .unwrap();
+    }
+
+    #[test]
+    fn stops_at_max_changes() {
+        let mut search = Search::new(history, false);
+        let (changes, truncated) = search.run(0, 1000, 2).unwrap();
+        assert!(truncated);
+        assert_eq!(changes.len(), 2);
+
+        let (changes, truncated) = Search::new(history, false).run(300, 800, 1).unwrap();
+        assert!(!truncated);
+        assert!(changes.is_empty());
+    }
+
+    #[test]
+    fn parses_paths_into_pointers() {
+        assert_eq!(parse_path("").unwrap(), "");
+        assert_eq!(parse_path("/coin/value").unwrap(), "/coin/value");
+        assert_eq!(parse_path("$.coin.value").unwrap(), "/coin/value");
+        assert_eq!(parse_path("items[0].amount").unwrap(), "/items/0/amount");
+        assert_eq!(parse_path("a.b[1][2]").unwrap(), "/a/b/1/2");
+        assert!(parse_path("a..b").is_err());
+        assert!(parse_path("a[x]").is_err());
+    }
+}
@@ -0,0 +1,485 @@
+use anyhow::{anyhow, Result};
+use clap::Args;
+use serde_json::{json, Map, Value};
+
+use crate::commands::{
+    account, account_gas, account_txs, audit, auth, coin, decode, events, fa, faucet, gas_profile,
+    graph, labels, move_structs, multisig, node, objects, openapi, plugin, resource_history,
+    signers, simulate_compare, source_verify, stake, state_diff, storage, swaps, tx, tx_cost,
+    type_tag, watch,
+};
+
+const JSON_SCHEMA_DIALECT: &str = "https://json-schema.org/draft/2020-12/schema";
+
+/// Command paths with a declared output schema, in `aptly --help` order.
+const SCHEMA_COMMAND_PATHS: &[&str] = &[
+    "node ledger",
+    "node spec",
+    "node health",
+    "node info",
+    "node estimate-gas-price",
+    "node wait-synced",
+    "node gas",
+    "node compare",
+    "account",
+    "account resources",
+    "account resource",
+    "account resource-history",
+    "account modules",
+    "account module",
+    "account balance",
+    "account balances",
+    "account coins",
+    "account primary-store",
+    "account stake",
+    "account multisig",
+    "account objects",
+    "account auth",
+    "account key-rotation",
+    "account handles",
+    "account storage",
+    "account txs",
+    "account watch",
+    "account sends",
+    "account receives",
+    "account gas",
+    "account gas-profile",
+    "account source-code",
+    "account source-verify",
+    "address",
+    "audit summarize",
+    "coin migration",
+    "fa status",
+    "labels list",
+    "labels add",
+    "type parse",
+    "decode resource",
+    "plugin list",
+    "plugin doctor",
+    "block",
+    "block by-version",
+    "events",
+    "events sync",
+    "faucet",
+    "table item",
+    "view",
+    "tx",
+    "tx list",
+    "tx encode",
+    "tx simulate",
+    "tx cost",
+    "tx submit",
+    "tx compose",
+    "tx trace",
+    "tx balance-change",
+    "tx state-diff",
+    "tx signers",
+    "tx graph",
+    "tx swaps",
+    "watch",
+];
+
+#[derive(Args)]
+#[command(
+    after_help = "Examples:\n  aptly schema\n  aptly schema account sends\n  aptly schema tx balance-change\n  aptly account sends 0x1 --schema"
+)]
+pub(crate) struct SchemaCommand {
+    /// Command path, e.g. `account sends`. Lists known paths when omitted.
+    #[arg(value_name = "COMMAND")]
+    pub(crate) path: Vec<String>,
+}
+
+/// Implemented by output structs to describe their serialized JSON shape.
+pub(crate) trait OutputSchema {
+    fn output_schema() -> Value;
+}
+
+pub(crate) fn run_schema(rpc_url: &str, command: SchemaCommand) -> Result<()> {
+    if command.path.is_empty() {
+        return crate::print_serialized(&json!({ "commands": SCHEMA_COMMAND_PATHS }));
+    }
+
+    let path: Vec<&str> = command.path.iter().map(String::as_str).collect();
+    let schema = command_schema(rpc_url, &path)?;
+    crate::print_pretty_json(&schema)
+}
+
+/// Resolves the subcommand path from raw CLI arguments so `--schema` works
+/// without supplying the command's required positional arguments.
+pub(crate) fn schema_path_from_args(root: &clap::Command, args: &[String]) -> Vec<String> {
+    let mut current = root;
+    let mut path = Vec::new();
+    for arg in args.iter().skip(1) {
+        if arg.starts_with('-') {
+            continue;
+        }
+        if let Some(next) = current.find_subcommand(arg) {
+            path.push(next.get_name().to_owned());
+            current = next;
+        }
+    }
+    path
+}
+
+pub(crate) fn command_schema(rpc_url: &str, path: &[&str]) -> Result<Value> {
+    let body = match path {
+        ["node", "ledger"] => node_schema(rpc_url, "IndexResponse", "Ledger info from `/`"),
+        ["node", "spec"] => json!({
+            "oneOf": [
+                {
+                    "description": "Raw node OpenAPI document",
+                    "$ref": node_spec_url(rpc_url),
+                },
+                openapi::summary_output_schema(),
+                openapi::detail_output_schema(),
+            ],
+        }),
+        ["node", "health"] => json!({
+            "oneOf": [
+                node_schema(rpc_url, "HealthCheckSuccess", "Node health check"),
+                node::health_output_schema(),
+            ],
+        }),
+        ["node", "info"] => json!({
+            "description": "Raw node build/runtime info; free-form key/value pairs",
+            "type": "object",
+        }),
+        ["node", "estimate-gas-price"] => {
+            node_schema(rpc_url, "GasEstimation", "Gas price estimate")
+        }
+        ["node", "wait-synced"] => node_schema(
+            rpc_url,
+            "IndexResponse",
+            "Ledger info once the node is synced",
+        ),
+        ["node", "gas"] => node::gas_output_schema(),
+        ["node", "compare"] => node::compare_output_schema(),
+        ["account"] => node_schema(rpc_url, "AccountData", "Account sequence number and key"),
+        ["account", "resources"] => json!({
+            "oneOf": [
+                node_array_schema(rpc_url, "MoveResource", "Resources under the account"),
+                integer_schema("Number of resources matching `--type` (`--count`)"),
+                account::resources_summary_output_schema(),
+            ],
+        }),
+        ["account", "resource"] => node_schema(rpc_url, "MoveResource", "A single resource"),
+        ["account", "resource-history"] => resource_history::resource_history_output_schema(),
+        ["account", "modules"] => json!({
+            "oneOf": [
+                node_array_schema(rpc_url, "MoveModuleBytecode", "Modules under the account"),
+                array_schema(
+                    "Module names (`--names`)",
+                    string_schema("Module name"),
+                ),
+                account::module_functions_output_schema(),
+            ],
+        }),
+        ["account", "module"] => json!({
+            "oneOf": [
+                node_schema(
+                    rpc_url,
+                    "MoveModuleBytecode",
+                    "Module bytecode and ABI; `--abi` prints only `abi`, `--bytecode` only `bytecode`",
+                ),
+                account::module_functions_list_output_schema(),
+                move_structs::structs_output_schema(),
+            ],
+        }),
+        ["account", "balance"] => account::balance_output_schema(),
+        ["account", "balances"] => account::balances_output_schema(),
+        ["account", "coins"] => account::coins_output_schema(),
+        ["account", "primary-store"] => fa::primary_store_output_schema(),
+        ["account", "stake"] => stake::stake_output_schema(),
+        ["account", "multisig"] => multisig::multisig_output_schema(),
+        ["account", "objects"] => objects::objects_output_schema(),
+        ["account", "auth"] => auth::auth_output_schema(),
+        ["account", "key-rotation"] => auth::key_rotation_output_schema(),
+        ["account", "handles"] => events::handles_output_schema(),
+        ["account", "storage"] => storage::storage_output_schema(),
+        ["account", "txs"] => json!({
+            "oneOf": [
+                node_array_schema(rpc_url, "Transaction", "Transactions sent by the account"),
+                account_txs::page_output_schema(node_schema(
+                    rpc_url,
+                    "Transaction",
+                    "Transaction sent by the account",
+                )),
+            ],
+        }),
+        ["account", "watch"] => node_schema(
+            rpc_url,
+            "Transaction",
+            "Transaction sent by the account, one JSON line each",
+        ),
+        ["account", "sends"] => account::sends_output_schema(),
+        ["account", "receives"] => account::receives_output_schema(),
+        ["account", "gas"] => account_gas::gas_output_schema(),
+        ["account", "gas-profile"] => gas_profile::gas_profile_output_schema(),
+        ["account", "source-code"] => account::source_code_output_schema(),
+        ["account", "source-verify"] => source_verify::source_verify_output_schema(),
+        ["address"] => json!({
+            "description": "Known address labels keyed by address",
+            "type": "object",
+            "additionalProperties": { "type": "string" },
+        }),
+        ["audit", "summarize"] => audit::summarize_output_schema(),
+        ["coin", "migration"] => coin::migration_output_schema(),
+        ["fa", "status"] => fa::status_output_schema(),
+        ["labels", "list"] => labels::list_output_schema(),
+        ["labels", "sync"] => labels::list_output_schema(),
+        ["labels", "add"] => labels::entry_output_schema(),
+        ["type", "parse"] => type_tag::parse_output_schema(),
+        ["decode", "resource"] => decode::resource_output_schema(),
+        ["plugin", "list"] => plugin::list_output_schema(),
+        ["plugin", "doctor"] => plugin::doctor_output_schema(),
+        ["block"] | ["block", "by-version"] => node_schema(rpc_url, "Block", "Block data"),
+        ["events"] => node_array_schema(rpc_url, "VersionedEvent", "Events for the handle"),
+        ["events", "sync"] => events::sync_output_schema(),
+        ["faucet"] => faucet::faucet_output_schema(),
+        ["table", "item"] => node_schema(rpc_url, "MoveValue", "Decoded table value"),
+        ["view"] => node_array_schema(rpc_url, "MoveValue", "View function return values"),
+        ["tx"] => node_schema(rpc_url, "Transaction", "Transaction by version or hash"),
+        ["tx", "list"] => node_array_schema(rpc_url, "Transaction", "Recent transactions"),
+        ["tx", "encode"] => json!({
+            "description": "Hex-encoded signing message (`0x...`)",
+            "type": "string",
+        }),
+        ["tx", "simulate"] => json!({
+            "oneOf": [
+                node_schema(rpc_url, "UserTransaction", "Simulated transaction"),
+                tx::simulate_summary_output_schema(),
+                simulate_compare::comparison_output_schema(),
+            ],
+        }),
+        ["tx", "cost"] => tx_cost::cost_output_schema(),
+        ["tx", "submit"] => node_schema(rpc_url, "PendingTransaction", "Submitted transaction"),
+        ["tx", "compose"] => tx::compose_output_schema(),
+        ["tx", "trace"] => json!({
+            "description": "Call trace as returned by Sentio or aptos-tracer; proxied unchanged",
+            "type": "object",
+        }),
+        ["tx", "balance-change"] => tx::balance_change_output_schema(),
+        ["tx", "state-diff"] => state_diff::state_diff_output_schema(),
+        ["tx", "signers"] => signers::signers_output_schema(),
+        ["tx", "graph"] => graph::graph_output_schema(),
+        ["tx", "swaps"] => swaps::swaps_output_schema(),
+        ["watch"] => watch::alert_output_schema(),
+        _ => {
+            return Err(anyhow!(
+                "no output schema for `{}`; run `aptly schema` to list known commands",
+                path.join(" ")
+            ))
+        }
+    };
+
+    let mut schema = Map::new();
+    schema.insert("$schema".to_owned(), json!(JSON_SCHEMA_DIALECT));
+    schema.insert(
+        "title".to_owned(),
+        json!(format!("aptly {}", path.join(" "))),
+    );
+    if let Value::Object(body) = body {
+        schema.extend(body);
+    }
+    Ok(Value::Object(schema))
+}
+
+pub(crate) fn object_schema(description: &str, properties: &[(&str, Value)]) -> Value {
+    let mut props = Map::new();
+    for (name, schema) in properties {
+        props.insert((*name).to_owned(), schema.clone());
+    }
+    let required: Vec<&str> = properties.iter().map(|(name, _)| *name).collect();
+    json!({
+        "description": description,
+        "type": "object",
+        "properties": props,
+        "required": required,
+        "additionalProperties": false,
+    })
+}
+
+/// Adds properties that may be absent from the serialized object.
+pub(crate) fn with_optional_properties(schema: Value, properties: &[(&str, Value)]) -> Value {
+    let mut schema = schema;
+    if let Some(props) = schema.get_mut("properties").and_then(Value::as_object_mut) {
+        for (name, property) in properties {
+            props.insert((*name).to_owned(), property.clone());
+        }
+    }
+    schema
+}
+
+pub(crate) fn array_schema(description: &str, items: Value) -> Value {
+    json!({ "description": description, "type": "array", "items": items })
+}
+
+pub(crate) fn string_schema(description: &str) -> Value {
+    json!({ "description": description, "type": "string" })
+}
+
+pub(crate) fn integer_schema(description: &str) -> Value {
+    json!({ "description": description, "type": "integer", "minimum": 0 })
+}
+
+pub(crate) fn boolean_schema(description: &str) -> Value {
+    json!({ "description": description, "type": "boolean" })
+}
+
+pub(crate) fn nullable(schema: Value) -> Value {
+    let mut schema = schema;
+    if let Some(kind) = schema.get("type").cloned() {
+        schema["type"] = json!([kind, "null"]);
+    }
+    schema
+}
+
+fn node_spec_url(rpc_url: &str) -> String {
+    format!("{}/spec.json", rpc_url.trim().trim_end_matches('/'))
+}
+
+fn node_schema(rpc_url: &str, definition: &str, description: &str) -> Value {
+    json!({
+        "description": format!("{description}; raw node API JSON proxied unchanged"),
+        "$ref": format!("{}#/components/schemas/{definition}", node_spec_url(rpc_url)),
+    })
+}
+
+fn node_array_schema(rpc_url: &str, definition: &str, description: &str) -> Value {
+    json!({
+        "description": format!("{description}; raw node API JSON proxied unchanged"),
+        "type": "array",
+        "items": {
+            "$ref": format!("{}#/components/schemas/{definition}", node_spec_url(rpc_url)),
+        },
+    })
+}
+
+/// Minimal JSON Schema validator covering the keywords emitted above, used to
+/// keep declared schemas in sync with serialized output.
+#[cfg(test)]
+pub(crate) fn validate(schema: &Value, value: &Value) -> std::result::Result<(), String> {
+    if schema.get("$ref").is_some() {
+        return Ok(());
+    }
+
+    if let Some(variants) = schema.get("oneOf").and_then(Value::as_array) {
+        let matches = variants
+            .iter()
+            .filter(|variant| validate(variant, value).is_ok())
+            .count();
+        if matches != 1 {
+            return Err(format!("expected exactly one oneOf match, got {matches}"));
+        }
+        return Ok(());
+    }
+
+    if let Some(kind) = schema.get("type") {
+        let kinds: Vec<&str> = match kind {
+            Value::String(kind) => vec![kind.as_str()],
+            Value::Array(kinds) => kinds.iter().filter_map(Value::as_str).collect(),
+            _ => Vec::new(),
+        };
+        let actual = match value {
+            Value::Null => "null",
+            Value::Bool(_) => "boolean",
+            Value::Number(n) if n.is_u64() || n.is_i64() => "integer",
+            Value::Number(_) => "number",
+            Value::String(_) => "string",
+            Value::Array(_) => "array",
+            Value::Object(_) => "object",
+        };
+        let ok = kinds
+            .iter()
+            .any(|kind| *kind == actual || (*kind == "number" && actual == "integer"));
+        if !ok {
+            return Err(format!("expected type {kinds:?}, got {actual}"));
+        }
+    }
+
+    if let (Some(items), Some(values)) = (schema.get("items"), value.as_array()) {
+        for (index, item) in values.iter().enumerate() {
+            validate(items, item).map_err(|err| format!("[{index}]: {err}"))?;
+        }
+    }
+
+    if let Some(object) = value.as_object() {
+        let properties = schema.get("properties").and_then(Value::as_object);
+        if let Some(required) = schema.get("required").and_then(Value::as_array) {
+            for name in required.iter().filter_map(Value::as_str) {
+                if !object.contains_key(name) {
+                    return Err(format!("missing required property `{name}`"));
+                }
+            }
+        }
+        for (name, field) in object {
+            match properties.and_then(|props| props.get(name)) {
+                Some(property) => {
+                    validate(property, field).map_err(|err| format!("{name}: {err}"))?
+                }
+                None => match schema.get("additionalProperties") {
+                    Some(Value::Bool(false)) => {
+                        return Err(format!("undeclared property `{name}`"))
+                    }
+                    Some(extra @ Value::Object(_)) => {
+                        validate(extra, field).map_err(|err| format!("{name}: {err}"))?
+                    }
+                    _ => {}
+                },
+            }
+        }
+    }
+
+    Ok(())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn every_listed_path_has_a_schema() {
+        for path in SCHEMA_COMMAND_PATHS {
+            let parts: Vec<&str> = path.split(' ').collect();
+            let schema = command_schema("https://node.example/v1", &parts).unwrap();
+            assert_eq!(schema["title"], format!("aptly {path}"));
+        }
+    }
+
+    #[test]
+    fn raw_node_commands_reference_openapi_spec() {
+        let schema = command_schema("https://node.example/v1/", &["tx"]).unwrap();
+        assert_eq!(
+            schema["$ref"],
+            "https://node.example/v1/spec.json#/components/schemas/Transaction"
+        );
+    }
+
+    #[test]
+    fn resolves_schema_path_from_args() {
+        use clap::CommandFactory;
+
+        let args: Vec<String> = [
+            "aptly",
+            "--rpc-url",
+            "x",
+            "account",
+            "sends",
+            "0x1",
+            "--schema",
+        ]
+        .iter()
+        .map(|arg| (*arg).to_owned())
+        .collect();
+        let path = schema_path_from_args(&crate::Cli::command(), &args);
+        assert_eq!(path, vec!["account", "sends"]);
+    }
+
+    #[test]
+    fn validator_rejects_undeclared_fields() {
+        let schema = object_schema("t", &[("a", string_schema("a"))]);
+        assert!(validate(&schema, &json!({ "a": "x" })).is_ok());
+        assert!(validate(&schema, &json!({ "a": "x", "b": 1 })).is_err());
+        assert!(validate(&schema, &json!({})).is_err());
+    }
+}
@@ -0,0 +1,461 @@
+use anyhow::{anyhow, Result};
+use aptly_aptos::AptosClient;
+use clap::Args;
+use serde::Serialize;
+use serde_json::{json, Value};
+
+use crate::commands::common::{get_nested_string, parse_u64, value_to_string};
+use crate::commands::schema::{
+    array_schema, boolean_schema, integer_schema, nullable, object_schema, string_schema,
+    OutputSchema,
+};
+use crate::commands::tx::fetch_transaction;
+
+#[derive(Args)]
+pub(crate) struct TxSignersArgs {
+    /// Transaction version (u64) or hash (0x...).
+    #[arg(value_name = "VERSION_OR_HASH")]
+    pub(crate) version_or_hash: String,
+    /// Render a text report instead of JSON.
+    #[arg(long, default_value_t = false)]
+    pub(crate) pretty: bool,
+}
+
+#[derive(Debug, Clone, Serialize)]
+struct SignersReport {
+    scheme: String,
+    signers: Vec<Signer>,
+    fee_payer: Option<String>,
+}
+
+/// One account that authorized the transaction.
+#[derive(Debug, Clone, Serialize)]
+struct Signer {
+    role: &'static str,
+    address: String,
+    scheme: String,
+    public_keys: Vec<SignerKey>,
+    threshold: Option<u64>,
+    signed_indices: Vec<u64>,
+}
+
+#[derive(Debug, Clone, PartialEq, Serialize)]
+struct SignerKey {
+    index: u64,
+    key_type: String,
+    public_key: String,
+    signed: bool,
+}
+
+impl OutputSchema for SignerKey {
+    fn output_schema() -> Value {
+        object_schema(
+            "Public key of a signer",
+            &[
+                ("index", integer_schema("Position in the signer's key set")),
+                (
+                    "key_type",
+                    string_schema("Key scheme (`ed25519`, `secp256k1_ecdsa`, `keyless`, ...)"),
+                ),
+                ("public_key", string_schema("Public key bytes as hex")),
+                (
+                    "signed",
+                    boolean_schema("Whether this key contributed a signature"),
+                ),
+            ],
+        )
+    }
+}
+
+impl OutputSchema for Signer {
+    fn output_schema() -> Value {
+        object_schema(
+            "Account that authorized the transaction",
+            &[
+                (
+                    "role",
+                    json!({
+                        "description": "Signer role",
+                        "type": "string",
+                        "enum": ["sender", "secondary", "fee_payer"],
+                    }),
+                ),
+                ("address", string_schema("Signer account address")),
+                (
+                    "scheme",
+                    string_schema(
+                        "Account authenticator (`ed25519`, `multi_ed25519`, `single_key`, `multi_key`, ...)",
+                    ),
+                ),
+                (
+                    "public_keys",
+                    array_schema("Keys of the account authenticator", SignerKey::output_schema()),
+                ),
+                (
+                    "threshold",
+                    nullable(integer_schema(
+                        "Signatures required; null for single-key schemes",
+                    )),
+                ),
+                (
+                    "signed_indices",
+                    array_schema(
+                        "Key indices that signed",
+                        integer_schema("Key index"),
+                    ),
+                ),
+            ],
+        )
+    }
+}
+
+impl OutputSchema for SignersReport {
+    fn output_schema() -> Value {
+        object_schema(
+            "Decoded transaction authenticator",
+            &[
+                (
+                    "scheme",
+                    string_schema(
+                        "Transaction authenticator (`ed25519`, `multi_ed25519`, `single_sender`, `multi_agent`, `fee_payer`, ...)",
+                    ),
+                ),
+                (
+                    "signers",
+                    array_schema(
+                        "Sender first, then secondary signers, then the fee payer",
+                        Signer::output_schema(),
+                    ),
+                ),
+                (
+                    "fee_payer",
+                    nullable(string_schema("Fee payer address when sponsored")),
+                ),
+            ],
+        )
+    }
+}
+
+pub(crate) fn signers_output_schema() -> Value {
+    SignersReport::output_schema()
+}
+
+pub(crate) fn run_tx_signers(client: &AptosClient, args: &TxSignersArgs) -> Result<()> {
+    let tx = fetch_transaction(client, &args.version_or_hash)?;
+    let report = decode_signers(tx.require_user("only user transactions have signers")?)?;
+    if args.pretty {
+        print_pretty_signers(&report);
+        return Ok(());
+    }
+    crate::print_serialized(&report)
+}
+
+fn decode_signers(tx: &Value) -> Result<SignersReport> {
+    let signature = tx.get("signature").ok_or_else(|| {
+        anyhow!(
+            "transaction has no signature (type `{}`)",
+            get_nested_string(tx, &["type"])
+        )
+    })?;
+    let sender = get_nested_string(tx, &["sender"]);
+    let scheme = scheme_name(signature);
+
+    let mut signers = Vec::new();
+    let mut fee_payer = None;
+    match scheme.as_str() {
+        "multi_agent" | "fee_payer" => {
+            let primary = signature
+                .get("sender")
+                .ok_or_else(|| anyhow!("{scheme} signature has no sender authenticator"))?;
+            signers.push(decode_account(primary, "sender", &sender));
+
+            let addresses = signature
+                .get("secondary_signer_addresses")
+                .and_then(Value::as_array)
+                .map(Vec::as_slice)
+                .unwrap_or_default();
+            let authenticators = signature
+                .get("secondary_signers")
+                .and_then(Value::as_array)
+                .map(Vec::as_slice)
+                .unwrap_or_default();
+            if addresses.len() != authenticators.len() {
+                return Err(anyhow!(
+                    "{} secondary signer addresses but {} authenticators",
+                    addresses.len(),
+                    authenticators.len()
+                ));
+            }
+            for (address, authenticator) in addresses.iter().zip(authenticators) {
+                signers.push(decode_account(
+                    authenticator,
+                    "secondary",
+                    &value_to_string(address),
+                ));
+            }
+
+            if scheme == "fee_payer" {
+                let address = get_nested_string(signature, &["fee_payer_address"]);
+                let authenticator = signature
+                    .get("fee_payer_signer")
+                    .ok_or_else(|| anyhow!("fee_payer signature has no fee payer authenticator"))?;
+                signers.push(decode_account(authenticator, "fee_payer", &address));
+                fee_payer = Some(address);
+            }
+        }
+        // Single-sender transactions carry the account authenticator directly.
+        _ => signers.push(decode_account(signature, "sender", &sender)),
+    }
+
+    Ok(SignersReport {
+        scheme,
+        signers,
+        fee_payer,
+    })
+}
+
+/// `ed25519_signature` -> `ed25519`.
+fn scheme_name(authenticator: &Value) -> String {
+    let kind = get_nested_string(authenticator, &["type"]);
+    kind.strip_suffix("_signature").unwrap_or(&kind).to_owned()
+}
+
+fn decode_account(authenticator: &Value, role: &'static str, address: &str) -> Signer {
+    let scheme = scheme_name(authenticator);
+    let mut signer = Signer {
+        role,
+        address: address.to_owned(),
+        scheme: scheme.clone(),
+        public_keys: Vec::new(),
+        threshold: None,
+        signed_indices: Vec::new(),
+    };
+    match scheme.as_str() {
+        "ed25519" => {
+            signer.public_keys.push(SignerKey {
+                index: 0,
+                key_type: "ed25519".to_owned(),
+                public_key: get_nested_string(authenticator, &["public_key"]),
+                signed: true,
+            });
+        }
+        "single_key" => {
+            let key = authenticator.get("public_key").unwrap_or(&Value::Null);
+            signer.public_keys.push(SignerKey {
+                index: 0,
+                key_type: get_nested_string(key, &["type"]),
+                public_key: get_nested_string(key, &["value"]),
+                signed: true,
+            });
+        }
+        "multi_ed25519" => {
+            let signed = bitmap_indices(&get_nested_string(authenticator, &["bitmap"]));
+            signer.threshold = authenticator.get("threshold").and_then(parse_u64);
+            signer.public_keys = keys(authenticator, |_| "ed25519".to_owned(), &signed);
+            signer.signed_indices = signed;
+        }
+        "multi_key" => {
+            let signed: Vec<u64> = authenticator
+                .get("signatures")
+                .and_then(Value::as_array)
+                .map(|signatures| {
+                    signatures
+                        .iter()
+                        .filter_map(|signature| signature.get("index").and_then(parse_u64))
+                        .collect()
+                })
+                .unwrap_or_default();
+            signer.threshold = authenticator.get("signatures_required").and_then(parse_u64);
+            signer.public_keys = keys(
+                authenticator,
+                |key| get_nested_string(key, &["type"]),
+                &signed,
+            );
+            signer.signed_indices = signed;
+        }
+        // `no_account`, `abstraction` and future schemes carry no key material
+        // we can decode.
+        _ => {}
+    }
+    signer
+}
+
+fn keys(
+    authenticator: &Value,
+    key_type: impl Fn(&Value) -> String,
+    signed: &[u64],
+) -> Vec<SignerKey> {
+    authenticator
+        .get("public_keys")
+        .and_then(Value::as_array)
+        .map(Vec::as_slice)
+        .unwrap_or_default()
+        .iter()
+        .zip(0u64..)
+        .map(|(key, index)| SignerKey {
+            index,
+            key_type: key_type(key),
+            public_key: match key {
+                Value::String(key) => key.clone(),
+                other => get_nested_string(other, &["value"]),
+            },
+            signed: signed.contains(&index),
+        })
+        .collect()
+}
+
+/// Key indices set in a multi-ed25519 bitmap; bit 0 is the most significant
+/// bit of the first byte.
+fn bitmap_indices(bitmap: &str) -> Vec<u64> {
+    let Ok(bytes) = hex::decode(bitmap.trim_start_matches("0x")) else {
+        return Vec::new();
+    };
+    (0u64..)
+        .zip(
+            bytes
+                .iter()
+                .flat_map(|byte| (0..8).map(move |bit| byte & (0x80 >> bit) != 0)),
+        )
+        .filter_map(|(index, set)| set.then_some(index))
+        .collect()
+}
+
+fn print_pretty_signers(report: &SignersReport) {
+    println!("authenticator: {}", report.scheme);
+    if let Some(fee_payer) = &report.fee_payer {
+        println!("fee payer:     {fee_payer}");
+    }
+    for signer in &report.signers {
+        let threshold = match signer.threshold {
+            Some(threshold) => format!(
+                "  {} of {} required, {} signed",
+                threshold,
+                signer.public_keys.len(),
+                signer.signed_indices.len()
+            ),
+            None => String::new(),
+        };
+        println!(
+            "\n{:<10} {}  ({}){threshold}",
+            signer.role, signer.address, signer.scheme
+        );
+        for key in &signer.public_keys {
+            println!(
+                "  {} [{}] {:<16} {}",
+                if key.signed { "*" } else { " " },
+                key.index,
+                key.key_type,
+                key.public_key
+            );
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::commands::schema::validate;
+
+    #[test]
+    fn decodes_single_sender_schemes() {
+        let ed25519 = json!({
+            "sender": "0xa",
+            "signature": {"type": "ed25519_signature", "public_key": "0xpk", "signature": "0xsig"}
+        });
+        let report = decode_signers(&ed25519).unwrap();
+        assert_eq!(report.scheme, "ed25519");
+        assert_eq!(report.signers[0].address, "0xa");
+        assert_eq!(report.signers[0].public_keys[0].public_key, "0xpk");
+
+        let multi_ed25519 = json!({
+            "sender": "0xa",
+            "signature": {
+                "type": "multi_ed25519_signature",
+                "public_keys": ["0xk0", "0xk1", "0xk2"],
+                "signatures": ["0xs0", "0xs2"],
+                "threshold": 2,
+                "bitmap": "0xa0000000"
+            }
+        });
+        let signer = &decode_signers(&multi_ed25519).unwrap().signers[0];
+        assert_eq!(signer.threshold, Some(2));
+        assert_eq!(signer.signed_indices, [0, 2]);
+        assert!(!signer.public_keys[1].signed);
+
+        let keyless = json!({
+            "sender": "0xa",
+            "signature": {
+                "type": "single_key_signature",
+                "public_key": {"type": "keyless", "value": "0xkk"},
+                "signature": {"type": "keyless", "value": "0xks"}
+            }
+        });
+        let signer = &decode_signers(&keyless).unwrap().signers[0];
+        assert_eq!(signer.scheme, "single_key");
+        assert_eq!(signer.public_keys[0].key_type, "keyless");
+    }
+
+    #[test]
+    fn decodes_fee_payer_with_secondary_signers() {
+        let tx = json!({
+            "sender": "0xa",
+            "signature": {
+                "type": "fee_payer_signature",
+                "sender": {
+                    "type": "multi_key_signature",
+                    "public_keys": [
+                        {"type": "ed25519", "value": "0xk0"},
+                        {"type": "secp256k1_ecdsa", "value": "0xk1"},
+                        {"type": "keyless", "value": "0xk2"}
+                    ],
+                    "signatures": [
+                        {"index": 1, "signature": {"type": "secp256k1_ecdsa", "value": "0xs1"}},
+                        {"index": 2, "signature": {"type": "keyless", "value": "0xs2"}}
+                    ],
+                    "signatures_required": 2
+                },
+                "secondary_signer_addresses": ["0xb"],
+                "secondary_signers": [{
+                    "type": "single_key_signature",
+                    "public_key": {"type": "secp256k1_ecdsa", "value": "0xbk"},
+                    "signature": {"type": "secp256k1_ecdsa", "value": "0xbs"}
+                }],
+                "fee_payer_address": "0xf",
+                "fee_payer_signer": {"type": "ed25519_signature", "public_key": "0xfk", "signature": "0xfs"}
+            }
+        });
+        let report = decode_signers(&tx).unwrap();
+        assert_eq!(report.scheme, "fee_payer");
+        assert_eq!(report.fee_payer.as_deref(), Some("0xf"));
+        let roles: Vec<(&str, &str, &str)> = report
+            .signers
+            .iter()
+            .map(|signer| (signer.role, signer.address.as_str(), signer.scheme.as_str()))
+            .collect();
+        assert_eq!(
+            roles,
+            [
+                ("sender", "0xa", "multi_key"),
+                ("secondary", "0xb", "single_key"),
+                ("fee_payer", "0xf", "ed25519"),
+            ]
+        );
+        let sender = &report.signers[0];
+        assert_eq!(sender.threshold, Some(2));
+        assert_eq!(sender.signed_indices, [1, 2]);
+        assert_eq!(sender.public_keys[2].key_type, "keyless");
+        assert_eq!(report.signers[1].public_keys[0].key_type, "secp256k1_ecdsa");
+
+        validate(
+            &signers_output_schema(),
+            &serde_json::to_value(&report).unwrap(),
+        )
+        .unwrap();
+    }
+
+    #[test]
+    fn rejects_transactions_without_signature() {
+        let err = decode_signers(&json!({"type": "block_metadata_transaction"})).unwrap_err();
+        assert!(err.to_string().contains("block_metadata_transaction"));
+    }
+}
@@ -0,0 +1,418 @@
+use anyhow::{anyhow, Result};
+use aptly_aptos::AptosClient;
+use serde::Serialize;
+use serde_json::Value;
+use std::collections::BTreeMap;
+use std::thread;
+
+use crate::commands::abort;
+use crate::commands::common::{get_nested_string, normalize_address};
+use crate::commands::schema::{
+    array_schema, boolean_schema, integer_schema, nullable, object_schema, string_schema,
+    OutputSchema,
+};
+use crate::commands::tx::{
+    aggregate_events, simulate_payload, transaction_balance_changes, SimulationParams,
+    SimulationSummary, Transaction,
+};
+
+/// Stands in for each simulation's own sender in balance rows, so the
+/// senders' changes line up.
+const SENDER_ROLE: &str = "sender";
+
+/// `tx simulate --compare-sender`: both outcomes plus the event counts and
+/// net balance changes that differ between them.
+#[derive(Debug, Clone, Serialize)]
+struct SimulationComparison {
+    primary: SenderOutcome,
+    compare: SenderOutcome,
+    event_counts: Vec<EventCountDiff>,
+    balance_changes: Vec<BalanceDiff>,
+}
+
+#[derive(Debug, Clone, Serialize)]
+struct SenderOutcome {
+    sender: String,
+    #[serde(flatten)]
+    summary: SimulationSummary,
+}
+
+#[derive(Debug, Clone, PartialEq, Eq, Serialize)]
+struct EventCountDiff {
+    #[serde(rename = "type")]
+    event_type: String,
+    primary: u64,
+    compare: u64,
+}
+
+#[derive(Debug, Clone, PartialEq, Eq, Serialize)]
+struct BalanceDiff {
+    account: String,
+    asset: String,
+    primary: String,
+    compare: String,
+}
+
+/// What one simulation contributes to the comparison.
+struct Simulated {
+    outcome: SenderOutcome,
+    event_counts: BTreeMap<String, u64>,
+    /// Net amount per (account, asset); the sender's own account is
+    /// [`SENDER_ROLE`].
+    balances: BTreeMap<(String, String), String>,
+}
+
+impl OutputSchema for SimulationComparison {
+    fn output_schema() -> Value {
+        let outcome = object_schema(
+            "Simulation outcome for one sender",
+            &[
+                ("sender", string_schema("Simulated sender address")),
+                (
+                    "success",
+                    boolean_schema("Whether the simulated transaction succeeded"),
+                ),
+                ("vm_status", string_schema("VM status reported by the node")),
+                ("gas_used", string_schema("Gas units used")),
+                ("abort", nullable(abort::resolved_abort_schema())),
+            ],
+        );
+        object_schema(
+            "Side-by-side simulation of one payload from two senders",
+            &[
+                ("primary", outcome.clone()),
+                ("compare", outcome),
+                (
+                    "event_counts",
+                    array_schema(
+                        "Event types emitted a different number of times",
+                        object_schema(
+                            "Event count per sender",
+                            &[
+                                ("type", string_schema("Event type")),
+                                ("primary", integer_schema("Count for the primary sender")),
+                                ("compare", integer_schema("Count for `--compare-sender`")),
+                            ],
+                        ),
+                    ),
+                ),
+                (
+                    "balance_changes",
+                    array_schema(
+                        "Net balance changes that differ",
+                        object_schema(
+                            "Signed net amount per sender, in base units",
+                            &[
+                                (
+                                    "account",
+                                    string_schema("`sender` for each simulation's own sender, else the address"),
+                                ),
+                                ("asset", string_schema("Fungible asset metadata address")),
+                                ("primary", string_schema("Net amount for the primary sender")),
+                                ("compare", string_schema("Net amount for `--compare-sender`")),
+                            ],
+                        ),
+                    ),
+                ),
+            ],
+        )
+    }
+}
+
+pub(crate) fn comparison_output_schema() -> Value {
+    SimulationComparison::output_schema()
+}
+
+/// Simulates `payload` from both senders concurrently and prints the joined
+/// report. Fails after printing when the primary sender's simulation fails.
+pub(crate) fn run_simulation_compare(
+    client: &AptosClient,
+    params: &SimulationParams,
+    payload: &Value,
+    sender: &str,
+    compare_sender: &str,
+    pretty: bool,
+) -> Result<()> {
+    let simulate = |sender: &str| -> Result<Simulated> {
+        let tx = simulate_payload(client, params, sender, payload)?;
+        Ok(summarize(client, sender, tx))
+    };
+    let (primary, compare) = thread::scope(|scope| {
+        let compare = scope.spawn(|| simulate(compare_sender));
+        let primary = simulate(sender);
+        (primary, compare.join().expect("simulation thread panicked"))
+    });
+    let report = compare_simulations(primary?, compare?);
+
+    if pretty {
+        print!("{}", render_pretty(&report));
+    } else {
+        crate::print_serialized(&report)?;
+    }
+    if !report.primary.summary.success {
+        return Err(anyhow!(
+            "simulation from {} failed: {}",
+            report.primary.sender,
+            report.primary.summary.vm_status
+        ));
+    }
+    Ok(())
+}
+
+fn summarize(client: &AptosClient, sender: &str, tx: Value) -> Simulated {
+    let mut event_counts = BTreeMap::new();
+    for event in tx
+        .get("events")
+        .and_then(Value::as_array)
+        .into_iter()
+        .flatten()
+    {
+        *event_counts
+            .entry(get_nested_string(event, &["type"]))
+            .or_insert(0) += 1;
+    }
+    let outcome = SenderOutcome {
+        sender: sender.to_owned(),
+        summary: SimulationSummary::new(client, &tx),
+    };
+    let changes = transaction_balance_changes(client, &Transaction::new(tx));
+    let own = normalize_address(sender);
+    let balances = aggregate_events(&changes)
+        .into_iter()
+        .map(|change| {
+            let account = if normalize_address(&change.account) == own {
+                SENDER_ROLE.to_owned()
+            } else {
+                change.account
+            };
+            ((account, change.asset), change.amount)
+        })
+        .collect();
+    Simulated {
+        outcome,
+        event_counts,
+        balances,
+    }
+}
+
+fn compare_simulations(primary: Simulated, compare: Simulated) -> SimulationComparison {
+    let mut event_types: Vec<&String> = primary
+        .event_counts
+        .keys()
+        .chain(compare.event_counts.keys())
+        .collect();
+    event_types.sort();
+    event_types.dedup();
+    let event_counts = event_types
+        .into_iter()
+        .map(|event_type| EventCountDiff {
+            event_type: event_type.clone(),
+            primary: primary.event_counts.get(event_type).copied().unwrap_or(0),
+            compare: compare.event_counts.get(event_type).copied().unwrap_or(0),
+        })
+        .filter(|diff| diff.primary != diff.compare)
+        .collect();
+
+    let mut keys: Vec<&(String, String)> = primary
+        .balances
+        .keys()
+        .chain(compare.balances.keys())
+        .collect();
+    keys.sort();
+    keys.dedup();
+    let amount = |balances: &BTreeMap<(String, String), String>, key| {
+        balances.get(key).cloned().unwrap_or_else(|| "0".to_owned())
+    };
+    let balance_changes = keys
+        .into_iter()
+        .map(|key| BalanceDiff {
+            account: key.0.clone(),
+            asset: key.1.clone(),
+            primary: amount(&primary.balances, key),
+            compare: amount(&compare.balances, key),
+        })
+        .filter(|diff| diff.primary != diff.compare)
+        .collect();
+
+    SimulationComparison {
+        primary: primary.outcome,
+        compare: compare.outcome,
+        event_counts,
+        balance_changes,
+    }
+}
+
+/// Two-column text summary; rows after the outcome only list differences.
+fn render_pretty(report: &SimulationComparison) -> String {
+    let outcome_rows = |outcome: &SenderOutcome| {
+        let summary = &outcome.summary;
+        [
+            outcome.sender.clone(),
+            if summary.success { "yes" } else { "no" }.to_owned(),
+            summary.vm_status.clone(),
+            summary.gas_used.clone(),
+            summary
+                .abort
+                .as_ref()
+                .map_or_else(|| "-".to_owned(), ToString::to_string),
+        ]
+    };
+    let labels = ["sender", "success", "vm_status", "gas_used", "abort"];
+    let mut rows: Vec<[String; 3]> =
+        vec![["".to_owned(), "primary".to_owned(), "compare".to_owned()]];
+    for ((label, primary), compare) in labels
+        .iter()
+        .zip(outcome_rows(&report.primary))
+        .zip(outcome_rows(&report.compare))
+    {
+        rows.push([(*label).to_owned(), primary, compare]);
+    }
+    for diff in &report.event_counts {
+        rows.push([
+            format!("event {}", diff.event_type),
+            diff.primary.to_string(),
+            diff.compare.to_string(),
+        ]);
+    }
+    for diff in &report.balance_changes {
+        rows.push([
+            format!("balance {} {}", diff.account, diff.asset),
+            diff.primary.clone(),
+            diff.compare.clone(),
+        ]);
+    }
+
+    let width = |column: usize| rows.iter().map(|row| row[column].len()).max().unwrap_or(0);
+    let (label_width, primary_width) = (width(0), width(1));
+    let mut out = String::new();
+    for [label, primary, compare] in &rows {
+        let line = format!("{label:<label_width$}  {primary:<primary_width$}  {compare}");
+        out.push_str(line.trim_end());
+        out.push('\n');
+    }
+    if report.event_counts.is_empty() && report.balance_changes.is_empty() {
+        out.push_str("no differences in events or balance changes\n");
+    }
+    out
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::commands::schema::validate;
+
+    const APT: &str = "0xa";
+
+    fn simulated(
+        sender: &str,
+        success: bool,
+        events: &[&str],
+        balances: &[(&str, &str)],
+    ) -> Simulated {
+        let mut event_counts = BTreeMap::new();
+        for event in events {
+            *event_counts.entry(event.to_string()).or_insert(0) += 1;
+        }
+        Simulated {
+            outcome: SenderOutcome {
+                sender: sender.to_owned(),
+                summary: SimulationSummary {
+                    success,
+                    vm_status: if success {
+                        "Executed successfully".to_owned()
+                    } else {
+                        "Move abort in 0xbeef::vault: ENOT_ADMIN(0x50001)".to_owned()
+                    },
+                    gas_used: if success { "12" } else { "5" }.to_owned(),
+                    abort: None,
+                },
+            },
+            event_counts,
+            balances: balances
+                .iter()
+                .map(|(account, amount)| {
+                    ((account.to_string(), APT.to_owned()), amount.to_string())
+                })
+                .collect(),
+        }
+    }
+
+    fn admin_and_user() -> SimulationComparison {
+        let admin = simulated(
+            "0xad",
+            true,
+            &[
+                "0xbeef::vault::Paused",
+                "0x1::fungible_asset::Withdraw",
+                "0x1::transaction_fee::FeeStatement",
+            ],
+            &[(SENDER_ROLE, "-1200"), ("0xbeef", "0")],
+        );
+        let user = simulated(
+            "0xb0b",
+            false,
+            &["0x1::transaction_fee::FeeStatement"],
+            &[(SENDER_ROLE, "-500")],
+        );
+        compare_simulations(admin, user)
+    }
+
+    #[test]
+    fn reports_only_differences() {
+        let report = admin_and_user();
+        assert_eq!(
+            report.event_counts,
+            [
+                EventCountDiff {
+                    event_type: "0x1::fungible_asset::Withdraw".to_owned(),
+                    primary: 1,
+                    compare: 0,
+                },
+                EventCountDiff {
+                    event_type: "0xbeef::vault::Paused".to_owned(),
+                    primary: 1,
+                    compare: 0,
+                },
+            ]
+        );
+        assert_eq!(
+            report.balance_changes,
+            [BalanceDiff {
+                account: SENDER_ROLE.to_owned(),
+                asset: APT.to_owned(),
+                primary: "-1200".to_owned(),
+                compare: "-500".to_owned(),
+            }],
+            "a zero net change matches a missing row"
+        );
+        validate(
+            &SimulationComparison::output_schema(),
+            &serde_json::to_value(&report).unwrap(),
+        )
+        .unwrap();
+    }
+
+    #[test]
+    fn renders_two_columns() {
+        let expected = [
+            "                                     primary                compare",
+            "sender                               0xad                   0xb0b",
+            "success                              yes                    no",
+            "vm_status                            Executed successfully  Move abort in 0xbeef::vault: ENOT_ADMIN(0x50001)",
+            "gas_used                             12                     5",
+            "abort                                -                      -",
+            "event 0x1::fungible_asset::Withdraw  1                      0",
+            "event 0xbeef::vault::Paused          1                      0",
+            "balance sender 0xa                   -1200                  -500",
+            "",
+        ]
+        .join("\n");
+        assert_eq!(render_pretty(&admin_and_user()), expected);
+
+        let same = compare_simulations(
+            simulated("0x1", true, &["0x1::a::B"], &[]),
+            simulated("0x2", true, &["0x1::a::B"], &[]),
+        );
+        assert!(render_pretty(&same).ends_with("no differences in events or balance changes\n"));
+    }
+}
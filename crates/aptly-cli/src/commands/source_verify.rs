@@ -0,0 +1,620 @@
+use anyhow::{anyhow, Context, Result};
+use aptly_aptos::AptosClient;
+use clap::Args;
+use regex::Regex;
+use serde::Serialize;
+use serde_json::{json, Value};
+use std::collections::{BTreeMap, BTreeSet};
+use std::fs;
+use std::path::{Path, PathBuf};
+
+use crate::commands::account::{decode_source, package_registry, DEFAULT_MAX_SOURCE_BYTES};
+use crate::commands::schema::{
+    array_schema, boolean_schema, integer_schema, nullable, object_schema, string_schema,
+    OutputSchema,
+};
+use crate::commands::text_diff::unified_diff;
+
+#[derive(Args)]
+pub(crate) struct SourceVerifyArgs {
+    /// Account address (`0x...`).
+    #[arg(value_name = "ADDRESS")]
+    pub(crate) address: String,
+    /// Published package to verify.
+    #[arg(long = "package", value_name = "NAME")]
+    pub(crate) package_name: String,
+    /// Local package directory; modules are read from its `sources/`.
+    #[arg(long, value_name = "DIR")]
+    pub(crate) local: PathBuf,
+    /// Treat lines that differ only in whitespace as identical.
+    #[arg(long, default_value_t = false)]
+    pub(crate) ignore_whitespace: bool,
+    /// Read from a historical ledger version.
+    #[arg(long)]
+    pub(crate) ledger_version: Option<u64>,
+    /// Maximum decompressed size of a single module source, in bytes.
+    #[arg(long, default_value_t = DEFAULT_MAX_SOURCE_BYTES)]
+    pub(crate) max_source_bytes: u64,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "snake_case")]
+enum ModuleStatus {
+    Identical,
+    Different,
+    /// Published, but no local file declares the module.
+    MissingLocal,
+    /// Declared locally, but not published in the package.
+    MissingOnchain,
+    /// Published without source metadata, or the source failed to decode.
+    NoOnchainSource,
+}
+
+#[derive(Debug, Clone, Serialize)]
+pub(crate) struct ModuleCheck {
+    module: String,
+    status: ModuleStatus,
+    local_path: Option<String>,
+    diff: Option<String>,
+    reason: Option<String>,
+}
+
+#[derive(Debug, Clone, Serialize)]
+struct SourceVerifyReport {
+    address: String,
+    package: String,
+    local: String,
+    ignore_whitespace: bool,
+    verified: bool,
+    identical: u64,
+    mismatched: u64,
+    modules: Vec<ModuleCheck>,
+}
+
+impl OutputSchema for ModuleCheck {
+    fn output_schema() -> Value {
+        object_schema(
+            "Comparison of one module's on-chain and local source",
+            &[
+                ("module", string_schema("Module name")),
+                (
+                    "status",
+                    json!({
+                        "description": "Comparison result",
+                        "type": "string",
+                        "enum": [
+                            "identical",
+                            "different",
+                            "missing_local",
+                            "missing_onchain",
+                            "no_onchain_source",
+                        ],
+                    }),
+                ),
+                (
+                    "local_path",
+                    nullable(string_schema("Local file declaring the module")),
+                ),
+                (
+                    "diff",
+                    nullable(string_schema(
+                        "Unified diff from on-chain to local source when `different`",
+                    )),
+                ),
+                (
+                    "reason",
+                    nullable(string_schema(
+                        "Why the on-chain source is unavailable when `no_onchain_source`",
+                    )),
+                ),
+            ],
+        )
+    }
+}
+
+impl OutputSchema for SourceVerifyReport {
+    fn output_schema() -> Value {
+        object_schema(
+            "Published package sources compared with a local checkout; exits non-zero unless verified",
+            &[
+                ("address", string_schema("Publishing account address")),
+                ("package", string_schema("Package name")),
+                ("local", string_schema("Local package directory")),
+                (
+                    "ignore_whitespace",
+                    boolean_schema("Whether whitespace-only line changes were ignored"),
+                ),
+                (
+                    "verified",
+                    boolean_schema("Whether every module is identical on both sides"),
+                ),
+                ("identical", integer_schema("Modules with identical source")),
+                (
+                    "mismatched",
+                    integer_schema("Modules that differ or are missing on either side"),
+                ),
+                (
+                    "modules",
+                    array_schema("Per-module results, by name", ModuleCheck::output_schema()),
+                ),
+            ],
+        )
+    }
+}
+
+pub(crate) fn source_verify_output_schema() -> Value {
+    SourceVerifyReport::output_schema()
+}
+
+pub(crate) fn run_source_verify(client: &AptosClient, args: &SourceVerifyArgs) -> Result<()> {
+    let local = local_modules(&args.local.join("sources"))?;
+    let packages = package_registry(client, &args.address, args.ledger_version)?;
+    let package = packages
+        .iter()
+        .find(|package| package.get("name").and_then(Value::as_str) == Some(&args.package_name))
+        .ok_or_else(|| {
+            let names: Vec<&str> = packages
+                .iter()
+                .filter_map(|package| package.get("name").and_then(Value::as_str))
+                .collect();
+            anyhow!(
+                "package {:?} not found at {} (published: {})",
+                args.package_name,
+                args.address,
+                if names.is_empty() {
+                    "none".to_owned()
+                } else {
+                    names.join(", ")
+                }
+            )
+        })?;
+    let onchain = onchain_sources(package, args.max_source_bytes);
+
+    let modules = compare_modules(&args.package_name, &onchain, &local, args.ignore_whitespace);
+    let identical = modules
+        .iter()
+        .filter(|check| check.status == ModuleStatus::Identical)
+        .count() as u64;
+    let mismatched = modules.len() as u64 - identical;
+    let report = SourceVerifyReport {
+        address: args.address.clone(),
+        package: args.package_name.clone(),
+        local: args.local.display().to_string(),
+        ignore_whitespace: args.ignore_whitespace,
+        verified: mismatched == 0,
+        identical,
+        mismatched,
+        modules,
+    };
+    crate::print_serialized(&report)?;
+    if !report.verified {
+        return Err(anyhow!(
+            "{mismatched} of {} modules in {} do not match {}",
+            report.modules.len(),
+            report.package,
+            report.local
+        ));
+    }
+    Ok(())
+}
+
+/// `account source-code --diff`: checks of every module published in
+/// `packages` and every module declared under `<local>/sources`, by name,
+/// optionally only `module`. Module names are unique per account, so each
+/// local module is paired with whichever package publishes it.
+pub(crate) fn diff_packages(
+    packages: &[&Value],
+    module: Option<&str>,
+    local: &Path,
+    max_source_bytes: u64,
+) -> Result<Vec<ModuleCheck>> {
+    let mut local = local_modules(&local.join("sources"))?;
+    local.retain(|name, _| module.map_or(true, |module| name == module));
+    let mut checks = Vec::new();
+    for package in packages {
+        let name = package
+            .get("name")
+            .and_then(Value::as_str)
+            .unwrap_or_default();
+        let mut onchain = onchain_sources(package, max_source_bytes);
+        onchain.retain(|name, _| module.map_or(true, |module| name == module));
+        let paired: BTreeMap<String, LocalModule> = onchain
+            .keys()
+            .filter_map(|name| local.remove_entry(name))
+            .collect();
+        checks.extend(compare_modules(name, &onchain, &paired, false));
+    }
+    checks.extend(compare_modules("", &BTreeMap::new(), &local, false));
+    checks.sort_by(|a, b| a.module.cmp(&b.module));
+    if let (Some(module), true) = (module, checks.is_empty()) {
+        return Err(anyhow!(
+            "module {module:?} is neither published nor found locally"
+        ));
+    }
+    Ok(checks)
+}
+
+/// Prints the diff of each modified module, then one status line per
+/// module, with unverifiable modules listed last. Fails when any verifiable
+/// module differs or is missing on either side.
+pub(crate) fn print_source_diff(checks: &[ModuleCheck], local: &Path) -> Result<()> {
+    for diff in checks.iter().filter_map(|check| check.diff.as_deref()) {
+        print!("{diff}");
+    }
+    let (unverifiable, verifiable): (Vec<&ModuleCheck>, Vec<&ModuleCheck>) = checks
+        .iter()
+        .partition(|check| check.status == ModuleStatus::NoOnchainSource);
+    for line in source_diff_status_lines(&verifiable) {
+        println!("{line}");
+    }
+    if !unverifiable.is_empty() {
+        println!();
+        for line in source_diff_status_lines(&unverifiable) {
+            println!("{line}");
+        }
+    }
+    let mismatched = verifiable
+        .iter()
+        .filter(|check| check.status != ModuleStatus::Identical)
+        .count();
+    if mismatched > 0 {
+        return Err(anyhow!(
+            "{mismatched} of {} verifiable modules do not match {}",
+            verifiable.len(),
+            local.display()
+        ));
+    }
+    Ok(())
+}
+
+fn source_diff_status_lines(checks: &[&ModuleCheck]) -> Vec<String> {
+    checks
+        .iter()
+        .map(|check| {
+            let status = match check.status {
+                ModuleStatus::Identical => "identical",
+                ModuleStatus::Different => "modified",
+                ModuleStatus::MissingLocal => "missing-locally",
+                ModuleStatus::MissingOnchain => "missing-on-chain",
+                ModuleStatus::NoOnchainSource => "unverifiable",
+            };
+            match &check.reason {
+                Some(reason) => format!("{status:<16}  {} ({reason})", check.module),
+                None => format!("{status:<16}  {}", check.module),
+            }
+        })
+        .collect()
+}
+
+/// Decoded source of each published module, or why it is unavailable.
+fn onchain_sources(package: &Value, max_source_bytes: u64) -> BTreeMap<String, Result<String>> {
+    package
+        .get("modules")
+        .and_then(Value::as_array)
+        .into_iter()
+        .flatten()
+        .filter_map(|module| {
+            let name = module.get("name").and_then(Value::as_str)?.to_owned();
+            let source = match module.get("source").and_then(Value::as_str) {
+                Some(hex) if !hex.is_empty() && hex != "0x" => decode_source(hex, max_source_bytes),
+                _ => Err(anyhow!(
+                    "published without source metadata (compiled without --save-metadata)"
+                )),
+            };
+            Some((name, source))
+        })
+        .collect()
+}
+
+/// A module declared by a local source file.
+#[derive(Debug, Clone, PartialEq, Eq)]
+struct LocalModule {
+    path: PathBuf,
+    source: String,
+}
+
+/// Modules declared by the `.move` files under `sources`, by name. A file
+/// declaring several modules is listed under each; `#[test_only]` modules are
+/// never published, so they are skipped.
+fn local_modules(sources: &Path) -> Result<BTreeMap<String, LocalModule>> {
+    if !sources.is_dir() {
+        return Err(anyhow!(
+            "{} is not a directory; pass the package directory that contains sources/",
+            sources.display()
+        ));
+    }
+    let declaration = Regex::new(
+        r"(?m)^\s*(#\[test_only\]\s*)?module\s+(?:[0-9A-Za-z_]+::)?([A-Za-z_][0-9A-Za-z_]*)",
+    )
+    .expect("module declaration pattern");
+
+    let mut files = Vec::new();
+    let mut pending = vec![sources.to_owned()];
+    while let Some(dir) = pending.pop() {
+        for entry in
+            fs::read_dir(&dir).with_context(|| format!("failed to read {}", dir.display()))?
+        {
+            let path = entry?.path();
+            if path.is_dir() {
+                pending.push(path);
+            } else if path.extension().is_some_and(|ext| ext == "move") {
+                files.push(path);
+            }
+        }
+    }
+    files.sort();
+
+    let mut modules = BTreeMap::new();
+    for path in files {
+        let source = fs::read_to_string(&path)
+            .with_context(|| format!("failed to read {}", path.display()))?;
+        for captures in declaration.captures_iter(&source) {
+            if captures.get(1).is_some() {
+                continue;
+            }
+            let name = captures[2].to_owned();
+            let module = LocalModule {
+                path: path.clone(),
+                source: source.clone(),
+            };
+            if let Some(previous) = modules.insert(name.clone(), module) {
+                return Err(anyhow!(
+                    "module {name} is declared in both {} and {}",
+                    previous.path.display(),
+                    path.display()
+                ));
+            }
+        }
+    }
+    Ok(modules)
+}
+
+fn compare_modules(
+    package: &str,
+    onchain: &BTreeMap<String, Result<String>>,
+    local: &BTreeMap<String, LocalModule>,
+    ignore_whitespace: bool,
+) -> Vec<ModuleCheck> {
+    let names: BTreeSet<&String> = onchain.keys().chain(local.keys()).collect();
+    names
+        .into_iter()
+        .map(|name| {
+            let local_module = local.get(name);
+            let mut check = ModuleCheck {
+                module: name.clone(),
+                status: ModuleStatus::Identical,
+                local_path: local_module.map(|module| module.path.display().to_string()),
+                diff: None,
+                reason: None,
+            };
+            match (onchain.get(name), local_module) {
+                (None, _) => check.status = ModuleStatus::MissingOnchain,
+                (Some(Err(err)), _) => {
+                    check.status = ModuleStatus::NoOnchainSource;
+                    check.reason = Some(format!("{err:#}"));
+                }
+                (Some(Ok(_)), None) => check.status = ModuleStatus::MissingLocal,
+                (Some(Ok(published)), Some(module)) => {
+                    // Checkouts on Windows may carry CRLF line endings.
+                    check.diff = unified_diff(
+                        &format!("onchain/{package}/{name}.move"),
+                        &module.path.display().to_string(),
+                        &published.replace("\r\n", "\n"),
+                        &module.source.replace("\r\n", "\n"),
+                        ignore_whitespace,
+                    );
+                    if check.diff.is_some() {
+                        check.status = ModuleStatus::Different;
+                    }
+                }
+            }
+            check
+        })
+        .collect()
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::commands::schema::validate;
+
+    fn write(dir: &Path, relative: &str, body: &str) -> PathBuf {
+        let path = dir.join(relative);
+        fs::create_dir_all(path.parent().unwrap()).unwrap();
+        fs::write(&path, body).unwrap();
+        path
+    }
+
+    #[test]
+    fn finds_local_modules_by_declaration() {
+        let dir = tempfile::tempdir().unwrap();
+        let sources = dir.path().join("sources");
+        let coin = write(
+            &sources,
+            "coin.move",
+            "module 0xcafe::coin {\n}\n#[test_only]\nmodule 0xcafe::coin_tests {\n}\n",
+        );
+        let pool = write(
+            &sources,
+            "amm/pool_impl.move",
+            "address 0xcafe {\n    module pool {\n    }\n}\n",
+        );
+        write(&sources, "README.md", "module not_move {}\n");
+
+        let modules = local_modules(&sources).unwrap();
+        let names: Vec<&str> = modules.keys().map(String::as_str).collect();
+        assert_eq!(names, ["coin", "pool"]);
+        assert_eq!(modules["coin"].path, coin);
+        assert_eq!(modules["pool"].path, pool);
+
+        write(&sources, "again.move", "module 0xcafe::pool {}\n");
+        let err = local_modules(&sources).unwrap_err().to_string();
+        assert!(err.starts_with("module pool is declared in both "));
+
+        let err = local_modules(&dir.path().join("missing")).unwrap_err();
+        assert!(err.to_string().ends_with("contains sources/"));
+    }
+
+    #[test]
+    fn reports_each_module_status() {
+        let dir = tempfile::tempdir().unwrap();
+        let sources = dir.path().join("sources");
+        write(
+            &sources,
+            "same.move",
+            "module 0x1::same {\n    fun f() {}\n}\n",
+        );
+        write(
+            &sources,
+            "spaced.move",
+            "module 0x1::spaced {\n  fun f() { }\n}\n",
+        );
+        write(&sources, "extra.move", "module 0x1::extra {}\n");
+        write(&sources, "opaque.move", "module 0x1::opaque {}\n");
+        let local = local_modules(&sources).unwrap();
+
+        let onchain: BTreeMap<String, Result<String>> = [
+            (
+                "same",
+                Ok("module 0x1::same {\n    fun f() {}\n}\n".to_owned()),
+            ),
+            (
+                "spaced",
+                Ok("module 0x1::spaced {\n    fun f() {}\n}\n".to_owned()),
+            ),
+            ("gone", Ok("module 0x1::gone {}\n".to_owned())),
+            ("opaque", Err(anyhow!("source is not valid UTF-8"))),
+        ]
+        .into_iter()
+        .map(|(name, source)| (name.to_owned(), source))
+        .collect();
+
+        let statuses = |checks: &[ModuleCheck]| -> Vec<(String, ModuleStatus)> {
+            checks
+                .iter()
+                .map(|check| (check.module.clone(), check.status))
+                .collect()
+        };
+        let checks = compare_modules("pkg", &onchain, &local, false);
+        assert_eq!(
+            statuses(&checks),
+            [
+                ("extra".to_owned(), ModuleStatus::MissingOnchain),
+                ("gone".to_owned(), ModuleStatus::MissingLocal),
+                ("opaque".to_owned(), ModuleStatus::NoOnchainSource),
+                ("same".to_owned(), ModuleStatus::Identical),
+                ("spaced".to_owned(), ModuleStatus::Different),
+            ]
+        );
+        let spaced = &checks[4];
+        let diff = spaced.diff.as_deref().unwrap();
+        assert!(diff.starts_with("--- onchain/pkg/spaced.move\n+++ "));
+        assert!(diff.contains("\n-    fun f() {}\n+  fun f() { }\n"));
+        assert_eq!(
+            checks[2].reason.as_deref(),
+            Some("source is not valid UTF-8")
+        );
+
+        let checks = compare_modules("pkg", &onchain, &local, true);
+        assert_eq!(checks[4].status, ModuleStatus::Identical);
+        assert_eq!(checks[4].diff, None);
+
+        let report = SourceVerifyReport {
+            address: "0x1".to_owned(),
+            package: "pkg".to_owned(),
+            local: dir.path().display().to_string(),
+            ignore_whitespace: true,
+            verified: false,
+            identical: 2,
+            mismatched: 3,
+            modules: compare_modules("pkg", &onchain, &local, false),
+        };
+        validate(
+            &source_verify_output_schema(),
+            &serde_json::to_value(report).unwrap(),
+        )
+        .unwrap();
+    }
+
+    #[test]
+    fn diffs_every_published_package() {
+        let dir = tempfile::tempdir().unwrap();
+        let sources = dir.path().join("sources");
+        write(
+            &sources,
+            "coin.move",
+            "module 0xcafe::coin {\r\n    fun f() {}\r\n}\r\n",
+        );
+        write(
+            &sources,
+            "pool.move",
+            "module 0xcafe::pool {\n    fun g() {}\n}\n",
+        );
+        write(
+            &sources,
+            "local_only.move",
+            "module 0xcafe::local_only {}\n",
+        );
+        let plain = |source: &str| format!("0x{}", hex::encode(source));
+        let core = json!({"name": "Core", "modules": [
+            {"name": "coin", "source": plain("module 0xcafe::coin {\n    fun f() {}\n}\n")},
+            {"name": "stripped", "source": "0x"},
+        ]});
+        let dex = json!({"name": "Dex", "modules": [
+            {"name": "pool", "source": plain("module 0xcafe::pool {\n    fun h() {}\n}\n")},
+            {"name": "router", "source": plain("module 0xcafe::router {}\n")},
+        ]});
+
+        let checks =
+            diff_packages(&[&core, &dex], None, dir.path(), DEFAULT_MAX_SOURCE_BYTES).unwrap();
+        let refs: Vec<&ModuleCheck> = checks.iter().collect();
+        assert_eq!(
+            source_diff_status_lines(&refs),
+            [
+                "identical         coin",
+                "missing-on-chain  local_only",
+                "modified          pool",
+                "missing-locally   router",
+                "unverifiable      stripped (published without source metadata (compiled without --save-metadata))",
+            ]
+        );
+        assert!(checks[2]
+            .diff
+            .as_deref()
+            .unwrap()
+            .starts_with("--- onchain/Dex/pool.move\n"));
+        let err = print_source_diff(&checks, dir.path()).unwrap_err();
+        assert!(err.to_string().starts_with("3 of 4 verifiable modules"));
+
+        let coin = diff_packages(
+            &[&core, &dex],
+            Some("coin"),
+            dir.path(),
+            DEFAULT_MAX_SOURCE_BYTES,
+        )
+        .unwrap();
+        assert_eq!(coin.len(), 1);
+        assert!(print_source_diff(&coin, dir.path()).is_ok());
+        assert!(
+            diff_packages(&[&core], Some("nope"), dir.path(), DEFAULT_MAX_SOURCE_BYTES).is_err()
+        );
+    }
+
+    #[test]
+    fn reads_registry_modules_without_source_as_unavailable() {
+        let package = json!({
+            "name": "pkg",
+            "modules": [
+                {"name": "plain", "source": format!("0x{}", hex::encode("module 0x1::plain {}"))},
+                {"name": "stripped", "source": "0x"},
+            ],
+        });
+        let sources = onchain_sources(&package, DEFAULT_MAX_SOURCE_BYTES);
+        assert_eq!(sources["plain"].as_ref().unwrap(), "module 0x1::plain {}");
+        assert!(sources["stripped"]
+            .as_ref()
+            .unwrap_err()
+            .to_string()
+            .contains("--save-metadata"));
+    }
+}
@@ -0,0 +1,376 @@
+use anyhow::{anyhow, Context, Result};
+use aptly_aptos::AptosClient;
+use clap::Args;
+use serde::Serialize;
+use serde_json::{json, Value};
+
+use crate::commands::common::{get_nested_string, normalize_address, parse_u64};
+use crate::commands::schema::{array_schema, nullable, object_schema, string_schema, OutputSchema};
+
+const STAKE_POOL_TYPE: &str = "0x1::stake::StakePool";
+const OWNER_CAPABILITY_TYPE: &str = "0x1::stake::OwnerCapability";
+const DELEGATION_OWNERSHIP_TYPE: &str = "0x1::delegation_pool::DelegationPoolOwnership";
+/// Octas per APT.
+const OCTAS: u64 = 100_000_000;
+
+#[derive(Args)]
+pub(crate) struct StakeArgs {
+    /// Account address (`0x...`).
+    #[arg(value_name = "ADDRESS")]
+    pub(crate) address: String,
+    /// Delegation pool to read the account's stake in; repeatable. Pools
+    /// given here are reported even when the stake is zero.
+    #[arg(long = "pool", value_name = "POOL_ADDRESS")]
+    pub(crate) pools: Vec<String>,
+    /// Print a table instead of JSON.
+    #[arg(long, default_value_t = false)]
+    pub(crate) pretty: bool,
+}
+
+#[derive(Debug, Clone, PartialEq, Eq, Serialize)]
+struct StakePosition {
+    /// `delegation` or `stake_pool`.
+    kind: &'static str,
+    pool: String,
+    active: String,
+    inactive: String,
+    /// Delegators have no pending-active stake of their own; null for them.
+    pending_active: Option<String>,
+    pending_inactive: String,
+}
+
+impl StakePosition {
+    /// Position from `delegation_pool::get_stake`, which returns
+    /// `[active, inactive, pending_inactive]` in octas.
+    fn delegation(pool: &str, stake: &Value) -> Result<Self> {
+        let amount = |index: usize| {
+            stake
+                .get(index)
+                .and_then(parse_u64)
+                .map(format_apt)
+                .ok_or_else(|| anyhow!("unexpected get_stake result for pool {pool}: {stake}"))
+        };
+        Ok(Self {
+            kind: "delegation",
+            pool: normalize_address(pool),
+            active: amount(0)?,
+            inactive: amount(1)?,
+            pending_active: None,
+            pending_inactive: amount(2)?,
+        })
+    }
+
+    /// Position from a `stake::StakePool` resource, whose buckets are
+    /// `Coin<AptosCoin>` values.
+    fn stake_pool(pool: &str, data: &Value) -> Result<Self> {
+        let amount = |bucket: &str| {
+            data.get(bucket)
+                .and_then(|coin| coin.get("value"))
+                .and_then(parse_u64)
+                .map(format_apt)
+                .ok_or_else(|| anyhow!("unexpected {STAKE_POOL_TYPE} format at {pool}"))
+        };
+        Ok(Self {
+            kind: "stake_pool",
+            pool: normalize_address(pool),
+            active: amount("active")?,
+            inactive: amount("inactive")?,
+            pending_active: Some(amount("pending_active")?),
+            pending_inactive: amount("pending_inactive")?,
+        })
+    }
+
+    fn is_empty(&self) -> bool {
+        [&self.active, &self.inactive, &self.pending_inactive]
+            .into_iter()
+            .chain(&self.pending_active)
+            .all(|amount| amount.trim_start_matches('0') == ".00000000")
+    }
+}
+
+impl OutputSchema for StakePosition {
+    fn output_schema() -> Value {
+        object_schema(
+            "Stake the account holds in one pool, in APT",
+            &[
+                (
+                    "kind",
+                    string_schema(
+                        "`delegation` for stake in a delegation pool, `stake_pool` for a pool the account owns",
+                    ),
+                ),
+                ("pool", string_schema("Pool address (64-hex)")),
+                ("active", string_schema("Active stake in APT")),
+                ("inactive", string_schema("Withdrawable stake in APT")),
+                (
+                    "pending_active",
+                    nullable(string_schema(
+                        "Stake activating next epoch in APT; null for delegations",
+                    )),
+                ),
+                (
+                    "pending_inactive",
+                    string_schema("Stake unlocking at the end of the lockup in APT"),
+                ),
+            ],
+        )
+    }
+}
+
+pub(crate) fn stake_output_schema() -> Value {
+    array_schema(
+        "Staking positions of the account; empty without staking activity",
+        StakePosition::output_schema(),
+    )
+}
+
+/// `account stake`: delegations in pools the account owns or names with
+/// `--pool`, and the `StakePool` it holds directly or through its
+/// `OwnerCapability`.
+pub(crate) fn run_account_stake(client: &AptosClient, args: &StakeArgs) -> Result<()> {
+    let address = normalize_address(&args.address);
+    let resources = read_resources(client, &address)?;
+    let positions = stake_positions(
+        &address,
+        &resources,
+        &args.pools,
+        |pool| {
+            view(
+                client,
+                "0x1::delegation_pool::get_stake",
+                vec![json!(pool), json!(address)],
+            )
+            .with_context(|| format!("failed to read stake of {address} in pool {pool}"))
+        },
+        |pool| {
+            let resources = read_resources(client, pool)?;
+            Ok(resource_data(&resources, STAKE_POOL_TYPE).cloned())
+        },
+    )?;
+    if args.pretty {
+        for line in pretty_stake_lines(&positions) {
+            println!("{line}");
+        }
+        return Ok(());
+    }
+    crate::print_serialized(&positions)
+}
+
+/// Builds the positions of `address` from its `resources`. `get_stake`
+/// returns the `delegation_pool::get_stake` result for a pool and
+/// `stake_pool` the `StakePool` data at another address, if any.
+fn stake_positions(
+    address: &str,
+    resources: &Value,
+    explicit_pools: &[String],
+    mut get_stake: impl FnMut(&str) -> Result<Value>,
+    mut stake_pool: impl FnMut(&str) -> Result<Option<Value>>,
+) -> Result<Vec<StakePosition>> {
+    let mut positions = Vec::new();
+
+    let mut delegation_pools: Vec<(String, bool)> = Vec::new();
+    if let Some(ownership) = resource_data(resources, DELEGATION_OWNERSHIP_TYPE) {
+        delegation_pools.push((
+            normalize_address(&get_nested_string(ownership, &["pool_address"])),
+            false,
+        ));
+    }
+    for pool in explicit_pools {
+        let pool = normalize_address(pool);
+        match delegation_pools
+            .iter_mut()
+            .find(|(known, _)| *known == pool)
+        {
+            Some((_, explicit)) => *explicit = true,
+            None => delegation_pools.push((pool, true)),
+        }
+    }
+    for (pool, explicit) in &delegation_pools {
+        let position = StakePosition::delegation(pool, &get_stake(pool)?)?;
+        if *explicit || !position.is_empty() {
+            positions.push(position);
+        }
+    }
+
+    // Delegation pools keep their `StakePool` at the pool's resource
+    // account; only pools run directly by the account are reported here.
+    let owned_pool = resource_data(resources, OWNER_CAPABILITY_TYPE)
+        .map(|capability| normalize_address(&get_nested_string(capability, &["pool_address"])));
+    if let Some(data) = resource_data(resources, STAKE_POOL_TYPE) {
+        positions.push(StakePosition::stake_pool(address, data)?);
+    }
+    if let Some(pool) = owned_pool.filter(|pool| pool != address) {
+        if let Some(data) = stake_pool(&pool)? {
+            positions.push(StakePosition::stake_pool(&pool, &data)?);
+        }
+    }
+    Ok(positions)
+}
+
+/// `--pretty` table: kind, pool, then one right-aligned column per bucket.
+fn pretty_stake_lines(positions: &[StakePosition]) -> Vec<String> {
+    if positions.is_empty() {
+        return vec!["no staking positions".to_owned()];
+    }
+    let header = [
+        "kind",
+        "pool",
+        "active",
+        "inactive",
+        "pending_active",
+        "pending_inactive",
+    ]
+    .map(str::to_owned);
+    let rows: Vec<[String; 6]> = std::iter::once(header)
+        .chain(positions.iter().map(|position| {
+            [
+                position.kind.to_owned(),
+                position.pool.clone(),
+                position.active.clone(),
+                position.inactive.clone(),
+                position
+                    .pending_active
+                    .clone()
+                    .unwrap_or_else(|| "-".to_owned()),
+                position.pending_inactive.clone(),
+            ]
+        }))
+        .collect();
+    let widths: Vec<usize> = (0..6)
+        .map(|column| {
+            rows.iter()
+                .map(|row| row[column].chars().count())
+                .max()
+                .unwrap_or(0)
+        })
+        .collect();
+    rows.iter()
+        .map(|row| {
+            let mut line = format!(
+                "{:<w0$}  {:<w1$}",
+                row[0],
+                row[1],
+                w0 = widths[0],
+                w1 = widths[1]
+            );
+            for column in 2..6 {
+                line.push_str(&format!("  {:>w$}", row[column], w = widths[column]));
+            }
+            line
+        })
+        .collect()
+}
+
+/// Octas as APT with all 8 decimals, so amounts line up in tables.
+fn format_apt(octas: u64) -> String {
+    format!("{}.{:08}", octas / OCTAS, octas % OCTAS)
+}
+
+/// Resources at `address`; an account that does not exist has none.
+fn read_resources(client: &AptosClient, address: &str) -> Result<Value> {
+    match client.get_json(&format!("/accounts/{address}/resources")) {
+        Ok(resources) => Ok(resources),
+        Err(err) if err.to_string().contains("status 404") => Ok(Value::Array(Vec::new())),
+        Err(err) => Err(err).with_context(|| format!("failed to read resources of {address}")),
+    }
+}
+
+fn resource_data<'a>(resources: &'a Value, resource_type: &str) -> Option<&'a Value> {
+    resources
+        .as_array()?
+        .iter()
+        .find(|resource| resource.get("type").and_then(Value::as_str) == Some(resource_type))?
+        .get("data")
+}
+
+fn view(client: &AptosClient, function: &str, arguments: Vec<Value>) -> Result<Value> {
+    client.post_json(
+        "/view",
+        &json!({
+            "function": function,
+            "type_arguments": [],
+            "arguments": arguments,
+        }),
+    )
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::commands::schema::validate;
+
+    fn coin(value: u64) -> Value {
+        json!({"value": value.to_string()})
+    }
+
+    #[test]
+    fn collects_delegations_and_owned_pools() {
+        let owner = normalize_address("0xa11ce");
+        let resources = json!([
+            {"type": DELEGATION_OWNERSHIP_TYPE, "data": {"pool_address": "0xd1"}},
+            {"type": OWNER_CAPABILITY_TYPE, "data": {"pool_address": "0x5a"}},
+        ]);
+        let mut queried = Vec::new();
+        let positions = stake_positions(
+            &owner,
+            &resources,
+            &["0xd2".to_owned(), "0xd1".to_owned()],
+            |pool| {
+                queried.push(pool.to_owned());
+                Ok(if pool.ends_with("d1") {
+                    json!(["150000000000", "0", "2500000000"])
+                } else {
+                    json!(["0", "0", "0"])
+                })
+            },
+            |pool| {
+                assert_eq!(pool, normalize_address("0x5a"));
+                Ok(Some(json!({
+                    "active": coin(100_000_000_000_000),
+                    "inactive": coin(0),
+                    "pending_active": coin(1),
+                    "pending_inactive": coin(0),
+                })))
+            },
+        )
+        .unwrap();
+        assert_eq!(
+            queried,
+            [normalize_address("0xd1"), normalize_address("0xd2")]
+        );
+        assert_eq!(positions.len(), 3);
+        assert_eq!(positions[0].active, "1500.00000000");
+        assert_eq!(positions[0].pending_inactive, "25.00000000");
+        assert_eq!(positions[0].pending_active, None);
+        // Named explicitly, so kept despite holding nothing.
+        assert_eq!(positions[1].active, "0.00000000");
+        assert_eq!(positions[2].kind, "stake_pool");
+        assert_eq!(positions[2].pending_active.as_deref(), Some("0.00000001"));
+        validate(
+            &stake_output_schema(),
+            &serde_json::to_value(&positions).unwrap(),
+        )
+        .unwrap();
+
+        let lines = pretty_stake_lines(&positions[..1]);
+        assert_eq!(lines.len(), 2);
+        assert!(lines[0].starts_with("kind        pool"));
+        assert!(lines[1].starts_with("delegation  0x00"));
+        assert!(lines[1].ends_with("1500.00000000  0.00000000               -       25.00000000"));
+    }
+
+    #[test]
+    fn accounts_without_staking_have_no_positions() {
+        let positions = stake_positions(
+            "0x1",
+            &json!([]),
+            &[],
+            |_| unreachable!(),
+            |_| unreachable!(),
+        )
+        .unwrap();
+        assert!(positions.is_empty());
+        assert_eq!(serde_json::to_value(&positions).unwrap(), json!([]));
+        assert_eq!(pretty_stake_lines(&positions), ["no staking positions"]);
+    }
+}
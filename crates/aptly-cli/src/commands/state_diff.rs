@@ -0,0 +1,442 @@
+use anyhow::{anyhow, Context, Result};
+use aptly_aptos::AptosClient;
+use clap::Args;
+use serde::Serialize;
+use serde_json::{json, Value};
+
+use crate::commands::common::{normalize_address, parse_u64};
+use crate::commands::schema::{array_schema, object_schema, string_schema, OutputSchema};
+use crate::commands::tx::fetch_transaction;
+
+#[derive(Args)]
+pub(crate) struct TxStateDiffArgs {
+    /// Transaction version (u64) or hash (0x...).
+    #[arg(value_name = "VERSION_OR_HASH")]
+    pub(crate) version_or_hash: String,
+    /// Only resources written under this address.
+    #[arg(long, value_name = "ADDRESS")]
+    pub(crate) address: Option<String>,
+    /// Only this resource type; every touched resource when omitted.
+    #[arg(long = "type", value_name = "RESOURCE_TYPE")]
+    pub(crate) resource_type: Option<String>,
+}
+
+#[derive(Debug, Clone, Serialize)]
+struct ResourceDiff {
+    address: String,
+    resource_type: String,
+    status: &'static str,
+    changes: Vec<FieldChange>,
+}
+
+#[derive(Debug, Clone, PartialEq, Serialize)]
+struct FieldChange {
+    path: String,
+    kind: &'static str,
+    old: Value,
+    new: Value,
+}
+
+impl OutputSchema for FieldChange {
+    fn output_schema() -> Value {
+        object_schema(
+            "One changed field",
+            &[
+                (
+                    "path",
+                    string_schema("JSON pointer into the resource data (`/coin/value`)"),
+                ),
+                (
+                    "kind",
+                    json!({
+                        "description": "Change kind",
+                        "type": "string",
+                        "enum": ["added", "removed", "changed"],
+                    }),
+                ),
+                (
+                    "old",
+                    json!({ "description": "Value before the transaction; null when added" }),
+                ),
+                (
+                    "new",
+                    json!({ "description": "Value after the transaction; null when removed" }),
+                ),
+            ],
+        )
+    }
+}
+
+impl OutputSchema for ResourceDiff {
+    fn output_schema() -> Value {
+        object_schema(
+            "Before/after diff of one resource in the write set",
+            &[
+                ("address", string_schema("Account holding the resource")),
+                (
+                    "resource_type",
+                    string_schema("Fully qualified resource type"),
+                ),
+                (
+                    "status",
+                    json!({
+                        "description": "`deleted` is a tombstone: every prior field is reported as removed",
+                        "type": "string",
+                        "enum": ["created", "modified", "deleted"],
+                    }),
+                ),
+                (
+                    "changes",
+                    array_schema("Field-level changes", FieldChange::output_schema()),
+                ),
+            ],
+        )
+    }
+}
+
+pub(crate) fn state_diff_output_schema() -> Value {
+    array_schema(
+        "Resource diffs in write-set order",
+        ResourceDiff::output_schema(),
+    )
+}
+
+pub(crate) fn run_tx_state_diff(client: &AptosClient, args: &TxStateDiffArgs) -> Result<()> {
+    let tx = fetch_transaction(client, &args.version_or_hash)?.json;
+    let version = tx
+        .get("version")
+        .and_then(parse_u64)
+        .ok_or_else(|| anyhow!("transaction has no version (still pending?)"))?;
+
+    let filter = Filter {
+        address: args.address.as_deref().map(normalize_address),
+        resource_type: args.resource_type.clone(),
+    };
+    let diffs = diff_transaction(&tx, &filter, |address, resource_type| {
+        fetch_before(client, version, address, resource_type)
+    })?;
+    crate::print_serialized(&diffs)
+}
+
+/// The resource as of `version - 1`, or `None` if it did not exist.
+fn fetch_before(
+    client: &AptosClient,
+    version: u64,
+    address: &str,
+    resource_type: &str,
+) -> Result<Option<Value>> {
+    let Some(previous) = version.checked_sub(1) else {
+        return Ok(None);
+    };
+    let path = format!(
+        "/accounts/{address}/resource/{}?ledger_version={previous}",
+        urlencoding::encode(resource_type)
+    );
+    match client.get_json(&path) {
+        Ok(resource) => Ok(Some(resource.get("data").cloned().unwrap_or(Value::Null))),
+        Err(err) if err.to_string().contains("status 404") => Ok(None),
+        Err(err) => Err(err)
+            .with_context(|| format!("failed to read {resource_type} at version {previous}")),
+    }
+}
+
+struct Filter {
+    address: Option<String>,
+    resource_type: Option<String>,
+}
+
+impl Filter {
+    fn matches(&self, address: &str, resource_type: &str) -> bool {
+        self.address
+            .as_deref()
+            .is_none_or(|wanted| normalize_address(address) == wanted)
+            && self
+                .resource_type
+                .as_deref()
+                .is_none_or(|wanted| wanted == resource_type)
+    }
+}
+
+/// Diffs every matching resource write in `tx` against the state returned by
+/// `before`.
+fn diff_transaction(
+    tx: &Value,
+    filter: &Filter,
+    mut before: impl FnMut(&str, &str) -> Result<Option<Value>>,
+) -> Result<Vec<ResourceDiff>> {
+    let changes = tx
+        .get("changes")
+        .and_then(Value::as_array)
+        .ok_or_else(|| anyhow!("transaction has no write set"))?;
+
+    let mut diffs = Vec::new();
+    for change in changes {
+        let address = change
+            .get("address")
+            .and_then(Value::as_str)
+            .unwrap_or_default();
+        let (resource_type, after) = match change.get("type").and_then(Value::as_str) {
+            Some("write_resource") => (
+                change.pointer("/data/type").and_then(Value::as_str),
+                change.pointer("/data/data").cloned(),
+            ),
+            Some("delete_resource") => (change.get("resource").and_then(Value::as_str), None),
+            _ => continue,
+        };
+        let Some(resource_type) = resource_type else {
+            continue;
+        };
+        if !filter.matches(address, resource_type) {
+            continue;
+        }
+
+        let prior = before(address, resource_type)?;
+        let status = match (&prior, &after) {
+            (None, _) => "created",
+            (Some(_), Some(_)) => "modified",
+            (Some(_), None) => "deleted",
+        };
+        let mut field_changes = Vec::new();
+        diff_values(
+            "",
+            prior.as_ref().unwrap_or(&Value::Null),
+            after.as_ref().unwrap_or(&Value::Null),
+            &mut field_changes,
+        );
+        diffs.push(ResourceDiff {
+            address: address.to_owned(),
+            resource_type: resource_type.to_owned(),
+            status,
+            changes: field_changes,
+        });
+    }
+    Ok(diffs)
+}
+
+/// Field-level diff; objects and arrays recurse, everything else compares as a
+/// leaf. A missing side (`null` at the root) expands to per-field changes.
+fn diff_values(path: &str, old: &Value, new: &Value, out: &mut Vec<FieldChange>) {
+    match (old, new) {
+        (Value::Object(old), Value::Object(new)) => {
+            for (key, old_value) in old {
+                let child = format!("{path}/{}", escape_pointer(key));
+                match new.get(key) {
+                    Some(new_value) => diff_values(&child, old_value, new_value, out),
+                    None => out.push(removed(child, old_value)),
+                }
+            }
+            for (key, new_value) in new {
+                if !old.contains_key(key) {
+                    out.push(added(format!("{path}/{}", escape_pointer(key)), new_value));
+                }
+            }
+        }
+        (Value::Array(old), Value::Array(new)) => {
+            for index in 0..old.len().max(new.len()) {
+                let child = format!("{path}/{index}");
+                match (old.get(index), new.get(index)) {
+                    (Some(old_value), Some(new_value)) => {
+                        diff_values(&child, old_value, new_value, out)
+                    }
+                    (Some(old_value), None) => out.push(removed(child, old_value)),
+                    (None, Some(new_value)) => out.push(added(child, new_value)),
+                    (None, None) => {}
+                }
+            }
+        }
+        (Value::Null, Value::Object(_)) if path.is_empty() => {
+            diff_values(path, &json!({}), new, out)
+        }
+        (Value::Object(_), Value::Null) if path.is_empty() => {
+            diff_values(path, old, &json!({}), out)
+        }
+        _ if old == new => {}
+        _ => out.push(FieldChange {
+            path: path.to_owned(),
+            kind: "changed",
+            old: old.clone(),
+            new: new.clone(),
+        }),
+    }
+}
+
+fn added(path: String, value: &Value) -> FieldChange {
+    FieldChange {
+        path,
+        kind: "added",
+        old: Value::Null,
+        new: value.clone(),
+    }
+}
+
+fn removed(path: String, value: &Value) -> FieldChange {
+    FieldChange {
+        path,
+        kind: "removed",
+        old: value.clone(),
+        new: Value::Null,
+    }
+}
+
+/// Escapes a key for use in a JSON pointer (RFC 6901).
+fn escape_pointer(key: &str) -> String {
+    key.replace('~', "~0").replace('/', "~1")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::commands::common::replay_client;
+    use crate::commands::schema::validate;
+
+    const POOL: &str = "0x1::stake::StakePool";
+    const COIN_STORE: &str = "0x1::coin::CoinStore<0x1::aptos_coin::AptosCoin>";
+
+    /// Trimmed from a `0x1::stake::increase_lockup` + unlock transaction.
+    fn staking_tx() -> Value {
+        json!({
+            "version": "1000",
+            "changes": [
+                {
+                    "type": "write_resource",
+                    "address": "0xbeef",
+                    "data": {
+                        "type": POOL,
+                        "data": {
+                            "active": {"value": "900"},
+                            "pending_inactive": {"value": "100"},
+                            "locked_until_secs": "1730000000",
+                            "operator_address": "0xbeef"
+                        }
+                    }
+                },
+                {
+                    "type": "delete_resource",
+                    "address": "0xcafe",
+                    "resource": COIN_STORE
+                },
+                {
+                    "type": "write_resource",
+                    "address": "0xcafe",
+                    "data": {"type": "0x1::account::Account", "data": {"sequence_number": "8"}}
+                },
+                {"type": "write_table_item", "handle": "0x1", "key": "0x", "value": "0x"}
+            ]
+        })
+    }
+
+    fn prior(_: &str, resource_type: &str) -> Result<Option<Value>> {
+        Ok(match resource_type {
+            POOL => Some(json!({
+                "active": {"value": "1000"},
+                "pending_inactive": {"value": "0"},
+                "locked_until_secs": "1720000000",
+                "operator_address": "0xbeef"
+            })),
+            COIN_STORE => Some(json!({"coin": {"value": "5"}, "frozen": false})),
+            _ => None,
+        })
+    }
+
+    fn all() -> Filter {
+        Filter {
+            address: None,
+            resource_type: None,
+        }
+    }
+
+    #[test]
+    fn diffs_lockup_and_balance_fields() {
+        let filter = Filter {
+            address: Some(normalize_address("0xbeef")),
+            resource_type: Some(POOL.to_owned()),
+        };
+        let diffs = diff_transaction(&staking_tx(), &filter, prior).unwrap();
+        assert_eq!(diffs.len(), 1);
+        assert_eq!(diffs[0].status, "modified");
+        let paths: Vec<(&str, &str, &str)> = diffs[0]
+            .changes
+            .iter()
+            .map(|change| {
+                (
+                    change.path.as_str(),
+                    change.old.as_str().unwrap(),
+                    change.new.as_str().unwrap(),
+                )
+            })
+            .collect();
+        assert_eq!(
+            paths,
+            [
+                ("/active/value", "1000", "900"),
+                ("/locked_until_secs", "1720000000", "1730000000"),
+                ("/pending_inactive/value", "0", "100"),
+            ]
+        );
+    }
+
+    #[test]
+    fn deleted_and_created_resources_expand_per_field() {
+        let diffs = diff_transaction(&staking_tx(), &all(), prior).unwrap();
+        assert_eq!(diffs.len(), 3);
+
+        let deleted = &diffs[1];
+        assert_eq!(deleted.status, "deleted");
+        assert_eq!(
+            deleted.changes,
+            [
+                removed("/coin".to_owned(), &json!({"value": "5"})),
+                removed("/frozen".to_owned(), &json!(false))
+            ]
+        );
+
+        let created = &diffs[2];
+        assert_eq!(created.status, "created");
+        assert_eq!(created.changes[0].kind, "added");
+        assert_eq!(created.changes[0].path, "/sequence_number");
+
+        validate(
+            &state_diff_output_schema(),
+            &serde_json::to_value(&diffs).unwrap(),
+        )
+        .unwrap();
+    }
+
+    #[test]
+    fn array_and_pointer_paths() {
+        let mut out = Vec::new();
+        diff_values(
+            "",
+            &json!({"a/b": [1, 2], "x": {"y": 1}}),
+            &json!({"a/b": [1, 3, 4], "x": {"y": 1}}),
+            &mut out,
+        );
+        let paths: Vec<(&str, &str)> = out
+            .iter()
+            .map(|change| (change.path.as_str(), change.kind))
+            .collect();
+        assert_eq!(paths, [("/a~1b/1", "changed"), ("/a~1b/2", "added")]);
+    }
+
+    #[test]
+    fn diffs_genesis_and_block_metadata_write_sets() {
+        let client = replay_client();
+        let diff = |version: &str, version_number: u64| {
+            let tx = fetch_transaction(&client, version).unwrap().json;
+            diff_transaction(&tx, &all(), |address, resource_type| {
+                fetch_before(&client, version_number, address, resource_type)
+            })
+            .unwrap()
+        };
+
+        let diffs = diff("0", 0);
+        assert_eq!(diffs.len(), 1);
+        assert_eq!(diffs[0].resource_type, "0x1::chain_id::ChainId");
+        assert_eq!(diffs[0].status, "created");
+
+        let diffs = diff("10", 10);
+        assert_eq!(diffs[0].status, "modified");
+        assert_eq!(diffs[0].changes[0].path, "/height");
+        assert_eq!(diffs[0].changes[0].old, json!("2"));
+    }
+}
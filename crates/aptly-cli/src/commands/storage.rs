@@ -0,0 +1,390 @@
+use anyhow::Result;
+use aptly_aptos::AptosClient;
+use clap::Args;
+use serde::Serialize;
+use serde_json::Value;
+use std::collections::{BTreeMap, BTreeSet};
+
+use crate::commands::account::{list_published_modules, list_resources};
+use crate::commands::common::{normalize_address, normalize_qualified_name};
+use crate::commands::schema::{
+    array_schema, integer_schema, nullable, object_schema, string_schema, OutputSchema,
+};
+
+#[derive(Args)]
+pub(crate) struct StorageArgs {
+    /// Account address (`0x...`).
+    #[arg(value_name = "ADDRESS")]
+    pub(crate) address: String,
+    /// Read from a historical ledger version.
+    #[arg(long)]
+    pub(crate) ledger_version: Option<u64>,
+    /// Print totals and a per-module table instead of JSON.
+    #[arg(long, default_value_t = false)]
+    pub(crate) pretty: bool,
+}
+
+#[derive(Debug, Clone, PartialEq, Eq, Serialize)]
+struct StorageUsage {
+    address: String,
+    resources: u64,
+    modules: u64,
+    /// Serialized JSON size of every resource, type included; a proxy for
+    /// the state the account occupies, not its BCS size.
+    resource_bytes: u64,
+    bytecode_bytes: u64,
+    /// Distinct table handles referenced from resource data.
+    tables: u64,
+    by_module: Vec<ModuleUsage>,
+}
+
+/// Resources declared by one module, and its bytecode when the account
+/// publishes it.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize)]
+struct ModuleUsage {
+    module: String,
+    resources: u64,
+    resource_bytes: u64,
+    bytecode_bytes: Option<u64>,
+}
+
+impl ModuleUsage {
+    fn total_bytes(&self) -> u64 {
+        self.resource_bytes + self.bytecode_bytes.unwrap_or(0)
+    }
+}
+
+impl OutputSchema for ModuleUsage {
+    fn output_schema() -> Value {
+        object_schema(
+            "Storage attributed to one module",
+            &[
+                ("module", string_schema("Module id (`address::name`)")),
+                (
+                    "resources",
+                    integer_schema("Resources held whose type the module declares"),
+                ),
+                (
+                    "resource_bytes",
+                    integer_schema("Serialized JSON size of those resources"),
+                ),
+                (
+                    "bytecode_bytes",
+                    nullable(integer_schema(
+                        "Bytecode size when the account publishes the module; null otherwise",
+                    )),
+                ),
+            ],
+        )
+    }
+}
+
+impl OutputSchema for StorageUsage {
+    fn output_schema() -> Value {
+        object_schema(
+            "Estimated state usage of an account",
+            &[
+                ("address", string_schema("Account address (64-hex)")),
+                ("resources", integer_schema("Resources held")),
+                ("modules", integer_schema("Modules published")),
+                (
+                    "resource_bytes",
+                    integer_schema("Serialized JSON size of all resources, types included"),
+                ),
+                (
+                    "bytecode_bytes",
+                    integer_schema("Bytecode size of all published modules"),
+                ),
+                (
+                    "tables",
+                    integer_schema("Distinct table handles referenced from resource data"),
+                ),
+                (
+                    "by_module",
+                    array_schema(
+                        "Per-module breakdown, largest first",
+                        ModuleUsage::output_schema(),
+                    ),
+                ),
+            ],
+        )
+    }
+}
+
+pub(crate) fn storage_output_schema() -> Value {
+    StorageUsage::output_schema()
+}
+
+/// `account storage`: resource, module and table counts and sizes of an
+/// account, from its paginated resource and module listings.
+pub(crate) fn run_account_storage(client: &AptosClient, args: &StorageArgs) -> Result<()> {
+    let resources = list_resources(client, &args.address, args.ledger_version)?;
+    let modules = list_published_modules(client, &args.address, args.ledger_version)?;
+    let usage = storage_usage(&args.address, &resources, &modules);
+    if args.pretty {
+        for line in pretty_storage_lines(&usage) {
+            println!("{line}");
+        }
+        return Ok(());
+    }
+    crate::print_serialized(&usage)
+}
+
+fn storage_usage(address: &str, resources: &[Value], modules: &[Value]) -> StorageUsage {
+    // Keyed by the normalized module id so `0x1` and its long form meet.
+    let mut by_module: BTreeMap<String, ModuleUsage> = BTreeMap::new();
+
+    let mut resource_bytes = 0;
+    let mut tables = BTreeSet::new();
+    for resource in resources {
+        let size = serde_json::to_vec(resource).map_or(0, |bytes| bytes.len() as u64);
+        resource_bytes += size;
+        let resource_type = resource.get("type").and_then(Value::as_str).unwrap_or("");
+        let usage = module_entry(&mut by_module, declaring_module(resource_type));
+        usage.resources += 1;
+        usage.resource_bytes += size;
+        if let Some(data) = resource.get("data") {
+            collect_table_handles(data, &mut tables);
+        }
+    }
+
+    let mut bytecode_bytes = 0;
+    for module in modules {
+        let size = module
+            .get("bytecode")
+            .and_then(Value::as_str)
+            .map_or(0, |hex| hex.trim_start_matches("0x").len() as u64 / 2);
+        bytecode_bytes += size;
+        let address = module.pointer("/abi/address").and_then(Value::as_str);
+        let name = module.pointer("/abi/name").and_then(Value::as_str);
+        if let (Some(address), Some(name)) = (address, name) {
+            *module_entry(&mut by_module, &format!("{address}::{name}"))
+                .bytecode_bytes
+                .get_or_insert(0) += size;
+        }
+    }
+
+    let mut by_module: Vec<ModuleUsage> = by_module.into_values().collect();
+    by_module.sort_by(|a, b| {
+        b.total_bytes()
+            .cmp(&a.total_bytes())
+            .then_with(|| a.module.cmp(&b.module))
+    });
+    StorageUsage {
+        address: normalize_address(address),
+        resources: resources.len() as u64,
+        modules: modules.len() as u64,
+        resource_bytes,
+        bytecode_bytes,
+        tables: tables.len() as u64,
+        by_module,
+    }
+}
+
+fn module_entry<'a>(
+    by_module: &'a mut BTreeMap<String, ModuleUsage>,
+    module: &str,
+) -> &'a mut ModuleUsage {
+    by_module
+        .entry(normalize_qualified_name(module))
+        .or_insert_with(|| ModuleUsage {
+            module: module.to_owned(),
+            resources: 0,
+            resource_bytes: 0,
+            bytecode_bytes: None,
+        })
+}
+
+/// `0x1::coin` of `0x1::coin::CoinStore<0x1::aptos_coin::AptosCoin>`.
+fn declaring_module(resource_type: &str) -> &str {
+    let base = resource_type
+        .split_once('<')
+        .map_or(resource_type, |(base, _)| base);
+    base.rsplit_once("::").map_or(base, |(module, _)| module)
+}
+
+/// Adds the handle of every `{"handle": "0x..."}` object under `value`,
+/// the shape `Table`, `TableWithLength` and `SmartTable` buckets share.
+fn collect_table_handles(value: &Value, handles: &mut BTreeSet<String>) {
+    match value {
+        Value::Object(fields) => {
+            if let Some(handle) = fields.get("handle").and_then(Value::as_str) {
+                if is_hex_address(handle) {
+                    handles.insert(normalize_address(handle));
+                    return;
+                }
+            }
+            for field in fields.values() {
+                collect_table_handles(field, handles);
+            }
+        }
+        Value::Array(items) => {
+            for item in items {
+                collect_table_handles(item, handles);
+            }
+        }
+        _ => {}
+    }
+}
+
+fn is_hex_address(value: &str) -> bool {
+    value.strip_prefix("0x").is_some_and(|hex| {
+        !hex.is_empty() && hex.len() <= 64 && hex.chars().all(|ch| ch.is_ascii_hexdigit())
+    })
+}
+
+/// `--pretty` output: totals, then modules with their resource and
+/// bytecode sizes, largest first.
+fn pretty_storage_lines(usage: &StorageUsage) -> Vec<String> {
+    let mut lines = vec![
+        format!(
+            "resources  {} ({} bytes)",
+            usage.resources, usage.resource_bytes
+        ),
+        format!(
+            "modules    {} ({} bytes of bytecode)",
+            usage.modules, usage.bytecode_bytes
+        ),
+        format!("tables     {}", usage.tables),
+    ];
+    if usage.by_module.is_empty() {
+        return lines;
+    }
+    let header = ["module", "resources", "resource bytes", "bytecode bytes"].map(str::to_owned);
+    let rows: Vec<[String; 4]> = std::iter::once(header)
+        .chain(usage.by_module.iter().map(|module| {
+            [
+                module.module.clone(),
+                module.resources.to_string(),
+                module.resource_bytes.to_string(),
+                module
+                    .bytecode_bytes
+                    .map_or_else(|| "-".to_owned(), |bytes| bytes.to_string()),
+            ]
+        }))
+        .collect();
+    let widths: Vec<usize> = (0..4)
+        .map(|column| {
+            rows.iter()
+                .map(|row| row[column].chars().count())
+                .max()
+                .unwrap_or(0)
+        })
+        .collect();
+    lines.push(String::new());
+    lines.extend(rows.iter().map(|row| {
+        format!(
+            "{:<w0$}  {:>w1$}  {:>w2$}  {:>w3$}",
+            row[0],
+            row[1],
+            row[2],
+            row[3],
+            w0 = widths[0],
+            w1 = widths[1],
+            w2 = widths[2],
+            w3 = widths[3],
+        )
+    }));
+    lines
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::commands::schema::validate;
+    use serde_json::json;
+
+    #[test]
+    fn finds_nested_table_handles() {
+        let data = json!({
+            "balances": {"handle": "0xab12"},
+            "pools": [
+                {"info": {"inner": {"handle": "0xcd34", "length": "3"}}},
+                {"info": {"inner": {"handle": "0xAB12", "length": "0"}}},
+            ],
+            "buckets": {"inner": {"handle": "0xef56"}, "length": "4"},
+            "events": {"counter": "2", "guid": {"id": {"addr": "0x1", "creation_num": "5"}}},
+            "named": {"handle": "not a table"},
+        });
+        let mut handles = BTreeSet::new();
+        collect_table_handles(&data, &mut handles);
+        assert_eq!(
+            handles,
+            ["0xab12", "0xcd34", "0xef56"]
+                .into_iter()
+                .map(normalize_address)
+                .collect::<BTreeSet<_>>()
+        );
+    }
+
+    #[test]
+    fn names_the_declaring_module() {
+        assert_eq!(
+            declaring_module("0x1::coin::CoinStore<0x1::aptos_coin::AptosCoin>"),
+            "0x1::coin"
+        );
+        assert_eq!(declaring_module("0xcafe::pool::Pool"), "0xcafe::pool");
+        assert_eq!(declaring_module(""), "");
+    }
+
+    #[test]
+    fn aggregates_resources_and_modules_per_module() {
+        let resources = vec![
+            json!({"type": "0x1::account::Account", "data": {"sequence_number": "3"}}),
+            json!({
+                "type": "0xcafe::pool::Pool",
+                "data": {"swaps": {"handle": "0x77"}, "fees": {"inner": {"handle": "0x78"}}},
+            }),
+            json!({"type": "0xcafe::pool::Config", "data": {"admin": "0xcafe"}}),
+        ];
+        let modules = vec![
+            json!({"bytecode": "0xa11ceb0b0600", "abi": {"address": "0xcafe", "name": "pool"}}),
+            json!({"bytecode": "0xa11ceb0b", "abi": {"address": "0xcafe", "name": "router"}}),
+        ];
+        let usage = storage_usage("0xcafe", &resources, &modules);
+        let size = |resource: &Value| serde_json::to_vec(resource).unwrap().len() as u64;
+
+        assert_eq!(usage.resources, 3);
+        assert_eq!(usage.modules, 2);
+        assert_eq!(usage.tables, 2);
+        assert_eq!(usage.bytecode_bytes, 10);
+        assert_eq!(
+            usage.resource_bytes,
+            resources.iter().map(size).sum::<u64>()
+        );
+        assert_eq!(
+            usage.by_module,
+            [
+                ModuleUsage {
+                    module: "0xcafe::pool".to_owned(),
+                    resources: 2,
+                    resource_bytes: size(&resources[1]) + size(&resources[2]),
+                    bytecode_bytes: Some(6),
+                },
+                ModuleUsage {
+                    module: "0x1::account".to_owned(),
+                    resources: 1,
+                    resource_bytes: size(&resources[0]),
+                    bytecode_bytes: None,
+                },
+                ModuleUsage {
+                    module: "0xcafe::router".to_owned(),
+                    resources: 0,
+                    resource_bytes: 0,
+                    bytecode_bytes: Some(4),
+                },
+            ]
+        );
+        validate(
+            &storage_output_schema(),
+            &serde_json::to_value(&usage).unwrap(),
+        )
+        .unwrap();
+
+        let lines = pretty_storage_lines(&usage);
+        assert_eq!(lines[1], "modules    2 (10 bytes of bytecode)");
+        assert_eq!(lines[2], "tables     2");
+        assert!(lines[4].starts_with("module          resources  resource bytes  bytecode bytes"));
+        assert!(lines[7].ends_with("               4"));
+    }
+}
@@ -0,0 +1,663 @@
+use anyhow::{Context, Result};
+use aptly_aptos::AptosClient;
+use clap::Args;
+use num_bigint::BigInt;
+use serde::{Deserialize, Serialize};
+use serde_json::{json, Value};
+use std::collections::BTreeMap;
+use std::fs;
+use std::str::FromStr;
+
+use crate::commands::account::{format_amount, AmountStyle};
+use crate::commands::assets;
+use crate::commands::bindings::split_type_args;
+use crate::commands::common::{
+    get_nested_string, glob_matches, normalize_address, parse_u64, shorten_addr, user_config_path,
+    value_to_string,
+};
+use crate::commands::schema::{
+    array_schema, integer_schema, nullable, object_schema, string_schema, OutputSchema,
+};
+use crate::commands::tx::{get_transaction, transaction_balance_changes, BalanceChange, TxKind};
+
+const PROTOCOLS_FILE: &str = "swap_protocols.json";
+
+/// Swap events of well-known mainnet DEXes, in the same format as the user
+/// protocols file.
+const BUILTIN_PROTOCOLS: &str = r#"[
+    {
+        "protocol": "liquidswap",
+        "address": "0x190d44266241744264b964a37b8f09863167a12d3e70cda39376cfb4e3561e12",
+        "event": "liquidity_pool::SwapEvent",
+        "layout": "pair",
+        "x_in": "x_in",
+        "x_out": "x_out",
+        "y_in": "y_in",
+        "y_out": "y_out"
+    },
+    {
+        "protocol": "pancakeswap",
+        "address": "0xc7efb4076dbe143cbcd98cfaaa929ecfc8f299203dfff63b95ccb6bfe19850fa",
+        "event": "swap::SwapEvent",
+        "layout": "pair",
+        "x_in": "amount_x_in",
+        "x_out": "amount_x_out",
+        "y_in": "amount_y_in",
+        "y_out": "amount_y_out"
+    },
+    {
+        "protocol": "thala",
+        "address": "0x48271d39d0b05bd6efca2278f22277d6fcc375504f9839fd73f74ace240861af",
+        "event": "*_pool::SwapEvent",
+        "layout": "indexed",
+        "index_in": "idx_in",
+        "index_out": "idx_out",
+        "amount_in": "amount_in",
+        "amount_out": "amount_out",
+        "fee": "fee_amount"
+    },
+    {
+        "protocol": "cellana",
+        "address": "0x4bf51972879e3b95c4781a5cdcb9e1ee24ef483e7d22f2d903626f126df62bd1",
+        "event": "liquidity_pool::SwapEvent",
+        "layout": "tokens",
+        "pool": "pool",
+        "asset_in": "from_token",
+        "asset_out": "to_token",
+        "amount_in": "amount_in",
+        "amount_out": "amount_out"
+    }
+]"#;
+
+#[derive(Args)]
+pub(crate) struct TxSwapsArgs {
+    /// Transaction version (u64) or hash (0x...).
+    /// If omitted, reads full transaction JSON from stdin.
+    #[arg(value_name = "VERSION_OR_HASH")]
+    pub(crate) version_or_hash: Option<String>,
+    /// Print one line per swap with symbols and decimal amounts.
+    #[arg(long, default_value_t = false)]
+    pub(crate) pretty: bool,
+    /// With `--pretty`, show at most N decimal places, truncating the rest.
+    #[arg(long, value_name = "N")]
+    pub(crate) precision: Option<u8>,
+}
+
+/// One DEX swap event and where its fields live.
+#[derive(Debug, Clone, Deserialize)]
+struct SwapProtocol {
+    protocol: String,
+    /// Address the DEX modules are published at.
+    address: String,
+    /// `module::Struct` of the swap event; `*` matches any run of characters.
+    event: String,
+    #[serde(flatten)]
+    layout: EventLayout,
+}
+
+/// How assets and amounts are laid out in a swap event's data.
+#[derive(Debug, Clone, Deserialize)]
+#[serde(tag = "layout", rename_all = "snake_case")]
+enum EventLayout {
+    /// Two-asset pools whose event type arguments are `<X, Y, ...>` and whose
+    /// data has in and out amounts for both sides.
+    Pair {
+        x_in: String,
+        x_out: String,
+        y_in: String,
+        y_out: String,
+        #[serde(default)]
+        fee: Option<String>,
+    },
+    /// Multi-asset pools whose data indexes into the event type arguments.
+    Indexed {
+        index_in: String,
+        index_out: String,
+        amount_in: String,
+        amount_out: String,
+        #[serde(default)]
+        fee: Option<String>,
+    },
+    /// Events that name the assets (and optionally the pool) in their data.
+    Tokens {
+        #[serde(default)]
+        pool: Option<String>,
+        asset_in: String,
+        asset_out: String,
+        amount_in: String,
+        amount_out: String,
+        #[serde(default)]
+        fee: Option<String>,
+    },
+}
+
+#[derive(Debug, Clone, Serialize)]
+struct TxSwaps {
+    version: u64,
+    function: Option<String>,
+    swaps: Vec<Swap>,
+}
+
+#[derive(Debug, Clone, PartialEq, Eq, Serialize)]
+struct Swap {
+    protocol: Option<String>,
+    source: &'static str,
+    trader: String,
+    pool: Option<String>,
+    asset_in: String,
+    amount_in: String,
+    asset_out: String,
+    amount_out: String,
+    fee: Option<String>,
+}
+
+impl OutputSchema for Swap {
+    fn output_schema() -> Value {
+        object_schema(
+            "One swap, in base units",
+            &[
+                (
+                    "protocol",
+                    nullable(string_schema(
+                        "DEX from the swap registry; null when unknown",
+                    )),
+                ),
+                (
+                    "source",
+                    json!({
+                        "description": "`event` when decoded from a known swap event, `balance_change` when inferred from the trader's withdraw and deposit of two assets",
+                        "type": "string",
+                        "enum": ["event", "balance_change"],
+                    }),
+                ),
+                ("trader", string_schema("Transaction sender")),
+                (
+                    "pool",
+                    nullable(string_schema(
+                        "Pool address, or the pool's type arguments for coin pools",
+                    )),
+                ),
+                (
+                    "asset_in",
+                    string_schema("Coin type or fungible asset metadata address paid in"),
+                ),
+                ("amount_in", string_schema("Amount paid in")),
+                (
+                    "asset_out",
+                    string_schema("Coin type or fungible asset metadata address received"),
+                ),
+                ("amount_out", string_schema("Amount received")),
+                (
+                    "fee",
+                    nullable(string_schema(
+                        "Fee in `asset_in` units; null when the event does not report it",
+                    )),
+                ),
+            ],
+        )
+    }
+}
+
+impl OutputSchema for TxSwaps {
+    fn output_schema() -> Value {
+        object_schema(
+            "Swaps in a transaction; `--pretty` prints text instead",
+            &[
+                (
+                    "version",
+                    integer_schema("Ledger version of the transaction"),
+                ),
+                (
+                    "function",
+                    nullable(string_schema("Entry function called; null for scripts")),
+                ),
+                (
+                    "swaps",
+                    array_schema("Swaps in event order", Swap::output_schema()),
+                ),
+            ],
+        )
+    }
+}
+
+pub(crate) fn swaps_output_schema() -> Value {
+    TxSwaps::output_schema()
+}
+
+pub(crate) fn run_tx_swaps(client: &AptosClient, args: &TxSwapsArgs) -> Result<()> {
+    let tx = get_transaction(client, args.version_or_hash.as_deref())?;
+    let registry = load_protocols()?;
+    let function = tx
+        .json
+        .pointer("/payload/function")
+        .and_then(Value::as_str)
+        .map(str::to_owned);
+
+    let mut swaps = event_swaps(&registry, &tx.json);
+    // Only a user transaction has a sender to infer a trade for.
+    if swaps.is_empty() && tx.kind == TxKind::User {
+        let changes = transaction_balance_changes(client, &tx);
+        let trader = get_nested_string(&tx.json, &["sender"]);
+        swaps.extend(infer_swap(&trader, &changes).map(|mut swap| {
+            swap.protocol = function
+                .as_deref()
+                .and_then(|function| entry_protocol(&registry, function));
+            swap
+        }));
+    }
+
+    tx.note_if_empty(swaps.is_empty(), "swaps");
+    let swaps = TxSwaps {
+        version: tx.version(),
+        function,
+        swaps,
+    };
+    if args.pretty {
+        print!(
+            "{}",
+            render_pretty(client, &swaps, AmountStyle::pretty(args.precision))
+        );
+        return Ok(());
+    }
+    crate::print_serialized(&swaps)
+}
+
+/// Built-in protocols followed by `$XDG_CONFIG_HOME/aptly/swap_protocols.json`,
+/// if present. A user entry replaces a built-in one for the same address and
+/// event.
+fn load_protocols() -> Result<Vec<SwapProtocol>> {
+    let mut protocols: Vec<SwapProtocol> =
+        serde_json::from_str(BUILTIN_PROTOCOLS).expect("built-in swap protocols are valid");
+    let path = user_config_path(PROTOCOLS_FILE)?;
+    let body = match fs::read_to_string(&path) {
+        Ok(body) => body,
+        Err(err) if err.kind() == std::io::ErrorKind::NotFound => return Ok(protocols),
+        Err(err) => return Err(err).with_context(|| format!("failed to read {}", path.display())),
+    };
+    let user: Vec<SwapProtocol> = serde_json::from_str(&body)
+        .with_context(|| format!("failed to parse {}", path.display()))?;
+    merge_protocols(&mut protocols, user);
+    Ok(protocols)
+}
+
+fn merge_protocols(protocols: &mut Vec<SwapProtocol>, user: Vec<SwapProtocol>) {
+    for entry in user {
+        protocols.retain(|builtin| {
+            normalize_address(&builtin.address) != normalize_address(&entry.address)
+                || builtin.event != entry.event
+        });
+        protocols.push(entry);
+    }
+}
+
+/// Decodes every event that matches a registry entry.
+fn event_swaps(registry: &[SwapProtocol], tx: &Value) -> Vec<Swap> {
+    let trader = get_nested_string(tx, &["sender"]);
+    let Some(events) = tx.get("events").and_then(Value::as_array) else {
+        return Vec::new();
+    };
+    events
+        .iter()
+        .filter_map(|event| {
+            let event_type = event.get("type").and_then(Value::as_str)?;
+            let (name, type_args) = split_type_args(event_type).ok()?;
+            let (address, struct_name) = name.split_once("::")?;
+            let address = normalize_address(address);
+            let protocol = registry.iter().find(|protocol| {
+                normalize_address(&protocol.address) == address
+                    && glob_matches(&protocol.event, struct_name)
+            })?;
+            decode_swap(protocol, event.get("data")?, &type_args, &trader)
+        })
+        .collect()
+}
+
+fn decode_swap(
+    protocol: &SwapProtocol,
+    data: &Value,
+    type_args: &[&str],
+    trader: &str,
+) -> Option<Swap> {
+    let field = |name: &str| asset_or_amount(data.get(name)?);
+    let nonzero = |amount: &Option<String>| {
+        amount
+            .as_deref()
+            .is_some_and(|amount| !amount.trim_start_matches('0').is_empty())
+    };
+
+    let (pool, asset_in, amount_in, asset_out, amount_out, fee) = match &protocol.layout {
+        EventLayout::Pair {
+            x_in,
+            x_out,
+            y_in,
+            y_out,
+            fee,
+        } => {
+            let (x, y) = (type_args.first()?, type_args.get(1)?);
+            let (x_in, x_out, y_in, y_out) = (field(x_in), field(x_out), field(y_in), field(y_out));
+            let (asset_in, amount_in, asset_out, amount_out) = if nonzero(&x_in) {
+                (x, x_in?, y, y_out?)
+            } else {
+                (y, y_in?, x, x_out?)
+            };
+            (
+                Some(type_args.join(", ")),
+                (*asset_in).to_owned(),
+                amount_in,
+                (*asset_out).to_owned(),
+                amount_out,
+                fee.as_deref().and_then(field),
+            )
+        }
+        EventLayout::Indexed {
+            index_in,
+            index_out,
+            amount_in,
+            amount_out,
+            fee,
+        } => {
+            let index = |name: &str| -> Option<&str> {
+                let index = usize::try_from(parse_u64(data.get(name)?)?).ok()?;
+                type_args.get(index).copied()
+            };
+            (
+                Some(type_args.join(", ")),
+                index(index_in)?.to_owned(),
+                field(amount_in)?,
+                index(index_out)?.to_owned(),
+                field(amount_out)?,
+                fee.as_deref().and_then(field),
+            )
+        }
+        EventLayout::Tokens {
+            pool,
+            asset_in,
+            asset_out,
+            amount_in,
+            amount_out,
+            fee,
+        } => (
+            pool.as_deref().and_then(field),
+            field(asset_in)?,
+            field(amount_in)?,
+            field(asset_out)?,
+            field(amount_out)?,
+            fee.as_deref().and_then(field),
+        ),
+    };
+    Some(Swap {
+        protocol: Some(protocol.protocol.clone()),
+        source: "event",
+        trader: trader.to_owned(),
+        pool,
+        asset_in,
+        amount_in,
+        asset_out,
+        amount_out,
+        fee,
+    })
+}
+
+/// Reads a string or number field, unwrapping `Object<T>` as its address.
+fn asset_or_amount(value: &Value) -> Option<String> {
+    let value = value.get("inner").unwrap_or(value);
+    let text = value_to_string(value);
+    (!text.is_empty()).then_some(text)
+}
+
+/// A swap through an unknown protocol: the trader's net change is one asset
+/// out and one different asset in. Gas is left out.
+fn infer_swap(trader: &str, changes: &[BalanceChange]) -> Option<Swap> {
+    let owner = normalize_address(trader);
+    let mut net: BTreeMap<&str, BigInt> = BTreeMap::new();
+    for change in changes {
+        if normalize_address(&change.account) != owner {
+            continue;
+        }
+        let amount = BigInt::from_str(&change.amount).unwrap_or_default();
+        let total = net.entry(change.asset.as_str()).or_default();
+        match change.event_type.as_str() {
+            "withdraw" => *total -= amount,
+            "deposit" => *total += amount,
+            _ => {}
+        }
+    }
+    let zero = BigInt::default();
+    let paid: Vec<_> = net.iter().filter(|(_, amount)| **amount < zero).collect();
+    let received: Vec<_> = net.iter().filter(|(_, amount)| **amount > zero).collect();
+    let ([(asset_in, amount_in)], [(asset_out, amount_out)]) = (&paid[..], &received[..]) else {
+        return None;
+    };
+    Some(Swap {
+        protocol: None,
+        source: "balance_change",
+        trader: trader.to_owned(),
+        pool: None,
+        asset_in: (*asset_in).to_string(),
+        amount_in: (-*amount_in).to_string(),
+        asset_out: (*asset_out).to_string(),
+        amount_out: amount_out.to_string(),
+        fee: None,
+    })
+}
+
+/// Registry protocol whose address published the entry function.
+fn entry_protocol(registry: &[SwapProtocol], function: &str) -> Option<String> {
+    let address = normalize_address(function.split_once("::")?.0);
+    registry
+        .iter()
+        .find(|protocol| normalize_address(&protocol.address) == address)
+        .map(|protocol| protocol.protocol.clone())
+}
+
+fn render_pretty(client: &AptosClient, swaps: &TxSwaps, style: AmountStyle) -> String {
+    let describe = |asset: &str, amount: &str| {
+        let metadata = assets::resolver().lookup(client, asset);
+        format!(
+            "{} {}",
+            style.apply(&format_amount(amount, metadata.decimals)),
+            metadata.symbol
+        )
+    };
+    let mut out = String::new();
+    for swap in &swaps.swaps {
+        let protocol = swap.protocol.as_deref().unwrap_or("unknown");
+        let mut line = format!(
+            "{protocol}{} {}: {} → {}",
+            if swap.source == "event" {
+                ""
+            } else {
+                " (inferred)"
+            },
+            shorten_addr(&swap.trader),
+            describe(&swap.asset_in, &swap.amount_in),
+            describe(&swap.asset_out, &swap.amount_out),
+        );
+        if let Some(fee) = &swap.fee {
+            line.push_str(&format!("  fee {}", describe(&swap.asset_in, fee)));
+        }
+        out.push_str(&line);
+        out.push('\n');
+    }
+    if swaps.swaps.is_empty() {
+        out.push_str("no swaps found\n");
+    }
+    out
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::commands::schema::validate;
+
+    const THALA: &str = "0x48271d39d0b05bd6efca2278f22277d6fcc375504f9839fd73f74ace240861af";
+    const LIQUIDSWAP: &str = "0x190d44266241744264b964a37b8f09863167a12d3e70cda39376cfb4e3561e12";
+    const CELLANA: &str = "0x4bf51972879e3b95c4781a5cdcb9e1ee24ef483e7d22f2d903626f126df62bd1";
+
+    fn builtin() -> Vec<SwapProtocol> {
+        serde_json::from_str(BUILTIN_PROTOCOLS).unwrap()
+    }
+
+    fn swap_tx(events: Value) -> Value {
+        json!({
+            "type": "user_transaction",
+            "version": "1234",
+            "sender": "0xcafe",
+            "payload": {"function": format!("{LIQUIDSWAP}::scripts_v2::swap")},
+            "events": events,
+        })
+    }
+
+    #[test]
+    fn decodes_known_dex_events() {
+        let tx = swap_tx(json!([
+            {
+                "type": "0x1::coin::WithdrawEvent",
+                "data": {"amount": "150000000"},
+            },
+            {
+                "type": format!("{LIQUIDSWAP}::liquidity_pool::SwapEvent<0x1::aptos_coin::AptosCoin, 0xf22b::asset::USDC, {LIQUIDSWAP}::curves::Uncorrelated>"),
+                "data": {"x_in": "150000000", "x_out": "0", "y_in": "0", "y_out": "1361234"},
+            },
+            {
+                "type": format!("{THALA}::weighted_pool::SwapEvent<0x1::aptos_coin::AptosCoin, 0xf22b::asset::USDC, {THALA}::base_pool::Null, {THALA}::base_pool::Null, {THALA}::weighted_pool::Weight_50, {THALA}::weighted_pool::Weight_50, {THALA}::base_pool::Null, {THALA}::base_pool::Null>"),
+                "data": {"idx_in": "1", "idx_out": "0", "amount_in": "1361234", "amount_out": "149000000", "fee_amount": "4083"},
+            },
+            {
+                "type": format!("{CELLANA}::liquidity_pool::SwapEvent"),
+                "data": {"pool": "0x77", "from_token": "0xa", "to_token": {"inner": "0xbae2"}, "amount_in": "5", "amount_out": "3"},
+            },
+        ]));
+        let swaps = event_swaps(&builtin(), &tx);
+        let output = serde_json::to_value(&swaps).unwrap();
+        assert_eq!(
+            output,
+            json!([
+                {
+                    "protocol": "liquidswap",
+                    "source": "event",
+                    "trader": "0xcafe",
+                    "pool": format!("0x1::aptos_coin::AptosCoin, 0xf22b::asset::USDC, {LIQUIDSWAP}::curves::Uncorrelated"),
+                    "asset_in": "0x1::aptos_coin::AptosCoin",
+                    "amount_in": "150000000",
+                    "asset_out": "0xf22b::asset::USDC",
+                    "amount_out": "1361234",
+                    "fee": null,
+                },
+                {
+                    "protocol": "thala",
+                    "source": "event",
+                    "trader": "0xcafe",
+                    "pool": output[1]["pool"],
+                    "asset_in": "0xf22b::asset::USDC",
+                    "amount_in": "1361234",
+                    "asset_out": "0x1::aptos_coin::AptosCoin",
+                    "amount_out": "149000000",
+                    "fee": "4083",
+                },
+                {
+                    "protocol": "cellana",
+                    "source": "event",
+                    "trader": "0xcafe",
+                    "pool": "0x77",
+                    "asset_in": "0xa",
+                    "amount_in": "5",
+                    "asset_out": "0xbae2",
+                    "amount_out": "3",
+                    "fee": null,
+                },
+            ])
+        );
+        let report = TxSwaps {
+            version: 1234,
+            function: None,
+            swaps,
+        };
+        validate(
+            &swaps_output_schema(),
+            &serde_json::to_value(&report).unwrap(),
+        )
+        .unwrap();
+    }
+
+    #[test]
+    fn user_protocols_extend_and_replace_builtins() {
+        let mut registry = builtin();
+        let user: Vec<SwapProtocol> = serde_json::from_str(
+            r#"[
+                {"protocol": "mydex", "address": "0xd3", "event": "router::Swapped",
+                 "layout": "tokens", "asset_in": "sold", "asset_out": "bought",
+                 "amount_in": "sold_amount", "amount_out": "bought_amount", "fee": "fee"},
+                {"protocol": "liquidswap-v0", "address": "0x190d44266241744264b964a37b8f09863167a12d3e70cda39376cfb4e3561e12",
+                 "event": "liquidity_pool::SwapEvent", "layout": "pair",
+                 "x_in": "x_in", "x_out": "x_out", "y_in": "y_in", "y_out": "y_out"}
+            ]"#,
+        )
+        .unwrap();
+        let builtin_count = registry.len();
+        merge_protocols(&mut registry, user);
+        assert_eq!(registry.len(), builtin_count + 1);
+
+        let tx = swap_tx(json!([
+            {
+                "type": "0x00d3::router::Swapped",
+                "data": {"sold": "0xa", "bought": "0xb", "sold_amount": "10", "bought_amount": 7, "fee": "1"},
+            },
+            {
+                "type": format!("{LIQUIDSWAP}::liquidity_pool::SwapEvent<0x1::a::A, 0x1::b::B, 0x1::c::C>"),
+                "data": {"x_in": "0", "x_out": "9", "y_in": "4", "y_out": "0"},
+            },
+        ]));
+        let swaps = event_swaps(&registry, &tx);
+        assert_eq!(swaps.len(), 2);
+        assert_eq!(swaps[0].protocol.as_deref(), Some("mydex"));
+        assert_eq!(
+            (swaps[0].amount_out.as_str(), swaps[0].fee.as_deref()),
+            ("7", Some("1"))
+        );
+        assert_eq!(swaps[1].protocol.as_deref(), Some("liquidswap-v0"));
+        assert_eq!(
+            (swaps[1].asset_in.as_str(), swaps[1].asset_out.as_str()),
+            ("0x1::b::B", "0x1::a::A")
+        );
+    }
+
+    #[test]
+    fn infers_swaps_from_trader_balance_changes() {
+        let change = |event_type: &str, account: &str, asset: &str, amount: &str| BalanceChange {
+            event_type: event_type.to_owned(),
+            account: account.to_owned(),
+            fungible_store: String::new(),
+            asset: asset.to_owned(),
+            amount: amount.to_owned(),
+            account_label: None,
+        };
+        let changes = vec![
+            change("gas_fee", "0xcafe", "0xa", "500"),
+            change("withdraw", "0xcafe", "0xa", "1000"),
+            change("deposit", "0x99", "0xa", "1000"),
+            change("withdraw", "0x99", "0xb", "42"),
+            change("deposit", "0xcafe", "0xb", "42"),
+        ];
+        let swap = infer_swap("0xcafe", &changes).unwrap();
+        assert_eq!(swap.source, "balance_change");
+        assert_eq!(
+            (
+                swap.asset_in.as_str(),
+                swap.amount_in.as_str(),
+                swap.asset_out.as_str(),
+                swap.amount_out.as_str()
+            ),
+            ("0xa", "1000", "0xb", "42")
+        );
+
+        // A plain transfer is not a swap.
+        assert!(infer_swap("0xcafe", &changes[..3]).is_none());
+
+        assert_eq!(
+            entry_protocol(&builtin(), &format!("{THALA}::router::swap_exact_in")).as_deref(),
+            Some("thala")
+        );
+        assert_eq!(entry_protocol(&builtin(), "0x1::coin::transfer"), None);
+    }
+}
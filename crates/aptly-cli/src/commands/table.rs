@@ -1,11 +1,19 @@
-use anyhow::{Context, Result};
+use anyhow::{anyhow, Context, Result};
 use aptly_aptos::AptosClient;
 use clap::{Args, Subcommand};
-use serde_json::{json, Value};
+use serde_json::{json, Map, Value};
+
+use crate::commands::bcs::{decode_hex, struct_fields, StructLayouts};
+use crate::commands::bindings::MoveType;
+use crate::commands::common::value_to_string;
+use crate::commands::type_tag::check_type_tag_arg;
+
+const U256_MAX: &str =
+    "115792089237316195423570985008687907853269984665640564039457584007913129639935";
 
 #[derive(Args)]
 #[command(
-    after_help = "Examples:\n  aptly table item <table_handle> --key-type address --value-type u64 --key '\"0x1\"'\n  aptly table item <table_handle> --key-type u64 --value-type 0x1::coin::CoinInfo<0x1::aptos_coin::AptosCoin> --key '1'"
+    after_help = "Examples:\n  aptly table item <table_handle> --key-type address --value-type u64 --key '\"0x1\"'\n  aptly table item <table_handle> --key-type u64 --value-type 0x1::coin::CoinInfo<0x1::aptos_coin::AptosCoin> --key '1'\n  aptly table item <table_handle> --key-type 0x1::string::String --value-type u64 --key '\"alice\"'\n  aptly table item <table_handle> --key-type 0xabc::pool::PoolKey --value-type u64 --key '{\"id\":\"7\",\"name\":\"apt\"}'\n  aptly table item <table_handle> --key-type vector<u8> --value-type u64 --key-bcs 0x0201ff"
 )]
 pub(crate) struct TableCommand {
     #[command(subcommand)]
@@ -29,20 +37,36 @@ pub(crate) struct TableItemArgs {
     /// Move type tag for the table value.
     #[arg(long)]
     pub(crate) value_type: String,
-    /// JSON-encoded key value.
-    #[arg(long)]
-    pub(crate) key: String,
+    /// JSON-encoded key value, checked and coerced against `--key-type`
+    /// (structs are read from their module ABI).
+    #[arg(long, required_unless_present = "key_bcs", conflicts_with = "key_bcs")]
+    pub(crate) key: Option<String>,
+    /// BCS-encoded key as hex, decoded against `--key-type`.
+    #[arg(long, value_name = "HEX")]
+    pub(crate) key_bcs: Option<String>,
 }
 
 pub(crate) fn run_table(client: &AptosClient, command: TableCommand) -> Result<()> {
     match command.command {
         TableSubcommand::Item(args) => {
-            let key_value: Value = serde_json::from_str(&args.key)
-                .with_context(|| format!("failed to parse key as JSON: {}", args.key))?;
+            let key_tag = check_type_tag_arg("--key-type", &args.key_type)?;
+            let value_tag = check_type_tag_arg("--value-type", &args.value_type)?;
+            let key_type = MoveType::parse(&args.key_type)
+                .with_context(|| format!("invalid --key-type `{}`", args.key_type))?;
+            let mut builder = KeyBuilder::new(|name: &str| struct_fields(client, name));
+            let key_value = match (&args.key, &args.key_bcs) {
+                (_, Some(hex)) => builder.decode_bcs(&key_type, hex)?,
+                (Some(key), None) => {
+                    let key: Value = serde_json::from_str(key)
+                        .with_context(|| format!("failed to parse key as JSON: {key}"))?;
+                    builder.coerce(&key_type, &key, "key")?
+                }
+                (None, None) => return Err(anyhow!("missing --key or --key-bcs")),
+            };
 
             let body = json!({
-                "key_type": args.key_type,
-                "value_type": args.value_type,
+                "key_type": key_tag.to_string(),
+                "value_type": value_tag.to_string(),
                 "key": key_value
             });
 
@@ -51,3 +75,295 @@ pub(crate) fn run_table(client: &AptosClient, command: TableCommand) -> Result<(
         }
     }
 }
+
+/// Builds a table key in the JSON shape the node expects, resolving struct
+/// layouts through `fields` (cached per struct).
+struct KeyBuilder<F> {
+    layouts: StructLayouts<F>,
+}
+
+impl<F: FnMut(&str) -> Result<Vec<(String, String)>>> KeyBuilder<F> {
+    fn new(fields: F) -> Self {
+        Self {
+            layouts: StructLayouts::new(fields),
+        }
+    }
+
+    fn coerce(&mut self, ty: &MoveType, value: &Value, path: &str) -> Result<Value> {
+        let mismatch = |expected: &str| anyhow!("{path}: expected {expected}, got {value}");
+        match ty {
+            MoveType::Bool => match value {
+                Value::Bool(_) => Ok(value.clone()),
+                Value::String(text) if text == "true" || text == "false" => {
+                    Ok(Value::Bool(text == "true"))
+                }
+                _ => Err(mismatch("bool")),
+            },
+            MoveType::U8 | MoveType::U16 | MoveType::U32 => {
+                let max = match ty {
+                    MoveType::U8 => u64::from(u8::MAX),
+                    MoveType::U16 => u64::from(u16::MAX),
+                    _ => u64::from(u32::MAX),
+                };
+                let number = match value {
+                    Value::Number(number) => number.as_u64(),
+                    Value::String(text) => text.parse::<u64>().ok(),
+                    _ => None,
+                };
+                match number {
+                    Some(number) if number <= max => Ok(json!(number)),
+                    _ => Err(mismatch(&format!("an integer in 0..={max}"))),
+                }
+            }
+            MoveType::U64 | MoveType::U128 | MoveType::U256 => {
+                let digits = match value {
+                    Value::Number(number) if number.is_u64() => number.to_string(),
+                    Value::String(text) => text.clone(),
+                    _ => return Err(mismatch("an unsigned integer")),
+                };
+                let max = match ty {
+                    MoveType::U64 => u64::MAX.to_string(),
+                    MoveType::U128 => u128::MAX.to_string(),
+                    _ => U256_MAX.to_owned(),
+                };
+                if digits.is_empty()
+                    || !digits.bytes().all(|byte| byte.is_ascii_digit())
+                    || exceeds(&digits, &max)
+                {
+                    return Err(mismatch(&format!("a decimal integer in 0..={max}")));
+                }
+                Ok(Value::String(digits))
+            }
+            MoveType::Address => match value {
+                Value::String(text) if is_hex_address(text) => Ok(value.clone()),
+                _ => Err(mismatch("an address (`0x...`)")),
+            },
+            // The node reads `0x1::string::String` from a plain JSON string.
+            MoveType::String => match value {
+                Value::String(_) => Ok(value.clone()),
+                Value::Object(object) if object.len() == 1 && object.contains_key("bytes") => {
+                    let bytes = decode_hex(&value_to_string(&object["bytes"]))
+                        .ok_or_else(|| mismatch("hex in `bytes`"))?;
+                    String::from_utf8(bytes)
+                        .map(Value::String)
+                        .map_err(|_| anyhow!("{path}.bytes: not valid UTF-8"))
+                }
+                _ => Err(mismatch("a string")),
+            },
+            MoveType::Object => match value {
+                Value::String(text) if is_hex_address(text) => Ok(json!({ "inner": text })),
+                Value::Object(object) if object.len() == 1 => match object.get("inner") {
+                    Some(Value::String(text)) if is_hex_address(text) => Ok(value.clone()),
+                    _ => Err(mismatch("an object address")),
+                },
+                _ => Err(mismatch("an object address")),
+            },
+            MoveType::Option(inner) => {
+                let items = match value {
+                    Value::Null => Vec::new(),
+                    Value::Object(object) if object.len() == 1 && object.contains_key("vec") => {
+                        match &object["vec"] {
+                            Value::Array(items) if items.len() <= 1 => items.clone(),
+                            _ => return Err(mismatch("`{\"vec\": []}` or `{\"vec\": [value]}`")),
+                        }
+                    }
+                    other => vec![other.clone()],
+                };
+                let items = items
+                    .iter()
+                    .map(|item| self.coerce(inner, item, &format!("{path}.vec[0]")))
+                    .collect::<Result<Vec<_>>>()?;
+                Ok(json!({ "vec": items }))
+            }
+            MoveType::Vector(inner) if **inner == MoveType::U8 => match value {
+                Value::String(text) if decode_hex(text).is_some() => Ok(value.clone()),
+                Value::Array(items) => {
+                    let bytes = items
+                        .iter()
+                        .enumerate()
+                        .map(|(index, item)| {
+                            item.as_u64()
+                                .and_then(|byte| u8::try_from(byte).ok())
+                                .ok_or_else(|| {
+                                    anyhow!("{path}[{index}]: expected a byte, got {item}")
+                                })
+                        })
+                        .collect::<Result<Vec<u8>>>()?;
+                    Ok(Value::String(format!("0x{}", hex::encode(bytes))))
+                }
+                _ => Err(mismatch("hex bytes (`0x...`) or an array of bytes")),
+            },
+            MoveType::Vector(inner) => match value {
+                Value::Array(items) => items
+                    .iter()
+                    .enumerate()
+                    .map(|(index, item)| self.coerce(inner, item, &format!("{path}[{index}]")))
+                    .collect::<Result<Vec<_>>>()
+                    .map(Value::Array),
+                _ => Err(mismatch("an array")),
+            },
+            MoveType::Struct(tag) => {
+                let Value::Object(object) = value else {
+                    return Err(mismatch(&format!("an object for {tag}")));
+                };
+                let layout = self.layouts.layout(tag)?;
+                if let Some(unknown) = object
+                    .keys()
+                    .find(|key| !layout.iter().any(|(name, _)| name == *key))
+                {
+                    return Err(anyhow!("{path}.{unknown}: {tag} has no such field"));
+                }
+                let mut coerced = Map::new();
+                for (name, field_type) in &layout {
+                    let field = object
+                        .get(name)
+                        .ok_or_else(|| anyhow!("{path}.{name}: missing field of {tag}"))?;
+                    coerced.insert(
+                        name.clone(),
+                        self.coerce(field_type, field, &format!("{path}.{name}"))?,
+                    );
+                }
+                Ok(Value::Object(coerced))
+            }
+            MoveType::Signer | MoveType::Reference(_) | MoveType::Generic(_) => {
+                Err(anyhow!("{path}: {ty:?} cannot be a table key"))
+            }
+        }
+    }
+
+    fn decode_bcs(&mut self, ty: &MoveType, hex: &str) -> Result<Value> {
+        let bytes = decode_hex(hex).ok_or_else(|| anyhow!("--key-bcs is not valid hex"))?;
+        let mut input = bytes.as_slice();
+        let value = self.layouts.read(ty, &mut input, "key")?;
+        if !input.is_empty() {
+            return Err(anyhow!(
+                "--key-bcs has {} trailing byte(s) after the key",
+                input.len()
+            ));
+        }
+        Ok(value)
+    }
+}
+
+/// Whether a decimal string is larger than `max` (both without leading signs).
+fn exceeds(digits: &str, max: &str) -> bool {
+    let digits = digits.trim_start_matches('0');
+    digits.len() > max.len() || (digits.len() == max.len() && digits > max)
+}
+
+fn is_hex_address(value: &str) -> bool {
+    let hex = value.strip_prefix("0x").unwrap_or(value);
+    !hex.is_empty() && hex.len() <= 64 && hex.bytes().all(|byte| byte.is_ascii_hexdigit())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::commands::bcs::le_to_decimal;
+
+    fn builder() -> KeyBuilder<impl FnMut(&str) -> Result<Vec<(String, String)>>> {
+        KeyBuilder::new(|name: &str| match name {
+            "0xabc::pool::PoolKey" => Ok(vec![
+                ("id".to_owned(), "u64".to_owned()),
+                ("name".to_owned(), "0x1::string::String".to_owned()),
+            ]),
+            "0xabc::pool::Pair" => Ok(vec![
+                ("left".to_owned(), "T0".to_owned()),
+                ("right".to_owned(), "vector<T1>".to_owned()),
+            ]),
+            other => Err(anyhow!("unknown struct {other}")),
+        })
+    }
+
+    fn parse(ty: &str) -> MoveType {
+        MoveType::parse(ty).unwrap()
+    }
+
+    #[test]
+    fn coerces_string_and_byte_vector_keys() {
+        let mut builder = builder();
+        let string = parse("0x1::string::String");
+        assert_eq!(
+            builder.coerce(&string, &json!("alice"), "key").unwrap(),
+            json!("alice")
+        );
+        assert_eq!(
+            builder
+                .coerce(&string, &json!({"bytes": "0x616c696365"}), "key")
+                .unwrap(),
+            json!("alice")
+        );
+        assert_eq!(
+            builder.decode_bcs(&string, "0x05616c696365").unwrap(),
+            json!("alice")
+        );
+
+        let bytes = parse("vector<u8>");
+        assert_eq!(
+            builder.coerce(&bytes, &json!([1, 255]), "key").unwrap(),
+            json!("0x01ff")
+        );
+        assert_eq!(
+            builder.coerce(&bytes, &json!("0x01ff"), "key").unwrap(),
+            json!("0x01ff")
+        );
+        assert_eq!(
+            builder.decode_bcs(&bytes, "0x0201ff").unwrap(),
+            json!("0x01ff")
+        );
+        let err = builder.coerce(&bytes, &json!([1, 256]), "key").unwrap_err();
+        assert_eq!(err.to_string(), "key[1]: expected a byte, got 256");
+    }
+
+    #[test]
+    fn coerces_two_field_struct_keys() {
+        let mut builder = builder();
+        let key = parse("0xabc::pool::PoolKey");
+        assert_eq!(
+            builder
+                .coerce(&key, &json!({"id": 7, "name": "apt"}), "key")
+                .unwrap(),
+            json!({"id": "7", "name": "apt"})
+        );
+        // u64 7 followed by the string "apt".
+        assert_eq!(
+            builder
+                .decode_bcs(&key, "0x070000000000000003617074")
+                .unwrap(),
+            json!({"id": "7", "name": "apt"})
+        );
+
+        let err = builder
+            .coerce(&key, &json!({"id": "x", "name": "apt"}), "key")
+            .unwrap_err();
+        assert!(err
+            .to_string()
+            .starts_with("key.id: expected a decimal integer"));
+        let err = builder.coerce(&key, &json!({"id": 1}), "key").unwrap_err();
+        assert_eq!(
+            err.to_string(),
+            "key.name: missing field of 0xabc::pool::PoolKey"
+        );
+        let err = builder
+            .coerce(&key, &json!({"id": 1, "name": "a", "extra": 0}), "key")
+            .unwrap_err();
+        assert_eq!(
+            err.to_string(),
+            "key.extra: 0xabc::pool::PoolKey has no such field"
+        );
+    }
+
+    #[test]
+    fn resolves_generic_fields_and_options() {
+        let mut builder = builder();
+        let pair = parse("0xabc::pool::Pair<address, 0x1::option::Option<u8>>");
+        assert_eq!(
+            builder
+                .coerce(&pair, &json!({"left": "0x1", "right": [null, 3]}), "key")
+                .unwrap(),
+            json!({"left": "0x1", "right": [{"vec": []}, {"vec": [3]}]})
+        );
+        assert_eq!(le_to_decimal(&[0xff; 16]), u128::MAX.to_string());
+        assert_eq!(le_to_decimal(&[0xff; 32]), U256_MAX);
+    }
+}
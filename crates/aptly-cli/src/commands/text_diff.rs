@@ -0,0 +1,279 @@
+/// Unchanged lines shown around each change, as in `diff -u`.
+const CONTEXT_LINES: usize = 3;
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Edit {
+    /// Line `old` of the old text equals line `new` of the new text.
+    Equal {
+        old: usize,
+        new: usize,
+    },
+    Delete {
+        old: usize,
+    },
+    Insert {
+        new: usize,
+    },
+}
+
+/// Unified diff of `old` against `new`, compared line by line, or `None`
+/// when they have the same lines. With `ignore_whitespace`, lines that
+/// differ only in whitespace compare equal (like `diff -w`); the diff still
+/// prints the original lines.
+pub(crate) fn unified_diff(
+    old_label: &str,
+    new_label: &str,
+    old: &str,
+    new: &str,
+    ignore_whitespace: bool,
+) -> Option<String> {
+    let old_lines: Vec<&str> = old.lines().collect();
+    let new_lines: Vec<&str> = new.lines().collect();
+    let key = |line: &str| -> String {
+        if ignore_whitespace {
+            line.split_whitespace().collect()
+        } else {
+            line.to_owned()
+        }
+    };
+    let old_keys: Vec<String> = old_lines.iter().map(|line| key(line)).collect();
+    let new_keys: Vec<String> = new_lines.iter().map(|line| key(line)).collect();
+    let edits = shortest_edit(&old_keys, &new_keys);
+    if edits.iter().all(|edit| matches!(edit, Edit::Equal { .. })) {
+        return None;
+    }
+
+    let mut out = format!("--- {old_label}\n+++ {new_label}\n");
+    for hunk in hunks(&edits) {
+        let (mut old_start, mut old_count, mut new_start, mut new_count) = (None, 0, None, 0);
+        for edit in hunk {
+            match *edit {
+                Edit::Equal { old, new } => {
+                    old_start.get_or_insert(old);
+                    new_start.get_or_insert(new);
+                    old_count += 1;
+                    new_count += 1;
+                }
+                Edit::Delete { old } => {
+                    old_start.get_or_insert(old);
+                    old_count += 1;
+                }
+                Edit::Insert { new } => {
+                    new_start.get_or_insert(new);
+                    new_count += 1;
+                }
+            }
+        }
+        // Empty ranges name the line before them, as `diff -u` does.
+        let old_start = old_start.map_or_else(|| insertion_point(hunk, true), |start| start + 1);
+        let new_start = new_start.map_or_else(|| insertion_point(hunk, false), |start| start + 1);
+        out.push_str(&format!(
+            "@@ -{} +{} @@\n",
+            range(old_start, old_count),
+            range(new_start, new_count)
+        ));
+        for edit in hunk {
+            let (marker, line) = match *edit {
+                Edit::Equal { old, .. } => (' ', old_lines[old]),
+                Edit::Delete { old } => ('-', old_lines[old]),
+                Edit::Insert { new } => ('+', new_lines[new]),
+            };
+            out.push(marker);
+            out.push_str(line);
+            out.push('\n');
+        }
+    }
+    Some(out)
+}
+
+fn range(start: usize, count: usize) -> String {
+    if count == 1 {
+        start.to_string()
+    } else {
+        format!("{start},{count}")
+    }
+}
+
+/// Line number before a hunk side with no lines: the other side's edits
+/// pin where the hunk sits.
+fn insertion_point(hunk: &[Edit], old_side: bool) -> usize {
+    hunk.iter()
+        .find_map(|edit| match (*edit, old_side) {
+            (Edit::Insert { new }, true) => Some(new),
+            (Edit::Delete { old }, false) => Some(old),
+            _ => None,
+        })
+        .unwrap_or(0)
+}
+
+/// Splits edits into hunks of changes with up to [`CONTEXT_LINES`] of
+/// context on each side, merging changes whose context would overlap.
+fn hunks(edits: &[Edit]) -> Vec<&[Edit]> {
+    let changes: Vec<usize> = edits
+        .iter()
+        .enumerate()
+        .filter(|(_, edit)| !matches!(edit, Edit::Equal { .. }))
+        .map(|(index, _)| index)
+        .collect();
+    let mut hunks = Vec::new();
+    let mut index = 0;
+    while index < changes.len() {
+        let start = changes[index].saturating_sub(CONTEXT_LINES);
+        let mut last = changes[index];
+        while index + 1 < changes.len() && changes[index + 1] - last <= 2 * CONTEXT_LINES {
+            index += 1;
+            last = changes[index];
+        }
+        let end = (last + CONTEXT_LINES + 1).min(edits.len());
+        hunks.push(&edits[start..end]);
+        index += 1;
+    }
+    hunks
+}
+
+/// Myers' O((N+M)D) shortest edit script. Deletions come before insertions
+/// within a change, as in `diff`.
+fn shortest_edit(old: &[String], new: &[String]) -> Vec<Edit> {
+    let (n, m) = (old.len() as isize, new.len() as isize);
+    let max = n + m;
+    let offset = max as usize;
+    let mut frontier = vec![0isize; 2 * offset + 2];
+    let mut trace = Vec::new();
+    'search: for d in 0..=max {
+        trace.push(frontier.clone());
+        for k in (-d..=d).step_by(2) {
+            let index = (k + max) as usize;
+            let mut x = if k == -d || (k != d && frontier[index - 1] < frontier[index + 1]) {
+                frontier[index + 1]
+            } else {
+                frontier[index - 1] + 1
+            };
+            let mut y = x - k;
+            while x < n && y < m && old[x as usize] == new[y as usize] {
+                x += 1;
+                y += 1;
+            }
+            frontier[index] = x;
+            if x >= n && y >= m {
+                break 'search;
+            }
+        }
+    }
+
+    let mut edits = Vec::new();
+    let (mut x, mut y) = (n, m);
+    for (d, frontier) in trace.iter().enumerate().rev() {
+        let d = d as isize;
+        let k = x - y;
+        let index = (k + max) as usize;
+        let previous_k = if k == -d || (k != d && frontier[index - 1] < frontier[index + 1]) {
+            k + 1
+        } else {
+            k - 1
+        };
+        let previous_x = frontier[(previous_k + max) as usize];
+        let previous_y = previous_x - previous_k;
+        while x > previous_x && y > previous_y {
+            x -= 1;
+            y -= 1;
+            edits.push(Edit::Equal {
+                old: x as usize,
+                new: y as usize,
+            });
+        }
+        if d > 0 {
+            if x == previous_x {
+                edits.push(Edit::Insert {
+                    new: previous_y as usize,
+                });
+            } else {
+                edits.push(Edit::Delete {
+                    old: previous_x as usize,
+                });
+            }
+        }
+        x = previous_x;
+        y = previous_y;
+    }
+    edits.reverse();
+    edits
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn numbered(lines: std::ops::Range<usize>) -> String {
+        lines.map(|line| format!("line {line}\n")).collect()
+    }
+
+    #[test]
+    fn reports_equal_texts_as_none() {
+        assert_eq!(unified_diff("a", "b", "x\ny\n", "x\ny", false), None);
+        assert_eq!(unified_diff("a", "b", "", "", false), None);
+        assert_eq!(
+            unified_diff("a", "b", "fun f() {\n  1\n}", "fun  f() {\n\t1 \n}", true),
+            None
+        );
+        assert!(unified_diff("a", "b", "fun f() {}", "fun  f() {}", false).is_some());
+    }
+
+    #[test]
+    fn renders_hunks_like_diff_u() {
+        let old = numbered(1..21);
+        let new = old
+            .replace("line 2\n", "line two\n")
+            .replace("line 15\n", "")
+            .replace("line 20\n", "line 20\nline 21\n");
+        let expected = "\
+--- onchain/coin.move
++++ local/coin.move
+@@ -1,5 +1,5 @@
+ line 1
+-line 2
++line two
+ line 3
+ line 4
+ line 5
+@@ -12,9 +12,9 @@
+ line 12
+ line 13
+ line 14
+-line 15
+ line 16
+ line 17
+ line 18
+ line 19
+ line 20
++line 21
+";
+        assert_eq!(
+            unified_diff("onchain/coin.move", "local/coin.move", &old, &new, false).unwrap(),
+            expected
+        );
+    }
+
+    #[test]
+    fn numbers_empty_sides_from_the_line_before() {
+        assert_eq!(
+            unified_diff("a", "b", "", "x\ny\n", false).unwrap(),
+            "--- a\n+++ b\n@@ -0,0 +1,2 @@\n+x\n+y\n"
+        );
+        assert_eq!(
+            unified_diff("a", "b", "x\n", "", false).unwrap(),
+            "--- a\n+++ b\n@@ -1 +0,0 @@\n-x\n"
+        );
+    }
+
+    #[test]
+    fn finds_minimal_edits() {
+        let keys = |text: &str| -> Vec<String> { text.chars().map(String::from).collect() };
+        // The classic Myers example: ABCABBA -> CBABAC takes 5 edits.
+        let edits = shortest_edit(&keys("ABCABBA"), &keys("CBABAC"));
+        let changes = edits
+            .iter()
+            .filter(|edit| !matches!(edit, Edit::Equal { .. }))
+            .count();
+        assert_eq!(changes, 5);
+    }
+}
@@ -5,13 +5,32 @@ use clap::{Args, Subcommand};
 use num_bigint::BigInt;
 use serde::Serialize;
 use serde_json::{json, Value};
-use std::collections::HashMap;
+use std::collections::{BTreeSet, HashMap};
 use std::io::{self, IsTerminal, Read};
+use std::path::{Path, PathBuf};
 use std::process::{Command, Stdio};
 use std::str::FromStr;
 use std::time::Duration;
 
-use crate::commands::common::{get_nested_string, parse_u64, value_to_string};
+use crate::commands::abort::{self, AbortResolver, ResolvedAbort};
+use crate::commands::common::{
+    get_nested_string, normalize_address, parse_u64, value_to_string, CanonicalAddresses,
+};
+use crate::commands::graph::{run_tx_graph, TxGraphArgs};
+use crate::commands::labels::{AddressLabels, LabelAddresses};
+use crate::commands::node::{fetch_gas_estimate, GasEstimate};
+use crate::commands::repl;
+use crate::commands::schema::{
+    array_schema, boolean_schema, integer_schema, nullable, object_schema, string_schema,
+    with_optional_properties, OutputSchema,
+};
+use crate::commands::signers::{run_tx_signers, TxSignersArgs};
+use crate::commands::simulate_compare::run_simulation_compare;
+use crate::commands::state_diff::{run_tx_state_diff, TxStateDiffArgs};
+use crate::commands::swaps::{run_tx_swaps, TxSwapsArgs};
+use crate::commands::tx_cost::{run_tx_cost, TxCostArgs};
+use crate::commands::tx_pretty;
+use crate::sqlite_export::{self, BalanceChangeRow};
 
 const OBJECT_CORE_TYPE: &str = "0x1::object::ObjectCore";
 const FUNGIBLE_STORE_TYPE: &str = "0x1::fungible_asset::FungibleStore";
@@ -20,7 +39,7 @@ const SENTIO_TRACE_BASE_URL: &str = "https://app.sentio.xyz";
 
 #[derive(Args)]
 #[command(
-    after_help = "Examples:\n  aptly tx 4300326632\n  aptly tx 0xf44b2ea4a0cd55a31559fc022a2fba12aa81c46dcfce31a050d9d42d93a7dae5\n  aptly tx list --limit 10 --start 0\n  aptly tx encode < unsigned_txn.json\n  aptly tx simulate 0x1 < payload.json\n  aptly tx submit < signed_txn.json\n  aptly tx compose < compose_payload.json\n  aptly tx trace 4300326632 --local-tracer\n  aptly tx balance-change 4300326632 --aggregate"
+    after_help = "Examples:\n  aptly tx 4300326632\n  aptly tx 4300326632 --pretty\n  aptly tx 0xf44b2ea4a0cd55a31559fc022a2fba12aa81c46dcfce31a050d9d42d93a7dae5\n  aptly tx list --limit 10 --start 0\n  aptly tx encode < unsigned_txn.json\n  aptly tx simulate 0x1 < payload.json\n  aptly tx simulate 0x1 --summary < payload.json\n  aptly tx simulate 0xa11ce --compare-sender 0xb0b --pretty < payload.json\n  aptly tx cost 0xa11ce --pretty < payload.json\n  aptly tx submit < signed_txn.json\n  aptly tx compose < compose_payload.json\n  aptly tx trace 4300326632 --local-tracer\n  aptly tx balance-change 4300326632 --aggregate\n  aptly tx signers 4300326632 --pretty\n  aptly tx graph 4300326632 --pretty --price-with pyth\n  aptly tx swaps 4300326632 --pretty"
 )]
 pub(crate) struct TxCommand {
     #[command(subcommand)]
@@ -29,6 +48,10 @@ pub(crate) struct TxCommand {
     /// Used when no subcommand is provided.
     #[arg(value_name = "VERSION_OR_HASH")]
     pub(crate) version_or_hash: Option<String>,
+    /// Render an overview, payload, events and write set as text. Exits
+    /// non-zero when the transaction failed.
+    #[arg(long, default_value_t = false)]
+    pub(crate) pretty: bool,
 }
 
 #[derive(Subcommand)]
@@ -39,6 +62,11 @@ pub(crate) enum TxSubcommand {
     Encode,
     #[command(about = "Simulate an entry function payload JSON from stdin")]
     Simulate(TxSimulateArgs),
+    #[command(
+        about = "Estimate the APT fee of an entry function payload JSON from stdin",
+        long_about = "Simulate an entry function payload JSON from stdin with node gas estimation and report gas units, the gas unit price, and the fee in octas and APT, plus the fee at the prioritized gas price as an upper bound. Exits non-zero with the failure reason when the simulation fails."
+    )]
+    Cost(TxCostArgs),
     #[command(about = "Submit a signed transaction JSON from stdin")]
     Submit,
     #[command(about = "Compose script bytecode from batched call payload JSON on stdin")]
@@ -47,9 +75,28 @@ pub(crate) enum TxSubcommand {
     Trace(TxTraceArgs),
     #[command(
         name = "balance-change",
-        about = "Summarize fungible asset balance changes for a transaction"
+        about = "Summarize fungible asset balance changes for a transaction",
+        after_help = "Query an export:\n  aptly tx balance-change --block 98765432 --export-sqlite changes.db\n  sqlite3 changes.db \"SELECT account, asset, SUM(CAST(amount AS INTEGER)) FROM balance_changes WHERE kind = 'deposit' GROUP BY 1, 2\""
     )]
     BalanceChange(TxBalanceChangeArgs),
+    #[command(
+        name = "state-diff",
+        about = "Diff resources written by a transaction against their prior state",
+        after_help = "Examples:\n  aptly tx state-diff 123456 --address 0x1234 --type 0x1::stake::StakePool\n  aptly tx state-diff 123456 --address 0x1234"
+    )]
+    StateDiff(TxStateDiffArgs),
+    #[command(about = "Decode who signed a transaction and with which keys")]
+    Signers(TxSignersArgs),
+    #[command(
+        about = "Build the owner-to-owner transfer graph of a transaction",
+        after_help = "Examples:\n  aptly tx graph 123456 --pretty\n  aptly tx graph 123456 --dot --price-with pyth | dot -Tsvg > tx.svg\n  aptly tx graph 123456 --pretty --simplify\n  aptly tx graph 123456 --export-sqlite flows.db\n  aptly tx graph --block 98765432 --simplify --export-sqlite flows.db\n  sqlite3 flows.db \"SELECT from_address, to_address, asset, COUNT(*) FROM transfers WHERE source = 'tx graph --simplify' GROUP BY 1, 2, 3 ORDER BY 4 DESC\"\n\nPrice feeds: built-in Pyth feeds cover APT, USDC and USDT. Add others in $XDG_CONFIG_HOME/aptly/price_feeds.json (default ~/.config/aptly/price_feeds.json) as {\"<asset metadata address>\": {\"pyth\": \"0x<feed id>\", \"switchboard\": \"0x<aggregator>\"}}. Prices are current, not historical."
+    )]
+    Graph(TxGraphArgs),
+    #[command(
+        about = "Detect DEX swaps in a transaction",
+        after_help = "Examples:\n  aptly tx swaps 123456\n  aptly tx swaps 123456 --pretty\n\nKnown protocols: Liquidswap, PancakeSwap, Thala and Cellana. Transactions through other DEXes fall back to the sender's net withdraw and deposit of two assets. Add protocols in $XDG_CONFIG_HOME/aptly/swap_protocols.json (default ~/.config/aptly/swap_protocols.json) as a list of entries; a user entry replaces a built-in one with the same address and event. For example:\n  [{\"protocol\": \"mydex\", \"address\": \"0x<dex>\", \"event\": \"router::SwapEvent\", \"layout\": \"tokens\", \"asset_in\": \"from\", \"asset_out\": \"to\", \"amount_in\": \"in\", \"amount_out\": \"out\", \"fee\": \"fee\"}]\nThe `pair` layout takes x_in, x_out, y_in and y_out fields with assets from the event's first two type arguments; `indexed` takes index_in, index_out, amount_in and amount_out indexing into the type arguments."
+    )]
+    Swaps(TxSwapsArgs),
 }
 
 #[derive(Args)]
@@ -68,9 +115,20 @@ pub(crate) struct TxBalanceChangeArgs {
     /// If omitted, reads full transaction JSON from stdin.
     #[arg(value_name = "VERSION_OR_HASH")]
     pub(crate) version_or_hash: Option<String>,
-    /// Aggregate deltas by `(account, asset)` pair.
+    /// Summarize every transaction of the block at this height instead,
+    /// printing `{version, changes}` for each one with changes.
+    #[arg(long, value_name = "HEIGHT", conflicts_with = "version_or_hash")]
+    pub(crate) block: Option<u64>,
+    /// Aggregate deltas by `(account, asset)` pair; with `--block`, across
+    /// the whole block.
     #[arg(long, default_value_t = false)]
     pub(crate) aggregate: bool,
+    /// Also append the per-event changes to this SQLite database.
+    #[arg(long, value_name = "FILE")]
+    pub(crate) export_sqlite: Option<PathBuf>,
+    /// Add `account_label` for known addresses (see `aptly labels`).
+    #[arg(long, default_value_t = false)]
+    pub(crate) labels: bool,
 }
 
 #[derive(Args)]
@@ -78,6 +136,17 @@ pub(crate) struct TxSimulateArgs {
     /// Sender account address used to resolve sequence number.
     #[arg(value_name = "SENDER")]
     pub(crate) sender: String,
+    /// Print success, VM status, gas used, and the resolved abort instead of
+    /// the full simulated transaction.
+    #[arg(long, default_value_t = false)]
+    pub(crate) summary: bool,
+    /// Also simulate the payload from this sender and print both outcomes
+    /// side by side; exits non-zero when `SENDER`'s simulation fails.
+    #[arg(long, value_name = "ADDRESS", conflicts_with = "summary")]
+    pub(crate) compare_sender: Option<String>,
+    /// With `--compare-sender`, print a two-column text summary.
+    #[arg(long, default_value_t = false, requires = "compare_sender")]
+    pub(crate) pretty: bool,
 }
 
 #[derive(Args)]
@@ -106,63 +175,261 @@ pub(crate) struct TxComposeArgs {
 }
 
 #[derive(Debug, Clone, Serialize)]
-struct BalanceChange {
+pub(crate) struct SimulationSummary {
+    pub(crate) success: bool,
+    pub(crate) vm_status: String,
+    pub(crate) gas_used: String,
+    pub(crate) abort: Option<ResolvedAbort>,
+}
+
+impl SimulationSummary {
+    pub(crate) fn new(client: &AptosClient, simulated: &Value) -> Self {
+        let vm_status = get_nested_string(simulated, &["vm_status"]);
+        Self {
+            success: simulated.get("success").and_then(Value::as_bool) == Some(true),
+            gas_used: get_nested_string(simulated, &["gas_used"]),
+            abort: AbortResolver::default().resolve_status(client, &vm_status),
+            vm_status,
+        }
+    }
+}
+
+impl OutputSchema for SimulationSummary {
+    fn output_schema() -> Value {
+        object_schema(
+            "Simulation outcome (`--summary`)",
+            &[
+                (
+                    "success",
+                    boolean_schema("Whether the simulated transaction succeeded"),
+                ),
+                ("vm_status", string_schema("VM status reported by the node")),
+                ("gas_used", string_schema("Gas units used")),
+                ("abort", nullable(abort::resolved_abort_schema())),
+            ],
+        )
+    }
+}
+
+pub(crate) fn simulate_summary_output_schema() -> Value {
+    SimulationSummary::output_schema()
+}
+
+#[derive(Debug, Clone, Serialize)]
+pub(crate) struct BalanceChange {
     #[serde(rename = "type")]
-    event_type: String,
-    account: String,
-    fungible_store: String,
-    asset: String,
-    amount: String,
+    pub(crate) event_type: String,
+    pub(crate) account: String,
+    pub(crate) fungible_store: String,
+    pub(crate) asset: String,
+    pub(crate) amount: String,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub(crate) account_label: Option<String>,
 }
 
 #[derive(Debug, Clone, Serialize)]
-struct AggregatedBalanceChange {
-    account: String,
-    asset: String,
-    amount: String,
+pub(crate) struct AggregatedBalanceChange {
+    pub(crate) account: String,
+    pub(crate) asset: String,
+    pub(crate) amount: String,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    account_label: Option<String>,
+}
+
+impl OutputSchema for BalanceChange {
+    fn output_schema() -> Value {
+        let schema = object_schema(
+            "Fungible asset balance change derived from transaction events",
+            &[
+                (
+                    "type",
+                    json!({
+                        "description": "Change kind",
+                        "type": "string",
+                        "enum": ["gas_fee", "withdraw", "deposit"],
+                    }),
+                ),
+                ("account", string_schema("Owner of the fungible store")),
+                ("fungible_store", string_schema("Fungible store address")),
+                ("asset", string_schema("Fungible asset metadata address")),
+                ("amount", string_schema("Unsigned amount in base units")),
+            ],
+        );
+        with_optional_properties(
+            schema,
+            &[(
+                "account_label",
+                string_schema("Known label of `account` (`--labels`)"),
+            )],
+        )
+    }
+}
+
+impl OutputSchema for AggregatedBalanceChange {
+    fn output_schema() -> Value {
+        let schema = object_schema(
+            "Net balance delta per (account, asset) pair",
+            &[
+                ("account", string_schema("Account address")),
+                ("asset", string_schema("Fungible asset metadata address")),
+                ("amount", string_schema("Signed net amount in base units")),
+            ],
+        );
+        with_optional_properties(
+            schema,
+            &[(
+                "account_label",
+                string_schema("Known label of `account` (`--labels`)"),
+            )],
+        )
+    }
+}
+
+/// Balance changes of one transaction of a `--block`.
+#[derive(Debug, Clone, Serialize)]
+struct BlockTxChanges {
+    version: u64,
+    changes: Vec<BalanceChange>,
+}
+
+impl OutputSchema for BlockTxChanges {
+    fn output_schema() -> Value {
+        object_schema(
+            "Balance changes of one transaction in the block",
+            &[
+                (
+                    "version",
+                    integer_schema("Ledger version of the transaction"),
+                ),
+                (
+                    "changes",
+                    array_schema(
+                        "Balance changes in event order",
+                        BalanceChange::output_schema(),
+                    ),
+                ),
+            ],
+        )
+    }
+}
+
+pub(crate) fn balance_change_output_schema() -> Value {
+    json!({
+        "oneOf": [
+            array_schema("Balance changes in event order", BalanceChange::output_schema()),
+            array_schema(
+                "Aggregated balance changes (`--aggregate`)",
+                AggregatedBalanceChange::output_schema(),
+            ),
+            array_schema(
+                "Transactions of the block with balance changes, in order (`--block`)",
+                BlockTxChanges::output_schema(),
+            ),
+        ],
+    })
+}
+
+pub(crate) fn compose_output_schema() -> Value {
+    json!({
+        "oneOf": [
+            {
+                "description": "0x-prefixed script bytecode",
+                "type": "string",
+            },
+            {
+                "description": "Script payload JSON (`--emit-script-payload`)",
+                "type": "object",
+            },
+        ],
+    })
+}
+
+impl LabelAddresses for BalanceChange {
+    fn apply_labels(&mut self, labels: &AddressLabels) {
+        self.account_label = labels.get(&self.account).map(str::to_owned);
+    }
+}
+
+impl LabelAddresses for AggregatedBalanceChange {
+    fn apply_labels(&mut self, labels: &AddressLabels) {
+        self.account_label = labels.get(&self.account).map(str::to_owned);
+    }
+}
+
+impl CanonicalAddresses for BalanceChange {
+    fn canonicalize_addresses(&mut self) {
+        self.account = normalize_address(&self.account);
+        self.fungible_store = normalize_address(&self.fungible_store);
+        self.asset = normalize_address(&self.asset);
+    }
+}
+
+impl CanonicalAddresses for AggregatedBalanceChange {
+    fn canonicalize_addresses(&mut self) {
+        self.account = normalize_address(&self.account);
+        self.asset = normalize_address(&self.asset);
+    }
 }
 
 #[derive(Debug, Clone, Default)]
-struct TransferStoreMetadata {
-    owner: String,
-    asset: String,
+pub(crate) struct TransferStoreMetadata {
+    pub(crate) owner: String,
+    pub(crate) asset: String,
 }
 
-pub(crate) fn run_tx(client: &AptosClient, rpc_url: &str, command: TxCommand) -> Result<()> {
+pub(crate) fn run_tx(
+    client: &AptosClient,
+    command: TxCommand,
+    canonical_addresses: bool,
+) -> Result<()> {
     match (command.command, command.version_or_hash) {
         (Some(TxSubcommand::List(args)), _) => {
-            let mut path = format!("/transactions?limit={}", args.limit);
-            if args.start > 0 {
-                path.push_str(&format!("&start={}", args.start));
-            }
-            let value = client.get_json(&path)?;
+            let value = fetch_transactions(client, args.limit, args.start)?;
             crate::print_pretty_json(&value)
         }
         (Some(TxSubcommand::Encode), _) => run_tx_encode(client),
         (Some(TxSubcommand::Simulate(args)), _) => run_tx_simulate(client, &args),
-        (Some(TxSubcommand::Compose(args)), _) => run_tx_compose(rpc_url, &args),
-        (Some(TxSubcommand::Trace(args)), _) => run_tx_trace(client, rpc_url, &args),
+        (Some(TxSubcommand::Cost(args)), _) => run_tx_cost(client, &args),
+        (Some(TxSubcommand::Compose(args)), _) => run_tx_compose(client.base_url(), &args),
+        (Some(TxSubcommand::Trace(args)), _) => run_tx_trace(client, &args),
         (Some(TxSubcommand::Submit), _) => {
             let reader = io::stdin();
             let txn: Value = serde_json::from_reader(reader.lock())
                 .context("failed to parse signed transaction JSON from stdin")?;
-            let value = client.post_json("/transactions", &txn)?;
+            let value = client
+                .post_json("/transactions", &txn)
+                .map_err(|err| explain_abort(client, err))?;
             crate::print_pretty_json(&value)
         }
-        (Some(TxSubcommand::BalanceChange(args)), _) => run_tx_balance_change(client, &args),
+        (Some(TxSubcommand::BalanceChange(args)), _) => {
+            run_tx_balance_change(client, &args, canonical_addresses)
+        }
+        (Some(TxSubcommand::StateDiff(args)), _) => run_tx_state_diff(client, &args),
+        (Some(TxSubcommand::Signers(args)), _) => run_tx_signers(client, &args),
+        (Some(TxSubcommand::Graph(args)), _) => run_tx_graph(client, &args, canonical_addresses),
+        (Some(TxSubcommand::Swaps(args)), _) => run_tx_swaps(client, &args),
         (None, Some(version_or_hash)) => {
-            let path = if version_or_hash.parse::<u64>().is_ok() {
-                format!("/transactions/by_version/{version_or_hash}")
-            } else {
-                format!("/transactions/by_hash/{version_or_hash}")
-            };
-            let value = client.get_json(&path)?;
+            let value = fetch_transaction(client, &version_or_hash)?.json;
+            if command.pretty {
+                return print_pretty_tx(client, &value);
+            }
+            report_abort(client, &value);
             crate::print_pretty_json(&value)
         }
         (None, None) => Err(anyhow!("missing version/hash or subcommand")),
     }
 }
 
+/// Fetches a page of transactions from `/transactions`; `start == 0` returns
+/// the most recent ones.
+pub(crate) fn fetch_transactions(client: &AptosClient, limit: u64, start: u64) -> Result<Value> {
+    let mut path = format!("/transactions?limit={limit}");
+    if start > 0 {
+        path.push_str(&format!("&start={start}"));
+    }
+    client.get_json(&path)
+}
+
 fn run_tx_encode(client: &AptosClient) -> Result<()> {
     let reader = io::stdin();
     let txn: Value = serde_json::from_reader(reader.lock())
@@ -174,50 +441,142 @@ fn run_tx_encode(client: &AptosClient) -> Result<()> {
 fn run_tx_simulate(client: &AptosClient, args: &TxSimulateArgs) -> Result<()> {
     let stdin_value = read_json_from_stdin("failed to parse payload JSON from stdin")?;
     let payload = normalize_simulation_payload(&stdin_value)?;
+    let params = SimulationParams::fetch(client)?;
+
+    if let Some(compare_sender) = &args.compare_sender {
+        return run_simulation_compare(
+            client,
+            &params,
+            &payload,
+            &args.sender,
+            compare_sender,
+            args.pretty,
+        );
+    }
+
+    let simulated = simulate_payload(client, &params, &args.sender, &payload)?;
+    if args.summary {
+        return crate::print_serialized(&SimulationSummary::new(client, &simulated));
+    }
 
+    report_abort(client, &simulated);
+    crate::print_pretty_json(&simulated)
+}
+
+/// Gas price and expiration shared by every simulation of one invocation.
+pub(crate) struct SimulationParams {
+    gas_unit_price: String,
+    expiration_timestamp_secs: String,
+    /// Let the node choose the gas unit price and max gas amount.
+    estimate_gas: bool,
+    pub(crate) gas_estimate: GasEstimate,
+}
+
+impl SimulationParams {
+    pub(crate) fn fetch(client: &AptosClient) -> Result<Self> {
+        let gas_estimate =
+            fetch_gas_estimate(client).context("failed to fetch gas price estimate")?;
+        let gas_unit_price = gas_estimate.normal.unwrap_or(100).to_string();
+
+        let ledger = client
+            .get_json("/")
+            .context("failed to fetch ledger info for expiration")?;
+        let ledger_timestamp_micros =
+            parse_u64(ledger.get("ledger_timestamp").unwrap_or(&Value::Null))
+                .ok_or_else(|| anyhow!("failed to parse ledger timestamp"))?;
+        Ok(Self {
+            gas_unit_price,
+            expiration_timestamp_secs: (ledger_timestamp_micros / 1_000_000 + 600).to_string(),
+            estimate_gas: false,
+            gas_estimate,
+        })
+    }
+
+    /// Simulates with the node's own gas unit price and max gas amount
+    /// estimates, as a wallet would before signing.
+    pub(crate) fn with_gas_estimation(self) -> Self {
+        Self {
+            estimate_gas: true,
+            ..self
+        }
+    }
+}
+
+/// Simulates `payload` from `sender` at its current sequence number and
+/// returns the simulated transaction.
+pub(crate) fn simulate_payload(
+    client: &AptosClient,
+    params: &SimulationParams,
+    sender: &str,
+    payload: &Value,
+) -> Result<Value> {
     let account = client
-        .get_json(&format!("/accounts/{}", args.sender))
-        .context("failed to fetch sender account")?;
+        .get_json(&format!("/accounts/{sender}"))
+        .with_context(|| format!("failed to fetch sender account {sender}"))?;
     let sequence_number = get_nested_string(&account, &["sequence_number"]);
     if sequence_number.is_empty() {
         return Err(anyhow!("failed to resolve sender sequence number"));
     }
 
-    let gas_price = client
-        .get_json("/estimate_gas_price")
-        .context("failed to fetch gas price estimate")?;
-    let gas_unit_price = first_non_empty_string(&[
-        get_nested_string(&gas_price, &["gas_estimate"]),
-        get_nested_string(&gas_price, &["gas_unit_price"]),
-    ])
-    .unwrap_or_else(|| "100".to_owned());
-
-    let ledger = client
-        .get_json("/")
-        .context("failed to fetch ledger info for expiration")?;
-    let ledger_timestamp_micros = parse_u64(ledger.get("ledger_timestamp").unwrap_or(&Value::Null))
-        .ok_or_else(|| anyhow!("failed to parse ledger timestamp"))?;
-    let expiration_timestamp_secs = (ledger_timestamp_micros / 1_000_000 + 600).to_string();
-
     let simulate_request = json!({
-        "sender": args.sender,
+        "sender": sender,
         "sequence_number": sequence_number,
         "max_gas_amount": "200000",
-        "gas_unit_price": gas_unit_price,
-        "expiration_timestamp_secs": expiration_timestamp_secs,
+        "gas_unit_price": params.gas_unit_price,
+        "expiration_timestamp_secs": params.expiration_timestamp_secs,
         "payload": payload,
         "signature": {"type": "no_account_signature"}
     });
 
+    let path = if params.estimate_gas {
+        "/transactions/simulate?estimate_gas_unit_price=true&estimate_max_gas_amount=true"
+    } else {
+        "/transactions/simulate"
+    };
     let response = client
-        .post_json("/transactions/simulate", &simulate_request)
+        .post_json(path, &simulate_request)
         .context("failed to simulate transaction")?;
+    Ok(match response {
+        Value::Array(mut items) if !items.is_empty() => items.swap_remove(0),
+        other => other,
+    })
+}
 
-    if let Some(first) = response.as_array().and_then(|arr| arr.first()) {
-        return crate::print_pretty_json(first);
+/// Notes a failed transaction's resolved abort on stderr, leaving the JSON
+/// output unchanged.
+fn report_abort(client: &AptosClient, tx: &Value) {
+    if tx.get("success").and_then(Value::as_bool) != Some(false) {
+        return;
     }
+    let vm_status = get_nested_string(tx, &["vm_status"]);
+    if let Some(abort) = AbortResolver::default().resolve_status(client, &vm_status) {
+        eprintln!("note: {abort}");
+    }
+}
+
+/// Prints the text page for `tx --pretty`; a failed transaction becomes an
+/// error after the page is printed.
+fn print_pretty_tx(client: &AptosClient, tx: &Value) -> Result<()> {
+    let failed = tx.get("success").and_then(Value::as_bool) == Some(false);
+    let vm_status = get_nested_string(tx, &["vm_status"]);
+    let abort = if failed {
+        AbortResolver::default().resolve_status(client, &vm_status)
+    } else {
+        None
+    };
+    print!("{}", tx_pretty::render_tx(tx, abort.as_ref()));
+    if failed {
+        return Err(anyhow!("transaction failed: {vm_status}"));
+    }
+    Ok(())
+}
 
-    crate::print_pretty_json(&response)
+/// Adds the resolved abort to a node error that reports one.
+fn explain_abort(client: &AptosClient, err: anyhow::Error) -> anyhow::Error {
+    match AbortResolver::default().resolve_status(client, &format!("{err:#}")) {
+        Some(abort) => err.context(abort.to_string()),
+        None => err,
+    }
 }
 
 fn run_tx_compose(rpc_url: &str, args: &TxComposeArgs) -> Result<()> {
@@ -257,12 +616,12 @@ fn run_tx_compose(rpc_url: &str, args: &TxComposeArgs) -> Result<()> {
     Ok(())
 }
 
-fn read_json_from_stdin(error_message: &str) -> Result<Value> {
+pub(crate) fn read_json_from_stdin(error_message: &str) -> Result<Value> {
     let reader = io::stdin();
     serde_json::from_reader(reader.lock()).context(error_message.to_owned())
 }
 
-fn normalize_simulation_payload(input: &Value) -> Result<Value> {
+pub(crate) fn normalize_simulation_payload(input: &Value) -> Result<Value> {
     if let Some(payload) = input.get("payload") {
         return Ok(payload.clone());
     }
@@ -297,12 +656,12 @@ fn normalize_simulation_payload(input: &Value) -> Result<Value> {
     }))
 }
 
-fn run_tx_trace(client: &AptosClient, rpc_url: &str, args: &TxTraceArgs) -> Result<()> {
+fn run_tx_trace(client: &AptosClient, args: &TxTraceArgs) -> Result<()> {
     let tx_hash = resolve_trace_tx_hash(client, &args.version_or_hash)?;
     let chain_id = resolve_trace_chain_id(client)?;
     let trace_json = if let Some(local_tracer) = args.local_tracer.as_ref() {
         run_local_trace_with_aptos_tracer(
-            rpc_url,
+            client.base_url(),
             chain_id,
             &tx_hash,
             local_tracer.as_ref().map(String::as_str),
@@ -444,26 +803,250 @@ fn strip_hex_prefix(value: &str) -> &str {
         .unwrap_or(value)
 }
 
-fn run_tx_balance_change(client: &AptosClient, args: &TxBalanceChangeArgs) -> Result<()> {
+fn run_tx_balance_change(
+    client: &AptosClient,
+    args: &TxBalanceChangeArgs,
+    canonical_addresses: bool,
+) -> Result<()> {
+    if let Some(height) = args.block {
+        return run_block_balance_change(client, args, height, canonical_addresses);
+    }
     let tx = get_transaction(client, args.version_or_hash.as_deref())?;
-    if tx.get("type").and_then(Value::as_str).unwrap_or_default() != "user_transaction" {
-        return Err(anyhow!("not a user transaction"));
+    let version = tx.version();
+    let mut events = transaction_balance_changes(client, &tx);
+    tx.note_if_empty(events.is_empty(), "balance changes");
+    if canonical_addresses {
+        events.canonicalize_addresses();
     }
 
-    let version = parse_u64(tx.get("version").unwrap_or(&Value::Null)).unwrap_or(0);
-    let mut store_info = extract_transfer_store_info_from_tx(&tx);
-    let events = build_balance_change_events(&tx, &mut store_info, client, version);
+    if let Some(path) = &args.export_sqlite {
+        export_balance_changes(client, path, &[(version, &events)])?;
+    }
 
+    let labels = if args.labels {
+        Some(AddressLabels::load()?)
+    } else {
+        None
+    };
     if args.aggregate {
-        let aggregated = aggregate_events(&events);
+        let mut aggregated = aggregate_events(&events);
+        if let Some(labels) = &labels {
+            aggregated.apply_labels(labels);
+        }
         return crate::print_serialized(&aggregated);
     }
-
+    if let Some(labels) = &labels {
+        events.apply_labels(labels);
+    }
     crate::print_serialized(&events)
 }
 
-fn get_transaction(client: &AptosClient, version_or_hash: Option<&str>) -> Result<Value> {
-    if !io::stdin().is_terminal() {
+/// `--block`: the changes of every transaction in the block at `height`.
+fn run_block_balance_change(
+    client: &AptosClient,
+    args: &TxBalanceChangeArgs,
+    height: u64,
+    canonical_addresses: bool,
+) -> Result<()> {
+    let mut block = Vec::new();
+    for tx in block_transactions(client, height)? {
+        let mut changes = transaction_balance_changes(client, &tx);
+        if changes.is_empty() {
+            continue;
+        }
+        if canonical_addresses {
+            changes.canonicalize_addresses();
+        }
+        block.push(BlockTxChanges {
+            version: tx.version(),
+            changes,
+        });
+    }
+
+    if let Some(path) = &args.export_sqlite {
+        let txs: Vec<(u64, &[BalanceChange])> = block
+            .iter()
+            .map(|tx| (tx.version, tx.changes.as_slice()))
+            .collect();
+        export_balance_changes(client, path, &txs)?;
+    }
+
+    let labels = if args.labels {
+        Some(AddressLabels::load()?)
+    } else {
+        None
+    };
+    if args.aggregate {
+        let changes: Vec<BalanceChange> = block.into_iter().flat_map(|tx| tx.changes).collect();
+        let mut aggregated = aggregate_events(&changes);
+        if let Some(labels) = &labels {
+            aggregated.apply_labels(labels);
+        }
+        return crate::print_serialized(&aggregated);
+    }
+    if let Some(labels) = &labels {
+        for tx in &mut block {
+            tx.changes.apply_labels(labels);
+        }
+    }
+    crate::print_serialized(&block)
+}
+
+/// Transactions of the block at `height`, in order.
+pub(crate) fn block_transactions(client: &AptosClient, height: u64) -> Result<Vec<Transaction>> {
+    let block = client.get_json(&format!(
+        "/blocks/by_height/{height}?with_transactions=true"
+    ))?;
+    let transactions = block
+        .get("transactions")
+        .and_then(Value::as_array)
+        .ok_or_else(|| anyhow!("block {height} response has no transactions"))?;
+    Ok(transactions.iter().cloned().map(Transaction::new).collect())
+}
+
+/// Appends the changes of each `(version, changes)` transaction, keyed by
+/// their position in it, and the metadata of their assets.
+fn export_balance_changes(
+    client: &AptosClient,
+    path: &Path,
+    txs: &[(u64, &[BalanceChange])],
+) -> Result<()> {
+    let mut conn = sqlite_export::open(path)?;
+    let rows: Vec<BalanceChangeRow> = txs
+        .iter()
+        .flat_map(|(version, changes)| {
+            changes
+                .iter()
+                .enumerate()
+                .map(|(index, change)| BalanceChangeRow {
+                    version: *version,
+                    event_index: index as u64,
+                    kind: &change.event_type,
+                    account: &change.account,
+                    fungible_store: &change.fungible_store,
+                    asset: &change.asset,
+                    amount: &change.amount,
+                })
+        })
+        .collect();
+    let inserted = sqlite_export::insert_balance_changes(&mut conn, &rows)?;
+    let ids: BTreeSet<&str> = txs
+        .iter()
+        .flat_map(|(_, changes)| changes.iter().map(|change| change.asset.as_str()))
+        .collect();
+    sqlite_export::export_assets(&mut conn, client, &ids)?;
+    eprintln!(
+        "exported {inserted} new balance change(s) to {} ({} already present)",
+        path.display(),
+        rows.len() - inserted
+    );
+    Ok(())
+}
+
+/// Gas fee, withdraw and deposit changes of a transaction, in event order,
+/// with store owners resolved. Only user transactions pay gas; other
+/// variants usually have no changes at all.
+pub(crate) fn transaction_balance_changes(
+    client: &AptosClient,
+    tx: &Transaction,
+) -> Vec<BalanceChange> {
+    let mut store_info = extract_transfer_store_info_from_tx(&tx.json);
+    build_balance_change_events(&tx.json, &mut store_info, client, tx.version())
+}
+
+/// Variant of a node transaction, from its `type` field.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub(crate) enum TxKind {
+    User,
+    Genesis,
+    BlockMetadata,
+    StateCheckpoint,
+    BlockEpilogue,
+    Validator,
+    Pending,
+    Unknown,
+}
+
+impl TxKind {
+    fn from_type(tx_type: &str) -> Self {
+        match tx_type {
+            "user_transaction" => Self::User,
+            "genesis_transaction" => Self::Genesis,
+            "block_metadata_transaction" => Self::BlockMetadata,
+            "state_checkpoint_transaction" => Self::StateCheckpoint,
+            "block_epilogue_transaction" => Self::BlockEpilogue,
+            "validator_transaction" => Self::Validator,
+            "pending_transaction" => Self::Pending,
+            _ => Self::Unknown,
+        }
+    }
+
+    pub(crate) fn name(self) -> &'static str {
+        match self {
+            Self::User => "user",
+            Self::Genesis => "genesis",
+            Self::BlockMetadata => "block metadata",
+            Self::StateCheckpoint => "state checkpoint",
+            Self::BlockEpilogue => "block epilogue",
+            Self::Validator => "validator",
+            Self::Pending => "pending",
+            Self::Unknown => "unknown",
+        }
+    }
+}
+
+/// A node transaction and its variant. Only user transactions carry a
+/// sender, payload, signature and gas fee; every variant may carry events
+/// and a write set.
+#[derive(Debug, Clone)]
+pub(crate) struct Transaction {
+    pub(crate) kind: TxKind,
+    pub(crate) json: Value,
+}
+
+impl Transaction {
+    pub(crate) fn new(json: Value) -> Self {
+        let kind = TxKind::from_type(json.get("type").and_then(Value::as_str).unwrap_or_default());
+        Self { kind, json }
+    }
+
+    pub(crate) fn version(&self) -> u64 {
+        parse_u64(self.json.get("version").unwrap_or(&Value::Null)).unwrap_or(0)
+    }
+
+    /// The transaction JSON, or an error naming the variant for commands
+    /// that depend on a payload or signature.
+    pub(crate) fn require_user(&self, reason: &str) -> Result<&Value> {
+        if self.kind == TxKind::User {
+            return Ok(&self.json);
+        }
+        Err(anyhow!(
+            "version {} is a {} transaction; {reason}",
+            self.version(),
+            self.kind.name()
+        ))
+    }
+
+    /// Tells stderr why a command that read a non-user transaction found
+    /// nothing, so an empty result isn't mistaken for a lookup failure.
+    pub(crate) fn note_if_empty(&self, is_empty: bool, what: &str) {
+        if is_empty && self.kind != TxKind::User {
+            eprintln!(
+                "note: version {} is a {} transaction with no {what}",
+                self.version(),
+                self.kind.name()
+            );
+        }
+    }
+}
+
+/// Reads a transaction from stdin if one is piped in, otherwise fetches it.
+pub(crate) fn get_transaction(
+    client: &AptosClient,
+    version_or_hash: Option<&str>,
+) -> Result<Transaction> {
+    // Inside `repl`, stdin carries the session's commands, not a transaction.
+    if !repl::is_active() && !io::stdin().is_terminal() {
         let mut input = String::new();
         io::stdin()
             .read_to_string(&mut input)
@@ -471,16 +1054,25 @@ fn get_transaction(client: &AptosClient, version_or_hash: Option<&str>) -> Resul
         if !input.trim().is_empty() {
             let tx: Value =
                 serde_json::from_str(&input).context("failed to parse transaction JSON")?;
-            return Ok(tx);
+            return Ok(Transaction::new(tx));
         }
     }
 
     let tx_ref = version_or_hash.ok_or_else(|| anyhow!("no transaction provided"))?;
-    if tx_ref.parse::<u64>().is_ok() {
-        return client.get_json(&format!("/transactions/by_version/{tx_ref}"));
-    }
+    fetch_transaction(client, tx_ref)
+}
 
-    client.get_json(&format!("/transactions/by_hash/{tx_ref}"))
+/// Fetches a transaction by version (u64) or hash (0x...).
+pub(crate) fn fetch_transaction(
+    client: &AptosClient,
+    version_or_hash: &str,
+) -> Result<Transaction> {
+    let path = if version_or_hash.parse::<u64>().is_ok() {
+        format!("/transactions/by_version/{version_or_hash}")
+    } else {
+        format!("/transactions/by_hash/{version_or_hash}")
+    };
+    client.get_json(&path).map(Transaction::new)
 }
 
 fn build_balance_change_events(
@@ -507,6 +1099,7 @@ fn build_balance_change_events(
             fungible_store: apt_store,
             asset: "0xa".to_owned(),
             amount: gas_fee.to_string(),
+            account_label: None,
         });
     }
 
@@ -542,13 +1135,16 @@ fn build_balance_change_events(
             fungible_store: store,
             asset: metadata.asset,
             amount,
+            account_label: None,
         });
     }
 
     events
 }
 
-fn extract_transfer_store_info_from_tx(tx: &Value) -> HashMap<String, TransferStoreMetadata> {
+pub(crate) fn extract_transfer_store_info_from_tx(
+    tx: &Value,
+) -> HashMap<String, TransferStoreMetadata> {
     let mut owners: HashMap<String, String> = HashMap::new();
     let mut info: HashMap<String, TransferStoreMetadata> = HashMap::new();
 
@@ -669,7 +1265,7 @@ fn find_sender_apt_store(tx: &Value, sender: &str) -> String {
     String::new()
 }
 
-fn query_transfer_store_info(
+pub(crate) fn query_transfer_store_info(
     client: &AptosClient,
     store: &str,
     version: u64,
@@ -684,11 +1280,7 @@ fn query_transfer_store_info(
         query = format!("?ledger_version={version}");
     }
 
-    let object_type = urlencoding::encode(OBJECT_CORE_TYPE);
-    let object_path = format!("/accounts/{store}/resource/{object_type}{query}");
-    if let Ok(value) = client.get_json(&object_path) {
-        metadata.owner = get_nested_string(&value, &["data", "owner"]);
-    }
+    metadata.owner = query_object_owner(client, store, version).unwrap_or_default();
 
     let store_type = urlencoding::encode(FUNGIBLE_STORE_TYPE);
     let store_path = format!("/accounts/{store}/resource/{store_type}{query}");
@@ -699,7 +1291,28 @@ fn query_transfer_store_info(
     metadata
 }
 
-fn aggregate_events(events: &[BalanceChange]) -> Vec<AggregatedBalanceChange> {
+/// Owner recorded in the `ObjectCore` at `address`, or `None` when
+/// `address` is not an object (or the lookup fails).
+pub(crate) fn query_object_owner(
+    client: &AptosClient,
+    address: &str,
+    version: u64,
+) -> Option<String> {
+    let query = if version > 0 {
+        format!("?ledger_version={version}")
+    } else {
+        String::new()
+    };
+    let object_type = urlencoding::encode(OBJECT_CORE_TYPE);
+    let value = client
+        .get_json(&format!(
+            "/accounts/{address}/resource/{object_type}{query}"
+        ))
+        .ok()?;
+    Some(get_nested_string(&value, &["data", "owner"])).filter(|owner| !owner.is_empty())
+}
+
+pub(crate) fn aggregate_events(events: &[BalanceChange]) -> Vec<AggregatedBalanceChange> {
     let mut totals: HashMap<(String, String), BigInt> = HashMap::new();
     let mut order: Vec<(String, String)> = Vec::new();
 
@@ -729,6 +1342,7 @@ fn aggregate_events(events: &[BalanceChange]) -> Vec<AggregatedBalanceChange> {
                 .unwrap_or_else(|| "0".to_owned()),
             account,
             asset,
+            account_label: None,
         })
         .collect()
 }
@@ -738,6 +1352,185 @@ fn parse_bigint(value: &Value) -> BigInt {
     BigInt::from_str(&string_value).unwrap_or_else(|_| BigInt::from(0))
 }
 
-fn first_non_empty_string(values: &[String]) -> Option<String> {
-    values.iter().find(|value| !value.is_empty()).cloned()
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::commands::common::replay_client;
+
+    fn variant_fixtures() -> [Value; 4] {
+        [
+            json!({
+                "type": "user_transaction",
+                "version": "9",
+                "sender": "0x1234",
+                "gas_used": "3",
+                "gas_unit_price": "100",
+                "events": [],
+                "changes": [],
+            }),
+            json!({
+                "type": "genesis_transaction",
+                "version": "0",
+                "events": [{"type": "0x1::reconfiguration::NewEpochEvent", "data": {"epoch": "1"}}],
+                "changes": [{
+                    "type": "write_resource",
+                    "address": "0x1",
+                    "data": {"type": "0x1::chain_id::ChainId", "data": {"id": 1}},
+                }],
+            }),
+            json!({
+                "type": "block_metadata_transaction",
+                "version": "10",
+                "gas_used": "0",
+                "events": [{"type": "0x1::block::NewBlockEvent", "data": {"round": "5"}}],
+                "changes": [],
+            }),
+            json!({
+                "type": "state_checkpoint_transaction",
+                "version": "11",
+                "gas_used": "0",
+                "changes": [],
+            }),
+        ]
+    }
+
+    #[test]
+    fn fetches_recorded_non_user_transactions() {
+        let client = replay_client();
+        let genesis = fetch_transaction(&client, "0").unwrap();
+        assert_eq!((genesis.kind, genesis.version()), (TxKind::Genesis, 0));
+        let block_metadata = fetch_transaction(&client, "10").unwrap();
+        assert_eq!(block_metadata.kind, TxKind::BlockMetadata);
+        assert!(transaction_balance_changes(&client, &block_metadata).is_empty());
+
+        let err = fetch_transaction(&client, "11").unwrap_err();
+        assert!(
+            format!("{err:#}").contains("no recorded fixture for GET /transactions/by_version/11")
+        );
+    }
+
+    #[test]
+    fn handles_every_transaction_variant() {
+        let client = AptosClient::new("http://127.0.0.1:9/v1").unwrap();
+        let [user, genesis, block_metadata, checkpoint] = variant_fixtures().map(Transaction::new);
+        assert_eq!(
+            [
+                user.kind,
+                genesis.kind,
+                block_metadata.kind,
+                checkpoint.kind
+            ],
+            [
+                TxKind::User,
+                TxKind::Genesis,
+                TxKind::BlockMetadata,
+                TxKind::StateCheckpoint
+            ]
+        );
+
+        let changes = transaction_balance_changes(&client, &user);
+        assert_eq!(changes.len(), 1);
+        assert_eq!(
+            (changes[0].event_type.as_str(), changes[0].amount.as_str()),
+            ("gas_fee", "300")
+        );
+        for tx in [&genesis, &block_metadata, &checkpoint] {
+            assert!(transaction_balance_changes(&client, tx).is_empty());
+        }
+
+        assert!(user.require_user("needs a payload").is_ok());
+        let err = block_metadata.require_user("needs a payload").unwrap_err();
+        assert_eq!(
+            err.to_string(),
+            "version 10 is a block metadata transaction; needs a payload"
+        );
+        assert!(genesis
+            .require_user("x")
+            .unwrap_err()
+            .to_string()
+            .contains("genesis"));
+        assert!(checkpoint
+            .require_user("x")
+            .unwrap_err()
+            .to_string()
+            .contains("state checkpoint"));
+    }
+
+    #[test]
+    fn balance_change_outputs_match_schema() {
+        let events = vec![BalanceChange {
+            event_type: "withdraw".to_owned(),
+            account: "0x1".to_owned(),
+            fungible_store: "0x2".to_owned(),
+            asset: "0xa".to_owned(),
+            amount: "10".to_owned(),
+            account_label: None,
+        }];
+        let aggregated = aggregate_events(&events);
+
+        let schema = balance_change_output_schema();
+        let validate = crate::commands::schema::validate;
+        validate(&schema, &serde_json::to_value(&events).unwrap()).unwrap();
+        validate(&schema, &serde_json::to_value(&aggregated).unwrap()).unwrap();
+    }
+
+    #[test]
+    fn block_exports_join_resolved_asset_metadata() {
+        // Nothing listens on port 9; APT resolves without the node.
+        let client = AptosClient::new("http://127.0.0.1:9/v1").unwrap();
+        let change = |asset: &str| BalanceChange {
+            event_type: "deposit".to_owned(),
+            account: "0x2".to_owned(),
+            fungible_store: "0xabc".to_owned(),
+            asset: asset.to_owned(),
+            amount: "100".to_owned(),
+            account_label: None,
+        };
+        let first = [
+            change(crate::commands::assets::APTOS_COIN_TYPE),
+            change("0xb0"),
+        ];
+        let second = [change("0xb0")];
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("changes.db");
+        export_balance_changes(&client, &path, &[(7, &first), (8, &second)]).unwrap();
+        export_balance_changes(&client, &path, &[(8, &second)]).unwrap();
+
+        let conn = rusqlite::Connection::open(&path).unwrap();
+        let count = |sql: &str| -> i64 { conn.query_row(sql, [], |row| row.get(0)).unwrap() };
+        assert_eq!(count("SELECT COUNT(*) FROM balance_changes"), 3);
+        assert_eq!(
+            count(
+                "SELECT COUNT(*) FROM balance_changes b JOIN assets a ON a.asset = b.asset
+                 WHERE a.symbol = 'APT' AND a.decimals = 8"
+            ),
+            1
+        );
+        assert_eq!(
+            count("SELECT COUNT(*) FROM assets WHERE asset = '0xb0' AND decimals IS NULL"),
+            1,
+            "unknown decimals stay unset"
+        );
+    }
+
+    #[test]
+    fn block_changes_match_schema() {
+        let block = vec![BlockTxChanges {
+            version: 7,
+            changes: vec![BalanceChange {
+                event_type: "gas_fee".to_owned(),
+                account: "0x2".to_owned(),
+                fungible_store: "0xabc".to_owned(),
+                asset: "0xa".to_owned(),
+                amount: "100".to_owned(),
+                account_label: None,
+            }],
+        }];
+        let validate = crate::commands::schema::validate;
+        validate(
+            &balance_change_output_schema(),
+            &serde_json::to_value(&block).unwrap(),
+        )
+        .unwrap();
+    }
 }
@@ -0,0 +1,265 @@
+use anyhow::{anyhow, Result};
+use aptly_aptos::AptosClient;
+use clap::Args;
+use serde::Serialize;
+use serde_json::Value;
+
+use crate::commands::abort::{self, ResolvedAbort};
+use crate::commands::account::format_amount;
+use crate::commands::common::parse_u64;
+use crate::commands::node::GasEstimate;
+use crate::commands::schema::{
+    boolean_schema, integer_schema, nullable, object_schema, string_schema, OutputSchema,
+};
+use crate::commands::tx::{
+    normalize_simulation_payload, read_json_from_stdin, simulate_payload, SimulationParams,
+    SimulationSummary,
+};
+
+const APT_DECIMALS: u8 = 8;
+
+#[derive(Args)]
+pub(crate) struct TxCostArgs {
+    /// Sender account address used to resolve sequence number.
+    #[arg(value_name = "SENDER")]
+    pub(crate) sender: String,
+    /// Print a single line of text instead of JSON.
+    #[arg(long, default_value_t = false)]
+    pub(crate) pretty: bool,
+}
+
+/// Estimated fee of one simulated payload. Cost fields are null when the
+/// simulation failed.
+#[derive(Debug, Clone, Serialize)]
+struct TxCost {
+    sender: String,
+    success: bool,
+    vm_status: String,
+    abort: Option<ResolvedAbort>,
+    gas_used: Option<u64>,
+    gas_unit_price: Option<u64>,
+    fee_octas: Option<String>,
+    fee_apt: Option<String>,
+    prioritized_gas_unit_price: Option<u64>,
+    max_fee_octas: Option<String>,
+    max_fee_apt: Option<String>,
+}
+
+impl OutputSchema for TxCost {
+    fn output_schema() -> Value {
+        object_schema(
+            "Estimated fee of a payload from a gas-estimating simulation; `--pretty` prints one line instead",
+            &[
+                ("sender", string_schema("Simulated sender")),
+                (
+                    "success",
+                    boolean_schema("Whether the simulated transaction succeeded"),
+                ),
+                ("vm_status", string_schema("VM status reported by the node")),
+                ("abort", nullable(abort::resolved_abort_schema())),
+                ("gas_used", nullable(integer_schema("Estimated gas units"))),
+                (
+                    "gas_unit_price",
+                    nullable(integer_schema("Octas per gas unit used by the simulation")),
+                ),
+                (
+                    "fee_octas",
+                    nullable(string_schema("gas_used * gas_unit_price (u128 as string)")),
+                ),
+                ("fee_apt", nullable(string_schema("fee_octas in APT"))),
+                (
+                    "prioritized_gas_unit_price",
+                    nullable(integer_schema(
+                        "Node high-priority estimate (octas per gas unit)",
+                    )),
+                ),
+                (
+                    "max_fee_octas",
+                    nullable(string_schema(
+                        "gas_used at the prioritized price; an upper bound (u128 as string)",
+                    )),
+                ),
+                ("max_fee_apt", nullable(string_schema("max_fee_octas in APT"))),
+            ],
+        )
+    }
+}
+
+pub(crate) fn cost_output_schema() -> Value {
+    TxCost::output_schema()
+}
+
+pub(crate) fn run_tx_cost(client: &AptosClient, args: &TxCostArgs) -> Result<()> {
+    let stdin_value = read_json_from_stdin("failed to parse payload JSON from stdin")?;
+    let payload = normalize_simulation_payload(&stdin_value)?;
+    let params = SimulationParams::fetch(client)?.with_gas_estimation();
+    let simulated = simulate_payload(client, &params, &args.sender, &payload)?;
+    let cost = tx_cost(
+        &args.sender,
+        SimulationSummary::new(client, &simulated),
+        &simulated,
+        params.gas_estimate,
+    );
+
+    if args.pretty {
+        println!("{}", render_line(&cost));
+    } else {
+        crate::print_serialized(&cost)?;
+    }
+    if !cost.success {
+        return Err(anyhow!("simulation failed: {}", cost.vm_status));
+    }
+    Ok(())
+}
+
+fn tx_cost(
+    sender: &str,
+    summary: SimulationSummary,
+    simulated: &Value,
+    estimate: GasEstimate,
+) -> TxCost {
+    let mut cost = TxCost {
+        sender: sender.to_owned(),
+        success: summary.success,
+        vm_status: summary.vm_status,
+        abort: summary.abort,
+        gas_used: None,
+        gas_unit_price: None,
+        fee_octas: None,
+        fee_apt: None,
+        prioritized_gas_unit_price: None,
+        max_fee_octas: None,
+        max_fee_apt: None,
+    };
+    if !cost.success {
+        return cost;
+    }
+
+    let field = |key: &str| parse_u64(simulated.get(key).unwrap_or(&Value::Null));
+    let Some(gas_used) = field("gas_used") else {
+        return cost;
+    };
+    // The node reports the price it estimated; fall back to its quote.
+    let gas_unit_price = field("gas_unit_price").or(estimate.normal);
+    let fee = |price: u64| (u128::from(gas_used) * u128::from(price)).to_string();
+
+    cost.gas_used = Some(gas_used);
+    cost.gas_unit_price = gas_unit_price;
+    cost.fee_octas = gas_unit_price.map(fee);
+    cost.fee_apt = cost
+        .fee_octas
+        .as_deref()
+        .map(|octas| format_amount(octas, APT_DECIMALS));
+    cost.prioritized_gas_unit_price = estimate.prioritized;
+    cost.max_fee_octas = estimate.prioritized.map(fee);
+    cost.max_fee_apt = cost
+        .max_fee_octas
+        .as_deref()
+        .map(|octas| format_amount(octas, APT_DECIMALS));
+    cost
+}
+
+/// One line for chat replies, e.g. `~0.0005 APT (500 gas at 100 octas/gas;
+/// up to 0.00075 APT at priority price 150)`.
+fn render_line(cost: &TxCost) -> String {
+    if !cost.success {
+        return match &cost.abort {
+            Some(abort) => format!("simulation failed: {abort}"),
+            None => format!("simulation failed: {}", cost.vm_status),
+        };
+    }
+    let (Some(gas_used), Some(price), Some(fee_apt)) =
+        (cost.gas_used, cost.gas_unit_price, cost.fee_apt.as_deref())
+    else {
+        return "simulation succeeded but reported no gas usage".to_owned();
+    };
+    let mut line = format!("~{fee_apt} APT ({gas_used} gas at {price} octas/gas");
+    if let (Some(max_fee_apt), Some(prioritized)) =
+        (cost.max_fee_apt.as_deref(), cost.prioritized_gas_unit_price)
+    {
+        line.push_str(&format!(
+            "; up to {max_fee_apt} APT at priority price {prioritized}"
+        ));
+    }
+    line.push(')');
+    line
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::commands::schema::validate;
+    use serde_json::json;
+
+    fn summary(success: bool, vm_status: &str) -> SimulationSummary {
+        SimulationSummary {
+            success,
+            vm_status: vm_status.to_owned(),
+            gas_used: String::new(),
+            abort: None,
+        }
+    }
+
+    fn estimate() -> GasEstimate {
+        GasEstimate {
+            deprioritized: Some(100),
+            normal: Some(100),
+            prioritized: Some(150),
+        }
+    }
+
+    #[test]
+    fn prices_successful_simulations() {
+        let simulated = json!({"success": true, "gas_used": "523", "gas_unit_price": "110"});
+        let cost = tx_cost(
+            "0xa11ce",
+            summary(true, "Executed successfully"),
+            &simulated,
+            estimate(),
+        );
+        assert_eq!(cost.gas_used, Some(523));
+        assert_eq!(cost.gas_unit_price, Some(110));
+        assert_eq!(cost.fee_octas.as_deref(), Some("57530"));
+        assert_eq!(cost.fee_apt.as_deref(), Some("0.0005753"));
+        assert_eq!(cost.max_fee_octas.as_deref(), Some("78450"));
+        assert_eq!(cost.max_fee_apt.as_deref(), Some("0.0007845"));
+        assert_eq!(
+            render_line(&cost),
+            "~0.0005753 APT (523 gas at 110 octas/gas; up to 0.0007845 APT at priority price 150)"
+        );
+        validate(&cost_output_schema(), &serde_json::to_value(&cost).unwrap()).unwrap();
+
+        // Without a price in the response, the node quote is used.
+        let cost = tx_cost(
+            "0xa11ce",
+            summary(true, "Executed successfully"),
+            &json!({"gas_used": "10"}),
+            GasEstimate {
+                prioritized: None,
+                ..estimate()
+            },
+        );
+        assert_eq!(cost.fee_octas.as_deref(), Some("1000"));
+        assert_eq!(cost.max_fee_octas, None);
+        assert_eq!(render_line(&cost), "~0.00001 APT (10 gas at 100 octas/gas)");
+    }
+
+    #[test]
+    fn reports_failures_without_a_fee() {
+        let vm_status = "Move abort in 0x1::coin: EINSUFFICIENT_BALANCE(0x10006)";
+        let cost = tx_cost(
+            "0xa11ce",
+            summary(false, vm_status),
+            &json!({"success": false, "gas_used": "12", "gas_unit_price": "100"}),
+            estimate(),
+        );
+        assert_eq!(cost.gas_used, None);
+        assert_eq!(cost.fee_octas, None);
+        assert_eq!(cost.max_fee_apt, None);
+        assert_eq!(
+            render_line(&cost),
+            format!("simulation failed: {vm_status}")
+        );
+        validate(&cost_output_schema(), &serde_json::to_value(&cost).unwrap()).unwrap();
+    }
+}
@@ -0,0 +1,222 @@
+use serde_json::Value;
+use std::collections::BTreeMap;
+use std::fmt::Write;
+
+use crate::commands::abort::ResolvedAbort;
+use crate::commands::common::{parse_u64, value_to_string};
+
+/// Renders a node transaction as a text page: an overview, then the decoded
+/// payload, events grouped by type and one line per write-set change.
+pub(crate) fn render_tx(tx: &Value, abort: Option<&ResolvedAbort>) -> String {
+    let mut out = String::new();
+    render_overview(&mut out, tx, abort);
+    if let Some(payload) = tx.get("payload") {
+        render_payload(&mut out, payload);
+    }
+    if let Some(events) = tx.get("events").and_then(Value::as_array) {
+        render_events(&mut out, events);
+    }
+    if let Some(changes) = tx.get("changes").and_then(Value::as_array) {
+        render_changes(&mut out, changes);
+    }
+    out
+}
+
+fn field(tx: &Value, key: &str) -> String {
+    tx.get(key).map(value_to_string).unwrap_or_default()
+}
+
+fn render_overview(out: &mut String, tx: &Value, abort: Option<&ResolvedAbort>) {
+    let _ = writeln!(
+        out,
+        "Transaction {}  {}",
+        tx.get("version")
+            .map(value_to_string)
+            .unwrap_or_else(|| "(pending)".to_owned()),
+        field(tx, "hash")
+    );
+    let mut row = |label: &str, value: String| {
+        if !value.is_empty() {
+            let _ = writeln!(out, "  {label:<11}{value}");
+        }
+    };
+    row("type:", field(tx, "type"));
+    row(
+        "status:",
+        match tx.get("success").and_then(Value::as_bool) {
+            Some(true) => "success".to_owned(),
+            Some(false) => format!("FAILED  {}", field(tx, "vm_status")),
+            None => field(tx, "vm_status"),
+        },
+    );
+    if let Some(abort) = abort {
+        row("abort:", abort.to_string());
+    }
+    let sender = field(tx, "sender");
+    if !sender.is_empty() {
+        row(
+            "sender:",
+            format!("{sender}  seq {}", field(tx, "sequence_number")),
+        );
+    }
+    row("timestamp:", field(tx, "timestamp"));
+    if let Some(gas_used) = tx.get("gas_used").and_then(parse_u64) {
+        let price = tx.get("gas_unit_price").and_then(parse_u64);
+        row(
+            "gas:",
+            match price {
+                Some(price) => format!(
+                    "{gas_used} units @ {price} octas = {} octas",
+                    u128::from(gas_used) * u128::from(price)
+                ),
+                None => format!("{gas_used} units"),
+            },
+        );
+    }
+}
+
+fn render_payload(out: &mut String, payload: &Value) {
+    let _ = writeln!(out, "\nPayload ({})", field(payload, "type"));
+    let function = field(payload, "function");
+    if !function.is_empty() {
+        let _ = writeln!(out, "  function:  {function}");
+    }
+    if let Some(type_args) = payload
+        .get("type_arguments")
+        .and_then(Value::as_array)
+        .filter(|args| !args.is_empty())
+    {
+        let type_args: Vec<String> = type_args.iter().map(value_to_string).collect();
+        let _ = writeln!(out, "  type args: {}", type_args.join(", "));
+    }
+    if let Some(args) = payload.get("arguments").and_then(Value::as_array) {
+        let _ = writeln!(out, "  args:");
+        for (index, arg) in args.iter().enumerate() {
+            let _ = writeln!(out, "    [{index}] {}", compact(arg));
+        }
+    }
+}
+
+fn render_events(out: &mut String, events: &[Value]) {
+    let _ = writeln!(out, "\nEvents ({})", events.len());
+    let mut counts: BTreeMap<String, usize> = BTreeMap::new();
+    for event in events {
+        *counts.entry(field(event, "type")).or_default() += 1;
+    }
+    for (event_type, count) in counts {
+        let _ = writeln!(out, "  {count:>4}  {event_type}");
+    }
+}
+
+fn render_changes(out: &mut String, changes: &[Value]) {
+    let _ = writeln!(out, "\nChanges ({})", changes.len());
+    for change in changes {
+        let kind = field(change, "type");
+        let target = match kind.as_str() {
+            "write_resource" => format!(
+                "{}  {}",
+                field(change, "address"),
+                change
+                    .pointer("/data/type")
+                    .map(value_to_string)
+                    .unwrap_or_default()
+            ),
+            "delete_resource" => format!(
+                "{}  {}",
+                field(change, "address"),
+                field(change, "resource")
+            ),
+            "write_module" => format!(
+                "{}  {}",
+                field(change, "address"),
+                change
+                    .pointer("/data/abi/name")
+                    .map(value_to_string)
+                    .unwrap_or_default()
+            ),
+            "delete_module" => format!("{}  {}", field(change, "address"), field(change, "module")),
+            "write_table_item" | "delete_table_item" => format!(
+                "handle {}  key {}",
+                field(change, "handle"),
+                field(change, "key")
+            ),
+            _ => field(change, "state_key_hash"),
+        };
+        let _ = writeln!(out, "  {kind:<18}{target}");
+    }
+}
+
+/// Single-line JSON for arguments; strings stay unquoted.
+fn compact(value: &Value) -> String {
+    match value {
+        Value::String(value) => value.clone(),
+        other => other.to_string(),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use serde_json::json;
+
+    #[test]
+    fn renders_explorer_style_page() {
+        let tx = json!({
+            "type": "user_transaction",
+            "version": "42",
+            "hash": "0xabc",
+            "success": false,
+            "vm_status": "Move abort in 0x1::coin: EINSUFFICIENT_BALANCE(0x10006)",
+            "sender": "0x1234",
+            "sequence_number": "7",
+            "timestamp": "1700000000000000",
+            "gas_used": "12",
+            "gas_unit_price": "100",
+            "payload": {
+                "type": "entry_function_payload",
+                "function": "0x1::aptos_account::transfer_coins",
+                "type_arguments": ["0x1::aptos_coin::AptosCoin"],
+                "arguments": ["0x5678", "1000", [1, 2]]
+            },
+            "events": [
+                {"type": "0x1::transaction_fee::FeeStatement", "data": {}},
+                {"type": "0x1::coin::CoinWithdraw", "data": {}},
+                {"type": "0x1::coin::CoinWithdraw", "data": {}}
+            ],
+            "changes": [
+                {
+                    "type": "write_resource",
+                    "address": "0x1234",
+                    "data": {"type": "0x1::account::Account", "data": {}}
+                },
+                {"type": "write_table_item", "handle": "0xh", "key": "0xk", "value": "0x"}
+            ]
+        });
+        let page = render_tx(&tx, None);
+        let expected = "\
+Transaction 42  0xabc
+  type:      user_transaction
+  status:    FAILED  Move abort in 0x1::coin: EINSUFFICIENT_BALANCE(0x10006)
+  sender:    0x1234  seq 7
+  timestamp: 1700000000000000
+  gas:       12 units @ 100 octas = 1200 octas
+
+Payload (entry_function_payload)
+  function:  0x1::aptos_account::transfer_coins
+  type args: 0x1::aptos_coin::AptosCoin
+  args:
+    [0] 0x5678
+    [1] 1000
+    [2] [1,2]
+
+Events (3)
+     2  0x1::coin::CoinWithdraw
+     1  0x1::transaction_fee::FeeStatement
+
+Changes (2)
+  write_resource    0x1234  0x1::account::Account
+  write_table_item  handle 0xh  key 0xk
+";
+        assert_eq!(page, expected);
+    }
+}
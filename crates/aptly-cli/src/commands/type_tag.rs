@@ -0,0 +1,599 @@
+use anyhow::{anyhow, Result};
+use clap::{Args, Subcommand};
+use serde::Serialize;
+use serde_json::{json, Value};
+use std::fmt;
+
+use crate::commands::bcs::{read_uleb128, take};
+use crate::commands::common::normalize_address;
+use crate::commands::schema::{object_schema, string_schema, with_optional_properties};
+
+#[derive(Args)]
+#[command(
+    after_help = "Examples:\n  aptly type parse '0x1::coin::CoinStore<0x1::aptos_coin::AptosCoin>'\n  aptly type parse 'vector<0x1::string::String>' --bcs"
+)]
+pub(crate) struct TypeCommand {
+    #[command(subcommand)]
+    pub(crate) command: TypeSubcommand,
+}
+
+#[derive(Subcommand)]
+pub(crate) enum TypeSubcommand {
+    #[command(about = "Validate a type tag and print its canonical form and structure")]
+    Parse(TypeParseArgs),
+}
+
+#[derive(Args)]
+pub(crate) struct TypeParseArgs {
+    /// Move type tag, e.g. `0x1::coin::CoinStore<0x1::aptos_coin::AptosCoin>`.
+    #[arg(value_name = "TYPE_TAG")]
+    pub(crate) type_tag: String,
+    /// Also print the BCS encoding of the tag as hex.
+    #[arg(long, default_value_t = false)]
+    pub(crate) bcs: bool,
+}
+
+/// A Move type tag as accepted by the node: primitives, vectors and fully
+/// qualified structs. Generic parameters and references are not type tags.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize)]
+#[serde(tag = "kind", rename_all = "snake_case")]
+pub(crate) enum TypeTag {
+    Bool,
+    U8,
+    U16,
+    U32,
+    U64,
+    U128,
+    U256,
+    Address,
+    Signer,
+    Vector { element: Box<TypeTag> },
+    Struct(StructTag),
+}
+
+#[derive(Debug, Clone, PartialEq, Eq, Serialize)]
+pub(crate) struct StructTag {
+    /// Long-form address.
+    pub(crate) address: String,
+    pub(crate) module: String,
+    pub(crate) name: String,
+    pub(crate) type_params: Vec<TypeTag>,
+}
+
+/// Where and why a type tag failed to parse.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub(crate) struct TypeTagError {
+    input: String,
+    /// Character offset of the problem.
+    pub(crate) offset: usize,
+    pub(crate) message: String,
+}
+
+impl fmt::Display for TypeTagError {
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        write!(
+            f,
+            "{} at offset {}\n  {}\n  {}^",
+            self.message,
+            self.offset,
+            self.input,
+            " ".repeat(self.offset)
+        )
+    }
+}
+
+impl std::error::Error for TypeTagError {}
+
+#[derive(Debug, Serialize)]
+struct ParsedTypeTag {
+    canonical: String,
+    tag: TypeTag,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    bcs: Option<String>,
+}
+
+pub(crate) fn parse_output_schema() -> Value {
+    let type_tag = json!({ "$ref": "#/$defs/TypeTag" });
+    let mut schema = with_optional_properties(
+        object_schema(
+            "A valid type tag",
+            &[
+                (
+                    "canonical",
+                    string_schema("Tag with long-form addresses and `, ` between type parameters"),
+                ),
+                ("tag", type_tag.clone()),
+            ],
+        ),
+        &[(
+            "bcs",
+            string_schema("BCS encoding of the tag as `0x` hex (`--bcs`)"),
+        )],
+    );
+    schema["$defs"] = json!({
+        "TypeTag": {
+            "description": "`kind` is a primitive name, `vector` (with `element`) or `struct` (with `address`, `module`, `name` and `type_params`)",
+            "type": "object",
+            "required": ["kind"],
+            "properties": {
+                "kind": {
+                    "type": "string",
+                    "enum": ["bool", "u8", "u16", "u32", "u64", "u128", "u256", "address", "signer", "vector", "struct"],
+                },
+                "element": type_tag,
+                "address": string_schema("Long-form address"),
+                "module": string_schema("Module name"),
+                "name": string_schema("Struct name"),
+                "type_params": { "type": "array", "items": type_tag },
+            },
+        },
+    });
+    schema
+}
+
+pub(crate) fn run_type(command: TypeCommand) -> Result<()> {
+    match command.command {
+        TypeSubcommand::Parse(args) => {
+            let tag = TypeTag::parse(&args.type_tag)?;
+            let output = ParsedTypeTag {
+                canonical: tag.to_string(),
+                bcs: args.bcs.then(|| format!("0x{}", hex::encode(tag.to_bcs()))),
+                tag,
+            };
+            crate::print_serialized(&output)
+        }
+    }
+}
+
+/// Parses a type tag given to `flag`, so a typo fails before any request
+/// with the offending offset.
+pub(crate) fn check_type_tag_arg(flag: &str, value: &str) -> Result<TypeTag> {
+    TypeTag::parse(value).map_err(|err| anyhow::anyhow!("invalid {flag} `{value}`: {err}"))
+}
+
+impl TypeTag {
+    pub(crate) fn parse(input: &str) -> Result<Self, TypeTagError> {
+        let mut parser = Parser {
+            input,
+            chars: input.chars().collect(),
+            pos: 0,
+        };
+        let tag = parser.type_tag()?;
+        parser.skip_whitespace();
+        if parser.pos < parser.chars.len() {
+            return Err(parser.error("unexpected trailing input"));
+        }
+        Ok(tag)
+    }
+
+    /// BCS encoding, with the variant numbering of `move_core_types::TypeTag`.
+    pub(crate) fn to_bcs(&self) -> Vec<u8> {
+        let mut out = Vec::new();
+        self.write_bcs(&mut out);
+        out
+    }
+
+    fn write_bcs(&self, out: &mut Vec<u8>) {
+        match self {
+            Self::Bool => out.push(0),
+            Self::U8 => out.push(1),
+            Self::U64 => out.push(2),
+            Self::U128 => out.push(3),
+            Self::Address => out.push(4),
+            Self::Signer => out.push(5),
+            Self::Vector { element } => {
+                out.push(6);
+                element.write_bcs(out);
+            }
+            Self::Struct(tag) => {
+                out.push(7);
+                let address = tag.address.trim_start_matches("0x");
+                out.extend(hex::decode(address).expect("addresses are normalized hex"));
+                write_bcs_str(out, &tag.module);
+                write_bcs_str(out, &tag.name);
+                write_uleb128(out, tag.type_params.len());
+                for param in &tag.type_params {
+                    param.write_bcs(out);
+                }
+            }
+            Self::U16 => out.push(8),
+            Self::U32 => out.push(9),
+            Self::U256 => out.push(10),
+        }
+    }
+
+    /// Reads one BCS-encoded tag from the front of `input`; `path` names it
+    /// in errors.
+    pub(crate) fn read_bcs(input: &mut &[u8], path: &str) -> Result<Self> {
+        let variant = take(input, 1, path)?[0];
+        Ok(match variant {
+            0 => Self::Bool,
+            1 => Self::U8,
+            2 => Self::U64,
+            3 => Self::U128,
+            4 => Self::Address,
+            5 => Self::Signer,
+            6 => Self::Vector {
+                element: Box::new(Self::read_bcs(input, path)?),
+            },
+            7 => {
+                let address = take(input, 32, path)?;
+                let module = read_bcs_str(input, path)?;
+                let name = read_bcs_str(input, path)?;
+                let count = read_uleb128(input, path)?;
+                let type_params = (0..count)
+                    .map(|index| Self::read_bcs(input, &format!("{path}<{index}>")))
+                    .collect::<Result<Vec<_>>>()?;
+                Self::Struct(StructTag {
+                    address: format!("0x{}", hex::encode(address)),
+                    module,
+                    name,
+                    type_params,
+                })
+            }
+            8 => Self::U16,
+            9 => Self::U32,
+            10 => Self::U256,
+            other => return Err(anyhow!("{path}: unknown type tag variant {other}")),
+        })
+    }
+}
+
+impl StructTag {
+    /// Parses a struct type such as a resource's `type`, e.g.
+    /// `0x1::coin::CoinStore<0x1::aptos_coin::AptosCoin>`.
+    pub(crate) fn parse(input: &str) -> Result<Self, TypeTagError> {
+        match TypeTag::parse(input)? {
+            TypeTag::Struct(tag) => Ok(tag),
+            _ => Err(TypeTagError {
+                input: input.to_owned(),
+                offset: 0,
+                message: "expected a struct type".to_owned(),
+            }),
+        }
+    }
+
+    /// `address::module`, with special addresses (`0x0` to `0xf`) in the
+    /// short form the node prints them in.
+    pub(crate) fn module_id(&self) -> String {
+        format!("{}::{}", short_special_address(&self.address), self.module)
+    }
+}
+
+/// `0x1` for a long-form special address; other addresses are unchanged.
+fn short_special_address(address: &str) -> String {
+    let digits = address.trim_start_matches("0x");
+    match digits.strip_prefix(&"0".repeat(63)) {
+        Some(last) if digits.len() == 64 => format!("0x{last}"),
+        _ => address.to_owned(),
+    }
+}
+
+impl fmt::Display for TypeTag {
+    /// Long-form addresses and `, ` between type parameters.
+    fn fmt(&self, f: &mut fmt::Formatter<'_>) -> fmt::Result {
+        match self {
+            Self::Bool => f.write_str("bool"),
+            Self::U8 => f.write_str("u8"),
+            Self::U16 => f.write_str("u16"),
+            Self::U32 => f.write_str("u32"),
+            Self::U64 => f.write_str("u64"),
+            Self::U128 => f.write_str("u128"),
+            Self::U256 => f.write_str("u256"),
+            Self::Address => f.write_str("address"),
+            Self::Signer => f.write_str("signer"),
+            Self::Vector { element } => write!(f, "vector<{element}>"),
+            Self::Struct(tag) => {
+                write!(f, "{}::{}::{}", tag.address, tag.module, tag.name)?;
+                if !tag.type_params.is_empty() {
+                    let params: Vec<String> =
+                        tag.type_params.iter().map(ToString::to_string).collect();
+                    write!(f, "<{}>", params.join(", "))?;
+                }
+                Ok(())
+            }
+        }
+    }
+}
+
+fn write_bcs_str(out: &mut Vec<u8>, value: &str) {
+    write_uleb128(out, value.len());
+    out.extend_from_slice(value.as_bytes());
+}
+
+pub(crate) fn read_bcs_str(input: &mut &[u8], path: &str) -> Result<String> {
+    let len = read_uleb128(input, path)?;
+    String::from_utf8(take(input, len, path)?)
+        .map_err(|_| anyhow!("{path}: string is not valid UTF-8"))
+}
+
+fn write_uleb128(out: &mut Vec<u8>, mut value: usize) {
+    loop {
+        let byte = (value & 0x7f) as u8;
+        value >>= 7;
+        if value == 0 {
+            out.push(byte);
+            return;
+        }
+        out.push(byte | 0x80);
+    }
+}
+
+struct Parser<'a> {
+    input: &'a str,
+    chars: Vec<char>,
+    pos: usize,
+}
+
+impl Parser<'_> {
+    fn type_tag(&mut self) -> Result<TypeTag, TypeTagError> {
+        self.skip_whitespace();
+        let start = self.pos;
+        if self.peek_str("0x") || self.peek_str("0X") {
+            return self.struct_tag().map(TypeTag::Struct);
+        }
+        let word = self.identifier()?;
+        if self.peek_str("::") {
+            self.pos = start;
+            return Err(self.error("expected a `0x` address"));
+        }
+        let tag = match word.as_str() {
+            "bool" => TypeTag::Bool,
+            "u8" => TypeTag::U8,
+            "u16" => TypeTag::U16,
+            "u32" => TypeTag::U32,
+            "u64" => TypeTag::U64,
+            "u128" => TypeTag::U128,
+            "u256" => TypeTag::U256,
+            "address" => TypeTag::Address,
+            "signer" => TypeTag::Signer,
+            "vector" => {
+                let mut params = self.type_params()?;
+                if params.len() != 1 {
+                    self.pos = start;
+                    return Err(self.error("`vector` takes exactly one type parameter"));
+                }
+                TypeTag::Vector {
+                    element: Box::new(params.remove(0)),
+                }
+            }
+            _ => {
+                self.pos = start;
+                return Err(self.error(&format!(
+                    "unknown type `{word}`; structs need a `0x` address"
+                )));
+            }
+        };
+        Ok(tag)
+    }
+
+    fn struct_tag(&mut self) -> Result<StructTag, TypeTagError> {
+        let start = self.pos;
+        self.pos += 2;
+        let digits_start = self.pos;
+        while self.peek().is_some_and(|ch| ch.is_ascii_hexdigit()) {
+            self.pos += 1;
+        }
+        let digits = self.pos - digits_start;
+        if digits == 0 {
+            return Err(self.error("expected hex digits after `0x`"));
+        }
+        if digits > 64 {
+            self.pos = start;
+            return Err(self.error("address is longer than 32 bytes"));
+        }
+        let address: String = self.chars[start..self.pos].iter().collect();
+        self.expect("::")?;
+        let module = self.identifier()?;
+        self.expect("::")?;
+        let name = self.identifier()?;
+        let type_params = if self.peek_after_whitespace() == Some('<') {
+            self.type_params()?
+        } else {
+            Vec::new()
+        };
+        Ok(StructTag {
+            address: normalize_address(&address.to_ascii_lowercase()),
+            module,
+            name,
+            type_params,
+        })
+    }
+
+    /// `<T, ...>`, requiring at least one parameter.
+    fn type_params(&mut self) -> Result<Vec<TypeTag>, TypeTagError> {
+        self.skip_whitespace();
+        self.expect("<")?;
+        let mut params = vec![self.type_tag()?];
+        loop {
+            self.skip_whitespace();
+            match self.peek() {
+                Some(',') => {
+                    self.pos += 1;
+                    params.push(self.type_tag()?);
+                }
+                Some('>') => {
+                    self.pos += 1;
+                    return Ok(params);
+                }
+                _ => return Err(self.error("expected `,` or `>`")),
+            }
+        }
+    }
+
+    fn identifier(&mut self) -> Result<String, TypeTagError> {
+        let start = self.pos;
+        match self.peek() {
+            Some(ch) if ch.is_ascii_alphabetic() || ch == '_' => self.pos += 1,
+            _ => return Err(self.error("expected an identifier")),
+        }
+        while self
+            .peek()
+            .is_some_and(|ch| ch.is_ascii_alphanumeric() || ch == '_')
+        {
+            self.pos += 1;
+        }
+        Ok(self.chars[start..self.pos].iter().collect())
+    }
+
+    fn expect(&mut self, token: &str) -> Result<(), TypeTagError> {
+        if self.peek_str(token) {
+            self.pos += token.chars().count();
+            Ok(())
+        } else {
+            Err(self.error(&format!("expected `{token}`")))
+        }
+    }
+
+    fn peek(&self) -> Option<char> {
+        self.chars.get(self.pos).copied()
+    }
+
+    fn peek_after_whitespace(&self) -> Option<char> {
+        self.chars[self.pos..]
+            .iter()
+            .copied()
+            .find(|ch| !ch.is_whitespace())
+    }
+
+    fn peek_str(&self, token: &str) -> bool {
+        token
+            .chars()
+            .enumerate()
+            .all(|(index, ch)| self.chars.get(self.pos + index) == Some(&ch))
+    }
+
+    fn skip_whitespace(&mut self) {
+        while self.peek().is_some_and(char::is_whitespace) {
+            self.pos += 1;
+        }
+    }
+
+    fn error(&self, message: &str) -> TypeTagError {
+        TypeTagError {
+            input: self.input.to_owned(),
+            offset: self.pos,
+            message: message.to_owned(),
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::commands::schema::validate;
+
+    const ONE: &str = "0x0000000000000000000000000000000000000000000000000000000000000001";
+
+    #[test]
+    fn splits_struct_types_into_components() {
+        let tag = StructTag::parse("0x1::coin::CoinStore<0x1::aptos_coin::AptosCoin>").unwrap();
+        assert_eq!(
+            (tag.address.as_str(), tag.module.as_str(), tag.name.as_str()),
+            (ONE, "coin", "CoinStore")
+        );
+        assert_eq!(tag.module_id(), "0x1::coin");
+        assert_eq!(tag.type_params.len(), 1);
+
+        let token = StructTag::parse("0x4::token::Token").unwrap();
+        assert_eq!(token.module_id(), "0x4::token");
+        let user = StructTag::parse("0xcafe::vault::Vault").unwrap();
+        assert_eq!(user.module_id(), format!("0x{:0>64}::vault", "cafe"));
+
+        let err = StructTag::parse("vector<u8>").unwrap_err();
+        assert_eq!(err.message, "expected a struct type");
+        assert!(StructTag::parse("0x1::coin").is_err());
+    }
+
+    #[test]
+    fn canonicalizes_addresses_and_spacing() {
+        let tag = TypeTag::parse(" 0x1::coin::CoinStore< 0x1::aptos_coin::AptosCoin >").unwrap();
+        assert_eq!(
+            tag.to_string(),
+            format!("{ONE}::coin::CoinStore<{ONE}::aptos_coin::AptosCoin>")
+        );
+
+        let tag = TypeTag::parse("0x1::pool::Pool<u64,vector<0xAB::m::S>,bool>").unwrap();
+        assert_eq!(
+            tag.to_string(),
+            format!(
+                "{ONE}::pool::Pool<u64, vector<0x{:0>64}::m::S>, bool>",
+                "ab"
+            )
+        );
+        let output = serde_json::to_value(ParsedTypeTag {
+            canonical: tag.to_string(),
+            bcs: None,
+            tag,
+        })
+        .unwrap();
+        assert_eq!(output["tag"]["kind"], "struct");
+        assert_eq!(output["tag"]["type_params"][1]["kind"], "vector");
+        assert_eq!(output["tag"]["type_params"][1]["element"]["name"], "S");
+        validate(&parse_output_schema(), &output).unwrap();
+    }
+
+    #[test]
+    fn reports_error_offsets() {
+        let cases = [
+            (
+                "0x1::coin::CoinStore<0x1::aptos_coin::AptosCoin",
+                47,
+                "expected `,` or `>`",
+            ),
+            ("0x1:coin::CoinStore", 3, "expected `::`"),
+            ("coin::CoinStore", 0, "expected a `0x` address"),
+            ("0x1::coin::", 11, "expected an identifier"),
+            ("0xg::m::S", 2, "expected hex digits after `0x`"),
+            (
+                "vector<u8, u8>",
+                0,
+                "`vector` takes exactly one type parameter",
+            ),
+            ("u64>", 3, "unexpected trailing input"),
+            ("T0", 0, "unknown type `T0`; structs need a `0x` address"),
+            ("0x1::m::S<>", 10, "expected an identifier"),
+        ];
+        for (input, offset, message) in cases {
+            let err = TypeTag::parse(input).unwrap_err();
+            assert_eq!(
+                (err.offset, err.message.as_str()),
+                (offset, message),
+                "{input}"
+            );
+        }
+
+        let err = TypeTag::parse("0x1:coin::CoinStore").unwrap_err();
+        assert_eq!(
+            err.to_string(),
+            "expected `::` at offset 3\n  0x1:coin::CoinStore\n     ^"
+        );
+    }
+
+    #[test]
+    fn encodes_bcs() {
+        assert_eq!(TypeTag::parse("u64").unwrap().to_bcs(), [2]);
+        assert_eq!(TypeTag::parse("vector<u8>").unwrap().to_bcs(), [6, 1]);
+        assert_eq!(TypeTag::parse("u256").unwrap().to_bcs(), [10]);
+
+        let bcs = TypeTag::parse("0x1::aptos_coin::AptosCoin")
+            .unwrap()
+            .to_bcs();
+        let mut expected = vec![7];
+        expected.extend([0; 31]);
+        expected.push(1);
+        expected.push(10);
+        expected.extend(b"aptos_coin");
+        expected.push(9);
+        expected.extend(b"AptosCoin");
+        expected.push(0);
+        assert_eq!(bcs, expected);
+
+        let tag = TypeTag::parse("vector<0x1::coin::Coin<0x1::aptos_coin::AptosCoin>>").unwrap();
+        let bcs = tag.to_bcs();
+        let mut input = bcs.as_slice();
+        assert_eq!(TypeTag::read_bcs(&mut input, "tag").unwrap(), tag);
+        assert!(input.is_empty());
+        assert!(TypeTag::read_bcs(&mut &[11u8][..], "tag").is_err());
+    }
+}
@@ -4,6 +4,7 @@ use clap::Args;
 use serde_json::{json, Value};
 
 use crate::commands::common::with_optional_ledger_version;
+use crate::commands::type_tag::check_type_tag_arg;
 
 #[derive(Args)]
 #[command(
@@ -13,7 +14,7 @@ pub(crate) struct ViewCommand {
     /// Fully-qualified Move function, e.g. `0x1::coin::balance`.
     #[arg(value_name = "FUNCTION")]
     pub(crate) function: String,
-    /// Repeatable type arguments.
+    /// Repeatable type arguments, validated locally before the request.
     #[arg(long = "type-args")]
     pub(crate) type_args: Vec<String>,
     /// Repeatable JSON arguments.
@@ -25,6 +26,11 @@ pub(crate) struct ViewCommand {
 }
 
 pub(crate) fn run_view(client: &AptosClient, command: ViewCommand) -> Result<()> {
+    let type_args = command
+        .type_args
+        .iter()
+        .map(|arg| Ok(check_type_tag_arg("--type-args", arg)?.to_string()))
+        .collect::<Result<Vec<_>>>()?;
     let mut parsed_args = Vec::with_capacity(command.args.len());
     for argument in &command.args {
         let parsed: Value = serde_json::from_str(argument)
@@ -34,7 +40,7 @@ pub(crate) fn run_view(client: &AptosClient, command: ViewCommand) -> Result<()>
 
     let body = json!({
         "function": command.function,
-        "type_arguments": command.type_args,
+        "type_arguments": type_args,
         "arguments": parsed_args
     });
 
@@ -0,0 +1,651 @@
+use anyhow::{anyhow, Context, Result};
+use aptly_aptos::AptosClient;
+use clap::Args;
+use num_bigint::BigInt;
+use serde::{Deserialize, Serialize};
+use serde_json::{json, Value};
+use std::collections::HashSet;
+use std::fs;
+use std::path::{Path, PathBuf};
+use std::str::FromStr;
+use std::thread;
+use std::time::{Duration, Instant, SystemTime, UNIX_EPOCH};
+
+use crate::commands::account::account_sequence_number;
+use crate::commands::common::{
+    glob_matches, normalize_address, normalize_qualified_name, parse_duration, parse_u64,
+    value_to_string,
+};
+use crate::commands::follow::FollowSource;
+use crate::commands::schema::{
+    integer_schema, nullable, object_schema, string_schema, OutputSchema,
+};
+
+const DEFAULT_ASSET: &str = "0x1::aptos_coin::AptosCoin";
+const DEFAULT_RULE_INTERVAL: &str = "30s";
+const PAGE_LIMIT: u64 = 100;
+
+#[derive(Args)]
+#[command(
+    after_help = "Examples:\n  aptly watch --config watch.yaml\n  aptly watch --config watch.yaml --once\n\nConfig:\n  interval: 30s                 # default for rules without their own\n  rules:\n    - name: treasury-low\n      interval: 1m\n      balance:\n        account: 0xabc\n        asset: 0x1::aptos_coin::AptosCoin   # default\n        below: \"10000000000\"              # base units; or `above`\n    - name: emergency-withdraw\n      transaction:\n        function: 0xdef::vault::emergency_withdraw   # `*` wildcards allowed\n        sender: 0x123                 # optional; default scans all transactions\n    - name: pool-paused\n      event:\n        type: 0xdef::pool::PausedEvent\n\nEach alert is printed as one JSON line. A balance rule fires when its condition starts to hold and re-arms once it clears."
+)]
+pub(crate) struct WatchCommand {
+    /// Rules file (YAML).
+    #[arg(long, value_name = "FILE")]
+    pub(crate) config: PathBuf,
+    /// Exit after the first alert.
+    #[arg(long, default_value_t = false)]
+    pub(crate) once: bool,
+}
+
+#[derive(Debug, Deserialize)]
+#[serde(deny_unknown_fields)]
+struct WatchFile {
+    #[serde(default)]
+    interval: Option<String>,
+    #[serde(default)]
+    rules: Vec<serde_yaml::Value>,
+}
+
+#[derive(Debug, Deserialize)]
+#[serde(deny_unknown_fields)]
+struct RuleConfig {
+    name: String,
+    #[serde(default)]
+    interval: Option<String>,
+    #[serde(default)]
+    balance: Option<BalanceConfig>,
+    #[serde(default)]
+    transaction: Option<TransactionConfig>,
+    #[serde(default)]
+    event: Option<EventConfig>,
+}
+
+#[derive(Debug, Deserialize)]
+#[serde(deny_unknown_fields)]
+struct BalanceConfig {
+    account: String,
+    #[serde(default)]
+    asset: Option<String>,
+    #[serde(default)]
+    below: Option<AmountConfig>,
+    #[serde(default)]
+    above: Option<AmountConfig>,
+}
+
+/// Thresholds may be YAML integers or strings (for values beyond u64).
+#[derive(Debug, Deserialize)]
+#[serde(untagged)]
+enum AmountConfig {
+    Integer(u64),
+    Text(String),
+}
+
+#[derive(Debug, Deserialize)]
+#[serde(deny_unknown_fields)]
+struct TransactionConfig {
+    function: String,
+    #[serde(default)]
+    sender: Option<String>,
+}
+
+#[derive(Debug, Deserialize)]
+#[serde(deny_unknown_fields)]
+struct EventConfig {
+    #[serde(rename = "type")]
+    event_type: String,
+    #[serde(default)]
+    sender: Option<String>,
+}
+
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+enum Comparison {
+    Below,
+    Above,
+}
+
+#[derive(Debug)]
+struct Predicate {
+    comparison: Comparison,
+    threshold: BigInt,
+}
+
+impl Predicate {
+    fn holds(&self, amount: &BigInt) -> bool {
+        match self.comparison {
+            Comparison::Below => amount < &self.threshold,
+            Comparison::Above => amount > &self.threshold,
+        }
+    }
+
+    fn describe(&self) -> String {
+        let op = match self.comparison {
+            Comparison::Below => "below",
+            Comparison::Above => "above",
+        };
+        format!("{op} {}", self.threshold)
+    }
+}
+
+/// A `*` glob over fully qualified Move names; the leading address is
+/// normalized on both sides.
+#[derive(Debug)]
+struct NamePattern(String);
+
+impl NamePattern {
+    fn matches(&self, value: &str) -> bool {
+        glob_matches(&self.0, &normalize_qualified_name(value))
+    }
+}
+
+#[derive(Debug)]
+enum Check {
+    Balance {
+        account: String,
+        asset: String,
+        predicate: Predicate,
+    },
+    Transaction {
+        source: FollowSource,
+        function: NamePattern,
+    },
+    Event {
+        source: FollowSource,
+        event_type: NamePattern,
+    },
+}
+
+#[derive(Debug)]
+struct Rule {
+    name: String,
+    interval: Duration,
+    check: Check,
+}
+
+#[derive(Debug, Clone, Serialize)]
+struct Alert {
+    rule: String,
+    kind: &'static str,
+    message: String,
+    observed_at: u64,
+    version: Option<u64>,
+    hash: Option<String>,
+    amount: Option<String>,
+}
+
+impl OutputSchema for Alert {
+    fn output_schema() -> Value {
+        object_schema(
+            "One alert; printed as a single JSON line",
+            &[
+                ("rule", string_schema("Name of the rule that fired")),
+                (
+                    "kind",
+                    json!({
+                        "description": "Rule kind",
+                        "type": "string",
+                        "enum": ["balance", "transaction", "event"],
+                    }),
+                ),
+                ("message", string_schema("Human-readable summary")),
+                (
+                    "observed_at",
+                    integer_schema("Unix time (seconds) when the alert was raised"),
+                ),
+                (
+                    "version",
+                    nullable(integer_schema("Matching transaction version")),
+                ),
+                ("hash", nullable(string_schema("Matching transaction hash"))),
+                (
+                    "amount",
+                    nullable(string_schema("Observed balance in base units")),
+                ),
+            ],
+        )
+    }
+}
+
+pub(crate) fn alert_output_schema() -> Value {
+    Alert::output_schema()
+}
+
+/// Per-rule polling state.
+struct RuleState {
+    rule: Rule,
+    next_due: Instant,
+    /// Next item to read from a transaction stream; set on the first poll so
+    /// history is not replayed.
+    cursor: Option<u64>,
+    /// Whether a balance condition held on the previous poll.
+    tripped: bool,
+}
+
+pub(crate) fn run_watch(client: &AptosClient, command: WatchCommand) -> Result<()> {
+    let rules = load_rules(&command.config)?;
+    eprintln!(
+        "watching {} rule(s) from {}",
+        rules.len(),
+        command.config.display()
+    );
+    let now = Instant::now();
+    let mut states: Vec<RuleState> = rules
+        .into_iter()
+        .map(|rule| RuleState {
+            rule,
+            next_due: now,
+            cursor: None,
+            tripped: false,
+        })
+        .collect();
+
+    loop {
+        for state in &mut states {
+            if state.next_due > Instant::now() {
+                continue;
+            }
+            let caught_up = match state.poll(client) {
+                Ok((alerts, caught_up)) => {
+                    for alert in alerts {
+                        println!("{}", serde_json::to_string(&alert)?);
+                        if command.once {
+                            return Ok(());
+                        }
+                    }
+                    caught_up
+                }
+                Err(err) => {
+                    eprintln!("watch: rule `{}`: {err:#}", state.rule.name);
+                    true
+                }
+            };
+            // A full page means the stream is behind; poll again right away.
+            state.next_due = if caught_up {
+                Instant::now() + state.rule.interval
+            } else {
+                Instant::now()
+            };
+        }
+
+        let next_due = states
+            .iter()
+            .map(|state| state.next_due)
+            .min()
+            .expect("config has at least one rule");
+        thread::sleep(next_due.saturating_duration_since(Instant::now()));
+    }
+}
+
+impl RuleState {
+    /// Runs one poll; returns new alerts and whether the source is caught up.
+    fn poll(&mut self, client: &AptosClient) -> Result<(Vec<Alert>, bool)> {
+        match &self.rule.check {
+            Check::Balance { account, asset, .. } => {
+                let path = format!("/accounts/{account}/balance/{}", urlencoding::encode(asset));
+                let value = client.get_json(&path)?;
+                let amount = BigInt::from_str(&value_to_string(&value))
+                    .map_err(|_| anyhow!("unexpected balance response: {value}"))?;
+                Ok((self.observe_balance(&amount).into_iter().collect(), true))
+            }
+            Check::Transaction { source, .. } | Check::Event { source, .. } => {
+                let cursor = match self.cursor {
+                    Some(cursor) => cursor,
+                    None => initial_cursor(client, source)?,
+                };
+                let transactions = match client.get_json(&source.path(cursor, PAGE_LIMIT))? {
+                    Value::Array(items) => items,
+                    other => return Err(anyhow!("unexpected transactions response: {other}")),
+                };
+                let mut next = cursor;
+                let mut alerts = Vec::new();
+                for transaction in &transactions {
+                    alerts.extend(self.match_transaction(transaction));
+                    next = source
+                        .next_cursor(transaction)
+                        .ok_or_else(|| anyhow!("transaction is missing its cursor field"))?
+                        .max(next);
+                }
+                self.cursor = Some(next);
+                Ok((alerts, (transactions.len() as u64) < PAGE_LIMIT))
+            }
+        }
+    }
+
+    /// Fires when the condition starts to hold; stays quiet until it clears.
+    fn observe_balance(&mut self, amount: &BigInt) -> Option<Alert> {
+        let Check::Balance {
+            account,
+            asset,
+            predicate,
+        } = &self.rule.check
+        else {
+            return None;
+        };
+        let holds = predicate.holds(amount);
+        let fire = holds && !self.tripped;
+        self.tripped = holds;
+        fire.then(|| Alert {
+            rule: self.rule.name.clone(),
+            kind: "balance",
+            message: format!(
+                "{account} {asset} balance {amount} is {}",
+                predicate.describe()
+            ),
+            observed_at: unix_now(),
+            version: None,
+            hash: None,
+            amount: Some(amount.to_string()),
+        })
+    }
+
+    fn match_transaction(&self, transaction: &Value) -> Vec<Alert> {
+        let version = transaction.get("version").and_then(parse_u64);
+        let hash = transaction
+            .get("hash")
+            .and_then(Value::as_str)
+            .map(str::to_owned);
+        let alert = |kind, message| Alert {
+            rule: self.rule.name.clone(),
+            kind,
+            message,
+            observed_at: unix_now(),
+            version,
+            hash: hash.clone(),
+            amount: None,
+        };
+        let at = version.map_or_else(String::new, |version| format!(" at version {version}"));
+
+        match &self.rule.check {
+            Check::Transaction { function, .. } => transaction
+                .get("payload")
+                .and_then(|payload| payload.get("function"))
+                .and_then(Value::as_str)
+                .filter(|name| function.matches(name))
+                .map(|name| alert("transaction", format!("{name} called{at}")))
+                .into_iter()
+                .collect(),
+            Check::Event { event_type, .. } => transaction
+                .get("events")
+                .and_then(Value::as_array)
+                .into_iter()
+                .flatten()
+                .filter_map(|event| event.get("type").and_then(Value::as_str))
+                .filter(|name| event_type.matches(name))
+                .map(|name| alert("event", format!("{name} emitted{at}")))
+                .collect(),
+            Check::Balance { .. } => Vec::new(),
+        }
+    }
+}
+
+/// Starts a stream at the ledger head so only new transactions are checked.
+fn initial_cursor(client: &AptosClient, source: &FollowSource) -> Result<u64> {
+    if let FollowSource::AccountTransactions { address } = source {
+        return account_sequence_number(client, address);
+    }
+    let ledger = client.get_json("/")?;
+    ledger
+        .get("ledger_version")
+        .and_then(parse_u64)
+        .map(|version| version + 1)
+        .ok_or_else(|| anyhow!("response from / has no ledger_version"))
+}
+
+fn unix_now() -> u64 {
+    SystemTime::now()
+        .duration_since(UNIX_EPOCH)
+        .map(|elapsed| elapsed.as_secs())
+        .unwrap_or_default()
+}
+
+fn load_rules(path: &Path) -> Result<Vec<Rule>> {
+    let body =
+        fs::read_to_string(path).with_context(|| format!("failed to read {}", path.display()))?;
+    parse_rules(&body).with_context(|| format!("invalid watch config {}", path.display()))
+}
+
+fn parse_rules(body: &str) -> Result<Vec<Rule>> {
+    let file: WatchFile = serde_yaml::from_str(body)?;
+    let default_interval =
+        parse_interval(file.interval.as_deref().unwrap_or(DEFAULT_RULE_INTERVAL))
+            .context("top-level `interval`")?;
+    if file.rules.is_empty() {
+        return Err(anyhow!("no rules defined under `rules`"));
+    }
+
+    let mut names = HashSet::new();
+    let mut rules = Vec::new();
+    for (index, raw) in file.rules.into_iter().enumerate() {
+        let label = match raw.get("name").and_then(|name| name.as_str()) {
+            Some(name) => format!("rule #{} `{name}`", index + 1),
+            None => format!("rule #{}", index + 1),
+        };
+        let rule = serde_yaml::from_value::<RuleConfig>(raw)
+            .map_err(anyhow::Error::from)
+            .and_then(|config| build_rule(config, default_interval))
+            .with_context(|| label.clone())?;
+        if !names.insert(rule.name.clone()) {
+            return Err(anyhow!("{label}: duplicate rule name"));
+        }
+        rules.push(rule);
+    }
+    Ok(rules)
+}
+
+fn build_rule(config: RuleConfig, default_interval: Duration) -> Result<Rule> {
+    let name = config.name.trim().to_owned();
+    if name.is_empty() {
+        return Err(anyhow!("`name` cannot be empty"));
+    }
+    let interval = match &config.interval {
+        Some(interval) => parse_interval(interval).context("`interval`")?,
+        None => default_interval,
+    };
+
+    let check = match (config.balance, config.transaction, config.event) {
+        (Some(balance), None, None) => build_balance_check(balance)?,
+        (None, Some(transaction), None) => Check::Transaction {
+            source: transaction_source(transaction.sender.as_deref())?,
+            function: name_pattern(&transaction.function, "transaction.function")?,
+        },
+        (None, None, Some(event)) => Check::Event {
+            source: transaction_source(event.sender.as_deref())?,
+            event_type: name_pattern(&event.event_type, "event.type")?,
+        },
+        _ => {
+            return Err(anyhow!(
+                "expected exactly one of `balance`, `transaction`, or `event`"
+            ))
+        }
+    };
+    Ok(Rule {
+        name,
+        interval,
+        check,
+    })
+}
+
+fn build_balance_check(config: BalanceConfig) -> Result<Check> {
+    let (comparison, amount) = match (config.below, config.above) {
+        (Some(amount), None) => (Comparison::Below, amount),
+        (None, Some(amount)) => (Comparison::Above, amount),
+        _ => return Err(anyhow!("`balance` needs exactly one of `below` or `above`")),
+    };
+    let threshold = match amount {
+        AmountConfig::Integer(value) => BigInt::from(value),
+        AmountConfig::Text(text) => BigInt::from_str(text.trim())
+            .ok()
+            .filter(|value| !value.is_negative())
+            .ok_or_else(|| {
+                anyhow!("threshold `{text}` is not a non-negative integer in base units")
+            })?,
+    };
+    Ok(Check::Balance {
+        account: parse_account(&config.account, "balance.account")?,
+        asset: config
+            .asset
+            .map(|asset| asset.trim().to_owned())
+            .unwrap_or_else(|| DEFAULT_ASSET.to_owned()),
+        predicate: Predicate {
+            comparison,
+            threshold,
+        },
+    })
+}
+
+fn transaction_source(sender: Option<&str>) -> Result<FollowSource> {
+    Ok(match sender {
+        Some(sender) => FollowSource::AccountTransactions {
+            address: parse_account(sender, "sender")?,
+        },
+        None => FollowSource::Transactions,
+    })
+}
+
+fn parse_account(value: &str, field: &str) -> Result<String> {
+    let address = normalize_address(value.trim());
+    if address.len() != 66 || !address.starts_with("0x") {
+        return Err(anyhow!("`{field}`: invalid address `{value}`"));
+    }
+    Ok(address)
+}
+
+fn name_pattern(value: &str, field: &str) -> Result<NamePattern> {
+    let value = value.trim();
+    if !value.contains('*') && value.split("::").count() < 3 {
+        return Err(anyhow!(
+            "`{field}`: expected `<address>::<module>::<name>` or a `*` pattern, got `{value}`"
+        ));
+    }
+    Ok(NamePattern(normalize_qualified_name(value)))
+}
+
+fn parse_interval(value: &str) -> Result<Duration> {
+    let interval = parse_duration(value).map_err(|err| anyhow!("{err}"))?;
+    if interval.is_zero() {
+        return Err(anyhow!("interval must be greater than zero"));
+    }
+    Ok(interval)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::commands::schema::validate;
+
+    // JSON is a subset of YAML, so these double as YAML configs.
+    const CONFIG: &str = r#"{
+        "interval": "10s",
+        "rules": [
+            {"name": "low", "interval": "1m",
+             "balance": {"account": "0xabc", "below": "100"}},
+            {"name": "withdraw",
+             "transaction": {"function": "0xdef::vault::emergency_withdraw"}},
+            {"name": "paused",
+             "event": {"type": "0xdef::pool::*Event", "sender": "0x123"}}
+        ]
+    }"#;
+
+    fn state(rule: Rule) -> RuleState {
+        RuleState {
+            rule,
+            next_due: Instant::now(),
+            cursor: None,
+            tripped: false,
+        }
+    }
+
+    #[test]
+    fn parses_rules_with_intervals() {
+        let rules = parse_rules(CONFIG).unwrap();
+        assert_eq!(rules.len(), 3);
+        assert_eq!(rules[0].interval, Duration::from_secs(60));
+        assert_eq!(rules[1].interval, Duration::from_secs(10));
+        assert!(matches!(
+            &rules[2].check,
+            Check::Event { source: FollowSource::AccountTransactions { address }, .. }
+                if *address == normalize_address("0x123")
+        ));
+    }
+
+    #[test]
+    fn validation_errors_name_the_rule() {
+        let cases = [
+            (
+                r#"{"rules": [{"name": "ok", "event": {"type": "0x1::a::B"}},
+                              {"name": "both", "event": {"type": "0x1::a::B"},
+                               "transaction": {"function": "0x1::a::b"}}]}"#,
+                "rule #2 `both`",
+            ),
+            (
+                r#"{"rules": [{"name": "neg", "balance": {"account": "0x1", "below": "-5"}}]}"#,
+                "not a non-negative integer",
+            ),
+            (
+                r#"{"rules": [{"name": "typo", "balance": {"acount": "0x1", "below": 5}}]}"#,
+                "rule #1 `typo`",
+            ),
+            (
+                r#"{"rules": [{"name": "fn", "transaction": {"function": "vault"}}]}"#,
+                "transaction.function",
+            ),
+            (
+                r#"{"rules": [{"name": "a", "event": {"type": "0x1::a::B"}},
+                              {"name": "a", "event": {"type": "0x1::a::C"}}]}"#,
+                "duplicate rule name",
+            ),
+            (r#"{"rules": []}"#, "no rules"),
+        ];
+        for (config, expected) in cases {
+            let err = format!("{:#}", parse_rules(config).unwrap_err());
+            assert!(
+                err.contains(expected),
+                "`{err}` should mention `{expected}`"
+            );
+        }
+    }
+
+    #[test]
+    fn balance_alert_fires_once_until_cleared() {
+        let mut rules = parse_rules(CONFIG).unwrap();
+        let mut state = state(rules.remove(0));
+        let below = BigInt::from(99u64);
+        let alert = state.observe_balance(&below).unwrap();
+        assert_eq!(alert.amount.as_deref(), Some("99"));
+        assert!(state.observe_balance(&below).is_none());
+        assert!(state.observe_balance(&BigInt::from(100u64)).is_none());
+        assert!(state.observe_balance(&below).is_some());
+    }
+
+    #[test]
+    fn matches_functions_and_event_types() {
+        let mut rules = parse_rules(CONFIG).unwrap();
+        let long_def = normalize_address("0xdef");
+        let transaction = json!({
+            "version": "42",
+            "hash": "0xfeed",
+            "payload": {"function": format!("{long_def}::vault::emergency_withdraw")},
+            "events": [
+                {"type": format!("{long_def}::pool::PausedEvent")},
+                {"type": "0x1::coin::DepositEvent"},
+                {"type": format!("{long_def}::pool::ResumedEvent")}
+            ]
+        });
+
+        let events = state(rules.remove(2)).match_transaction(&transaction);
+        assert_eq!(events.len(), 2);
+        let calls = state(rules.remove(1)).match_transaction(&transaction);
+        assert_eq!(calls.len(), 1);
+        assert_eq!(calls[0].version, Some(42));
+        assert_eq!(calls[0].hash.as_deref(), Some("0xfeed"));
+        validate(
+            &alert_output_schema(),
+            &serde_json::to_value(&calls[0]).unwrap(),
+        )
+        .unwrap();
+
+        let other = json!({"payload": {"function": "0x1::vault::emergency_withdraw"}});
+        let calls = state(parse_rules(CONFIG).unwrap().remove(1)).match_transaction(&other);
+        assert!(calls.is_empty());
+    }
+}
@@ -0,0 +1,127 @@
+use aptly_aptos::UtcDateTime;
+
+/// Koinly "universal" import columns. CoinTracker accepts the same file.
+const KOINLY_HEADER: &str =
+    "Date,Sent Amount,Sent Currency,Received Amount,Received Currency,Fee Amount,Fee Currency,TxHash";
+
+/// Plain `account sends` columns.
+const SENDS_HEADER: &str = "version,from,to,amount,asset";
+
+/// One movement of funds, in decimal amounts. Either side may be empty.
+pub(crate) struct KoinlyRow<'a> {
+    /// Block timestamp in microseconds since the Unix epoch.
+    pub(crate) timestamp_us: u64,
+    pub(crate) sent: Option<(&'a str, &'a str)>,
+    pub(crate) received: Option<(&'a str, &'a str)>,
+    pub(crate) fee: Option<(&'a str, &'a str)>,
+    pub(crate) tx_hash: &'a str,
+}
+
+/// Renders rows as CSV with a header line and `\n` line endings.
+pub(crate) fn koinly_csv(rows: &[KoinlyRow<'_>]) -> String {
+    let mut out = String::from(KOINLY_HEADER);
+    out.push('\n');
+    for row in rows {
+        let (sent_amount, sent_currency) = row.sent.unwrap_or_default();
+        let (received_amount, received_currency) = row.received.unwrap_or_default();
+        let (fee_amount, fee_currency) = row.fee.unwrap_or_default();
+        let fields = [
+            koinly_date(row.timestamp_us).as_str(),
+            sent_amount,
+            sent_currency,
+            received_amount,
+            received_currency,
+            fee_amount,
+            fee_currency,
+            row.tx_hash,
+        ]
+        .map(csv_field);
+        out.push_str(&fields.join(","));
+        out.push('\n');
+    }
+    out
+}
+
+/// One transfer: decimal amount and resolved asset symbol.
+pub(crate) struct SendRow<'a> {
+    pub(crate) version: u64,
+    pub(crate) from: &'a str,
+    pub(crate) to: &'a str,
+    pub(crate) amount: &'a str,
+    pub(crate) asset: &'a str,
+}
+
+/// Renders rows as CSV with a header line, which is printed even when there
+/// are no rows.
+pub(crate) fn sends_csv(rows: &[SendRow<'_>]) -> String {
+    let mut out = String::from(SENDS_HEADER);
+    out.push('\n');
+    for row in rows {
+        let fields = [
+            row.version.to_string().as_str(),
+            row.from,
+            row.to,
+            row.amount,
+            row.asset,
+        ]
+        .map(csv_field);
+        out.push_str(&fields.join(","));
+        out.push('\n');
+    }
+    out
+}
+
+/// UTC `YYYY-MM-DD HH:MM`.
+fn koinly_date(timestamp_us: u64) -> String {
+    let at = UtcDateTime::from_unix_seconds(timestamp_us / 1_000_000);
+    format!(
+        "{:04}-{:02}-{:02} {:02}:{:02}",
+        at.year, at.month, at.day, at.hour, at.minute
+    )
+}
+
+/// Quotes a field that holds a comma, quote or line break.
+fn csv_field(value: &str) -> String {
+    if value.contains([',', '"', '\n', '\r']) {
+        format!("\"{}\"", value.replace('"', "\"\""))
+    } else {
+        value.to_owned()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn quotes_fields_and_formats_dates() {
+        let rows = [KoinlyRow {
+            timestamp_us: 1_700_000_059_999_999,
+            sent: Some(("1.5", "MY,TOKEN")),
+            received: None,
+            fee: None,
+            tx_hash: "0xab",
+        }];
+        assert_eq!(
+            koinly_csv(&rows),
+            format!("{KOINLY_HEADER}\n2023-11-14 22:14,1.5,\"MY,TOKEN\",,,,,0xab\n")
+        );
+        assert_eq!(csv_field("a\"b"), "\"a\"\"b\"");
+    }
+
+    #[test]
+    fn sends_csv_keeps_the_header_without_rows() {
+        assert_eq!(sends_csv(&[]), format!("{SENDS_HEADER}\n"));
+        let rows = [SendRow {
+            version: 42,
+            from: "0x2",
+            to: "0x3",
+            amount: "1.5",
+            asset: "LP \"A,B\"",
+        }];
+        assert_eq!(
+            sends_csv(&rows),
+            format!("{SENDS_HEADER}\n42,0x2,0x3,1.5,\"LP \"\"A,B\"\"\"\n")
+        );
+    }
+}
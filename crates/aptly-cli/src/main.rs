@@ -1,37 +1,169 @@
-use anyhow::Result;
-use aptly_aptos::AptosClient;
-use clap::{Parser, Subcommand};
+use anyhow::{anyhow, Result};
+use aptly_aptos::{AptosClient, AuditLog, ClientOptions, Fixtures};
+use clap::{CommandFactory, Parser, Subcommand};
 use serde::Serialize;
-use serde_json::Value;
+use serde_json::{json, Value};
+use std::env;
+use std::path::PathBuf;
+use std::sync::{Arc, OnceLock};
 
 mod commands;
+mod csv_export;
+mod metrics;
+mod network;
+mod output;
 mod plugin_tools;
+mod sqlite_export;
 
-use commands::account::{run_account, AccountCommand};
+use commands::account::{run_account, AccountCommand, AccountSubcommand};
 use commands::address::{run_address, AddressCommand};
-use commands::block::{run_block, BlockCommand};
+use commands::asset_cache;
+use commands::audit::{run_audit, AuditCommand};
+use commands::block::{run_block, BlockCommand, BlockSubcommand};
+use commands::coin::{run_coin, CoinCommand};
+use commands::decode::{run_decode, DecodeCommand};
 use commands::decompile::{run_decompile, DecompileCommand};
-use commands::events::{run_events, EventsCommand};
-use commands::node::{run_node, NodeCommand};
+use commands::events::{run_events, EventsCommand, EventsSubcommand};
+use commands::fa::{run_fa, FaCommand};
+use commands::faucet::{run_faucet, FaucetCommand};
+use commands::labels::{run_labels, LabelsCommand};
+use commands::node::{run_node, NodeCommand, NodeSubcommand};
 use commands::plugin::{run_plugin, PluginCommand};
+use commands::repl::run_repl;
+use commands::schema::{command_schema, run_schema, schema_path_from_args, SchemaCommand};
 use commands::table::{run_table, TableCommand};
-use commands::tx::{run_tx, TxCommand};
+use commands::tx::{run_tx, TxCommand, TxSubcommand};
+use commands::type_tag::{run_type, TypeCommand};
 use commands::view::{run_view, ViewCommand};
+use commands::watch::{run_watch, WatchCommand};
+use network::{check_localnet, Network};
+use output::OutputTarget;
 
-const DEFAULT_RPC_URL: &str = "https://rpc.sentio.xyz/aptos/v1";
+/// Audit log path used when `--audit-log` is not given.
+const AUDIT_LOG_ENV: &str = "APTLY_AUDIT_LOG";
+/// Mainnet endpoint used when neither `--rpc-url` nor `--network` is given.
+pub(crate) const DEFAULT_RPC_URL: &str = "https://rpc.sentio.xyz/aptos/v1";
+/// Stays under the anonymous per-IP quota of public Aptos gateways.
+const DEFAULT_MAX_RPS: f64 = 10.0;
+
+/// Ledger version pinned by `--pin-ledger`; wraps JSON output when set.
+static PINNED_LEDGER_VERSION: OnceLock<u64> = OnceLock::new();
 
 #[derive(Parser)]
 #[command(name = "aptly")]
 #[command(about = "Aptos CLI utilities in Rust")]
 struct Cli {
-    /// Aptos node REST API endpoint.
+    /// Aptos node REST API endpoint, or a comma-separated list of fallbacks.
     #[arg(long, global = true, default_value = DEFAULT_RPC_URL)]
     rpc_url: String,
 
+    /// Use a well-known network's endpoints instead of `--rpc-url`. `local`
+    /// checks that a localnet is running before the command starts.
+    #[arg(long, global = true, value_enum, conflicts_with = "rpc_url")]
+    network: Option<Network>,
+
+    /// Use only the first `--rpc-url` endpoint; never fail over.
+    #[arg(long, global = true, default_value_t = false)]
+    strict_endpoint: bool,
+
+    /// Pin all state queries to the ledger version current at startup.
+    #[arg(long, global = true, default_value_t = false)]
+    pin_ledger: bool,
+
+    /// Maximum node requests per second; `0` disables client-side limiting.
+    #[arg(long, global = true, default_value_t = DEFAULT_MAX_RPS, value_parser = parse_max_rps)]
+    max_rps: f64,
+
+    /// Log RPC diagnostics (such as endpoint failovers) to stderr.
+    #[arg(long, global = true, default_value_t = false)]
+    verbose: bool,
+
+    /// Append one JSON line per node request to this file. Defaults to
+    /// `$APTLY_AUDIT_LOG` when set.
+    #[arg(long, global = true, value_name = "FILE")]
+    audit_log: Option<PathBuf>,
+
+    /// Include request and response bodies in `--audit-log` lines.
+    #[arg(long, global = true, default_value_t = false)]
+    audit_bodies: bool,
+
+    /// Write every node request and response to this fixture directory.
+    #[arg(long, global = true, value_name = "DIR", conflicts_with = "replay")]
+    record: Option<PathBuf>,
+
+    /// Serve node requests from a `--record` fixture directory instead of the
+    /// network; a request with no recorded response fails.
+    #[arg(long, global = true, value_name = "DIR")]
+    replay: Option<PathBuf>,
+
+    /// Rewrite address fields in structured output to the 64-hex long form.
+    #[arg(long, global = true, default_value_t = false)]
+    canonical_addresses: bool,
+
+    /// Write JSON output to FILE instead of stdout, which then gets a one-line
+    /// summary with the path and byte count.
+    #[arg(long, global = true, value_name = "FILE", conflicts_with = "split_by")]
+    output: Option<PathBuf>,
+
+    /// Write each element of array output to its own file under
+    /// `--output-dir`, named by this field (dotted for nested fields, e.g.
+    /// `abi.name`).
+    #[arg(long, global = true, value_name = "FIELD", requires = "output_dir")]
+    split_by: Option<String>,
+
+    /// Directory for `--split-by` files.
+    #[arg(long, global = true, value_name = "DIR", requires = "split_by")]
+    output_dir: Option<PathBuf>,
+
+    /// Let `--output`, `--split-by` and commands writing files of their own
+    /// (`account source-code --out-dir`) replace existing files.
+    #[arg(long, global = true, default_value_t = false)]
+    force: bool,
+
+    /// Skip the on-disk asset metadata cache (`~/.cache/aptly/assets.json`).
+    #[arg(long, global = true, default_value_t = false)]
+    no_cache: bool,
+
+    /// Print the JSON Schema of the command's output instead of running it.
+    #[arg(long, global = true, default_value_t = false)]
+    schema: bool,
+
     #[command(subcommand)]
     command: Command,
 }
 
+impl Cli {
+    pub(crate) fn output_target(&self) -> OutputTarget {
+        match (&self.output, &self.split_by, &self.output_dir) {
+            (Some(path), _, _) => OutputTarget::File {
+                path: path.clone(),
+                force: self.force,
+            },
+            (None, Some(field), Some(dir)) => OutputTarget::Split {
+                field: field.clone(),
+                dir: dir.clone(),
+                force: self.force,
+            },
+            _ => OutputTarget::Stdout,
+        }
+    }
+}
+
+/// Accepts a finite rate `>= 0`. Negative values would silently disable
+/// limiting and NaN or infinity would panic when computing the wait.
+fn parse_max_rps(value: &str) -> std::result::Result<f64, String> {
+    let rps: f64 = value
+        .trim()
+        .parse()
+        .map_err(|_| format!("invalid rate `{value}`"))?;
+    if !rps.is_finite() || rps < 0.0 {
+        return Err(format!(
+            "rate must be a finite number >= 0 (use 0 to disable limiting), got `{value}`"
+        ));
+    }
+    Ok(rps)
+}
+
 #[derive(Subcommand)]
 enum Command {
     #[command(
@@ -49,6 +181,26 @@ enum Command {
         long_about = "Resolve protocol and ecosystem labels to on-chain addresses using a curated label source."
     )]
     Address(AddressCommand),
+    #[command(
+        about = "Inspect coin types and their fungible asset counterparts",
+        long_about = "Inspect legacy coin types during the coin to fungible asset migration: paired FA metadata and balances split between `CoinStore` and the primary fungible store."
+    )]
+    Coin(CoinCommand),
+    #[command(
+        about = "Inspect fungible asset stores",
+        long_about = "Inspect fungible asset primary stores: the derived store address, whether it exists, its balance and frozen flag."
+    )]
+    Fa(FaCommand),
+    #[command(
+        about = "Manage local address labels",
+        long_about = "List built-in labels for well-known mainnet addresses and maintain a user labels file used by `--labels` on `account sends` and `tx balance-change`."
+    )]
+    Labels(LabelsCommand),
+    #[command(
+        about = "Validate and canonicalize Move type tags",
+        long_about = "Parse a Move type tag offline, reporting its canonical form (long addresses, `, ` between type parameters), a structural breakdown and optionally its BCS encoding, or the character offset where parsing failed."
+    )]
+    Type(TypeCommand),
     #[command(
         about = "Inspect optional external plugins",
         long_about = "Inspect optional binaries (`move-decompiler`, `aptos-tracer`, `aptos-script-compose`) used by decompile/trace/compose workflows."
@@ -59,6 +211,11 @@ enum Command {
         long_about = "Decompile Move module bytecode when published source metadata is unavailable from `aptly account source-code`."
     )]
     Decompile(DecompileCommand),
+    #[command(
+        about = "Decode BCS-encoded Move values",
+        long_about = "Decode BCS hex, such as raw table items or state values, into the JSON the node would return. Struct layouts are read from the ABIs of the defining modules, resolving nested structs recursively."
+    )]
+    Decode(DecodeCommand),
     #[command(
         about = "Fetch blocks by height or version",
         long_about = "Fetch block data either by block height or by a containing ledger version."
@@ -69,6 +226,11 @@ enum Command {
         long_about = "Read account events using the account address and event handle creation number, with pagination support."
     )]
     Events(EventsCommand),
+    #[command(
+        about = "Fund an account from a network faucet",
+        long_about = "Request coins from the faucet of the selected network (`--network local` or `devnet`) or an explicit `--faucet-url`, and print the funding transaction hashes."
+    )]
+    Faucet(FaucetCommand),
     #[command(
         about = "Read Move table items",
         long_about = "Read Move table entries by table handle and typed key/value descriptors."
@@ -84,37 +246,286 @@ enum Command {
         long_about = "Inspect transactions by version/hash, list transactions, encode or submit payloads via stdin, simulate entry functions, compose scripts, fetch traces, and summarize balance changes."
     )]
     Tx(TxCommand),
+    #[command(
+        about = "Poll the chain and alert when watch rules trip",
+        long_about = "Evaluate rules from a YAML file on per-rule intervals: account balances crossing a threshold, transactions calling a matching function, and events of a matching type. Each alert is printed once as a JSON line; `--once` exits after the first."
+    )]
+    Watch(WatchCommand),
+    #[command(
+        about = "Summarize `--audit-log` files",
+        long_about = "Aggregate a JSONL audit log written by `--audit-log` (or `APTLY_AUDIT_LOG`) into request counts and latencies per endpoint and status."
+    )]
+    Audit(AuditCommand),
+    #[command(
+        about = "Print JSON Schema for a command's output",
+        long_about = "Print the JSON Schema describing a command's JSON output. Commands that proxy raw node JSON reference the node OpenAPI definitions."
+    )]
+    Schema(SchemaCommand),
+    #[command(
+        about = "Run commands interactively with a shared client",
+        long_about = "Start an interactive session that accepts the same command syntax (e.g. `account balance 0x1`) and keeps the HTTP client alive between commands. Use `set rpc-url <url>` or `set network <name>` to switch endpoints, `history` to list past commands, and `exit` or EOF to quit."
+    )]
+    Repl,
     #[command(about = "Print build version information")]
     Version,
 }
 
-fn main() -> Result<()> {
+/// Error that ends a one-shot run with exit status `code` instead of 1.
+/// Inside `repl` it is reported like any other error.
+#[derive(Debug)]
+pub(crate) struct ExitStatusError {
+    pub(crate) code: i32,
+    pub(crate) message: String,
+}
+
+impl std::fmt::Display for ExitStatusError {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.write_str(&self.message)
+    }
+}
+
+impl std::error::Error for ExitStatusError {}
+
+fn main() {
+    if let Err(err) = run() {
+        eprintln!("Error: {err:?}");
+        let code = err
+            .downcast_ref::<ExitStatusError>()
+            .map_or(1, |exit| exit.code);
+        std::process::exit(code);
+    }
+}
+
+fn run() -> Result<()> {
+    let args: Vec<String> = std::env::args().collect();
+    if args
+        .iter()
+        .take_while(|arg| *arg != "--")
+        .any(|arg| arg == "--schema")
+    {
+        return print_command_schema(&args);
+    }
+
     let cli = Cli::parse();
-    let rpc_url = cli.rpc_url.clone();
+    let rpc_url = match cli.network {
+        Some(network) => network.rpc_url().to_owned(),
+        None => cli.rpc_url.clone(),
+    };
+    let canonical_addresses = cli.canonical_addresses;
+    let audit_log = cli
+        .audit_log
+        .clone()
+        .or_else(|| env::var_os(AUDIT_LOG_ENV).map(PathBuf::from))
+        .filter(|path| !path.as_os_str().is_empty());
+    let audit = match audit_log {
+        Some(path) => {
+            let command = schema_path_from_args(&Cli::command(), &args).join(" ");
+            Some(Arc::new(AuditLog::open(&path, &command, cli.audit_bodies)?))
+        }
+        None => None,
+    };
+    let fixtures = match (&cli.record, &cli.replay) {
+        (Some(dir), _) => Some(Arc::new(Fixtures::record(dir)?)),
+        (None, Some(dir)) => Some(Arc::new(Fixtures::replay(dir)?)),
+        (None, None) => None,
+    };
+    // Fixture runs must see every request the command would make.
+    if cli.no_cache || cli.record.is_some() || cli.replay.is_some() {
+        asset_cache::disable();
+    }
+    let client_options = ClientOptions {
+        strict_endpoint: cli.strict_endpoint,
+        verbose: cli.verbose,
+        max_rps: cli.max_rps,
+        audit,
+        fixtures,
+    };
+
+    output::set_target(cli.output_target());
+    output::set_force(cli.force);
 
     match cli.command {
         Command::Version => print_version(),
         Command::Plugin(command) => run_plugin(command)?,
-        Command::Decompile(command) => run_decompile(&rpc_url, command)?,
+        Command::Labels(command) => run_labels(command)?,
+        Command::Audit(command) => run_audit(command)?,
+        Command::Type(command) => run_type(command)?,
+        Command::Repl => {
+            if cli.pin_ledger {
+                return Err(anyhow!("--pin-ledger is not supported by `repl`"));
+            }
+            if cli.output_target() != OutputTarget::Stdout {
+                return Err(anyhow!(
+                    "--output and --split-by apply per command; pass them inside the `repl` session"
+                ));
+            }
+            run_repl(&rpc_url, client_options, canonical_addresses)?;
+        }
         command => {
-            let client = AptosClient::new(&rpc_url)?;
-            match command {
-                Command::Node(command) => run_node(&client, command)?,
-                Command::Account(command) => run_account(&client, command)?,
-                Command::Address(command) => run_address(command)?,
-                Command::Block(command) => run_block(&client, command)?,
-                Command::Events(command) => run_events(&client, command)?,
-                Command::Table(command) => run_table(&client, command)?,
-                Command::View(command) => run_view(&client, command)?,
-                Command::Tx(command) => run_tx(&client, &rpc_url, command)?,
-                Command::Plugin(_) | Command::Decompile(_) | Command::Version => unreachable!(),
+            let mut client = AptosClient::with_options(&rpc_url, client_options)?;
+            if cli.network == Some(Network::Local) {
+                check_localnet(&client)?;
             }
+            if cli.pin_ledger {
+                let unpinnable = unpinnable_endpoints(&command);
+                if !unpinnable.is_empty() {
+                    return Err(anyhow!(
+                        "--pin-ledger is not supported by this command; these endpoints do not accept ledger_version: {}",
+                        unpinnable.join(", ")
+                    ));
+                }
+                let version = client.pin_ledger_version()?;
+                let _ = PINNED_LEDGER_VERSION.set(version);
+            }
+            let result = run_command(&client, command, canonical_addresses);
+            client.log_stats();
+            result?;
         }
     }
 
     Ok(())
 }
 
+/// Dispatches a parsed command; shared by one-shot runs and `repl`.
+pub(crate) fn run_command(
+    client: &AptosClient,
+    command: Command,
+    canonical_addresses: bool,
+) -> Result<()> {
+    match command {
+        Command::Node(command) => run_node(client, command),
+        Command::Account(command) => run_account(client, command, canonical_addresses),
+        Command::Address(command) => run_address(command),
+        Command::Coin(command) => run_coin(client, command),
+        Command::Fa(command) => run_fa(client, command),
+        Command::Labels(command) => run_labels(command),
+        Command::Audit(command) => run_audit(command),
+        Command::Type(command) => run_type(command),
+        Command::Plugin(command) => run_plugin(command),
+        Command::Block(command) => run_block(client, command),
+        Command::Events(command) => run_events(client, command),
+        Command::Faucet(command) => run_faucet(client, command),
+        Command::Table(command) => run_table(client, command),
+        Command::View(command) => run_view(client, command),
+        Command::Tx(command) => run_tx(client, command, canonical_addresses),
+        Command::Watch(command) => run_watch(client, command),
+        Command::Decompile(command) => run_decompile(client, command),
+        Command::Decode(command) => run_decode(client, command),
+        Command::Schema(command) => run_schema(client.base_url(), command),
+        Command::Version => {
+            print_version();
+            Ok(())
+        }
+        Command::Repl => Err(anyhow!("already in a repl session")),
+    }
+}
+
+/// Handles the global `--schema` flag before full argument parsing, so the
+/// schema can be printed without the command's required arguments.
+pub(crate) fn print_command_schema(args: &[String]) -> Result<()> {
+    let path = schema_path_from_args(&Cli::command(), args);
+    let rpc_url = args
+        .iter()
+        .position(|arg| arg == "--rpc-url")
+        .and_then(|index| args.get(index + 1))
+        .map(String::as_str)
+        .or_else(|| args.iter().find_map(|arg| arg.strip_prefix("--rpc-url=")))
+        .and_then(|rpc_url| rpc_url.split(',').next())
+        .unwrap_or(DEFAULT_RPC_URL);
+    let path: Vec<&str> = path.iter().map(String::as_str).collect();
+    let schema = command_schema(rpc_url, &path)?;
+    print_pretty_json(&schema)
+}
+
+/// Node endpoints a command queries that cannot be pinned to a ledger version.
+fn unpinnable_endpoints(command: &Command) -> Vec<&'static str> {
+    match command {
+        Command::Node(command) => match command.command {
+            NodeSubcommand::Ledger => vec!["/"],
+            NodeSubcommand::Spec(_) => vec!["/spec.json"],
+            NodeSubcommand::Health(_) => vec!["/", "/-/healthy"],
+            NodeSubcommand::Info => vec!["/info"],
+            NodeSubcommand::EstimateGasPrice => vec!["/estimate_gas_price"],
+            NodeSubcommand::WaitSynced(_) => vec!["/"],
+            NodeSubcommand::Gas(_) => vec!["/estimate_gas_price", "/transactions"],
+            NodeSubcommand::Compare(_) => vec!["/"],
+        },
+        Command::Account(command) => match command.command {
+            Some(AccountSubcommand::Txs(_)) | Some(AccountSubcommand::Watch(_)) => {
+                vec!["/accounts/{address}/transactions"]
+            }
+            Some(AccountSubcommand::Sends(ref args))
+                if args.since.is_some() || args.until.is_some() =>
+            {
+                vec![
+                    "/",
+                    "/blocks/by_height/{height}",
+                    "/accounts/{address}/transactions",
+                ]
+            }
+            Some(AccountSubcommand::Sends(_)) => vec!["/accounts/{address}/transactions"],
+            Some(AccountSubcommand::Gas(_)) => vec!["/accounts/{address}/transactions"],
+            Some(AccountSubcommand::GasProfile(_)) => vec![
+                "indexer graphql",
+                "/transactions/by_version/{version}",
+                "/",
+                "/transactions",
+            ],
+            Some(AccountSubcommand::ResourceHistory(_)) => vec!["/"],
+            Some(AccountSubcommand::Receives(_)) => {
+                vec!["indexer graphql", "/transactions/by_version/{version}"]
+            }
+            Some(AccountSubcommand::Objects(_)) => {
+                vec!["indexer graphql", "/accounts/{address}/transactions"]
+            }
+            Some(AccountSubcommand::KeyRotation(_)) => vec![
+                "/accounts/{address}/events/{event_handle}/{field_name}",
+                "/accounts/{address}/transactions",
+            ],
+            _ => Vec::new(),
+        },
+        Command::Block(command) => match command.command {
+            Some(BlockSubcommand::ByVersion(_)) => vec!["/blocks/by_version/{version}"],
+            None => vec!["/blocks/by_height/{height}"],
+        },
+        Command::Events(EventsCommand {
+            command: Some(EventsSubcommand::Sync(_)),
+            ..
+        }) => vec!["/", "/accounts/{address}/events/{creation_number}"],
+        Command::Events(_) => vec!["/accounts/{address}/events/{creation_number}"],
+        Command::Faucet(_) => vec!["faucet /mint"],
+        Command::Tx(command) => match command.command {
+            Some(TxSubcommand::BalanceChange(ref args)) if args.block.is_some() => {
+                vec!["/blocks/by_height/{height}"]
+            }
+            Some(TxSubcommand::Graph(ref args)) if args.block.is_some() => {
+                vec!["/blocks/by_height/{height}"]
+            }
+            None
+            | Some(TxSubcommand::BalanceChange(_))
+            | Some(TxSubcommand::StateDiff(_))
+            | Some(TxSubcommand::Signers(_))
+            | Some(TxSubcommand::Graph(_))
+            | Some(TxSubcommand::Swaps(_)) => {
+                vec![
+                    "/transactions/by_version/{version}",
+                    "/transactions/by_hash/{hash}",
+                ]
+            }
+            Some(TxSubcommand::List(_)) => vec!["/transactions"],
+            Some(TxSubcommand::Encode) => vec!["/transactions/encode_submission"],
+            Some(TxSubcommand::Simulate(_)) | Some(TxSubcommand::Cost(_)) => {
+                vec!["/", "/estimate_gas_price", "/transactions/simulate"]
+            }
+            Some(TxSubcommand::Submit) => vec!["/transactions"],
+            Some(TxSubcommand::Trace(_)) => vec!["/", "/transactions/by_version/{version}"],
+            Some(TxSubcommand::Compose(_)) => vec!["aptos-script-compose"],
+        },
+        Command::Watch(_) => vec!["/", "/transactions", "/accounts/{address}/transactions"],
+        _ => Vec::new(),
+    }
+}
+
 fn print_version() {
     let version = env!("APTLY_VERSION");
     let commit_sha = env!("APTLY_GIT_SHA");
@@ -126,12 +537,79 @@ fn print_version() {
 }
 
 pub(crate) fn print_pretty_json(value: &Value) -> Result<()> {
-    let rendered = serde_json::to_string_pretty(value)?;
-    println!("{rendered}");
-    Ok(())
+    let rendered = match PINNED_LEDGER_VERSION.get() {
+        Some(version) => serde_json::to_string_pretty(&json!({
+            "ledger_version": version.to_string(),
+            "data": value,
+        }))?,
+        None => serde_json::to_string_pretty(value)?,
+    };
+    output::emit(value, &rendered)
 }
 
 pub(crate) fn print_serialized<T: Serialize>(value: &T) -> Result<()> {
     let json_value = serde_json::to_value(value)?;
     print_pretty_json(&json_value)
 }
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn unpinnable(args: &[&str]) -> Vec<&'static str> {
+        let cli = Cli::try_parse_from(args).unwrap();
+        unpinnable_endpoints(&cli.command)
+    }
+
+    #[test]
+    fn account_key_rotation_is_unpinnable() {
+        assert_eq!(
+            unpinnable(&["aptly", "account", "key-rotation", "0x1"]),
+            vec![
+                "/accounts/{address}/events/{event_handle}/{field_name}",
+                "/accounts/{address}/transactions",
+            ]
+        );
+    }
+
+    #[test]
+    fn account_receives_and_objects_are_unpinnable() {
+        assert_eq!(
+            unpinnable(&["aptly", "account", "receives", "0x1"]),
+            vec!["indexer graphql", "/transactions/by_version/{version}"]
+        );
+        assert_eq!(
+            unpinnable(&["aptly", "account", "objects", "0x1"]),
+            vec!["indexer graphql", "/accounts/{address}/transactions"]
+        );
+    }
+
+    #[test]
+    fn block_modes_are_unpinnable() {
+        for command in ["balance-change", "graph"] {
+            assert_eq!(
+                unpinnable(&["aptly", "tx", command, "--block", "5"]),
+                vec!["/blocks/by_height/{height}"]
+            );
+        }
+    }
+
+    #[test]
+    fn max_rps_rejects_negative_and_non_finite_rates() {
+        let parse = |value: &str| {
+            let flag = format!("--max-rps={value}");
+            Cli::try_parse_from(["aptly", flag.as_str(), "node", "ledger"]).map(|cli| cli.max_rps)
+        };
+        assert_eq!(parse("0").unwrap(), 0.0);
+        assert_eq!(parse("2.5").unwrap(), 2.5);
+        for value in ["-1", "NaN", "inf", "-inf", "fast"] {
+            assert!(parse(value).is_err(), "{value} should be rejected");
+        }
+    }
+
+    #[test]
+    fn account_state_reads_are_pinnable() {
+        assert!(unpinnable(&["aptly", "account", "resources", "0x1"]).is_empty());
+        assert!(unpinnable(&["aptly", "account", "balance", "0x1"]).is_empty());
+    }
+}
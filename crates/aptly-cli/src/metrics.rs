@@ -0,0 +1,191 @@
+use anyhow::{Context, Result};
+use std::collections::BTreeMap;
+use std::io::{ErrorKind, Read, Write};
+use std::net::{SocketAddr, TcpListener, TcpStream};
+use std::sync::atomic::{AtomicBool, AtomicU64, Ordering};
+use std::sync::{Arc, Mutex};
+use std::thread::{self, JoinHandle};
+use std::time::Duration;
+
+const CONTENT_TYPE: &str = "application/openmetrics-text; version=1.0.0; charset=utf-8";
+/// How often the accept loop checks for shutdown while idle.
+const ACCEPT_POLL: Duration = Duration::from_millis(50);
+
+/// Counters updated by follow-mode watch loops.
+#[derive(Debug, Default)]
+pub(crate) struct WatchMetrics {
+    events_seen: Mutex<BTreeMap<String, u64>>,
+    transactions_seen: AtomicU64,
+    last_seen_version: AtomicU64,
+    node_errors: AtomicU64,
+}
+
+impl WatchMetrics {
+    pub(crate) fn record_event(&self, event_type: &str) {
+        let mut events = self
+            .events_seen
+            .lock()
+            .unwrap_or_else(|err| err.into_inner());
+        *events.entry(event_type.to_owned()).or_default() += 1;
+    }
+
+    pub(crate) fn record_transactions(&self, count: u64) {
+        self.transactions_seen.fetch_add(count, Ordering::Relaxed);
+    }
+
+    pub(crate) fn record_version(&self, version: u64) {
+        self.last_seen_version.fetch_max(version, Ordering::Relaxed);
+    }
+
+    pub(crate) fn record_node_error(&self) {
+        self.node_errors.fetch_add(1, Ordering::Relaxed);
+    }
+
+    /// Renders all counters in OpenMetrics text format.
+    pub(crate) fn render(&self) -> String {
+        let mut out = String::new();
+        out.push_str("# TYPE events_seen counter\n");
+        out.push_str("# HELP events_seen Events observed, by Move event type.\n");
+        let events = self
+            .events_seen
+            .lock()
+            .unwrap_or_else(|err| err.into_inner());
+        for (event_type, count) in events.iter() {
+            out.push_str(&format!(
+                "events_seen_total{{type=\"{}\"}} {count}\n",
+                escape_label(event_type)
+            ));
+        }
+        drop(events);
+
+        let mut scalar = |name: &str, kind: &str, help: &str, sample: &str, value: u64| {
+            out.push_str(&format!("# TYPE {name} {kind}\n# HELP {name} {help}\n"));
+            out.push_str(&format!("{sample} {value}\n"));
+        };
+        scalar(
+            "transactions_seen",
+            "counter",
+            "Transactions observed.",
+            "transactions_seen_total",
+            self.transactions_seen.load(Ordering::Relaxed),
+        );
+        scalar(
+            "last_seen_version",
+            "gauge",
+            "Highest ledger version observed.",
+            "last_seen_version",
+            self.last_seen_version.load(Ordering::Relaxed),
+        );
+        scalar(
+            "node_errors",
+            "counter",
+            "Failed node requests.",
+            "node_errors_total",
+            self.node_errors.load(Ordering::Relaxed),
+        );
+        out.push_str("# EOF\n");
+        out
+    }
+}
+
+fn escape_label(value: &str) -> String {
+    value
+        .replace('\\', "\\\\")
+        .replace('"', "\\\"")
+        .replace('\n', "\\n")
+}
+
+/// Serves `WatchMetrics` over HTTP on a background thread until dropped.
+pub(crate) struct MetricsServer {
+    addr: SocketAddr,
+    shutdown: Arc<AtomicBool>,
+    handle: Option<JoinHandle<()>>,
+}
+
+impl MetricsServer {
+    /// Binds `listen` (`:9464` binds every interface) and starts serving.
+    pub(crate) fn start(listen: &str, metrics: Arc<WatchMetrics>) -> Result<Self> {
+        let listen = if listen.starts_with(':') {
+            format!("0.0.0.0{listen}")
+        } else {
+            listen.to_owned()
+        };
+        let listener = TcpListener::bind(&listen)
+            .with_context(|| format!("failed to bind metrics listener on {listen}"))?;
+        listener
+            .set_nonblocking(true)
+            .context("failed to configure metrics listener")?;
+        let addr = listener.local_addr()?;
+
+        let shutdown = Arc::new(AtomicBool::new(false));
+        let stop = Arc::clone(&shutdown);
+        let handle = thread::spawn(move || {
+            while !stop.load(Ordering::Relaxed) {
+                match listener.accept() {
+                    Ok((stream, _)) => {
+                        let _ = serve(stream, &metrics);
+                    }
+                    Err(err) if err.kind() == ErrorKind::WouldBlock => thread::sleep(ACCEPT_POLL),
+                    Err(_) => thread::sleep(ACCEPT_POLL),
+                }
+            }
+        });
+
+        Ok(Self {
+            addr,
+            shutdown,
+            handle: Some(handle),
+        })
+    }
+
+    pub(crate) fn local_addr(&self) -> SocketAddr {
+        self.addr
+    }
+}
+
+impl Drop for MetricsServer {
+    fn drop(&mut self) {
+        self.shutdown.store(true, Ordering::Relaxed);
+        if let Some(handle) = self.handle.take() {
+            let _ = handle.join();
+        }
+    }
+}
+
+fn serve(mut stream: TcpStream, metrics: &WatchMetrics) -> std::io::Result<()> {
+    stream.set_nonblocking(false)?;
+    stream.set_read_timeout(Some(Duration::from_secs(5)))?;
+    let mut buf = [0u8; 1024];
+    let _ = stream.read(&mut buf)?;
+    let body = metrics.render();
+    let response = format!(
+        "HTTP/1.1 200 OK\r\nContent-Type: {CONTENT_TYPE}\r\nContent-Length: {}\r\nConnection: close\r\n\r\n{body}",
+        body.len()
+    );
+    stream.write_all(response.as_bytes())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn renders_openmetrics_text() {
+        let metrics = WatchMetrics::default();
+        metrics.record_event("0x1::coin::DepositEvent");
+        metrics.record_event("0x1::coin::DepositEvent");
+        metrics.record_event("a\"b");
+        metrics.record_transactions(3);
+        metrics.record_version(10);
+        metrics.record_version(7);
+        metrics.record_node_error();
+
+        let text = metrics.render();
+        assert!(text.contains("events_seen_total{type=\"0x1::coin::DepositEvent\"} 2\n"));
+        assert!(text.contains("events_seen_total{type=\"a\\\"b\"} 1\n"));
+        assert!(text.contains("transactions_seen_total 3\n"));
+        assert!(text.contains("last_seen_version 10\n"));
+        assert!(text.contains("node_errors_total 1\n"));
+        assert!(text.ends_with("# EOF\n"));
+    }
+}
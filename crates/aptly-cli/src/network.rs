@@ -0,0 +1,132 @@
+use anyhow::{anyhow, Result};
+use aptly_aptos::AptosClient;
+use clap::ValueEnum;
+use serde_json::Value;
+
+/// Chain id of `aptos node run-local-testnet`.
+const LOCAL_CHAIN_ID: u64 = 4;
+const LOCAL_NODE_ADDR: &str = "127.0.0.1:8080";
+
+/// Well-known networks selectable with `--network` or `set network`.
+#[derive(Debug, Clone, Copy, PartialEq, Eq, ValueEnum)]
+pub(crate) enum Network {
+    Mainnet,
+    Testnet,
+    Devnet,
+    Local,
+}
+
+impl Network {
+    pub(crate) fn rpc_url(self) -> &'static str {
+        match self {
+            Self::Mainnet => "https://api.mainnet.aptoslabs.com/v1",
+            Self::Testnet => "https://api.testnet.aptoslabs.com/v1",
+            Self::Devnet => "https://api.devnet.aptoslabs.com/v1",
+            Self::Local => "http://127.0.0.1:8080/v1",
+        }
+    }
+
+    /// Indexer GraphQL endpoint; a localnet serves one when started with
+    /// `--with-indexer-api`.
+    pub(crate) fn indexer_url(self) -> &'static str {
+        match self {
+            Self::Mainnet => "https://api.mainnet.aptoslabs.com/v1/graphql",
+            Self::Testnet => "https://api.testnet.aptoslabs.com/v1/graphql",
+            Self::Devnet => "https://api.devnet.aptoslabs.com/v1/graphql",
+            Self::Local => "http://127.0.0.1:8090/v1/graphql",
+        }
+    }
+
+    /// Faucet accepting `POST /mint`; testnet minting is web-only.
+    pub(crate) fn faucet_url(self) -> Option<&'static str> {
+        match self {
+            Self::Devnet => Some("https://faucet.devnet.aptoslabs.com"),
+            Self::Local => Some("http://127.0.0.1:8081"),
+            Self::Mainnet | Self::Testnet => None,
+        }
+    }
+
+    /// Case-insensitive lookup by name.
+    pub(crate) fn from_name(name: &str) -> Result<Self> {
+        Self::from_str(name, true).map_err(|_| {
+            let names: Vec<String> = Self::value_variants()
+                .iter()
+                .filter_map(|network| network.to_possible_value())
+                .map(|value| value.get_name().to_owned())
+                .collect();
+            anyhow!(
+                "unknown network `{name}`; expected one of: {}",
+                names.join(", ")
+            )
+        })
+    }
+
+    /// The network whose default endpoint is `rpc_url`, if any.
+    pub(crate) fn from_rpc_url(rpc_url: &str) -> Option<Self> {
+        let rpc_url = rpc_url.trim_end_matches('/');
+        Self::value_variants()
+            .iter()
+            .copied()
+            .find(|network| network.rpc_url() == rpc_url)
+    }
+}
+
+/// Confirms a localnet answers on the default port before any command runs,
+/// so a stopped node fails with one plain line instead of a connection error.
+pub(crate) fn check_localnet(client: &AptosClient) -> Result<()> {
+    let ledger = client.get_json("/").map_err(|_| {
+        anyhow!(
+            "no localnet detected on {LOCAL_NODE_ADDR}; start one with `aptos node run-local-testnet`"
+        )
+    })?;
+    check_local_chain_id(&ledger)
+}
+
+fn check_local_chain_id(ledger: &Value) -> Result<()> {
+    match ledger.get("chain_id").and_then(Value::as_u64) {
+        Some(LOCAL_CHAIN_ID) => Ok(()),
+        Some(chain_id) => Err(anyhow!(
+            "node on {LOCAL_NODE_ADDR} reports chain id {chain_id}, not a localnet (chain id {LOCAL_CHAIN_ID})"
+        )),
+        None => Err(anyhow!(
+            "node on {LOCAL_NODE_ADDR} did not report a chain id"
+        )),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use serde_json::json;
+
+    #[test]
+    fn resolves_networks_by_name_and_url() {
+        assert_eq!(Network::from_name("LOCAL").unwrap(), Network::Local);
+        assert_eq!(
+            Network::from_rpc_url("http://127.0.0.1:8080/v1/"),
+            Some(Network::Local)
+        );
+        assert_eq!(
+            Network::from_rpc_url("https://rpc.sentio.xyz/aptos/v1"),
+            None
+        );
+        let err = Network::from_name("moonnet").unwrap_err();
+        assert_eq!(
+            err.to_string(),
+            "unknown network `moonnet`; expected one of: mainnet, testnet, devnet, local"
+        );
+    }
+
+    #[test]
+    fn localnet_check_reports_plainly() {
+        assert!(check_local_chain_id(&json!({"chain_id": 4})).is_ok());
+        let err = check_local_chain_id(&json!({"chain_id": 1})).unwrap_err();
+        assert!(err.to_string().contains("chain id 1, not a localnet"));
+
+        let client = AptosClient::new("http://127.0.0.1:9/v1").unwrap();
+        let err = check_localnet(&client).unwrap_err();
+        assert!(err
+            .to_string()
+            .starts_with("no localnet detected on 127.0.0.1:8080"));
+    }
+}
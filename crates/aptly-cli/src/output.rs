@@ -0,0 +1,284 @@
+use anyhow::{anyhow, Context, Result};
+use serde_json::Value;
+use std::collections::BTreeSet;
+use std::fs;
+use std::io::{ErrorKind, Write};
+use std::path::{Path, PathBuf};
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::sync::Mutex;
+use tempfile::NamedTempFile;
+
+/// Where JSON output goes, set per command from `--output`, `--split-by`,
+/// `--output-dir` and `--force`.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub(crate) enum OutputTarget {
+    Stdout,
+    /// The whole document to one file.
+    File {
+        path: PathBuf,
+        force: bool,
+    },
+    /// One file per array element under `dir`, named by the element's
+    /// `field` (a dotted path such as `abi.name`).
+    Split {
+        field: String,
+        dir: PathBuf,
+        force: bool,
+    },
+}
+
+static TARGET: Mutex<OutputTarget> = Mutex::new(OutputTarget::Stdout);
+
+/// `--force`, also read by commands that write files of their own.
+static FORCE: AtomicBool = AtomicBool::new(false);
+
+pub(crate) fn set_target(target: OutputTarget) {
+    *TARGET.lock().expect("output target lock") = target;
+}
+
+pub(crate) fn set_force(force: bool) {
+    FORCE.store(force, Ordering::Relaxed);
+}
+
+pub(crate) fn force() -> bool {
+    FORCE.load(Ordering::Relaxed)
+}
+
+/// Prints `rendered`, the pretty JSON of `value`, or writes it to the
+/// current target and prints a one-line summary instead.
+pub(crate) fn emit(value: &Value, rendered: &str) -> Result<()> {
+    let target = TARGET.lock().expect("output target lock").clone();
+    match target {
+        OutputTarget::Stdout => println!("{rendered}"),
+        OutputTarget::File { path, force } => {
+            let text = format!("{rendered}\n");
+            write_atomic(&path, text.as_bytes(), force)?;
+            println!("{}", file_summary(&path, text.len()));
+        }
+        OutputTarget::Split { field, dir, force } => {
+            let written = split_array(value, &field, &dir, force)?;
+            println!("{}", split_summary(&dir, &written));
+        }
+    }
+    Ok(())
+}
+
+fn file_summary(path: &Path, bytes: usize) -> String {
+    format!("wrote {bytes} bytes to {}", path.display())
+}
+
+fn split_summary(dir: &Path, written: &[(PathBuf, usize)]) -> String {
+    let bytes: usize = written.iter().map(|(_, bytes)| bytes).sum();
+    format!(
+        "wrote {} files ({bytes} bytes) to {}",
+        written.len(),
+        dir.display()
+    )
+}
+
+/// Writes each element of the array `value` to `<dir>/<key>.json`. Every
+/// name is checked before the first write, so a missing key, a duplicate or
+/// an existing file leaves `dir` untouched.
+fn split_array(
+    value: &Value,
+    field: &str,
+    dir: &Path,
+    force: bool,
+) -> Result<Vec<(PathBuf, usize)>> {
+    let items = value
+        .as_array()
+        .ok_or_else(|| anyhow!("--split-by needs a command whose output is a JSON array"))?;
+
+    let mut names = BTreeSet::new();
+    let mut files = Vec::with_capacity(items.len());
+    for (index, item) in items.iter().enumerate() {
+        let key = field
+            .split('.')
+            .try_fold(item, |value, part| value.get(part))
+            .and_then(|key| match key {
+                Value::String(key) => Some(key.clone()),
+                Value::Number(key) => Some(key.to_string()),
+                _ => None,
+            })
+            .ok_or_else(|| anyhow!("element {index} has no string or number field `{field}`"))?;
+        let name = format!("{}.json", file_component(&key));
+        if !names.insert(name.clone()) {
+            return Err(anyhow!(
+                "elements share the file name {name} (`{field}` = `{key}`); pick a unique field"
+            ));
+        }
+        let path = dir.join(name);
+        if !force && path.exists() {
+            return Err(overwrite_error(&path));
+        }
+        files.push((path, item));
+    }
+
+    fs::create_dir_all(dir)
+        .with_context(|| format!("failed to create output directory {}", dir.display()))?;
+    let mut written = Vec::with_capacity(files.len());
+    for (path, item) in files {
+        let text = format!("{}\n", serde_json::to_string_pretty(item)?);
+        write_atomic(&path, text.as_bytes(), force)?;
+        written.push((path, text.len()));
+    }
+    Ok(written)
+}
+
+/// Keeps letters, digits, `_`, `-` and `.`; anything else, and a
+/// component of only dots, becomes `_`.
+pub(crate) fn file_component(key: &str) -> String {
+    let component: String = key
+        .chars()
+        .map(|ch| {
+            if ch.is_ascii_alphanumeric() || matches!(ch, '_' | '-' | '.') {
+                ch
+            } else {
+                '_'
+            }
+        })
+        .collect();
+    if component.chars().all(|ch| ch == '.') {
+        "_".to_owned()
+    } else {
+        component
+    }
+}
+
+/// Writes through a temporary file in the same directory and renames it into
+/// place, so readers never see a partial file. Without `force` the rename
+/// itself refuses to replace an existing file.
+pub(crate) fn write_atomic(path: &Path, bytes: &[u8], force: bool) -> Result<()> {
+    let dir = match path.parent() {
+        Some(dir) if !dir.as_os_str().is_empty() => dir,
+        _ => Path::new("."),
+    };
+    if !force && path.exists() {
+        return Err(overwrite_error(path));
+    }
+    let mut temp = NamedTempFile::new_in(dir)
+        .with_context(|| format!("failed to create a temporary file in {}", dir.display()))?;
+    temp.write_all(bytes)
+        .and_then(|()| temp.as_file().sync_all())
+        .with_context(|| format!("failed to write {}", path.display()))?;
+    let persisted = if force {
+        temp.persist(path)
+    } else {
+        temp.persist_noclobber(path)
+    };
+    match persisted {
+        Ok(_) => Ok(()),
+        Err(err) if err.error.kind() == ErrorKind::AlreadyExists => Err(overwrite_error(path)),
+        Err(err) => Err(err.error).with_context(|| format!("failed to write {}", path.display())),
+    }
+}
+
+fn overwrite_error(path: &Path) -> anyhow::Error {
+    anyhow!("refusing to overwrite {}; pass --force", path.display())
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use serde_json::json;
+
+    fn entries(dir: &Path) -> Vec<String> {
+        let mut names: Vec<String> = fs::read_dir(dir)
+            .unwrap()
+            .map(|entry| entry.unwrap().file_name().to_string_lossy().into_owned())
+            .collect();
+        names.sort();
+        names
+    }
+
+    #[test]
+    fn splits_arrays_by_dotted_field() {
+        let dir = tempfile::tempdir().unwrap();
+        let out = dir.path().join("modules");
+        let modules = json!([
+            {"bytecode": "0x01", "abi": {"name": "coin"}},
+            {"bytecode": "0x02", "abi": {"name": "aptos_coin"}},
+        ]);
+        let written = split_array(&modules, "abi.name", &out, false).unwrap();
+        assert_eq!(entries(&out), ["aptos_coin.json", "coin.json"]);
+        let coin: Value =
+            serde_json::from_str(&fs::read_to_string(out.join("coin.json")).unwrap()).unwrap();
+        assert_eq!(coin, modules[0]);
+        assert_eq!(
+            split_summary(&out, &written),
+            format!(
+                "wrote 2 files ({} bytes) to {}",
+                written[0].1 + written[1].1,
+                out.display()
+            )
+        );
+
+        // Numbers and odd characters make names too.
+        split_array(&json!([{"id": 3}, {"id": "a/b"}]), "id", &out, false).unwrap();
+        assert!(entries(&out).contains(&"3.json".to_owned()));
+        assert!(entries(&out).contains(&"a_b.json".to_owned()));
+    }
+
+    #[test]
+    fn rejects_bad_splits_before_writing() {
+        let dir = tempfile::tempdir().unwrap();
+        let out = dir.path().join("out");
+        let err = split_array(&json!({"a": 1}), "a", &out, false).unwrap_err();
+        assert_eq!(
+            err.to_string(),
+            "--split-by needs a command whose output is a JSON array"
+        );
+        let err = split_array(&json!([{"name": "x"}, {}]), "name", &out, false).unwrap_err();
+        assert_eq!(
+            err.to_string(),
+            "element 1 has no string or number field `name`"
+        );
+        let err = split_array(
+            &json!([{"name": "a b"}, {"name": "a_b"}]),
+            "name",
+            &out,
+            false,
+        )
+        .unwrap_err();
+        assert!(err
+            .to_string()
+            .starts_with("elements share the file name a_b.json"));
+        assert!(!out.exists());
+
+        split_array(&json!([{"name": "x"}]), "name", &out, false).unwrap();
+        let err =
+            split_array(&json!([{"name": "y"}, {"name": "x"}]), "name", &out, false).unwrap_err();
+        assert!(err.to_string().starts_with("refusing to overwrite"));
+        assert_eq!(entries(&out), ["x.json"]);
+        split_array(&json!([{"name": "y"}, {"name": "x"}]), "name", &out, true).unwrap();
+        assert_eq!(entries(&out), ["x.json", "y.json"]);
+    }
+
+    #[test]
+    fn writes_atomically_and_refuses_to_overwrite() {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("dump.json");
+        write_atomic(&path, b"{\"a\": 1}\n", false).unwrap();
+
+        let err = write_atomic(&path, b"{}\n", false).unwrap_err();
+        assert_eq!(
+            err.to_string(),
+            format!("refusing to overwrite {}; pass --force", path.display())
+        );
+        assert_eq!(fs::read_to_string(&path).unwrap(), "{\"a\": 1}\n");
+
+        write_atomic(&path, b"{}\n", true).unwrap();
+        assert_eq!(fs::read_to_string(&path).unwrap(), "{}\n");
+        // No temporary files are left behind.
+        assert_eq!(entries(dir.path()), ["dump.json"]);
+
+        let missing = dir.path().join("missing").join("dump.json");
+        assert!(write_atomic(&missing, b"{}\n", false).is_err());
+        assert_eq!(entries(dir.path()), ["dump.json"]);
+
+        assert_eq!(
+            file_summary(&path, 3),
+            format!("wrote 3 bytes to {}", path.display())
+        );
+    }
+}
@@ -0,0 +1,426 @@
+use anyhow::{anyhow, Context, Result};
+use aptly_aptos::AptosClient;
+use rusqlite::{params, Connection};
+use std::collections::BTreeSet;
+use std::path::Path;
+
+use crate::commands::assets::{self, AssetMetadata};
+use crate::commands::common::normalize_address;
+
+/// Tables are created on first use; the unique indexes make re-exporting
+/// the same range a no-op. `event_index` is the row's position in what the
+/// exporting command derived from the transaction, not an on-chain event
+/// index, so `transfers` rows are also keyed by `source`, the command that
+/// wrote them: a send and a graph edge of one version never collide.
+/// `asset` columns hold the coin type or metadata address, joining to
+/// `assets`. Addresses are stored in the long form whatever
+/// `--canonical-addresses` says, so reruns with and without it match.
+const SCHEMA: &str = "
+CREATE TABLE IF NOT EXISTS transfers (
+    source TEXT NOT NULL,
+    version INTEGER NOT NULL,
+    event_index INTEGER NOT NULL,
+    from_address TEXT NOT NULL,
+    to_address TEXT NOT NULL,
+    amount TEXT NOT NULL,
+    asset TEXT NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS transfers_source_version_event
+    ON transfers (source, version, event_index);
+
+CREATE TABLE IF NOT EXISTS balance_changes (
+    version INTEGER NOT NULL,
+    event_index INTEGER NOT NULL,
+    kind TEXT NOT NULL,
+    account TEXT NOT NULL,
+    fungible_store TEXT NOT NULL,
+    asset TEXT NOT NULL,
+    amount TEXT NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS balance_changes_version_event
+    ON balance_changes (version, event_index);
+
+CREATE TABLE IF NOT EXISTS assets (
+    asset TEXT PRIMARY KEY,
+    symbol TEXT,
+    decimals INTEGER
+);
+";
+
+/// `source` of `account sends` rows.
+pub(crate) const SOURCE_SENDS: &str = "account sends";
+/// `source` of `tx graph` rows; edges differ with `--simplify`.
+pub(crate) const SOURCE_GRAPH: &str = "tx graph";
+pub(crate) const SOURCE_GRAPH_SIMPLIFIED: &str = "tx graph --simplify";
+
+pub(crate) struct TransferRow<'a> {
+    pub(crate) source: &'a str,
+    pub(crate) version: u64,
+    pub(crate) event_index: u64,
+    pub(crate) from: &'a str,
+    pub(crate) to: &'a str,
+    pub(crate) amount: &'a str,
+    pub(crate) asset: &'a str,
+}
+
+pub(crate) struct BalanceChangeRow<'a> {
+    pub(crate) version: u64,
+    pub(crate) event_index: u64,
+    pub(crate) kind: &'a str,
+    pub(crate) account: &'a str,
+    pub(crate) fungible_store: &'a str,
+    pub(crate) asset: &'a str,
+    pub(crate) amount: &'a str,
+}
+
+pub(crate) struct AssetRow<'a> {
+    pub(crate) asset: &'a str,
+    pub(crate) symbol: Option<&'a str>,
+    pub(crate) decimals: Option<u8>,
+}
+
+/// Opens (or creates) an export database with the tables above.
+pub(crate) fn open(path: &Path) -> Result<Connection> {
+    let conn = Connection::open(path)
+        .with_context(|| format!("failed to open sqlite database {}", path.display()))?;
+    conn.execute_batch(SCHEMA)
+        .context("failed to create sqlite export tables")?;
+    Ok(conn)
+}
+
+/// Appends rows, skipping identical ones already present; returns the
+/// number inserted. A different row under the same key is an error and
+/// nothing is written.
+pub(crate) fn insert_transfers(conn: &mut Connection, rows: &[TransferRow]) -> Result<usize> {
+    let tx = conn.transaction()?;
+    let mut inserted = 0;
+    for row in rows {
+        let (from, to, asset) = (
+            normalize_address(row.from),
+            normalize_address(row.to),
+            normalize_address(row.asset),
+        );
+        let values = params![
+            row.source,
+            row.version as i64,
+            row.event_index as i64,
+            from,
+            to,
+            row.amount,
+            asset
+        ];
+        let added = tx.execute(
+            "INSERT INTO transfers
+                 (source, version, event_index, from_address, to_address, amount, asset)
+             VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7)
+             ON CONFLICT (source, version, event_index) DO NOTHING",
+            values,
+        )?;
+        if added == 0 {
+            let same: i64 = tx.query_row(
+                "SELECT COUNT(*) FROM transfers
+                 WHERE source = ?1 AND version = ?2 AND event_index = ?3
+                   AND from_address = ?4 AND to_address = ?5 AND amount = ?6 AND asset = ?7",
+                values,
+                |found| found.get(0),
+            )?;
+            if same == 0 {
+                return Err(conflict_error(
+                    "transfers",
+                    Some(row.source),
+                    row.version,
+                    row.event_index,
+                ));
+            }
+        }
+        inserted += added;
+    }
+    tx.commit()?;
+    Ok(inserted)
+}
+
+pub(crate) fn insert_balance_changes(
+    conn: &mut Connection,
+    rows: &[BalanceChangeRow],
+) -> Result<usize> {
+    let tx = conn.transaction()?;
+    let mut inserted = 0;
+    for row in rows {
+        let (account, fungible_store, asset) = (
+            normalize_address(row.account),
+            normalize_address(row.fungible_store),
+            normalize_address(row.asset),
+        );
+        let values = params![
+            row.version as i64,
+            row.event_index as i64,
+            row.kind,
+            account,
+            fungible_store,
+            asset,
+            row.amount
+        ];
+        let added = tx.execute(
+            "INSERT INTO balance_changes
+                 (version, event_index, kind, account, fungible_store, asset, amount)
+             VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7)
+             ON CONFLICT (version, event_index) DO NOTHING",
+            values,
+        )?;
+        if added == 0 {
+            let same: i64 = tx.query_row(
+                "SELECT COUNT(*) FROM balance_changes
+                 WHERE version = ?1 AND event_index = ?2 AND kind = ?3 AND account = ?4
+                   AND fungible_store = ?5 AND asset = ?6 AND amount = ?7",
+                values,
+                |found| found.get(0),
+            )?;
+            if same == 0 {
+                return Err(conflict_error(
+                    "balance_changes",
+                    None,
+                    row.version,
+                    row.event_index,
+                ));
+            }
+        }
+        inserted += added;
+    }
+    tx.commit()?;
+    Ok(inserted)
+}
+
+fn conflict_error(
+    table: &str,
+    source: Option<&str>,
+    version: u64,
+    event_index: u64,
+) -> anyhow::Error {
+    let source = source.map_or(String::new(), |source| format!(" from `{source}`"));
+    anyhow!(
+        "{table} already holds a different row{source} for version {version}, index {event_index}; nothing was exported (use a fresh database)"
+    )
+}
+
+/// Records asset metadata; known symbols and decimals replace missing ones.
+pub(crate) fn upsert_assets(conn: &mut Connection, rows: &[AssetRow]) -> Result<()> {
+    let tx = conn.transaction()?;
+    for row in rows {
+        tx.execute(
+            "INSERT INTO assets (asset, symbol, decimals) VALUES (?1, ?2, ?3)
+             ON CONFLICT (asset) DO UPDATE SET
+                 symbol = COALESCE(excluded.symbol, symbol),
+                 decimals = COALESCE(excluded.decimals, decimals)",
+            params![normalize_address(row.asset), row.symbol, row.decimals],
+        )?;
+    }
+    tx.commit()?;
+    Ok(())
+}
+
+/// Records the symbol and decimals of `ids` (coin types or metadata
+/// addresses) as the asset resolver finds them; decimals stay unset when the
+/// chain did not report them.
+pub(crate) fn export_assets(
+    conn: &mut Connection,
+    client: &AptosClient,
+    ids: &BTreeSet<&str>,
+) -> Result<()> {
+    assets::resolver().warm(client, ids);
+    let metadata: Vec<(&str, AssetMetadata)> = ids
+        .iter()
+        .map(|asset| (*asset, assets::resolver().lookup(client, asset)))
+        .collect();
+    let rows: Vec<AssetRow> = metadata
+        .iter()
+        .map(|(asset, metadata)| AssetRow {
+            asset,
+            symbol: Some(&metadata.symbol),
+            decimals: metadata.decimals_known.then_some(metadata.decimals),
+        })
+        .collect();
+    upsert_assets(conn, &rows)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn count(path: &Path, table: &str) -> i64 {
+        let conn = Connection::open(path).unwrap();
+        conn.query_row(&format!("SELECT COUNT(*) FROM {table}"), [], |row| {
+            row.get(0)
+        })
+        .unwrap()
+    }
+
+    #[test]
+    fn reruns_append_without_duplicates() {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("export.db");
+        let transfer = |version| TransferRow {
+            source: SOURCE_SENDS,
+            version,
+            event_index: 0,
+            from: "0x1",
+            to: "0x2",
+            amount: "1.5",
+            asset: "0xa",
+        };
+
+        let mut conn = open(&path).unwrap();
+        assert_eq!(
+            insert_transfers(&mut conn, &[transfer(1), transfer(2)]).unwrap(),
+            2
+        );
+        drop(conn);
+
+        let mut conn = open(&path).unwrap();
+        assert_eq!(
+            insert_transfers(&mut conn, &[transfer(2), transfer(3)]).unwrap(),
+            1
+        );
+        let change = BalanceChangeRow {
+            version: 3,
+            event_index: 1,
+            kind: "deposit",
+            account: "0x2",
+            fungible_store: "0xabc",
+            asset: "0xa",
+            amount: "100",
+        };
+        assert_eq!(insert_balance_changes(&mut conn, &[change]).unwrap(), 1);
+        upsert_assets(
+            &mut conn,
+            &[
+                AssetRow {
+                    asset: "0xa",
+                    symbol: None,
+                    decimals: None,
+                },
+                AssetRow {
+                    asset: "0xa",
+                    symbol: Some("APT"),
+                    decimals: Some(8),
+                },
+            ],
+        )
+        .unwrap();
+        drop(conn);
+
+        assert_eq!(count(&path, "transfers"), 3);
+        assert_eq!(count(&path, "balance_changes"), 1);
+        assert_eq!(count(&path, "assets"), 1);
+    }
+
+    #[test]
+    fn sources_share_keys_and_conflicts_are_errors() {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("export.db");
+        let mut conn = open(&path).unwrap();
+        let transfer = |source, amount| TransferRow {
+            source,
+            version: 7,
+            event_index: 0,
+            from: "0x1",
+            to: "0x2",
+            amount,
+            asset: "0xa",
+        };
+        for source in [SOURCE_SENDS, SOURCE_GRAPH, SOURCE_GRAPH_SIMPLIFIED] {
+            assert_eq!(
+                insert_transfers(&mut conn, &[transfer(source, "1.5")]).unwrap(),
+                1
+            );
+        }
+        assert_eq!(
+            insert_transfers(&mut conn, &[transfer(SOURCE_GRAPH, "1.5")]).unwrap(),
+            0
+        );
+
+        let err = insert_transfers(
+            &mut conn,
+            &[
+                TransferRow {
+                    version: 8,
+                    ..transfer(SOURCE_GRAPH, "1")
+                },
+                transfer(SOURCE_GRAPH, "2"),
+            ],
+        )
+        .unwrap_err();
+        assert!(format!("{err:#}").contains("different row from `tx graph` for version 7"));
+        let change = |amount| BalanceChangeRow {
+            version: 7,
+            event_index: 0,
+            kind: "deposit",
+            account: "0x2",
+            fungible_store: "0xabc",
+            asset: "0xa",
+            amount,
+        };
+        insert_balance_changes(&mut conn, &[change("100")]).unwrap();
+        // `--canonical-addresses` output matches what was exported without it.
+        let long = BalanceChangeRow {
+            account: "0x0000000000000000000000000000000000000000000000000000000000000002",
+            ..change("100")
+        };
+        assert_eq!(insert_balance_changes(&mut conn, &[long]).unwrap(), 0);
+        assert!(insert_balance_changes(&mut conn, &[change("101")]).is_err());
+        drop(conn);
+
+        // The failed batch left nothing behind.
+        assert_eq!(count(&path, "transfers"), 3);
+        assert_eq!(count(&path, "balance_changes"), 1);
+    }
+
+    #[test]
+    fn transfers_join_their_asset_metadata() {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("export.db");
+        let mut conn = open(&path).unwrap();
+        let transfer = |version, asset| TransferRow {
+            source: SOURCE_SENDS,
+            version,
+            event_index: 0,
+            from: "0x1",
+            to: "0x2",
+            amount: "1.5",
+            asset,
+        };
+        insert_transfers(
+            &mut conn,
+            &[
+                transfer(1, "0xa"),
+                transfer(2, "0x1::aptos_coin::AptosCoin"),
+            ],
+        )
+        .unwrap();
+        upsert_assets(
+            &mut conn,
+            &[
+                AssetRow {
+                    asset: "0xa",
+                    symbol: Some("APT"),
+                    decimals: Some(8),
+                },
+                AssetRow {
+                    asset: "0x1::aptos_coin::AptosCoin",
+                    symbol: Some("APT"),
+                    decimals: Some(8),
+                },
+            ],
+        )
+        .unwrap();
+
+        let joined: (i64, String, i64) = conn
+            .query_row(
+                "SELECT COUNT(*), MIN(a.symbol), MIN(a.decimals) FROM transfers t
+                 JOIN assets a ON a.asset = t.asset",
+                [],
+                |row| Ok((row.get(0)?, row.get(1)?, row.get(2)?)),
+            )
+            .unwrap();
+        assert_eq!(joined, (2, "APT".to_owned(), 8));
+    }
+}